@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"db-backuper/internal/backup"
+	"db-backuper/internal/config"
+	"db-backuper/internal/progress"
+	"db-backuper/internal/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// changeCaptureDatabases returns the enabled PostgreSQL databases with change capture enabled
+func changeCaptureDatabases(cfg *config.Config) []config.DatabaseConfig {
+	var databases []config.DatabaseConfig
+	for _, db := range cfg.Databases {
+		if db.IsEnabled() && db.EngineType() == config.EngineTypePostgres && db.Postgres.ChangeCapture.Enabled {
+			databases = append(databases, db)
+		}
+	}
+	return databases
+}
+
+// startChangeCapture stores the changes of every database with change
+// capture enabled in the background and returns a function stopping it
+func startChangeCapture(cfg *config.Config, storageManager storage.Backend, logger *logrus.Logger) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	targets := newStorageTargets(cfg, storageManager, logger)
+	for _, dbConfig := range changeCaptureDatabases(cfg) {
+		dbLogger := logger.WithFields(logrus.Fields{"database": dbConfig.Database, "operation": "change_capture"})
+		target, err := targets.For(dbConfig.Database)
+		if err != nil {
+			dbLogger.Errorf("Change capture disabled: %v", err)
+			continue
+		}
+
+		capture := backup.NewChangeCapture(&dbConfig, dbLogger)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer capture.Close()
+			runChangeCapture(ctx, capture, target, dbConfig, dbLogger)
+		}()
+	}
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// runChangeCapture stores the changes of a database every interval until ctx
+// is cancelled, creating its replication slot first
+func runChangeCapture(ctx context.Context, capture *backup.ChangeCapture, target storageTarget, dbConfig config.DatabaseConfig, logger logrus.FieldLogger) {
+	interval := dbConfig.Postgres.ChangeCapture.Interval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	slotReady := false
+	for {
+		if !slotReady {
+			created, err := capture.EnsureSlot(ctx)
+			switch {
+			case err != nil:
+				logger.Errorf("Change capture is not running, retrying in %s: %v", interval, err)
+			case created:
+				logger.Infof("Created replication slot %s, capturing changes every %s", capture.Slot(), interval)
+			default:
+				logger.Infof("Capturing changes from replication slot %s every %s", capture.Slot(), interval)
+			}
+			slotReady = err == nil
+		}
+		if slotReady {
+			if err := captureChanges(ctx, capture, target, dbConfig, logger); err != nil && ctx.Err() == nil {
+				logger.Errorf("Failed to capture changes: %v", err)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// captureChanges stores the pending changes of a database in batches until
+// its slot is drained
+func captureChanges(ctx context.Context, capture *backup.ChangeCapture, target storageTarget, dbConfig config.DatabaseConfig, logger logrus.FieldLogger) error {
+	for {
+		changes, err := storeChangeBatch(ctx, capture, target, dbConfig.Database, logger)
+		if err != nil || changes < dbConfig.Postgres.ChangeCapture.BatchSize() {
+			return err
+		}
+	}
+}
+
+// storeChangeBatch stores the next batch of changes of a database and
+// returns how many it held. The batch is confirmed in the slot only once it
+// is stored, so a failed upload is retried with the next batch.
+func storeChangeBatch(ctx context.Context, capture *backup.ChangeCapture, target storageTarget, database string, logger logrus.FieldLogger) (int, error) {
+	now := time.Now().UTC()
+	tempPath := filepath.Join(os.TempDir(), fmt.Sprintf("%s_changes_%d.jsonl.gz", database, now.UnixNano()))
+	defer os.Remove(tempPath)
+
+	batch, err := capture.Peek(ctx, tempPath)
+	if err != nil || batch.Changes == 0 {
+		return 0, err
+	}
+
+	name := fmt.Sprintf("%s_changes_%s_%s.jsonl.gz", database, now.Format("2006-01-02_15-04-05"), strings.ReplaceAll(batch.FirstLSN, "/", "-"))
+	key := path.Join(target.prefix, database, now.Format(storage.DateLayout), storage.ChangesDir, name)
+	if err := target.storage.UploadFile(tempPath, key, nil); err != nil {
+		return 0, fmt.Errorf("failed to store change batch: %w", err)
+	}
+	if err := capture.Confirm(ctx, batch.LastLSN); err != nil {
+		return 0, err
+	}
+	logger.Infof("Stored %d changes from LSN %s to %s in %s", batch.Changes, batch.FirstLSN, batch.LastLSN, key)
+	return batch.Changes, nil
+}
+
+// slotRow is the state of the replication slot of a database, as printed by the changes command
+type slotRow struct {
+	Database      string `json:"database"`
+	Slot          string `json:"slot"`
+	Exists        bool   `json:"exists"`
+	Plugin        string `json:"plugin,omitempty"`
+	Active        bool   `json:"active"`
+	ConfirmedLSN  string `json:"confirmed_lsn,omitempty"`
+	RetainedBytes int64  `json:"retained_bytes"`
+}
+
+// changesCommand shows the replication slots of change capture, or drops one
+func changesCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("changes", "[-database <name>] [-output table|json|yaml] | -database <name> -drop [-force]")
+	database := fs.String("database", "", "Only show the replication slot of this database")
+	drop := fs.Bool("drop", false, "Drop the replication slot of -database, releasing the WAL it retains")
+	force := fs.Bool("force", false, "Drop without asking for confirmation")
+	output := addOutputFlag(fs)
+	return fs, func() error {
+		if *drop && *database == "" {
+			fs.Usage()
+			return fmt.Errorf("-drop requires -database")
+		}
+
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+		ctx := context.Background()
+
+		if *drop {
+			dbConfig := cfg.FindDatabase(*database)
+			if dbConfig == nil || dbConfig.EngineType() != config.EngineTypePostgres {
+				return fmt.Errorf("unknown PostgreSQL database %s", *database)
+			}
+			capture := backup.NewChangeCapture(dbConfig, logger)
+			defer capture.Close()
+
+			prompt := fmt.Sprintf("Drop replication slot %s? Changes not yet stored are lost.", capture.Slot())
+			if !*force && !confirm(os.Stdin, os.Stdout, prompt) {
+				return fmt.Errorf("drop cancelled")
+			}
+			if err := capture.DropSlot(ctx); err != nil {
+				return err
+			}
+			fmt.Printf("Dropped replication slot %s\n", capture.Slot())
+			return nil
+		}
+
+		var rows []slotRow
+		for _, dbConfig := range changeCaptureDatabases(cfg) {
+			if *database != "" && dbConfig.Database != *database {
+				continue
+			}
+			capture := backup.NewChangeCapture(&dbConfig, logger)
+			status, err := capture.Status(ctx)
+			capture.Close()
+			if err != nil {
+				return err
+			}
+			row := slotRow{Database: dbConfig.Database, Slot: capture.Slot()}
+			if status != nil {
+				row.Exists = true
+				row.Plugin, row.Active, row.ConfirmedLSN, row.RetainedBytes = status.Plugin, status.Active, status.ConfirmedLSN, status.RetainedBytes
+			}
+			rows = append(rows, row)
+		}
+		if *database != "" && len(rows) == 0 {
+			return fmt.Errorf("change capture is not enabled for database %s", *database)
+		}
+
+		return printResults(output, rows, func(w io.Writer) {
+			fmt.Fprintln(w, "DATABASE\tSLOT\tPLUGIN\tACTIVE\tCONFIRMED LSN\tRETAINED WAL")
+			for _, row := range rows {
+				if !row.Exists {
+					fmt.Fprintf(w, "%s\t%s\t-\t-\t-\tnot created yet\n", row.Database, row.Slot)
+					continue
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\n", row.Database, row.Slot, row.Plugin, row.Active, row.ConfirmedLSN, progress.FormatBytes(row.RetainedBytes))
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/restore"
+)
+
+// commandRestoreCommand restores a command engine backup with its restore template
+func commandRestoreCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("command-restore", "-database <name> -file <path> [-force]")
+	database := fs.String("database", "", "Configured command database to restore")
+	file := fs.String("file", "", "Downloaded backup file")
+	force := fs.Bool("force", false, "Restore without asking for confirmation")
+	return fs, func() error {
+		if *database == "" || *file == "" {
+			fs.Usage()
+			return fmt.Errorf("-database and -file are required")
+		}
+
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+
+		if err := cfg.FilterDatabases([]string{*database}); err != nil {
+			return err
+		}
+		dbConfig := cfg.Databases[0]
+		if dbConfig.EngineType() != config.EngineTypeCommand {
+			return fmt.Errorf("database %s is not a command database", *database)
+		}
+
+		if !*force {
+			prompt := fmt.Sprintf("This will run the restore command for %s with %s.", *database, *file)
+			if !confirm(os.Stdin, os.Stdout, prompt) {
+				return fmt.Errorf("restore cancelled")
+			}
+		}
+
+		commandRestore := restore.NewCommandRestore(&dbConfig, logger)
+		commandRestore.SetAuditLog(newAuditLog(cfg, logger))
+		return commandRestore.Restore(*file)
+	}
+}
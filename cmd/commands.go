@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"db-backuper/internal/completion"
+	"db-backuper/internal/compliance"
+	"db-backuper/internal/config"
+	"db-backuper/internal/redact"
+
+	"github.com/sirupsen/logrus"
+)
+
+// commandFunc defines the flags of a subcommand on a new flag set and
+// returns the function running the subcommand once they are parsed. Shell
+// completion calls it for the flag set alone, so it must not do anything
+// else.
+type commandFunc func() (*flag.FlagSet, func() error)
+
+// command is a CLI subcommand, with flags or with actions of its own
+type command struct {
+	description string
+	flags       commandFunc
+	actions     map[string]command
+}
+
+// run parses args with the flags of the subcommand and runs it, or runs the
+// action named by the first argument
+func (c command) run(name string, args []string) error {
+	if c.actions == nil {
+		fs, run := c.flags()
+		fs.Parse(args)
+		return run()
+	}
+
+	actions := slices.Sorted(maps.Keys(c.actions))
+	if len(args) > 0 && slices.Contains(helpFlags, args[0]) {
+		fmt.Printf("Usage: db-backuper %s <action> [flags]\n\nActions:\n", name)
+		for _, action := range actions {
+			fmt.Printf("  %-10s %s\n", action, c.actions[action].description)
+		}
+		return nil
+	}
+	if len(args) == 0 || c.actions[args[0]].flags == nil {
+		return fmt.Errorf("usage: db-backuper %s %s [flags]", name, strings.Join(actions, "|"))
+	}
+	return c.actions[args[0]].run(name+" "+args[0], args[1:])
+}
+
+// commands lists every subcommand by name
+var commands = map[string]command{
+	"apply-lifecycle": {
+		description: "Create or update the S3 lifecycle rules matching aws.lifecycle",
+		flags:       applyLifecycleCommand,
+	},
+	"backup": {
+		description: "Back up the configured databases once and exit",
+		flags:       backupCommand,
+	},
+	"changes": {
+		description: "Show or drop the replication slots of PostgreSQL change capture",
+		flags:       changesCommand,
+	},
+	"cleanup": {
+		description: "Delete backups past the retention period without taking new ones",
+		flags:       cleanupCommand,
+	},
+	"completion": {
+		description: "Print a shell completion script for bash, zsh or fish",
+		flags:       completionCommand,
+	},
+	"command-restore": {
+		description: "Restore a command engine backup with its restore template",
+		flags:       commandRestoreCommand,
+	},
+	"config": {
+		description: "Upgrade a configuration file written for an earlier release to the current schema",
+		actions: map[string]command{
+			"upgrade": {
+				description: "Rewrite a configuration file in the current schema, keeping the original as a .bak file",
+				flags:       configUpgradeCommand,
+			},
+		},
+	},
+	"copy": {
+		description: "Copy a backup to another prefix, bucket or local directory",
+		flags:       copyCommand,
+	},
+	"delete": {
+		description: "Delete a backup by key or by database and date",
+		flags:       deleteCommand,
+	},
+	"diff": {
+		description: "Compare the schema of a backup with the live database",
+		flags:       diffCommand,
+	},
+	"download": {
+		description: "Download a backup from storage to a local path",
+		flags:       downloadCommand,
+	},
+	"export-catalog": {
+		description: "Write a signed report of the backups, retention decisions and restores of a date range for auditors",
+		flags:       exportCatalogCommand,
+	},
+	"fixture": {
+		description: "Seed a small SQL fixture for CI from a backup, optionally keeping a sample of its rows",
+		flags:       fixtureCommand,
+	},
+	"gc": {
+		description: "Abort incomplete multipart uploads left behind by failed runs",
+		flags:       gcCommand,
+	},
+	"generate-infra": {
+		description: "Print Terraform or SAM definitions deploying the backup Lambda function with this configuration",
+		flags:       generateInfraCommand,
+	},
+	"history": {
+		description: "Show the most recent backup runs",
+		flags:       historyCommand,
+	},
+	"hold": {
+		description: "Exempt a backup from retention cleanup, or release or list holds",
+		flags:       holdCommand,
+	},
+	"iam-policy": {
+		description: "Print the least privilege IAM policy for the configured buckets, prefixes and key",
+		flags:       iamPolicyCommand,
+	},
+	"init": {
+		description: "Write a first configuration file by answering questions, testing each part as it is entered",
+		flags:       initCommand,
+	},
+	"init-storage": {
+		description: "Create the bucket or backup directories and check access with a test object",
+		flags:       initStorageCommand,
+	},
+	"install-service": {
+		description: "Register the scheduler as a systemd, launchd or Windows service",
+		flags:       installServiceCommand,
+	},
+	"jobs": {
+		description: "List, start, approve or cancel the jobs of a running scheduler",
+		flags:       jobsCommand,
+	},
+	"list": {
+		description: "List stored backups by database and date",
+		flags:       listCommand,
+	},
+	"logs": {
+		description: "Print or follow the logs of a run from a running scheduler",
+		flags:       logsCommand,
+	},
+	"mssql-restore": {
+		description: "Restore a SQL Server .bak or .bacpac backup",
+		flags:       mssqlRestoreCommand,
+	},
+	"pause": {
+		description: "Pause scheduled backups for a maintenance window",
+		flags:       pauseCommand,
+	},
+	"rehearse": {
+		description: "Restore a backup into a disposable PostgreSQL container and validate it",
+		flags:       rehearseCommand,
+	},
+	"rekey": {
+		description: "Re-encrypt stored backups with the current SSE-C key",
+		flags:       rekeyCommand,
+	},
+	"restore": {
+		description: "Import a backup into the configured target database",
+		flags:       restoreCommand,
+	},
+	"restore-points": {
+		description: "List named restore points",
+		flags:       restorePointsCommand,
+	},
+	"resume": {
+		description: "Resume paused scheduled backups",
+		flags:       resumeCommand,
+	},
+	"retention": {
+		description: "Simulate a retention policy, reporting the backups it would delete and the space reclaimed",
+		actions: map[string]command{
+			"simulate": {
+				description: "Report the backups a retention policy would delete, without deleting anything",
+				flags:       retentionSimulateCommand,
+			},
+		},
+	},
+	"safeguard": {
+		description: "Back up, verify and tag databases, then run a wrapped command",
+		flags:       safeguardCommand,
+	},
+	"schema": {
+		description: "Print the JSON Schema of the configuration file",
+		flags:       schemaCommand,
+	},
+	"serve": {
+		description: "Run scheduled backups until interrupted",
+		flags:       serveCommand,
+	},
+	"share": {
+		description: "Print a pre-signed URL downloading a backup without bucket access",
+		flags:       shareCommand,
+	},
+	"subset": {
+		description: "Reduce a backup to a referentially consistent subset of its rows",
+		flags:       subsetCommand,
+	},
+	"tag": {
+		description: "Name a backup as a restore point",
+		flags:       tagCommand,
+	},
+	"transform": {
+		description: "Rewrite a SQL backup with the import transforms",
+		flags:       transformCommand,
+	},
+	"untag": {
+		description: "Remove a restore point name, keeping the backup",
+		flags:       untagCommand,
+	},
+	"uninstall-service": {
+		description: "Stop the scheduler service and remove its registration",
+		flags:       uninstallServiceCommand,
+	},
+	"usage": {
+		description: "Report the space and estimated cost of stored backups with suggestions to lower it",
+		flags:       usageCommand,
+	},
+	"validate": {
+		description: "Check the configuration without connecting to anything",
+		flags:       validateCommand,
+	},
+	"verify": {
+		description: "Check a SQL backup for truncation and missing tables without restoring it",
+		flags:       verifyCommand,
+	},
+	"redis-restore": {
+		description: "Print the steps to restore a Redis RDB backup",
+		flags:       redisRestoreCommand,
+	},
+}
+
+// helpFlags ask for the list of commands instead of running the service
+var helpFlags = []string{"-h", "-help", "--help"}
+
+// execute runs the subcommand named by the first of args and exits with a
+// non-zero status on failure
+func execute(args []string) {
+	name, args := args[0], args[1:]
+	switch {
+	case slices.Contains(helpFlags, name), name == "help" && len(args) == 0:
+		printCommands(os.Stdout)
+		return
+	case name == "help":
+		name, args = args[0], []string{"-h"}
+	case name == completion.RequestCommand:
+		candidates, files := completion.Complete(completionCommands(commands), args)
+		completion.Write(os.Stdout, candidates, files)
+		return
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		printCommands(os.Stderr)
+		os.Exit(2)
+	}
+	if err := cmd.run(name, args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+
+		// Pass on the exit status of a wrapped command
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+			os.Exit(exitErr.ExitCode())
+		}
+		os.Exit(1)
+	}
+}
+
+// printCommands writes the usage line and the list of subcommands
+func printCommands(w io.Writer) {
+	fmt.Fprintln(w, "Usage: db-backuper <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "db-backuper backs up databases to local, S3 or rclone storage on a schedule and restores them.")
+	fmt.Fprintln(w, "Run a command with -h for its flags.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Available commands:")
+	for _, name := range slices.Sorted(maps.Keys(commands)) {
+		fmt.Fprintf(w, "  %-18s %s\n", name, commands[name].description)
+	}
+}
+
+// configFlags holds the flags selecting the configuration file, profile and env file
+type configFlags struct {
+	path    *string
+	profile *string
+	envFile *string
+	strict  *bool
+}
+
+// loadCommandConfig loads the configuration and a redacting logger for a subcommand
+func loadCommandConfig(flags configFlags) (*config.Config, *logrus.Logger, error) {
+	if *flags.envFile != "" {
+		if err := config.LoadDotEnv(*flags.envFile); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	cfg, err := config.LoadConfig(*flags.path, config.LoadOptions{Profile: *flags.profile, Strict: *flags.strict})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	logger := setupLogger(cfg.Logging)
+	redactor := redact.New()
+	redactor.AddSecrets(cfg.Secrets()...)
+	redact.Install(logger, redactor)
+	if err := compliance.Apply(cfg, logger); err != nil {
+		return nil, nil, err
+	}
+	return cfg, logger, nil
+}
+
+// confirm asks the operator to type "yes" before a destructive action
+func confirm(in io.Reader, out io.Writer, prompt string) bool {
+	fmt.Fprintf(out, "%s Type 'yes' to continue: ", prompt)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && answer == "" {
+		return false
+	}
+	return strings.TrimSpace(answer) == "yes"
+}
+
+// newFlagSet creates a flag set for a subcommand with the shared configuration flags
+func newFlagSet(name, usage string) (*flag.FlagSet, configFlags) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	setUsage(fs, name, usage)
+	flags := configFlags{
+		path:    fs.String("config", "", "Path to configuration file (default: appsettings.json if present, otherwise environment variables only)"),
+		profile: fs.String("profile", "", "Configuration profile to apply (default: $"+config.ProfileEnvVar+")"),
+		envFile: fs.String("env-file", "", "Load environment variables from a .env file before applying overrides"),
+		strict:  fs.Bool("strict", false, "Reject unknown fields and mistyped values in the configuration file"),
+	}
+	return fs, flags
+}
+
+// setUsage makes -h print the usage line and flags of a subcommand
+func setUsage(fs *flag.FlagSet, name, usage string) {
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: db-backuper %s %s\n\n", name, usage)
+		fs.PrintDefaults()
+	}
+}
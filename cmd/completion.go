@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"slices"
+
+	"db-backuper/internal/completion"
+)
+
+// completionCommands returns the subcommands for shell completion, sorted by name
+func completionCommands(commands map[string]command) []completion.Command {
+	var completions []completion.Command
+	for _, name := range slices.Sorted(maps.Keys(commands)) {
+		cmd := commands[name]
+		c := completion.Command{Name: name, Description: cmd.description}
+		if name == "completion" {
+			c.Args = completion.Shells
+		}
+		if cmd.actions != nil {
+			c.Actions = completionCommands(cmd.actions)
+		} else {
+			c.Flags = func() *flag.FlagSet {
+				fs, _ := cmd.flags()
+				return fs
+			}
+		}
+		completions = append(completions, c)
+	}
+	return completions
+}
+
+// completionCommand prints the completion script of a shell
+func completionCommand() (*flag.FlagSet, func() error) {
+	fs := flag.NewFlagSet("completion", flag.ExitOnError)
+	setUsage(fs, "completion", "bash|zsh|fish")
+	return fs, func() error {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return fmt.Errorf("expected one shell: bash, zsh or fish")
+		}
+		script, err := completion.Script(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		fmt.Print(script)
+		return nil
+	}
+}
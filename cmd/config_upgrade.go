@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"db-backuper/internal/config"
+)
+
+// configUpgradeCommand rewrites a configuration file written for an earlier
+// release in the current schema, keeping the original as a .bak file
+func configUpgradeCommand() (*flag.FlagSet, func() error) {
+	fs := flag.NewFlagSet("config upgrade", flag.ExitOnError)
+	setUsage(fs, "config upgrade", "[-config <path>] [-output <path>] [-dry-run]")
+	path := fs.String("config", config.DefaultConfigPath, "Configuration file to upgrade")
+	output := fs.String("output", "", "Write the upgraded file here instead of replacing -config")
+	dryRun := fs.Bool("dry-run", false, "List the changes without writing anything")
+	return fs, func() error {
+		data, err := os.ReadFile(*path)
+		if err != nil {
+			return fmt.Errorf("failed to read config file: %w", err)
+		}
+		upgraded, changes, err := config.Upgrade(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", *path, err)
+		}
+		if bytes.Equal(upgraded, data) {
+			fmt.Printf("%s is up to date (config_version %d)\n", *path, config.CurrentVersion)
+			return nil
+		}
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+		if *dryRun {
+			return nil
+		}
+
+		target := *output
+		if target == "" {
+			target = *path
+			if err := os.WriteFile(*path+".bak", data, 0600); err != nil {
+				return fmt.Errorf("failed to keep a copy of %s: %w", *path, err)
+			}
+		}
+		if err := os.WriteFile(target, upgraded, 0600); err != nil {
+			return fmt.Errorf("failed to write upgraded config: %w", err)
+		}
+		fmt.Printf("Upgraded %s to config_version %d\n", target, config.CurrentVersion)
+		return nil
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/promote"
+	"db-backuper/internal/s3"
+	"db-backuper/internal/storage"
+)
+
+// copyCommand copies a backup to another prefix, bucket or backend
+func copyCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("copy", "(-key <key> | -database <name> [-date <YYYY-MM-DD>] | -restore-point <name>) -to-prefix <prefix> [-to-bucket <bucket> | -to-local <path>]")
+	selection := addBackupFlags(fs, "copy")
+	toPrefix := fs.String("to-prefix", "", "Backup prefix at the destination (default: the configured backup_prefix)")
+	toBucket := fs.String("to-bucket", "", "Destination S3 bucket (same region and credentials)")
+	toLocal := fs.String("to-local", "", "Destination local backup directory")
+	return fs, func() error {
+		if err := selection.validate(); err != nil {
+			fs.Usage()
+			return err
+		}
+		if *toBucket != "" && *toLocal != "" {
+			return fmt.Errorf("-to-bucket and -to-local cannot be combined")
+		}
+
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+
+		storageManager, err := newStorageManager(cfg, logger)
+		if err != nil {
+			return err
+		}
+		target, srcKey, err := selection.resolve(newStorageTargets(cfg, storageManager, logger))
+		if err != nil {
+			return err
+		}
+		source := target.storage
+
+		// The destination defaults to the source backend
+		dest := source
+		switch {
+		case *toBucket != "":
+			awsConfig := cfg.AWS
+			awsConfig.Bucket = *toBucket
+			dest, err = s3.NewS3Manager(&awsConfig, logger)
+			if err != nil {
+				return err
+			}
+		case *toLocal != "":
+			dest, err = storage.NewLocalStorage(&config.LocalConfig{Path: *toLocal}, logger)
+			if err != nil {
+				return err
+			}
+		}
+
+		to := promote.Target{Backend: dest, Prefix: *toPrefix}
+		if to.Prefix == "" {
+			to.Prefix = target.prefix
+		}
+		destKey, err := promote.Promote(promote.Target{Backend: source, Prefix: target.prefix}, to, srcKey, cfg.Backup.Transfers())
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Copied %s/%s to %s/%s\n", source.Location(), srcKey, dest.Location(), destKey)
+		return nil
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"db-backuper/internal/audit"
+	"db-backuper/internal/plugin"
+	"db-backuper/internal/rclone"
+	"db-backuper/internal/s3"
+	"db-backuper/internal/storage"
+)
+
+// Every storage backend supports listing and deleting backups
+var (
+	_ storage.Backend = (*s3.S3Manager)(nil)
+	_ storage.Backend = (*storage.LocalStorage)(nil)
+	_ storage.Backend = (*rclone.Remote)(nil)
+	_ storage.Backend = (*plugin.Storage)(nil)
+)
+
+// deleteCommand deletes a specific backup after confirmation
+func deleteCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("delete", "(-key <key> | -database <name> -date <YYYY-MM-DD>) [-force]")
+	key := fs.String("key", "", "Storage key or local path of the backup to delete")
+	database := fs.String("database", "", "Database whose backups should be deleted")
+	date := fs.String("date", "", "Date (YYYY-MM-DD) of the backups to delete, used with -database")
+	force := fs.Bool("force", false, "Delete without asking for confirmation")
+	return fs, func() error {
+		if (*key == "") == (*database == "" || *date == "") {
+			fs.Usage()
+			return fmt.Errorf("specify either -key or both -database and -date")
+		}
+
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+
+		backend, err := newStorageManager(cfg, logger)
+		if err != nil {
+			return err
+		}
+
+		// Resolve the request to existing keys so nothing unexpected is deleted
+		var keys []string
+		if *key != "" {
+			found, err := backend.ListKeys(*key)
+			if err != nil {
+				return err
+			}
+			for _, k := range found {
+				if k == *key || strings.HasSuffix(filepath.ToSlash(*key), "/"+k) {
+					keys = append(keys, k)
+				}
+			}
+		} else {
+			keys, err = backend.ListKeys(path.Join(cfg.Backup.BackupPrefix, *database, *date) + "/")
+			if err != nil {
+				return err
+			}
+			// The files of a directory backup and the parts of a split one are deleted with their index
+			keys = slices.DeleteFunc(keys, func(k string) bool {
+				_, ok := storage.IndexOf(k)
+				return ok
+			})
+		}
+
+		if len(keys) == 0 {
+			return fmt.Errorf("no matching backups found in %s", backend.Location())
+		}
+
+		fmt.Printf("The following backups in %s will be deleted:\n", backend.Location())
+		for _, k := range keys {
+			fmt.Printf("  %s\n", k)
+		}
+		if !*force && !confirm(os.Stdin, os.Stdout, fmt.Sprintf("Delete %d backup(s)?", len(keys))) {
+			return fmt.Errorf("deletion cancelled")
+		}
+
+		deleted, deleteErr := backend.DeleteBackups(keys)
+		if len(deleted) > 0 {
+			if err := newAuditLog(cfg, logger).Record(audit.Event{
+				Action:  audit.ActionDelete,
+				Storage: backend.Location(),
+				Targets: deleted,
+			}); err != nil {
+				logger.Errorf("Failed to record deletion in audit log: %v", err)
+			}
+		}
+		if deleteErr != nil {
+			return deleteErr
+		}
+
+		fmt.Printf("Deleted %d backup(s)\n", len(deleted))
+		return nil
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"db-backuper/internal/backup"
+	"db-backuper/internal/config"
+	"db-backuper/internal/restore"
+	"db-backuper/internal/schemadiff"
+	"db-backuper/internal/storage"
+)
+
+// diffCommand restores the schema of a backup into a disposable container and
+// reports how the live database's tables and columns differ from it
+func diffCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("diff", "-database <name> [-file <path> | -key <key> | -date <YYYY-MM-DD> | -restore-point <name>] [-schema <name>] [-json]")
+	file := fs.String("file", "", "Local backup file to compare instead of one from storage")
+	selection := addBackupFlags(fs, "compare")
+	schema := fs.String("schema", "", "Only compare tables in this schema, such as public")
+	version := fs.String("version", "", "PostgreSQL version of the container (default: rehearsal.postgres_version)")
+	asJSON := fs.Bool("json", false, "Print the differences as JSON")
+	return fs, func() error {
+		database := *selection.database
+		if database == "" {
+			fs.Usage()
+			return fmt.Errorf("-database is required")
+		}
+		selected := 0
+		for _, value := range []string{*file, *selection.key, *selection.restorePoint} {
+			if value != "" {
+				selected++
+			}
+		}
+		if selected > 1 {
+			fs.Usage()
+			return fmt.Errorf("specify at most one of -file, -key or -restore-point")
+		}
+
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+		if err := cfg.FilterDatabases([]string{database}); err != nil {
+			return err
+		}
+		dbConfig := cfg.Databases[0]
+		if dbConfig.EngineType() != config.EngineTypePostgres {
+			return fmt.Errorf("database %s is not a PostgreSQL database", database)
+		}
+
+		backupPath := *file
+		if backupPath == "" {
+			// -key and -restore-point name the backup; -database only names it
+			// when neither is given
+			if selected > 0 {
+				none := ""
+				selection.database = &none
+			}
+			storageManager, err := newStorageManager(cfg, logger)
+			if err != nil {
+				return err
+			}
+			storageTarget, key, err := selection.resolve(newStorageTargets(cfg, storageManager, logger))
+			if err != nil {
+				return err
+			}
+			workDir, err := os.MkdirTemp("", "db-backuper-diff-*")
+			if err != nil {
+				return fmt.Errorf("failed to create download directory: %w", err)
+			}
+			defer os.RemoveAll(workDir)
+
+			backupPath = filepath.Join(workDir, path.Base(filepath.ToSlash(key)))
+			logger.Infof("Downloading %s", key)
+			if backupPath, err = storage.Fetch(storageTarget.storage, key, backupPath, cfg.Backup.Transfers()); err != nil {
+				return err
+			}
+			if err := verifyDownload(storageTarget.storage, key, backupPath); err != nil {
+				return err
+			}
+		}
+
+		live, err := backup.NewPostgresBackup(&dbConfig, logger).Columns()
+		if err != nil {
+			return fmt.Errorf("failed to read the schema of %s: %w", database, err)
+		}
+
+		rehearsal, err := restore.NewRehearsal(&cfg.Rehearsal, logger)
+		if err != nil {
+			return err
+		}
+		result, err := rehearsal.Run(backupPath, database, restore.RehearsalOptions{Version: *version, SchemaOnly: true, Transforms: cfg.Import.Transforms})
+		if result != nil {
+			defer func() {
+				if err := rehearsal.Remove(result); err != nil {
+					logger.Warnf("Failed to remove rehearsal container: %v", err)
+				}
+			}()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore the schema of the backup: %w", err)
+		}
+		restored, err := rehearsal.Columns(result)
+		if err != nil {
+			return err
+		}
+
+		diff := schemadiff.Compare(inSchema(restored, *schema), inSchema(live, *schema))
+		if *asJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(diff)
+		}
+		fmt.Printf("Schema of %s compared with %s:\n", database, path.Base(filepath.ToSlash(backupPath)))
+		diff.Write(os.Stdout)
+		return nil
+	}
+}
+
+// inSchema returns the columns of tables in schema, or every column when schema is empty
+func inSchema(columns []schemadiff.Column, schema string) []schemadiff.Column {
+	if schema == "" {
+		return columns
+	}
+	var kept []schemadiff.Column
+	for _, column := range columns {
+		if column.Schema == schema {
+			kept = append(kept, column)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"path/filepath"
+	"time"
+
+	"db-backuper/internal/storage"
+)
+
+// downloadCommand fetches a backup from storage to a local path
+func downloadCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("download", "(-key <key> | -database <name> [-date <YYYY-MM-DD>] | -restore-point <name>) [-output <path>] [-decompress]")
+	selection := addBackupFlags(fs, "download")
+	output := fs.String("output", "", "Destination file or directory (default: current directory)")
+	decompress := fs.Bool("decompress", false, "Decompress a gzip compressed backup (.gz), such as a compressed command engine backup, after checking its checksum")
+	return fs, func() error {
+		if err := selection.validate(); err != nil {
+			fs.Usage()
+			return err
+		}
+
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+
+		storageManager, err := newStorageManager(cfg, logger)
+		if err != nil {
+			return err
+		}
+
+		target, selected, err := selection.resolve(newStorageTargets(cfg, storageManager, logger))
+		if err != nil {
+			return err
+		}
+		backend := target.storage
+
+		destPath, err := storage.DownloadDestination(*output, path.Base(filepath.ToSlash(selected)))
+		if err != nil {
+			return err
+		}
+
+		if destPath, err = storage.Fetch(backend, selected, destPath, cfg.Backup.Transfers()); err != nil {
+			return err
+		}
+		if err := verifyDownload(backend, selected, destPath); err != nil {
+			return err
+		}
+		if *decompress {
+			if storage.IsIndex(destPath) {
+				return fmt.Errorf("-decompress only applies to backups stored as one file")
+			}
+			if destPath, err = storage.Decompress(destPath); err != nil {
+				return err
+			}
+		}
+
+		fmt.Printf("Downloaded %s to %s\n", selected, destPath)
+		return nil
+	}
+}
+
+// verifyDownload prints the provenance recorded with a backup and checks the
+// downloaded copy against its checksum, see storage.VerifyDownload
+func verifyDownload(backend storage.Backend, key, destPath string) error {
+	meta, err := storage.VerifyDownload(backend, key, destPath)
+	if meta != nil {
+		fmt.Printf("Provenance: %s\n", meta.Summary())
+	}
+	return err
+}
+
+// latestKey returns the key of the most recent backup selected by query.
+// Keys embed the backup timestamp, so the lexically greatest key is the newest.
+func latestKey(backend storage.Backend, query storage.ListQuery) (string, error) {
+	entries, err := storage.AllBackups(backend, query)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("no backups found under %s in %s", query.KeyPrefix(), backend.Location())
+	}
+
+	latest := entries[0].Key
+	for _, entry := range entries[1:] {
+		if entry.Key > latest {
+			latest = entry.Key
+		}
+	}
+	return latest, nil
+}
+
+// parseDate parses the YYYY-MM-DD value of a date flag
+func parseDate(flagName, value string) (time.Time, error) {
+	date, err := time.Parse(storage.DateLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %q, expected YYYY-MM-DD", flagName, value)
+	}
+	return date, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"db-backuper/internal/audit"
+	"db-backuper/internal/catalog"
+	"db-backuper/internal/config"
+	"db-backuper/internal/s3"
+	"db-backuper/internal/status"
+	"db-backuper/internal/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// exportCatalogCommand writes a signed report of the backups, retention decisions
+// and restores of a date range for auditors
+func exportCatalogCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("export-catalog", "-since <YYYY-MM-DD> -until <YYYY-MM-DD> -signing-key <pem> -out <file> [-format csv|json] [-database <name>]")
+	since := fs.String("since", "", "First day of the report (YYYY-MM-DD)")
+	until := fs.String("until", "", "Last day of the report (YYYY-MM-DD)")
+	signingKey := fs.String("signing-key", "", "Ed25519 private key in PEM form signing the report")
+	out := fs.String("out", "", "File the report is written to; the signature is written to <file>.sig")
+	format := fs.String("format", "csv", "Report format: csv or json")
+	database := fs.String("database", "", "Only report backups and restores of this database")
+	return fs, func() error {
+		if *since == "" || *until == "" || *signingKey == "" || *out == "" {
+			fs.Usage()
+			return fmt.Errorf("-since, -until, -signing-key and -out are required")
+		}
+		if *format != "csv" && *format != "json" {
+			fs.Usage()
+			return fmt.Errorf("unknown format %q, expected csv or json", *format)
+		}
+		var query storage.ListQuery
+		var err error
+		if query.Since, err = parseDate("-since", *since); err != nil {
+			fs.Usage()
+			return err
+		}
+		if query.Until, err = parseDate("-until", *until); err != nil {
+			fs.Usage()
+			return err
+		}
+		if query.Until.Before(query.Since) {
+			return fmt.Errorf("-until %s is before -since %s", *until, *since)
+		}
+		query.Database = *database
+
+		// Never replace an earlier report or signature
+		for _, file := range []string{*out, *out + ".sig"} {
+			if _, err := os.Stat(file); err == nil {
+				return fmt.Errorf("%s already exists", file)
+			}
+		}
+		key, err := catalog.LoadSigningKey(*signingKey)
+		if err != nil {
+			return err
+		}
+
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+		storageManager, err := newStorageManager(cfg, logger)
+		if err != nil {
+			return err
+		}
+
+		export := catalog.NewExport(query.Since, query.Until, cfg.Backup.RetentionDays, audit.CurrentActor(), time.Now())
+		if err := exportBackups(export, newStorageTargets(cfg, storageManager, logger), query, logger); err != nil {
+			return err
+		}
+		events, err := exportAuditEvents(cfg, storageManager, query, logger)
+		if err != nil {
+			return err
+		}
+		export.AddAuditEvents(events)
+		exportRestores(export, cfg, storageManager, *database, logger)
+		export.SigningKey = catalog.KeyFingerprint(key)
+
+		var report []byte
+		if *format == "json" {
+			report, err = export.JSON()
+		} else {
+			report, err = export.CSV()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to encode report: %w", err)
+		}
+		if err := writeNewFile(*out, report); err != nil {
+			return err
+		}
+		if err := writeNewFile(*out+".sig", ed25519.Sign(key, report)); err != nil {
+			return err
+		}
+		logger.Infof("Wrote %d backups and %d events to %s, signed with key %s", len(export.Backups), len(export.Events), *out, export.SigningKey)
+		return nil
+	}
+}
+
+// exportBackups adds the backups of every storage target selected by query
+func exportBackups(export *catalog.Export, resolver *storageTargets, query storage.ListQuery, logger *logrus.Logger) error {
+	var targets []storageTarget
+	if query.Database != "" {
+		target, err := resolver.For(query.Database)
+		if err != nil {
+			return err
+		}
+		targets = []storageTarget{target}
+	} else {
+		var err error
+		if targets, err = resolver.All(); err != nil {
+			return err
+		}
+	}
+
+	for _, target := range targets {
+		backend := target.storage
+		holds, err := storage.LoadHolds(backend, target.prefix)
+		if err != nil {
+			return err
+		}
+		query.Prefix = target.prefix
+		query.PageToken = ""
+		for {
+			page, err := backend.ListBackups(query)
+			if err != nil {
+				return err
+			}
+			for _, entry := range page.Entries {
+				meta, err := backend.Metadata(entry.Key)
+				if err != nil {
+					logger.Warnf("Failed to read the provenance of %s, reporting it without a checksum: %v", entry.Key, err)
+				}
+				export.AddBackup(backend.Location(), entry, meta, holds)
+			}
+			if page.NextPageToken == "" {
+				break
+			}
+			query.PageToken = page.NextPageToken
+		}
+	}
+	return nil
+}
+
+// exportAuditEvents reads the audit events of the days of query. Events in
+// S3 are preferred, since every host records its events there, over the
+// local audit file of this host.
+func exportAuditEvents(cfg *config.Config, storageManager storage.Backend, query storage.ListQuery, logger *logrus.Logger) ([]audit.Event, error) {
+	s3Manager, ok := storageManager.(*s3.S3Manager)
+	if cfg.Audit.S3Prefix == "" || !ok {
+		if cfg.Audit.Path == "" {
+			logger.Warn("No audit log is configured, so the report has no retention or restore events besides measured restores")
+			return nil, nil
+		}
+		return audit.ReadFile(cfg.Audit.Path)
+	}
+
+	var events []audit.Event
+	for day := query.Since; !day.After(query.Until); day = day.AddDate(0, 0, 1) {
+		keys, err := s3Manager.ListKeys(path.Join(cfg.Audit.S3Prefix, day.Format(storage.DateLayout)) + "/")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list audit events: %w", err)
+		}
+		for _, key := range keys {
+			data, err := s3Manager.GetObject(key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read audit event: %w", err)
+			}
+			var event audit.Event
+			if err := json.Unmarshal(data, &event); err != nil {
+				return nil, fmt.Errorf("invalid audit event %s: %w", key, err)
+			}
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// exportRestores adds the restores measured in the status file, such as
+// imports and rehearsals. The status file keeps only the most recent
+// restores of each database.
+func exportRestores(export *catalog.Export, cfg *config.Config, storageManager storage.Backend, database string, logger *logrus.Logger) {
+	statusS3, _ := storageManager.(*s3.S3Manager)
+	report := status.NewWriter(&cfg.Status, statusS3, logger).Load()
+	if report == nil {
+		return
+	}
+	for _, result := range report.Databases {
+		if database != "" && result.Database != database {
+			continue
+		}
+		for _, restore := range result.Restores {
+			details := map[string]string{"duration_seconds": strconv.FormatFloat(restore.DurationSeconds, 'f', 0, 64)}
+			export.AddRestore(result.Database, restore.Source, restore.Key, restore.FinishedAt, details)
+		}
+	}
+}
+
+// writeNewFile writes data to a read-only file that must not exist yet
+func writeNewFile(name string, data []byte) error {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0444)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%s already exists", name)
+		}
+		return fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return file.Close()
+}
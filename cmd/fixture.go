@@ -0,0 +1,198 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"db-backuper/internal/backup"
+	"db-backuper/internal/config"
+	"db-backuper/internal/progress"
+	"db-backuper/internal/restore"
+	"db-backuper/internal/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fixtureCommand restores a backup into a disposable PostgreSQL container,
+// optionally keeps a sample of its rows and dumps the result as a fixture
+func fixtureCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("fixture", "(-file <path> | -key <key> | -database <name> [-date <YYYY-MM-DD>] | -restore-point <name>) -out <path> [-percent <n>] [-version <n>]")
+	file := fs.String("file", "", "Local backup file to restore instead of one from storage")
+	selection := addBackupFlags(fs, "seed the fixture from")
+	out := fs.String("out", "", "Path of the SQL fixture to write")
+	percent := fs.Float64("percent", 100, "Percentage of the rows of every table to keep, following foreign keys")
+	version := fs.String("version", "", "PostgreSQL version of the container (default: rehearsal.postgres_version)")
+	return fs, func() error {
+		if *file == "" {
+			if err := selection.validate(); err != nil {
+				fs.Usage()
+				return fmt.Errorf("specify -file or exactly one of -key, -database or -restore-point")
+			}
+		}
+		if *out == "" {
+			fs.Usage()
+			return fmt.Errorf("-out is required")
+		}
+		if *percent <= 0 || *percent > 100 {
+			fs.Usage()
+			return fmt.Errorf("-percent must be greater than 0 and at most 100")
+		}
+
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+
+		backupPath, cleanup, err := fixtureSource(cfg, logger, *file, selection)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+
+		reduce := func(ctx context.Context, rehearsal *restore.Rehearsal, result *restore.RehearsalResult) ([]restore.TableSubset, error) {
+			if *percent == 100 {
+				return nil, nil
+			}
+			db, err := rehearsal.Open(result)
+			if err != nil {
+				return nil, err
+			}
+			defer db.Close()
+			logger.Infof("Keeping %g%% of the rows of every table", *percent)
+			return restore.SampleRows(ctx, db, *percent, logger)
+		}
+		database := cmp.Or(*selection.database, "fixture")
+		subsets, err := buildFixture(cfg, logger, backupPath, database, *version, *out, reduce)
+		if err != nil {
+			return err
+		}
+		printFixture(*out, subsets)
+		return nil
+	}
+}
+
+// fixtureSource returns the path of the backup a fixture is seeded from,
+// downloading the selected backup unless file is given, and a function
+// removing the download
+func fixtureSource(cfg *config.Config, logger *logrus.Logger, file string, selection backupFlags) (string, func(), error) {
+	if file != "" {
+		return file, func() {}, nil
+	}
+	storageManager, err := newStorageManager(cfg, logger)
+	if err != nil {
+		return "", nil, err
+	}
+	storageTarget, selected, err := selection.resolve(newStorageTargets(cfg, storageManager, logger))
+	if err != nil {
+		return "", nil, err
+	}
+	workDir, err := os.MkdirTemp("", "db-backuper-fixture-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create download directory: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(workDir) }
+
+	backupPath := filepath.Join(workDir, path.Base(filepath.ToSlash(selected)))
+	logger.Infof("Downloading %s", selected)
+	if backupPath, err = storage.Fetch(storageTarget.storage, selected, backupPath, cfg.Backup.Transfers()); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if err := verifyDownload(storageTarget.storage, selected, backupPath); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return backupPath, cleanup, nil
+}
+
+// fixtureReducer removes rows from the database restored for a fixture and
+// returns the row counts of its tables, or nil when it kept every row
+type fixtureReducer func(ctx context.Context, rehearsal *restore.Rehearsal, result *restore.RehearsalResult) ([]restore.TableSubset, error)
+
+// buildFixture restores backupPath into a rehearsal container, lets reduce
+// remove rows and dumps the remaining data to out as plain SQL. The
+// container is removed afterwards.
+func buildFixture(cfg *config.Config, logger *logrus.Logger, backupPath, database, version, out string, reduce fixtureReducer) ([]restore.TableSubset, error) {
+	rehearsal, err := restore.NewRehearsal(&cfg.Rehearsal, logger)
+	if err != nil {
+		return nil, err
+	}
+	result, err := rehearsal.Run(backupPath, database, restore.RehearsalOptions{Version: version, SkipChecks: true, Transforms: cfg.Import.Transforms})
+	if result != nil {
+		defer func() {
+			if err := rehearsal.Remove(result); err != nil {
+				logger.Warnf("Failed to remove rehearsal container: %v", err)
+			}
+		}()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore the backup: %w", err)
+	}
+
+	subsets, err := reduce(context.Background(), rehearsal, result)
+	if err != nil {
+		return nil, err
+	}
+
+	dbConfig := result.DatabaseConfig()
+	dumpPath, err := backup.NewPostgresBackup(&dbConfig, logger).CreateBackup()
+	defer os.Remove(dumpPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump the fixture: %w", err)
+	}
+	if err := copyFixture(dumpPath, out); err != nil {
+		return nil, err
+	}
+	return subsets, nil
+}
+
+// copyFixture copies the dump of a fixture to its destination
+func copyFixture(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open fixture dump: %w", err)
+	}
+	defer in.Close()
+
+	if dir := filepath.Dir(dst); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create fixture directory: %w", err)
+		}
+	}
+	outFile, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create fixture: %w", err)
+	}
+	if _, err := io.Copy(outFile, in); err != nil {
+		outFile.Close()
+		return fmt.Errorf("failed to write fixture: %w", err)
+	}
+	if err := outFile.Close(); err != nil {
+		return fmt.Errorf("failed to write fixture: %w", err)
+	}
+	return nil
+}
+
+// printFixture reports the fixture written and the rows it kept
+func printFixture(out string, subsets []restore.TableSubset) {
+	size := "-"
+	if info, err := os.Stat(out); err == nil {
+		size = progress.FormatBytes(info.Size())
+	}
+	if subsets == nil {
+		fmt.Printf("Wrote fixture %s (%s) with every row of the backup\n", out, size)
+		return
+	}
+	var before, after int64
+	for _, subset := range subsets {
+		before += subset.Before
+		after += subset.After
+	}
+	fmt.Printf("Wrote fixture %s (%s): kept %d of %d rows in %d tables\n", out, size, after, before, len(subsets))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"db-backuper/internal/s3"
+)
+
+// defaultIncompleteUploadHours is the age after which gc aborts incomplete
+// uploads when neither -older-than-hours nor the configuration sets one
+const defaultIncompleteUploadHours = 24
+
+// gcCommand aborts the incomplete multipart uploads under every backup prefix in use
+func gcCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("gc", "[-older-than-hours <hours>] [-dry-run]")
+	olderThan := fs.Int("older-than-hours", 0, fmt.Sprintf("Only abort uploads started more than this many hours ago (default: aws.abort_incomplete_uploads_hours or %d)", defaultIncompleteUploadHours))
+	dryRun := fs.Bool("dry-run", false, "List the incomplete uploads without aborting them")
+	return fs, func() error {
+		if *olderThan < 0 {
+			fs.Usage()
+			return fmt.Errorf("-older-than-hours must not be negative")
+		}
+
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+		if !cfg.IsAWSStorage() {
+			return fmt.Errorf("gc only applies to AWS S3 storage")
+		}
+
+		hours := *olderThan
+		if hours == 0 {
+			hours = cfg.AWS.AbortIncompleteUploadsHours
+		}
+		if hours == 0 {
+			hours = defaultIncompleteUploadHours
+		}
+
+		storageManager, err := newStorageManager(cfg, logger)
+		if err != nil {
+			return err
+		}
+		targets, err := newStorageTargets(cfg, storageManager, logger).All()
+		if err != nil {
+			return err
+		}
+
+		total := 0
+		for _, target := range targets {
+			sm := target.storage.(*s3.S3Manager)
+			uploads, err := sm.AbortIncompleteUploads(target.prefix, time.Duration(hours)*time.Hour, *dryRun)
+			for _, upload := range uploads {
+				fmt.Printf("%s/%s (started %s)\n", sm.Location(), upload.Key, upload.Initiated.Format(time.RFC3339))
+			}
+			total += len(uploads)
+			if err != nil {
+				return err
+			}
+		}
+
+		if *dryRun {
+			fmt.Printf("%d incomplete upload(s) older than %d hours would be aborted\n", total, hours)
+		} else {
+			fmt.Printf("Aborted %d incomplete upload(s) older than %d hours\n", total, hours)
+		}
+		return nil
+	}
+}
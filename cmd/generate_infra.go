@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"db-backuper/internal/infra"
+)
+
+// generateInfraCommand prints the Terraform or SAM definitions deploying the
+// backup Lambda function with the current configuration
+func generateInfraCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("generate-infra", "[-format terraform|sam] [-name <function>] [-package <zip>] [-layers <arn,...>]")
+	format := fs.String("format", "terraform", "Definitions to print: terraform or sam")
+	var opts infra.Options
+	fs.StringVar(&opts.FunctionName, "name", infra.DefaultFunctionName, "Name of the Lambda function and prefix of its resources")
+	fs.StringVar(&opts.Package, "package", infra.DefaultPackage, "Zip holding the bootstrap binary built from Dockerfile.lambda")
+	layers := fs.String("layers", "", "Comma-separated ARNs of layers to add, such as one with the PostgreSQL client tools")
+	return fs, func() error {
+		if *layers != "" {
+			opts.Layers = strings.Split(*layers, ",")
+		}
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+		stack, err := infra.New(cfg, opts)
+		if err != nil {
+			return err
+		}
+		for _, warning := range stack.Warnings {
+			logger.Warn(warning)
+		}
+
+		switch *format {
+		case "terraform":
+			module, err := stack.Terraform()
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Print(module)
+			return err
+		case "sam":
+			template, err := stack.SAM()
+			if err != nil {
+				return err
+			}
+			_, err = os.Stdout.Write(template)
+			return err
+		default:
+			return fmt.Errorf("unknown format %q: use terraform or sam", *format)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"db-backuper/internal/audit"
+	"db-backuper/internal/s3"
+	"db-backuper/internal/storage"
+)
+
+// holdCommand exempts a backup from retention cleanup until the hold is released
+func holdCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("hold", "(-key <key> | -database <name> [-date <YYYY-MM-DD>] | -restore-point <name>) [-reason <text>] [-release] | -list [-output table|json|yaml]")
+	selection := addBackupFlags(fs, "hold")
+	reason := fs.String("reason", "", "Why the backup is held, e.g. a legal hold or incident reference")
+	release := fs.Bool("release", false, "Release the hold so retention cleanup applies again")
+	list := fs.Bool("list", false, "List the held backups")
+	output := addOutputFlag(fs)
+	return fs, func() error {
+		if !*list {
+			if err := selection.validate(); err != nil {
+				fs.Usage()
+				return err
+			}
+			if !*release && *reason == "" {
+				fs.Usage()
+				return fmt.Errorf("-reason is required when placing a hold")
+			}
+		}
+
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+
+		storageManager, err := newStorageManager(cfg, logger)
+		if err != nil {
+			return err
+		}
+
+		if *list {
+			holds, err := storage.LoadHolds(storageManager, cfg.Backup.BackupPrefix)
+			if err != nil {
+				return err
+			}
+			return printResults(output, holds, func(w io.Writer) {
+				fmt.Fprintln(w, "KEY\tDATABASE\tCREATED\tACTOR\tREASON")
+				for _, hold := range holds {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", hold.Key, hold.Database, hold.CreatedAt.Format("2006-01-02 15:04:05"), hold.Actor, hold.Reason)
+				}
+			})
+		}
+
+		target, key, err := selection.resolve(newStorageTargets(cfg, storageManager, logger))
+		if err != nil {
+			return err
+		}
+		backend := target.storage
+
+		holds, err := storage.LoadHolds(backend, target.prefix)
+		if err != nil {
+			return err
+		}
+
+		action := audit.ActionHold
+		if *release {
+			// A released backup may already be gone, so only the holds are searched
+			index := slices.IndexFunc(holds, func(hold storage.Hold) bool { return matchKey([]string{hold.Key}, key) != "" })
+			if index < 0 {
+				return fmt.Errorf("backup %s is not held", key)
+			}
+			key = holds[index].Key
+			holds = slices.Delete(holds, index, index+1)
+			action = audit.ActionReleaseHold
+		} else {
+			keys, err := backend.ListKeys(key)
+			if err != nil {
+				return err
+			}
+			requested := key
+			if key = matchKey(keys, key); key == "" {
+				return fmt.Errorf("backup %s not found in %s", requested, backend.Location())
+			}
+			if slices.ContainsFunc(holds, func(hold storage.Hold) bool { return hold.Key == key }) {
+				return fmt.Errorf("backup %s is already held", key)
+			}
+			holds = append(holds, storage.Hold{
+				Key:       key,
+				Database:  databaseFromKey(key, target.prefix),
+				Reason:    *reason,
+				Actor:     audit.CurrentActor(),
+				CreatedAt: time.Now().UTC(),
+			})
+		}
+
+		if err := storage.SaveHolds(backend, target.prefix, holds); err != nil {
+			return err
+		}
+
+		// The tag only mirrors the hold; the holds file is what retention reads
+		if manager, ok := backend.(*s3.S3Manager); ok {
+			if err := manager.SetHoldTag(key, *reason, !*release); err != nil {
+				logger.Warnf("Failed to update the hold tag: %v", err)
+			}
+			if days := cfg.AWS.Lifecycle.ExpirationDays; days > 0 && !*release {
+				logger.Warnf("Bucket lifecycle rules applied from aws.lifecycle.expiration_days delete backups %d days after they were written, including held ones", days)
+			}
+		}
+
+		details := map[string]string{}
+		if *reason != "" {
+			details["reason"] = *reason
+		}
+		if err := newAuditLog(cfg, logger).Record(audit.Event{
+			Action:  action,
+			Storage: backend.Location(),
+			Targets: []string{key},
+			Details: details,
+		}); err != nil {
+			logger.Errorf("Failed to record hold in audit log: %v", err)
+		}
+
+		if *release {
+			fmt.Printf("Released the hold on %s\n", key)
+		} else {
+			fmt.Printf("Held %s; retention cleanup will keep it until the hold is released\n", key)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	"db-backuper/internal/iampolicy"
+)
+
+// iamPolicyCommand prints the least privilege IAM policy of the configured
+// buckets, prefixes, key and metrics
+func iamPolicyCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("iam-policy", "[-read-only] [-setup]")
+	var access iampolicy.Access
+	fs.BoolVar(&access.ReadOnly, "read-only", false, "Only grant listing, downloading and restoring backups")
+	fs.BoolVar(&access.Setup, "setup", false, "Also grant creating and configuring the buckets with init-storage and apply-lifecycle")
+	return fs, func() error {
+		cfg, _, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+		policy, err := iampolicy.Generate(cfg, access)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(policy)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"db-backuper/internal/backup"
+	"db-backuper/internal/config"
+	"db-backuper/internal/s3"
+	"db-backuper/internal/setup"
+
+	"github.com/sirupsen/logrus"
+)
+
+// initCommand asks for a first configuration on the terminal, testing the
+// database connections and the storage as they are entered, and writes it
+func initCommand() (*flag.FlagSet, func() error) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	setUsage(fs, "init", "[-output <path>] [-force]")
+	output := fs.String("output", config.DefaultConfigPath, "Configuration file to write")
+	force := fs.Bool("force", false, "Replace an existing file without asking")
+	return fs, func() error {
+		if _, err := os.Stat(*output); err == nil && !*force {
+			if !confirm(os.Stdin, os.Stdout, fmt.Sprintf("%s already exists and will be replaced.", *output)) {
+				return fmt.Errorf("aborted")
+			}
+		}
+
+		// The checks report their own failures, so keep the logs off the prompts
+		logger := logrus.New()
+		logger.SetOutput(io.Discard)
+
+		wizard := setup.New(os.Stdin, os.Stdout, setup.Checks{
+			Database: func(db *config.DatabaseConfig) error {
+				engine, err := backup.NewEngine(db, logger)
+				if err != nil {
+					return err
+				}
+				return engine.TestConnection()
+			},
+			Storage: func(cfg *config.Config) error {
+				return checkInitStorage(cfg, logger)
+			},
+		})
+		cfg, err := wizard.Run()
+		if err != nil {
+			return err
+		}
+
+		data, err := setup.Marshal(cfg)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*output, data, 0600); err != nil {
+			return fmt.Errorf("failed to write config: %w", err)
+		}
+
+		fmt.Printf("\nWrote %s. Next:\n", *output)
+		fmt.Printf("  go run ./cmd validate -config %s\n", *output)
+		fmt.Printf("  go run ./cmd backup -config %s\n", *output)
+		fmt.Printf("  go run ./cmd serve -config %s\n", *output)
+		return nil
+	}
+}
+
+// checkInitStorage writes, reads and deletes a test file under the backup
+// prefix, creating the local backup directory if needed
+func checkInitStorage(cfg *config.Config, logger *logrus.Logger) error {
+	if cfg.IsLocalStorage() {
+		dir := filepath.Join(cfg.Local.Path, filepath.FromSlash(cfg.Backup.BackupPrefix))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+		return verifyLocalAccess(dir)
+	}
+
+	manager, err := s3.NewS3Manager(&cfg.AWS, logger)
+	if err != nil {
+		return err
+	}
+	if err := manager.VerifyAccess(cfg.Backup.BackupPrefix); err != nil {
+		return fmt.Errorf("%w (if the bucket does not exist yet, keep these settings and run init-storage)", err)
+	}
+	return nil
+}
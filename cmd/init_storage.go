@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/s3"
+
+	"github.com/sirupsen/logrus"
+)
+
+// initStorageCommand prepares the configured storage for a new environment: it
+// creates the bucket or backup directories, applies the bucket settings of
+// the configuration and checks access with a test object
+func initStorageCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("init-storage", "")
+	return fs, func() error {
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case cfg.IsLocalStorage():
+			return initLocalStorage(cfg)
+		case cfg.IsAWSStorage():
+			storageManager, err := newStorageManager(cfg, logger)
+			if err != nil {
+				return err
+			}
+			return initS3Storage(cfg, newStorageTargets(cfg, storageManager, logger), logger)
+		default:
+			return fmt.Errorf("init-storage only applies to local and AWS S3 storage; set up rclone remotes and storage plugins with their own tools")
+		}
+	}
+}
+
+// initLocalStorage creates the backup directory of every database and
+// checks that files can be written, read and deleted in each backup prefix
+func initLocalStorage(cfg *config.Config) error {
+	prefixes := []string{filepath.Join(cfg.Local.Path, filepath.FromSlash(cfg.Backup.BackupPrefix))}
+	dirs := []string{prefixes[0]}
+	for _, db := range cfg.Databases {
+		resolved := cfg.StorageFor(db.Database)
+		prefix := filepath.Join(resolved.Path, filepath.FromSlash(resolved.Prefix))
+		if !slices.Contains(prefixes, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+		dirs = append(dirs, filepath.Join(prefix, db.Database))
+	}
+
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+	for _, prefix := range prefixes {
+		if err := verifyLocalAccess(prefix); err != nil {
+			return fmt.Errorf("%s is not usable for backups: %w", prefix, err)
+		}
+		fmt.Printf("Ready: %s\n", prefix)
+	}
+	return nil
+}
+
+// verifyLocalAccess writes, reads and deletes a test file in dir
+func verifyLocalAccess(dir string) error {
+	file, err := os.CreateTemp(dir, ".db-backuper-init-*")
+	if err != nil {
+		return fmt.Errorf("write check failed: %w", err)
+	}
+	data := []byte("db-backuper init-storage test file\n")
+	_, writeErr := file.Write(data)
+	if err := errors.Join(writeErr, file.Close()); err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("write check failed: %w", err)
+	}
+	read, err := os.ReadFile(file.Name())
+	if err == nil && !bytes.Equal(read, data) {
+		err = fmt.Errorf("%s was read back with different contents", file.Name())
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("read check failed: %w", err)
+	}
+	if err := os.Remove(file.Name()); err != nil {
+		return fmt.Errorf("delete check failed: %w", err)
+	}
+	return nil
+}
+
+// initS3Storage creates every bucket in use, sets its versioning and default
+// encryption, checks access under each backup prefix and applies the
+// lifecycle rules of the configuration
+func initS3Storage(cfg *config.Config, resolver *storageTargets, logger *logrus.Logger) error {
+	targets, err := resolver.All()
+	if err != nil {
+		return err
+	}
+
+	configured := make(map[string]bool)
+	for _, target := range targets {
+		manager := target.storage.(*s3.S3Manager)
+		location := manager.Location()
+		if !configured[location] {
+			configured[location] = true
+			created, err := manager.EnsureBucket()
+			if err != nil {
+				return err
+			}
+			if created {
+				fmt.Printf("Created bucket %s\n", location)
+			}
+			if cfg.AWS.Versioning {
+				changed, err := manager.EnableVersioning()
+				if err != nil {
+					return err
+				}
+				if changed {
+					fmt.Printf("Enabled versioning of %s\n", location)
+				}
+			}
+			if cfg.AWS.DefaultEncryption != "" {
+				changed, err := manager.SetDefaultEncryption(cfg.AWS.DefaultEncryption, cfg.AWS.KMSKeyID)
+				if err != nil {
+					return err
+				}
+				if changed {
+					fmt.Printf("Set default encryption of %s to %s\n", location, cfg.AWS.DefaultEncryption)
+				}
+			}
+		}
+
+		if err := manager.VerifyAccess(target.prefix); err != nil {
+			return fmt.Errorf("%s/%s is not usable for backups: %w", location, target.prefix, err)
+		}
+		fmt.Printf("Ready: %s/%s\n", location, target.prefix)
+	}
+
+	if cfg.AWS.Versioning && cfg.AWS.Lifecycle.NoncurrentDays == 0 {
+		logger.Warn("Versioned buckets keep the backups retention deletes as noncurrent versions; set aws.lifecycle.noncurrent_days to expire them")
+	}
+	if cfg.AWS.Lifecycle.Enabled() {
+		changed, err := applyLifecycle(cfg, resolver, false)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Changed %d lifecycle rule(s)\n", changed)
+	}
+	return nil
+}
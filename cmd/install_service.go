@@ -0,0 +1,221 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/service"
+)
+
+// installServiceCommand registers the scheduler with the service manager of the
+// host, running serve with the current configuration
+func installServiceCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("install-service", "[-name <name>] [-dry-run]")
+	name := fs.String("name", service.DefaultName, "Name to register the service under")
+	dryRun := fs.Bool("dry-run", false, "Print the service definition instead of registering it")
+	return fs, func() error {
+		if err := service.ValidateName(*name); err != nil {
+			return err
+		}
+		// The configuration the service will load must be valid before it is registered
+		if _, _, err := loadCommandConfig(configFlags); err != nil {
+			return err
+		}
+		def, err := serviceDefinition(*name, configFlags)
+		if err != nil {
+			return err
+		}
+
+		switch runtime.GOOS {
+		case "linux":
+			return installSystemd(def, *dryRun)
+		case "darwin":
+			return installLaunchd(def, *dryRun)
+		case "windows":
+			return runNSSM(def.NSSMInstall(), *dryRun)
+		default:
+			return fmt.Errorf("install-service does not support %s; run serve under your service manager", runtime.GOOS)
+		}
+	}
+}
+
+// uninstallServiceCommand stops the scheduler service and removes its registration
+func uninstallServiceCommand() (*flag.FlagSet, func() error) {
+	fs := flag.NewFlagSet("uninstall-service", flag.ExitOnError)
+	setUsage(fs, "uninstall-service", "[-name <name>]")
+	name := fs.String("name", service.DefaultName, "Name the service was registered under")
+	return fs, func() error {
+		if err := service.ValidateName(*name); err != nil {
+			return err
+		}
+		switch runtime.GOOS {
+		case "linux":
+			return uninstallSystemd(*name)
+		case "darwin":
+			return uninstallLaunchd(*name)
+		case "windows":
+			return runNSSM(service.Definition{Name: *name}.NSSMUninstall(), false)
+		default:
+			return fmt.Errorf("uninstall-service does not support %s", runtime.GOOS)
+		}
+	}
+}
+
+// serviceDefinition describes a service running this binary's serve command
+// with the configuration selected by flags, by absolute paths
+func serviceDefinition(name string, flags configFlags) (service.Definition, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return service.Definition{}, fmt.Errorf("failed to locate the db-backuper binary: %w", err)
+	}
+	if executable, err = filepath.EvalSymlinks(executable); err != nil {
+		return service.Definition{}, fmt.Errorf("failed to locate the db-backuper binary: %w", err)
+	}
+	if strings.Contains(executable, "go-build") {
+		return service.Definition{}, errors.New("install-service registers the running binary; build it first instead of using go run")
+	}
+
+	configPath := *flags.path
+	if configPath == "" {
+		if _, err := os.Stat(config.DefaultConfigPath); err == nil {
+			configPath = config.DefaultConfigPath
+		}
+	}
+	if configPath == "" && *flags.envFile == "" {
+		return service.Definition{}, errors.New("the service does not inherit this shell's environment; pass -config or -env-file")
+	}
+
+	args := []string{"serve"}
+	workingDir, err := os.Getwd()
+	if err != nil {
+		return service.Definition{}, err
+	}
+	if configPath != "" {
+		if configPath, err = filepath.Abs(configPath); err != nil {
+			return service.Definition{}, err
+		}
+		args = append(args, "-config", configPath)
+		// Relative paths in the configuration keep resolving next to it
+		workingDir = filepath.Dir(configPath)
+	}
+	if *flags.envFile != "" {
+		envFile, err := filepath.Abs(*flags.envFile)
+		if err != nil {
+			return service.Definition{}, err
+		}
+		args = append(args, "-env-file", envFile)
+	}
+	if profile := cmp.Or(*flags.profile, os.Getenv(config.ProfileEnvVar)); profile != "" {
+		args = append(args, "-profile", profile)
+	}
+	if *flags.strict {
+		args = append(args, "-strict")
+	}
+	return service.Definition{Name: name, Executable: executable, Args: args, WorkingDir: workingDir}, nil
+}
+
+// installSystemd writes the unit of a service, then enables and starts it
+func installSystemd(def service.Definition, dryRun bool) error {
+	unitPath := service.SystemdUnitPath(def.Name)
+	if dryRun {
+		fmt.Printf("# %s\n%s", unitPath, def.SystemdUnit())
+		return nil
+	}
+	if err := os.WriteFile(unitPath, []byte(def.SystemdUnit()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s (installing a service needs root): %w", unitPath, err)
+	}
+	if err := runServiceManager("systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+	if err := runServiceManager("systemctl", "enable", "--now", def.Name); err != nil {
+		return err
+	}
+	fmt.Printf("Installed and started %s; follow its logs with: journalctl -u %s -f\n", unitPath, def.Name)
+	return nil
+}
+
+// uninstallSystemd stops and disables a service, then removes its unit
+func uninstallSystemd(name string) error {
+	unitPath := service.SystemdUnitPath(name)
+	if _, err := os.Stat(unitPath); err != nil {
+		return fmt.Errorf("service %s is not installed: %w", name, err)
+	}
+	if err := runServiceManager("systemctl", "disable", "--now", name); err != nil {
+		return err
+	}
+	if err := os.Remove(unitPath); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", unitPath, err)
+	}
+	if err := runServiceManager("systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+	fmt.Printf("Stopped %s and removed %s\n", name, unitPath)
+	return nil
+}
+
+// installLaunchd writes the daemon definition of a service and loads it
+func installLaunchd(def service.Definition, dryRun bool) error {
+	plistPath := service.LaunchdPlistPath(def.Name)
+	if dryRun {
+		fmt.Printf("<!-- %s -->\n%s", plistPath, def.LaunchdPlist())
+		return nil
+	}
+	if err := os.WriteFile(plistPath, []byte(def.LaunchdPlist()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s (installing a service needs root): %w", plistPath, err)
+	}
+	if err := runServiceManager("launchctl", "bootstrap", "system", plistPath); err != nil {
+		return err
+	}
+	fmt.Printf("Installed and started %s; its logs are written to /Library/Logs/%s.log\n", plistPath, def.Name)
+	return nil
+}
+
+// uninstallLaunchd unloads a daemon and removes its definition
+func uninstallLaunchd(name string) error {
+	plistPath := service.LaunchdPlistPath(name)
+	if _, err := os.Stat(plistPath); err != nil {
+		return fmt.Errorf("service %s is not installed: %w", name, err)
+	}
+	if err := runServiceManager("launchctl", "bootout", "system/"+name); err != nil {
+		return err
+	}
+	if err := os.Remove(plistPath); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", plistPath, err)
+	}
+	fmt.Printf("Stopped %s and removed %s\n", name, plistPath)
+	return nil
+}
+
+// runNSSM runs nssm commands registering or removing a Windows service, or
+// prints them when dryRun is set
+func runNSSM(commands [][]string, dryRun bool) error {
+	for _, args := range commands {
+		if dryRun {
+			fmt.Println("nssm " + strings.Join(args, " "))
+			continue
+		}
+		if err := runServiceManager("nssm", args...); err != nil {
+			return fmt.Errorf("%w (Windows services are registered through NSSM, see https://nssm.cc)", err)
+		}
+	}
+	return nil
+}
+
+// runServiceManager runs a command of the service manager, passing its output through
+func runServiceManager(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s failed: %w", name, strings.Join(args, " "), err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"db-backuper/internal/jobs"
+	"db-backuper/internal/web"
+)
+
+// apiTokenEnv names the environment variable holding the API token sent to the scheduler
+const apiTokenEnv = "API_TOKEN"
+
+// jobsCommand lists, starts, approves or cancels the jobs of a running scheduler through its HTTP server
+func jobsCommand() (*flag.FlagSet, func() error) {
+	fs := flag.NewFlagSet("jobs", flag.ExitOnError)
+	setUsage(fs, "jobs", "[-server <url>] [-start [-database <name>]... | -restore <key> | -approve <job-id> | -cancel <job-id>] [-output table|json|yaml]")
+	server := fs.String("server", "http://localhost:8080", "URL of the scheduler's -listen address")
+	start := fs.Bool("start", false, "Queue a backup of every database, or of the -database ones")
+	var databases stringSliceFlag
+	fs.Var(&databases, "database", "Only back up the named database when starting a backup (repeatable)")
+	restoreKey := fs.String("restore", "", "Queue a restore of the backup with this storage key into the import target database")
+	approve := fs.String("approve", "", "Approve the restore pending approval with this job ID")
+	cancel := fs.String("cancel", "", "Cancel the pending, queued or running job with this ID")
+	output := addOutputFlag(fs)
+	return fs, func() error {
+		actions := 0
+		for _, set := range []bool{*start, *restoreKey != "", *approve != "", *cancel != ""} {
+			if set {
+				actions++
+			}
+		}
+		if actions > 1 || len(databases) > 0 && !*start {
+			fs.Usage()
+			return fmt.Errorf("specify at most one of -start, -restore, -approve or -cancel, and -database only with -start")
+		}
+		base := strings.TrimSuffix(*server, "/")
+
+		var list []jobs.Job
+		var job jobs.Job
+		switch {
+		case *start:
+			body, _ := json.Marshal(web.JobRequest{Databases: databases})
+			if err := callJobs(http.MethodPost, base+"/jobs", body, &job); err != nil {
+				return err
+			}
+		case *restoreKey != "":
+			body, _ := json.Marshal(web.JobRequest{Kind: jobs.KindRestore, Backup: *restoreKey})
+			if err := callJobs(http.MethodPost, base+"/jobs", body, &job); err != nil {
+				return err
+			}
+		case *approve != "":
+			if err := callJobs(http.MethodPost, fmt.Sprintf("%s/jobs/%s/approve", base, url.PathEscape(*approve)), nil, &job); err != nil {
+				return err
+			}
+		case *cancel != "":
+			if err := callJobs(http.MethodPost, fmt.Sprintf("%s/jobs/%s/cancel", base, url.PathEscape(*cancel)), nil, &job); err != nil {
+				return err
+			}
+		default:
+			if err := callJobs(http.MethodGet, base+"/jobs", nil, &list); err != nil {
+				return err
+			}
+		}
+		if job.ID != "" {
+			list = []jobs.Job{job}
+		}
+
+		return printResults(output, list, func(w io.Writer) {
+			fmt.Fprintln(w, "JOB ID\tKIND\tTRIGGER\tTARGET\tSTATE\tRESULT\tQUEUED")
+			for _, job := range list {
+				target, result := "all", job.Result
+				switch {
+				case job.Backup != "":
+					target = job.Backup
+				case len(job.Databases) > 0:
+					target = strings.Join(job.Databases, ",")
+				}
+				if result == "" {
+					result = "-"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", job.ID, job.Kind, job.Trigger, target, job.State, result, job.QueuedAt.Local().Format("2006-01-02 15:04:05"))
+			}
+		})
+	}
+}
+
+// callJobs sends a request to the job endpoints of a scheduler, decoding the response into v
+func callJobs(method, endpoint string, body []byte, v any) error {
+	resp, err := apiRequest(method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && strings.HasSuffix(endpoint, "/jobs") {
+		return fmt.Errorf("the scheduler serves no job queue: %s (is it running with -listen?)", resp.Status)
+	}
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("scheduler refused the request: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse jobs: %w", err)
+	}
+	return nil
+}
+
+// apiRequest sends a request to the HTTP server of a scheduler with the
+// token in API_TOKEN, if any
+func apiRequest(method, endpoint string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token := os.Getenv(apiTokenEnv); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach the scheduler: %w", err)
+	}
+	return resp, nil
+}
@@ -5,12 +5,18 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
+	"db-backuper/internal/audit"
 	"db-backuper/internal/backup"
+	"db-backuper/internal/compliance"
 	"db-backuper/internal/config"
+	"db-backuper/internal/metrics"
+	"db-backuper/internal/redact"
+	"db-backuper/internal/runid"
 	"db-backuper/internal/s3"
+	"db-backuper/internal/status"
+	"db-backuper/internal/storage"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/sirupsen/logrus"
@@ -34,6 +40,8 @@ func Handler(ctx context.Context, event LambdaEvent) (LambdaResponse, error) {
 	logger := logrus.New()
 	logger.SetLevel(logrus.InfoLevel)
 	logger.SetFormatter(&logrus.JSONFormatter{})
+	redactor := redact.New()
+	redact.Install(logger, redactor)
 
 	logger.Info("Starting PostgreSQL backup Lambda function")
 
@@ -48,166 +56,37 @@ func Handler(ctx context.Context, event LambdaEvent) (LambdaResponse, error) {
 		logger.WithError(err).Error("Failed to load configuration")
 		return LambdaResponse{
 			StatusCode: 500,
-			Message:    fmt.Sprintf("Configuration error: %v", err),
+			Message:    redactor.String(fmt.Sprintf("Configuration error: %v", err)),
 			Success:    false,
 		}, nil
 	}
 
-	// Setup logger with configuration
+	// Setup logger with configuration, masking every configured credential
 	logger = setupLogger(cfg.Logging)
+	redactor.AddSecrets(cfg.Secrets()...)
+	redact.Install(logger, redactor)
+	if err := compliance.Apply(cfg, logger); err != nil {
+		logger.WithError(err).Error("Refusing to run")
+		return LambdaResponse{
+			StatusCode: 500,
+			Message:    redactor.String(fmt.Sprintf("Compliance error: %v", err)),
+			Success:    false,
+		}, nil
+	}
 
-	// Execute backup operation
-	return handleBackup(cfg, logger)
+	// Execute backup operation, keeping credentials out of the response as well
+	response, err := handleBackup(cfg, logger)
+	response.Message = redactor.String(response.Message)
+	return response, err
 }
 
 // loadLambdaConfig loads configuration for Lambda environment
 func loadLambdaConfig() (*config.Config, error) {
-	// Create a minimal config that will be populated by environment variables
-	cfg := &config.Config{
-		Databases: []config.DatabaseConfig{},
-		AWS: config.AWSConfig{
-			Region:          os.Getenv("AWS_REGION"), // Automatically provided by Lambda
-			Bucket:          os.Getenv("AWS_BUCKET"),
-			AccessKeyID:     "", // Not needed - Lambda uses IAM role
-			SecretAccessKey: "", // Not needed - Lambda uses IAM role
-		},
-		Backup: config.BackupConfig{
-			RetentionDays: 2,
-			Schedule:      "0 */3 * * *",
-			BackupPrefix:  "postgres-backup",
-		},
-		Logging: config.LoggingConfig{
-			Level:  "info",
-			Format: "json",
-		},
-	}
-
-	// Apply environment variable overrides
-	if err := applyLambdaEnvOverrides(cfg); err != nil {
-		return nil, fmt.Errorf("failed to apply environment overrides: %w", err)
-	}
-
-	// Validate configuration for backup operations
-	if err := cfg.ValidateForBackup(); err != nil {
-		return nil, fmt.Errorf("configuration validation failed: %w", err)
-	}
-
-	return cfg, nil
-}
-
-// applyLambdaEnvOverrides applies environment variable overrides for Lambda
-func applyLambdaEnvOverrides(cfg *config.Config) error {
-	// Parse environment variables for each section
-	if err := parseLambdaConfigSections(cfg); err != nil {
-		return fmt.Errorf("failed to parse environment variables: %w", err)
-	}
-
-	// Handle database arrays from environment variables
-	if err := parseLambdaDatabases(cfg); err != nil {
-		return fmt.Errorf("failed to parse database environment variables: %w", err)
-	}
-
-	return nil
-}
-
-// parseLambdaConfigSections parses environment variables for different config sections
-func parseLambdaConfigSections(cfg *config.Config) error {
-	// Parse AWS config
-	if region := os.Getenv("AWS_REGION"); region != "" {
-		cfg.AWS.Region = region
-	}
-	if bucket := os.Getenv("AWS_BUCKET"); bucket != "" {
-		cfg.AWS.Bucket = bucket
-	}
-	if accessKey := os.Getenv("AWS_ACCESS_KEY_ID"); accessKey != "" {
-		cfg.AWS.AccessKeyID = accessKey
-	}
-	if secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY"); secretKey != "" {
-		cfg.AWS.SecretAccessKey = secretKey
-	}
-
-	// Parse Backup config
-	if retentionDays := os.Getenv("BACKUP_RETENTION_DAYS"); retentionDays != "" {
-		if days, err := parseInt(retentionDays); err == nil {
-			cfg.Backup.RetentionDays = days
-		}
-	}
-	if schedule := os.Getenv("BACKUP_SCHEDULE"); schedule != "" {
-		cfg.Backup.Schedule = schedule
-	}
-	if prefix := os.Getenv("BACKUP_PREFIX"); prefix != "" {
-		cfg.Backup.BackupPrefix = prefix
-	}
-
-	// Parse Logging config
-	if level := os.Getenv("LOG_LEVEL"); level != "" {
-		cfg.Logging.Level = level
-	}
-	if format := os.Getenv("LOG_FORMAT"); format != "" {
-		cfg.Logging.Format = format
-	}
-
-	return nil
-}
-
-// parseLambdaDatabases parses database configuration from environment variables
-func parseLambdaDatabases(cfg *config.Config) error {
-	// Check for database configuration in environment variables
-	// Format: DB_0_HOST, DB_0_PORT, DB_0_USERNAME, etc.
-	i := 0
-	for {
-		host := os.Getenv(fmt.Sprintf("DB_%d_HOST", i))
-		if host == "" {
-			if i == 0 {
-				return fmt.Errorf("no database configuration found - please set DB_0_HOST environment variable")
-			}
-			break // No more databases
-		}
-
-		db := config.DatabaseConfig{
-			Host:     host,
-			Port:     5432, // Default port
-			Username: os.Getenv(fmt.Sprintf("DB_%d_USERNAME", i)),
-			Password: os.Getenv(fmt.Sprintf("DB_%d_PASSWORD", i)),
-			Database: os.Getenv(fmt.Sprintf("DB_%d_DATABASE", i)),
-			SSLMode:  os.Getenv(fmt.Sprintf("DB_%d_SSL_MODE", i)),
-		}
-
-		// Parse port if provided
-		if portStr := os.Getenv(fmt.Sprintf("DB_%d_PORT", i)); portStr != "" {
-			if port, err := parseInt(portStr); err == nil {
-				db.Port = port
-			}
-		}
-
-		// Set default SSL mode if not provided
-		if db.SSLMode == "" {
-			db.SSLMode = "disable"
-		}
-
-		// Validate required fields
-		if db.Username == "" {
-			return fmt.Errorf("DB_%d_USERNAME is required", i)
-		}
-		if db.Password == "" {
-			return fmt.Errorf("DB_%d_PASSWORD is required", i)
-		}
-		if db.Database == "" {
-			return fmt.Errorf("DB_%d_DATABASE is required", i)
-		}
-
-		cfg.Databases = append(cfg.Databases, db)
-		i++
-	}
-
-	return nil
-}
-
-// parseInt parses a string to integer
-func parseInt(s string) (int, error) {
-	var result int
-	_, err := fmt.Sscanf(s, "%d", &result)
-	return result, err
+	// Defaults for a backup-only function; every value can be overridden through
+	// the same environment variables the CLI accepts
+	defaults := config.EnvDefaults()
+	defaults.Logging.Format = "json"
+	return config.LoadEnvConfig(defaults)
 }
 
 // setupLogger configures the logger based on configuration
@@ -253,14 +132,36 @@ func handleBackup(cfg *config.Config, logger *logrus.Logger) (LambdaResponse, er
 		}, nil
 	}
 
-	// Create PostgreSQL backup instances for each database
-	var postgresBackups []*backup.PostgresBackup
-	for i, dbConfig := range cfg.Databases {
+	s3Manager.SetAuditLog(audit.NewLog(&cfg.Audit, s3Manager))
+	s3Manager.SetUploadStateDir(uploadStateDir(cfg))
+	s3Manager.SetModTimeRetention(cfg.Backup.RetentionModTimeFallback)
+	s3Manager.SetTransferJobs(cfg.Backup.Transfers())
+
+	// Finish uploads cut short when a previous invocation timed out in this container
+	if err := s3Manager.ResumeUploads(); err != nil {
+		logger.Warnf("Failed to resume interrupted uploads: %v", err)
+	}
+
+	// Create backup engines for each database, highest priority first
+	var engines []backup.Engine
+	for i, dbConfig := range cfg.DatabasesByPriority() {
+		if !dbConfig.IsEnabled() {
+			logger.Infof("Skipping disabled database %d: %s", i+1, dbConfig.Database)
+			continue
+		}
 		logger.Infof("Initializing backup for database %d: %s", i+1, dbConfig.Database)
-		postgresBackup := backup.NewPostgresBackup(&dbConfig, logger)
+		engine, err := backup.NewEngine(&dbConfig, logger)
+		if err != nil {
+			logger.WithError(err).Errorf("Failed to initialize backup for database %d", i+1)
+			return LambdaResponse{
+				StatusCode: 500,
+				Message:    fmt.Sprintf("Backup initialization failed for database %d: %v", i+1, err),
+				Success:    false,
+			}, nil
+		}
 
 		// Test connection before adding to backup list
-		if err := postgresBackup.TestConnection(); err != nil {
+		if err := engine.TestConnection(); err != nil {
 			logger.WithError(err).Errorf("Connection test failed for database %d", i+1)
 			return LambdaResponse{
 				StatusCode: 500,
@@ -269,11 +170,20 @@ func handleBackup(cfg *config.Config, logger *logrus.Logger) (LambdaResponse, er
 			}, nil
 		}
 
-		postgresBackups = append(postgresBackups, postgresBackup)
+		engines = append(engines, engine)
 	}
 
 	// Run backup using the same logic as the main application
-	if err := performLambdaBackup(postgresBackups, s3Manager, &cfg.Backup, logger); err != nil {
+	summary, err := performLambdaBackup(engines, s3Manager, cfg, logger)
+	if statusErr := status.NewWriter(&cfg.Status, s3Manager, logger).Update(summary); statusErr != nil {
+		logger.WithError(statusErr).Warn("Failed to update status file")
+	}
+	if publisher, metricsErr := metrics.NewPublisher(cfg, logger); metricsErr != nil {
+		logger.WithError(metricsErr).Warn("Failed to initialize metrics")
+	} else if metricsErr := publisher.Publish(summary); metricsErr != nil {
+		logger.WithError(metricsErr).Warn("Failed to publish metrics")
+	}
+	if err != nil {
 		logger.WithError(err).Error("Backup operation failed")
 		return LambdaResponse{
 			StatusCode: 500,
@@ -290,66 +200,209 @@ func handleBackup(cfg *config.Config, logger *logrus.Logger) (LambdaResponse, er
 	}, nil
 }
 
+// uploadStateDir returns where in-progress multipart uploads are tracked
+func uploadStateDir(cfg *config.Config) string {
+	return filepath.Join(cfg.Backup.StateDirectory(), "uploads")
+}
+
+// lambdaTarget is an S3 bucket and the prefix backups are kept under in it
+type lambdaTarget struct {
+	s3Manager *s3.S3Manager
+	prefix    string
+}
+
 // performLambdaBackup performs backup operations for Lambda
-func performLambdaBackup(postgresBackups []*backup.PostgresBackup, s3Manager *s3.S3Manager, backupConfig *config.BackupConfig, logger *logrus.Logger) error {
-	startTime := time.Now()
-	logger.Infof("Starting backup operation for %d databases", len(postgresBackups))
+func performLambdaBackup(engines []backup.Engine, s3Manager *s3.S3Manager, cfg *config.Config, logger *logrus.Logger) (*status.RunSummary, error) {
+	backupConfig := &cfg.Backup
+	summary := &status.RunSummary{
+		RunID:     runid.New(),
+		StartedAt: time.Now(),
+		Storage:   s3Manager.Location(),
+		Disabled:  cfg.DisabledDatabases(),
+	}
 
-	var successfulBackups int
-	var failedBackups int
+	// Tag every log line of this invocation so CloudWatch logs can be grouped per run
+	runLogger := logger.WithFields(logrus.Fields{
+		"run_id":    summary.RunID,
+		"operation": "backup",
+		"storage":   summary.Storage,
+	})
+	runLogger.Infof("Starting backup operation for %d databases", len(engines))
+
+	// Databases with storage overrides go to their own bucket or prefix
+	buckets := map[string]*s3.S3Manager{cfg.AWS.Bucket: s3Manager}
+	targetFor := func(database string) (lambdaTarget, error) {
+		resolved := cfg.StorageFor(database)
+		manager, ok := buckets[resolved.Bucket]
+		if !ok {
+			awsConfig := cfg.AWS
+			awsConfig.Bucket = resolved.Bucket
+			var err error
+			if manager, err = s3.NewS3Manager(&awsConfig, logger); err != nil {
+				return lambdaTarget{}, fmt.Errorf("failed to initialize S3 bucket %s: %w", resolved.Bucket, err)
+			}
+			manager.SetAuditLog(audit.NewLog(&cfg.Audit, s3Manager))
+			manager.SetUploadStateDir(uploadStateDir(cfg))
+			manager.SetModTimeRetention(cfg.Backup.RetentionModTimeFallback)
+			manager.SetTransferJobs(cfg.Backup.Transfers())
+			buckets[resolved.Bucket] = manager
+		}
+		return lambdaTarget{s3Manager: manager, prefix: resolved.Prefix}, nil
+	}
 
-	// Backup each database
-	for i, postgresBackup := range postgresBackups {
-		logger.Infof("Backing up database %d of %d", i+1, len(postgresBackups))
+	// Backup each database, skipping the rest once the run budget is spent
+	budget := backupConfig.MaxRunDuration()
+	for i, e := range engines {
+		dbLogger := runLogger.WithField("database", e.DatabaseName())
+		if budget > 0 && time.Since(summary.StartedAt) >= budget {
+			reason := fmt.Sprintf("run budget of %s exhausted", budget)
+			dbLogger.Warnf("Skipping database %d of %d: %s", i+1, len(engines), reason)
+			result := status.SkippedResult(e.DatabaseName(), reason)
+			result.RunID = summary.RunID
+			summary.Add(result)
+			continue
+		}
+		engine := e.WithLogger(dbLogger)
+		target, err := targetFor(e.DatabaseName())
+		if err != nil {
+			dbLogger.Errorf("Failed to resolve storage for database %d: %v", i+1, err)
+			summary.Add(finishResult(status.DatabaseResult{
+				Database:  e.DatabaseName(),
+				Status:    status.ResultFailed,
+				RunID:     summary.RunID,
+				StartedAt: time.Now(),
+			}, err))
+			continue
+		}
+		dbS3Manager := target.s3Manager.WithLogger(dbLogger).(*s3.S3Manager)
+
+		dbLogger.Infof("Backing up database %d of %d", i+1, len(engines))
+		result := status.DatabaseResult{
+			Database:  e.DatabaseName(),
+			Status:    status.ResultFailed,
+			RunID:     summary.RunID,
+			StartedAt: time.Now(),
+		}
 
 		// Create database backup
-		backupPath, err := postgresBackup.CreateBackup()
+		backupPath, err := engine.CreateBackup()
 		if err != nil {
-			logger.Errorf("Failed to create backup for database %d: %v", i+1, err)
-			failedBackups++
+			dbLogger.Errorf("Failed to create backup for database %d: %v", i+1, err)
+			summary.Add(finishResult(result, err))
 			continue
 		}
 
-		// Get database name from the backup path (it's in the filename)
-		// Format: database-name_YYYY-MM-DD_HH-MM-SS.sql
-		filename := filepath.Base(backupPath)
-		databaseName := strings.Split(filename, "_")[0]
+		if info, err := os.Stat(backupPath); err == nil {
+			result.SizeBytes = info.Size()
+		}
+
+		// Record where the backup came from so the stored object describes itself
+		meta, err := backup.Provenance(engine, cfg.FindDatabase(e.DatabaseName()), backupPath, dbLogger)
+		if err != nil {
+			if cleanupErr := engine.CleanupBackup(backupPath); cleanupErr != nil {
+				dbLogger.Warnf("Failed to cleanup backup file: %v", cleanupErr)
+			}
+			dbLogger.Errorf("Failed to describe backup for database %d: %v", i+1, err)
+			summary.Add(finishResult(result, err))
+			continue
+		}
+		meta.RunID = summary.RunID
+		meta.Instance = cfg.Backup.InstanceName()
+
+		// Split large backups into parts within the object size limits of S3
+		splitPath, err := backup.Split(backupPath, cfg.Backup.SplitSize(), dbLogger)
+		if err != nil {
+			if cleanupErr := engine.CleanupBackup(backupPath); cleanupErr != nil {
+				dbLogger.Warnf("Failed to cleanup backup file: %v", cleanupErr)
+			}
+			dbLogger.Errorf("Failed to split backup for database %d: %v", i+1, err)
+			summary.Add(finishResult(result, err))
+			continue
+		}
+		backupPath = splitPath
 
 		// Save backup to S3
-		s3Key, err := s3Manager.UploadBackup(backupPath, backupConfig.BackupPrefix, databaseName)
+		s3Key, err := dbS3Manager.UploadBackup(backupPath, target.prefix, e.DatabaseName(), meta)
 		if err != nil {
 			// Cleanup local backup file on upload failure
-			if cleanupErr := postgresBackup.CleanupBackup(backupPath); cleanupErr != nil {
-				logger.Warnf("Failed to cleanup backup file after upload failure: %v", cleanupErr)
+			if cleanupErr := engine.CleanupBackup(backupPath); cleanupErr != nil {
+				dbLogger.Warnf("Failed to cleanup backup file after upload failure: %v", cleanupErr)
 			}
-			logger.Errorf("Failed to upload backup for database %d to S3: %v", i+1, err)
-			failedBackups++
+			dbLogger.Errorf("Failed to upload backup for database %d to S3: %v", i+1, err)
+			summary.Add(finishResult(result, err))
 			continue
 		}
 
+		if err := dbS3Manager.UpdateLatest(target.prefix, e.DatabaseName(), s3Key, result.SizeBytes, meta); err != nil {
+			dbLogger.Warnf("Failed to update latest backup pointer: %v", err)
+		}
+
 		// Cleanup local backup file after successful upload
-		if err := postgresBackup.CleanupBackup(backupPath); err != nil {
-			logger.Warnf("Failed to cleanup backup file: %v", err)
+		if err := engine.CleanupBackup(backupPath); err != nil {
+			dbLogger.Warnf("Failed to cleanup backup file: %v", err)
 		}
 
-		logger.Infof("Successfully backed up database %d to: %s", i+1, s3Key)
-		successfulBackups++
+		dbLogger.Infof("Successfully backed up database %d to: %s", i+1, s3Key)
+		result.Location = s3Key
+		summary.Add(finishResult(result, nil))
 	}
 
 	// Clean up old backups
-	logger.Info("Cleaning up old backups...")
-	if err := s3Manager.DeleteOldBackups(backupConfig.BackupPrefix, backupConfig.RetentionDays); err != nil {
-		logger.Errorf("Failed to cleanup old backups: %v", err)
+	cleanupLogger := runLogger.WithField("operation", "retention")
+	cleanupLogger.Info("Cleaning up old backups...")
+	cleaned := map[lambdaTarget]bool{}
+	hostname, _ := os.Hostname()
+	claim := storage.InstanceClaim{Instance: cfg.Backup.InstanceName(), Host: hostname, RunID: summary.RunID, LastRun: time.Now().UTC()}
+	cleanupTargets := []lambdaTarget{{s3Manager: s3Manager, prefix: backupConfig.BackupPrefix}}
+	for _, db := range cfg.Databases {
+		if target, err := targetFor(db.Database); err == nil {
+			cleanupTargets = append(cleanupTargets, target)
+		}
+	}
+	for _, target := range cleanupTargets {
+		if cleaned[target] {
+			continue
+		}
+		cleaned[target] = true
+		// Record this instance, spotting other deployments writing to the same prefix
+		storage.CheckInstance(target.s3Manager, target.prefix, claim, runLogger)
+		deleted, err := target.s3Manager.WithLogger(cleanupLogger).DeleteOldBackups(target.prefix, backupConfig.RetentionDays)
+		summary.ObjectsDeleted += deleted
+		if err != nil {
+			cleanupLogger.Errorf("Failed to cleanup old backups in %s: %v", target.s3Manager.Location(), err)
+		}
+		if hours := cfg.AWS.AbortIncompleteUploadsHours; hours > 0 {
+			if _, err := target.s3Manager.WithLogger(cleanupLogger).(*s3.S3Manager).AbortIncompleteUploads(target.prefix, time.Duration(hours)*time.Hour, false); err != nil {
+				cleanupLogger.Errorf("Failed to abort incomplete uploads in %s: %v", target.s3Manager.Location(), err)
+			}
+		}
 	}
 
-	duration := time.Since(startTime)
-	logger.Infof("Backup operation completed in %v. Successful: %d, Failed: %d", duration, successfulBackups, failedBackups)
+	summary.FinishedAt = time.Now()
+	duration := summary.FinishedAt.Sub(summary.StartedAt)
+	runLogger.Infof("Backup operation completed in %v. Successful: %d, Failed: %d, Skipped: %d", duration, summary.Successful, summary.Failed, summary.Skipped)
 
-	if failedBackups > 0 {
-		return fmt.Errorf("backup operation completed with %d failures", failedBackups)
+	if summary.Failed > 0 {
+		return summary, fmt.Errorf("backup operation completed with %d failures", summary.Failed)
 	}
+	if summary.Skipped > 0 {
+		return summary, fmt.Errorf("backup operation skipped %d databases after exceeding its run budget", summary.Skipped)
+	}
+
+	return summary, nil
+}
 
-	return nil
+// finishResult completes a database result with its outcome and duration
+func finishResult(result status.DatabaseResult, err error) status.DatabaseResult {
+	result.FinishedAt = time.Now()
+	result.DurationSeconds = result.FinishedAt.Sub(result.StartedAt).Seconds()
+	if err != nil {
+		result.Status = status.ResultFailed
+		result.Error = err.Error()
+	} else {
+		result.Status = status.ResultSuccess
+	}
+	return result
 }
 
 func main() {
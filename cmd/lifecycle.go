@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/s3"
+	"db-backuper/internal/storage"
+)
+
+// lifecycleBucket collects the prefixes given lifecycle rules in one bucket
+type lifecycleBucket struct {
+	manager *s3.S3Manager
+	// prefixes are the backup directories of the databases stored in the bucket
+	prefixes []string
+	// managed are the backup prefixes whose managed rules are replaced
+	managed []string
+}
+
+// applyLifecycleCommand creates, updates or removes the bucket lifecycle rules
+// of every backup bucket in use so they match aws.lifecycle
+func applyLifecycleCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("apply-lifecycle", "[-dry-run]")
+	dryRun := fs.Bool("dry-run", false, "Print the rule changes without applying them")
+	return fs, func() error {
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+		if !cfg.IsAWSStorage() {
+			return fmt.Errorf("apply-lifecycle only applies to AWS S3 storage")
+		}
+
+		storageManager, err := newStorageManager(cfg, logger)
+		if err != nil {
+			return err
+		}
+		changed, err := applyLifecycle(cfg, newStorageTargets(cfg, storageManager, logger), *dryRun)
+		if err != nil {
+			return err
+		}
+
+		if *dryRun {
+			fmt.Printf("%d lifecycle rule(s) would be changed\n", changed)
+		} else {
+			fmt.Printf("Changed %d lifecycle rule(s)\n", changed)
+		}
+		return nil
+	}
+}
+
+// applyLifecycle makes the managed lifecycle rules of every bucket in use
+// match aws.lifecycle, printing the change made to each rule, and returns
+// how many rules changed
+func applyLifecycle(cfg *config.Config, resolver *storageTargets, dryRun bool) (int, error) {
+	targets, err := resolver.All()
+	if err != nil {
+		return 0, err
+	}
+
+	var buckets []*lifecycleBucket
+	byLocation := make(map[string]*lifecycleBucket)
+	for _, target := range targets {
+		manager := target.storage.(*s3.S3Manager)
+		// Bucket expiration cannot tell held backups apart, so it is refused
+		// while any backup is held
+		if cfg.AWS.Lifecycle.ExpirationDays > 0 {
+			holds, err := storage.LoadHolds(manager, target.prefix)
+			if err != nil {
+				return 0, err
+			}
+			if len(holds) > 0 {
+				return 0, fmt.Errorf("%d backup(s) under %s/%s are held, and aws.lifecycle.expiration_days would delete them regardless; release the holds or remove expiration_days", len(holds), manager.Location(), target.prefix)
+			}
+		}
+		bucket := byLocation[manager.Location()]
+		if bucket == nil {
+			bucket = &lifecycleBucket{manager: manager}
+			byLocation[manager.Location()] = bucket
+			buckets = append(buckets, bucket)
+		}
+		bucket.managed = append(bucket.managed, target.prefix+"/")
+	}
+	// Rules cover the directory of each database rather than the whole
+	// prefix, which also holds the holds file and the restore point catalog
+	for _, db := range cfg.Databases {
+		target, err := resolver.For(db.Database)
+		if err != nil {
+			return 0, err
+		}
+		bucket := byLocation[target.storage.(*s3.S3Manager).Location()]
+		bucket.prefixes = append(bucket.prefixes, path.Join(target.prefix, db.Database)+"/")
+	}
+
+	changed := 0
+	for _, bucket := range buckets {
+		rules := s3.LifecycleRules(bucket.prefixes, cfg.AWS.Lifecycle)
+		changes, err := bucket.manager.ApplyLifecycle(rules, bucket.managed, dryRun)
+		if err != nil {
+			return 0, err
+		}
+		for _, change := range changes {
+			fmt.Printf("%-9s %s/%s\n", change.Action, bucket.manager.Location(), change.Prefix)
+			if change.Action != s3.LifecycleUnchanged {
+				changed++
+			}
+		}
+	}
+	return changed, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"db-backuper/internal/storage"
+)
+
+// listCommand lists the stored backups, one page at a time
+func listCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("list", "[-database <name>] [-since <YYYY-MM-DD>] [-until <YYYY-MM-DD>] [-output table|json|yaml]")
+	database := fs.String("database", "", "Only list backups of this database")
+	since := fs.String("since", "", "Only list backups taken on or after this date (YYYY-MM-DD)")
+	until := fs.String("until", "", "Only list backups taken on or before this date (YYYY-MM-DD)")
+	output := addOutputFlag(fs)
+	return fs, func() error {
+		var query storage.ListQuery
+		query.Database = *database
+		for _, bound := range []struct {
+			flag  string
+			value string
+			date  *time.Time
+		}{{"-since", *since, &query.Since}, {"-until", *until, &query.Until}} {
+			if bound.value == "" {
+				continue
+			}
+			date, err := parseDate(bound.flag, bound.value)
+			if err != nil {
+				fs.Usage()
+				return err
+			}
+			*bound.date = date
+		}
+
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+
+		storageManager, err := newStorageManager(cfg, logger)
+		if err != nil {
+			return err
+		}
+		resolver := newStorageTargets(cfg, storageManager, logger)
+
+		// A single database is listed from its own storage, otherwise every target is
+		var targets []storageTarget
+		if *database != "" {
+			target, err := resolver.For(*database)
+			if err != nil {
+				return err
+			}
+			targets = []storageTarget{target}
+		} else if targets, err = resolver.All(); err != nil {
+			return err
+		}
+
+		// JSON and YAML are printed once the listing is complete
+		var entries []storage.Entry
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		if output.table() {
+			fmt.Fprintln(w, "DATABASE\tDATE\tSIZE\tKEY")
+		}
+		for _, target := range targets {
+			query.Prefix = target.prefix
+			query.PageToken = ""
+			for {
+				page, err := target.storage.ListBackups(query)
+				if err != nil {
+					return err
+				}
+				if !output.table() {
+					entries = append(entries, page.Entries...)
+				} else {
+					for _, entry := range page.Entries {
+						fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", entry.Database, entry.Date.Format(storage.DateLayout), entry.Size, entry.Key)
+					}
+					// Flush each page so large listings show up as they are fetched
+					if err := w.Flush(); err != nil {
+						return err
+					}
+				}
+				if page.NextPageToken == "" {
+					break
+				}
+				query.PageToken = page.NextPageToken
+			}
+		}
+		if output.table() {
+			return nil
+		}
+		return printResults(output, entries, nil)
+	}
+}
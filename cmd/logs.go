@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"db-backuper/internal/joblog"
+	"db-backuper/internal/jobs"
+	"db-backuper/internal/status"
+)
+
+// logsCommand prints the logs of a run from the HTTP server of a running
+// scheduler, optionally following them until the run finishes
+func logsCommand() (*flag.FlagSet, func() error) {
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	setUsage(fs, "logs", "[-server <url>] [-job <job-id>] [-follow]")
+	server := fs.String("server", "http://localhost:8080", "URL of the scheduler's -listen address")
+	jobID := fs.String("job", "", "Job whose logs to print (default: the most recent job)")
+	follow := fs.Bool("follow", false, "Keep printing lines until the run finishes")
+	return fs, func() error {
+		base := strings.TrimSuffix(*server, "/")
+
+		if *jobID == "" {
+			var list []jobs.Job
+			if err := callJobs(http.MethodGet, base+"/jobs", nil, &list); err != nil {
+				return err
+			}
+			if len(list) == 0 {
+				return errors.New("the scheduler has not run any job yet")
+			}
+			*jobID = list[0].ID
+		}
+		return streamLogs(base, *jobID, *follow)
+	}
+}
+
+// streamLogs prints the log stream of a run. When following, it returns once
+// the run has finished, with an error unless the run succeeded.
+func streamLogs(base, id string, follow bool) error {
+	resp, err := apiRequest(http.MethodGet, fmt.Sprintf("%s/jobs/%s/logs?follow=%t", base, url.PathEscape(id), follow), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to stream logs of %s: %s: %s", id, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var event, data string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "":
+			switch event {
+			case "log":
+				fmt.Println(formatLogLine(data))
+			case "end":
+				var job joblog.Job
+				if err := json.Unmarshal([]byte(data), &job); err != nil {
+					return fmt.Errorf("failed to parse end of run: %w", err)
+				}
+				fmt.Printf("Run %s finished: %s\n", job.ID, job.Result)
+				if job.Result != status.ResultSuccess {
+					return fmt.Errorf("run %s %s", job.ID, job.Result)
+				}
+				return nil
+			}
+			event, data = "", ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("log stream of %s broke off: %w", id, err)
+	}
+	if follow {
+		return fmt.Errorf("log stream of %s ended before the run finished", id)
+	}
+	return nil
+}
+
+// formatLogLine renders a JSON log entry as "time level message key=value...",
+// leaving lines that are not JSON as they are
+func formatLogLine(data string) string {
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(data), &entry); err != nil {
+		return data
+	}
+	timestamp, _ := entry["time"].(string)
+	if t, err := time.Parse(time.RFC3339, timestamp); err == nil {
+		timestamp = t.Local().Format("2006-01-02 15:04:05")
+	}
+	level, _ := entry["level"].(string)
+	message, _ := entry["msg"].(string)
+
+	var fields []string
+	for key, value := range entry {
+		switch key {
+		case "time", "level", "msg", "run_id":
+			continue
+		}
+		fields = append(fields, fmt.Sprintf("%s=%v", key, value))
+	}
+	sort.Strings(fields)
+	line := fmt.Sprintf("%s %-5s %s", timestamp, strings.ToUpper(level), message)
+	if len(fields) > 0 {
+		line += " " + strings.Join(fields, " ")
+	}
+	return line
+}
@@ -1,127 +1,484 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"strings"
-	"syscall"
+	"sync"
 	"time"
 
+	"db-backuper/internal/audit"
 	"db-backuper/internal/backup"
+	"db-backuper/internal/compliance"
 	"db-backuper/internal/config"
+	"db-backuper/internal/control"
+	"db-backuper/internal/joblog"
+	"db-backuper/internal/jobs"
+	"db-backuper/internal/metrics"
+	"db-backuper/internal/notify"
+	"db-backuper/internal/pause"
+	"db-backuper/internal/plugin"
+	"db-backuper/internal/rclone"
+	"db-backuper/internal/redact"
 	"db-backuper/internal/restore"
+	"db-backuper/internal/runid"
+	"db-backuper/internal/runstate"
 	"db-backuper/internal/s3"
+	"db-backuper/internal/sla"
+	"db-backuper/internal/status"
 	"db-backuper/internal/storage"
+	"db-backuper/internal/usage"
+	"db-backuper/internal/web"
 
 	"github.com/robfig/cron/v3"
 	"github.com/sirupsen/logrus"
 )
 
 func main() {
-	// Parse command line flags
-	configPath := flag.String("config", "appsettings.json", "Path to configuration file")
-	runOnce := flag.Bool("once", false, "Run backup once and exit")
-	importBackup := flag.Bool("import", false, "Import backup to target database and exit")
-	flag.Parse()
+	args := os.Args[1:]
 
+	// Without a subcommand the service runs from flags, as it did before
+	// subcommands existed; a bare invocation runs the scheduler
+	if len(args) == 0 || (strings.HasPrefix(args[0], "-") && !slices.Contains(helpFlags, args[0])) {
+		runLegacy(args)
+		return
+	}
+	execute(args)
+}
+
+// serviceOptions selects what the backup service does and with which configuration
+type serviceOptions struct {
+	configPath    string
+	configDir     string
+	profile       string
+	envFile       string
+	strict        bool
+	once          bool
+	summaryFile   string
+	importBackup  bool
+	importPath    string
+	controlSocket string
+	listen        string
+	databases     []string
+	groups        []string
+}
+
+// runLegacy runs the service from the flags accepted before subcommands existed
+func runLegacy(args []string) {
+	fs := flag.NewFlagSet("db-backuper", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to configuration file (default: appsettings.json if present, otherwise environment variables only)")
+	configDir := fs.String("config-dir", "", "Directory of per-tenant configuration files (*.json) run together by one scheduler")
+	profile := fs.String("profile", "", "Configuration profile to apply (default: $"+config.ProfileEnvVar+")")
+	envFile := fs.String("env-file", "", "Load environment variables from a .env file before applying overrides")
+	strict := fs.Bool("strict", false, "Reject unknown fields and mistyped values in the configuration file")
+	runOnce := fs.Bool("once", false, "Run backup once and exit (deprecated: use the backup command)")
+	summaryFile := fs.String("summary-file", "", "Write the results of a -once run as JSON to this file")
+	importBackup := fs.Bool("import", false, "Import backup to target database and exit (deprecated: use the restore command)")
+	controlSocket := fs.String("control-socket", "", "Path of a Unix socket accepting on-demand backup commands (scheduler mode)")
+	listenAddr := fs.String("listen", "", "Address of an HTTP server serving status badges, the job queue and run logs, such as :8080 (scheduler mode)")
+	var databaseNames stringSliceFlag
+	fs.Var(&databaseNames, "database", "Only back up the named database (repeatable)")
+	var groupNames stringSliceFlag
+	fs.Var(&groupNames, "group", "Only back up the databases of the named backup group (repeatable)")
+	fs.Parse(args)
+
+	switch {
+	case *importBackup:
+		fmt.Fprintln(os.Stderr, "Warning: -import is deprecated, use: db-backuper restore")
+	case *runOnce:
+		fmt.Fprintln(os.Stderr, "Warning: -once is deprecated, use: db-backuper backup")
+	}
+
+	runService(serviceOptions{
+		configPath:    *configPath,
+		configDir:     *configDir,
+		profile:       *profile,
+		envFile:       *envFile,
+		strict:        *strict,
+		once:          *runOnce,
+		summaryFile:   *summaryFile,
+		importBackup:  *importBackup,
+		controlSocket: *controlSocket,
+		listen:        *listenAddr,
+		databases:     databaseNames,
+		groups:        groupNames,
+	})
+}
+
+// runService runs a one-time backup, an import or the scheduler, exiting on failure
+func runService(opts serviceOptions) {
 	// Setup logger first (we need it for error messages)
 	logger := logrus.New()
 	logger.SetLevel(logrus.InfoLevel)
+	redactor := redact.New()
+	redact.Install(logger, redactor)
+
+	// Variables from the .env file feed the overrides below but never replace exported ones
+	if opts.envFile != "" {
+		if err := config.LoadDotEnv(opts.envFile); err != nil {
+			logger.Fatalf("Failed to load env file: %v", err)
+		}
+	}
 
 	// Load configuration based on operation type
 	var cfg *config.Config
 	var err error
+	loadOptions := config.LoadOptions{Profile: opts.profile, Strict: opts.strict}
+
+	if opts.configDir != "" {
+		if opts.configPath != "" || opts.importBackup || len(opts.databases) > 0 || len(opts.groups) > 0 || opts.controlSocket != "" || opts.listen != "" {
+			logger.Fatal("-config-dir cannot be combined with -config, -import, -database, -group, -control-socket or -listen")
+		}
+		runTenants(opts.configDir, loadOptions, opts.once, opts.summaryFile, logger)
+		return
+	}
 
-	if *importBackup {
+	if opts.importBackup {
+		// The backup file given on the command line wins like any other environment override
+		if opts.importPath != "" {
+			os.Setenv("IMPORT_BACKUP_PATH", opts.importPath)
+		}
 		// For import operations, use special loading that allows empty databases
-		cfg, err = config.LoadConfigForImport(*configPath)
+		cfg, err = config.LoadConfigForImport(opts.configPath, loadOptions)
 		if err != nil {
 			logger.Fatalf("Failed to load import configuration: %v", err)
 		}
 		logger.Info("Starting PostgreSQL import service")
 	} else {
 		// For backup operations, use standard loading
-		cfg, err = config.LoadConfig(*configPath)
+		cfg, err = config.LoadConfig(opts.configPath, loadOptions)
 		if err != nil {
 			logger.Fatalf("Failed to load configuration: %v", err)
 		}
 		logger.Info("Starting PostgreSQL backup service")
 	}
 
-	// Setup logger with configuration
+	// Setup logger with configuration, masking every configured credential
 	logger = setupLogger(cfg.Logging)
+	redactor.AddSecrets(cfg.Secrets()...)
+	redact.Install(logger, redactor)
+	if cfg.Profile != "" {
+		logger.Infof("Using configuration profile %s", cfg.Profile)
+	}
+	if err := compliance.Apply(cfg, logger); err != nil {
+		logger.Fatalf("Refusing to run: %v", err)
+	}
 
 	// Handle import operation
-	if *importBackup {
+	if opts.importBackup {
 		postgresImport := restore.NewPostgresImport(&cfg.Import, logger)
+		postgresImport.SetAuditLog(newAuditLog(cfg, logger))
+		startedAt := time.Now()
 		if err := postgresImport.ImportBackup(); err != nil {
 			logger.Fatalf("Import failed: %v", err)
 		}
 		logger.Info("Import completed successfully")
+		recordImport(cfg, logger, startedAt)
 		return
 	}
 
-	// Initialize backup components
-	postgresBackups := make([]*backup.PostgresBackup, len(cfg.Databases))
-	for i, dbConfig := range cfg.Databases {
-		postgresBackups[i] = backup.NewPostgresBackup(&dbConfig, logger)
+	// Restrict the run to the databases selected on the command line
+	selected, err := selectDatabases(cfg, opts.databases, opts.groups)
+	if err != nil {
+		logger.Fatalf("Invalid database selection: %v", err)
+	}
+	if len(selected) > 0 {
+		logger.Infof("Backing up selected databases only: %s", strings.Join(selected, ", "))
 	}
 
-	var storageManager interface{}
-	if cfg.IsLocalStorage() {
-		localStorage, err := storage.NewLocalStorage(&cfg.Local, logger)
-		if err != nil {
-			logger.Fatalf("Failed to initialize local storage: %v", err)
+	// Initialize backup components, highest priority first
+	var engines []backup.Engine
+	for _, dbConfig := range cfg.DatabasesByPriority() {
+		if !dbConfig.IsEnabled() {
+			logger.Infof("Skipping disabled database %s", dbConfig.Database)
+			continue
 		}
-		storageManager = localStorage
-		logger.Info("Using local storage for backups")
-	} else if cfg.IsAWSStorage() {
-		s3Manager, err := s3.NewS3Manager(&cfg.AWS, logger)
+		engine, err := backup.NewEngine(&dbConfig, logger)
 		if err != nil {
-			logger.Fatalf("Failed to initialize S3 manager: %v", err)
+			logger.Fatalf("Failed to initialize backup for database %s: %v", dbConfig.Database, err)
+		}
+		engines = append(engines, engine)
+	}
+	if len(engines) == 0 {
+		logger.Fatal("Every selected database is disabled")
+	}
+
+	storageManager, err := newStorageManager(cfg, logger)
+	if err != nil {
+		logger.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	// Finish uploads cut short when a previous process died mid-upload
+	if sm, ok := storageManager.(*s3.S3Manager); ok {
+		if err := sm.ResumeUploads(); err != nil {
+			logger.Warnf("Failed to resume interrupted uploads: %v", err)
 		}
-		storageManager = s3Manager
-		logger.Info("Using AWS S3 for backups")
 	}
 
 	// Test connections
-	if err := testConnections(postgresBackups, storageManager, logger); err != nil {
+	if err := testConnections(engines, storageManager, logger); err != nil {
 		logger.Fatalf("Connection test failed: %v", err)
 	}
 
-	if *runOnce {
-		// Run backup once and exit
-		if err := performBackup(postgresBackups, storageManager, &cfg.Backup, logger); err != nil {
+	// Publish per-database results after every run
+	var statusS3 *s3.S3Manager
+	if sm, ok := storageManager.(*s3.S3Manager); ok {
+		statusS3 = sm
+	}
+	statusWriter := status.NewWriter(&cfg.Status, statusS3, logger)
+
+	// Keep scheduler and alerting state in the state directory so restarts do not reset it
+	runState, err := runstate.Open(filepath.Join(cfg.Backup.StateDirectory(), runstate.FileName))
+	if err != nil {
+		logger.Fatalf("Failed to load scheduler state: %v", err)
+	}
+	if cfg.Notifications.StatePath == "" {
+		cfg.Notifications.StatePath = filepath.Join(cfg.Backup.StateDirectory(), "notifications.json")
+	}
+	notifier, err := notify.NewNotifier(&cfg.Notifications, logger)
+	if err != nil {
+		logger.Fatalf("Failed to initialize notifications: %v", err)
+	}
+	publisher, err := metrics.NewPublisher(cfg, logger)
+	if err != nil {
+		logger.Fatalf("Failed to initialize metrics: %v", err)
+	}
+	pauses := pauseFile(cfg)
+	var webServer *web.Server
+	var slaMonitor *sla.Monitor
+	var lastSummary *status.RunSummary
+	// Jobs running at once publish their results one at a time
+	var publishMu sync.Mutex
+	runBackup := func(ctx context.Context, runID string, runEngines []backup.Engine) (*status.RunSummary, error) {
+		summary, err := performBackup(ctx, runID, runEngines, storageManager, cfg, logger)
+		publishMu.Lock()
+		defer publishMu.Unlock()
+		lastSummary = summary
+		if stateErr := runState.RecordRun(summary); stateErr != nil {
+			logger.Warnf("Failed to save scheduler state: %v", stateErr)
+		}
+		if statusErr := statusWriter.Update(summary); statusErr != nil {
+			logger.Warnf("Failed to update status file: %v", statusErr)
+		}
+		if webServer != nil {
+			webServer.Update(summary)
+		}
+		if slaMonitor != nil {
+			slaMonitor.Update(summary)
+		}
+		if notifyErr := notifier.Notify(summary); notifyErr != nil {
+			logger.Warnf("Failed to send notifications: %v", notifyErr)
+		}
+		if metricsErr := publisher.Publish(summary); metricsErr != nil {
+			logger.Warnf("Failed to publish metrics: %v", metricsErr)
+		}
+		return summary, err
+	}
+
+	if opts.once {
+		// Run backup once, print its results and exit
+		var err error
+		if runEngines := backupEngines(engines, nil, "one-time", pauses, logger); len(runEngines) == 0 {
+			logger.Info("Skipping one-time backup: every database is paused")
+		} else {
+			_, err = runBackup(context.Background(), runid.New(), runEngines)
+		}
+		if lastSummary != nil {
+			result := status.NewRunResult("", lastSummary)
+			status.PrintRunResult(os.Stdout, result)
+			if opts.summaryFile != "" {
+				if summaryErr := status.WriteSummaryFile(opts.summaryFile, result); summaryErr != nil {
+					logger.Errorf("Failed to write run summary: %v", summaryErr)
+				}
+			}
+		}
+		if err != nil {
 			logger.Fatalf("Backup failed: %v", err)
 		}
 		logger.Info("Backup completed successfully")
 		return
 	}
 
-	// Setup scheduled backups
-	c := cron.New()
-	_, err = c.AddFunc(cfg.Backup.Schedule, func() {
-		if err := performBackup(postgresBackups, storageManager, &cfg.Backup, logger); err != nil {
-			logger.Errorf("Scheduled backup failed: %v", err)
+	// Queue scheduled and on-demand backups so runs of a database never
+	// overlap, picking up the jobs queued before a restart
+	queue, err := newBackupQueue(cfg, engines, pauses, runBackup, logger)
+	if err != nil {
+		logger.Fatalf("Failed to load job queue: %v", err)
+	}
+	queue.Handle(jobs.KindRestore, restoreKind(cfg, storageManager, logger))
+
+	// Results of earlier runs, from the scheduler state or, before there is
+	// any, the published status file
+	previous := runState.Report()
+	if previous == nil {
+		previous = statusWriter.Load()
+	}
+
+	// Serve status badges, starting from the results of earlier runs, and
+	// the job queue, streaming the logs of runs as they are written
+	if opts.listen != "" {
+		jobLogs := joblog.NewHub(redactor)
+		logger.AddHook(jobLogs)
+		queue.Observe(func(job jobs.Job) {
+			switch {
+			case !job.Finished():
+				jobLogs.Start(job.ID)
+			case job.State == jobs.StateCompleted:
+				jobLogs.Finish(job.ID, job.Result)
+			default:
+				jobLogs.Finish(job.ID, job.State)
+			}
+		})
+		webServer = web.NewServer(opts.listen, previous, logger)
+		webServer.SetJobLogs(jobLogs)
+		webServer.SetJobQueue(queue, cfg.API)
+		webServer.SetAuditLog(newAuditLog(cfg, logger))
+		usageTargets := newStorageTargets(cfg, storageManager, logger)
+		webServer.SetUsage(func() (*usage.Report, error) {
+			return usageReport(cfg, usageTargets, "")
+		})
+		if err := webServer.Start(); err != nil {
+			logger.Fatalf("Failed to start HTTP server: %v", err)
 		}
-	})
+		defer webServer.Stop()
+	}
+
+	// Evaluate backup freshness SLAs continuously, even if no run ever completes
+	if monitor := sla.NewMonitor(cfg, previous, notifier, publisher, logger); monitor.Enabled() {
+		slaMonitor = monitor
+		slaMonitor.Persist(runState)
+		slaMonitor.SkipPaused(pauses)
+		slaMonitor.Start()
+		defer slaMonitor.Stop()
+		logger.Infof("Checking backup SLAs every %s", sla.CheckInterval)
+	}
+
+	// Setup scheduled backups, recording the next run so that a run missed
+	// while the service is stopped is noticed on the next start
+	schedule, err := cron.ParseStandard(cfg.Backup.Schedule)
 	if err != nil {
 		logger.Fatalf("Failed to schedule backup: %v", err)
 	}
+	missedAt, missed := runState.Missed(time.Now())
+	if err := runState.SetNextRun(schedule.Next(time.Now())); err != nil {
+		logger.Warnf("Failed to save scheduler state: %v", err)
+	}
+	c := cron.New()
+	c.Schedule(schedule, cron.FuncJob(func() {
+		if err := runState.SetNextRun(schedule.Next(time.Now())); err != nil {
+			logger.Warnf("Failed to save scheduler state: %v", err)
+		}
+		queueBackup(queue, "scheduled", logger)
+	}))
 
 	logger.Infof("Scheduled backup with cron expression: %s", cfg.Backup.Schedule)
 	c.Start()
 
-	// Wait for interrupt signal
+	// Store the changes of databases with change capture between their dumps
+	stopChangeCapture := startChangeCapture(cfg, storageManager, logger)
+
+	// Listen for on-demand backup commands
+	if opts.controlSocket != "" {
+		socketServer := control.NewSocketServer(opts.controlSocket, func(trigger string) bool {
+			return queueBackup(queue, trigger, logger)
+		}, logger)
+		socketServer.SetPauses(pauses)
+		if err := socketServer.Start(); err != nil {
+			logger.Fatalf("Failed to start control socket: %v", err)
+		}
+		defer socketServer.Stop()
+	}
+
+	// Run the jobs queued before a restart and those queued from now on
+	queue.Start()
+
+	if missed {
+		if cfg.Backup.CatchUp {
+			logger.Warnf("Missed the backup scheduled at %s while the service was stopped, catching up", missedAt.Format(time.RFC3339))
+			queueBackup(queue, "catch-up", logger)
+		} else {
+			logger.Warnf("Missed the backup scheduled at %s while the service was stopped; set backup.catch_up to run missed backups on start", missedAt.Format(time.RFC3339))
+		}
+	}
+
+	notifyReady(c, logger)
+
+	// Wait for interrupt signal, triggering an immediate backup on SIGUSR1
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, serviceSignals...)
+	for sig := range sigChan {
+		if isBackupSignal(sig) {
+			logger.Info("Received SIGUSR1, triggering on-demand backup")
+			queueBackup(queue, "signal", logger)
+			continue
+		}
+		break
+	}
 
 	logger.Info("Shutting down backup service")
+	notifyStopping(logger)
 	c.Stop()
+	stopChangeCapture()
+	queue.Stop()
+}
+
+// unpausedEngines returns the engines of the databases without a maintenance
+// pause, logging the ones skipped
+func unpausedEngines(engines []backup.Engine, pauses *pause.File, logger *logrus.Logger) []backup.Engine {
+	active, err := pauses.Active(time.Now())
+	if err != nil {
+		logger.Warnf("Ignoring maintenance pauses: %v", err)
+		return engines
+	}
+	var unpaused []backup.Engine
+	for _, engine := range engines {
+		if p, ok := pause.Find(active, engine.DatabaseName()); ok {
+			logger.Infof("Skipping %s: %s", engine.DatabaseName(), p)
+			continue
+		}
+		unpaused = append(unpaused, engine)
+	}
+	return unpaused
+}
+
+// selectDatabases restricts the configuration to the named databases and the
+// members of the named groups, returning the selected names
+func selectDatabases(cfg *config.Config, databaseNames, groupNames []string) ([]string, error) {
+	selected := slices.Clone(databaseNames)
+	for _, group := range groupNames {
+		members, err := cfg.GroupDatabases(group)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			if !slices.Contains(selected, member) {
+				selected = append(selected, member)
+			}
+		}
+	}
+	if err := cfg.FilterDatabases(selected); err != nil {
+		return nil, err
+	}
+	return selected, nil
+}
+
+// stringSliceFlag collects the values of a repeatable command line flag
+type stringSliceFlag []string
+
+// String returns the collected values as a comma separated list
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+// Set appends a value each time the flag is given
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
 }
 
 // setupLogger configures the logger based on configuration
@@ -148,34 +505,25 @@ func setupLogger(loggingConfig config.LoggingConfig) *logrus.Logger {
 }
 
 // testConnections tests database and storage connections
-func testConnections(postgresBackups []*backup.PostgresBackup, storageManager interface{}, logger *logrus.Logger) error {
+func testConnections(engines []backup.Engine, storageManager storage.Backend, logger *logrus.Logger) error {
 	logger.Info("Testing connections...")
 
 	// Test storage connection
-	switch sm := storageManager.(type) {
-	case *s3.S3Manager:
-		if err := sm.TestConnection(); err != nil {
-			return fmt.Errorf("S3 connection test failed: %w", err)
-		}
-	case *storage.LocalStorage:
-		if err := sm.TestConnection(); err != nil {
-			return fmt.Errorf("local storage connection test failed: %w", err)
-		}
-	default:
-		return fmt.Errorf("unknown storage manager type")
+	if err := storageManager.TestConnection(); err != nil {
+		return fmt.Errorf("storage connection test failed for %s: %w", storageManager.Location(), err)
 	}
 
 	// Test database connections by attempting to create a backup for each database
 	logger.Info("Testing database connections...")
-	for i, postgresBackup := range postgresBackups {
+	for i, engine := range engines {
 		logger.Infof("Testing connection for database %d...", i+1)
-		backupPath, err := postgresBackup.CreateBackup()
+		backupPath, err := engine.CreateBackup()
 		if err != nil {
 			return fmt.Errorf("database %d connection test failed: %w", i+1, err)
 		}
 
 		// Cleanup test backup
-		if err := postgresBackup.CleanupBackup(backupPath); err != nil {
+		if err := engine.CleanupBackup(backupPath); err != nil {
 			logger.Warnf("Failed to cleanup test backup for database %d: %v", i+1, err)
 		}
 	}
@@ -184,92 +532,214 @@ func testConnections(postgresBackups []*backup.PostgresBackup, storageManager in
 	return nil
 }
 
-// performBackup performs a complete backup operation for all databases
-func performBackup(postgresBackups []*backup.PostgresBackup, storageManager interface{}, backupConfig *config.BackupConfig, logger *logrus.Logger) error {
-	startTime := time.Now()
-	logger.Infof("Starting backup operation for %d databases", len(postgresBackups))
-
-	var successfulBackups int
-	var failedBackups int
-
-	// Backup each database
-	for i, postgresBackup := range postgresBackups {
-		logger.Infof("Backing up database %d of %d", i+1, len(postgresBackups))
+// performBackup performs a complete backup operation for all databases as
+// run runID. Databases not started when ctx is done are skipped.
+func performBackup(ctx context.Context, runID string, engines []backup.Engine, storageManager storage.Backend, cfg *config.Config, logger *logrus.Logger) (*status.RunSummary, error) {
+	backupConfig := &cfg.Backup
+	summary := &status.RunSummary{
+		RunID:     runID,
+		StartedAt: time.Now(),
+		Storage:   storageManager.Location(),
+		Disabled:  cfg.DisabledDatabases(),
+	}
 
-		// Create database backup
-		backupPath, err := postgresBackup.CreateBackup()
-		if err != nil {
-			logger.Errorf("Failed to create backup for database %d: %v", i+1, err)
-			failedBackups++
+	// Tag every log line of this cycle so JSON logs can be grouped per run
+	runLogger := logger.WithFields(logrus.Fields{
+		"run_id":    summary.RunID,
+		"operation": "backup",
+		"storage":   summary.Storage,
+	})
+	runLogger.Infof("Starting backup operation for %d databases", len(engines))
+
+	// Pin shared snapshots before any group member is dumped
+	releaseSnapshots, pinErrors := backup.PinGroups(cfg.Groups, engines, runLogger)
+	defer releaseSnapshots()
+
+	targets := newStorageTargets(cfg, storageManager, logger)
+	budget := backupConfig.MaxRunDuration()
+
+	// Backup each database, skipping the rest once the run budget is spent
+	// or the run is cancelled
+	for i, e := range engines {
+		dbLogger := runLogger.WithField("database", e.DatabaseName())
+		reason := ""
+		if ctx.Err() != nil {
+			reason = "run cancelled"
+		} else if budget > 0 && time.Since(summary.StartedAt) >= budget {
+			reason = fmt.Sprintf("run budget of %s exhausted", budget)
+		}
+		if reason != "" {
+			dbLogger.Warnf("Skipping database %d of %d: %s", i+1, len(engines), reason)
+			result := status.SkippedResult(e.DatabaseName(), reason)
+			result.RunID = summary.RunID
+			summary.Add(result)
 			continue
 		}
+		groupName := ""
+		if group := cfg.GroupOf(e.DatabaseName()); group != nil {
+			groupName = group.Name
+			dbLogger = dbLogger.WithField("group", groupName)
+		}
+		engine := e.WithLogger(dbLogger)
 
-		// Get database name from the backup path (it's in the filename)
-		// Format: database-name_YYYY-MM-DD_HH-MM-SS.sql
-		filename := filepath.Base(backupPath)
-		databaseName := strings.Split(filename, "_")[0]
-
-		// Save backup to storage
-		var finalPath string
-		switch sm := storageManager.(type) {
-		case *s3.S3Manager:
-			s3Key, err := sm.UploadBackup(backupPath, backupConfig.BackupPrefix, databaseName)
-			if err != nil {
-				// Cleanup local backup file on upload failure
-				if cleanupErr := postgresBackup.CleanupBackup(backupPath); cleanupErr != nil {
-					logger.Warnf("Failed to cleanup backup file after upload failure: %v", cleanupErr)
-				}
-				logger.Errorf("Failed to upload backup for database %d to S3: %v", i+1, err)
-				failedBackups++
-				continue
-			}
-			finalPath = s3Key
-		case *storage.LocalStorage:
-			localPath, err := sm.SaveBackup(backupPath, backupConfig.BackupPrefix, databaseName)
-			if err != nil {
-				// Cleanup local backup file on save failure
-				if cleanupErr := postgresBackup.CleanupBackup(backupPath); cleanupErr != nil {
-					logger.Warnf("Failed to cleanup backup file after save failure: %v", cleanupErr)
-				}
-				logger.Errorf("Failed to save backup for database %d to local storage: %v", i+1, err)
-				failedBackups++
-				continue
+		dbLogger.Infof("Backing up database %d of %d", i+1, len(engines))
+		var result status.DatabaseResult
+		target, err := targets.For(e.DatabaseName())
+		if err == nil {
+			err = pinErrors[e.DatabaseName()]
+		}
+		if err != nil {
+			now := time.Now()
+			result = status.DatabaseResult{
+				Database:   e.DatabaseName(),
+				Status:     status.ResultFailed,
+				StartedAt:  now,
+				FinishedAt: now,
+				Error:      err.Error(),
 			}
-			finalPath = localPath
-		default:
-			logger.Errorf("Unknown storage manager type for database %d", i+1)
-			failedBackups++
-			continue
+		} else {
+			// Databases with a storage prefix override keep their backups under it
+			dbBackupConfig := *backupConfig
+			dbBackupConfig.BackupPrefix = target.prefix
+			result = backup.BackupDatabase(engine, cfg.FindDatabase(e.DatabaseName()), target.storage.WithLogger(dbLogger), &dbBackupConfig, summary.RunID, dbLogger)
 		}
+		result.RunID = summary.RunID
+		result.Group = groupName
+		if result.Status == status.ResultSuccess {
+			dbLogger.Infof("Successfully backed up database %d to: %s", i+1, result.Location)
+		} else {
+			dbLogger.Errorf("Failed to back up database %d: %s", i+1, result.Error)
+		}
+		summary.Add(result)
+	}
+
+	// Record this instance under every target, spotting other deployments writing there
+	claimTargets(cfg, targets, summary.RunID, runLogger)
+
+	// Cleanup old backups once per storage target, not per database
+	summary.ObjectsDeleted = cleanupOldBackups(cfg, targets, runLogger.WithField("operation", "retention"))
+
+	summary.FinishedAt = time.Now()
+	duration := summary.FinishedAt.Sub(summary.StartedAt)
+	runLogger.Infof("Backup operation completed in %v. Successful: %d, Failed: %d, Skipped: %d", duration, summary.Successful, summary.Failed, summary.Skipped)
+
+	if summary.Failed > 0 {
+		return summary, fmt.Errorf("backup operation completed with %d failures out of %d databases", summary.Failed, len(engines))
+	}
+	if summary.Skipped > 0 && ctx.Err() != nil {
+		return summary, fmt.Errorf("backup operation cancelled, skipping %d of %d databases", summary.Skipped, len(engines))
+	}
+	if summary.Skipped > 0 {
+		return summary, fmt.Errorf("backup operation skipped %d of %d databases after exceeding its run budget", summary.Skipped, len(engines))
+	}
+
+	return summary, nil
+}
 
-		// Cleanup local backup file
-		if err := postgresBackup.CleanupBackup(backupPath); err != nil {
-			logger.Warnf("Failed to cleanup local backup file for database %d: %v", i+1, err)
+// cleanupOldBackups deletes the backups past the retention period from every
+// storage target and returns how many were deleted. Failures are logged, so
+// one unreachable target does not stop the others.
+func cleanupOldBackups(cfg *config.Config, targets *storageTargets, cleanupLogger logrus.FieldLogger) int {
+	backupConfig := &cfg.Backup
+	objectsDeleted := 0
+	cleanupLogger.Info("Cleaning up old backups...")
+	cleanupTargets, err := targets.All()
+	if err != nil {
+		cleanupLogger.Warnf("Failed to resolve every storage target: %v", err)
+	}
+	for _, target := range cleanupTargets {
+		sm := target.storage.WithLogger(cleanupLogger)
+		deleted, err := sm.DeleteOldBackups(target.prefix, backupConfig.RetentionDays)
+		objectsDeleted += deleted
+		if err != nil {
+			cleanupLogger.Warnf("Failed to cleanup old backups in %s: %v", sm.Location(), err)
+		}
+		if s3Manager, ok := sm.(*s3.S3Manager); ok && cfg.AWS.AbortIncompleteUploadsHours > 0 {
+			if _, err := s3Manager.AbortIncompleteUploads(target.prefix, time.Duration(cfg.AWS.AbortIncompleteUploadsHours)*time.Hour, false); err != nil {
+				cleanupLogger.Warnf("Failed to abort incomplete uploads in %s: %v", sm.Location(), err)
+			}
 		}
+	}
+	return objectsDeleted
+}
 
-		logger.Infof("Successfully backed up database %d to: %s", i+1, finalPath)
-		successfulBackups++
+// claimTargets records the run of this instance under every storage target
+// and logs the other deployments backing up into the same prefixes
+func claimTargets(cfg *config.Config, targets *storageTargets, runID string, logger logrus.FieldLogger) {
+	all, err := targets.All()
+	if err != nil {
+		logger.Warnf("Failed to resolve every storage target: %v", err)
+	}
+	hostname, _ := os.Hostname()
+	claim := storage.InstanceClaim{Instance: cfg.Backup.InstanceName(), Host: hostname, RunID: runID, LastRun: time.Now().UTC()}
+	for _, target := range all {
+		storage.CheckInstance(target.storage, target.prefix, claim, logger)
 	}
+}
 
-	// Cleanup old backups (only once, not per database)
-	logger.Info("Cleaning up old backups...")
-	switch sm := storageManager.(type) {
-	case *s3.S3Manager:
-		if err := sm.DeleteOldBackups(backupConfig.BackupPrefix, backupConfig.RetentionDays); err != nil {
-			logger.Warnf("Failed to cleanup old S3 backups: %v", err)
+// newStorageManager creates the configured storage backend with audit logging attached
+func newStorageManager(cfg *config.Config, logger *logrus.Logger) (storage.Backend, error) {
+	if cfg.IsLocalStorage() {
+		localStorage, err := storage.NewLocalStorage(&cfg.Local, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize local storage: %w", err)
 		}
-	case *storage.LocalStorage:
-		if err := sm.DeleteOldBackups(backupConfig.BackupPrefix, backupConfig.RetentionDays); err != nil {
-			logger.Warnf("Failed to cleanup old local backups: %v", err)
+		localStorage.SetAuditLog(newAuditLog(cfg, logger))
+		localStorage.SetModTimeRetention(cfg.Backup.RetentionModTimeFallback)
+		localStorage.SetTransferJobs(cfg.Backup.Transfers())
+		localStorage.SetInstance(cfg.Backup.InstanceName())
+		logger.Info("Using local storage for backups")
+		return localStorage, nil
+	}
+
+	if cfg.IsAWSStorage() {
+		s3Manager, err := s3.NewS3Manager(&cfg.AWS, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize S3 manager: %w", err)
 		}
+		s3Manager.SetAuditLog(newAuditLog(cfg, logger))
+		s3Manager.SetUploadStateDir(uploadStateDir(cfg))
+		s3Manager.SetModTimeRetention(cfg.Backup.RetentionModTimeFallback)
+		s3Manager.SetTransferJobs(cfg.Backup.Transfers())
+		logger.Info("Using AWS S3 for backups")
+		return s3Manager, nil
 	}
 
-	duration := time.Since(startTime)
-	logger.Infof("Backup operation completed in %v. Successful: %d, Failed: %d", duration, successfulBackups, failedBackups)
+	if cfg.IsRcloneStorage() {
+		remote := rclone.NewRemote(&cfg.Rclone, logger)
+		remote.SetAuditLog(newAuditLog(cfg, logger))
+		remote.SetModTimeRetention(cfg.Backup.RetentionModTimeFallback)
+		remote.SetTransferJobs(cfg.Backup.Transfers())
+		logger.Infof("Using rclone remote %s for backups", cfg.Rclone.Remote)
+		return remote, nil
+	}
 
-	if failedBackups > 0 {
-		return fmt.Errorf("backup operation completed with %d failures out of %d databases", failedBackups, len(postgresBackups))
+	if cfg.IsPluginStorage() {
+		pluginStorage := plugin.NewStorage(&cfg.StoragePlugin, logger)
+		pluginStorage.SetAuditLog(newAuditLog(cfg, logger))
+		pluginStorage.SetModTimeRetention(cfg.Backup.RetentionModTimeFallback)
+		pluginStorage.SetTransferJobs(cfg.Backup.Transfers())
+		logger.Infof("Using storage plugin %s for backups", cfg.StoragePlugin.Command())
+		return pluginStorage, nil
 	}
 
-	return nil
+	return nil, fmt.Errorf("no storage backend configured")
+}
+
+// uploadStateDir returns where in-progress multipart uploads are tracked
+func uploadStateDir(cfg *config.Config) string {
+	return filepath.Join(cfg.Backup.StateDirectory(), "uploads")
+}
+
+// newAuditLog creates the audit log for destructive operations, or nil when it is not configured
+func newAuditLog(cfg *config.Config, logger *logrus.Logger) *audit.Log {
+	var objects audit.ObjectWriter
+	if cfg.Audit.S3Prefix != "" && cfg.IsAWSStorage() {
+		s3Manager, err := s3.NewS3Manager(&cfg.AWS, logger)
+		if err != nil {
+			logger.Fatalf("Failed to initialize S3 manager for audit log: %v", err)
+		}
+		objects = s3Manager
+	}
+	return audit.NewLog(&cfg.Audit, objects)
 }
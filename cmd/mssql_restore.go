@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/restore"
+)
+
+// mssqlRestoreCommand restores a SQL Server backup into a configured server
+func mssqlRestoreCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("mssql-restore", "-database <name> -file <path> [-target <name>] [-replace] [-force]")
+	database := fs.String("database", "", "Configured SQL Server database whose server is restored to")
+	file := fs.String("file", "", "Downloaded .bak or .bacpac backup file")
+	target := fs.String("target", "", "Name of the restored database (default: the configured database)")
+	replace := fs.Bool("replace", false, "Overwrite the target database if it exists")
+	force := fs.Bool("force", false, "Replace without asking for confirmation")
+	return fs, func() error {
+		if *database == "" || *file == "" {
+			fs.Usage()
+			return fmt.Errorf("-database and -file are required")
+		}
+
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+
+		if err := cfg.FilterDatabases([]string{*database}); err != nil {
+			return err
+		}
+		dbConfig := cfg.Databases[0]
+		if dbConfig.EngineType() != config.EngineTypeMSSQL {
+			return fmt.Errorf("database %s is not a SQL Server database", *database)
+		}
+
+		targetDatabase := *target
+		if targetDatabase == "" {
+			targetDatabase = dbConfig.Database
+		}
+
+		if *replace && !*force {
+			prompt := fmt.Sprintf("This will overwrite database %s on %s.", targetDatabase, dbConfig.Host)
+			if !confirm(os.Stdin, os.Stdout, prompt) {
+				return fmt.Errorf("restore cancelled")
+			}
+		}
+
+		mssqlRestore := restore.NewMSSQLRestore(&dbConfig, logger)
+		mssqlRestore.SetAuditLog(newAuditLog(cfg, logger))
+		return mssqlRestore.Restore(*file, targetDatabase, *replace)
+	}
+}
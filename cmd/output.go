@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+
+	"db-backuper/internal/output"
+)
+
+// outputFlag holds the -output flag of a read command
+type outputFlag struct {
+	format *output.Format
+}
+
+// addOutputFlag registers the flag choosing how a read command prints its results
+func addOutputFlag(fs *flag.FlagSet) outputFlag {
+	format := output.Format(output.Table)
+	fs.Var(&format, "output", "Output format: table, json or yaml")
+	return outputFlag{format: &format}
+}
+
+// table reports whether the results are printed as a table
+func (o outputFlag) table() bool {
+	return *o.format == output.Table
+}
+
+// printResults writes results to standard output in the selected format,
+// with printTable writing the table
+func printResults[T any](o outputFlag, results []T, printTable func(w io.Writer)) error {
+	return output.WriteList(os.Stdout, *o.format, results, printTable)
+}
+
+// printResult writes a single result, such as a report, like printResults
+func printResult(o outputFlag, result any, printTable func(w io.Writer)) error {
+	return output.Write(os.Stdout, *o.format, result, printTable)
+}
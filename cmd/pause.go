@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/pause"
+)
+
+// pauseCommand pauses scheduled backups of a database, or of every database, until a deadline
+func pauseCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("pause", "[-database <name>] [-for <duration>] [-reason <text>] | -list [-output table|json|yaml]")
+	database := fs.String("database", "", "Only pause this database (default: every database)")
+	duration := fs.Duration("for", time.Hour, "How long to pause before backups resume automatically")
+	reason := fs.String("reason", "", "Why backups are paused, shown in the logs")
+	list := fs.Bool("list", false, "List the pauses in effect instead of adding one")
+	output := addOutputFlag(fs)
+	return fs, func() error {
+		cfg, _, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+		pauses := pauseFile(cfg)
+
+		if *list {
+			active, err := pauses.Active(time.Now())
+			if err != nil {
+				return err
+			}
+			return printResults(output, active, func(w io.Writer) {
+				fmt.Fprintln(w, "DATABASE\tUNTIL\tREASON")
+				for _, p := range active {
+					target := p.Database
+					if p.Global() {
+						target = "*"
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\n", target, p.Until.Format("2006-01-02 15:04:05"), p.Reason)
+				}
+			})
+		}
+
+		if *duration <= 0 {
+			return fmt.Errorf("-for must be positive")
+		}
+		if *database != "" && cfg.FindDatabase(*database) == nil {
+			return fmt.Errorf("unknown database %s", *database)
+		}
+		p, err := pauses.Pause(*database, time.Now().Add(*duration), *reason)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Scheduled backups of %s\n", p)
+		return nil
+	}
+}
+
+// resumeCommand lifts the pause of a database, or the global pause
+func resumeCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("resume", "[-database <name>]")
+	database := fs.String("database", "", "Resume this database (default: lift the pause covering every database)")
+	return fs, func() error {
+		cfg, _, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+
+		resumed, err := pauseFile(cfg).Resume(*database)
+		if err != nil {
+			return err
+		}
+		target := *database
+		if target == "" {
+			target = "all databases"
+		}
+		if !resumed {
+			fmt.Printf("No pause in effect for %s\n", target)
+			return nil
+		}
+		fmt.Printf("Resumed scheduled backups of %s\n", target)
+		return nil
+	}
+}
+
+// pauseFile returns the pause file in the state directory
+func pauseFile(cfg *config.Config) *pause.File {
+	return pause.Open(filepath.Join(cfg.Backup.StateDirectory(), pause.FileName))
+}
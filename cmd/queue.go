@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"db-backuper/internal/audit"
+	"db-backuper/internal/backup"
+	"db-backuper/internal/config"
+	"db-backuper/internal/jobs"
+	"db-backuper/internal/pause"
+	"db-backuper/internal/restore"
+	"db-backuper/internal/status"
+	"db-backuper/internal/storage"
+	"db-backuper/internal/web"
+
+	"github.com/sirupsen/logrus"
+)
+
+// onDemandTriggers start backups an operator asked for explicitly, which
+// include paused databases
+var onDemandTriggers = []string{"signal", "control-socket", web.APITrigger}
+
+// backupFunc backs up the databases of engines as run runID
+type backupFunc func(ctx context.Context, runID string, engines []backup.Engine) (*status.RunSummary, error)
+
+// newBackupQueue opens the job queue in the state directory of cfg, running
+// backup jobs of engines with run
+func newBackupQueue(cfg *config.Config, engines []backup.Engine, pauses *pause.File, run backupFunc, logger *logrus.Logger) (*jobs.Manager, error) {
+	queue, err := jobs.NewManager(filepath.Join(cfg.Backup.StateDirectory(), jobs.FileName), cfg.Backup.ConcurrentJobs(), logger)
+	if err != nil {
+		return nil, err
+	}
+	queue.Handle(jobs.KindBackup, jobs.Kind{
+		Validate: func(job jobs.Job) error {
+			if job.Backup != "" {
+				return fmt.Errorf("backup jobs take databases, not a backup")
+			}
+			for _, name := range job.Databases {
+				if !slices.ContainsFunc(engines, func(e backup.Engine) bool { return e.DatabaseName() == name }) {
+					return fmt.Errorf("unknown or disabled database %s", name)
+				}
+			}
+			return nil
+		},
+		Run: func(ctx context.Context, job jobs.Job) (string, error) {
+			runEngines := backupEngines(engines, job.Databases, job.Trigger, pauses, logger)
+			if len(runEngines) == 0 {
+				logger.Infof("Skipping %s backup: every database is paused", job.Trigger)
+				return status.ResultSkipped, nil
+			}
+			summary, err := run(ctx, job.ID, runEngines)
+			if summary == nil {
+				return status.ResultFailed, err
+			}
+			return summary.Result(), err
+		},
+	})
+	return queue, nil
+}
+
+// backupEngines returns the engines of the named databases, or all of them,
+// leaving out paused databases unless an operator asked for the backup
+func backupEngines(engines []backup.Engine, databases []string, trigger string, pauses *pause.File, logger *logrus.Logger) []backup.Engine {
+	if len(databases) > 0 {
+		engines = slices.DeleteFunc(slices.Clone(engines), func(e backup.Engine) bool {
+			return !slices.Contains(databases, e.DatabaseName())
+		})
+	}
+	if slices.Contains(onDemandTriggers, trigger) {
+		return engines
+	}
+	return unpausedEngines(engines, pauses, logger)
+}
+
+// queueBackup queues a backup of every database, unless one is already
+// queued or running, and reports whether it did
+func queueBackup(queue *jobs.Manager, trigger string, logger *logrus.Logger) bool {
+	job, queued, err := queue.Submit(jobs.Request{Kind: jobs.KindBackup, Trigger: trigger})
+	if err != nil {
+		logger.Warnf("Skipping %s backup: %v", trigger, err)
+		return false
+	}
+	if !queued {
+		logger.Warnf("Skipping %s backup: backup %s is already %s", trigger, job.ID, job.State)
+		return false
+	}
+	logger.Infof("Queued %s backup %s", trigger, job.ID)
+	return true
+}
+
+// restoreKind restores backups of the default storage into the import target
+// database, downloading them first
+func restoreKind(cfg *config.Config, storageManager storage.Backend, logger *logrus.Logger) jobs.Kind {
+	return jobs.Kind{
+		Validate: func(job jobs.Job) error {
+			if job.Backup == "" || len(job.Databases) > 0 {
+				return fmt.Errorf("restore jobs take the key of a backup, not databases")
+			}
+			probe := *cfg
+			probe.Import.BackupPath = job.Backup
+			return probe.ValidateForImport()
+		},
+		Run: func(ctx context.Context, job jobs.Job) (string, error) {
+			runLogger := logger.WithFields(logrus.Fields{"run_id": job.ID, "operation": "restore"})
+			target, err := newStorageTargets(cfg, storageManager, logger).For("")
+			if err != nil {
+				return status.ResultFailed, err
+			}
+			workDir, err := os.MkdirTemp("", "db-backuper-restore-*")
+			if err != nil {
+				return status.ResultFailed, fmt.Errorf("failed to create download directory: %w", err)
+			}
+			defer os.RemoveAll(workDir)
+
+			runLogger.Infof("Downloading %s", job.Backup)
+			backupPath, err := storage.Fetch(target.storage, job.Backup, filepath.Join(workDir, path.Base(job.Backup)), cfg.Backup.Transfers())
+			if err == nil {
+				err = verifyDownload(target.storage, job.Backup, backupPath)
+			}
+			if err != nil {
+				return status.ResultFailed, err
+			}
+			if err := ctx.Err(); err != nil {
+				return status.ResultSkipped, err
+			}
+
+			restoreCfg := *cfg
+			restoreCfg.Import.BackupPath = backupPath
+			auditLog := newAuditLog(cfg, logger)
+			details := map[string]string{"job_id": job.ID, "target_database": cfg.Import.TargetDatabase.Database}
+			if job.RequestedBy != "" {
+				details["requested_by"] = job.RequestedBy
+			}
+			if job.ApprovedBy != "" {
+				details["approved_by"] = job.ApprovedBy
+			}
+			if err := auditLog.Record(audit.Event{Action: audit.ActionRestore, Storage: target.storage.Location(), Targets: []string{job.Backup}, Details: details}); err != nil {
+				runLogger.Warnf("Failed to record audit event: %v", err)
+			}
+
+			runLogger.Infof("Restoring %s into %s", job.Backup, cfg.Import.TargetDatabase.Database)
+			postgresImport := restore.NewPostgresImport(&restoreCfg.Import, runLogger)
+			postgresImport.SetAuditLog(auditLog)
+			startedAt := time.Now()
+			if err := postgresImport.ImportBackup(); err != nil {
+				return status.ResultFailed, err
+			}
+			runLogger.Info("Restore completed successfully")
+			recordImport(&restoreCfg, runLogger, startedAt)
+			return status.ResultSuccess, nil
+		},
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/restore"
+)
+
+// redisRestoreCommand prints instructions for restoring a Redis RDB backup
+func redisRestoreCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("redis-restore", "-database <name> -file <path>")
+	database := fs.String("database", "", "Configured Redis database to restore into")
+	file := fs.String("file", "", "Downloaded RDB backup file")
+	return fs, func() error {
+		if *database == "" || *file == "" {
+			fs.Usage()
+			return fmt.Errorf("-database and -file are required")
+		}
+
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+
+		if err := cfg.FilterDatabases([]string{*database}); err != nil {
+			return err
+		}
+		dbConfig := cfg.Databases[0]
+		if dbConfig.EngineType() != config.EngineTypeRedis {
+			return fmt.Errorf("database %s is not a Redis database", *database)
+		}
+
+		if _, err := os.Stat(*file); err != nil {
+			return fmt.Errorf("backup file is not accessible: %w", err)
+		}
+
+		// Tailor the steps to the target server when it lets us read its config
+		placement, err := restore.InspectRedis(&dbConfig.Redis)
+		if err != nil {
+			logger.Warnf("Could not read data directory from %s, printing generic steps: %v", dbConfig.Redis.Host, err)
+		}
+
+		fmt.Print(restore.RedisRestoreInstructions(*file, &dbConfig.Redis, placement))
+		return nil
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"db-backuper/internal/docker"
+	"db-backuper/internal/provenance"
+	"db-backuper/internal/restore"
+	"db-backuper/internal/status"
+	"db-backuper/internal/storage"
+)
+
+// rehearseCommand restores a backup into a disposable PostgreSQL container,
+// validates it and hands the connection string to the operator
+func rehearseCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("rehearse", "(-file <path> | -key <key> | -database <name> [-date <YYYY-MM-DD>] | -restore-point <name>) [-version <n>] [-keep | -remove]")
+	file := fs.String("file", "", "Local backup file to restore instead of one from storage")
+	selection := addBackupFlags(fs, "rehearse")
+	target := fs.String("target", "", "Name of the restored database (default: the -database flag, or rehearsal)")
+	version := fs.String("version", "", "PostgreSQL version of the container (default: rehearsal.postgres_version)")
+	keep := fs.Bool("keep", false, "Leave the container running after the rehearsal")
+	remove := fs.Bool("remove", false, "Remove the container as soon as the validations finish")
+	return fs, func() error {
+		if *file == "" {
+			if err := selection.validate(); err != nil {
+				fs.Usage()
+				return fmt.Errorf("specify -file or exactly one of -key, -database or -restore-point")
+			}
+		}
+		if *keep && *remove {
+			fs.Usage()
+			return fmt.Errorf("-keep and -remove cannot be combined")
+		}
+
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+
+		// The restore time is measured end to end, including the download
+		startedAt := time.Now()
+		backupPath := *file
+		sample := status.RestoreSample{Source: status.RestoreSourceRehearsal}
+		sourceDatabase := *selection.database
+		var backend storage.Backend
+		if backupPath == "" {
+			storageManager, err := newStorageManager(cfg, logger)
+			if err != nil {
+				return err
+			}
+			storageTarget, selected, err := selection.resolve(newStorageTargets(cfg, storageManager, logger))
+			if err != nil {
+				return err
+			}
+			backend = storageTarget.storage
+			sample.Key = selected
+			sourceDatabase = cmp.Or(sourceDatabase, databaseFromKey(selected, storageTarget.prefix))
+			workDir, err := os.MkdirTemp("", "db-backuper-rehearsal-*")
+			if err != nil {
+				return fmt.Errorf("failed to create download directory: %w", err)
+			}
+			defer os.RemoveAll(workDir)
+
+			backupPath = filepath.Join(workDir, path.Base(filepath.ToSlash(selected)))
+			logger.Infof("Downloading %s", selected)
+			if backupPath, err = storage.Fetch(storageTarget.storage, selected, backupPath, cfg.Backup.Transfers()); err != nil {
+				return err
+			}
+			if err := verifyDownload(storageTarget.storage, selected, backupPath); err != nil {
+				return err
+			}
+		}
+
+		rehearsal, err := restore.NewRehearsal(&cfg.Rehearsal, logger)
+		if err != nil {
+			return err
+		}
+		database := cmp.Or(*target, *selection.database, "rehearsal")
+		result, err := rehearsal.Run(backupPath, database, restore.RehearsalOptions{Version: *version, Transforms: cfg.Import.Transforms})
+		if err != nil {
+			if result != nil {
+				if *keep {
+					fmt.Printf("Rehearsal failed; container %s kept for inspection: %s\n", result.ContainerID, result.ConnectionString())
+				} else if removeErr := rehearsal.Remove(result); removeErr != nil {
+					logger.Warnf("Failed to remove rehearsal container: %v", removeErr)
+				}
+			}
+			return fmt.Errorf("restore rehearsal failed: %w", err)
+		}
+
+		fmt.Printf("Restored %s into %s (%s) in %v: %d tables, %d checks passed\n",
+			path.Base(filepath.ToSlash(backupPath)), result.Image, docker.ShortID(result.ContainerID), result.RestoreDuration.Round(time.Second), result.Tables, len(result.Checks))
+		fmt.Printf("Connection string: %s\n", result.ConnectionString())
+		if info, err := os.Stat(backupPath); err == nil {
+			sample.SizeBytes = info.Size()
+		}
+		if meta, err := provenance.ReadSidecar(backupPath); err == nil && meta != nil {
+			sourceDatabase = cmp.Or(sourceDatabase, meta.Database)
+		}
+		recordRestore(cfg, backend, logger, sourceDatabase, sample, startedAt)
+
+		switch {
+		case *keep:
+			fmt.Printf("Container left running; remove it with: docker rm -f %s\n", docker.ShortID(result.ContainerID))
+			return nil
+		case !*remove:
+			fmt.Println("Press Enter to remove the container")
+			waitForRelease()
+		}
+		return rehearsal.Remove(result)
+	}
+}
+
+// waitForRelease blocks until the operator presses Enter, closes standard
+// input or interrupts the command
+func waitForRelease() {
+	released := make(chan struct{}, 1)
+	go func() {
+		bufio.NewReader(os.Stdin).ReadString('\n')
+		released <- struct{}{}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	select {
+	case <-released:
+	case <-signals:
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"db-backuper/internal/s3"
+)
+
+// rekeyCommand re-encrypts the stored backups of every backup prefix in use with
+// the current SSE-C key, finishing a key rotation
+func rekeyCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("rekey", "[-database <name>] [-dry-run]")
+	database := fs.String("database", "", "Only re-encrypt the backups of this database")
+	dryRun := fs.Bool("dry-run", false, "List the objects that would be re-encrypted without rewriting them")
+	return fs, func() error {
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+		if !cfg.IsAWSStorage() {
+			return fmt.Errorf("rekey only applies to AWS S3 storage")
+		}
+		if cfg.AWS.SSECustomerKey == "" && len(cfg.AWS.SSEPreviousKeys) == 0 {
+			return fmt.Errorf("rekey requires aws.sse_customer_key or aws.sse_previous_keys")
+		}
+
+		storageManager, err := newStorageManager(cfg, logger)
+		if err != nil {
+			return err
+		}
+		targets, err := newStorageTargets(cfg, storageManager, logger).All()
+		if err != nil {
+			return err
+		}
+
+		rekeyed, failed := 0, 0
+		for _, target := range targets {
+			sm := target.storage.(*s3.S3Manager)
+			prefix := target.prefix + "/"
+			if *database != "" {
+				prefix += *database + "/"
+			}
+			keys, err := sm.ListKeys(prefix)
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				changed, err := sm.Rekey(key, *dryRun)
+				if err != nil {
+					logger.Errorf("%v", err)
+					failed++
+					continue
+				}
+				if changed {
+					fmt.Printf("%s/%s\n", sm.Location(), key)
+					rekeyed++
+				}
+			}
+		}
+
+		if *dryRun {
+			fmt.Printf("%d object(s) would be re-encrypted with the current key\n", rekeyed)
+		} else {
+			fmt.Printf("Re-encrypted %d object(s) with the current key\n", rekeyed)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d object(s) could not be re-encrypted", failed)
+		}
+		return nil
+	}
+}
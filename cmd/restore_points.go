@@ -0,0 +1,225 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"db-backuper/internal/catalog"
+	"db-backuper/internal/storage"
+)
+
+// backupFlags holds the flags selecting a single backup
+type backupFlags struct {
+	key          *string
+	database     *string
+	date         *string
+	restorePoint *string
+}
+
+// addBackupFlags registers the flags selecting the backup a command acts on
+func addBackupFlags(fs *flag.FlagSet, verb string) backupFlags {
+	return backupFlags{
+		key:          fs.String("key", "", fmt.Sprintf("Storage key of the backup to %s", verb)),
+		database:     fs.String("database", "", fmt.Sprintf("%s the latest backup of this database", strings.ToUpper(verb[:1])+verb[1:])),
+		date:         fs.String("date", "", "Restrict -database to backups taken on this date (YYYY-MM-DD)"),
+		restorePoint: fs.String("restore-point", "", fmt.Sprintf("Name of the restore point to %s", verb)),
+	}
+}
+
+// validate checks that exactly one way of selecting a backup was given
+func (b backupFlags) validate() error {
+	selected := 0
+	for _, value := range []string{*b.key, *b.database, *b.restorePoint} {
+		if value != "" {
+			selected++
+		}
+	}
+	if selected != 1 {
+		return fmt.Errorf("specify exactly one of -key, -database or -restore-point")
+	}
+	return nil
+}
+
+// resolve returns the storage key of the selected backup and the storage it
+// is in. Backups selected by -database are looked up in that database's
+// storage, everything else in the default storage.
+func (b backupFlags) resolve(targets *storageTargets) (storageTarget, string, error) {
+	database := ""
+	if *b.key == "" && *b.restorePoint == "" {
+		database = *b.database
+	}
+	target, err := targets.For(database)
+	if err != nil {
+		return target, "", err
+	}
+	backend := target.storage
+
+	switch {
+	case *b.restorePoint != "":
+		point, err := catalog.New(backend, target.prefix).Get(*b.restorePoint)
+		if err != nil {
+			return target, "", err
+		}
+		return target, point.Key, nil
+	case *b.key != "":
+		return target, *b.key, nil
+	default:
+		query := storage.ListQuery{Prefix: target.prefix, Database: *b.database}
+		if *b.date != "" {
+			date, err := parseDate("-date", *b.date)
+			if err != nil {
+				return target, "", err
+			}
+			query.Since, query.Until = date, date
+		}
+		key, err := latestKey(backend, query)
+		return target, key, err
+	}
+}
+
+// tagCommand names a backup as a restore point
+func tagCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("tag", "-name <name> (-key <key> | -database <name> [-date <YYYY-MM-DD>]) [-note <text>] [-replace]")
+	name := fs.String("name", "", "Restore point name, e.g. pre-migration-v42")
+	selection := addBackupFlags(fs, "tag")
+	note := fs.String("note", "", "Free text stored with the restore point")
+	replace := fs.Bool("replace", false, "Move an existing restore point with the same name to this backup")
+	return fs, func() error {
+		if *name == "" {
+			fs.Usage()
+			return fmt.Errorf("-name is required")
+		}
+		if err := catalog.ValidateName(*name); err != nil {
+			return err
+		}
+		if err := selection.validate(); err != nil {
+			fs.Usage()
+			return err
+		}
+
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+
+		storageManager, err := newStorageManager(cfg, logger)
+		if err != nil {
+			return err
+		}
+
+		target, key, err := selection.resolve(newStorageTargets(cfg, storageManager, logger))
+		if err != nil {
+			return err
+		}
+		backend := target.storage
+
+		// Only tag backups that exist, storing the key relative to the storage root
+		keys, err := backend.ListKeys(key)
+		if err != nil {
+			return err
+		}
+		if key = matchKey(keys, key); key == "" {
+			return fmt.Errorf("backup %s not found in %s", *selection.key, backend.Location())
+		}
+
+		point := catalog.RestorePoint{
+			Name:     *name,
+			Database: databaseFromKey(key, target.prefix),
+			Key:      key,
+			Note:     *note,
+		}
+		if err := catalog.New(backend, target.prefix).Tag(point, *replace); err != nil {
+			return err
+		}
+
+		fmt.Printf("Tagged %s as restore point %s\n", key, *name)
+		return nil
+	}
+}
+
+// untagCommand removes a restore point name, keeping the backup
+func untagCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("untag", "-name <name>")
+	name := fs.String("name", "", "Restore point to remove")
+	return fs, func() error {
+		if *name == "" {
+			fs.Usage()
+			return fmt.Errorf("-name is required")
+		}
+
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+
+		storageManager, err := newStorageManager(cfg, logger)
+		if err != nil {
+			return err
+		}
+
+		if err := catalog.New(storageManager, cfg.Backup.BackupPrefix).Untag(*name); err != nil {
+			return err
+		}
+
+		fmt.Printf("Removed restore point %s\n", *name)
+		return nil
+	}
+}
+
+// restorePointsCommand lists the named restore points
+func restorePointsCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("restore-points", "[-database <name>] [-output table|json|yaml]")
+	database := fs.String("database", "", "Only list restore points of this database")
+	output := addOutputFlag(fs)
+	return fs, func() error {
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+
+		storageManager, err := newStorageManager(cfg, logger)
+		if err != nil {
+			return err
+		}
+
+		points, err := catalog.New(storageManager, cfg.Backup.BackupPrefix).RestorePoints()
+		if err != nil {
+			return err
+		}
+
+		if *database != "" {
+			points = slices.DeleteFunc(points, func(point catalog.RestorePoint) bool { return point.Database != *database })
+		}
+
+		return printResults(output, points, func(w io.Writer) {
+			fmt.Fprintln(w, "NAME\tDATABASE\tCREATED\tKEY\tNOTE")
+			for _, point := range points {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", point.Name, point.Database, point.CreatedAt.Format("2006-01-02 15:04:05"), point.Key, point.Note)
+			}
+		})
+	}
+}
+
+// matchKey returns the listed key equal to key, or to the absolute local path key, if any
+func matchKey(keys []string, key string) string {
+	for _, k := range keys {
+		if k == key || strings.HasSuffix(filepath.ToSlash(key), "/"+k) {
+			return k
+		}
+	}
+	return ""
+}
+
+// databaseFromKey returns the database folder of a backup key laid out as
+// prefix/database/YYYY-MM-DD/file
+func databaseFromKey(key, backupPrefix string) string {
+	parts := strings.Split(strings.TrimPrefix(key, backupPrefix+"/"), "/")
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[len(parts)-3]
+}
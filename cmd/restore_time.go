@@ -0,0 +1,70 @@
+package main
+
+import (
+	"cmp"
+	"os"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/metrics"
+	"db-backuper/internal/provenance"
+	"db-backuper/internal/s3"
+	"db-backuper/internal/status"
+	"db-backuper/internal/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// recordRestore publishes how long a restore of database took, measured from
+// startedAt, to the status file and the metrics sinks. Failures are only
+// logged, since the restore itself succeeded.
+func recordRestore(cfg *config.Config, backend storage.Backend, logger logrus.FieldLogger, database string, sample status.RestoreSample, startedAt time.Time) {
+	if database == "" {
+		logger.Debug("Restored backup has no known source database, not recording its restore time")
+		return
+	}
+	sample.FinishedAt = time.Now().UTC()
+	sample.DurationSeconds = sample.FinishedAt.Sub(startedAt).Seconds()
+	logger.Infof("Restore of %s took %v end to end", database, time.Duration(sample.DurationSeconds*float64(time.Second)).Round(time.Second))
+
+	var statusS3 *s3.S3Manager
+	if sm, ok := backend.(*s3.S3Manager); ok {
+		statusS3 = sm
+	}
+	if err := status.NewWriter(&cfg.Status, statusS3, logger).RecordRestore(database, sample); err != nil {
+		logger.Warnf("Failed to record restore time in status file: %v", err)
+	}
+
+	publisher, err := metrics.NewPublisher(cfg, logger)
+	if err != nil {
+		logger.Warnf("Failed to initialize metrics: %v", err)
+		return
+	}
+	if err := publisher.PublishSamples(metrics.CollectRestore(database, sample)); err != nil {
+		logger.Warnf("Failed to publish restore time metrics: %v", err)
+	}
+}
+
+// recordImport records the restore time of a finished import. The source
+// database is taken from the backup's metadata sidecar, falling back to the
+// target database.
+func recordImport(cfg *config.Config, logger logrus.FieldLogger, startedAt time.Time) {
+	sample := status.RestoreSample{Source: status.RestoreSourceImport, Key: cfg.Import.BackupPath}
+	if info, err := os.Stat(cfg.Import.BackupPath); err == nil {
+		sample.SizeBytes = info.Size()
+	}
+	database := cfg.Import.TargetDatabase.Database
+	if meta, err := provenance.ReadSidecar(cfg.Import.BackupPath); err == nil && meta != nil {
+		database = cmp.Or(meta.Database, database)
+	}
+
+	// Imports may run without storage configured; the status object in S3
+	// is only updated when the bucket is
+	var backend storage.Backend
+	if cfg.Status.S3Key != "" && cfg.IsAWSStorage() {
+		if s3Manager, err := s3.NewS3Manager(&cfg.AWS, logger); err == nil {
+			backend = s3Manager
+		}
+	}
+	recordRestore(cfg, backend, logger, database, sample, startedAt)
+}
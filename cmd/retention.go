@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"db-backuper/internal/retention"
+	"db-backuper/internal/storage"
+)
+
+// retentionSimulateCommand reports which stored backups a retention policy would
+// delete and how much space that reclaims, without deleting anything
+func retentionSimulateCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("retention simulate", "[-days <n>] [-keep-last <n>] [-keep-daily <n>] [-keep-weekly <n>] [-keep-monthly <n>] [-keep-yearly <n>] [-database <name>] [-all] [-output table|json|yaml]")
+	var policy retention.Policy
+	fs.IntVar(&policy.Days, "days", 0, "Keep backups dated within this many days (default: backup.retention_days when no rule is given)")
+	fs.IntVar(&policy.KeepLast, "keep-last", 0, "Keep the newest backups of each database")
+	fs.IntVar(&policy.KeepDaily, "keep-daily", 0, "Keep the newest backup of each of the most recent days with backups")
+	fs.IntVar(&policy.KeepWeekly, "keep-weekly", 0, "Keep the newest backup of each of the most recent ISO weeks with backups")
+	fs.IntVar(&policy.KeepMonthly, "keep-monthly", 0, "Keep the newest backup of each of the most recent months with backups")
+	fs.IntVar(&policy.KeepYearly, "keep-yearly", 0, "Keep the newest backup of each of the most recent years with backups")
+	database := fs.String("database", "", "Only simulate the backups of this database")
+	all := fs.Bool("all", false, "Also list the backups that would be kept, with the rules keeping them")
+	output := addOutputFlag(fs)
+	return fs, func() error {
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+		if policy == (retention.Policy{}) {
+			policy.Days = cfg.Backup.RetentionDays
+		}
+		if err := policy.Validate(); err != nil {
+			fs.Usage()
+			return err
+		}
+		storageManager, err := newStorageManager(cfg, logger)
+		if err != nil {
+			return err
+		}
+
+		backups, err := storedBackups(newStorageTargets(cfg, storageManager, logger), *database)
+		if err != nil {
+			return err
+		}
+		decisions := retention.Simulate(policy, backups, time.Now())
+
+		var listed []retention.Decision
+		var deleted int
+		var reclaimed int64
+		for _, decision := range decisions {
+			if decision.Delete {
+				deleted++
+				reclaimed += decision.Size
+			}
+			if decision.Delete || *all {
+				listed = append(listed, decision)
+			}
+		}
+		if err := printResults(output, listed, func(w io.Writer) {
+			fmt.Fprintln(w, "DATABASE\tDATE\tSIZE\tACTION\tKEPT BY\tKEY")
+			for _, decision := range listed {
+				action, keptBy := "keep", strings.Join(decision.KeptBy, ", ")
+				if decision.Delete {
+					action, keptBy = "delete", "-"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n", decision.Database, decision.Date, decision.Size, action, keptBy, decision.Key)
+			}
+		}); err != nil {
+			return err
+		}
+		if output.table() {
+			fmt.Printf("Policy %s would delete %d of %d backups, reclaiming %s\n", policy, deleted, len(decisions), formatBytes(reclaimed))
+		}
+		return nil
+	}
+}
+
+// storedBackups lists the backups of every storage target, or of one
+// database, with their retention holds
+func storedBackups(resolver *storageTargets, database string) ([]retention.Backup, error) {
+	var targets []storageTarget
+	if database != "" {
+		target, err := resolver.For(database)
+		if err != nil {
+			return nil, err
+		}
+		targets = []storageTarget{target}
+	} else {
+		var err error
+		if targets, err = resolver.All(); err != nil {
+			return nil, err
+		}
+	}
+
+	var backups []retention.Backup
+	for _, target := range targets {
+		backend := target.storage
+		held, err := storage.HeldKeys(backend, target.prefix)
+		if err != nil {
+			return nil, err
+		}
+		query := storage.ListQuery{Prefix: target.prefix, Database: database}
+		for {
+			page, err := backend.ListBackups(query)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range page.Entries {
+				backups = append(backups, retention.Backup{Storage: backend.Location(), Entry: entry, Held: storage.IsHeld(held, entry.Key)})
+			}
+			if page.NextPageToken == "" {
+				break
+			}
+			query.PageToken = page.NextPageToken
+		}
+	}
+	return backups, nil
+}
+
+// formatBytes formats a size in bytes with a binary unit, such as 1.5 GiB
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, exp := float64(size)/unit, 0
+	for value >= unit && exp < 4 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[exp])
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"db-backuper/internal/backup"
+	"db-backuper/internal/catalog"
+	"db-backuper/internal/runid"
+	"db-backuper/internal/s3"
+	"db-backuper/internal/safeguard"
+	"db-backuper/internal/status"
+)
+
+// safeguardCommand backs up databases, verifies the backups and only then runs the wrapped command
+func safeguardCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("safeguard", "-label <label> [-database <name>] [-group <name>] -- <command> [args...]")
+	label := fs.String("label", "", "Label of the backups; each is tagged as restore point <label>-<database>")
+	var databaseNames stringSliceFlag
+	fs.Var(&databaseNames, "database", "Only back up the named database (repeatable)")
+	var groupNames stringSliceFlag
+	fs.Var(&groupNames, "group", "Only back up the databases of the named backup group (repeatable)")
+	return fs, func() error {
+		command := fs.Args()
+		if *label == "" || len(command) == 0 {
+			fs.Usage()
+			return fmt.Errorf("-label and a command after -- are required")
+		}
+		if err := catalog.ValidateName(*label); err != nil {
+			return err
+		}
+
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+		if _, err := selectDatabases(cfg, databaseNames, groupNames); err != nil {
+			return err
+		}
+
+		var engines []backup.Engine
+		for _, dbConfig := range cfg.DatabasesByPriority() {
+			if !dbConfig.IsEnabled() {
+				logger.Infof("Skipping disabled database %s", dbConfig.Database)
+				continue
+			}
+			engine, err := backup.NewEngine(&dbConfig, logger)
+			if err != nil {
+				return fmt.Errorf("failed to initialize backup for database %s: %w", dbConfig.Database, err)
+			}
+			if err := engine.TestConnection(); err != nil {
+				return fmt.Errorf("connection test failed for database %s: %w", dbConfig.Database, err)
+			}
+			engines = append(engines, engine)
+		}
+		if len(engines) == 0 {
+			return fmt.Errorf("every selected database is disabled")
+		}
+
+		storageManager, err := newStorageManager(cfg, logger)
+		if err != nil {
+			return err
+		}
+		targets := newStorageTargets(cfg, storageManager, logger)
+
+		logger.Infof("Taking safeguard backup %s before running %q", *label, command[0])
+		summary, err := performBackup(context.Background(), runid.New(), engines, storageManager, cfg, logger)
+		var statusS3 *s3.S3Manager
+		if sm, ok := storageManager.(*s3.S3Manager); ok {
+			statusS3 = sm
+		}
+		if statusErr := status.NewWriter(&cfg.Status, statusS3, logger).Update(summary); statusErr != nil {
+			logger.Warnf("Failed to update status file: %v", statusErr)
+		}
+		if err != nil {
+			return fmt.Errorf("safeguard backup failed, not running the command: %w", err)
+		}
+
+		// Only run the command once every backup is read back and verified
+		var backups []safeguard.Backup
+		for _, result := range summary.Databases {
+			target, err := targets.For(result.Database)
+			if err != nil {
+				return err
+			}
+			backups = append(backups, safeguard.Backup{Result: result, Backend: target.storage, Prefix: target.prefix})
+		}
+		return safeguard.Run(backups, safeguard.Options{
+			Label:   *label,
+			Command: command,
+			RunID:   summary.RunID,
+			Jobs:    cfg.Backup.Transfers(),
+		}, logger)
+	}
+}
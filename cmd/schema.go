@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"db-backuper/internal/config"
+)
+
+// schemaCommand prints the JSON Schema of the configuration file
+func schemaCommand() (*flag.FlagSet, func() error) {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	setUsage(fs, "schema", "[-output <path>]")
+	output := fs.String("output", "", "Write the schema to a file instead of standard output")
+	return fs, func() error {
+		data, err := json.MarshalIndent(config.Schema(), "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode schema: %w", err)
+		}
+		data = append(data, '\n')
+
+		if *output == "" {
+			_, err = os.Stdout.Write(data)
+			return err
+		}
+		if err := os.WriteFile(*output, data, 0644); err != nil {
+			return fmt.Errorf("failed to write schema: %w", err)
+		}
+		fmt.Printf("Schema written to %s\n", *output)
+		return nil
+	}
+}
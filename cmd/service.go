@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/runstate"
+)
+
+// serviceFlags holds the flags shared by the commands running the backup service
+type serviceFlags struct {
+	config    configFlags
+	configDir *string
+	databases *stringSliceFlag
+	groups    *stringSliceFlag
+}
+
+// addServiceFlags registers the tenant directory and database selection flags
+func addServiceFlags(fs *flag.FlagSet, flags configFlags) serviceFlags {
+	sf := serviceFlags{
+		config:    flags,
+		configDir: fs.String("config-dir", "", "Directory of per-tenant configuration files (*.json) run together by one process"),
+		databases: &stringSliceFlag{},
+		groups:    &stringSliceFlag{},
+	}
+	fs.Var(sf.databases, "database", "Only back up the named database (repeatable)")
+	fs.Var(sf.groups, "group", "Only back up the databases of the named backup group (repeatable)")
+	return sf
+}
+
+// options returns the service options selected by the flags
+func (sf serviceFlags) options() serviceOptions {
+	return serviceOptions{
+		configPath: *sf.config.path,
+		configDir:  *sf.configDir,
+		profile:    *sf.config.profile,
+		envFile:    *sf.config.envFile,
+		strict:     *sf.config.strict,
+		databases:  *sf.databases,
+		groups:     *sf.groups,
+	}
+}
+
+// backupCommand backs up the configured databases once and exits
+func backupCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("backup", "[-database <name>]... [-group <name>]... [-config-dir <dir>] [-summary-file <path>]")
+	flags := addServiceFlags(fs, configFlags)
+	summaryFile := fs.String("summary-file", "", "Also write the results of the run as JSON to this file")
+	return fs, func() error {
+		opts := flags.options()
+		opts.once = true
+		opts.summaryFile = *summaryFile
+		runService(opts)
+		return nil
+	}
+}
+
+// serveCommand runs the scheduler until it is interrupted
+func serveCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("serve", "[-database <name>]... [-group <name>]... [-config-dir <dir>] [-control-socket <path>] [-listen <addr>]")
+	flags := addServiceFlags(fs, configFlags)
+	controlSocket := fs.String("control-socket", "", "Path of a Unix socket accepting on-demand backup commands")
+	listenAddr := fs.String("listen", "", "Address of an HTTP server serving status badges, the job queue and run logs, such as :8080")
+	return fs, func() error {
+		opts := flags.options()
+		opts.controlSocket = *controlSocket
+		opts.listen = *listenAddr
+		runService(opts)
+		return nil
+	}
+}
+
+// restoreCommand imports a backup into the configured target database
+func restoreCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("restore", "[-file <path>]")
+	file := fs.String("file", "", "Backup file to import (default: import.backup_path)")
+	return fs, func() error {
+		runService(serviceOptions{
+			configPath:   *configFlags.path,
+			profile:      *configFlags.profile,
+			envFile:      *configFlags.envFile,
+			strict:       *configFlags.strict,
+			importBackup: true,
+			importPath:   *file,
+		})
+		return nil
+	}
+}
+
+// cleanupCommand deletes the backups past the retention period without taking new ones
+func cleanupCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("cleanup", "")
+	return fs, func() error {
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+		storageManager, err := newStorageManager(cfg, logger)
+		if err != nil {
+			return err
+		}
+
+		deleted := cleanupOldBackups(cfg, newStorageTargets(cfg, storageManager, logger), logger.WithField("operation", "retention"))
+		fmt.Printf("Deleted %d backups older than %d days\n", deleted, cfg.Backup.RetentionDays)
+		return nil
+	}
+}
+
+// validateCommand loads and validates the configuration without connecting to anything
+func validateCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("validate", "[-config-dir <dir>]")
+	configDir := fs.String("config-dir", "", "Validate every tenant configuration in this directory instead")
+	return fs, func() error {
+		if *configFlags.envFile != "" {
+			if err := config.LoadDotEnv(*configFlags.envFile); err != nil {
+				return err
+			}
+		}
+		loadOptions := config.LoadOptions{Profile: *configFlags.profile, Strict: *configFlags.strict}
+
+		if *configDir != "" {
+			tenants, err := config.LoadTenants(*configDir, loadOptions)
+			for _, tenant := range tenants {
+				fmt.Printf("%s: %s\n", tenant.Name, describeConfig(tenant.Config))
+			}
+			if err != nil {
+				return fmt.Errorf("invalid tenant configurations:\n%w", err)
+			}
+			return nil
+		}
+
+		cfg, err := config.LoadConfig(*configFlags.path, loadOptions)
+		if err != nil {
+			return err
+		}
+		if err := cfg.ValidateForBackup(); err != nil {
+			return err
+		}
+		fmt.Printf("Configuration is valid: %s\n", describeConfig(cfg))
+		return nil
+	}
+}
+
+// describeConfig summarizes what a valid configuration backs up and when
+func describeConfig(cfg *config.Config) string {
+	enabled := 0
+	for _, db := range cfg.Databases {
+		if db.IsEnabled() {
+			enabled++
+		}
+	}
+	return fmt.Sprintf("%d databases (%d enabled), schedule %q, retention %d days", len(cfg.Databases), enabled, cfg.Backup.Schedule, cfg.Backup.RetentionDays)
+}
+
+// historyCommand prints the most recent backup runs recorded in the scheduler state
+func historyCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("history", "[-limit <n>] [-output table|json|yaml]")
+	limit := fs.Int("limit", 20, "Number of runs to show, newest first")
+	output := addOutputFlag(fs)
+	return fs, func() error {
+		cfg, _, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(cfg.Backup.StateDirectory(), runstate.FileName)
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("no runs recorded yet in %s", path)
+		}
+		state, err := runstate.Open(path)
+		if err != nil {
+			return err
+		}
+
+		runs := state.Runs()
+		slices.Reverse(runs)
+		runs = runs[:min(len(runs), max(*limit, 0))]
+
+		return printResults(output, runs, func(w io.Writer) {
+			fmt.Fprintln(w, "STARTED\tDURATION\tSUCCESSFUL\tFAILED\tSKIPPED\tDELETED\tRUN ID\tFAILED DATABASES")
+			for _, run := range runs {
+				fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%s\t%s\n",
+					run.StartedAt.Format("2006-01-02 15:04:05"), run.FinishedAt.Sub(run.StartedAt).Round(time.Second),
+					run.Successful, run.Failed, run.Skipped, run.ObjectsDeleted, run.RunID, strings.Join(run.FailedDatabases, ", "))
+			}
+		})
+	}
+}
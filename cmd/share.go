@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"db-backuper/internal/audit"
+	"db-backuper/internal/config"
+	"db-backuper/internal/s3"
+	"db-backuper/internal/storage"
+)
+
+// shareCommand prints a pre-signed URL downloading a backup without AWS
+// credentials until it expires
+func shareCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("share", "(-key <key> | -database <name> [-date <YYYY-MM-DD>] | -restore-point <name>) [-ttl <duration>]")
+	selection := addBackupFlags(fs, "share")
+	ttl := fs.Duration("ttl", 0, "How long the URL stays valid, e.g. 2h, at most 168h (default: aws.share_ttl_hours or 24h)")
+	return fs, func() error {
+		if err := selection.validate(); err != nil {
+			fs.Usage()
+			return err
+		}
+		if *ttl < 0 || *ttl > config.MaxShareTTL {
+			fs.Usage()
+			return fmt.Errorf("-ttl must be between 1s and %s", config.MaxShareTTL)
+		}
+
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+		if !cfg.IsAWSStorage() {
+			return fmt.Errorf("share only applies to AWS S3 storage")
+		}
+		if *ttl == 0 {
+			*ttl = cfg.AWS.ShareTTL()
+		}
+
+		storageManager, err := newStorageManager(cfg, logger)
+		if err != nil {
+			return err
+		}
+		target, key, err := selection.resolve(newStorageTargets(cfg, storageManager, logger))
+		if err != nil {
+			return err
+		}
+		if storage.IsIndex(key) {
+			return fmt.Errorf("%s is stored as several objects; download it and hand over the file instead", key)
+		}
+		sm := target.storage.(*s3.S3Manager)
+
+		url, err := sm.PresignDownload(key, *ttl)
+		if err != nil {
+			return err
+		}
+		expires := time.Now().Add(*ttl).UTC()
+
+		// The URL itself is a credential and stays out of the audit log
+		if err := newAuditLog(cfg, logger).Record(audit.Event{
+			Action:  audit.ActionShare,
+			Storage: sm.Location(),
+			Targets: []string{key},
+			Details: map[string]string{"ttl": ttl.String(), "expires": expires.Format(time.RFC3339)},
+		}); err != nil {
+			logger.Errorf("Failed to record share in audit log: %v", err)
+		}
+
+		fmt.Printf("Anyone with this URL can download %s until %s:\n%s\n", key, expires.Format(time.RFC3339), url)
+		return nil
+	}
+}
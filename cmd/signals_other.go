@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// serviceSignals are the signals the scheduler waits for. Windows has no
+// SIGUSR1; on-demand backups go through the control socket instead.
+var serviceSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// isBackupSignal reports whether sig asks for an on-demand backup
+func isBackupSignal(sig os.Signal) bool {
+	return false
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// serviceSignals are the signals the scheduler waits for: interrupts stop
+// it, SIGUSR1 triggers an on-demand backup
+var serviceSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR1}
+
+// isBackupSignal reports whether sig asks for an on-demand backup
+func isBackupSignal(sig os.Signal) bool {
+	return sig == syscall.SIGUSR1
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/s3"
+	"db-backuper/internal/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// storageTarget is a storage backend and the prefix backups are kept under in it
+type storageTarget struct {
+	storage storage.Backend
+	prefix  string
+}
+
+// storageTargets resolves where each database's backups are stored. Databases
+// without storage overrides share the default storage manager; the managers
+// of overridden buckets and paths are created on first use.
+type storageTargets struct {
+	cfg      *config.Config
+	logger   *logrus.Logger
+	fallback storage.Backend
+	managers map[string]storage.Backend
+}
+
+// newStorageTargets creates the resolver around the default storage manager
+func newStorageTargets(cfg *config.Config, fallback storage.Backend, logger *logrus.Logger) *storageTargets {
+	return &storageTargets{
+		cfg:      cfg,
+		logger:   logger,
+		fallback: fallback,
+		managers: map[string]storage.Backend{},
+	}
+}
+
+// For returns where the backups of the named database are stored
+func (t *storageTargets) For(database string) (storageTarget, error) {
+	resolved := t.cfg.StorageFor(database)
+	target := storageTarget{storage: t.fallback, prefix: resolved.Prefix}
+	if resolved.Bucket == t.cfg.AWS.Bucket && resolved.Path == t.cfg.Local.Path {
+		return target, nil
+	}
+
+	location := resolved.Bucket + resolved.Path
+	if manager, ok := t.managers[location]; ok {
+		target.storage = manager
+		return target, nil
+	}
+
+	var manager storage.Backend
+	if t.cfg.IsLocalStorage() {
+		localConfig := t.cfg.Local
+		localConfig.Path = resolved.Path
+		localStorage, err := storage.NewLocalStorage(&localConfig, t.logger)
+		if err != nil {
+			return target, fmt.Errorf("failed to initialize local storage %s for database %s: %w", resolved.Path, database, err)
+		}
+		localStorage.SetAuditLog(newAuditLog(t.cfg, t.logger))
+		localStorage.SetModTimeRetention(t.cfg.Backup.RetentionModTimeFallback)
+		localStorage.SetTransferJobs(t.cfg.Backup.Transfers())
+		localStorage.SetInstance(t.cfg.Backup.InstanceName())
+		manager = localStorage
+	} else {
+		awsConfig := t.cfg.AWS
+		awsConfig.Bucket = resolved.Bucket
+		s3Manager, err := s3.NewS3Manager(&awsConfig, t.logger)
+		if err != nil {
+			return target, fmt.Errorf("failed to initialize S3 bucket %s for database %s: %w", resolved.Bucket, database, err)
+		}
+		s3Manager.SetAuditLog(newAuditLog(t.cfg, t.logger))
+		s3Manager.SetUploadStateDir(uploadStateDir(t.cfg))
+		s3Manager.SetModTimeRetention(t.cfg.Backup.RetentionModTimeFallback)
+		s3Manager.SetTransferJobs(t.cfg.Backup.Transfers())
+		manager = s3Manager
+	}
+
+	t.logger.Infof("Storing backups of %s in %s", database, manager.Location())
+	t.managers[location] = manager
+	target.storage = manager
+	return target, nil
+}
+
+// All returns every distinct target of the configured databases, starting
+// with the default storage and prefix, so retention covers each of them
+func (t *storageTargets) All() ([]storageTarget, error) {
+	targets := []storageTarget{{storage: t.fallback, prefix: t.cfg.Backup.BackupPrefix}}
+	seen := map[string]bool{t.fallback.Location() + "\x00" + t.cfg.Backup.BackupPrefix: true}
+	for _, db := range t.cfg.Databases {
+		target, err := t.For(db.Database)
+		if err != nil {
+			return targets, err
+		}
+		key := target.storage.Location() + "\x00" + target.prefix
+		if !seen[key] {
+			seen[key] = true
+			targets = append(targets, target)
+		}
+	}
+	return targets, nil
+}
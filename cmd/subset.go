@@ -0,0 +1,75 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"db-backuper/internal/restore"
+)
+
+// subsetCommand reduces a backup to the rows selected by the subset rules of the
+// configuration and the rows they reference, and dumps the result
+func subsetCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("subset", "(-file <path> | -key <key> | -database <name> [-date <YYYY-MM-DD>] | -restore-point <name>) -out <path> [-version <n>] [-output table|json|yaml]")
+	file := fs.String("file", "", "Local backup file to restore instead of one from storage")
+	selection := addBackupFlags(fs, "subset")
+	out := fs.String("out", "", "Path of the SQL dump of the subset to write")
+	version := fs.String("version", "", "PostgreSQL version of the container (default: rehearsal.postgres_version)")
+	output := addOutputFlag(fs)
+	return fs, func() error {
+		if *file == "" {
+			if err := selection.validate(); err != nil {
+				fs.Usage()
+				return fmt.Errorf("specify -file or exactly one of -key, -database or -restore-point")
+			}
+		}
+		if *out == "" {
+			fs.Usage()
+			return fmt.Errorf("-out is required")
+		}
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+		if len(cfg.Subset.Roots) == 0 {
+			return fmt.Errorf("no subset roots configured; add at least one to subset.roots")
+		}
+
+		backupPath, cleanup, err := fixtureSource(cfg, logger, *file, selection)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+
+		reduce := func(ctx context.Context, rehearsal *restore.Rehearsal, result *restore.RehearsalResult) ([]restore.TableSubset, error) {
+			db, err := rehearsal.Open(result)
+			if err != nil {
+				return nil, err
+			}
+			defer db.Close()
+			return restore.Subset(ctx, db, &cfg.Subset, logger)
+		}
+		database := cmp.Or(*selection.database, "subset")
+		subsets, err := buildFixture(cfg, logger, backupPath, database, *version, *out, reduce)
+		if err != nil {
+			return err
+		}
+
+		if output.table() {
+			printFixture(*out, subsets)
+		}
+		return printResults(output, subsets, func(w io.Writer) {
+			fmt.Fprintln(w, "TABLE\tROWS BEFORE\tROWS AFTER\tKEPT")
+			for _, subset := range subsets {
+				kept := "-"
+				if subset.Before > 0 {
+					kept = fmt.Sprintf("%.1f%%", float64(subset.After)*100/float64(subset.Before))
+				}
+				fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", subset.Table, subset.Before, subset.After, kept)
+			}
+		})
+	}
+}
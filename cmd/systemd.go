@@ -0,0 +1,40 @@
+package main
+
+import (
+	"db-backuper/internal/systemd"
+
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// notifyReady tells systemd the scheduler is running. When the unit has a
+// watchdog, c pings it, so that systemd restarts a scheduler that stopped
+// running its jobs.
+func notifyReady(c *cron.Cron, logger *logrus.Logger) {
+	interval := systemd.WatchdogInterval()
+	if interval > 0 {
+		c.Schedule(cron.Every(interval), cron.FuncJob(func() {
+			if _, err := systemd.Notify("WATCHDOG=1"); err != nil {
+				logger.Warnf("Failed to ping systemd watchdog: %v", err)
+			}
+		}))
+	}
+
+	// The first watchdog ping goes with readiness, however long startup took
+	sent, err := systemd.Notify("READY=1\nWATCHDOG=1")
+	switch {
+	case err != nil:
+		logger.Warnf("Failed to notify systemd: %v", err)
+	case sent && interval > 0:
+		logger.Infof("Notified systemd of readiness, pinging its watchdog every %s", interval)
+	case sent:
+		logger.Info("Notified systemd of readiness")
+	}
+}
+
+// notifyStopping tells systemd the service is shutting down
+func notifyStopping(logger *logrus.Logger) {
+	if _, err := systemd.Notify("STOPPING=1"); err != nil {
+		logger.Warnf("Failed to notify systemd: %v", err)
+	}
+}
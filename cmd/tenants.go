@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"db-backuper/internal/backup"
+	"db-backuper/internal/compliance"
+	"db-backuper/internal/config"
+	"db-backuper/internal/jobs"
+	"db-backuper/internal/metrics"
+	"db-backuper/internal/notify"
+	"db-backuper/internal/pause"
+	"db-backuper/internal/redact"
+	"db-backuper/internal/runid"
+	"db-backuper/internal/runstate"
+	"db-backuper/internal/s3"
+	"db-backuper/internal/sla"
+	"db-backuper/internal/status"
+	"db-backuper/internal/storage"
+
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// tenant is one configuration run by the multi-tenant scheduler, with its
+// own storage, state, notifications and metrics
+type tenant struct {
+	name         string
+	cfg          *config.Config
+	logger       *logrus.Logger
+	runState     *runstate.Store
+	statusWriter *status.Writer
+	notifier     *notify.Notifier
+	publisher    *metrics.Publisher
+	pauses       *pause.File
+	queue        *jobs.Manager
+	slaMonitor   *sla.Monitor
+	engines      []backup.Engine
+	storage      storage.Backend
+	// publishMu makes jobs running at once publish their results one at a time
+	publishMu sync.Mutex
+	// stopChangeCapture stops storing the changes of the tenant's databases
+	stopChangeCapture func()
+	// lastSummary holds the results of the tenant's most recent run
+	lastSummary *status.RunSummary
+}
+
+// tenantField adds the tenant's name to every log line of its logger
+type tenantField string
+
+// Levels returns the levels the field is added to
+func (t tenantField) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire adds the field to a log entry
+func (t tenantField) Fire(entry *logrus.Entry) error {
+	entry.Data["tenant"] = string(t)
+	return nil
+}
+
+// runTenants runs the backups of every tenant configuration in dir from one
+// process. A tenant whose configuration, connections or backups fail is
+// reported and left out, and the other tenants keep running.
+func runTenants(dir string, loadOptions config.LoadOptions, runOnce bool, summaryFile string, logger *logrus.Logger) {
+	configs, err := config.LoadTenants(dir, loadOptions)
+	if err != nil {
+		logger.Errorf("Some tenants could not be loaded: %v", err)
+	}
+
+	var tenants []*tenant
+	for _, tc := range configs {
+		t, err := newTenant(tc)
+		if err != nil {
+			logger.WithField("tenant", tc.Name).Errorf("Tenant disabled: %v", err)
+			continue
+		}
+		tenants = append(tenants, t)
+	}
+	if len(tenants) == 0 {
+		logger.Fatalf("No tenant in %s could be started", dir)
+	}
+	logger.Infof("Running %d tenants from %s", len(tenants), dir)
+
+	if runOnce {
+		var failed []string
+		results := []status.RunResult{}
+		for _, t := range tenants {
+			if err := t.runBackup("one-time"); err != nil {
+				t.logger.Errorf("Backup failed: %v", err)
+				failed = append(failed, t.name)
+			}
+			if t.lastSummary != nil {
+				results = append(results, status.NewRunResult(t.name, t.lastSummary))
+			}
+		}
+		for _, result := range results {
+			status.PrintRunResult(os.Stdout, result)
+		}
+		if summaryFile != "" {
+			if err := status.WriteSummaryFile(summaryFile, results); err != nil {
+				logger.Errorf("Failed to write run summary: %v", err)
+			}
+		}
+		if len(failed) > 0 {
+			logger.Fatalf("Backups of %d of %d tenants failed: %v", len(failed), len(tenants), failed)
+		}
+		logger.Info("Backups of every tenant completed successfully")
+		return
+	}
+
+	c := cron.New()
+	for _, t := range tenants {
+		if err := t.schedule(c); err != nil {
+			t.logger.Errorf("Tenant disabled: %v", err)
+		}
+	}
+	c.Start()
+	notifyReady(c, logger)
+
+	// Wait for interrupt signal, triggering an immediate backup of every tenant on SIGUSR1
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, serviceSignals...)
+	for sig := range sigChan {
+		if isBackupSignal(sig) {
+			logger.Info("Received SIGUSR1, triggering on-demand backups of every tenant")
+			for _, t := range tenants {
+				if t.queue != nil {
+					queueBackup(t.queue, "signal", t.logger)
+				}
+			}
+			continue
+		}
+		break
+	}
+
+	logger.Info("Shutting down backup service")
+	notifyStopping(logger)
+	c.Stop()
+	for _, t := range tenants {
+		if t.stopChangeCapture != nil {
+			t.stopChangeCapture()
+		}
+		if t.queue != nil {
+			t.queue.Stop()
+		}
+		if t.slaMonitor != nil {
+			t.slaMonitor.Stop()
+		}
+	}
+}
+
+// newTenant prepares the backups of a tenant and tests its connections
+func newTenant(tc config.Tenant) (*tenant, error) {
+	cfg := tc.Config
+	logger := setupLogger(cfg.Logging)
+	redactor := redact.New()
+	redactor.AddSecrets(cfg.Secrets()...)
+	redact.Install(logger, redactor)
+	logger.AddHook(tenantField(tc.Name))
+
+	// Tenants keep their scheduler state apart even when they share a state directory
+	cfg.Backup.StateDir = filepath.Join(cfg.Backup.StateDirectory(), "tenants", tc.Name)
+	if err := compliance.Apply(cfg, logger); err != nil {
+		return nil, fmt.Errorf("refusing to run: %w", err)
+	}
+
+	t := &tenant{name: tc.Name, cfg: cfg, logger: logger, pauses: pauseFile(cfg)}
+	var engines []backup.Engine
+	for _, dbConfig := range cfg.DatabasesByPriority() {
+		if !dbConfig.IsEnabled() {
+			logger.Infof("Skipping disabled database %s", dbConfig.Database)
+			continue
+		}
+		engine, err := backup.NewEngine(&dbConfig, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize backup for database %s: %w", dbConfig.Database, err)
+		}
+		engines = append(engines, engine)
+	}
+	if len(engines) == 0 {
+		return nil, errors.New("every database is disabled")
+	}
+
+	storageManager, err := newStorageManager(cfg, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize storage: %w", err)
+	}
+	t.storage = storageManager
+	statusS3, _ := storageManager.(*s3.S3Manager)
+	if statusS3 != nil {
+		if err := statusS3.ResumeUploads(); err != nil {
+			logger.Warnf("Failed to resume interrupted uploads: %v", err)
+		}
+	}
+	if err := testConnections(engines, storageManager, logger); err != nil {
+		return nil, fmt.Errorf("connection test failed: %w", err)
+	}
+
+	t.statusWriter = status.NewWriter(&cfg.Status, statusS3, logger)
+	if t.runState, err = runstate.Open(filepath.Join(cfg.Backup.StateDirectory(), runstate.FileName)); err != nil {
+		return nil, fmt.Errorf("failed to load scheduler state: %w", err)
+	}
+	if cfg.Notifications.StatePath == "" {
+		cfg.Notifications.StatePath = filepath.Join(cfg.Backup.StateDirectory(), "notifications.json")
+	}
+	if t.notifier, err = notify.NewNotifier(&cfg.Notifications, logger); err != nil {
+		return nil, fmt.Errorf("failed to initialize notifications: %w", err)
+	}
+	if t.publisher, err = metrics.NewPublisher(cfg, logger); err != nil {
+		return nil, fmt.Errorf("failed to initialize metrics: %w", err)
+	}
+	t.publisher.SetTenant(tc.Name)
+
+	t.engines = engines
+	return t, nil
+}
+
+// runBackup runs a backup of the tenant in the foreground
+func (t *tenant) runBackup(trigger string) error {
+	engines := backupEngines(t.engines, nil, trigger, t.pauses, t.logger)
+	if len(engines) == 0 {
+		t.logger.Infof("Skipping %s backup: every database is paused", trigger)
+		return nil
+	}
+	_, err := t.runEngines(context.Background(), runid.New(), engines)
+	return err
+}
+
+// runEngines backs up the tenant's databases and publishes the results. A
+// panic is turned into an error so it cannot take down the other tenants.
+func (t *tenant) runEngines(ctx context.Context, runID string, engines []backup.Engine) (summary *status.RunSummary, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("backup run panicked: %v", recovered)
+		}
+	}()
+
+	summary, err = performBackup(ctx, runID, engines, t.storage, t.cfg, t.logger)
+	t.publishMu.Lock()
+	defer t.publishMu.Unlock()
+	t.lastSummary = summary
+	if stateErr := t.runState.RecordRun(summary); stateErr != nil {
+		t.logger.Warnf("Failed to save scheduler state: %v", stateErr)
+	}
+	if statusErr := t.statusWriter.Update(summary); statusErr != nil {
+		t.logger.Warnf("Failed to update status file: %v", statusErr)
+	}
+	if t.slaMonitor != nil {
+		t.slaMonitor.Update(summary)
+	}
+	if notifyErr := t.notifier.Notify(summary); notifyErr != nil {
+		t.logger.Warnf("Failed to send notifications: %v", notifyErr)
+	}
+	if metricsErr := t.publisher.Publish(summary); metricsErr != nil {
+		t.logger.Warnf("Failed to publish metrics: %v", metricsErr)
+	}
+	return summary, err
+}
+
+// schedule registers the tenant's backup schedule, starts its SLA checks and
+// catches up on a run missed while the service was stopped
+func (t *tenant) schedule(c *cron.Cron) error {
+	schedule, err := cron.ParseStandard(t.cfg.Backup.Schedule)
+	if err != nil {
+		return fmt.Errorf("failed to schedule backup: %w", err)
+	}
+
+	previous := t.runState.Report()
+	if previous == nil {
+		previous = t.statusWriter.Load()
+	}
+	if monitor := sla.NewMonitor(t.cfg, previous, t.notifier, t.publisher, t.logger); monitor.Enabled() {
+		t.slaMonitor = monitor
+		t.slaMonitor.Persist(t.runState)
+		t.slaMonitor.SkipPaused(t.pauses)
+		t.slaMonitor.Start()
+	}
+
+	if t.queue, err = newBackupQueue(t.cfg, t.engines, t.pauses, t.runEngines, t.logger); err != nil {
+		return fmt.Errorf("failed to load job queue: %w", err)
+	}
+	t.queue.Start()
+
+	missedAt, missed := t.runState.Missed(time.Now())
+	if err := t.runState.SetNextRun(schedule.Next(time.Now())); err != nil {
+		t.logger.Warnf("Failed to save scheduler state: %v", err)
+	}
+	c.Schedule(schedule, cron.FuncJob(func() {
+		if err := t.runState.SetNextRun(schedule.Next(time.Now())); err != nil {
+			t.logger.Warnf("Failed to save scheduler state: %v", err)
+		}
+		queueBackup(t.queue, "scheduled", t.logger)
+	}))
+	t.logger.Infof("Scheduled backup with cron expression: %s", t.cfg.Backup.Schedule)
+	t.stopChangeCapture = startChangeCapture(t.cfg, t.storage, t.logger)
+
+	if missed {
+		if t.cfg.Backup.CatchUp {
+			t.logger.Warnf("Missed the backup scheduled at %s while the service was stopped, catching up", missedAt.Format(time.RFC3339))
+			queueBackup(t.queue, "catch-up", t.logger)
+		} else {
+			t.logger.Warnf("Missed the backup scheduled at %s while the service was stopped; set backup.catch_up to run missed backups on start", missedAt.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"db-backuper/internal/transform"
+)
+
+// transformCommand writes a SQL backup through the import transforms, for
+// loading it with other tools
+func transformCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("transform", "-file <path> -out <path>")
+	file := fs.String("file", "", "SQL backup to transform")
+	out := fs.String("out", "", "Path of the transformed script, - for standard output")
+	return fs, func() error {
+		if *file == "" || *out == "" {
+			fs.Usage()
+			return fmt.Errorf("-file and -out are required")
+		}
+
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+		if len(cfg.Import.Transforms) == 0 {
+			return fmt.Errorf("no transforms configured; add rules to import.transforms")
+		}
+		pipeline, err := transform.New(cfg.Import.Transforms)
+		if err != nil {
+			return err
+		}
+
+		var stats transform.Stats
+		if *out == "-" {
+			in, err := os.Open(*file)
+			if err != nil {
+				return fmt.Errorf("failed to open script: %w", err)
+			}
+			defer in.Close()
+			if stats, err = pipeline.Copy(os.Stdout, in); err != nil {
+				return fmt.Errorf("failed to transform script: %w", err)
+			}
+		} else if stats, err = pipeline.CopyFile(*file, *out); err != nil {
+			return err
+		}
+		logger.Infof("Transformed %s: %s", *file, stats)
+		return nil
+	}
+}
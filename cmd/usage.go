@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/usage"
+)
+
+// usageCommand reports the space taken by stored backups, its estimated S3 cost
+// and retention and storage class changes that would lower it
+func usageCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("usage", "[-database <name>] [-output table|json|yaml]")
+	database := fs.String("database", "", "Only report the backups of this database")
+	output := addOutputFlag(fs)
+	return fs, func() error {
+		cfg, logger, err := loadCommandConfig(configFlags)
+		if err != nil {
+			return err
+		}
+		storageManager, err := newStorageManager(cfg, logger)
+		if err != nil {
+			return err
+		}
+
+		report, err := usageReport(cfg, newStorageTargets(cfg, storageManager, logger), *database)
+		if err != nil {
+			return err
+		}
+		return printResult(output, report, func(w io.Writer) {
+			fmt.Fprintln(w, "STORAGE\tDATABASE\tBACKUPS\tSIZE\tOLDEST\tNEWEST\tCOST/MO")
+			for _, db := range report.Databases {
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n", db.Storage, db.Database, db.Backups, formatBytes(db.Bytes), db.Oldest, db.Newest, formatCost(db.MonthlyCost))
+			}
+			fmt.Fprintf(w, "TOTAL\t\t%d\t%s\t\t\t%s\n", report.Backups, formatBytes(report.Bytes), formatCost(report.MonthlyCost))
+			if len(report.Suggestions) > 0 {
+				fmt.Fprintln(w)
+				fmt.Fprintln(w, "Suggestions:")
+				for _, suggestion := range report.Suggestions {
+					fmt.Fprintf(w, "  %s\n", suggestion.Description)
+				}
+			}
+		})
+	}
+}
+
+// usageReport analyzes the backups of every storage target, or of one
+// database, with the configured retention and prices
+func usageReport(cfg *config.Config, resolver *storageTargets, database string) (*usage.Report, error) {
+	backups, err := storedBackups(resolver, database)
+	if err != nil {
+		return nil, err
+	}
+	return usage.Analyze(backups, cfg.Backup.RetentionDays, cfg.Pricing.S3, time.Now()), nil
+}
+
+// formatCost formats a monthly cost in USD, or "-" when nothing is priced
+func formatCost(cost float64) string {
+	if cost == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("$%.2f", cost)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"db-backuper/internal/backup"
+	"db-backuper/internal/provenance"
+	"db-backuper/internal/storage"
+)
+
+// verifyCommand checks a plain SQL backup for truncation and missing tables by
+// reading it, without restoring it anywhere
+func verifyCommand() (*flag.FlagSet, func() error) {
+	fs, configFlags := newFlagSet("verify", "(-file <path> | -key <key> | -database <name> [-date <YYYY-MM-DD>] | -restore-point <name>) [-json]")
+	file := fs.String("file", "", "Local backup file to verify instead of one from storage")
+	selection := addBackupFlags(fs, "verify")
+	asJSON := fs.Bool("json", false, "Print the verification as JSON")
+	return fs, func() error {
+		if *file == "" {
+			if err := selection.validate(); err != nil {
+				fs.Usage()
+				return fmt.Errorf("specify -file or exactly one of -key, -database or -restore-point")
+			}
+		}
+
+		backupPath := *file
+		if backupPath == "" {
+			cfg, logger, err := loadCommandConfig(configFlags)
+			if err != nil {
+				return err
+			}
+			storageManager, err := newStorageManager(cfg, logger)
+			if err != nil {
+				return err
+			}
+			storageTarget, selected, err := selection.resolve(newStorageTargets(cfg, storageManager, logger))
+			if err != nil {
+				return err
+			}
+			workDir, err := os.MkdirTemp("", "db-backuper-verify-*")
+			if err != nil {
+				return fmt.Errorf("failed to create download directory: %w", err)
+			}
+			defer os.RemoveAll(workDir)
+
+			backupPath = filepath.Join(workDir, path.Base(filepath.ToSlash(selected)))
+			logger.Infof("Downloading %s", selected)
+			if backupPath, err = storage.Fetch(storageTarget.storage, selected, backupPath, cfg.Backup.Transfers()); err != nil {
+				return err
+			}
+			if err := verifyDownload(storageTarget.storage, selected, backupPath); err != nil {
+				return err
+			}
+		}
+		if strings.HasSuffix(backupPath, backup.PostgresDirectorySuffix) || storage.IsDirectoryIndex(backupPath) {
+			return fmt.Errorf("only plain SQL backups can be verified; rehearse directory format backups instead")
+		}
+
+		// Without a manifest only the completion marker can be checked
+		var manifest []provenance.TableStats
+		meta, err := provenance.ReadSidecar(backupPath)
+		if err != nil {
+			return err
+		}
+		if meta != nil {
+			manifest = meta.Tables
+		}
+
+		verification, err := backup.VerifySQLDump(backupPath, manifest)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(verification); err != nil {
+				return err
+			}
+		} else {
+			fmt.Printf("%s: %d tables, complete: %t\n", path.Base(filepath.ToSlash(backupPath)), verification.Tables, verification.Complete)
+			for _, problem := range verification.Problems {
+				fmt.Printf("  %s\n", problem)
+			}
+		}
+		if !verification.OK() {
+			return fmt.Errorf("verification found %d problems", len(verification.Problems))
+		}
+		if len(manifest) == 0 && !*asJSON {
+			fmt.Println("Backup has no table manifest; only its completeness was checked")
+		}
+		return nil
+	}
+}
@@ -249,6 +249,28 @@ resource "aws_iam_policy" "lambda_basic_policy" {
 }
 
 # Attach policies to Lambda role
+# IAM policy for Lambda to publish backup metrics
+resource "aws_iam_policy" "lambda_metrics_policy" {
+  name        = "${var.function_name}-metrics-policy"
+  description = "Policy for Lambda to publish backup metrics to CloudWatch"
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect   = "Allow"
+        Action   = ["cloudwatch:PutMetricData"]
+        Resource = "*"
+        Condition = {
+          StringEquals = {
+            "cloudwatch:namespace" = var.metrics_namespace
+          }
+        }
+      }
+    ]
+  })
+}
+
 resource "aws_iam_role_policy_attachment" "lambda_s3_attachment" {
   role       = aws_iam_role.lambda_role.name
   policy_arn = aws_iam_policy.lambda_s3_policy.arn
@@ -259,6 +281,11 @@ resource "aws_iam_role_policy_attachment" "lambda_basic_attachment" {
   policy_arn = aws_iam_policy.lambda_basic_policy.arn
 }
 
+resource "aws_iam_role_policy_attachment" "lambda_metrics_attachment" {
+  role       = aws_iam_role.lambda_role.name
+  policy_arn = aws_iam_policy.lambda_metrics_policy.arn
+}
+
 # Build Lambda deployment package using Docker
 resource "null_resource" "lambda_build" {
   provisioner "local-exec" {
@@ -313,12 +340,13 @@ resource "aws_lambda_function" "backup_function" {
   environment {
     variables = merge(
       {
-        AWS_BUCKET              = aws_s3_bucket.backup_bucket.bucket
-        BACKUP_RETENTION_DAYS   = var.backup_retention_days
-        BACKUP_SCHEDULE         = "0 */3 * * *" # Every 3 hours
-        BACKUP_PREFIX           = var.backup_prefix
-        LOG_LEVEL               = var.log_level
-        LOG_FORMAT              = "json"
+        AWS_BUCKET                   = aws_s3_bucket.backup_bucket.bucket
+        BACKUP_RETENTION_DAYS        = var.backup_retention_days
+        BACKUP_SCHEDULE              = "0 */3 * * *" # Every 3 hours
+        BACKUP_PREFIX                = var.backup_prefix
+        LOG_LEVEL                    = var.log_level
+        LOG_FORMAT                   = "json"
+        METRICS_CLOUDWATCH_NAMESPACE = var.metrics_namespace
       },
       local.all_database_env_vars
     )
@@ -327,6 +355,7 @@ resource "aws_lambda_function" "backup_function" {
   depends_on = [
     aws_iam_role_policy_attachment.lambda_s3_attachment,
     aws_iam_role_policy_attachment.lambda_basic_attachment,
+    aws_iam_role_policy_attachment.lambda_metrics_attachment,
     aws_cloudwatch_log_group.lambda_logs
   ]
 
@@ -425,3 +454,30 @@ resource "aws_cloudwatch_metric_alarm" "lambda_duration" {
     Project     = "db-backuper"
   }
 }
+
+# CloudWatch alarm per database when its last backup failed
+resource "aws_cloudwatch_metric_alarm" "backup_failed" {
+  for_each = toset([for db in var.databases : db.database])
+
+  alarm_name          = "${var.function_name}-${each.value}-backup-failed"
+  comparison_operator = "LessThanThreshold"
+  evaluation_periods  = "1"
+  metric_name         = "BackupSuccess"
+  namespace           = var.metrics_namespace
+  period              = "10800" # The backup schedule of 3 hours
+  statistic           = "Minimum"
+  threshold           = "1"
+  treat_missing_data  = "breaching"
+  alarm_description   = "The latest backup of ${each.value} failed or did not run"
+  alarm_actions       = var.alarm_sns_topic_arn != "" ? [var.alarm_sns_topic_arn] : []
+
+  dimensions = {
+    Database = each.value
+  }
+
+  tags = {
+    Name        = "${var.function_name}-${each.value}-backup-failed"
+    Environment = var.environment
+    Project     = "db-backuper"
+  }
+}
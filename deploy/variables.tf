@@ -70,6 +70,12 @@ variable "alarm_sns_topic_arn" {
   default     = ""
 }
 
+variable "metrics_namespace" {
+  description = "CloudWatch namespace receiving the backup metrics"
+  type        = string
+  default     = "DBBackup"
+}
+
 # Database configuration variables
 variable "databases" {
   description = "List of databases to backup"
@@ -12,6 +12,7 @@ require (
 	github.com/uptrace/bun v1.2.15
 	github.com/uptrace/bun/dialect/pgdialect v1.2.15
 	github.com/uptrace/bun/driver/pgdriver v1.2.15
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,173 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/runid"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionRestoreDropExisting = "restore_drop_existing"
+	ActionRetentionDelete     = "retention_delete"
+	ActionDelete              = "delete"
+	ActionRestoreCommand      = "restore_command"
+	ActionAbortUpload         = "abort_incomplete_upload"
+	ActionLowSpacePrune       = "low_space_prune"
+	ActionHold                = "hold"
+	ActionReleaseHold         = "release_hold"
+	ActionShare               = "share"
+	ActionRestoreRequest      = "restore_request"
+	ActionRestoreApprove      = "restore_approve"
+	ActionRestoreCancel       = "restore_cancel"
+	ActionRestore             = "restore"
+)
+
+// Event is a single audit log record
+type Event struct {
+	ID      string            `json:"id"`
+	Time    time.Time         `json:"time"`
+	Action  string            `json:"action"`
+	Actor   string            `json:"actor"`
+	Storage string            `json:"storage,omitempty"`
+	Targets []string          `json:"targets"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// ObjectWriter writes immutable objects to remote storage
+type ObjectWriter interface {
+	PutObject(key string, data []byte, contentType string) error
+}
+
+// Log records destructive operations to an append-only file and/or storage objects.
+// A nil *Log is valid and records nothing.
+type Log struct {
+	config  *config.AuditConfig
+	objects ObjectWriter
+	actor   string
+	mu      sync.Mutex
+}
+
+// NewLog creates a new audit log; objects may be nil when no S3 prefix is used.
+// It returns nil when auditing is not configured.
+func NewLog(auditConfig *config.AuditConfig, objects ObjectWriter) *Log {
+	if auditConfig.Path == "" && (auditConfig.S3Prefix == "" || objects == nil) {
+		return nil
+	}
+
+	return &Log{
+		config:  auditConfig,
+		objects: objects,
+		actor:   CurrentActor(),
+	}
+}
+
+// Record writes an event to every configured audit destination
+func (l *Log) Record(event Event) error {
+	if l == nil {
+		return nil
+	}
+
+	if event.ID == "" {
+		event.ID = runid.New()
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	if event.Actor == "" {
+		event.Actor = l.actor
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %w", err)
+	}
+
+	var errs []error
+	if l.config.Path != "" {
+		if err := l.appendFile(data); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if l.config.S3Prefix != "" && l.objects != nil {
+		// One object per event so existing records are never rewritten
+		key := path.Join(l.config.S3Prefix, event.Time.Format("2006-01-02"), fmt.Sprintf("%s_%s.json", event.ID, event.Action))
+		if err := l.objects.PutObject(key, data, "application/json"); err != nil {
+			errs = append(errs, fmt.Errorf("failed to write audit object: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// appendFile appends one JSON line to the audit file
+func (l *Log) appendFile(data []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.config.Path), 0755); err != nil {
+		return fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+
+	file, err := os.OpenFile(l.config.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return file.Sync()
+}
+
+// CurrentActor identifies who is running the process as user@host
+func CurrentActor() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if override := os.Getenv("AUDIT_ACTOR"); override != "" {
+		name = override
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s@%s", name, host)
+}
+
+// ReadFile returns the events of the audit file at path in the order they
+// were recorded, or none when it does not exist yet
+func ReadFile(path string) ([]Event, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	var events []Event
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			return nil, fmt.Errorf("invalid audit log line %d: %w", i+1, err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
@@ -0,0 +1,123 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// archiveEntry is a directory stored under Name inside an archive. Paths
+// matching an Exclude pattern, relative to Dir or by base name, are skipped.
+type archiveEntry struct {
+	Name    string
+	Dir     string
+	Exclude []string
+}
+
+// writeTarGz writes the given directories into a gzip compressed tar file
+func writeTarGz(dest string, entries []archiveEntry) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	file, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	for _, entry := range entries {
+		if err := addDirectory(tw, entry); err != nil {
+			return fmt.Errorf("failed to archive %s: %w", entry.Dir, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to finish compression: %w", err)
+	}
+	return file.Close()
+}
+
+// addDirectory adds every file below entry.Dir to the archive
+func addDirectory(tw *tar.Writer, entry archiveEntry) error {
+	return filepath.WalkDir(entry.Dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(entry.Dir, p)
+		if err != nil {
+			return err
+		}
+		if rel != "." && excluded(filepath.ToSlash(rel), entry.Exclude) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := path.Join(entry.Name, filepath.ToSlash(rel))
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if d.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		// Copy exactly the size in the header so a file growing meanwhile
+		// cannot corrupt the archive
+		_, err = io.CopyN(tw, f, header.Size)
+		return err
+	})
+}
+
+// excluded reports whether a relative path matches any exclude pattern
+func excluded(rel string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/progress"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CassandraBackup handles Cassandra and ScyllaDB keyspace backups using nodetool snapshots
+type CassandraBackup struct {
+	config *config.DatabaseConfig
+	logger logrus.FieldLogger
+}
+
+// NewCassandraBackup creates a new Cassandra backup instance
+func NewCassandraBackup(dbConfig *config.DatabaseConfig, logger logrus.FieldLogger) *CassandraBackup {
+	return &CassandraBackup{
+		config: dbConfig,
+		logger: logger,
+	}
+}
+
+// WithLogger returns a copy of the backup instance that logs through logger
+func (cb *CassandraBackup) WithLogger(logger logrus.FieldLogger) Engine {
+	return &CassandraBackup{
+		config: cb.config,
+		logger: logger,
+	}
+}
+
+// DatabaseName returns the keyspace being backed up
+func (cb *CassandraBackup) DatabaseName() string {
+	return cb.config.Database
+}
+
+// TestConnection checks that nodetool can reach the node and the keyspace's data is readable
+func (cb *CassandraBackup) TestConnection() error {
+	cb.logger.Infof("Testing nodetool connection")
+
+	if err := cb.nodetool("info"); err != nil {
+		return fmt.Errorf("nodetool connection test failed: %w", err)
+	}
+
+	keyspaceDir := filepath.Join(cb.config.Cassandra.DataDirectory(), cb.config.Database)
+	if _, err := os.Stat(keyspaceDir); err != nil {
+		return fmt.Errorf("keyspace data directory is not accessible: %w", err)
+	}
+
+	cb.logger.Infof("Cassandra connection test successful")
+	return nil
+}
+
+// CreateBackup snapshots the keyspace, archives the snapshot and returns the archive path
+func (cb *CassandraBackup) CreateBackup() (string, error) {
+	timestamp := time.Now().Format("2006-01-02_15-04-05")
+	node := cb.config.Cassandra.NodeName()
+	tag := "db-backuper-" + timestamp
+	// The node name keeps artifacts from different nodes of a cluster apart
+	backupPath := filepath.Join(TempDir, fmt.Sprintf("%s_%s_%s.tar.gz", cb.config.Database, timestamp, node))
+
+	hookEnv := []string{
+		"DB_BACKUP_KEYSPACE=" + cb.config.Database,
+		"DB_BACKUP_SNAPSHOT_TAG=" + tag,
+		"DB_BACKUP_NODE=" + node,
+	}
+
+	if hook := cb.config.Cassandra.PreSnapshotHook; hook != "" {
+		cb.logger.Infof("Running pre-snapshot hook")
+		if err := runHook(hook, hookEnv, cb.logger); err != nil {
+			return "", fmt.Errorf("pre-snapshot hook failed: %w", err)
+		}
+	}
+
+	cb.logger.Infof("Taking snapshot %s of keyspace %s", tag, cb.config.Database)
+	if err := cb.nodetool("snapshot", "-t", tag, cb.config.Database); err != nil {
+		return "", fmt.Errorf("nodetool snapshot failed: %w", err)
+	}
+	// Snapshots are hard links that pin SSTables on disk; always release them
+	defer func() {
+		if err := cb.nodetool("clearsnapshot", "-t", tag, "--", cb.config.Database); err != nil {
+			cb.logger.Warnf("Failed to clear snapshot %s: %v", tag, err)
+		}
+	}()
+
+	entries, err := cb.snapshotDirectories(tag)
+	if err != nil {
+		return "", err
+	}
+
+	cb.logger.Infof("Archiving %d table snapshots: %s", len(entries), backupPath)
+	reporter := progress.StartFileReporter(backupPath, fmt.Sprintf("Backup of %s", cb.config.Database), progress.DefaultInterval, cb.logger)
+	defer reporter.Stop()
+
+	if err := writeTarGz(backupPath, entries); err != nil {
+		os.Remove(backupPath)
+		return "", err
+	}
+	reporter.Finish()
+
+	if hook := cb.config.Cassandra.PostSnapshotHook; hook != "" {
+		cb.logger.Infof("Running post-snapshot hook")
+		if err := runHook(hook, append(hookEnv, "DB_BACKUP_FILE="+backupPath), cb.logger); err != nil {
+			cb.logger.Warnf("Post-snapshot hook failed: %v", err)
+		}
+	}
+
+	cb.logger.Infof("Cassandra backup completed successfully: %s", backupPath)
+	return backupPath, nil
+}
+
+// CleanupBackup removes the temporary archive
+func (cb *CassandraBackup) CleanupBackup(backupPath string) error {
+	return removeBackupFile(backupPath, cb.logger)
+}
+
+// snapshotDirectories finds the snapshot directory of every table in the keyspace.
+// Each is archived under its table directory name.
+func (cb *CassandraBackup) snapshotDirectories(tag string) ([]archiveEntry, error) {
+	pattern := filepath.Join(cb.config.Cassandra.DataDirectory(), cb.config.Database, "*", "snapshots", tag)
+	dirs, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find snapshot directories: %w", err)
+	}
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("no snapshot directories found matching %s", pattern)
+	}
+
+	entries := make([]archiveEntry, len(dirs))
+	for i, dir := range dirs {
+		tableDir := filepath.Base(filepath.Dir(filepath.Dir(dir)))
+		entries[i] = archiveEntry{Name: tableDir, Dir: dir}
+	}
+	return entries, nil
+}
+
+// nodetool runs a nodetool command against the configured node
+func (cb *CassandraBackup) nodetool(args ...string) error {
+	var connArgs []string
+	if cb.config.Host != "" {
+		connArgs = append(connArgs, "-h", cb.config.Host)
+	}
+	if cb.config.Port != 0 {
+		connArgs = append(connArgs, "-p", fmt.Sprintf("%d", cb.config.Port))
+	}
+	if cb.config.Username != "" {
+		// Pass JMX credentials through a password file so they stay out of the process list
+		passwordFile, err := os.CreateTemp("", "db-backuper-jmx-*")
+		if err != nil {
+			return fmt.Errorf("failed to create JMX password file: %w", err)
+		}
+		defer os.Remove(passwordFile.Name())
+		if _, err := fmt.Fprintf(passwordFile, "%s %s\n", cb.config.Username, cb.config.Password); err != nil {
+			passwordFile.Close()
+			return fmt.Errorf("failed to write JMX password file: %w", err)
+		}
+		passwordFile.Close()
+		connArgs = append(connArgs, "-u", cb.config.Username, "-pwf", passwordFile.Name())
+	}
+
+	cmd := exec.Command(cb.config.Cassandra.NodetoolPath(), append(connArgs, args...)...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w\nOutput: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// runHook runs a shell hook with extra environment variables
+func runHook(hook string, env []string, logger logrus.FieldLogger) error {
+	cmd := exec.Command("sh", "-c", hook)
+	cmd.Env = append(os.Environ(), env...)
+	return progress.RunStreaming(cmd, logger, "hook")
+}
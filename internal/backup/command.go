@@ -0,0 +1,230 @@
+package backup
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/progress"
+
+	"github.com/sirupsen/logrus"
+)
+
+// placeholderPattern matches {name} placeholders; ${name} is left for the shell
+var placeholderPattern = regexp.MustCompile(`\$?\{[a-z_]+\}`)
+
+// CommandBackup runs a user-defined dump command for datastores without a built-in engine
+type CommandBackup struct {
+	config *config.DatabaseConfig
+	logger logrus.FieldLogger
+}
+
+// NewCommandBackup creates a new command backup instance
+func NewCommandBackup(dbConfig *config.DatabaseConfig, logger logrus.FieldLogger) *CommandBackup {
+	return &CommandBackup{
+		config: dbConfig,
+		logger: logger,
+	}
+}
+
+// WithLogger returns a copy of the backup instance that logs through logger
+func (cb *CommandBackup) WithLogger(logger logrus.FieldLogger) Engine {
+	return &CommandBackup{
+		config: cb.config,
+		logger: logger,
+	}
+}
+
+// DatabaseName returns the name of the database being backed up
+func (cb *CommandBackup) DatabaseName() string {
+	return cb.config.Database
+}
+
+// TestConnection checks that the dump command template only uses known placeholders.
+// The command itself is only run as part of a backup.
+func (cb *CommandBackup) TestConnection() error {
+	values := CommandPlaceholders(cb.config)
+	values["output"] = ""
+	values["timestamp"] = ""
+	if _, err := ExpandCommand(cb.config.Command.Dump, values); err != nil {
+		return fmt.Errorf("invalid dump command: %w", err)
+	}
+	return nil
+}
+
+// CreateBackup runs the dump command and returns the backup path
+func (cb *CommandBackup) CreateBackup() (string, error) {
+	timestamp := time.Now().Format("2006-01-02_15-04-05")
+	ext := strings.TrimSuffix(cb.config.Command.FileExtension(), ".gz")
+	dumpPath := filepath.Join(TempDir, fmt.Sprintf("%s_%s.%s", cb.config.Database, timestamp, ext))
+
+	if err := os.MkdirAll(filepath.Dir(dumpPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	values := CommandPlaceholders(cb.config)
+	values["output"] = dumpPath
+	values["timestamp"] = timestamp
+	script, err := ExpandCommand(cb.config.Command.Dump, values)
+	if err != nil {
+		return "", fmt.Errorf("invalid dump command: %w", err)
+	}
+
+	cb.logger.Infof("Running dump command: %s", script)
+	reporter := progress.StartFileReporter(dumpPath, fmt.Sprintf("Backup of %s", cb.config.Database), progress.DefaultInterval, cb.logger)
+	defer reporter.Stop()
+
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Env = append(os.Environ(), CommandEnv(cb.config)...)
+	if UsesPlaceholder(cb.config.Command.Dump, "output") {
+		err = progress.RunStreaming(cmd, cb.logger, "dump")
+	} else {
+		// Without {output} the dump is whatever the command writes to stdout
+		err = runToFile(cmd, dumpPath, cb.logger)
+	}
+	if err != nil {
+		os.Remove(dumpPath)
+		return "", fmt.Errorf("dump command failed: %w", err)
+	}
+
+	if _, err := os.Stat(dumpPath); err != nil {
+		return "", fmt.Errorf("dump command did not create %s: %w", dumpPath, err)
+	}
+	reporter.Finish()
+
+	if !cb.config.Command.Compress {
+		cb.logger.Infof("Command backup completed successfully: %s", dumpPath)
+		return dumpPath, nil
+	}
+
+	backupPath := dumpPath + ".gz"
+	err = gzipFile(dumpPath, backupPath)
+	os.Remove(dumpPath)
+	if err != nil {
+		os.Remove(backupPath)
+		return "", fmt.Errorf("failed to compress dump: %w", err)
+	}
+
+	cb.logger.Infof("Command backup completed successfully: %s", backupPath)
+	return backupPath, nil
+}
+
+// CleanupBackup removes the temporary backup file
+func (cb *CommandBackup) CleanupBackup(backupPath string) error {
+	return removeBackupFile(backupPath, cb.logger)
+}
+
+// CommandPlaceholders returns the connection placeholders shared by dump and restore templates
+func CommandPlaceholders(dbConfig *config.DatabaseConfig) map[string]string {
+	return map[string]string{
+		"database": dbConfig.Database,
+		"host":     dbConfig.Host,
+		"port":     strconv.Itoa(dbConfig.Port),
+		"username": dbConfig.Username,
+	}
+}
+
+// CommandEnv returns the environment passed to dump and restore commands. The
+// password is only available here so it never appears in the command line.
+func CommandEnv(dbConfig *config.DatabaseConfig) []string {
+	return []string{
+		"DB_BACKUP_DATABASE=" + dbConfig.Database,
+		"DB_BACKUP_HOST=" + dbConfig.Host,
+		"DB_BACKUP_PORT=" + strconv.Itoa(dbConfig.Port),
+		"DB_BACKUP_USERNAME=" + dbConfig.Username,
+		"DB_BACKUP_PASSWORD=" + dbConfig.Password,
+	}
+}
+
+// ExpandCommand replaces {name} placeholders with shell-quoted values
+func ExpandCommand(template string, values map[string]string) (string, error) {
+	var unknown []string
+	expanded := placeholderPattern.ReplaceAllStringFunc(template, func(match string) string {
+		if strings.HasPrefix(match, "$") {
+			return match
+		}
+		value, ok := values[strings.Trim(match, "{}")]
+		if !ok {
+			unknown = append(unknown, match)
+			return match
+		}
+		return shellQuote(value)
+	})
+
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("unknown placeholders: %s", strings.Join(unknown, ", "))
+	}
+	return expanded, nil
+}
+
+// UsesPlaceholder reports whether a template contains {name}
+func UsesPlaceholder(template, name string) bool {
+	for _, match := range placeholderPattern.FindAllString(template, -1) {
+		if match == "{"+name+"}" {
+			return true
+		}
+	}
+	return false
+}
+
+// shellQuote quotes s as a single POSIX shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runToFile runs a command writing its stdout to path and its stderr to the logger
+func runToFile(cmd *exec.Cmd, path string, logger logrus.FieldLogger) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create dump file: %w", err)
+	}
+	defer file.Close()
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("failed to capture command output: %w", err)
+	}
+	cmd.Stdout = file
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start command: %w", err)
+	}
+
+	tail := progress.StreamLines(stderr, logger, "dump", 20)
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%w\nOutput: %s", err, strings.Join(tail, "\n"))
+	}
+	return file.Close()
+}
+
+// gzipFile compresses src into dst
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,144 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/provenance"
+	"db-backuper/internal/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TempDir is where backups are written before they are handed to storage,
+// under the temporary directory of the platform
+var TempDir = filepath.Join(os.TempDir(), "db-backuper")
+
+// Engine creates backup files for a single configured database
+type Engine interface {
+	DatabaseName() string
+	TestConnection() error
+	CreateBackup() (string, error)
+	CleanupBackup(backupPath string) error
+	WithLogger(logger logrus.FieldLogger) Engine
+}
+
+// Versioned is implemented by engines that can report the version of the
+// server they back up
+type Versioned interface {
+	ServerVersion() (string, error)
+}
+
+// Manifested is implemented by engines that record the tables of the backup
+// they last created
+type Manifested interface {
+	Tables() []provenance.TableStats
+}
+
+// Provenance describes the backup written to backupPath for storage metadata.
+// A server version that cannot be read is left out rather than failing the backup.
+func Provenance(engine Engine, dbConfig *config.DatabaseConfig, backupPath string, logger logrus.FieldLogger) (*provenance.Metadata, error) {
+	meta, err := provenance.Describe(backupPath)
+	if err != nil {
+		return nil, err
+	}
+	meta.Database = engine.DatabaseName()
+
+	if dbConfig != nil {
+		meta.Engine = dbConfig.EngineType()
+		switch dbConfig.EngineType() {
+		case config.EngineTypeRedis:
+			meta.SourceHost = dbConfig.Redis.Host
+		case config.EngineTypeCassandra:
+			meta.SourceHost = dbConfig.Cassandra.NodeName()
+		default:
+			meta.SourceHost = dbConfig.Host
+		}
+	}
+	if meta.SourceHost == "" {
+		// File based engines back up a path on the machine running the service
+		meta.SourceHost, _ = os.Hostname()
+	}
+
+	if versioned, ok := engine.(Versioned); ok {
+		version, err := versioned.ServerVersion()
+		if err != nil {
+			logger.Warnf("Failed to read server version for backup metadata: %v", err)
+		} else {
+			meta.ServerVersion = version
+		}
+	}
+	if manifested, ok := engine.(Manifested); ok {
+		meta.Tables = manifested.Tables()
+	}
+	return meta, nil
+}
+
+// Split splits the backup at backupPath into parts of at most partSize
+// bytes and returns the path of their index, which replaces the backup.
+// Backups no larger than partSize, or already stored as several objects, are
+// returned unchanged, as are all backups when partSize is 0.
+func Split(backupPath string, partSize int64, logger logrus.FieldLogger) (string, error) {
+	if partSize <= 0 || storage.IsIndex(backupPath) {
+		return backupPath, nil
+	}
+	info, err := os.Stat(backupPath)
+	if err != nil || info.Size() <= partSize {
+		return backupPath, nil
+	}
+
+	indexPath, err := storage.SplitFile(backupPath, partSize)
+	if err != nil {
+		storage.RemoveDirectoryBackup(backupPath + storage.PartsIndexSuffix)
+		return "", fmt.Errorf("failed to split backup: %w", err)
+	}
+	if err := os.Remove(backupPath); err != nil {
+		logger.Warnf("Failed to remove backup after splitting it: %v", err)
+	}
+	logger.Infof("Split backup of %d bytes into parts of at most %d bytes", info.Size(), partSize)
+	return indexPath, nil
+}
+
+// NewEngine creates the backup engine for the database's configured type
+func NewEngine(dbConfig *config.DatabaseConfig, logger logrus.FieldLogger) (Engine, error) {
+	switch dbConfig.EngineType() {
+	case config.EngineTypePostgres:
+		return NewPostgresBackup(dbConfig, logger), nil
+	case config.EngineTypeSQLite:
+		return NewSQLiteBackup(dbConfig, logger), nil
+	case config.EngineTypeRedis:
+		return NewRedisBackup(dbConfig, logger), nil
+	case config.EngineTypeMSSQL:
+		return NewMSSQLBackup(dbConfig, logger), nil
+	case config.EngineTypeCassandra:
+		return NewCassandraBackup(dbConfig, logger), nil
+	case config.EngineTypeCommand:
+		return NewCommandBackup(dbConfig, logger), nil
+	case config.EngineTypeFilesystem:
+		return NewFilesystemBackup(dbConfig, logger), nil
+	default:
+		return nil, fmt.Errorf("unsupported database type: %s", dbConfig.Type)
+	}
+}
+
+// removeBackupFile removes a temporary backup file, ignoring files that no longer exist
+func removeBackupFile(backupPath string, logger logrus.FieldLogger) error {
+	if backupPath == "" {
+		return nil
+	}
+
+	logger.Infof("Cleaning up backup file: %s", backupPath)
+
+	if storage.IsIndex(backupPath) {
+		if err := storage.RemoveDirectoryBackup(backupPath); err != nil {
+			return fmt.Errorf("failed to remove backup files: %w", err)
+		}
+		return nil
+	}
+	if err := os.Remove(backupPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove backup file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package backup
+
+import (
+	"fmt"
+
+	"db-backuper/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SnapshotPinner is implemented by engines that can share a snapshot with the
+// other members of a backup group
+type SnapshotPinner interface {
+	SnapshotKey() string
+	PinSnapshot(importID string) (string, error)
+	ReleaseSnapshot() error
+}
+
+// Quiescer is implemented by engines that can pause application writes
+type Quiescer interface {
+	Quiesce() (func(), error)
+}
+
+// PinGroups pins a snapshot for every member of each group with
+// shared_snapshot enabled before any of them is dumped. Members of the same
+// database import one exported snapshot; members of different databases get
+// snapshots taken back to back. It returns a function releasing every
+// snapshot and the error for each database whose group could not be pinned.
+func PinGroups(groups []config.GroupConfig, engines []Engine, logger logrus.FieldLogger) (func(), map[string]error) {
+	byName := make(map[string]Engine, len(engines))
+	for _, engine := range engines {
+		byName[engine.DatabaseName()] = engine
+	}
+
+	var pinned []SnapshotPinner
+	release := func() {
+		for _, pinner := range pinned {
+			if err := pinner.ReleaseSnapshot(); err != nil {
+				logger.Warnf("Failed to release snapshot: %v", err)
+			}
+		}
+	}
+
+	failed := make(map[string]error)
+	for _, group := range groups {
+		if !group.SharedSnapshot {
+			continue
+		}
+
+		groupLogger := logger.WithField("group", group.Name)
+		exported := make(map[string]string)
+		var groupPinned []SnapshotPinner
+		var members []string
+		var err error
+
+		// Pause writes on every member first so all snapshots see the same state
+		var resumes []func()
+		for _, name := range group.Databases {
+			if quiescer, ok := byName[name].(Quiescer); ok && err == nil {
+				var resume func()
+				if resume, err = quiescer.Quiesce(); err != nil {
+					err = fmt.Errorf("failed to quiesce %s: %w", name, err)
+				} else {
+					resumes = append(resumes, resume)
+				}
+			}
+		}
+
+		for _, name := range group.Databases {
+			engine, ok := byName[name]
+			if !ok {
+				// Not part of this run, e.g. filtered out with -database
+				continue
+			}
+			members = append(members, name)
+			if err != nil {
+				continue
+			}
+
+			pinner, ok := engine.(SnapshotPinner)
+			if !ok {
+				err = fmt.Errorf("database %s does not support shared snapshots", name)
+				continue
+			}
+
+			key := pinner.SnapshotKey()
+			var id string
+			if id, err = pinner.PinSnapshot(exported[key]); err != nil {
+				err = fmt.Errorf("failed to pin snapshot for %s: %w", name, err)
+				continue
+			}
+			if exported[key] == "" {
+				exported[key] = id
+			}
+			groupPinned = append(groupPinned, pinner)
+		}
+
+		for _, resume := range resumes {
+			resume()
+		}
+
+		if err != nil {
+			groupLogger.Errorf("Shared snapshot failed, skipping group: %v", err)
+			for _, pinner := range groupPinned {
+				pinner.ReleaseSnapshot()
+			}
+			for _, name := range members {
+				failed[name] = fmt.Errorf("shared snapshot for group %s failed: %w", group.Name, err)
+			}
+			continue
+		}
+
+		groupLogger.Infof("Pinned shared snapshot for %d databases", len(groupPinned))
+		pinned = append(pinned, groupPinned...)
+	}
+
+	return release, failed
+}
@@ -0,0 +1,186 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/progress"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MSSQLBackup handles SQL Server backups using sqlcmd or sqlpackage
+type MSSQLBackup struct {
+	config *config.DatabaseConfig
+	logger logrus.FieldLogger
+}
+
+// NewMSSQLBackup creates a new SQL Server backup instance
+func NewMSSQLBackup(dbConfig *config.DatabaseConfig, logger logrus.FieldLogger) *MSSQLBackup {
+	return &MSSQLBackup{
+		config: dbConfig,
+		logger: logger,
+	}
+}
+
+// WithLogger returns a copy of the backup instance that logs through logger
+func (mb *MSSQLBackup) WithLogger(logger logrus.FieldLogger) Engine {
+	return &MSSQLBackup{
+		config: mb.config,
+		logger: logger,
+	}
+}
+
+// DatabaseName returns the name of the database being backed up
+func (mb *MSSQLBackup) DatabaseName() string {
+	return mb.config.Database
+}
+
+// TestConnection checks that the server accepts the configured credentials
+func (mb *MSSQLBackup) TestConnection() error {
+	mb.logger.Infof("Testing SQL Server connection to %s:%d", mb.config.Host, mb.config.Port)
+
+	output, err := SQLCmd(mb.config, "SET NOCOUNT ON; SELECT 1").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("sql server connection test failed: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	mb.logger.Infof("SQL Server connection test successful")
+	return nil
+}
+
+// CreateBackup creates a database backup and returns the backup path
+func (mb *MSSQLBackup) CreateBackup() (string, error) {
+	timestamp := time.Now().Format("2006-01-02_15-04-05")
+
+	if mb.config.MSSQL.BackupMethod() == config.MSSQLMethodBacpac {
+		return mb.exportBacpac(filepath.Join(TempDir, fmt.Sprintf("%s_%s.bacpac", mb.config.Database, timestamp)))
+	}
+	return mb.backupDatabase(fmt.Sprintf("%s_%s.bak", mb.config.Database, timestamp))
+}
+
+// backupDatabase runs BACKUP DATABASE into the shared backup directory
+func (mb *MSSQLBackup) backupDatabase(filename string) (string, error) {
+	serverPath := mb.config.MSSQL.ServerPath(filename)
+	localPath := filepath.Join(mb.config.MSSQL.LocalDir(), filename)
+
+	mb.logger.Infof("Creating SQL Server backup: %s (server path: %s)", localPath, serverPath)
+
+	// COPY_ONLY keeps the backup out of the server's differential and log chain
+	query := fmt.Sprintf("BACKUP DATABASE %s TO DISK = %s WITH COPY_ONLY, INIT, CHECKSUM, STATS = 10",
+		QuoteMSSQLName(mb.config.Database), QuoteMSSQLString(serverPath))
+	if err := progress.RunStreaming(SQLCmd(mb.config, query), mb.logger, "sqlcmd"); err != nil {
+		return "", fmt.Errorf("sql server backup failed: %w", err)
+	}
+
+	if _, err := os.Stat(localPath); err != nil {
+		return "", fmt.Errorf("backup file is not visible at %s, check local_backup_dir: %w", localPath, err)
+	}
+
+	mb.logger.Infof("SQL Server backup completed successfully: %s", localPath)
+	return localPath, nil
+}
+
+// exportBacpac exports the database schema and data with sqlpackage
+func (mb *MSSQLBackup) exportBacpac(backupPath string) (string, error) {
+	mb.logger.Infof("Exporting SQL Server bacpac: %s", backupPath)
+
+	if err := os.MkdirAll(filepath.Dir(backupPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	cmd, cleanup, err := SQLPackage(mb.config, "Source", mb.config.Database, "/Action:Export", "/TargetFile:"+backupPath)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	reporter := progress.StartFileReporter(backupPath, fmt.Sprintf("Backup of %s", mb.config.Database), progress.DefaultInterval, mb.logger)
+	defer reporter.Stop()
+
+	if err := progress.RunStreaming(cmd, mb.logger, "sqlpackage"); err != nil {
+		os.Remove(backupPath)
+		return "", fmt.Errorf("sqlpackage export failed: %w", err)
+	}
+
+	reporter.Finish()
+	mb.logger.Infof("SQL Server bacpac export completed successfully: %s", backupPath)
+	return backupPath, nil
+}
+
+// CleanupBackup removes the backup file from the temporary or shared directory
+func (mb *MSSQLBackup) CleanupBackup(backupPath string) error {
+	return removeBackupFile(backupPath, mb.logger)
+}
+
+// SQLCmd builds a sqlcmd command running query; the password is passed via
+// SQLCMDPASSWORD so it never appears in the process list
+func SQLCmd(dbConfig *config.DatabaseConfig, query string) *exec.Cmd {
+	args := append(dbConfig.GetSQLCmdArgs(), "-d", "master", "-Q", query)
+	cmd := exec.Command("sqlcmd", args...)
+	cmd.Env = append(os.Environ(), "SQLCMDPASSWORD="+dbConfig.Password)
+	return cmd
+}
+
+// SQLPackage builds a sqlpackage command running action with the connection
+// of side. The password is written to a response file readable only by the
+// current user, so it never appears in the process list; call cleanup once
+// the command has finished to remove it.
+func SQLPackage(dbConfig *config.DatabaseConfig, side, database string, action ...string) (cmd *exec.Cmd, cleanup func(), err error) {
+	file, err := os.CreateTemp("", "db-backuper-sqlpackage-*.rsp")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create sqlpackage response file: %w", err)
+	}
+	cleanup = func() { os.Remove(file.Name()) }
+
+	// CreateTemp creates the file with mode 0600
+	line := quoteResponseArg(fmt.Sprintf("/%sPassword:%s", side, dbConfig.Password)) + "\n"
+	_, writeErr := file.WriteString(line)
+	if err := errors.Join(writeErr, file.Close()); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to write sqlpackage response file: %w", err)
+	}
+
+	args := append(append(append([]string{}, action...), dbConfig.GetSQLPackageArgs(side, database)...), "@"+file.Name())
+	return exec.Command("sqlpackage", args...), cleanup, nil
+}
+
+// quoteResponseArg quotes an argument of a response file, escaping
+// backslashes before quotes and the quotes themselves
+func quoteResponseArg(arg string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	backslashes := 0
+	for _, r := range arg {
+		switch r {
+		case '\\':
+			backslashes++
+			continue
+		case '"':
+			b.WriteString(strings.Repeat(`\`, backslashes*2+1))
+		default:
+			b.WriteString(strings.Repeat(`\`, backslashes))
+		}
+		backslashes = 0
+		b.WriteRune(r)
+	}
+	b.WriteString(strings.Repeat(`\`, backslashes*2))
+	b.WriteByte('"')
+	return b.String()
+}
+
+// QuoteMSSQLName quotes an identifier for T-SQL
+func QuoteMSSQLName(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
+
+// QuoteMSSQLString quotes a Unicode string literal for T-SQL
+func QuoteMSSQLString(s string) string {
+	return "N'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -10,7 +10,12 @@ import (
 	"strings"
 	"time"
 
+	"db-backuper/internal/compliance"
 	"db-backuper/internal/config"
+	"db-backuper/internal/progress"
+	"db-backuper/internal/provenance"
+	"db-backuper/internal/rdsauth"
+	"db-backuper/internal/schemadiff"
 
 	"github.com/sirupsen/logrus"
 	"github.com/uptrace/bun"
@@ -20,17 +25,172 @@ import (
 
 // PostgresBackup handles PostgreSQL database backups using bun ORM
 type PostgresBackup struct {
-	config *config.DatabaseConfig
-	logger *logrus.Logger
-	db     *bun.DB
+	config   *config.DatabaseConfig
+	logger   logrus.FieldLogger
+	db       *bun.DB
+	q        bun.IDB
+	snapshot *pinnedSnapshot
+	tables   []provenance.TableStats
+}
+
+// pinnedSnapshot is a transaction opened ahead of the dump so that the
+// members of a backup group see their data as of the same moment
+type pinnedSnapshot struct {
+	db *bun.DB
+	tx *bun.Tx
+	id string
 }
 
 // NewPostgresBackup creates a new PostgreSQL backup instance
-func NewPostgresBackup(dbConfig *config.DatabaseConfig, logger *logrus.Logger) *PostgresBackup {
+func NewPostgresBackup(dbConfig *config.DatabaseConfig, logger logrus.FieldLogger) *PostgresBackup {
+	return &PostgresBackup{
+		config:   dbConfig,
+		logger:   logger,
+		snapshot: &pinnedSnapshot{},
+	}
+}
+
+// WithLogger returns a copy of the backup instance that logs through logger.
+// The copy shares any pinned snapshot with the original.
+func (pb *PostgresBackup) WithLogger(logger logrus.FieldLogger) Engine {
 	return &PostgresBackup{
-		config: dbConfig,
-		logger: logger,
+		config:   pb.config,
+		logger:   logger,
+		snapshot: pb.snapshot,
+	}
+}
+
+// SnapshotKey identifies the database a snapshot can be shared within
+func (pb *PostgresBackup) SnapshotKey() string {
+	return fmt.Sprintf("%s:%d/%s", pb.config.Host, pb.config.Port, pb.config.Database)
+}
+
+// PinSnapshot opens the repeatable read transaction the next backup will use
+// and returns its exported snapshot ID. When importID is set the transaction
+// adopts that snapshot instead of taking its own.
+func (pb *PostgresBackup) PinSnapshot(importID string) (string, error) {
+	// The transaction outlives this call, so it must not be bound to a deadline
+	ctx := context.Background()
+
+	dsn, err := pb.buildConnectionString()
+	if err != nil {
+		return "", err
+	}
+	sqldb := sql.OpenDB(newConnector(dsn))
+	db := bun.NewDB(sqldb, pgdialect.New())
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		db.Close()
+		return "", fmt.Errorf("failed to begin snapshot transaction: %w", err)
+	}
+
+	if importID != "" {
+		if _, err := tx.ExecContext(ctx, "SET TRANSACTION SNAPSHOT ?", importID); err != nil {
+			tx.Rollback()
+			db.Close()
+			return "", fmt.Errorf("failed to import snapshot %s: %w", importID, err)
+		}
+	}
+
+	// Exporting also fixes the transaction's snapshot at this moment
+	var snapshotID string
+	if err := tx.QueryRowContext(ctx, "SELECT pg_export_snapshot()").Scan(&snapshotID); err != nil {
+		tx.Rollback()
+		db.Close()
+		return "", fmt.Errorf("failed to export snapshot: %w", err)
+	}
+
+	pb.snapshot.db = db
+	pb.snapshot.tx = &tx
+	pb.snapshot.id = snapshotID
+	pb.logger.Infof("Pinned snapshot %s", snapshotID)
+	return snapshotID, nil
+}
+
+// Quiesce runs the configured pre-backup actions on a dedicated connection
+// and returns a function undoing them. Applications that take the advisory
+// lock in shared mode around their writes pause while it is held.
+func (pb *PostgresBackup) Quiesce() (func(), error) {
+	quiesce := &pb.config.Quiesce
+	if !quiesce.Enabled() {
+		return func() {}, nil
+	}
+
+	ctx := context.Background()
+	dsn, err := pb.buildConnectionString()
+	if err != nil {
+		return nil, err
+	}
+	sqldb := sql.OpenDB(newConnector(dsn))
+	db := bun.NewDB(sqldb, pgdialect.New())
+
+	// Session-level advisory locks belong to one connection, so pin one
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open quiesce connection: %w", err)
+	}
+
+	locked := false
+	start := time.Now()
+	resume := func() {
+		for _, statement := range quiesce.PostSQL {
+			if _, err := conn.ExecContext(ctx, statement); err != nil {
+				pb.logger.Warnf("Post-backup statement failed: %v", err)
+			}
+		}
+		if locked {
+			if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock(?)", *quiesce.AdvisoryLock); err != nil {
+				pb.logger.Warnf("Failed to release advisory lock %d: %v", *quiesce.AdvisoryLock, err)
+			}
+		}
+		conn.Close()
+		db.Close()
+		pb.logger.Infof("Resumed application writes after %v", time.Since(start).Round(time.Millisecond))
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, quiesce.Timeout())
+	defer cancel()
+
+	if quiesce.AdvisoryLock != nil {
+		pb.logger.Infof("Acquiring advisory lock %d to pause application writes", *quiesce.AdvisoryLock)
+		if _, err := conn.ExecContext(timeoutCtx, "SELECT pg_advisory_lock(?)", *quiesce.AdvisoryLock); err != nil {
+			resume()
+			return nil, fmt.Errorf("failed to acquire advisory lock %d: %w", *quiesce.AdvisoryLock, err)
+		}
+		locked = true
 	}
+
+	for _, statement := range quiesce.PreSQL {
+		if _, err := conn.ExecContext(timeoutCtx, statement); err != nil {
+			resume()
+			return nil, fmt.Errorf("pre-backup statement failed: %w", err)
+		}
+	}
+
+	return resume, nil
+}
+
+// ReleaseSnapshot ends the pinned snapshot transaction, if any
+func (pb *PostgresBackup) ReleaseSnapshot() error {
+	if pb.snapshot.tx == nil {
+		return nil
+	}
+
+	err := pb.snapshot.tx.Rollback()
+	if closeErr := pb.snapshot.db.Close(); err == nil {
+		err = closeErr
+	}
+	pb.snapshot.tx = nil
+	pb.snapshot.db = nil
+	pb.snapshot.id = ""
+	return err
+}
+
+// DatabaseName returns the name of the database being backed up
+func (pb *PostgresBackup) DatabaseName() string {
+	return pb.config.Database
 }
 
 // connect establishes a database connection using bun
@@ -40,12 +200,15 @@ func (pb *PostgresBackup) connect(ctx context.Context) error {
 	}
 
 	// Build DSN connection string
-	dsn := pb.buildConnectionString()
+	dsn, err := pb.buildConnectionString()
+	if err != nil {
+		return err
+	}
 	pb.logger.Infof("Connecting to database using bun ORM")
 	pb.logger.Infof("DSN: %s", pb.maskPassword(dsn))
 
 	// Create bun database connection
-	sqldb := sql.OpenDB(pgdriver.NewConnector(pgdriver.WithDSN(dsn)))
+	sqldb := sql.OpenDB(newConnector(dsn))
 	db := bun.NewDB(sqldb, pgdialect.New())
 
 	// Test connection
@@ -69,6 +232,87 @@ func (pb *PostgresBackup) close() error {
 	return nil
 }
 
+// ServerVersion returns the version reported by the PostgreSQL server
+func (pb *PostgresBackup) ServerVersion() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := pb.connect(ctx); err != nil {
+		return "", err
+	}
+	defer pb.close()
+
+	var version string
+	if err := pb.db.QueryRowContext(ctx, "SHOW server_version").Scan(&version); err != nil {
+		return "", fmt.Errorf("failed to read server version: %w", err)
+	}
+	return version, nil
+}
+
+// Tables returns the manifest of the tables in the last backup
+func (pb *PostgresBackup) Tables() []provenance.TableStats {
+	return pb.tables
+}
+
+// Columns returns the columns of the database's tables
+func (pb *PostgresBackup) Columns() ([]schemadiff.Column, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := pb.connect(ctx); err != nil {
+		return nil, err
+	}
+	defer pb.close()
+	return schemadiff.Read(ctx, pb.db)
+}
+
+// tableStatisticsQuery reads the row estimate and total size of every user
+// table. reltuples is -1 for tables never vacuumed or analyzed, for which the
+// live tuple count of the statistics collector is used instead.
+const tableStatisticsQuery = `SELECT s.schemaname, s.relname,
+		CASE WHEN c.reltuples >= 0 THEN c.reltuples::bigint ELSE s.n_live_tup END,
+		pg_total_relation_size(s.relid)
+	FROM pg_stat_user_tables s
+	JOIN pg_class c ON c.oid = s.relid
+	ORDER BY s.schemaname, s.relname`
+
+// tableStatistics returns the row estimates and sizes of the database's tables
+func tableStatistics(ctx context.Context, q bun.IDB) ([]provenance.TableStats, error) {
+	rows, err := q.QueryContext(ctx, tableStatisticsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []provenance.TableStats
+	for rows.Next() {
+		var schema, table string
+		var stat provenance.TableStats
+		if err := rows.Scan(&schema, &table, &stat.EstimatedRows, &stat.SizeBytes); err != nil {
+			return nil, err
+		}
+		stat.Name = schema + "." + table
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
+}
+
+// mergeTableStatistics adds the estimates and sizes of stats to the tables
+// of a manifest, leaving out tables the backup does not contain
+func mergeTableStatistics(tables, stats []provenance.TableStats) []provenance.TableStats {
+	byName := make(map[string]provenance.TableStats, len(stats))
+	for _, table := range stats {
+		byName[table.Name] = table
+	}
+	for i, table := range tables {
+		if stat, ok := byName[table.Name]; ok {
+			tables[i].EstimatedRows = stat.EstimatedRows
+			tables[i].SizeBytes = stat.SizeBytes
+		}
+	}
+	return tables
+}
+
 // TestConnection tests the database connection using bun
 func (pb *PostgresBackup) TestConnection() error {
 	pb.logger.Infof("Testing database connection using bun ORM")
@@ -104,7 +348,10 @@ func (pb *PostgresBackup) TestConnection() error {
 func (pb *PostgresBackup) CreateBackup() (string, error) {
 	// Generate backup filename
 	timestamp := time.Now().Format("2006-01-02_15-04-05")
-	backupPath := fmt.Sprintf("/tmp/db-backuper/%s_%s.sql", pb.config.Database, timestamp)
+	if pb.config.Postgres.DumpFormat() == config.PostgresFormatDirectory {
+		return pb.createDirectoryBackup(filepath.Join(TempDir, fmt.Sprintf("%s_%s", pb.config.Database, timestamp)))
+	}
+	backupPath := filepath.Join(TempDir, fmt.Sprintf("%s_%s.sql", pb.config.Database, timestamp))
 
 	err := pb.createBackup(backupPath)
 	return backupPath, err
@@ -118,11 +365,38 @@ func (pb *PostgresBackup) createBackup(backupPath string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
 	defer cancel()
 
-	// Connect to database
-	if err := pb.connect(ctx); err != nil {
-		return err
+	if pb.snapshot.tx != nil {
+		// Dump inside the snapshot pinned for the backup group
+		pb.q = pb.snapshot.tx
+	} else {
+		// Connect to database
+		if err := pb.connect(ctx); err != nil {
+			return err
+		}
+		defer pb.close()
+
+		// Pause application writes only for the moment the snapshot is taken
+		resume, err := pb.Quiesce()
+		if err != nil {
+			return fmt.Errorf("failed to quiesce database: %w", err)
+		}
+
+		// Read every table from the same snapshot so the dump is consistent
+		tx, err := pb.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+		if err != nil {
+			resume()
+			return fmt.Errorf("failed to begin backup transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		// The snapshot is taken by the transaction's first statement
+		_, err = tx.ExecContext(ctx, "SELECT 1")
+		resume()
+		if err != nil {
+			return fmt.Errorf("failed to take backup snapshot: %w", err)
+		}
+		pb.q = tx
 	}
-	defer pb.close()
 
 	// Create backup directory if it doesn't exist
 	backupDir := filepath.Dir(backupPath)
@@ -137,12 +411,26 @@ func (pb *PostgresBackup) createBackup(backupPath string) error {
 	}
 	defer backupFile.Close()
 
+	// Periodically log the growing dump size while tables are written
+	reporter := progress.StartFileReporter(backupPath, fmt.Sprintf("Backup of %s", pb.config.Database), progress.DefaultInterval, pb.logger)
+	defer reporter.Stop()
+
+	// The server version is recorded in pg_dump's words, so restores can
+	// check compatibility with their target the same way for both
+	serverVersion := "unknown"
+	if err := pb.savepoint(ctx, func() error {
+		return pb.q.QueryRowContext(ctx, "SHOW server_version").Scan(&serverVersion)
+	}); err != nil {
+		pb.logger.Warnf("Failed to read server version: %v", err)
+	}
+
 	// Write SQL header
-	header := fmt.Sprintf(`-- PostgreSQL database backup created by db-backuper
+	header := fmt.Sprintf(PostgresHeader+`
 -- Database: %s
 -- Host: %s
 -- Port: %d
 -- Created: %s
+-- Dumped from database version %s
 -- Generated by bun ORM
 
 SET statement_timeout = 0;
@@ -155,7 +443,7 @@ SET xmloption = content;
 SET client_min_messages = warning;
 SET row_security = off;
 
-`, pb.config.Database, pb.config.Host, pb.config.Port, time.Now().Format(time.RFC3339))
+`, pb.config.Database, pb.config.Host, pb.config.Port, time.Now().Format(time.RFC3339), serverVersion)
 
 	if _, err := backupFile.WriteString(header); err != nil {
 		return fmt.Errorf("failed to write backup header: %w", err)
@@ -171,26 +459,61 @@ SET row_security = off;
 		return fmt.Errorf("failed to backup data: %w", err)
 	}
 
+	// Statistics only describe the backup, so the dump does not depend on them
+	if err := pb.savepoint(ctx, func() error {
+		stats, err := tableStatistics(ctx, pb.q)
+		if err == nil {
+			pb.tables = mergeTableStatistics(pb.tables, stats)
+		}
+		return err
+	}); err != nil {
+		pb.logger.Warnf("Failed to read table statistics: %v", err)
+	}
+
 	// Write footer
-	footer := fmt.Sprintf(`
--- Backup completed at: %s
-`, time.Now().Format(time.RFC3339))
+	footer := "\n" + PostgresCompletionMarker + time.Now().Format(time.RFC3339) + "\n"
 
 	if _, err := backupFile.WriteString(footer); err != nil {
 		return fmt.Errorf("failed to write backup footer: %w", err)
 	}
 
+	reporter.Finish()
+	if err := checkSQLDump(backupPath, sourceRows(pb.tables)); err != nil {
+		return err
+	}
+	if pb.config.Postgres.VerifyDump {
+		if err := pb.verifyDump(backupPath); err != nil {
+			return err
+		}
+	}
 	pb.logger.Infof("Database backup completed successfully: %s", backupPath)
 	return nil
 }
 
+// verifyDump reads the finished dump back and checks it against the tables
+// just backed up
+func (pb *PostgresBackup) verifyDump(backupPath string) error {
+	verification, err := VerifySQLDump(backupPath, pb.tables)
+	if err != nil {
+		return err
+	}
+	if !verification.OK() {
+		for _, problem := range verification.Problems {
+			pb.logger.Errorf("Dump verification: %s", problem)
+		}
+		return fmt.Errorf("dump verification found %d problems", len(verification.Problems))
+	}
+	pb.logger.Infof("Verified dump: complete, %d tables", verification.Tables)
+	return nil
+}
+
 // backupSchema backs up the database schema
 func (pb *PostgresBackup) backupSchema(ctx context.Context, backupFile *os.File) error {
 	pb.logger.Infof("Backing up database schema")
 
 	// Get all tables
 	var tables []string
-	err := pb.db.NewSelect().
+	err := pb.q.NewSelect().
 		Column("tablename").
 		Table("pg_tables").
 		Where("schemaname = ?", "public").
@@ -206,32 +529,50 @@ func (pb *PostgresBackup) backupSchema(ctx context.Context, backupFile *os.File)
 
 	// Backup each table schema
 	for _, table := range tables {
-		if err := pb.backupTableSchema(ctx, backupFile, table); err != nil {
+		if err := pb.savepoint(ctx, func() error { return pb.backupTableSchema(ctx, backupFile, table) }); err != nil {
 			pb.logger.Warnf("Failed to backup schema for table %s: %v", table, err)
 			continue
 		}
 	}
 
 	// Get all functions
-	if err := pb.backupFunctions(ctx, backupFile); err != nil {
+	if err := pb.savepoint(ctx, func() error { return pb.backupFunctions(ctx, backupFile) }); err != nil {
 		pb.logger.Warnf("Failed to backup functions: %v", err)
 	}
 
 	// Get all triggers
-	if err := pb.backupTriggers(ctx, backupFile); err != nil {
+	if err := pb.savepoint(ctx, func() error { return pb.backupTriggers(ctx, backupFile) }); err != nil {
 		pb.logger.Warnf("Failed to backup triggers: %v", err)
 	}
 
 	return nil
 }
 
+// savepoint runs a best-effort step so that a failing statement does not
+// abort the surrounding backup transaction
+func (pb *PostgresBackup) savepoint(ctx context.Context, step func() error) error {
+	if _, err := pb.q.ExecContext(ctx, "SAVEPOINT backup_step"); err != nil {
+		return err
+	}
+	if err := step(); err != nil {
+		if _, rollbackErr := pb.q.ExecContext(ctx, "ROLLBACK TO SAVEPOINT backup_step"); rollbackErr != nil {
+			return fmt.Errorf("%w (rollback to savepoint failed: %v)", err, rollbackErr)
+		}
+		return err
+	}
+	_, err := pb.q.ExecContext(ctx, "RELEASE SAVEPOINT backup_step")
+	return err
+}
+
 // backupTableSchema backs up a single table's schema
 func (pb *PostgresBackup) backupTableSchema(ctx context.Context, backupFile *os.File, tableName string) error {
 	// Get table definition
 	var createTable string
-	err := pb.db.NewSelect().
-		ColumnExpr("pg_get_tabledef(?)", tableName).
-		Scan(ctx, &createTable)
+	err := pb.savepoint(ctx, func() error {
+		return pb.q.NewSelect().
+			ColumnExpr("pg_get_tabledef(?)", tableName).
+			Scan(ctx, &createTable)
+	})
 	if err != nil {
 		// Fallback: get basic table info
 		return pb.backupTableSchemaFallback(ctx, backupFile, tableName)
@@ -258,7 +599,7 @@ func (pb *PostgresBackup) backupTableSchemaFallback(ctx context.Context, backupF
 		ColumnDefault *string `bun:"column_default"`
 	}
 
-	err := pb.db.NewSelect().
+	err := pb.q.NewSelect().
 		Column("column_name", "data_type", "is_nullable", "column_default").
 		Table("information_schema.columns").
 		Where("table_name = ?", tableName).
@@ -309,7 +650,7 @@ func (pb *PostgresBackup) backupFunctions(ctx context.Context, backupFile *os.Fi
 		FunctionDef  string `bun:"prosrc"`
 	}
 
-	err := pb.db.NewSelect().
+	err := pb.q.NewSelect().
 		Column("proname", "prosrc").
 		Table("pg_proc").
 		Where("prokind = ?", "f").
@@ -346,7 +687,7 @@ func (pb *PostgresBackup) backupTriggers(ctx context.Context, backupFile *os.Fil
 		Action      string `bun:"action_statement"`
 	}
 
-	err := pb.db.NewSelect().
+	err := pb.q.NewSelect().
 		Column("trigger_name", "event_manipulation", "event_object_table", "action_statement").
 		Table("information_schema.triggers").
 		Where("trigger_schema = ?", "public").
@@ -380,7 +721,7 @@ func (pb *PostgresBackup) backupData(ctx context.Context, backupFile *os.File) e
 
 	// Get all tables
 	var tables []string
-	err := pb.db.NewSelect().
+	err := pb.q.NewSelect().
 		Column("tablename").
 		Table("pg_tables").
 		Where("schemaname = ?", "public").
@@ -389,14 +730,16 @@ func (pb *PostgresBackup) backupData(ctx context.Context, backupFile *os.File) e
 		return fmt.Errorf("failed to get table list: %w", err)
 	}
 
+	pb.tables = nil
+
 	// Write data section header
-	if _, err := backupFile.WriteString("\n--\n-- Database Data\n--\n\n"); err != nil {
+	if _, err := backupFile.WriteString("\n--\n" + PostgresDataSection + "\n--\n\n"); err != nil {
 		return err
 	}
 
 	// Backup each table's data
 	for _, table := range tables {
-		if err := pb.backupTableData(ctx, backupFile, table); err != nil {
+		if err := pb.savepoint(ctx, func() error { return pb.backupTableData(ctx, backupFile, table) }); err != nil {
 			pb.logger.Warnf("Failed to backup data for table %s: %v", table, err)
 			continue
 		}
@@ -409,13 +752,15 @@ func (pb *PostgresBackup) backupData(ctx context.Context, backupFile *os.File) e
 func (pb *PostgresBackup) backupTableData(ctx context.Context, backupFile *os.File, tableName string) error {
 	// Get row count
 	var count int
-	err := pb.db.NewSelect().
+	err := pb.q.NewSelect().
 		ColumnExpr("COUNT(*)").
 		Table(tableName).
 		Scan(ctx, &count)
 	if err != nil {
 		return err
 	}
+	counted := int64(count)
+	pb.tables = append(pb.tables, provenance.TableStats{Name: "public." + tableName, Rows: &counted})
 
 	if count == 0 {
 		pb.logger.Debugf("Table %s is empty, skipping data backup", tableName)
@@ -426,7 +771,7 @@ func (pb *PostgresBackup) backupTableData(ctx context.Context, backupFile *os.Fi
 
 	// Get column names
 	var columns []string
-	err = pb.db.NewSelect().
+	err = pb.q.NewSelect().
 		Column("column_name").
 		Table("information_schema.columns").
 		Where("table_name = ?", tableName).
@@ -443,7 +788,7 @@ func (pb *PostgresBackup) backupTableData(ctx context.Context, backupFile *os.Fi
 	}
 
 	// Get all rows and write them as INSERT statements
-	rows, err := pb.db.NewSelect().
+	rows, err := pb.q.NewSelect().
 		Column(columns...).
 		Table(tableName).
 		Rows(ctx)
@@ -495,10 +840,34 @@ func (pb *PostgresBackup) backupTableData(ctx context.Context, backupFile *os.Fi
 	return rows.Err()
 }
 
+// password returns the password to connect with: a fresh IAM authentication
+// token when iam_auth is enabled, otherwise the configured password
+func (pb *PostgresBackup) password() (string, error) {
+	if !pb.config.IAMAuth {
+		return pb.config.Password, nil
+	}
+	return rdsauth.Token(pb.config.Host, pb.config.Port, pb.config.Username, pb.config.IAMRegion)
+}
+
+// newConnector creates a pgdriver connector for dsn, restricting its TLS
+// configuration when the regulated crypto policy is in force
+func newConnector(dsn string) *pgdriver.Connector {
+	connector := pgdriver.NewConnector(pgdriver.WithDSN(dsn))
+	if tlsConfig := connector.Config().TLSConfig; tlsConfig != nil && compliance.Enabled() {
+		compliance.Restrict(tlsConfig)
+	}
+	return connector
+}
+
 // buildConnectionString builds a PostgreSQL DSN from the config
-func (pb *PostgresBackup) buildConnectionString() string {
+func (pb *PostgresBackup) buildConnectionString() (string, error) {
+	password, err := pb.password()
+	if err != nil {
+		return "", err
+	}
+
 	// URL-encode the password to handle special characters like +, @, etc.
-	encodedPassword := url.QueryEscape(pb.config.Password)
+	encodedPassword := url.QueryEscape(password)
 	encodedUsername := url.QueryEscape(pb.config.Username)
 	encodedDatabase := url.QueryEscape(pb.config.Database)
 
@@ -513,7 +882,7 @@ func (pb *PostgresBackup) buildConnectionString() string {
 		dsn += "?sslmode=disable"
 	}
 
-	return dsn
+	return dsn, nil
 }
 
 // maskPassword masks the password in a DSN for logging
@@ -537,18 +906,5 @@ func (pb *PostgresBackup) maskPassword(dsn string) string {
 
 // CleanupBackup removes the backup file
 func (pb *PostgresBackup) CleanupBackup(backupPath string) error {
-	if backupPath == "" {
-		return nil
-	}
-
-	pb.logger.Infof("Cleaning up backup file: %s", backupPath)
-
-	if err := os.Remove(backupPath); err != nil {
-		if !os.IsNotExist(err) {
-			return fmt.Errorf("failed to remove backup file: %w", err)
-		}
-		// File doesn't exist, that's fine
-	}
-
-	return nil
+	return removeBackupFile(backupPath, pb.logger)
 }
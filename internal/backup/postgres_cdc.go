@@ -0,0 +1,208 @@
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"db-backuper/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ChangeBatch describes the changes written by ChangeCapture.Peek
+type ChangeBatch struct {
+	Changes  int
+	FirstLSN string
+	LastLSN  string
+}
+
+// SlotStatus describes the replication slot of a change capture
+type SlotStatus struct {
+	Slot         string
+	Plugin       string
+	Active       bool
+	ConfirmedLSN string
+	// RetainedBytes is the WAL the server keeps for the slot
+	RetainedBytes int64
+}
+
+// changeRecord is one line of a stored change batch. Changes decoded by
+// wal2json are kept as JSON, the binary pgoutput messages base64 encoded.
+type changeRecord struct {
+	LSN    string          `json:"lsn"`
+	XID    string          `json:"xid"`
+	Change json.RawMessage `json:"change,omitempty"`
+	Data   string          `json:"data,omitempty"`
+}
+
+// ChangeCapture reads the changes of a PostgreSQL database from a logical
+// replication slot. Changes are peeked, stored by the caller and only then
+// confirmed, so changes that could not be stored stay in the slot.
+type ChangeCapture struct {
+	pb      *PostgresBackup
+	capture *config.ChangeCaptureConfig
+	slot    string
+}
+
+// NewChangeCapture creates the change capture of a PostgreSQL database
+func NewChangeCapture(dbConfig *config.DatabaseConfig, logger logrus.FieldLogger) *ChangeCapture {
+	return &ChangeCapture{
+		pb:      NewPostgresBackup(dbConfig, logger),
+		capture: &dbConfig.Postgres.ChangeCapture,
+		slot:    dbConfig.Postgres.ChangeCapture.SlotName(dbConfig.Database),
+	}
+}
+
+// Slot returns the name of the replication slot
+func (c *ChangeCapture) Slot() string {
+	return c.slot
+}
+
+// EnsureSlot creates the replication slot unless it exists and reports
+// whether it was created. The slot keeps every change from its creation on,
+// including those made while the service is stopped.
+func (c *ChangeCapture) EnsureSlot(ctx context.Context) (bool, error) {
+	status, err := c.Status(ctx)
+	if err != nil {
+		return false, err
+	}
+	plugin := c.capture.OutputPlugin()
+	if status != nil {
+		if status.Plugin != plugin {
+			return false, fmt.Errorf("replication slot %s uses the %s plugin, not %s", c.slot, status.Plugin, plugin)
+		}
+		return false, nil
+	}
+
+	if _, err := c.pb.db.ExecContext(ctx, "SELECT pg_create_logical_replication_slot(?, ?)", c.slot, plugin); err != nil {
+		return false, fmt.Errorf("failed to create replication slot %s (requires wal_level = logical and the REPLICATION privilege): %w", c.slot, err)
+	}
+	return true, nil
+}
+
+// Status returns the state of the replication slot, or nil when it does not exist
+func (c *ChangeCapture) Status(ctx context.Context) (*SlotStatus, error) {
+	if err := c.pb.connect(ctx); err != nil {
+		return nil, err
+	}
+
+	status := &SlotStatus{Slot: c.slot}
+	err := c.pb.db.QueryRowContext(ctx,
+		`SELECT plugin, active, coalesce(confirmed_flush_lsn::text, ''),
+			coalesce(pg_wal_lsn_diff(pg_current_wal_lsn(), restart_lsn), 0)::bigint
+		FROM pg_replication_slots WHERE slot_name = ?`, c.slot).
+		Scan(&status.Plugin, &status.Active, &status.ConfirmedLSN, &status.RetainedBytes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read replication slot %s: %w", c.slot, err)
+	}
+	return status, nil
+}
+
+// Peek writes the pending changes of the slot to path as gzipped JSON lines
+// without consuming them. A batch ends after the transaction that reaches
+// the batch size, so transactions are never split across batches.
+func (c *ChangeCapture) Peek(ctx context.Context, path string) (ChangeBatch, error) {
+	var batch ChangeBatch
+	if err := c.pb.connect(ctx); err != nil {
+		return batch, err
+	}
+
+	query, args := c.changesQuery("peek", nil, c.capture.BatchSize())
+	rows, err := c.pb.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return batch, fmt.Errorf("failed to read changes from replication slot %s: %w", c.slot, err)
+	}
+	defer rows.Close()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return batch, fmt.Errorf("failed to create change batch: %w", err)
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	encoder := json.NewEncoder(gz)
+
+	binary := c.capture.OutputPlugin() == config.ChangeCapturePluginPgoutput
+	for rows.Next() {
+		var record changeRecord
+		var data string
+		if err := rows.Scan(&record.LSN, &record.XID, &data); err != nil {
+			return batch, fmt.Errorf("failed to read change: %w", err)
+		}
+		if binary {
+			record.Data = data
+		} else {
+			record.Change = json.RawMessage(data)
+		}
+		if err := encoder.Encode(record); err != nil {
+			return batch, fmt.Errorf("failed to write change batch: %w", err)
+		}
+
+		if batch.Changes == 0 {
+			batch.FirstLSN = record.LSN
+		}
+		batch.LastLSN = record.LSN
+		batch.Changes++
+	}
+	if err := rows.Err(); err != nil {
+		return batch, fmt.Errorf("failed to read changes from replication slot %s: %w", c.slot, err)
+	}
+
+	if err := gz.Close(); err != nil {
+		return batch, fmt.Errorf("failed to write change batch: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return batch, fmt.Errorf("failed to write change batch: %w", err)
+	}
+	return batch, nil
+}
+
+// Confirm consumes the changes up to and including the transaction at lsn
+// once they are stored, letting the server release their WAL
+func (c *ChangeCapture) Confirm(ctx context.Context, lsn string) error {
+	if err := c.pb.connect(ctx); err != nil {
+		return err
+	}
+	query, args := c.changesQuery("get", lsn, nil)
+	if _, err := c.pb.db.ExecContext(ctx, "SELECT count(*) FROM ("+query+") AS consumed", args...); err != nil {
+		return fmt.Errorf("failed to confirm changes up to %s in replication slot %s: %w", lsn, c.slot, err)
+	}
+	return nil
+}
+
+// DropSlot drops the replication slot, releasing the WAL it retains
+func (c *ChangeCapture) DropSlot(ctx context.Context) error {
+	if err := c.pb.connect(ctx); err != nil {
+		return err
+	}
+	if _, err := c.pb.db.ExecContext(ctx, "SELECT pg_drop_replication_slot(?)", c.slot); err != nil {
+		return fmt.Errorf("failed to drop replication slot %s: %w", c.slot, err)
+	}
+	return nil
+}
+
+// Close closes the connection to the database
+func (c *ChangeCapture) Close() error {
+	return c.pb.close()
+}
+
+// changesQuery returns the query reading the slot's changes with the peek or
+// get function of its output plugin, up to an LSN or a number of changes
+func (c *ChangeCapture) changesQuery(function string, uptoLSN any, uptoChanges any) (string, []any) {
+	if c.capture.OutputPlugin() == config.ChangeCapturePluginPgoutput {
+		query := fmt.Sprintf(`SELECT lsn::text, xid::text, encode(data, 'base64')
+			FROM pg_logical_slot_%s_binary_changes(?, ?::pg_lsn, ?::int, 'proto_version', '1', 'publication_names', ?)`, function)
+		return query, []any{c.slot, uptoLSN, uptoChanges, c.capture.Publication}
+	}
+	query := fmt.Sprintf(`SELECT lsn::text, xid::text, data
+		FROM pg_logical_slot_%s_changes(?, ?::pg_lsn, ?::int, 'format-version', '2', 'include-timestamp', '1')`, function)
+	return query, []any{c.slot, uptoLSN, uptoChanges}
+}
@@ -0,0 +1,141 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"db-backuper/internal/progress"
+	"db-backuper/internal/storage"
+)
+
+// PostgresDirectorySuffix marks archived pg_dump directory format backups
+const PostgresDirectorySuffix = ".dir.tar.gz"
+
+// PostgresDataSection is the comment starting the data section of SQL backups;
+// everything before it is schema
+const PostgresDataSection = "-- Database Data"
+
+// PostgresDumpDir is the directory holding the pg_dump output inside the archive
+const PostgresDumpDir = "dump"
+
+// createDirectoryBackup dumps the database with pg_dump's directory format,
+// running the configured number of parallel jobs, and archives the directory.
+// With upload_files the directory is kept as it is and indexed instead, so
+// storage can transfer its files in parallel.
+func (pb *PostgresBackup) createDirectoryBackup(dumpDir string) (string, error) {
+	backupPath := dumpDir + PostgresDirectorySuffix
+	if pb.config.Postgres.UploadFiles {
+		backupPath = dumpDir + storage.DirectoryIndexSuffix
+	}
+	jobs := pb.config.Postgres.Jobs()
+	pb.logger.Infof("Creating directory format backup with pg_dump (%d jobs): %s", jobs, backupPath)
+
+	snapshotID := pb.snapshot.id
+	if pb.snapshot.tx == nil && pb.config.Quiesce.Enabled() {
+		// Pause application writes only while a snapshot for pg_dump is exported
+		resume, err := pb.Quiesce()
+		if err != nil {
+			return backupPath, fmt.Errorf("failed to quiesce database: %w", err)
+		}
+		snapshotID, err = pb.PinSnapshot("")
+		resume()
+		if err != nil {
+			return backupPath, err
+		}
+		defer pb.ReleaseSnapshot()
+	}
+
+	if err := os.RemoveAll(dumpDir); err != nil {
+		return backupPath, fmt.Errorf("failed to clear dump directory: %w", err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	args := []string{
+		"--format=directory",
+		"--jobs=" + strconv.Itoa(jobs),
+		"--file=" + dumpDir,
+		"--host=" + pb.config.Host,
+		"--port=" + strconv.Itoa(pb.config.Port),
+		"--username=" + pb.config.Username,
+		"--dbname=" + pb.config.Database,
+		"--no-password",
+	}
+	if snapshotID != "" {
+		// Every worker reads from the snapshot shared with the rest of the group
+		args = append(args, "--snapshot="+snapshotID)
+	}
+
+	env, err := pb.pgEnv()
+	if err != nil {
+		return backupPath, err
+	}
+	cmd := exec.Command("pg_dump", args...)
+	cmd.Env = append(os.Environ(), env...)
+	if err := progress.RunStreaming(cmd, pb.logger, "pg_dump"); err != nil {
+		return backupPath, fmt.Errorf("pg_dump failed: %w", err)
+	}
+	pb.recordTableStatistics()
+	if err := checkDirectoryDump(dumpDir, sourceRows(pb.tables)); err != nil {
+		return backupPath, err
+	}
+
+	if pb.config.Postgres.UploadFiles {
+		filesDir := storage.DirectoryPath(backupPath)
+		if err := os.RemoveAll(filesDir); err != nil {
+			return backupPath, fmt.Errorf("failed to clear backup directory: %w", err)
+		}
+		if err := os.Rename(dumpDir, filesDir); err != nil {
+			return backupPath, fmt.Errorf("failed to move dump directory: %w", err)
+		}
+		if err := storage.WriteDirectoryIndex(filesDir, backupPath); err != nil {
+			return backupPath, err
+		}
+	} else if err := writeTarGz(backupPath, []archiveEntry{{Name: PostgresDumpDir, Dir: dumpDir}}); err != nil {
+		return backupPath, err
+	}
+
+	pb.logger.Infof("Directory format backup completed successfully: %s", backupPath)
+	return backupPath, nil
+}
+
+// recordTableStatistics reads the manifest of a directory format backup.
+// pg_dump counts no rows, so only estimates and sizes are recorded, and a
+// failure leaves the manifest empty rather than failing the backup.
+func (pb *PostgresBackup) recordTableStatistics() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	pb.tables = nil
+	if err := pb.connect(ctx); err != nil {
+		pb.logger.Warnf("Failed to read table statistics: %v", err)
+		return
+	}
+	defer pb.close()
+
+	stats, err := tableStatistics(ctx, pb.db)
+	if err != nil {
+		pb.logger.Warnf("Failed to read table statistics: %v", err)
+		return
+	}
+	pb.tables = stats
+}
+
+// pgEnv returns the libpq environment carrying the password and SSL mode
+func (pb *PostgresBackup) pgEnv() ([]string, error) {
+	password, err := pb.password()
+	if err != nil {
+		return nil, err
+	}
+	sslMode := pb.config.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	return []string{
+		"PGPASSWORD=" + password,
+		"PGSSLMODE=" + sslMode,
+	}, nil
+}
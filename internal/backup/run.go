@@ -0,0 +1,140 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/plugin"
+	"db-backuper/internal/rclone"
+	"db-backuper/internal/s3"
+	"db-backuper/internal/status"
+	"db-backuper/internal/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// BackupDatabase creates a backup of a single database and saves it to
+// storageManager under a key carrying runID
+func BackupDatabase(engine Engine, dbConfig *config.DatabaseConfig, storageManager storage.Backend, backupConfig *config.BackupConfig, runID string, logger logrus.FieldLogger) status.DatabaseResult {
+	result := status.DatabaseResult{
+		Database:  engine.DatabaseName(),
+		Status:    status.ResultFailed,
+		StartedAt: time.Now(),
+	}
+	fail := func(err error) status.DatabaseResult {
+		result.FinishedAt = time.Now()
+		result.DurationSeconds = result.FinishedAt.Sub(result.StartedAt).Seconds()
+		result.Error = err.Error()
+		return result
+	}
+
+	// Refuse to dump when the backup disk is already below its free space watermark
+	if ls, ok := storageManager.(*storage.LocalStorage); ok {
+		if err := ls.CheckFreeSpace(backupConfig.BackupPrefix); err != nil {
+			return fail(err)
+		}
+	}
+
+	// Create database backup
+	backupPath, err := engine.CreateBackup()
+	if err != nil {
+		return fail(fmt.Errorf("failed to create backup: %w", err))
+	}
+
+	if info, err := os.Stat(backupPath); err == nil {
+		result.SizeBytes = info.Size()
+	}
+
+	databaseName := engine.DatabaseName()
+
+	// Record where the backup came from so the stored object describes itself
+	meta, err := Provenance(engine, dbConfig, backupPath, logger)
+	if err != nil {
+		if cleanupErr := engine.CleanupBackup(backupPath); cleanupErr != nil {
+			logger.Warnf("Failed to cleanup backup file: %v", cleanupErr)
+		}
+		return fail(fmt.Errorf("failed to describe backup: %w", err))
+	}
+	meta.RunID = runID
+	meta.Instance = backupConfig.InstanceName()
+
+	// Split large backups into parts within the object size limits of the storage
+	splitPath, err := Split(backupPath, backupConfig.SplitSize(), logger)
+	if err != nil {
+		if cleanupErr := engine.CleanupBackup(backupPath); cleanupErr != nil {
+			logger.Warnf("Failed to cleanup backup file: %v", cleanupErr)
+		}
+		return fail(err)
+	}
+	backupPath = splitPath
+
+	// Save backup to storage
+	switch sm := storageManager.(type) {
+	case *s3.S3Manager:
+		s3Key, err := sm.UploadBackup(backupPath, backupConfig.BackupPrefix, databaseName, meta)
+		if err != nil {
+			// Cleanup local backup file on upload failure
+			if cleanupErr := engine.CleanupBackup(backupPath); cleanupErr != nil {
+				logger.Warnf("Failed to cleanup backup file after upload failure: %v", cleanupErr)
+			}
+			return fail(fmt.Errorf("failed to upload backup to S3: %w", err))
+		}
+		result.Location = s3Key
+		if err := sm.UpdateLatest(backupConfig.BackupPrefix, databaseName, s3Key, result.SizeBytes, meta); err != nil {
+			logger.Warnf("Failed to update latest backup pointer: %v", err)
+		}
+	case *storage.LocalStorage:
+		localPath, err := sm.SaveBackup(backupPath, backupConfig.BackupPrefix, databaseName, meta)
+		if err != nil {
+			// Cleanup local backup file on save failure
+			if cleanupErr := engine.CleanupBackup(backupPath); cleanupErr != nil {
+				logger.Warnf("Failed to cleanup backup file after save failure: %v", cleanupErr)
+			}
+			return fail(fmt.Errorf("failed to save backup to local storage: %w", err))
+		}
+		result.Location = localPath
+		if err := sm.UpdateLatest(backupConfig.BackupPrefix, databaseName, localPath); err != nil {
+			logger.Warnf("Failed to update latest backup pointer: %v", err)
+		}
+	case *rclone.Remote:
+		key, err := sm.SaveBackup(backupPath, backupConfig.BackupPrefix, databaseName, meta)
+		if err != nil {
+			// Cleanup local backup file on upload failure
+			if cleanupErr := engine.CleanupBackup(backupPath); cleanupErr != nil {
+				logger.Warnf("Failed to cleanup backup file after upload failure: %v", cleanupErr)
+			}
+			return fail(fmt.Errorf("failed to upload backup with rclone: %w", err))
+		}
+		result.Location = key
+		if err := sm.UpdateLatest(backupConfig.BackupPrefix, databaseName, key, result.SizeBytes, meta); err != nil {
+			logger.Warnf("Failed to update latest backup pointer: %v", err)
+		}
+	case *plugin.Storage:
+		key, err := sm.SaveBackup(backupPath, backupConfig.BackupPrefix, databaseName, meta)
+		if err != nil {
+			// Cleanup local backup file on upload failure
+			if cleanupErr := engine.CleanupBackup(backupPath); cleanupErr != nil {
+				logger.Warnf("Failed to cleanup backup file after upload failure: %v", cleanupErr)
+			}
+			return fail(fmt.Errorf("failed to upload backup with storage plugin: %w", err))
+		}
+		result.Location = key
+		if err := sm.UpdateLatest(backupConfig.BackupPrefix, databaseName, key, result.SizeBytes, meta); err != nil {
+			logger.Warnf("Failed to update latest backup pointer: %v", err)
+		}
+	default:
+		return fail(fmt.Errorf("unknown storage manager type"))
+	}
+
+	// Cleanup local backup file
+	if err := engine.CleanupBackup(backupPath); err != nil {
+		logger.Warnf("Failed to cleanup local backup file: %v", err)
+	}
+
+	result.Status = status.ResultSuccess
+	result.FinishedAt = time.Now()
+	result.DurationSeconds = result.FinishedAt.Sub(result.StartedAt).Seconds()
+	return result
+}
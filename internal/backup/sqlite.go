@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/progress"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sqliteBusyTimeout is how long sqlite3 waits for writers to release their locks
+const sqliteBusyTimeout = 30 * time.Second
+
+// SQLiteBackup handles SQLite database backups using the sqlite3 command line shell
+type SQLiteBackup struct {
+	config *config.DatabaseConfig
+	logger logrus.FieldLogger
+}
+
+// NewSQLiteBackup creates a new SQLite backup instance
+func NewSQLiteBackup(dbConfig *config.DatabaseConfig, logger logrus.FieldLogger) *SQLiteBackup {
+	return &SQLiteBackup{
+		config: dbConfig,
+		logger: logger,
+	}
+}
+
+// WithLogger returns a copy of the backup instance that logs through logger
+func (sb *SQLiteBackup) WithLogger(logger logrus.FieldLogger) Engine {
+	return &SQLiteBackup{
+		config: sb.config,
+		logger: logger,
+	}
+}
+
+// DatabaseName returns the name of the database being backed up
+func (sb *SQLiteBackup) DatabaseName() string {
+	return sb.config.Database
+}
+
+// TestConnection checks that the database file exists and can be read by sqlite3
+func (sb *SQLiteBackup) TestConnection() error {
+	sb.logger.Infof("Testing SQLite database: %s", sb.config.Path)
+
+	if _, err := os.Stat(sb.config.Path); err != nil {
+		return fmt.Errorf("sqlite database file is not accessible: %w", err)
+	}
+
+	if _, err := sb.run("PRAGMA schema_version;"); err != nil {
+		return fmt.Errorf("sqlite database test failed: %w", err)
+	}
+
+	sb.logger.Infof("SQLite database test successful")
+	return nil
+}
+
+// CreateBackup creates a consistent copy of the database file and returns the backup path
+func (sb *SQLiteBackup) CreateBackup() (string, error) {
+	timestamp := time.Now().Format("2006-01-02_15-04-05")
+	backupPath := filepath.Join(TempDir, fmt.Sprintf("%s_%s.sqlite", sb.config.Database, timestamp))
+
+	sb.logger.Infof("Creating SQLite backup of %s: %s", sb.config.Path, backupPath)
+
+	if err := os.MkdirAll(filepath.Dir(backupPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	// VACUUM INTO refuses to overwrite, so clear any leftover from an earlier attempt
+	if err := os.Remove(backupPath); err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to remove stale backup file: %w", err)
+	}
+
+	reporter := progress.StartFileReporter(backupPath, fmt.Sprintf("Backup of %s", sb.config.Database), progress.DefaultInterval, sb.logger)
+	defer reporter.Stop()
+
+	// VACUUM INTO reads the database in a single transaction, so the copy is
+	// consistent even while the service keeps writing to it
+	if _, err := sb.run(fmt.Sprintf("VACUUM INTO %s;", quoteSQLiteString(backupPath))); err != nil {
+		os.Remove(backupPath)
+		return "", fmt.Errorf("sqlite backup failed: %w", err)
+	}
+
+	reporter.Finish()
+	sb.logger.Infof("SQLite backup completed successfully: %s", backupPath)
+	return backupPath, nil
+}
+
+// CleanupBackup removes the temporary backup file
+func (sb *SQLiteBackup) CleanupBackup(backupPath string) error {
+	return removeBackupFile(backupPath, sb.logger)
+}
+
+// run executes a single SQL statement against the database with sqlite3
+func (sb *SQLiteBackup) run(statement string) (string, error) {
+	cmd := exec.Command("sqlite3",
+		"-batch",
+		"-bail",
+		"-cmd", fmt.Sprintf(".timeout %d", sqliteBusyTimeout.Milliseconds()),
+		sb.config.Path,
+		statement,
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("sqlite3 command failed: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
+
+// quoteSQLiteString quotes s as an SQL string literal
+func quoteSQLiteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,209 @@
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"db-backuper/internal/provenance"
+)
+
+// PostgresHeader is the first line of SQL backups written by this service
+const PostgresHeader = "-- PostgreSQL database backup created by db-backuper"
+
+// pgDumpArchiveMagic starts the table of contents of pg_dump's directory format
+const pgDumpArchiveMagic = "PGDMP"
+
+// PostgresCompletionMarker starts the last line of SQL backups written by
+// this service; a dump cut short by a full disk or a crash lacks it
+const PostgresCompletionMarker = "-- Backup completed at: "
+
+// pgDumpCompletionMarker ends scripts written by pg_dump
+const pgDumpCompletionMarker = "-- PostgreSQL database dump complete"
+
+// createTableStatement matches the table named by a CREATE TABLE statement
+var createTableStatement = regexp.MustCompile(`^CREATE (?:UNLOGGED )?TABLE (?:IF NOT EXISTS )?([^\s(]+)`)
+
+// insertStatement matches the table named by an INSERT statement
+var insertStatement = regexp.MustCompile(`^INSERT INTO ([^\s(]+)`)
+
+// copyStatement matches the table named by the COPY block of a pg_dump script
+var copyStatement = regexp.MustCompile(`^COPY ([^\s(]+) .*FROM stdin;$`)
+
+// DumpVerification is the outcome of checking a SQL backup without restoring it
+type DumpVerification struct {
+	Path     string   `json:"path"`
+	Complete bool     `json:"complete"`
+	Tables   int      `json:"tables"`
+	Problems []string `json:"problems"`
+}
+
+// OK returns true if the dump passed every check
+func (v *DumpVerification) OK() bool {
+	return len(v.Problems) == 0
+}
+
+// VerifySQLDump reads a plain SQL backup, gzip compressed or not, and checks
+// that it ends with its completion marker, creates every table of the
+// manifest and holds at least the rows recorded for each of them. The data is
+// only counted, never parsed, so it takes about as long as reading the file.
+func VerifySQLDump(path string, manifest []provenance.TableStats) (*DumpVerification, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress %s: %w", path, err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	created := make(map[string]bool)
+	rows := make(map[string]int64)
+	lastLine := ""
+	copyTable := ""
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if copyTable != "" {
+			if line == `\.` {
+				copyTable = ""
+			} else {
+				rows[copyTable]++
+			}
+			continue
+		}
+		// pg_dump frames its comments with bare "--" lines
+		if trimmed := strings.TrimSpace(line); trimmed != "" && trimmed != "--" {
+			lastLine = line
+		}
+		if m := insertStatement.FindStringSubmatch(line); m != nil {
+			rows[qualifiedName(m[1])]++
+		} else if m := createTableStatement.FindStringSubmatch(line); m != nil {
+			created[qualifiedName(m[1])] = true
+		} else if m := copyStatement.FindStringSubmatch(line); m != nil {
+			copyTable = qualifiedName(m[1])
+		}
+	}
+
+	verification := &DumpVerification{Path: path, Tables: len(created), Problems: []string{}}
+	if err := scanner.Err(); err != nil {
+		// A gzip stream cut short ends in an unexpected EOF
+		verification.Problems = append(verification.Problems, fmt.Sprintf("dump could not be read to the end: %v", err))
+	}
+
+	verification.Complete = strings.HasPrefix(lastLine, PostgresCompletionMarker) || lastLine == pgDumpCompletionMarker
+	if !verification.Complete {
+		verification.Problems = append(verification.Problems, "dump does not end with its completion marker and is probably truncated")
+	}
+	for _, table := range manifest {
+		if !created[table.Name] {
+			verification.Problems = append(verification.Problems, fmt.Sprintf("table %s has no CREATE TABLE statement", table.Name))
+			continue
+		}
+		if table.Rows != nil && rows[table.Name] < *table.Rows {
+			verification.Problems = append(verification.Problems,
+				fmt.Sprintf("table %s has %d rows in the dump, %d were backed up", table.Name, rows[table.Name], *table.Rows))
+		}
+	}
+	return verification, nil
+}
+
+// qualifiedName returns the schema-qualified, unquoted form of a table name
+// in a statement. Backups written by this service leave out the public schema.
+func qualifiedName(name string) string {
+	name = strings.ReplaceAll(name, `"`, "")
+	if !strings.Contains(name, ".") {
+		return "public." + name
+	}
+	return name
+}
+
+// checkSQLDump rejects a SQL backup that is empty, does not start with the
+// backup header or, when the source reported rows, holds no data statements
+func checkSQLDump(path string, sourceRows int64) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		return fmt.Errorf("backup file %s is empty", path)
+	}
+	if scanner.Text() != PostgresHeader {
+		return fmt.Errorf("backup file %s does not start with the backup header", path)
+	}
+	if sourceRows == 0 {
+		return nil
+	}
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "INSERT INTO ") || strings.HasPrefix(line, "COPY ") {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return fmt.Errorf("backup file %s holds only the schema although the database reported %d rows", path, sourceRows)
+}
+
+// checkDirectoryDump rejects a pg_dump directory without a valid table of
+// contents or, when the source reported rows, without any table data files
+func checkDirectoryDump(dir string, sourceRows int64) error {
+	toc, err := os.Open(filepath.Join(dir, "toc.dat"))
+	if err != nil {
+		return fmt.Errorf("pg_dump wrote no table of contents: %w", err)
+	}
+	defer toc.Close()
+
+	magic := make([]byte, len(pgDumpArchiveMagic))
+	if _, err := io.ReadFull(toc, magic); err != nil || string(magic) != pgDumpArchiveMagic {
+		return fmt.Errorf("%s is not a pg_dump table of contents", toc.Name())
+	}
+	if sourceRows == 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read dump directory: %w", err)
+	}
+	for _, entry := range entries {
+		if name := entry.Name(); name != "toc.dat" && strings.Contains(name, ".dat") {
+			return nil
+		}
+	}
+	return fmt.Errorf("pg_dump wrote no table data although the database reported about %d rows", sourceRows)
+}
+
+// sourceRows totals the rows of a manifest, using the exact counts of SQL
+// backups and the estimates of directory format backups
+func sourceRows(tables []provenance.TableStats) int64 {
+	var total int64
+	for _, table := range tables {
+		if table.Rows != nil {
+			total += *table.Rows
+		} else {
+			total += table.EstimatedRows
+		}
+	}
+	return total
+}
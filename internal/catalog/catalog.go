@@ -0,0 +1,199 @@
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"time"
+
+	"db-backuper/internal/storage"
+)
+
+// SchemaVersion is bumped whenever the catalog layout changes incompatibly
+const SchemaVersion = 1
+
+// Dir is the folder under the backup prefix holding the catalog
+const Dir = storage.CatalogDir
+
+// fileName is the name of the catalog object
+const fileName = "catalog.json"
+
+// ErrNotFound is returned when a restore point does not exist
+var ErrNotFound = errors.New("restore point not found")
+
+// validName restricts restore point names to characters safe in paths and shells
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// RestorePoint is a backup tagged with a human friendly name
+type RestorePoint struct {
+	Name      string    `json:"name"`
+	Database  string    `json:"database"`
+	Key       string    `json:"key"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// document is the catalog object stored next to the backups
+type document struct {
+	SchemaVersion int            `json:"schema_version"`
+	RestorePoints []RestorePoint `json:"restore_points"`
+}
+
+// Catalog stores named restore points in the backup storage
+type Catalog struct {
+	backend storage.Backend
+	key     string
+}
+
+// New creates a catalog for the backups under backupPrefix
+func New(backend storage.Backend, backupPrefix string) *Catalog {
+	return &Catalog{
+		backend: backend,
+		key:     path.Join(backupPrefix, Dir, fileName),
+	}
+}
+
+// ValidateName checks that a restore point name can be stored
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid restore point name %q: use letters, digits, '.', '_' and '-'", name)
+	}
+	return nil
+}
+
+// RestorePoints returns every restore point, newest first
+func (c *Catalog) RestorePoints() ([]RestorePoint, error) {
+	doc, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+
+	points := doc.RestorePoints
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].CreatedAt.After(points[j].CreatedAt)
+	})
+	return points, nil
+}
+
+// Get returns the named restore point
+func (c *Catalog) Get(name string) (*RestorePoint, error) {
+	doc, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, point := range doc.RestorePoints {
+		if point.Name == name {
+			return &point, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+}
+
+// Tag records a restore point. An existing point with the same name is only
+// replaced when replace is set.
+func (c *Catalog) Tag(point RestorePoint, replace bool) error {
+	if err := ValidateName(point.Name); err != nil {
+		return err
+	}
+
+	doc, err := c.load()
+	if err != nil {
+		return err
+	}
+
+	if i := slices.IndexFunc(doc.RestorePoints, func(p RestorePoint) bool { return p.Name == point.Name }); i >= 0 {
+		if !replace {
+			return fmt.Errorf("restore point %s already exists for %s", point.Name, doc.RestorePoints[i].Key)
+		}
+		doc.RestorePoints = slices.Delete(doc.RestorePoints, i, i+1)
+	}
+
+	if point.CreatedAt.IsZero() {
+		point.CreatedAt = time.Now().UTC()
+	}
+	doc.RestorePoints = append(doc.RestorePoints, point)
+	return c.save(doc)
+}
+
+// Untag removes the named restore point; the backup itself is kept
+func (c *Catalog) Untag(name string) error {
+	doc, err := c.load()
+	if err != nil {
+		return err
+	}
+
+	i := slices.IndexFunc(doc.RestorePoints, func(p RestorePoint) bool { return p.Name == name })
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	doc.RestorePoints = slices.Delete(doc.RestorePoints, i, i+1)
+	return c.save(doc)
+}
+
+// load reads the catalog, returning an empty one when none has been written yet
+func (c *Catalog) load() (*document, error) {
+	keys, err := c.backend.ListKeys(c.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up catalog: %w", err)
+	}
+	if !slices.Contains(keys, c.key) {
+		return &document{SchemaVersion: SchemaVersion}, nil
+	}
+
+	tmp, err := os.CreateTemp("", "db-backuper-catalog-*.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary catalog file: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := c.backend.Download(c.key, tmp.Name()); err != nil {
+		return nil, fmt.Errorf("failed to read catalog: %w", err)
+	}
+	data, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog: %w", err)
+	}
+
+	var doc document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode catalog %s: %w", c.key, err)
+	}
+	if doc.SchemaVersion > SchemaVersion {
+		return nil, fmt.Errorf("catalog %s has schema version %d, newer than supported version %d", c.key, doc.SchemaVersion, SchemaVersion)
+	}
+	doc.SchemaVersion = SchemaVersion
+	return &doc, nil
+}
+
+// save writes the catalog back to storage
+func (c *Catalog) save(doc *document) error {
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode catalog: %w", err)
+	}
+
+	tmp, err := os.CreateTemp("", "db-backuper-catalog-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary catalog file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary catalog file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write temporary catalog file: %w", err)
+	}
+
+	if err := c.backend.UploadFile(tmp.Name(), c.key, nil); err != nil {
+		return fmt.Errorf("failed to write catalog: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package catalog
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"db-backuper/internal/audit"
+	"db-backuper/internal/provenance"
+	"db-backuper/internal/storage"
+)
+
+// Retention decisions of an exported backup
+const (
+	// RetentionKept backups are younger than the retention period
+	RetentionKept = "kept"
+	// RetentionHeld backups are exempt from retention by a hold
+	RetentionHeld = "held"
+	// RetentionExpired backups are past the retention period and deleted
+	// by the next cleanup
+	RetentionExpired = "expired"
+)
+
+// Categories of exported events
+const (
+	CategoryRetention = "retention"
+	CategoryRestore   = "restore"
+)
+
+// eventCategories maps the audit actions included in an export to their category
+var eventCategories = map[string]string{
+	audit.ActionRetentionDelete:     CategoryRetention,
+	audit.ActionDelete:              CategoryRetention,
+	audit.ActionLowSpacePrune:       CategoryRetention,
+	audit.ActionHold:                CategoryRetention,
+	audit.ActionReleaseHold:         CategoryRetention,
+	audit.ActionRestoreDropExisting: CategoryRestore,
+	audit.ActionRestoreCommand:      CategoryRestore,
+	audit.ActionRestoreRequest:      CategoryRestore,
+	audit.ActionRestoreApprove:      CategoryRestore,
+	audit.ActionRestoreCancel:       CategoryRestore,
+	audit.ActionRestore:             CategoryRestore,
+}
+
+// Export is a report of the backups stored in a date range, the retention
+// decision of each, and the retention and restore events of the range
+type Export struct {
+	GeneratedAt   time.Time `json:"generated_at"`
+	GeneratedBy   string    `json:"generated_by"`
+	Since         string    `json:"since"`
+	Until         string    `json:"until"`
+	RetentionDays int       `json:"retention_days"`
+	// SigningKey is the fingerprint of the key signing the report
+	SigningKey string           `json:"signing_key,omitempty"`
+	Backups    []ExportedBackup `json:"backups"`
+	Events     []ExportedEvent  `json:"events"`
+
+	since, until time.Time
+}
+
+// ExportedBackup is a stored backup with its retention decision
+type ExportedBackup struct {
+	Storage   string     `json:"storage"`
+	Key       string     `json:"key"`
+	Database  string     `json:"database"`
+	Date      string     `json:"date"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	SizeBytes int64      `json:"size_bytes"`
+	SHA256    string     `json:"sha256,omitempty"`
+	RunID     string     `json:"run_id,omitempty"`
+	Retention string     `json:"retention"`
+	// ExpiresOn is the day retention cleanup deletes the backup, unless it is held
+	ExpiresOn  string `json:"expires_on,omitempty"`
+	HoldReason string `json:"hold_reason,omitempty"`
+}
+
+// ExportedEvent is a retention or restore event
+type ExportedEvent struct {
+	Time     time.Time         `json:"time"`
+	Category string            `json:"category"`
+	Action   string            `json:"action"`
+	Actor    string            `json:"actor,omitempty"`
+	Storage  string            `json:"storage,omitempty"`
+	Database string            `json:"database,omitempty"`
+	Targets  []string          `json:"targets,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+}
+
+// NewExport creates an empty export of the days from since to until,
+// inclusive, judging retention by retentionDays as of now
+func NewExport(since, until time.Time, retentionDays int, generatedBy string, now time.Time) *Export {
+	since = time.Date(since.Year(), since.Month(), since.Day(), 0, 0, 0, 0, time.UTC)
+	until = time.Date(until.Year(), until.Month(), until.Day(), 0, 0, 0, 0, time.UTC)
+	return &Export{
+		GeneratedAt:   now.UTC(),
+		GeneratedBy:   generatedBy,
+		Since:         since.Format(storage.DateLayout),
+		Until:         until.Format(storage.DateLayout),
+		RetentionDays: retentionDays,
+		Backups:       []ExportedBackup{},
+		Events:        []ExportedEvent{},
+		since:         since,
+		until:         until,
+	}
+}
+
+// AddBackup adds a backup listed from location, with its provenance when
+// recorded and the holds of its storage
+func (e *Export) AddBackup(location string, entry storage.Entry, meta *provenance.Metadata, holds []storage.Hold) {
+	backup := ExportedBackup{
+		Storage:   location,
+		Key:       entry.Key,
+		Database:  entry.Database,
+		Date:      entry.Date.Format(storage.DateLayout),
+		SizeBytes: entry.Size,
+		Retention: RetentionKept,
+	}
+	if meta != nil {
+		createdAt := meta.CreatedAt.UTC()
+		backup.CreatedAt = &createdAt
+		backup.SHA256 = meta.SHA256
+		backup.RunID = meta.RunID
+	}
+
+	// Cleanup deletes date directories dated before retention_days ago
+	if e.RetentionDays > 0 {
+		expiresOn := entry.Date.AddDate(0, 0, e.RetentionDays)
+		backup.ExpiresOn = expiresOn.Format(storage.DateLayout)
+		if !e.GeneratedAt.Before(expiresOn) {
+			backup.Retention = RetentionExpired
+		}
+	}
+	for _, hold := range holds {
+		if storage.IsHeld(map[string]bool{hold.Key: true}, entry.Key) {
+			backup.Retention = RetentionHeld
+			backup.HoldReason = hold.Reason
+			break
+		}
+	}
+	e.Backups = append(e.Backups, backup)
+}
+
+// AddAuditEvents adds the retention and restore events among events that
+// were recorded in the export's date range
+func (e *Export) AddAuditEvents(events []audit.Event) {
+	for _, event := range events {
+		category, ok := eventCategories[event.Action]
+		if !ok || !e.covers(event.Time) {
+			continue
+		}
+		e.Events = append(e.Events, ExportedEvent{
+			Time:     event.Time.UTC(),
+			Category: category,
+			Action:   event.Action,
+			Actor:    event.Actor,
+			Storage:  event.Storage,
+			Targets:  event.Targets,
+			Details:  event.Details,
+		})
+	}
+}
+
+// AddRestore adds a restore of database measured at finishedAt, such as an
+// import or a rehearsal, when it falls in the export's date range
+func (e *Export) AddRestore(database, source, key string, finishedAt time.Time, details map[string]string) {
+	if !e.covers(finishedAt) {
+		return
+	}
+	var targets []string
+	if key != "" {
+		targets = []string{key}
+	}
+	e.Events = append(e.Events, ExportedEvent{
+		Time:     finishedAt.UTC(),
+		Category: CategoryRestore,
+		Action:   source,
+		Database: database,
+		Targets:  targets,
+		Details:  details,
+	})
+}
+
+// covers reports whether t falls on one of the export's days
+func (e *Export) covers(t time.Time) bool {
+	return !t.Before(e.since) && t.Before(e.until.AddDate(0, 0, 1))
+}
+
+// sort orders backups by database, date and key and events by time
+func (e *Export) sort() {
+	slices.SortFunc(e.Backups, func(a, b ExportedBackup) int {
+		return strings.Compare(a.Database+"\x00"+a.Date+"\x00"+a.Key, b.Database+"\x00"+b.Date+"\x00"+b.Key)
+	})
+	slices.SortStableFunc(e.Events, func(a, b ExportedEvent) int { return a.Time.Compare(b.Time) })
+}
+
+// JSON encodes the export as indented JSON
+func (e *Export) JSON() ([]byte, error) {
+	e.sort()
+	return json.MarshalIndent(e, "", "  ")
+}
+
+// csvHeader are the columns of a CSV export
+var csvHeader = []string{"record", "time", "database", "storage", "key", "size_bytes", "sha256", "run_id", "retention", "expires_on", "action", "actor", "details"}
+
+// CSV encodes the export as a single CSV table: one row per backup, whose
+// record is "backup", followed by one per event, whose record is its category
+func (e *Export) CSV() ([]byte, error) {
+	e.sort()
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(csvHeader)
+	for _, backup := range e.Backups {
+		created := backup.Date
+		if backup.CreatedAt != nil {
+			created = backup.CreatedAt.Format(time.RFC3339)
+		}
+		details := ""
+		if backup.HoldReason != "" {
+			details = "hold_reason=" + backup.HoldReason
+		}
+		w.Write([]string{"backup", created, backup.Database, backup.Storage, backup.Key, strconv.FormatInt(backup.SizeBytes, 10), backup.SHA256, backup.RunID, backup.Retention, backup.ExpiresOn, "", "", details})
+	}
+	for _, event := range e.Events {
+		var details []string
+		for _, key := range slices.Sorted(maps.Keys(event.Details)) {
+			details = append(details, key+"="+event.Details[key])
+		}
+		w.Write([]string{event.Category, event.Time.Format(time.RFC3339), event.Database, event.Storage, strings.Join(event.Targets, " "), "", "", "", "", "", event.Action, event.Actor, strings.Join(details, "; ")})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// LoadSigningKey reads an Ed25519 private key from a PEM file in PKCS #8
+// form, as written by openssl genpkey -algorithm ed25519
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM encoded", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key %s: %w", path, err)
+	}
+	signingKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is not an Ed25519 key", path)
+	}
+	return signingKey, nil
+}
+
+// KeyFingerprint returns the hex SHA-256 digest of the DER encoded public key
+// of key, identifying the key a report was signed with
+func KeyFingerprint(key ed25519.PrivateKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,144 @@
+// Package completion completes db-backuper command lines for shells: command
+// and action names, the flags of each command and the formats of -output
+package completion
+
+import (
+	"flag"
+	"slices"
+	"strings"
+
+	"db-backuper/internal/output"
+)
+
+// Command is a subcommand offered for completion
+type Command struct {
+	Name        string
+	Description string
+	// Flags returns a new flag set of the command. It only defines the
+	// flags, so completion never runs anything of the command.
+	Flags func() *flag.FlagSet
+	// Args are the values of the arguments after the flags, such as the
+	// shells of completion; without them files are completed
+	Args []string
+	// Actions are the subcommands of a command without flags of its own,
+	// such as simulate of retention
+	Actions []Command
+}
+
+// Candidate is a completion of the word being typed
+type Candidate struct {
+	Value       string
+	Description string
+}
+
+// Complete returns the candidates for the last of words, the command line
+// after the program name ending with the word being typed, and whether the
+// shell should complete file names instead, such as for flag values.
+func Complete(commands []Command, words []string) (candidates []Candidate, files bool) {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	args, toComplete := words[:len(words)-1], words[len(words)-1]
+
+	// Walk down to the command or action being completed
+	for {
+		if len(args) == 0 {
+			return names(commands, toComplete), false
+		}
+		i := slices.IndexFunc(commands, func(c Command) bool { return c.Name == args[0] })
+		if i < 0 {
+			return nil, false
+		}
+		cmd := commands[i]
+		args = args[1:]
+		if cmd.Flags == nil {
+			commands = cmd.Actions
+			continue
+		}
+		return completeFlags(cmd, args, toComplete)
+	}
+}
+
+// names completes the names of commands
+func names(commands []Command, toComplete string) []Candidate {
+	var candidates []Candidate
+	for _, cmd := range commands {
+		if strings.HasPrefix(cmd.Name, toComplete) {
+			candidates = append(candidates, Candidate{cmd.Name, cmd.Description})
+		}
+	}
+	return candidates
+}
+
+// completeFlags completes the flag names of cmd, the formats of -output and
+// the arguments of cmd, leaving other values to file name completion
+func completeFlags(cmd Command, args []string, toComplete string) ([]Candidate, bool) {
+	fs := cmd.Flags()
+	if !strings.HasPrefix(toComplete, "-") {
+		if len(args) > 0 {
+			if f := lookup(fs, args[len(args)-1]); f != nil && !isBool(f) {
+				return values(f, "", toComplete)
+			}
+		}
+		if cmd.Args == nil {
+			return nil, true
+		}
+		var candidates []Candidate
+		for _, arg := range cmd.Args {
+			if strings.HasPrefix(arg, toComplete) {
+				candidates = append(candidates, Candidate{Value: arg})
+			}
+		}
+		return candidates, false
+	}
+
+	// A value given as -name=value
+	if name, value, ok := strings.Cut(toComplete, "="); ok {
+		if f := lookup(fs, name); f != nil {
+			return values(f, name+"=", value)
+		}
+		return nil, false
+	}
+
+	// Complete in the style typed, -name or --name
+	dashes := "-"
+	if strings.HasPrefix(toComplete, "--") {
+		dashes = "--"
+	}
+	var candidates []Candidate
+	fs.VisitAll(func(f *flag.Flag) {
+		if name := dashes + f.Name; strings.HasPrefix(name, toComplete) {
+			usage, _, _ := strings.Cut(f.Usage, "\n")
+			candidates = append(candidates, Candidate{name, usage})
+		}
+	})
+	return candidates, false
+}
+
+// values completes the value of flag f, offering the formats of -output
+func values(f *flag.Flag, prefix, toComplete string) ([]Candidate, bool) {
+	if _, ok := f.Value.(*output.Format); !ok {
+		return nil, true
+	}
+	var candidates []Candidate
+	for _, format := range output.Formats {
+		if strings.HasPrefix(format, toComplete) {
+			candidates = append(candidates, Candidate{Value: prefix + format})
+		}
+	}
+	return candidates, false
+}
+
+// lookup returns the flag of fs named by word, written -name or --name
+func lookup(fs *flag.FlagSet, word string) *flag.Flag {
+	if !strings.HasPrefix(word, "-") || strings.Contains(word, "=") {
+		return nil
+	}
+	return fs.Lookup(strings.TrimLeft(word, "-"))
+}
+
+// isBool reports whether f is a flag without a value, such as -force
+func isBool(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
@@ -0,0 +1,122 @@
+package completion
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RequestCommand is the hidden command the completion scripts run with the
+// words of the command line, the last one being the word typed
+const RequestCommand = "__complete"
+
+// filesDirective is written instead of candidates when the shell should
+// complete file names
+const filesDirective = ":files"
+
+// Write writes the answer to a completion request: one candidate per line
+// with its description after a tab, or the files directive
+func Write(w io.Writer, candidates []Candidate, files bool) error {
+	if files {
+		_, err := fmt.Fprintln(w, filesDirective)
+		return err
+	}
+	for _, candidate := range candidates {
+		line := candidate.Value
+		if candidate.Description != "" {
+			line += "\t" + candidate.Description
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Shells lists the shells Script supports
+var Shells = []string{"bash", "zsh", "fish"}
+
+// Script returns the completion script of shell for the db-backuper binary
+func Script(shell string) (string, error) {
+	var script string
+	switch shell {
+	case "bash":
+		script = bashScript
+	case "zsh":
+		script = zshScript
+	case "fish":
+		script = fishScript
+	default:
+		return "", fmt.Errorf("unknown shell %q, expected bash, zsh or fish", shell)
+	}
+	replacer := strings.NewReplacer("{{request}}", RequestCommand, "{{files}}", filesDirective)
+	return replacer.Replace(script), nil
+}
+
+// bashScript splits the line up to the cursor itself, as bash breaks words
+// at = and would split -output=json
+const bashScript = `# bash completion for db-backuper
+_db_backuper() {
+    local line="${COMP_LINE:0:COMP_POINT}" out cur
+    local -a words
+    read -ra words <<< "$line"
+    [[ "$line" == *[[:space:]] ]] && words+=("")
+    out=$("${words[0]}" {{request}} "${words[@]:1}" 2>/dev/null) || return
+    if [[ "$out" == "{{files}}" ]]; then
+        compopt -o default 2>/dev/null
+        COMPREPLY=()
+        return
+    fi
+    cur="${words[${#words[@]}-1]}"
+    local IFS=$'\n'
+    COMPREPLY=($(cut -f1 <<< "$out"))
+    # Bash replaces only the part after =, which it treats as a word break
+    if [[ "$cur" == *=* && "$COMP_WORDBREAKS" == *=* ]]; then
+        COMPREPLY=("${COMPREPLY[@]#*=}")
+    fi
+}
+complete -F _db_backuper db-backuper
+`
+
+const zshScript = `#compdef db-backuper
+
+_db_backuper() {
+    local out line value
+    local -a candidates
+    out=$("${words[1]}" {{request}} "${(@)words[2,CURRENT]}" 2>/dev/null) || return 1
+    if [[ "$out" == "{{files}}" ]]; then
+        _files
+        return
+    fi
+    for line in "${(@f)out}"; do
+        value="${line%%$'\t'*}"
+        value="${value//:/\\:}"
+        if [[ "$line" == *$'\t'* ]]; then
+            candidates+=("$value:${line#*$'\t'}")
+        else
+            candidates+=("$value")
+        fi
+    done
+    _describe -t commands 'db-backuper' candidates
+}
+
+if [[ "$funcstack[1]" == "_db_backuper" ]]; then
+    _db_backuper "$@"
+else
+    compdef _db_backuper db-backuper
+fi
+`
+
+const fishScript = `# fish completion for db-backuper
+function __db_backuper_complete
+    set -l words (commandline -opc)
+    set -l current (commandline -ct)
+    set -l out (command $words[1] {{request}} $words[2..-1] "$current" 2>/dev/null)
+    if test (count $out) -eq 1; and test "$out[1]" = "{{files}}"
+        __fish_complete_path "$current"
+        return
+    end
+    printf '%s\n' $out
+end
+complete -c db-backuper -f -a '(__db_backuper_complete)'
+`
@@ -0,0 +1,189 @@
+// Package compliance applies the regulated mode crypto policy and reports
+// whether a configuration satisfies it
+package compliance
+
+import (
+	"crypto/fips140"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"db-backuper/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PostgresMinProtocolEnv is the libpq variable setting the minimum TLS version of pg_dump, psql and pg_restore
+const PostgresMinProtocolEnv = "PGSSLMINPROTOCOLVERSION"
+
+// CipherSuites are the FIPS-approved TLS 1.2 cipher suites allowed in regulated mode.
+// TLS 1.3 suites are not configurable; run with GODEBUG=fips140=on to restrict them too.
+var CipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// encryptedSSLModes are the PostgreSQL ssl_mode values that refuse unencrypted connections
+var encryptedSSLModes = []string{"require", "verify-ca", "verify-full"}
+
+var regulated atomic.Bool
+
+// Enable switches the process to the regulated crypto policy
+func Enable() {
+	regulated.Store(true)
+	os.Setenv(PostgresMinProtocolEnv, "TLSv1.2")
+}
+
+// Enabled reports whether the regulated crypto policy is in force
+func Enabled() bool {
+	return regulated.Load()
+}
+
+// Restrict limits a client TLS configuration to TLS 1.2+ and the approved cipher suites
+func Restrict(tlsConfig *tls.Config) {
+	tlsConfig.MinVersion = tls.VersionTLS12
+	tlsConfig.CipherSuites = CipherSuites
+	tlsConfig.CurvePreferences = []tls.CurveID{tls.CurveP256, tls.CurveP384}
+}
+
+// HTTPClient returns an HTTP client restricted to the regulated crypto policy,
+// or nil when the policy is not in force so callers keep their default client
+func HTTPClient() *http.Client {
+	if !Enabled() {
+		return nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{}
+	Restrict(transport.TLSClientConfig)
+	return &http.Client{Transport: transport}
+}
+
+// Check is one line of the compliance report
+type Check struct {
+	Name   string
+	Passed bool
+	Detail string
+	// Advisory checks are reported but do not stop the service
+	Advisory bool
+}
+
+// Report is the outcome of checking a configuration against the regulated mode requirements
+type Report struct {
+	Checks []Check
+}
+
+// Evaluate checks cfg against the regulated mode requirements
+func Evaluate(cfg *config.Config) Report {
+	var r Report
+	r.add(Check{
+		Name:     "FIPS 140-3 module",
+		Passed:   fips140.Enabled(),
+		Detail:   "Go cryptographic module in FIPS 140-3 mode (GODEBUG=fips140=on)",
+		Advisory: true,
+	})
+	r.add(Check{
+		Name:   "TLS policy",
+		Passed: true,
+		Detail: "TLS 1.2+ with AES-GCM ECDHE cipher suites for S3 and PostgreSQL",
+	})
+
+	if cfg.IsAWSStorage() {
+		r.add(Check{
+			Name:   "S3 credentials",
+			Passed: cfg.AWS.AccessKeyID == "" && cfg.AWS.SecretAccessKey == "",
+			Detail: "no static access keys; use role_arn or web identity credentials",
+		})
+	}
+
+	for i, db := range cfg.Databases {
+		name := fmt.Sprintf("database %s", db.Database)
+		if db.Database == "" {
+			name = fmt.Sprintf("database %d", i)
+		}
+		if db.EngineType() == config.EngineTypePostgres {
+			r.add(sslModeCheck(name, db.SSLMode))
+		}
+		r.add(Check{
+			Name:   name + " credentials",
+			Passed: db.Password == "" && db.Redis.Password == "",
+			Detail: "no plaintext password; use iam_auth",
+		})
+	}
+
+	if cfg.Import.TargetDatabase.Host != "" {
+		r.add(sslModeCheck("import target database", cfg.Import.TargetDatabase.SSLMode))
+		r.add(Check{
+			Name:   "import target database credentials",
+			Passed: cfg.Import.TargetDatabase.Password == "",
+			Detail: "no plaintext password; use iam_auth",
+		})
+	}
+	return r
+}
+
+// sslModeCheck checks that a PostgreSQL connection refuses to fall back to plaintext
+func sslModeCheck(name, sslMode string) Check {
+	passed := false
+	for _, mode := range encryptedSSLModes {
+		if sslMode == mode {
+			passed = true
+		}
+	}
+	return Check{
+		Name:   name + " TLS",
+		Passed: passed,
+		Detail: fmt.Sprintf("ssl_mode %q; requires one of %s", sslMode, strings.Join(encryptedSSLModes, ", ")),
+	}
+}
+
+func (r *Report) add(check Check) {
+	r.Checks = append(r.Checks, check)
+}
+
+// Err returns an error naming every failed required check, or nil when the configuration complies
+func (r Report) Err() error {
+	var failed []string
+	for _, check := range r.Checks {
+		if !check.Passed && !check.Advisory {
+			failed = append(failed, check.Name)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("regulated mode requirements not met: %s", strings.Join(failed, ", "))
+}
+
+// Log writes the report, one line per check
+func (r Report) Log(logger logrus.FieldLogger) {
+	logger.Info("Compliance report (regulated mode)")
+	for _, check := range r.Checks {
+		entry := logger.WithField("check", check.Name)
+		switch {
+		case check.Passed:
+			entry.Infof("PASS: %s", check.Detail)
+		case check.Advisory:
+			entry.Warnf("WARN: %s", check.Detail)
+		default:
+			entry.Errorf("FAIL: %s", check.Detail)
+		}
+	}
+}
+
+// Apply enforces regulated mode when cfg enables it: it switches the process
+// to the regulated crypto policy, logs the compliance report and returns an
+// error when the configuration does not comply
+func Apply(cfg *config.Config, logger logrus.FieldLogger) error {
+	if !cfg.Compliance.Regulated {
+		return nil
+	}
+	Enable()
+	report := Evaluate(cfg)
+	report.Log(logger)
+	return report.Err()
+}
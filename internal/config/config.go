@@ -1,295 +1,2147 @@
 package config
 
 import (
-	"encoding/json"
+	"cmp"
+	"encoding/base64"
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/caarlos0/env/v11"
 )
 
 // Config holds all configuration for the backup application
 type Config struct {
-	Databases []DatabaseConfig `json:"databases"`
-	AWS       AWSConfig        `json:"aws"`
-	Local     LocalConfig      `json:"local"`
-	Backup    BackupConfig     `json:"backup"`
-	Import    ImportConfig     `json:"import"`
-	Logging   LoggingConfig    `json:"logging"`
+	Databases     []DatabaseConfig    `json:"databases"`
+	AWS           AWSConfig           `json:"aws"`
+	Local         LocalConfig         `json:"local"`
+	Rclone        RcloneConfig        `json:"rclone"`
+	StoragePlugin StoragePluginConfig `json:"storage_plugin"`
+	Backup        BackupConfig        `json:"backup"`
+	Import        ImportConfig        `json:"import"`
+	Rehearsal     RehearsalConfig     `json:"rehearsal"`
+	Subset        SubsetConfig        `json:"subset"`
+	Logging       LoggingConfig       `json:"logging"`
+	Status        StatusConfig        `json:"status"`
+	Audit         AuditConfig         `json:"audit"`
+	Compliance    ComplianceConfig    `json:"compliance"`
+	Notifications NotificationsConfig `json:"notifications"`
+	Metrics       MetricsConfig       `json:"metrics"`
+	API           APIConfig           `json:"api"`
+	Pricing       PricingConfig       `json:"pricing"`
+	Groups        []GroupConfig       `json:"groups"`
+	Profile       string              `json:"-"`
 }
 
-// DatabaseConfig holds PostgreSQL connection configuration
+// Database engine types
+const (
+	EngineTypePostgres   = "postgres"
+	EngineTypeSQLite     = "sqlite"
+	EngineTypeRedis      = "redis"
+	EngineTypeMSSQL      = "mssql"
+	EngineTypeCassandra  = "cassandra"
+	EngineTypeCommand    = "command"
+	EngineTypeFilesystem = "filesystem"
+)
+
+// PostgreSQL dump formats
+const (
+	PostgresFormatSQL       = "sql"
+	PostgresFormatDirectory = "directory"
+)
+
+// SQL Server backup methods
+const (
+	MSSQLMethodBackup = "backup"
+	MSSQLMethodBacpac = "bacpac"
+)
+
+// DatabaseConfig holds the connection configuration for a database to back up
 type DatabaseConfig struct {
-	Host     string `json:"host" env:"DB_HOST"`
-	Port     int    `json:"port" env:"DB_PORT"`
-	Username string `json:"username" env:"DB_USERNAME"`
-	Password string `json:"password" env:"DB_PASSWORD"`
-	Database string `json:"database" env:"DB_DATABASE"`
-	SSLMode  string `json:"ssl_mode" env:"DB_SSL_MODE"`
+	Type       string           `json:"type" env:"DB_TYPE"`
+	Path       string           `json:"path" env:"DB_PATH"`
+	Host       string           `json:"host" env:"DB_HOST"`
+	Port       int              `json:"port" env:"DB_PORT"`
+	Username   string           `json:"username" env:"DB_USERNAME"`
+	Password   string           `json:"password" env:"DB_PASSWORD"`
+	Database   string           `json:"database" env:"DB_DATABASE"`
+	SSLMode    string           `json:"ssl_mode" env:"DB_SSL_MODE"`
+	IAMAuth    bool             `json:"iam_auth" env:"DB_IAM_AUTH"`
+	IAMRegion  string           `json:"iam_region" env:"DB_IAM_REGION"`
+	Postgres   PostgresConfig   `json:"postgres"`
+	Redis      RedisConfig      `json:"redis"`
+	MSSQL      MSSQLConfig      `json:"mssql"`
+	Cassandra  CassandraConfig  `json:"cassandra"`
+	Command    CommandConfig    `json:"command"`
+	Filesystem FilesystemConfig `json:"filesystem"`
+	Quiesce    QuiesceConfig    `json:"quiesce"`
+	Storage    StorageConfig    `json:"storage"`
+	SLA        SLAConfig        `json:"sla"`
+	// Enabled set to false leaves the database out of backup runs while keeping its settings
+	Enabled *bool `json:"enabled,omitempty" env:"DB_ENABLED"`
+	// Priority orders the databases within a run, highest first
+	Priority int `json:"priority" env:"DB_PRIORITY"`
+}
+
+// IsEnabled returns true unless the database is disabled with enabled: false
+func (d *DatabaseConfig) IsEnabled() bool {
+	return d.Enabled == nil || *d.Enabled
+}
+
+// SLAConfig holds the freshness objectives of a database, evaluated
+// continuously in scheduler mode
+type SLAConfig struct {
+	// MaxAgeMinutes limits the time since the last successful backup finished
+	MaxAgeMinutes int `json:"max_age_minutes" env:"DB_SLA_MAX_AGE_MINUTES"`
+	// MaxRPOMinutes limits the time since the recovery point of the last
+	// successful backup, which is when that backup started
+	MaxRPOMinutes int `json:"max_rpo_minutes" env:"DB_SLA_MAX_RPO_MINUTES"`
+}
+
+// Enabled returns true if any objective is configured
+func (s *SLAConfig) Enabled() bool {
+	return s.MaxAgeMinutes > 0 || s.MaxRPOMinutes > 0
+}
+
+// MaxAge returns the maximum age of the last successful backup, or zero when unlimited
+func (s *SLAConfig) MaxAge() time.Duration {
+	return time.Duration(s.MaxAgeMinutes) * time.Minute
+}
+
+// MaxRPO returns the maximum recovery point objective, or zero when unlimited
+func (s *SLAConfig) MaxRPO() time.Duration {
+	return time.Duration(s.MaxRPOMinutes) * time.Minute
+}
+
+// StorageConfig overrides where the backups of a single database are stored
+type StorageConfig struct {
+	Bucket string `json:"bucket" env:"DB_STORAGE_BUCKET"`
+	Path   string `json:"path" env:"DB_STORAGE_PATH"`
+	Prefix string `json:"prefix" env:"DB_STORAGE_PREFIX"`
+}
+
+// PostgresConfig holds PostgreSQL dump options
+type PostgresConfig struct {
+	Format     string `json:"format" env:"DB_POSTGRES_FORMAT"`
+	DumpJobs   int    `json:"dump_jobs" env:"DB_POSTGRES_DUMP_JOBS"`
+	VerifyDump bool   `json:"verify_dump" env:"DB_POSTGRES_VERIFY_DUMP"`
+	// UploadFiles stores the files of directory format dumps as separate
+	// objects, transferred in parallel, instead of one archive
+	UploadFiles bool `json:"upload_files" env:"DB_POSTGRES_UPLOAD_FILES"`
+	// ChangeCapture streams changes from a logical replication slot between full dumps
+	ChangeCapture ChangeCaptureConfig `json:"change_capture"`
+}
+
+// Logical decoding output plugins of change capture
+const (
+	ChangeCapturePluginWal2JSON = "wal2json"
+	ChangeCapturePluginPgoutput = "pgoutput"
+)
+
+// Change capture defaults
+const (
+	DefaultChangeCaptureInterval   = time.Minute
+	DefaultChangeCaptureMaxChanges = 10000
+)
+
+// validSlotName matches the replication slot names PostgreSQL accepts
+var validSlotName = regexp.MustCompile(`^[a-z0-9_]{1,63}$`)
+
+// ChangeCaptureConfig holds the logical replication slot whose changes are
+// stored between full dumps of a PostgreSQL database
+type ChangeCaptureConfig struct {
+	Enabled bool `json:"enabled" env:"DB_POSTGRES_CDC_ENABLED"`
+	// Slot names the replication slot (default: db_backuper_<database>)
+	Slot string `json:"slot" env:"DB_POSTGRES_CDC_SLOT"`
+	// Plugin is the output plugin decoding the changes, wal2json or pgoutput
+	Plugin string `json:"plugin" env:"DB_POSTGRES_CDC_PLUGIN"`
+	// Publication selects the tables captured with pgoutput
+	Publication     string `json:"publication" env:"DB_POSTGRES_CDC_PUBLICATION"`
+	IntervalSeconds int    `json:"interval_seconds" env:"DB_POSTGRES_CDC_INTERVAL_SECONDS"`
+	// MaxChanges limits the changes stored in one batch; whole transactions are always kept together
+	MaxChanges int `json:"max_changes" env:"DB_POSTGRES_CDC_MAX_CHANGES"`
+}
+
+// RedisConfig holds Redis connection configuration
+type RedisConfig struct {
+	Host     string `json:"host" env:"DB_REDIS_HOST"`
+	Port     int    `json:"port" env:"DB_REDIS_PORT"`
+	Username string `json:"username" env:"DB_REDIS_USERNAME"`
+	Password string `json:"password" env:"DB_REDIS_PASSWORD"`
+	TLS      bool   `json:"tls" env:"DB_REDIS_TLS"`
+}
+
+// MSSQLConfig holds SQL Server backup options
+type MSSQLConfig struct {
+	Method                 string `json:"method" env:"DB_MSSQL_METHOD"`
+	ServerBackupDir        string `json:"server_backup_dir" env:"DB_MSSQL_SERVER_BACKUP_DIR"`
+	LocalBackupDir         string `json:"local_backup_dir" env:"DB_MSSQL_LOCAL_BACKUP_DIR"`
+	TrustServerCertificate bool   `json:"trust_server_certificate" env:"DB_MSSQL_TRUST_SERVER_CERTIFICATE"`
+}
+
+// CassandraConfig holds Cassandra and ScyllaDB snapshot options
+type CassandraConfig struct {
+	DataDir          string `json:"data_dir" env:"DB_CASSANDRA_DATA_DIR"`
+	Nodetool         string `json:"nodetool" env:"DB_CASSANDRA_NODETOOL"`
+	Node             string `json:"node" env:"DB_CASSANDRA_NODE"`
+	PreSnapshotHook  string `json:"pre_snapshot_hook" env:"DB_CASSANDRA_PRE_SNAPSHOT_HOOK"`
+	PostSnapshotHook string `json:"post_snapshot_hook" env:"DB_CASSANDRA_POST_SNAPSHOT_HOOK"`
+}
+
+// CommandConfig holds the command templates of a user-defined backup engine
+type CommandConfig struct {
+	Dump      string `json:"dump" env:"DB_COMMAND_DUMP"`
+	Restore   string `json:"restore" env:"DB_COMMAND_RESTORE"`
+	Extension string `json:"extension" env:"DB_COMMAND_EXTENSION"`
+	Compress  bool   `json:"compress" env:"DB_COMMAND_COMPRESS"`
+}
+
+// FilesystemConfig holds options for directory backups
+type FilesystemConfig struct {
+	Exclude []string `json:"exclude" env:"DB_FILESYSTEM_EXCLUDE" envSeparator:","`
+}
+
+// GroupConfig holds a set of databases backed up together
+type GroupConfig struct {
+	Name           string   `json:"name"`
+	Databases      []string `json:"databases"`
+	SharedSnapshot bool     `json:"shared_snapshot"`
+}
+
+// APIConfig holds the users of the job API served by scheduler mode with -listen
+type APIConfig struct {
+	// Users authenticate with a bearer token; without any, the API is open
+	// and takes no restores (configuration file only)
+	Users []APIUser `json:"users"`
+	// RestoreApproval holds restores requested through the API until a
+	// second user approves them
+	RestoreApproval bool `json:"restore_approval" env:"API_RESTORE_APPROVAL"`
+}
+
+// APIUser is a user of the job API
+type APIUser struct {
+	Name string `json:"name"`
+	// TokenSHA256 is the hex SHA-256 digest of the user's token, so the
+	// configuration holds no usable credential
+	TokenSHA256 string `json:"token_sha256"`
+	// Approver lets the user approve restores requested by others
+	Approver bool `json:"approver"`
+}
+
+// PricingConfig holds the storage prices the usage command estimates costs with
+type PricingConfig struct {
+	// S3 overrides the us-east-1 price of S3 storage classes, in USD per
+	// GB-month (configuration file only)
+	S3 map[string]float64 `json:"s3"`
+}
+
+// S3StorageClasses are the S3 storage classes prices can be set for
+var S3StorageClasses = []string{"STANDARD", "STANDARD_IA", "ONEZONE_IA", "INTELLIGENT_TIERING", "GLACIER_IR", "GLACIER", "DEEP_ARCHIVE"}
+
+// MetricsConfig holds the sinks receiving the metrics of every backup run
+type MetricsConfig struct {
+	CloudWatch CloudWatchConfig `json:"cloudwatch"`
+	StatsD     StatsDConfig     `json:"statsd"`
+}
+
+// CloudWatchConfig holds the CloudWatch metrics sink, enabled by setting a namespace
+type CloudWatchConfig struct {
+	Namespace string `json:"namespace" env:"METRICS_CLOUDWATCH_NAMESPACE"`
+	// Region defaults to the AWS region of the S3 configuration
+	Region string `json:"region" env:"METRICS_CLOUDWATCH_REGION"`
+}
+
+// StatsDConfig holds the StatsD metrics sink, enabled by setting an address.
+// Metrics carry DogStatsD tags, which plain StatsD servers ignore.
+type StatsDConfig struct {
+	Address     string   `json:"address" env:"METRICS_STATSD_ADDRESS"`
+	Prefix      string   `json:"prefix" env:"METRICS_STATSD_PREFIX"`
+	Environment string   `json:"environment" env:"METRICS_STATSD_ENVIRONMENT"`
+	Tags        []string `json:"tags" env:"METRICS_STATSD_TAGS"`
+}
+
+// DefaultStatsDPrefix is prepended to StatsD metric names when no prefix is configured
+const DefaultStatsDPrefix = "db_backup."
+
+// MetricPrefix returns the prefix of the StatsD metric names
+func (s *StatsDConfig) MetricPrefix() string {
+	if s.Prefix == "" {
+		return DefaultStatsDPrefix
+	}
+	return s.Prefix
+}
+
+// Notification channel types
+const (
+	ChannelWebhook = "webhook"
+	ChannelSlack   = "slack"
+	ChannelDiscord = "discord"
+	ChannelTeams   = "teams"
+	ChannelPlugin  = "plugin"
+)
+
+// When a channel is notified
+const (
+	NotifyOnFailure    = "failure"
+	NotifyAlways       = "always"
+	NotifyOnEscalation = "escalation"
+)
+
+// NotificationsConfig holds the channels notified after each backup run and
+// the policies limiting repeated failure notifications
+type NotificationsConfig struct {
+	Channels []ChannelConfig `json:"channels"`
+	// RepeatIntervalMinutes suppresses a failure notification identical to the
+	// previous one until this many minutes have passed; 0 sends every one
+	RepeatIntervalMinutes int `json:"repeat_interval_minutes"`
+	// EscalateAfter notifies escalation channels once a database has failed
+	// this many runs in a row; 0 disables escalation
+	EscalateAfter int `json:"escalate_after"`
+	// StatePath keeps failure counts and suppression state across restarts
+	StatePath string `json:"state_path"`
+}
+
+// RepeatInterval returns how long identical failure notifications are suppressed
+func (n *NotificationsConfig) RepeatInterval() time.Duration {
+	return time.Duration(n.RepeatIntervalMinutes) * time.Minute
+}
+
+// ChannelConfig holds a notification channel. Subject and Body are Go
+// templates executed against the run summary; empty ones use the defaults.
+type ChannelConfig struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	URL     string `json:"url"`
+	On      string `json:"on"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	// Plugin runs db-backuper-notify-<plugin> from PATH for plugin
+	// channels unless Binary is set
+	Plugin string   `json:"plugin"`
+	Binary string   `json:"binary"`
+	Args   []string `json:"args"`
+}
+
+// Command returns the executable of a plugin channel
+func (c *ChannelConfig) Command() string {
+	if c.Binary == "" {
+		return NotifyPluginPrefix + c.Plugin
+	}
+	return c.Binary
+}
+
+// Trigger returns when the channel is notified, defaulting to failed runs only
+func (c *ChannelConfig) Trigger() string {
+	if c.On == "" {
+		return NotifyOnFailure
+	}
+	return c.On
+}
+
+// DisplayName returns the channel name, defaulting to its type
+func (c *ChannelConfig) DisplayName() string {
+	if c.Name == "" {
+		return c.Type
+	}
+	return c.Name
+}
+
+// QuiesceConfig holds the actions pausing application writes while a
+// PostgreSQL backup takes its snapshot
+type QuiesceConfig struct {
+	AdvisoryLock   *int64   `json:"advisory_lock" env:"DB_QUIESCE_ADVISORY_LOCK"`
+	TimeoutSeconds int      `json:"timeout_seconds" env:"DB_QUIESCE_TIMEOUT_SECONDS"`
+	PreSQL         []string `json:"pre_sql"`
+	PostSQL        []string `json:"post_sql"`
+}
+
+// Enabled returns true if any quiesce action is configured
+func (q *QuiesceConfig) Enabled() bool {
+	return q.AdvisoryLock != nil || len(q.PreSQL) > 0 || len(q.PostSQL) > 0
+}
+
+// Timeout returns how long to wait for the advisory lock and pre-backup statements
+func (q *QuiesceConfig) Timeout() time.Duration {
+	if q.TimeoutSeconds <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(q.TimeoutSeconds) * time.Second
+}
+
+// AWSConfig holds AWS S3 configuration
+type AWSConfig struct {
+	Region               string `json:"region" env:"AWS_REGION"`
+	Bucket               string `json:"bucket" env:"AWS_BUCKET"`
+	AccessKeyID          string `json:"access_key_id" env:"AWS_ACCESS_KEY_ID"`
+	SecretAccessKey      string `json:"secret_access_key" env:"AWS_SECRET_ACCESS_KEY"`
+	SessionToken         string `json:"session_token" env:"AWS_SESSION_TOKEN"`
+	RoleARN              string `json:"role_arn" env:"AWS_ROLE_ARN"`
+	ExternalID           string `json:"external_id" env:"AWS_EXTERNAL_ID"`
+	RoleSessionName      string `json:"role_session_name" env:"AWS_ROLE_SESSION_NAME"`
+	WebIdentityTokenFile string `json:"web_identity_token_file" env:"AWS_WEB_IDENTITY_TOKEN_FILE"`
+
+	AbortIncompleteUploadsHours int `json:"abort_incomplete_uploads_hours" env:"AWS_ABORT_INCOMPLETE_UPLOADS_HOURS"`
+	ListRequestsPerSecond       int `json:"list_requests_per_second" env:"AWS_LIST_REQUESTS_PER_SECOND"`
+	// ShareTTLHours is how long the URLs of the share command stay valid
+	ShareTTLHours int `json:"share_ttl_hours" env:"AWS_SHARE_TTL_HOURS"`
+
+	// SSECustomerKey is a base64 encoded 256-bit key objects are encrypted
+	// with using SSE-C, so the key is held outside AWS
+	SSECustomerKey string `json:"sse_customer_key" env:"AWS_SSE_CUSTOMER_KEY"`
+	// SSEPreviousKeys are retired SSE-C keys still tried when reading
+	// objects, until rekey has moved every backup to the current key
+	SSEPreviousKeys []string `json:"sse_previous_keys" env:"AWS_SSE_PREVIOUS_KEYS"`
+
+	// Versioning, DefaultEncryption and KMSKeyID are set on the bucket by init-storage
+	Versioning bool `json:"versioning" env:"AWS_VERSIONING"`
+	// DefaultEncryption is AES256 for SSE-S3 or aws:kms for SSE-KMS
+	DefaultEncryption string `json:"default_encryption" env:"AWS_DEFAULT_ENCRYPTION"`
+	// KMSKeyID is the key of aws:kms encryption; empty uses the AWS managed key
+	KMSKeyID string `json:"kms_key_id" env:"AWS_KMS_KEY_ID"`
+
+	// Lifecycle holds the bucket lifecycle rules provisioned by apply-lifecycle
+	Lifecycle LifecycleConfig `json:"lifecycle"`
+}
+
+// Default bucket encryption algorithms
+const (
+	EncryptionAES256 = "AES256"
+	EncryptionKMS    = "aws:kms"
+)
+
+// LifecycleConfig holds the S3 lifecycle rules enforcing the backup policy
+// in the bucket, besides the application's own cleanup
+type LifecycleConfig struct {
+	// Transitions move backups to cheaper storage classes as they age
+	// (configuration file only)
+	Transitions []LifecycleTransition `json:"transitions"`
+	// ExpirationDays deletes backups this many days after they were written,
+	// as a backstop for retention cleanup
+	ExpirationDays int `json:"expiration_days" env:"AWS_LIFECYCLE_EXPIRATION_DAYS"`
+	// NoncurrentDays deletes the versions of backups that were deleted or
+	// replaced this many days ago, in versioned buckets
+	NoncurrentDays int `json:"noncurrent_days" env:"AWS_LIFECYCLE_NONCURRENT_DAYS"`
+}
+
+// LifecycleTransition moves backups to a storage class once they are Days old
+type LifecycleTransition struct {
+	Days         int    `json:"days"`
+	StorageClass string `json:"storage_class"`
+}
+
+// Enabled reports whether any lifecycle rule is configured
+func (l *LifecycleConfig) Enabled() bool {
+	return len(l.Transitions) > 0 || l.ExpirationDays > 0 || l.NoncurrentDays > 0
+}
+
+// DefaultListRequestsPerSecond limits backup listings when no rate is configured
+const DefaultListRequestsPerSecond = 10
+
+// DefaultShareTTLHours is how long shared URLs stay valid when no TTL is configured
+const DefaultShareTTLHours = 24
+
+// MaxShareTTL is the longest S3 accepts for a pre-signed URL
+const MaxShareTTL = 7 * 24 * time.Hour
+
+// ShareTTL returns how long the URLs of the share command stay valid
+func (a *AWSConfig) ShareTTL() time.Duration {
+	if a.ShareTTLHours == 0 {
+		return DefaultShareTTLHours * time.Hour
+	}
+	return time.Duration(a.ShareTTLHours) * time.Hour
+}
+
+// DefaultRoleSessionName names the sessions of an assumed backup role
+const DefaultRoleSessionName = "db-backuper"
+
+// LocalConfig holds local storage configuration
+type LocalConfig struct {
+	Path     string `json:"path" env:"LOCAL_BACKUP_PATH"`
+	Transfer string `json:"transfer" env:"LOCAL_TRANSFER_MODE"`
+
+	// MinFreeMB is the free space, in MiB, backups must leave on the backup disk
+	MinFreeMB int64  `json:"min_free_mb" env:"LOCAL_MIN_FREE_MB"`
+	LowSpace  string `json:"low_space" env:"LOCAL_LOW_SPACE"`
+
+	// NetworkMount marks the path as an NFS or SMB mount shared between hosts
+	NetworkMount bool `json:"network_mount" env:"LOCAL_NETWORK_MOUNT"`
+	IORetries    int  `json:"io_retries" env:"LOCAL_IO_RETRIES"`
+	// FileMode is the octal permission mode and FileOwner the uid:gid set on stored files
+	FileMode  string `json:"file_mode" env:"LOCAL_FILE_MODE"`
+	FileOwner string `json:"file_owner" env:"LOCAL_FILE_OWNER"`
+}
+
+// DefaultNetworkIORetries is how often an I/O operation failing with a
+// transient error is retried on a network mount when io_retries is not set
+const DefaultNetworkIORetries = 3
+
+// Retries returns how often an I/O operation failing with a transient error is retried
+func (l *LocalConfig) Retries() int {
+	if l.IORetries == 0 && l.NetworkMount {
+		return DefaultNetworkIORetries
+	}
+	return l.IORetries
+}
+
+// Permissions returns the mode set on stored files; ok is false when none is configured
+func (l *LocalConfig) Permissions() (mode os.FileMode, ok bool, err error) {
+	if l.FileMode == "" {
+		return 0, false, nil
+	}
+	value, err := strconv.ParseUint(l.FileMode, 8, 32)
+	if err != nil || value > 0777 {
+		return 0, false, fmt.Errorf("invalid local file_mode %q: must be octal permissions such as 0640", l.FileMode)
+	}
+	return os.FileMode(value), true, nil
+}
+
+// Owner returns the uid and gid set on stored files, -1 for each one left unchanged
+func (l *LocalConfig) Owner() (uid, gid int, err error) {
+	uid, gid = -1, -1
+	if l.FileOwner == "" {
+		return uid, gid, nil
+	}
+	userPart, groupPart, _ := strings.Cut(l.FileOwner, ":")
+	if userPart != "" {
+		if uid, err = strconv.Atoi(userPart); err != nil || uid < 0 {
+			return -1, -1, fmt.Errorf("invalid local file_owner %q: must be uid:gid", l.FileOwner)
+		}
+	}
+	if groupPart != "" {
+		if gid, err = strconv.Atoi(groupPart); err != nil || gid < 0 {
+			return -1, -1, fmt.Errorf("invalid local file_owner %q: must be uid:gid", l.FileOwner)
+		}
+	}
+	return uid, gid, nil
+}
+
+// Low space actions, what local storage does when a backup would leave less
+// than the configured free space
+const (
+	LowSpaceRefuse = "refuse"
+	LowSpacePrune  = "prune"
+)
+
+// Local transfer modes, how a finished dump is moved into local storage
+const (
+	LocalTransferCopy   = "copy"
+	LocalTransferLink   = "link"
+	LocalTransferRename = "rename"
+)
+
+// RcloneConfig holds the rclone remote backups are stored on, giving access
+// to every provider rclone supports
+type RcloneConfig struct {
+	// Remote is an rclone remote and optional path, e.g. "gdrive:backups"
+	Remote string   `json:"remote" env:"RCLONE_REMOTE"`
+	Binary string   `json:"binary" env:"RCLONE_BINARY"`
+	Flags  []string `json:"flags" env:"RCLONE_FLAGS" envSeparator:" "`
+}
+
+// DefaultRcloneBinary is the rclone executable run when no binary is configured
+const DefaultRcloneBinary = "rclone"
+
+// Command returns the rclone executable to run
+func (r *RcloneConfig) Command() string {
+	if r.Binary == "" {
+		return DefaultRcloneBinary
+	}
+	return r.Binary
+}
+
+// Prefixes of the executables plugins are looked up by on PATH
+const (
+	StoragePluginPrefix = "db-backuper-storage-"
+	NotifyPluginPrefix  = "db-backuper-notify-"
+)
+
+// validPluginName matches plugin names that stay a single executable name
+// when appended to a plugin prefix
+var validPluginName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// StoragePluginConfig holds the external program backups are stored through,
+// letting third parties add storage backends without changing this service
+type StoragePluginConfig struct {
+	// Name runs db-backuper-storage-<name> from PATH unless Binary is set
+	Name   string   `json:"name" env:"STORAGE_PLUGIN"`
+	Binary string   `json:"binary" env:"STORAGE_PLUGIN_BINARY"`
+	Args   []string `json:"args" env:"STORAGE_PLUGIN_ARGS" envSeparator:" "`
+}
+
+// Command returns the plugin executable to run
+func (p *StoragePluginConfig) Command() string {
+	if p.Binary == "" {
+		return StoragePluginPrefix + p.Name
+	}
+	return p.Binary
+}
+
+// DisplayName returns the plugin name, defaulting to the name of its executable
+func (p *StoragePluginConfig) DisplayName() string {
+	if p.Name == "" {
+		return filepath.Base(p.Binary)
+	}
+	return p.Name
+}
+
+// BackupConfig holds backup-specific configuration
+type BackupConfig struct {
+	RetentionDays int    `json:"retention_days" env:"BACKUP_RETENTION_DAYS"`
+	Schedule      string `json:"schedule" env:"BACKUP_SCHEDULE"`
+	BackupPrefix  string `json:"backup_prefix" env:"BACKUP_PREFIX"`
+	StateDir      string `json:"state_dir" env:"BACKUP_STATE_DIR"`
+	// CatchUp runs a scheduled backup missed while the service was stopped as soon as it starts
+	CatchUp bool `json:"catch_up" env:"BACKUP_CATCH_UP"`
+	// MaxRunMinutes is the time budget of a run, after which the databases
+	// not yet started are skipped
+	MaxRunMinutes int `json:"max_run_minutes" env:"BACKUP_MAX_RUN_MINUTES"`
+	// Instance names this deployment in backup metadata, lock files and the
+	// instances recorded under the backup prefix
+	Instance string `json:"instance" env:"BACKUP_INSTANCE"`
+	// TransferJobs limits how many files of a directory backup, or parts of
+	// a split one, are uploaded or downloaded at once
+	TransferJobs int `json:"transfer_jobs" env:"BACKUP_TRANSFER_JOBS"`
+	// MaxConcurrentJobs limits how many queued jobs of scheduler mode run at
+	// once. Jobs touching the same database never run together.
+	MaxConcurrentJobs int `json:"max_concurrent_jobs" env:"BACKUP_MAX_CONCURRENT_JOBS"`
+
+	// SplitSizeGB splits backups larger than this many GiB into parts of
+	// that size, each stored as its own object. 0 keeps backups whole.
+	SplitSizeGB int `json:"split_size_gb" env:"BACKUP_SPLIT_SIZE_GB"`
+
+	RetentionModTimeFallback bool `json:"retention_mtime_fallback" env:"BACKUP_RETENTION_MTIME_FALLBACK"`
+}
+
+// MaxRunDuration returns the time budget of a run, or zero when unlimited
+func (b *BackupConfig) MaxRunDuration() time.Duration {
+	return time.Duration(b.MaxRunMinutes) * time.Minute
+}
+
+// DefaultTransferJobs is how many files of a directory backup are transferred at once by default
+const DefaultTransferJobs = 8
+
+// Transfers returns how many files of a directory backup are transferred at once
+func (b *BackupConfig) Transfers() int {
+	if b.TransferJobs < 1 {
+		return DefaultTransferJobs
+	}
+	return b.TransferJobs
+}
+
+// ConcurrentJobs returns how many jobs of scheduler mode run at once
+func (b *BackupConfig) ConcurrentJobs() int {
+	if b.MaxConcurrentJobs < 1 {
+		return 1
+	}
+	return b.MaxConcurrentJobs
+}
+
+// InstanceName returns the name of this deployment: the configured
+// instance, else the Lambda function name or the hostname
+func (b *BackupConfig) InstanceName() string {
+	if b.Instance != "" {
+		return b.Instance
+	}
+	if name := os.Getenv("AWS_LAMBDA_FUNCTION_NAME"); name != "" {
+		return name
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// SplitSize returns the size of the parts backups are split into in bytes,
+// or zero when backups are kept whole
+func (b *BackupConfig) SplitSize() int64 {
+	return int64(b.SplitSizeGB) << 30
+}
+
+// DefaultStateDir holds job state kept across restarts when no state_dir is
+// configured
+var DefaultStateDir = defaultStateDir()
+
+// defaultStateDir returns a directory surviving reboots: the StateDirectory
+// of a systemd service, /var/lib/db-backuper for root, %ProgramData% on
+// Windows and the user configuration directory otherwise. Lambda can only
+// write to its temporary directory.
+func defaultStateDir() string {
+	tempDir := filepath.Join(os.TempDir(), "db-backuper", "state")
+	// systemd lists the state directories of a unit separated by colons
+	systemdDir, _, _ := strings.Cut(os.Getenv("STATE_DIRECTORY"), ":")
+	switch {
+	case systemdDir != "":
+		return systemdDir
+	case os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "":
+		return tempDir
+	case runtime.GOOS == "windows":
+		if dir := os.Getenv("ProgramData"); dir != "" {
+			return filepath.Join(dir, "db-backuper", "state")
+		}
+	case os.Geteuid() == 0:
+		return "/var/lib/db-backuper"
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, "db-backuper", "state")
+	}
+	return tempDir
+}
+
+// StateDirectory returns the directory holding job state kept across restarts
+func (b *BackupConfig) StateDirectory() string {
+	if b.StateDir == "" {
+		return DefaultStateDir
+	}
+	return b.StateDir
+}
+
+// ImportConfig holds import/restore configuration
+type ImportConfig struct {
+	TargetDatabase ImportDatabaseConfig `json:"target_database"`
+	BackupPath     string               `json:"backup_path" env:"IMPORT_BACKUP_PATH"`
+	DropExisting   bool                 `json:"drop_existing" env:"IMPORT_DROP_EXISTING"`
+	Jobs           int                  `json:"jobs" env:"IMPORT_JOBS"`
+	NoOwner        bool                 `json:"no_owner" env:"IMPORT_NO_OWNER"`
+	NoPrivileges   bool                 `json:"no_privileges" env:"IMPORT_NO_PRIVILEGES"`
+	Clean          bool                 `json:"clean" env:"IMPORT_CLEAN"`
+	IfExists       bool                 `json:"if_exists" env:"IMPORT_IF_EXISTS"`
+	SchemaOnly     bool                 `json:"schema_only" env:"IMPORT_SCHEMA_ONLY"`
+	Role           string               `json:"role" env:"IMPORT_ROLE"`
+	RoleMap        map[string]string    `json:"role_map" env:"IMPORT_ROLE_MAP" envSeparator:"," envKeyValSeparator:"="`
+	OnErrorStop    bool                 `json:"on_error_stop" env:"IMPORT_ON_ERROR_STOP"`
+	MaxErrors      *int                 `json:"max_errors" env:"IMPORT_MAX_ERRORS"`
+	ErrorReport    string               `json:"error_report" env:"IMPORT_ERROR_REPORT"`
+	RowCountReport string               `json:"row_count_report" env:"IMPORT_ROW_COUNT_REPORT"`
+	// Transforms rewrite the SQL of a backup as it is restored (configuration file only)
+	Transforms []TransformRule `json:"transforms"`
+	// Compatibility is fix to drop the statements of newer dumps an older
+	// target rejects, or warn to only report them
+	Compatibility string `json:"compatibility" env:"IMPORT_COMPATIBILITY"`
+}
+
+// Import compatibility modes
+const (
+	ImportCompatibilityFix  = "fix"
+	ImportCompatibilityWarn = "warn"
+)
+
+// TransformRule rewrites or drops the lines of a SQL script matching a
+// regular expression
+type TransformRule struct {
+	Name  string `json:"name"`
+	Match string `json:"match"`
+	// Replace is the replacement of the match, with $1 for its first group
+	Replace string `json:"replace"`
+	// Drop removes matching lines instead of rewriting them
+	Drop bool `json:"drop"`
+	// Data also applies the rule to COPY data, which is left untouched by default
+	Data bool `json:"data"`
+}
+
+// validateTransforms checks that every transform rule has a valid expression
+func validateTransforms(rules []TransformRule) error {
+	for i, rule := range rules {
+		if rule.Match == "" {
+			return fmt.Errorf("import transform %d requires match", i)
+		}
+		if _, err := regexp.Compile(rule.Match); err != nil {
+			return fmt.Errorf("invalid match of import transform %d: %w", i, err)
+		}
+		if rule.Drop && rule.Replace != "" {
+			return fmt.Errorf("import transform %d cannot both drop and replace", i)
+		}
+	}
+	return nil
+}
+
+// ImportDatabaseConfig holds target database configuration for imports
+type ImportDatabaseConfig struct {
+	Host      string `json:"host" env:"IMPORT_DB_HOST"`
+	Port      int    `json:"port" env:"IMPORT_DB_PORT"`
+	Username  string `json:"username" env:"IMPORT_DB_USERNAME"`
+	Password  string `json:"password" env:"IMPORT_DB_PASSWORD"`
+	Database  string `json:"database" env:"IMPORT_DB_DATABASE"`
+	SSLMode   string `json:"ssl_mode" env:"IMPORT_DB_SSL_MODE"`
+	IAMAuth   bool   `json:"iam_auth" env:"IMPORT_DB_IAM_AUTH"`
+	IAMRegion string `json:"iam_region" env:"IMPORT_DB_IAM_REGION"`
+}
+
+// RehearsalConfig holds the settings of restore rehearsals into disposable
+// PostgreSQL containers
+type RehearsalConfig struct {
+	Image                 string `json:"image" env:"REHEARSAL_IMAGE"`
+	PostgresVersion       string `json:"postgres_version" env:"REHEARSAL_POSTGRES_VERSION"`
+	DockerHost            string `json:"docker_host" env:"REHEARSAL_DOCKER_HOST"`
+	StartupTimeoutSeconds int    `json:"startup_timeout_seconds" env:"REHEARSAL_STARTUP_TIMEOUT_SECONDS"`
+	MaxErrors             *int   `json:"max_errors" env:"REHEARSAL_MAX_ERRORS"`
+	// Checks are queries run against the restored database, each of which
+	// must return true or a non-zero number
+	Checks []RehearsalCheck `json:"checks"`
+}
+
+// RehearsalCheck is a validation query run after a rehearsal restore
+type RehearsalCheck struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+// Defaults of restore rehearsals
+const (
+	DefaultRehearsalImage           = "postgres"
+	DefaultRehearsalPostgresVersion = "16"
+	DefaultRehearsalStartupTimeout  = 60 * time.Second
+)
+
+// ImageReference returns the image of the rehearsal container, tagged with
+// the PostgreSQL version unless version is empty
+func (r *RehearsalConfig) ImageReference(version string) string {
+	image := cmp.Or(r.Image, DefaultRehearsalImage)
+	if version == "" {
+		version = cmp.Or(r.PostgresVersion, DefaultRehearsalPostgresVersion)
+	}
+	return image + ":" + version
+}
+
+// StartupTimeout returns how long to wait for the rehearsal container to accept connections
+func (r *RehearsalConfig) StartupTimeout() time.Duration {
+	if r.StartupTimeoutSeconds <= 0 {
+		return DefaultRehearsalStartupTimeout
+	}
+	return time.Duration(r.StartupTimeoutSeconds) * time.Second
+}
+
+// SubsetConfig holds the rules reducing a backup to a referentially
+// consistent subset of its rows
+type SubsetConfig struct {
+	// Roots select the rows the subset starts from
+	Roots []SubsetRoot `json:"roots"`
+	// FollowChildren also keeps the rows referencing kept root rows, and the
+	// rows referencing those in turn
+	FollowChildren bool `json:"follow_children" env:"SUBSET_FOLLOW_CHILDREN"`
+	// ChildLimit caps the rows kept per table reached through FollowChildren, 0 for no limit
+	ChildLimit int `json:"child_limit" env:"SUBSET_CHILD_LIMIT"`
+	// KeepTables are kept whole, such as lookup tables
+	KeepTables []string `json:"keep_tables" env:"SUBSET_KEEP_TABLES" envSeparator:","`
+}
+
+// SubsetRoot selects the rows of a table a subset starts from
+type SubsetRoot struct {
+	Table string `json:"table"`
+	// Where is an SQL condition on the table's rows, empty for every row
+	Where string `json:"where"`
+	// Limit caps the rows selected, 0 for no limit
+	Limit int `json:"limit"`
+}
+
+// validate checks the subset rules
+func (s *SubsetConfig) validate() error {
+	for i, root := range s.Roots {
+		if root.Table == "" {
+			return fmt.Errorf("subset root %d requires a table", i)
+		}
+		if root.Limit < 0 {
+			return fmt.Errorf("subset root %d limit must not be negative", i)
+		}
+	}
+	if s.ChildLimit < 0 {
+		return fmt.Errorf("subset child_limit must not be negative")
+	}
+	if s.ChildLimit > 0 && !s.FollowChildren {
+		return fmt.Errorf("subset child_limit requires follow_children")
+	}
+	return nil
+}
+
+// LoggingConfig holds logging configuration
+type LoggingConfig struct {
+	Level  string `json:"level" env:"LOG_LEVEL"`
+	Format string `json:"format" env:"LOG_FORMAT"`
+}
+
+// StatusConfig holds configuration for the machine-readable status file
+type StatusConfig struct {
+	Path  string `json:"path" env:"STATUS_FILE_PATH"`
+	S3Key string `json:"s3_key" env:"STATUS_S3_KEY"`
+}
+
+// AuditConfig holds configuration for the audit log of destructive operations
+type AuditConfig struct {
+	Path     string `json:"path" env:"AUDIT_LOG_PATH"`
+	S3Prefix string `json:"s3_prefix" env:"AUDIT_S3_PREFIX"`
+}
+
+// ComplianceConfig holds settings for regulated environments such as FedRAMP
+type ComplianceConfig struct {
+	// Regulated restricts TLS to FIPS-approved cipher suites on TLS 1.2+, requires
+	// encrypted PostgreSQL connections and refuses plaintext credentials
+	Regulated bool `json:"regulated" env:"COMPLIANCE_REGULATED"`
+}
+
+// EngineType returns the database engine, defaulting to PostgreSQL
+func (d *DatabaseConfig) EngineType() string {
+	if d.Type == "" {
+		return EngineTypePostgres
+	}
+	return d.Type
+}
+
+// GetCLIArgs returns the redis-cli connection arguments. The password is not
+// included; pass it through the REDISCLI_AUTH environment variable instead.
+func (r *RedisConfig) GetCLIArgs() []string {
+	port := r.Port
+	if port == 0 {
+		port = 6379
+	}
+
+	args := []string{"-h", r.Host, "-p", fmt.Sprintf("%d", port)}
+	if r.Username != "" {
+		args = append(args, "--user", r.Username)
+	}
+	if r.TLS {
+		args = append(args, "--tls")
+	}
+	return args
+}
+
+// DumpFormat returns the PostgreSQL dump format, defaulting to a plain SQL script
+func (p *PostgresConfig) DumpFormat() string {
+	if p.Format == "" {
+		return PostgresFormatSQL
+	}
+	return p.Format
+}
+
+// SlotName returns the replication slot of a database, defaulting to one named after it
+func (c *ChangeCaptureConfig) SlotName(database string) string {
+	if c.Slot != "" {
+		return c.Slot
+	}
+	name := "db_backuper_" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		default:
+			return '_'
+		}
+	}, database)
+	return name[:min(len(name), 63)]
+}
+
+// OutputPlugin returns the logical decoding output plugin, defaulting to wal2json
+func (c *ChangeCaptureConfig) OutputPlugin() string {
+	return cmp.Or(c.Plugin, ChangeCapturePluginWal2JSON)
 }
 
-// AWSConfig holds AWS S3 configuration
-type AWSConfig struct {
-	Region          string `json:"region" env:"AWS_REGION"`
-	Bucket          string `json:"bucket" env:"AWS_BUCKET"`
-	AccessKeyID     string `json:"access_key_id" env:"AWS_ACCESS_KEY_ID"`
-	SecretAccessKey string `json:"secret_access_key" env:"AWS_SECRET_ACCESS_KEY"`
-}
+// Interval returns how often changes are read from the slot
+func (c *ChangeCaptureConfig) Interval() time.Duration {
+	if c.IntervalSeconds <= 0 {
+		return DefaultChangeCaptureInterval
+	}
+	return time.Duration(c.IntervalSeconds) * time.Second
+}
+
+// BatchSize returns the number of changes after which a batch is stored
+func (c *ChangeCaptureConfig) BatchSize() int {
+	return cmp.Or(c.MaxChanges, DefaultChangeCaptureMaxChanges)
+}
+
+// validate checks the change capture settings of an enabled capture
+func (c *ChangeCaptureConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Slot != "" && !validSlotName.MatchString(c.Slot) {
+		return fmt.Errorf("change_capture slot %q may only contain lowercase letters, digits and underscores, up to 63 characters", c.Slot)
+	}
+	switch c.OutputPlugin() {
+	case ChangeCapturePluginWal2JSON:
+	case ChangeCapturePluginPgoutput:
+		if c.Publication == "" {
+			return fmt.Errorf("change_capture publication is required with the pgoutput plugin")
+		}
+	default:
+		return fmt.Errorf("unsupported change_capture plugin %q", c.Plugin)
+	}
+	if c.IntervalSeconds < 0 || c.MaxChanges < 0 {
+		return fmt.Errorf("change_capture interval_seconds and max_changes must not be negative")
+	}
+	return nil
+}
+
+// Jobs returns the number of parallel pg_dump jobs, defaulting to one
+func (p *PostgresConfig) Jobs() int {
+	if p.DumpJobs < 1 {
+		return 1
+	}
+	return p.DumpJobs
+}
+
+// SessionName returns the session name used when assuming the backup role
+func (a *AWSConfig) SessionName() string {
+	if a.RoleSessionName == "" {
+		return DefaultRoleSessionName
+	}
+	return a.RoleSessionName
+}
+
+// ListRate returns the maximum number of list requests per second made by backup listings
+func (a *AWSConfig) ListRate() int {
+	if a.ListRequestsPerSecond == 0 {
+		return DefaultListRequestsPerSecond
+	}
+	return a.ListRequestsPerSecond
+}
+
+// CustomerKey returns the decoded SSE-C key, or nil when none is configured
+func (a *AWSConfig) CustomerKey() ([]byte, error) {
+	if a.SSECustomerKey == "" {
+		return nil, nil
+	}
+	return decodeCustomerKey("sse_customer_key", a.SSECustomerKey)
+}
+
+// PreviousCustomerKeys returns the decoded retired SSE-C keys
+func (a *AWSConfig) PreviousCustomerKeys() ([][]byte, error) {
+	keys := make([][]byte, 0, len(a.SSEPreviousKeys))
+	for _, encoded := range a.SSEPreviousKeys {
+		key, err := decodeCustomerKey("sse_previous_keys", encoded)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// decodeCustomerKey decodes a base64 encoded 256-bit SSE-C key
+func decodeCustomerKey(field, encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("aws %s must be a base64 encoded 256-bit key", field)
+	}
+	return key, nil
+}
+
+// HasCredentials reports whether static keys or a role to assume are configured
+func (a *AWSConfig) HasCredentials() bool {
+	return (a.AccessKeyID != "" && a.SecretAccessKey != "") || a.RoleARN != ""
+}
+
+// BackupMethod returns the SQL Server backup method, defaulting to native backups
+func (m *MSSQLConfig) BackupMethod() string {
+	if m.Method == "" {
+		return MSSQLMethodBackup
+	}
+	return m.Method
+}
+
+// LocalDir returns where this tool reads the files SQL Server writes to
+// server_backup_dir, defaulting to the same path
+func (m *MSSQLConfig) LocalDir() string {
+	if m.LocalBackupDir == "" {
+		return m.ServerBackupDir
+	}
+	return m.LocalBackupDir
+}
+
+// ServerPath returns the path of filename inside server_backup_dir as seen by
+// SQL Server, which may be running on Windows
+func (m *MSSQLConfig) ServerPath(filename string) string {
+	dir := m.ServerBackupDir
+	separator := "/"
+	if strings.Contains(dir, "\\") {
+		separator = "\\"
+	}
+	return strings.TrimRight(dir, separator) + separator + filename
+}
+
+// DataDirectory returns the node's data directory, defaulting to the package install location
+func (c *CassandraConfig) DataDirectory() string {
+	if c.DataDir == "" {
+		return "/var/lib/cassandra/data"
+	}
+	return c.DataDir
+}
+
+// NodetoolPath returns the nodetool executable
+func (c *CassandraConfig) NodetoolPath() string {
+	if c.Nodetool == "" {
+		return "nodetool"
+	}
+	return c.Nodetool
+}
+
+// NodeName returns the name identifying this node's artifacts, defaulting to the hostname
+func (c *CassandraConfig) NodeName() string {
+	if c.Node != "" {
+		return c.Node
+	}
+	if host, err := os.Hostname(); err == nil {
+		return host
+	}
+	return "node"
+}
+
+// FileExtension returns the extension of files produced by the dump command
+func (c *CommandConfig) FileExtension() string {
+	ext := strings.TrimPrefix(c.Extension, ".")
+	if ext == "" {
+		ext = "dump"
+	}
+	if c.Compress {
+		ext += ".gz"
+	}
+	return ext
+}
+
+// GetSQLCmdArgs returns the sqlcmd connection arguments. The password is not
+// included; pass it through the SQLCMDPASSWORD environment variable instead.
+func (d *DatabaseConfig) GetSQLCmdArgs() []string {
+	port := d.Port
+	if port == 0 {
+		port = 1433
+	}
+
+	args := []string{"-S", fmt.Sprintf("%s,%d", d.Host, port), "-U", d.Username, "-b"}
+	if d.MSSQL.TrustServerCertificate {
+		args = append(args, "-C")
+	}
+	return args
+}
+
+// GetSQLPackageArgs returns the sqlpackage connection arguments for the
+// Source or Target side of an export or import. The password is not
+// included; pass it in a response file instead.
+func (d *DatabaseConfig) GetSQLPackageArgs(side, database string) []string {
+	port := d.Port
+	if port == 0 {
+		port = 1433
+	}
+
+	args := []string{
+		fmt.Sprintf("/%sServerName:%s,%d", side, d.Host, port),
+		fmt.Sprintf("/%sDatabaseName:%s", side, database),
+		fmt.Sprintf("/%sUser:%s", side, d.Username),
+	}
+	if d.MSSQL.TrustServerCertificate {
+		args = append(args, fmt.Sprintf("/%sTrustServerCertificate:True", side))
+	}
+	return args
+}
+
+// GetConnectionString returns the PostgreSQL connection string
+func (d *DatabaseConfig) GetConnectionString() string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		d.Host, d.Port, d.Username, d.Password, d.Database, d.SSLMode)
+}
+
+// GetConnectionString returns the PostgreSQL connection string for import database
+func (d *ImportDatabaseConfig) GetConnectionString() string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		d.Host, d.Port, d.Username, d.Password, d.Database, d.SSLMode)
+}
+
+// LoadOptions controls how a configuration file is read
+type LoadOptions struct {
+	// Profile is the profile to apply; empty falls back to CONFIG_PROFILE
+	Profile string
+	// Strict rejects unknown fields and values of the wrong type
+	Strict bool
+}
+
+// LoadConfig loads configuration from appsettings.json with the selected profile applied.
+// Without a path and default file, the configuration comes from the environment only.
+func LoadConfig(configPath string, opts LoadOptions) (*Config, error) {
+	configPath = resolveConfigPath(configPath)
+	if configPath == "" {
+		if opts.Profile != "" {
+			return nil, fmt.Errorf("profile %s requires a configuration file", opts.Profile)
+		}
+		return LoadEnvConfig(EnvDefaults())
+	}
+
+	config, err := decodeConfigFile(configPath, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply environment variable overrides
+	if err := applyEnvOverrides(config); err != nil {
+		return nil, fmt.Errorf("failed to apply environment overrides: %w", err)
+	}
+
+	// Validate configuration
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("configuration validation failed: %w", err)
+	}
+
+	return config, nil
+}
+
+// LoadConfigForImport loads configuration from a JSON file for import operations
+func LoadConfigForImport(configPath string, opts LoadOptions) (*Config, error) {
+	var config *Config
+	if configPath = resolveConfigPath(configPath); configPath == "" {
+		if opts.Profile != "" {
+			return nil, fmt.Errorf("profile %s requires a configuration file", opts.Profile)
+		}
+		defaults := EnvDefaults()
+		config = &defaults
+	} else {
+		var err error
+		if config, err = decodeConfigFile(configPath, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	// Apply environment variable overrides
+	if err := applyEnvOverrides(config); err != nil {
+		return nil, fmt.Errorf("failed to apply environment overrides: %w", err)
+	}
+
+	// Validate configuration for import (allows empty databases)
+	if err := config.ValidateForImport(); err != nil {
+		return nil, fmt.Errorf("import configuration validation failed: %w", err)
+	}
+
+	return config, nil
+}
+
+// applyEnvOverrides applies environment variable overrides to the configuration
+func applyEnvOverrides(config *Config) error {
+	// Handle database arrays - check for both DB_* and DB_INDEX_* environment variables
+	// This allows overriding specific database configurations
+	for i := range config.Databases {
+		// First try DB_* variables (for the first database)
+		if i == 0 {
+			if err := parseDatabaseEnv(&config.Databases[i], "DB_"); err != nil {
+				return fmt.Errorf("failed to parse database %d environment variables: %w", i, err)
+			}
+		}
+
+		// Then try DB_INDEX_* variables (for all databases)
+		dbPrefix := fmt.Sprintf("DB_%d_", i)
+		if err := parseDatabaseEnv(&config.Databases[i], dbPrefix); err != nil {
+			return fmt.Errorf("failed to parse database %d environment variables: %w", i, err)
+		}
+	}
+
+	// Databases past the end of the file are defined by their indexed variables alone
+	discovered, err := discoverDatabases(len(config.Databases))
+	if err != nil {
+		return err
+	}
+	config.Databases = append(config.Databases, discovered...)
+
+	// Parse environment variables for the main config (excluding databases)
+	// We need to parse each section separately to avoid conflicts
+	if err := parseConfigSections(config); err != nil {
+		return fmt.Errorf("failed to parse environment variables: %w", err)
+	}
+
+	// Credentials may be mounted as files, such as Docker and Kubernetes secrets
+	return applySecretFiles(config)
+}
+
+// parseConfigSections parses environment variables for different config sections
+func parseConfigSections(config *Config) error {
+	// Parse AWS config
+	if err := env.Parse(&config.AWS); err != nil {
+		return fmt.Errorf("failed to parse AWS environment variables: %w", err)
+	}
+
+	// Parse Local config
+	if err := env.Parse(&config.Local); err != nil {
+		return fmt.Errorf("failed to parse Local environment variables: %w", err)
+	}
+
+	// Parse Rclone config
+	if err := env.Parse(&config.Rclone); err != nil {
+		return fmt.Errorf("failed to parse Rclone environment variables: %w", err)
+	}
+
+	// Parse storage plugin config
+	if err := env.Parse(&config.StoragePlugin); err != nil {
+		return fmt.Errorf("failed to parse storage plugin environment variables: %w", err)
+	}
+
+	// Parse Backup config
+	if err := env.Parse(&config.Backup); err != nil {
+		return fmt.Errorf("failed to parse Backup environment variables: %w", err)
+	}
+
+	// Parse Import config
+	if err := env.Parse(&config.Import); err != nil {
+		return fmt.Errorf("failed to parse Import environment variables: %w", err)
+	}
+
+	// Parse Rehearsal config
+	if err := env.Parse(&config.Rehearsal); err != nil {
+		return fmt.Errorf("failed to parse Rehearsal environment variables: %w", err)
+	}
+
+	// Parse Subset config
+	if err := env.Parse(&config.Subset); err != nil {
+		return fmt.Errorf("failed to parse Subset environment variables: %w", err)
+	}
+
+	// Parse Logging config
+	if err := env.Parse(&config.Logging); err != nil {
+		return fmt.Errorf("failed to parse Logging environment variables: %w", err)
+	}
+
+	// Parse Status config
+	if err := env.Parse(&config.Status); err != nil {
+		return fmt.Errorf("failed to parse Status environment variables: %w", err)
+	}
+
+	// Parse Audit config
+	if err := env.Parse(&config.Audit); err != nil {
+		return fmt.Errorf("failed to parse Audit environment variables: %w", err)
+	}
+
+	// Parse Metrics config
+	if err := env.Parse(&config.Metrics); err != nil {
+		return fmt.Errorf("failed to parse Metrics environment variables: %w", err)
+	}
+
+	// Parse API config
+	if err := env.Parse(&config.API); err != nil {
+		return fmt.Errorf("failed to parse API environment variables: %w", err)
+	}
+
+	// Parse Compliance config
+	if err := env.Parse(&config.Compliance); err != nil {
+		return fmt.Errorf("failed to parse Compliance environment variables: %w", err)
+	}
+
+	return nil
+}
+
+// parseDatabaseEnv parses environment variables for a specific database
+func parseDatabaseEnv(db *DatabaseConfig, prefix string) error {
+	// Create a temporary struct with prefixed env tags
+	type TempDB struct {
+		Enabled  *bool  `env:"ENABLED"`
+		Priority int    `env:"PRIORITY"`
+		Type     string `env:"TYPE"`
+		Path     string `env:"PATH"`
+		Host     string `env:"HOST"`
+		Port     int    `env:"PORT"`
+		Username string `env:"USERNAME"`
+		Password string `env:"PASSWORD"`
+		Database string `env:"DATABASE"`
+		SSLMode  string `env:"SSL_MODE"`
+
+		IAMAuth   bool   `env:"IAM_AUTH"`
+		IAMRegion string `env:"IAM_REGION"`
+
+		PostgresFormat      string `env:"POSTGRES_FORMAT"`
+		PostgresDumpJobs    int    `env:"POSTGRES_DUMP_JOBS"`
+		PostgresVerifyDump  bool   `env:"POSTGRES_VERIFY_DUMP"`
+		PostgresUploadFiles bool   `env:"POSTGRES_UPLOAD_FILES"`
+
+		PostgresCDCEnabled         bool   `env:"POSTGRES_CDC_ENABLED"`
+		PostgresCDCSlot            string `env:"POSTGRES_CDC_SLOT"`
+		PostgresCDCPlugin          string `env:"POSTGRES_CDC_PLUGIN"`
+		PostgresCDCPublication     string `env:"POSTGRES_CDC_PUBLICATION"`
+		PostgresCDCIntervalSeconds int    `env:"POSTGRES_CDC_INTERVAL_SECONDS"`
+		PostgresCDCMaxChanges      int    `env:"POSTGRES_CDC_MAX_CHANGES"`
+
+		RedisHost     string `env:"REDIS_HOST"`
+		RedisPort     int    `env:"REDIS_PORT"`
+		RedisUsername string `env:"REDIS_USERNAME"`
+		RedisPassword string `env:"REDIS_PASSWORD"`
+		RedisTLS      bool   `env:"REDIS_TLS"`
+
+		MSSQLMethod                 string `env:"MSSQL_METHOD"`
+		MSSQLServerBackupDir        string `env:"MSSQL_SERVER_BACKUP_DIR"`
+		MSSQLLocalBackupDir         string `env:"MSSQL_LOCAL_BACKUP_DIR"`
+		MSSQLTrustServerCertificate bool   `env:"MSSQL_TRUST_SERVER_CERTIFICATE"`
+
+		CassandraDataDir          string `env:"CASSANDRA_DATA_DIR"`
+		CassandraNodetool         string `env:"CASSANDRA_NODETOOL"`
+		CassandraNode             string `env:"CASSANDRA_NODE"`
+		CassandraPreSnapshotHook  string `env:"CASSANDRA_PRE_SNAPSHOT_HOOK"`
+		CassandraPostSnapshotHook string `env:"CASSANDRA_POST_SNAPSHOT_HOOK"`
+
+		CommandDump      string `env:"COMMAND_DUMP"`
+		CommandRestore   string `env:"COMMAND_RESTORE"`
+		CommandExtension string `env:"COMMAND_EXTENSION"`
+		CommandCompress  bool   `env:"COMMAND_COMPRESS"`
+
+		FilesystemExclude []string `env:"FILESYSTEM_EXCLUDE" envSeparator:","`
+
+		QuiesceAdvisoryLock   *int64 `env:"QUIESCE_ADVISORY_LOCK"`
+		QuiesceTimeoutSeconds int    `env:"QUIESCE_TIMEOUT_SECONDS"`
+
+		SLAMaxAgeMinutes int `env:"SLA_MAX_AGE_MINUTES"`
+		SLAMaxRPOMinutes int `env:"SLA_MAX_RPO_MINUTES"`
+
+		StorageBucket string `env:"STORAGE_BUCKET"`
+		StoragePath   string `env:"STORAGE_PATH"`
+		StoragePrefix string `env:"STORAGE_PREFIX"`
+	}
+
+	tempDB := TempDB{
+		Enabled:  db.Enabled,
+		Priority: db.Priority,
+		Type:     db.Type,
+		Path:     db.Path,
+		Host:     db.Host,
+		Port:     db.Port,
+		Username: db.Username,
+		Password: db.Password,
+		Database: db.Database,
+		SSLMode:  db.SSLMode,
+
+		IAMAuth:   db.IAMAuth,
+		IAMRegion: db.IAMRegion,
+
+		PostgresFormat:      db.Postgres.Format,
+		PostgresDumpJobs:    db.Postgres.DumpJobs,
+		PostgresVerifyDump:  db.Postgres.VerifyDump,
+		PostgresUploadFiles: db.Postgres.UploadFiles,
+
+		PostgresCDCEnabled:         db.Postgres.ChangeCapture.Enabled,
+		PostgresCDCSlot:            db.Postgres.ChangeCapture.Slot,
+		PostgresCDCPlugin:          db.Postgres.ChangeCapture.Plugin,
+		PostgresCDCPublication:     db.Postgres.ChangeCapture.Publication,
+		PostgresCDCIntervalSeconds: db.Postgres.ChangeCapture.IntervalSeconds,
+		PostgresCDCMaxChanges:      db.Postgres.ChangeCapture.MaxChanges,
+
+		RedisHost:     db.Redis.Host,
+		RedisPort:     db.Redis.Port,
+		RedisUsername: db.Redis.Username,
+		RedisPassword: db.Redis.Password,
+		RedisTLS:      db.Redis.TLS,
+
+		MSSQLMethod:                 db.MSSQL.Method,
+		MSSQLServerBackupDir:        db.MSSQL.ServerBackupDir,
+		MSSQLLocalBackupDir:         db.MSSQL.LocalBackupDir,
+		MSSQLTrustServerCertificate: db.MSSQL.TrustServerCertificate,
+
+		CassandraDataDir:          db.Cassandra.DataDir,
+		CassandraNodetool:         db.Cassandra.Nodetool,
+		CassandraNode:             db.Cassandra.Node,
+		CassandraPreSnapshotHook:  db.Cassandra.PreSnapshotHook,
+		CassandraPostSnapshotHook: db.Cassandra.PostSnapshotHook,
+
+		CommandDump:      db.Command.Dump,
+		CommandRestore:   db.Command.Restore,
+		CommandExtension: db.Command.Extension,
+		CommandCompress:  db.Command.Compress,
+
+		FilesystemExclude: db.Filesystem.Exclude,
+
+		QuiesceAdvisoryLock:   db.Quiesce.AdvisoryLock,
+		QuiesceTimeoutSeconds: db.Quiesce.TimeoutSeconds,
+
+		SLAMaxAgeMinutes: db.SLA.MaxAgeMinutes,
+		SLAMaxRPOMinutes: db.SLA.MaxRPOMinutes,
+
+		StorageBucket: db.Storage.Bucket,
+		StoragePath:   db.Storage.Path,
+		StoragePrefix: db.Storage.Prefix,
+	}
+
+	// Parse with custom prefix
+	opts := env.Options{
+		Prefix: prefix,
+	}
+	if err := env.ParseWithOptions(&tempDB, opts); err != nil {
+		return err
+	}
+
+	// Update the original database config if environment variables were set
+	if os.Getenv(prefix+"ENABLED") != "" {
+		db.Enabled = tempDB.Enabled
+	}
+	if os.Getenv(prefix+"PRIORITY") != "" {
+		db.Priority = tempDB.Priority
+	}
+	if os.Getenv(prefix+"TYPE") != "" {
+		db.Type = tempDB.Type
+	}
+	if os.Getenv(prefix+"PATH") != "" {
+		db.Path = tempDB.Path
+	}
+	if os.Getenv(prefix+"HOST") != "" {
+		db.Host = tempDB.Host
+	}
+	if os.Getenv(prefix+"PORT") != "" {
+		db.Port = tempDB.Port
+	}
+	if os.Getenv(prefix+"USERNAME") != "" {
+		db.Username = tempDB.Username
+	}
+	if os.Getenv(prefix+"PASSWORD") != "" {
+		db.Password = tempDB.Password
+	}
+	if os.Getenv(prefix+"DATABASE") != "" {
+		db.Database = tempDB.Database
+	}
+	if os.Getenv(prefix+"SSL_MODE") != "" {
+		db.SSLMode = tempDB.SSLMode
+	}
+	if os.Getenv(prefix+"IAM_AUTH") != "" {
+		db.IAMAuth = tempDB.IAMAuth
+	}
+	if os.Getenv(prefix+"IAM_REGION") != "" {
+		db.IAMRegion = tempDB.IAMRegion
+	}
+	if os.Getenv(prefix+"POSTGRES_FORMAT") != "" {
+		db.Postgres.Format = tempDB.PostgresFormat
+	}
+	if os.Getenv(prefix+"POSTGRES_DUMP_JOBS") != "" {
+		db.Postgres.DumpJobs = tempDB.PostgresDumpJobs
+	}
+	if os.Getenv(prefix+"POSTGRES_VERIFY_DUMP") != "" {
+		db.Postgres.VerifyDump = tempDB.PostgresVerifyDump
+	}
+	if os.Getenv(prefix+"POSTGRES_UPLOAD_FILES") != "" {
+		db.Postgres.UploadFiles = tempDB.PostgresUploadFiles
+	}
+	if os.Getenv(prefix+"POSTGRES_CDC_ENABLED") != "" {
+		db.Postgres.ChangeCapture.Enabled = tempDB.PostgresCDCEnabled
+	}
+	if os.Getenv(prefix+"POSTGRES_CDC_SLOT") != "" {
+		db.Postgres.ChangeCapture.Slot = tempDB.PostgresCDCSlot
+	}
+	if os.Getenv(prefix+"POSTGRES_CDC_PLUGIN") != "" {
+		db.Postgres.ChangeCapture.Plugin = tempDB.PostgresCDCPlugin
+	}
+	if os.Getenv(prefix+"POSTGRES_CDC_PUBLICATION") != "" {
+		db.Postgres.ChangeCapture.Publication = tempDB.PostgresCDCPublication
+	}
+	if os.Getenv(prefix+"POSTGRES_CDC_INTERVAL_SECONDS") != "" {
+		db.Postgres.ChangeCapture.IntervalSeconds = tempDB.PostgresCDCIntervalSeconds
+	}
+	if os.Getenv(prefix+"POSTGRES_CDC_MAX_CHANGES") != "" {
+		db.Postgres.ChangeCapture.MaxChanges = tempDB.PostgresCDCMaxChanges
+	}
+	if os.Getenv(prefix+"REDIS_HOST") != "" {
+		db.Redis.Host = tempDB.RedisHost
+	}
+	if os.Getenv(prefix+"REDIS_PORT") != "" {
+		db.Redis.Port = tempDB.RedisPort
+	}
+	if os.Getenv(prefix+"REDIS_USERNAME") != "" {
+		db.Redis.Username = tempDB.RedisUsername
+	}
+	if os.Getenv(prefix+"REDIS_PASSWORD") != "" {
+		db.Redis.Password = tempDB.RedisPassword
+	}
+	if os.Getenv(prefix+"REDIS_TLS") != "" {
+		db.Redis.TLS = tempDB.RedisTLS
+	}
+	if os.Getenv(prefix+"MSSQL_METHOD") != "" {
+		db.MSSQL.Method = tempDB.MSSQLMethod
+	}
+	if os.Getenv(prefix+"MSSQL_SERVER_BACKUP_DIR") != "" {
+		db.MSSQL.ServerBackupDir = tempDB.MSSQLServerBackupDir
+	}
+	if os.Getenv(prefix+"MSSQL_LOCAL_BACKUP_DIR") != "" {
+		db.MSSQL.LocalBackupDir = tempDB.MSSQLLocalBackupDir
+	}
+	if os.Getenv(prefix+"MSSQL_TRUST_SERVER_CERTIFICATE") != "" {
+		db.MSSQL.TrustServerCertificate = tempDB.MSSQLTrustServerCertificate
+	}
+	if os.Getenv(prefix+"CASSANDRA_DATA_DIR") != "" {
+		db.Cassandra.DataDir = tempDB.CassandraDataDir
+	}
+	if os.Getenv(prefix+"CASSANDRA_NODETOOL") != "" {
+		db.Cassandra.Nodetool = tempDB.CassandraNodetool
+	}
+	if os.Getenv(prefix+"CASSANDRA_NODE") != "" {
+		db.Cassandra.Node = tempDB.CassandraNode
+	}
+	if os.Getenv(prefix+"CASSANDRA_PRE_SNAPSHOT_HOOK") != "" {
+		db.Cassandra.PreSnapshotHook = tempDB.CassandraPreSnapshotHook
+	}
+	if os.Getenv(prefix+"CASSANDRA_POST_SNAPSHOT_HOOK") != "" {
+		db.Cassandra.PostSnapshotHook = tempDB.CassandraPostSnapshotHook
+	}
+	if os.Getenv(prefix+"COMMAND_DUMP") != "" {
+		db.Command.Dump = tempDB.CommandDump
+	}
+	if os.Getenv(prefix+"COMMAND_RESTORE") != "" {
+		db.Command.Restore = tempDB.CommandRestore
+	}
+	if os.Getenv(prefix+"COMMAND_EXTENSION") != "" {
+		db.Command.Extension = tempDB.CommandExtension
+	}
+	if os.Getenv(prefix+"COMMAND_COMPRESS") != "" {
+		db.Command.Compress = tempDB.CommandCompress
+	}
+	if os.Getenv(prefix+"FILESYSTEM_EXCLUDE") != "" {
+		db.Filesystem.Exclude = tempDB.FilesystemExclude
+	}
+	if os.Getenv(prefix+"QUIESCE_ADVISORY_LOCK") != "" {
+		db.Quiesce.AdvisoryLock = tempDB.QuiesceAdvisoryLock
+	}
+	if os.Getenv(prefix+"QUIESCE_TIMEOUT_SECONDS") != "" {
+		db.Quiesce.TimeoutSeconds = tempDB.QuiesceTimeoutSeconds
+	}
+	if os.Getenv(prefix+"SLA_MAX_AGE_MINUTES") != "" {
+		db.SLA.MaxAgeMinutes = tempDB.SLAMaxAgeMinutes
+	}
+	if os.Getenv(prefix+"SLA_MAX_RPO_MINUTES") != "" {
+		db.SLA.MaxRPOMinutes = tempDB.SLAMaxRPOMinutes
+	}
+	if os.Getenv(prefix+"STORAGE_BUCKET") != "" {
+		db.Storage.Bucket = tempDB.StorageBucket
+	}
+	if os.Getenv(prefix+"STORAGE_PATH") != "" {
+		db.Storage.Path = tempDB.StoragePath
+	}
+	if os.Getenv(prefix+"STORAGE_PREFIX") != "" {
+		db.Storage.Prefix = tempDB.StoragePrefix
+	}
+
+	return nil
+}
+
+// Validate checks if the configuration is valid
+func (c *Config) Validate() error {
+	return c.ValidateForBackup()
+}
+
+// ValidateForBackup checks if the configuration is valid for backup operations
+func (c *Config) ValidateForBackup() error {
+	// Check if databases are configured
+	if len(c.Databases) == 0 {
+		return fmt.Errorf("at least one database must be configured")
+	}
+	if len(c.DisabledDatabases()) == len(c.Databases) {
+		return fmt.Errorf("at least one database must be enabled")
+	}
+
+	// Validate each database configuration
+	for i, db := range c.Databases {
+		if db.Database == "" {
+			return fmt.Errorf("database name is required for database %d", i)
+		}
+		// Disabled databases may keep incomplete settings until they are enabled again
+		if !db.IsEnabled() {
+			continue
+		}
+		switch db.EngineType() {
+		case EngineTypePostgres:
+			if db.Host == "" {
+				return fmt.Errorf("database host is required for database %d", i)
+			}
+			if db.Username == "" {
+				return fmt.Errorf("database username is required for database %d", i)
+			}
+			if err := validateIAMAuth(db.IAMAuth, db.Password, db.SSLMode); err != nil {
+				return fmt.Errorf("%w for database %d", err, i)
+			}
+			switch db.Postgres.DumpFormat() {
+			case PostgresFormatSQL:
+				if db.Postgres.DumpJobs > 1 {
+					return fmt.Errorf("dump_jobs requires the directory format for database %d", i)
+				}
+				if db.Postgres.UploadFiles {
+					return fmt.Errorf("upload_files requires the directory format for database %d", i)
+				}
+			case PostgresFormatDirectory:
+				if db.Postgres.VerifyDump {
+					return fmt.Errorf("verify_dump requires the sql format for database %d", i)
+				}
+			default:
+				return fmt.Errorf("unsupported postgres format %q for database %d", db.Postgres.Format, i)
+			}
+			if err := db.Postgres.ChangeCapture.validate(); err != nil {
+				return fmt.Errorf("%w for database %d", err, i)
+			}
+		case EngineTypeSQLite:
+			if db.Path == "" {
+				return fmt.Errorf("database path is required for sqlite database %d", i)
+			}
+		case EngineTypeRedis:
+			if db.Redis.Host == "" {
+				return fmt.Errorf("redis host is required for database %d", i)
+			}
+		case EngineTypeMSSQL:
+			if db.Host == "" {
+				return fmt.Errorf("database host is required for database %d", i)
+			}
+			if db.Username == "" {
+				return fmt.Errorf("database username is required for database %d", i)
+			}
+			if db.Password == "" {
+				return fmt.Errorf("database password is required for database %d", i)
+			}
+			switch db.MSSQL.BackupMethod() {
+			case MSSQLMethodBackup:
+				if db.MSSQL.ServerBackupDir == "" {
+					return fmt.Errorf("mssql server_backup_dir is required for database %d", i)
+				}
+			case MSSQLMethodBacpac:
+			default:
+				return fmt.Errorf("unsupported mssql method %q for database %d", db.MSSQL.Method, i)
+			}
+		case EngineTypeCassandra:
+			// The keyspace is the database name; everything else has defaults
+		case EngineTypeCommand:
+			if db.Command.Dump == "" {
+				return fmt.Errorf("command dump template is required for database %d", i)
+			}
+		case EngineTypeFilesystem:
+			if db.Path == "" {
+				return fmt.Errorf("directory path is required for filesystem database %d", i)
+			}
+		default:
+			return fmt.Errorf("unsupported database type %q for database %d", db.Type, i)
+		}
+
+		if db.IAMAuth && db.EngineType() != EngineTypePostgres {
+			return fmt.Errorf("iam_auth is only supported for PostgreSQL databases (database %d)", i)
+		}
+		if db.Quiesce.Enabled() && db.EngineType() != EngineTypePostgres {
+			return fmt.Errorf("quiesce is only supported for PostgreSQL databases (database %d)", i)
+		}
+		if db.Postgres.ChangeCapture.Enabled && db.EngineType() != EngineTypePostgres {
+			return fmt.Errorf("change_capture is only supported for PostgreSQL databases (database %d)", i)
+		}
+		if db.SLA.MaxAgeMinutes < 0 || db.SLA.MaxRPOMinutes < 0 {
+			return fmt.Errorf("sla limits must not be negative (database %d)", i)
+		}
+		if db.Storage.Bucket != "" && !c.IsAWSStorage() {
+			return fmt.Errorf("storage bucket requires AWS S3 storage (database %d)", i)
+		}
+		if db.Storage.Path != "" && !c.IsLocalStorage() {
+			return fmt.Errorf("storage path requires local storage (database %d)", i)
+		}
+	}
+
+	// Check that exactly one of local path, AWS S3, rclone and a storage plugin is configured
+	hasLocal := c.Local.Path != ""
+	hasAWS := c.IsAWSStorage()
+	hasRclone := c.IsRcloneStorage()
+	hasPlugin := c.IsPluginStorage()
+
+	if !hasLocal && !hasAWS && !hasRclone && !hasPlugin {
+		return fmt.Errorf("either local storage path, AWS S3, rclone remote or storage plugin configuration is required")
+	}
+
+	if hasLocal && hasAWS {
+		return fmt.Errorf("both local storage and AWS S3 are configured, please choose one")
+	}
+
+	if hasRclone && (hasLocal || hasAWS) {
+		return fmt.Errorf("an rclone remote and local storage or AWS S3 are configured, please choose one")
+	}
 
-// LocalConfig holds local storage configuration
-type LocalConfig struct {
-	Path string `json:"path" env:"LOCAL_BACKUP_PATH"`
-}
+	if hasPlugin && (hasLocal || hasAWS || hasRclone) {
+		return fmt.Errorf("a storage plugin and local storage, AWS S3 or an rclone remote are configured, please choose one")
+	}
+	if c.StoragePlugin.Name != "" && !validPluginName.MatchString(c.StoragePlugin.Name) {
+		return fmt.Errorf("invalid storage plugin name %q: use letters, digits, dashes and underscores", c.StoragePlugin.Name)
+	}
 
-// BackupConfig holds backup-specific configuration
-type BackupConfig struct {
-	RetentionDays int    `json:"retention_days" env:"BACKUP_RETENTION_DAYS"`
-	Schedule      string `json:"schedule" env:"BACKUP_SCHEDULE"`
-	BackupPrefix  string `json:"backup_prefix" env:"BACKUP_PREFIX"`
-}
+	if (c.AWS.ExternalID != "" || c.AWS.WebIdentityTokenFile != "") && c.AWS.RoleARN == "" {
+		return fmt.Errorf("aws external_id and web_identity_token_file require role_arn")
+	}
 
-// ImportConfig holds import/restore configuration
-type ImportConfig struct {
-	TargetDatabase ImportDatabaseConfig `json:"target_database"`
-	BackupPath     string               `json:"backup_path" env:"IMPORT_BACKUP_PATH"`
-	DropExisting   bool                 `json:"drop_existing" env:"IMPORT_DROP_EXISTING"`
-}
+	switch c.Local.Transfer {
+	case "", LocalTransferCopy, LocalTransferLink, LocalTransferRename:
+	default:
+		return fmt.Errorf("invalid local transfer mode %q: must be copy, link or rename", c.Local.Transfer)
+	}
 
-// ImportDatabaseConfig holds target database configuration for imports
-type ImportDatabaseConfig struct {
-	Host     string `json:"host" env:"IMPORT_DB_HOST"`
-	Port     int    `json:"port" env:"IMPORT_DB_PORT"`
-	Username string `json:"username" env:"IMPORT_DB_USERNAME"`
-	Password string `json:"password" env:"IMPORT_DB_PASSWORD"`
-	Database string `json:"database" env:"IMPORT_DB_DATABASE"`
-	SSLMode  string `json:"ssl_mode" env:"IMPORT_DB_SSL_MODE"`
-}
+	if c.Local.MinFreeMB < 0 {
+		return fmt.Errorf("local min_free_mb must not be negative")
+	}
 
-// LoggingConfig holds logging configuration
-type LoggingConfig struct {
-	Level  string `json:"level" env:"LOG_LEVEL"`
-	Format string `json:"format" env:"LOG_FORMAT"`
-}
+	switch c.Local.LowSpace {
+	case "", LowSpaceRefuse, LowSpacePrune:
+	default:
+		return fmt.Errorf("invalid local low_space action %q: must be refuse or prune", c.Local.LowSpace)
+	}
 
-// GetConnectionString returns the PostgreSQL connection string
-func (d *DatabaseConfig) GetConnectionString() string {
-	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		d.Host, d.Port, d.Username, d.Password, d.Database, d.SSLMode)
-}
+	if c.Local.IORetries < 0 {
+		return fmt.Errorf("local io_retries must not be negative")
+	}
 
-// GetConnectionString returns the PostgreSQL connection string for import database
-func (d *ImportDatabaseConfig) GetConnectionString() string {
-	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		d.Host, d.Port, d.Username, d.Password, d.Database, d.SSLMode)
-}
+	if _, _, err := c.Local.Permissions(); err != nil {
+		return err
+	}
 
-// LoadConfig loads configuration from appsettings.json
-func LoadConfig(configPath string) (*Config, error) {
-	file, err := os.Open(configPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open config file: %w", err)
+	if _, _, err := c.Local.Owner(); err != nil {
+		return err
 	}
-	defer file.Close()
 
-	var config Config
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&config); err != nil {
-		return nil, fmt.Errorf("failed to decode config: %w", err)
+	if c.AWS.AbortIncompleteUploadsHours < 0 {
+		return fmt.Errorf("aws abort_incomplete_uploads_hours must not be negative")
 	}
 
-	// Apply environment variable overrides
-	if err := applyEnvOverrides(&config); err != nil {
-		return nil, fmt.Errorf("failed to apply environment overrides: %w", err)
+	if c.AWS.ListRequestsPerSecond < 0 {
+		return fmt.Errorf("aws list_requests_per_second must not be negative")
 	}
 
-	// Validate configuration
-	if err := config.Validate(); err != nil {
-		return nil, fmt.Errorf("configuration validation failed: %w", err)
+	if c.AWS.ShareTTLHours < 0 || c.AWS.ShareTTL() > MaxShareTTL {
+		return fmt.Errorf("aws share_ttl_hours must be between 1 and %d", int(MaxShareTTL.Hours()))
 	}
 
-	return &config, nil
-}
+	if _, err := c.AWS.CustomerKey(); err != nil {
+		return err
+	}
 
-// LoadConfigForImport loads configuration from a JSON file for import operations
-func LoadConfigForImport(configPath string) (*Config, error) {
-	file, err := os.Open(configPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open config file: %w", err)
+	if _, err := c.AWS.PreviousCustomerKeys(); err != nil {
+		return err
 	}
-	defer file.Close()
 
-	var config Config
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&config); err != nil {
-		return nil, fmt.Errorf("failed to decode config: %w", err)
+	switch c.AWS.DefaultEncryption {
+	case "", EncryptionAES256, EncryptionKMS:
+	default:
+		return fmt.Errorf("invalid aws default_encryption %q: must be %s or %s", c.AWS.DefaultEncryption, EncryptionAES256, EncryptionKMS)
 	}
 
-	// Apply environment variable overrides
-	if err := applyEnvOverrides(&config); err != nil {
-		return nil, fmt.Errorf("failed to apply environment overrides: %w", err)
+	if c.AWS.KMSKeyID != "" && c.AWS.DefaultEncryption != EncryptionKMS {
+		return fmt.Errorf("aws kms_key_id requires default_encryption %s", EncryptionKMS)
 	}
 
-	// Validate configuration for import (allows empty databases)
-	if err := config.ValidateForImport(); err != nil {
-		return nil, fmt.Errorf("import configuration validation failed: %w", err)
+	if err := c.validateLifecycle(); err != nil {
+		return err
 	}
 
-	return &config, nil
-}
+	if c.Backup.MaxRunMinutes < 0 {
+		return fmt.Errorf("backup max_run_minutes must not be negative")
+	}
 
-// applyEnvOverrides applies environment variable overrides to the configuration
-func applyEnvOverrides(config *Config) error {
-	// Handle database arrays - check for both DB_* and DB_INDEX_* environment variables
-	// This allows overriding specific database configurations
-	for i := range config.Databases {
-		// First try DB_* variables (for the first database)
-		if i == 0 {
-			if err := parseDatabaseEnv(&config.Databases[i], "DB_"); err != nil {
-				return fmt.Errorf("failed to parse database %d environment variables: %w", i, err)
-			}
-		}
+	if c.Backup.TransferJobs < 0 {
+		return fmt.Errorf("backup transfer_jobs must not be negative")
+	}
 
-		// Then try DB_INDEX_* variables (for all databases)
-		dbPrefix := fmt.Sprintf("DB_%d_", i)
-		if err := parseDatabaseEnv(&config.Databases[i], dbPrefix); err != nil {
-			return fmt.Errorf("failed to parse database %d environment variables: %w", i, err)
-		}
+	if c.Backup.MaxConcurrentJobs < 0 {
+		return fmt.Errorf("backup max_concurrent_jobs must not be negative")
 	}
 
-	// Parse environment variables for the main config (excluding databases)
-	// We need to parse each section separately to avoid conflicts
-	if err := parseConfigSections(config); err != nil {
-		return fmt.Errorf("failed to parse environment variables: %w", err)
+	if c.Backup.SplitSizeGB < 0 {
+		return fmt.Errorf("backup split_size_gb must not be negative")
 	}
 
-	return nil
-}
+	// Keys separate directories with slashes on every platform, including Windows
+	if strings.Contains(c.Backup.BackupPrefix, `\`) {
+		return fmt.Errorf("backup backup_prefix must separate directories with forward slashes")
+	}
 
-// parseConfigSections parses environment variables for different config sections
-func parseConfigSections(config *Config) error {
-	// Parse AWS config
-	if err := env.Parse(&config.AWS); err != nil {
-		return fmt.Errorf("failed to parse AWS environment variables: %w", err)
+	if err := c.validateGroups(); err != nil {
+		return err
 	}
 
-	// Parse Local config
-	if err := env.Parse(&config.Local); err != nil {
-		return fmt.Errorf("failed to parse Local environment variables: %w", err)
+	if err := c.Subset.validate(); err != nil {
+		return err
 	}
 
-	// Parse Backup config
-	if err := env.Parse(&config.Backup); err != nil {
-		return fmt.Errorf("failed to parse Backup environment variables: %w", err)
+	// Rehearsals and fixtures restore with the import transforms too
+	if err := validateTransforms(c.Import.Transforms); err != nil {
+		return err
 	}
 
-	// Parse Import config
-	if err := env.Parse(&config.Import); err != nil {
-		return fmt.Errorf("failed to parse Import environment variables: %w", err)
+	if err := c.validateNotifications(); err != nil {
+		return err
 	}
 
-	// Parse Logging config
-	if err := env.Parse(&config.Logging); err != nil {
-		return fmt.Errorf("failed to parse Logging environment variables: %w", err)
+	if c.Metrics.CloudWatch.Namespace != "" && c.Metrics.CloudWatch.Region == "" && c.AWS.Region == "" {
+		return fmt.Errorf("metrics cloudwatch requires a region")
 	}
 
-	return nil
-}
+	if c.Metrics.StatsD.Address != "" {
+		if _, _, err := net.SplitHostPort(c.Metrics.StatsD.Address); err != nil {
+			return fmt.Errorf("invalid metrics statsd address %q: %w", c.Metrics.StatsD.Address, err)
+		}
+	}
 
-// parseDatabaseEnv parses environment variables for a specific database
-func parseDatabaseEnv(db *DatabaseConfig, prefix string) error {
-	// Create a temporary struct with prefixed env tags
-	type TempDB struct {
-		Host     string `env:"HOST"`
-		Port     int    `env:"PORT"`
-		Username string `env:"USERNAME"`
-		Password string `env:"PASSWORD"`
-		Database string `env:"DATABASE"`
-		SSLMode  string `env:"SSL_MODE"`
+	if c.Status.S3Key != "" && !hasAWS {
+		return fmt.Errorf("status s3_key requires AWS S3 storage")
 	}
 
-	tempDB := TempDB{
-		Host:     db.Host,
-		Port:     db.Port,
-		Username: db.Username,
-		Password: db.Password,
-		Database: db.Database,
-		SSLMode:  db.SSLMode,
+	if c.Audit.S3Prefix != "" && !hasAWS {
+		return fmt.Errorf("audit s3_prefix requires AWS S3 storage")
 	}
 
-	// Parse with custom prefix
-	opts := env.Options{
-		Prefix: prefix,
+	if err := c.validateAPI(); err != nil {
+		return err
 	}
-	if err := env.ParseWithOptions(&tempDB, opts); err != nil {
+
+	if err := c.validatePricing(); err != nil {
 		return err
 	}
 
-	// Update the original database config if environment variables were set
-	if os.Getenv(prefix+"HOST") != "" {
-		db.Host = tempDB.Host
+	return nil
+}
+
+// validTokenDigest matches a hex SHA-256 digest
+var validTokenDigest = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// validateAPI checks the users of the job API and that restore approval
+// leaves someone to approve
+func (c *Config) validateAPI() error {
+	names := make(map[string]bool)
+	approvers := 0
+	for i, user := range c.API.Users {
+		if user.Name == "" {
+			return fmt.Errorf("api user name is required for user %d", i)
+		}
+		if names[user.Name] {
+			return fmt.Errorf("api user %s is defined more than once", user.Name)
+		}
+		names[user.Name] = true
+		if !validTokenDigest.MatchString(user.TokenSHA256) {
+			return fmt.Errorf("api user %s needs token_sha256, the lowercase hex SHA-256 digest of its token", user.Name)
+		}
+		if user.Approver {
+			approvers++
+		}
 	}
-	if os.Getenv(prefix+"PORT") != "" {
-		db.Port = tempDB.Port
+	if c.API.RestoreApproval && (len(c.API.Users) < 2 || approvers == 0) {
+		return fmt.Errorf("api restore_approval requires at least two users, one of them an approver")
 	}
-	if os.Getenv(prefix+"USERNAME") != "" {
-		db.Username = tempDB.Username
+	return nil
+}
+
+// validatePricing checks that prices are set for known storage classes and not negative
+func (c *Config) validatePricing() error {
+	for class, price := range c.Pricing.S3 {
+		if !slices.Contains(S3StorageClasses, class) {
+			return fmt.Errorf("pricing s3 has unknown storage class %s, expected one of %s", class, strings.Join(S3StorageClasses, ", "))
+		}
+		if price < 0 {
+			return fmt.Errorf("pricing s3 %s cannot be negative", class)
+		}
 	}
-	if os.Getenv(prefix+"PASSWORD") != "" {
-		db.Password = tempDB.Password
+	return nil
+}
+
+// validateLifecycle checks that lifecycle transitions are ordered and that
+// expiration leaves retention cleanup to delete backups first
+func (c *Config) validateLifecycle() error {
+	lifecycle := c.AWS.Lifecycle
+	if !lifecycle.Enabled() {
+		return nil
 	}
-	if os.Getenv(prefix+"DATABASE") != "" {
-		db.Database = tempDB.Database
+	if !c.IsAWSStorage() {
+		return fmt.Errorf("aws lifecycle requires AWS S3 storage")
 	}
-	if os.Getenv(prefix+"SSL_MODE") != "" {
-		db.SSLMode = tempDB.SSLMode
+	previous := 0
+	for _, transition := range lifecycle.Transitions {
+		if transition.StorageClass == "STANDARD" || !slices.Contains(S3StorageClasses, transition.StorageClass) {
+			return fmt.Errorf("aws lifecycle transition has invalid storage class %q", transition.StorageClass)
+		}
+		if transition.Days <= previous {
+			return fmt.Errorf("aws lifecycle transition to %s must come after %d days and after the previous transition", transition.StorageClass, previous)
+		}
+		previous = transition.Days
+	}
+	if lifecycle.ExpirationDays < 0 || lifecycle.NoncurrentDays < 0 {
+		return fmt.Errorf("aws lifecycle expiration_days and noncurrent_days must not be negative")
+	}
+	if lifecycle.ExpirationDays > 0 {
+		if lifecycle.ExpirationDays <= c.Backup.RetentionDays {
+			return fmt.Errorf("aws lifecycle expiration_days must be greater than backup retention_days (%d), so cleanup deletes backups first", c.Backup.RetentionDays)
+		}
+		if lifecycle.ExpirationDays <= previous {
+			return fmt.Errorf("aws lifecycle expiration_days must be greater than the days of every transition")
+		}
 	}
+	return nil
+}
 
+// validateGroups checks that every group member is configured and belongs to one group only
+func (c *Config) validateGroups() error {
+	groupOf := make(map[string]string)
+	names := make(map[string]bool)
+	for i, group := range c.Groups {
+		if group.Name == "" {
+			return fmt.Errorf("group name is required for group %d", i)
+		}
+		if names[group.Name] {
+			return fmt.Errorf("group %s is defined more than once", group.Name)
+		}
+		names[group.Name] = true
+
+		if len(group.Databases) == 0 {
+			return fmt.Errorf("group %s has no databases", group.Name)
+		}
+		for _, name := range group.Databases {
+			db := c.FindDatabase(name)
+			if db == nil {
+				return fmt.Errorf("group %s references unknown database %s", group.Name, name)
+			}
+			if other, ok := groupOf[name]; ok {
+				return fmt.Errorf("database %s is in both group %s and group %s", name, other, group.Name)
+			}
+			groupOf[name] = group.Name
+
+			if group.SharedSnapshot && db.EngineType() != EngineTypePostgres {
+				return fmt.Errorf("group %s uses shared_snapshot but database %s is not a PostgreSQL database", group.Name, name)
+			}
+		}
+	}
 	return nil
 }
 
-// Validate checks if the configuration is valid
-func (c *Config) Validate() error {
-	return c.ValidateForBackup()
+// validateNotifications checks the type, URL and trigger of every notification channel
+func (c *Config) validateNotifications() error {
+	if c.Notifications.RepeatIntervalMinutes < 0 {
+		return fmt.Errorf("notifications repeat_interval_minutes must not be negative")
+	}
+	if c.Notifications.EscalateAfter < 0 {
+		return fmt.Errorf("notifications escalate_after must not be negative")
+	}
+	for i, channel := range c.Notifications.Channels {
+		switch channel.Type {
+		case ChannelWebhook, ChannelSlack, ChannelDiscord, ChannelTeams:
+			if channel.URL == "" {
+				return fmt.Errorf("url is required for notification channel %d", i)
+			}
+		case ChannelPlugin:
+			if channel.Plugin == "" && channel.Binary == "" {
+				return fmt.Errorf("plugin or binary is required for notification channel %d", i)
+			}
+			if channel.Plugin != "" && !validPluginName.MatchString(channel.Plugin) {
+				return fmt.Errorf("invalid plugin name %q for notification channel %d: use letters, digits, dashes and underscores", channel.Plugin, i)
+			}
+		case "":
+			return fmt.Errorf("type is required for notification channel %d", i)
+		default:
+			return fmt.Errorf("unsupported type %q for notification channel %d", channel.Type, i)
+		}
+		switch channel.Trigger() {
+		case NotifyOnFailure, NotifyAlways:
+		case NotifyOnEscalation:
+			if c.Notifications.EscalateAfter == 0 {
+				return fmt.Errorf("notification channel %d is notified on escalation but escalate_after is not set", i)
+			}
+		default:
+			return fmt.Errorf("unsupported on %q for notification channel %d, expected %s, %s or %s", channel.On, i, NotifyOnFailure, NotifyAlways, NotifyOnEscalation)
+		}
+	}
+	return nil
 }
 
-// ValidateForBackup checks if the configuration is valid for backup operations
-func (c *Config) ValidateForBackup() error {
-	// Check if databases are configured
-	if len(c.Databases) == 0 {
-		return fmt.Errorf("at least one database must be configured")
+// FindDatabase returns the configured database with the given name
+func (c *Config) FindDatabase(name string) *DatabaseConfig {
+	for i := range c.Databases {
+		if c.Databases[i].Database == name {
+			return &c.Databases[i]
+		}
 	}
+	return nil
+}
 
-	// Validate each database configuration
-	for i, db := range c.Databases {
-		if db.Database == "" {
-			return fmt.Errorf("database name is required for database %d", i)
+// StorageFor returns where the backups of the named database are stored: its
+// storage overrides on top of the configured bucket, local path and prefix
+func (c *Config) StorageFor(name string) StorageConfig {
+	target := StorageConfig{
+		Bucket: c.AWS.Bucket,
+		Path:   c.Local.Path,
+		Prefix: c.Backup.BackupPrefix,
+	}
+	if db := c.FindDatabase(name); db != nil {
+		if db.Storage.Bucket != "" {
+			target.Bucket = db.Storage.Bucket
 		}
-		if db.Host == "" {
-			return fmt.Errorf("database host is required for database %d", i)
+		if db.Storage.Path != "" {
+			target.Path = db.Storage.Path
 		}
-		if db.Username == "" {
-			return fmt.Errorf("database username is required for database %d", i)
+		if db.Storage.Prefix != "" {
+			target.Prefix = db.Storage.Prefix
+		}
+	}
+	return target
+}
+
+// GroupDatabases returns the members of the named group
+func (c *Config) GroupDatabases(name string) ([]string, error) {
+	for _, group := range c.Groups {
+		if group.Name == name {
+			return group.Databases, nil
 		}
-		if db.Password == "" {
-			return fmt.Errorf("database password is required for database %d", i)
+	}
+	return nil, fmt.Errorf("group %s is not configured", name)
+}
+
+// GroupOf returns the group a database belongs to, or nil
+func (c *Config) GroupOf(name string) *GroupConfig {
+	for i, group := range c.Groups {
+		for _, member := range group.Databases {
+			if member == name {
+				return &c.Groups[i]
+			}
 		}
 	}
+	return nil
+}
 
-	// Check if either local path or AWS S3 is configured
-	hasLocal := c.Local.Path != ""
-	hasAWS := c.AWS.Bucket != "" && c.AWS.Region != "" && c.AWS.AccessKeyID != "" && c.AWS.SecretAccessKey != ""
+// DatabasesByPriority returns the databases in the order they are backed up:
+// highest priority first, in configuration order among equal priorities
+func (c *Config) DatabasesByPriority() []DatabaseConfig {
+	databases := slices.Clone(c.Databases)
+	slices.SortStableFunc(databases, func(a, b DatabaseConfig) int {
+		return cmp.Compare(b.Priority, a.Priority)
+	})
+	return databases
+}
 
-	if !hasLocal && !hasAWS {
-		return fmt.Errorf("either local storage path or AWS S3 configuration is required")
+// DisabledDatabases returns the names of the databases disabled with enabled: false
+func (c *Config) DisabledDatabases() []string {
+	var names []string
+	for _, db := range c.Databases {
+		if !db.IsEnabled() {
+			names = append(names, db.Database)
+		}
 	}
+	return names
+}
 
-	if hasLocal && hasAWS {
-		return fmt.Errorf("both local storage and AWS S3 are configured, please choose one")
+// FilterDatabases restricts the configured databases to the given names.
+// An empty list leaves the configuration untouched. Every requested name
+// must match a configured database.
+func (c *Config) FilterDatabases(names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	var filtered []DatabaseConfig
+	for _, name := range names {
+		found := false
+		for _, db := range c.Databases {
+			if db.Database == name {
+				filtered = append(filtered, db)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("database %s is not configured", name)
+		}
 	}
 
+	c.Databases = filtered
 	return nil
 }
 
+// IsPluginStorage returns true if a storage plugin is configured
+func (c *Config) IsPluginStorage() bool {
+	return c.StoragePlugin.Name != "" || c.StoragePlugin.Binary != ""
+}
+
+// IsRcloneStorage returns true if an rclone remote is configured
+func (c *Config) IsRcloneStorage() bool {
+	return c.Rclone.Remote != ""
+}
+
 // IsLocalStorage returns true if local storage is configured
 func (c *Config) IsLocalStorage() bool {
 	return c.Local.Path != ""
@@ -301,7 +2153,7 @@ func (c *Config) IsImportConfigured() bool {
 		c.Import.TargetDatabase.Host != "" &&
 		c.Import.TargetDatabase.Database != "" &&
 		c.Import.TargetDatabase.Username != "" &&
-		c.Import.TargetDatabase.Password != ""
+		(c.Import.TargetDatabase.Password != "" || c.Import.TargetDatabase.IAMAuth)
 }
 
 // ValidateImportConfig validates the import configuration
@@ -319,13 +2171,53 @@ func (c *Config) ValidateImportConfig() error {
 	if c.Import.TargetDatabase.Username == "" {
 		return fmt.Errorf("import target database username is required")
 	}
-	if c.Import.TargetDatabase.Password == "" {
-		return fmt.Errorf("import target database password is required")
+	if err := validateIAMAuth(c.Import.TargetDatabase.IAMAuth, c.Import.TargetDatabase.Password, c.Import.TargetDatabase.SSLMode); err != nil {
+		return fmt.Errorf("import target %w", err)
 	}
 	if c.Import.BackupPath == "" {
 		return fmt.Errorf("import backup path is required")
 	}
+	if c.Import.MaxErrors != nil && *c.Import.MaxErrors < 0 {
+		return fmt.Errorf("import max_errors must not be negative")
+	}
+	if c.Import.IfExists && !c.Import.Clean {
+		return fmt.Errorf("import if_exists requires clean")
+	}
+	if err := validateTransforms(c.Import.Transforms); err != nil {
+		return err
+	}
+	switch c.Import.Compatibility {
+	case "", ImportCompatibilityFix, ImportCompatibilityWarn:
+	default:
+		return fmt.Errorf("unsupported import compatibility %q, expected fix or warn", c.Import.Compatibility)
+	}
+	if c.Import.NoOwner && len(c.Import.RoleMap) > 0 {
+		return fmt.Errorf("import no_owner and role_map cannot be combined")
+	}
+	for from, to := range c.Import.RoleMap {
+		if from == "" || to == "" {
+			return fmt.Errorf("import role_map entries need both a source and a target role")
+		}
+	}
+
+	return nil
+}
 
+// validateIAMAuth checks that a PostgreSQL connection has exactly one way to
+// authenticate. IAM tokens are only accepted over SSL.
+func validateIAMAuth(iamAuth bool, password, sslMode string) error {
+	if !iamAuth {
+		if password == "" {
+			return fmt.Errorf("database password is required")
+		}
+		return nil
+	}
+	if password != "" {
+		return fmt.Errorf("database password must not be set with iam_auth")
+	}
+	if sslMode == "" || sslMode == "disable" {
+		return fmt.Errorf("iam_auth requires an ssl_mode other than disable")
+	}
 	return nil
 }
 
@@ -336,7 +2228,22 @@ func (c *Config) ValidateForImport() error {
 	return c.ValidateImportConfig()
 }
 
+// Secrets returns every credential in the configuration so it can be redacted from output
+func (c *Config) Secrets() []string {
+	var secrets []string
+	for _, db := range c.Databases {
+		secrets = append(secrets, db.Password, db.Redis.Password)
+	}
+	secrets = append(secrets, c.Import.TargetDatabase.Password, c.AWS.SecretAccessKey, c.AWS.SessionToken, c.AWS.SSECustomerKey)
+	secrets = append(secrets, c.AWS.SSEPreviousKeys...)
+	for _, channel := range c.Notifications.Channels {
+		// Webhook URLs carry their own access token
+		secrets = append(secrets, channel.URL)
+	}
+	return secrets
+}
+
 // IsAWSStorage returns true if AWS S3 is configured
 func (c *Config) IsAWSStorage() bool {
-	return c.AWS.Bucket != "" && c.AWS.Region != "" && c.AWS.AccessKeyID != "" && c.AWS.SecretAccessKey != ""
+	return c.AWS.Bucket != "" && c.AWS.Region != "" && c.AWS.HasCredentials()
 }
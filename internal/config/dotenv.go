@@ -0,0 +1,105 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LoadDotEnv sets the variables defined in a .env file. Variables already set
+// in the environment keep their value, so exported values win over the file.
+func LoadDotEnv(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open env file: %w", err)
+	}
+	defer file.Close()
+
+	values, err := parseDotEnv(bufio.NewScanner(file))
+	if err != nil {
+		return fmt.Errorf("failed to parse env file %s: %w", path, err)
+	}
+
+	for _, kv := range values {
+		if _, exists := os.LookupEnv(kv[0]); exists {
+			continue
+		}
+		if err := os.Setenv(kv[0], kv[1]); err != nil {
+			return fmt.Errorf("failed to set %s: %w", kv[0], err)
+		}
+	}
+	return nil
+}
+
+// parseDotEnv parses KEY=VALUE lines in file order. Blank lines, # comments and
+// an "export " prefix are ignored; values may be single or double quoted.
+func parseDotEnv(scanner *bufio.Scanner) ([][2]string, error) {
+	var values [][2]string
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNumber)
+		}
+
+		value, err := parseDotEnvValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		values = append(values, [2]string{key, value})
+	}
+	return values, scanner.Err()
+}
+
+// parseDotEnvValue unquotes a value. Double quoted values support \n, \" and \\
+// escapes, single quoted values are literal, and unquoted values end at " #".
+func parseDotEnvValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	switch quote := value[0]; quote {
+	case '\'':
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated single quoted value")
+		}
+		return value[1 : end+1], nil
+	case '"':
+		var out strings.Builder
+		for i := 1; i < len(value); i++ {
+			c := value[i]
+			switch {
+			case c == '"':
+				return out.String(), nil
+			case c == '\\' && i+1 < len(value):
+				i++
+				switch value[i] {
+				case 'n':
+					out.WriteByte('\n')
+				case 't':
+					out.WriteByte('\t')
+				default:
+					out.WriteByte(value[i])
+				}
+			default:
+				out.WriteByte(c)
+			}
+		}
+		return "", fmt.Errorf("unterminated double quoted value")
+	}
+
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value, nil
+}
@@ -0,0 +1,305 @@
+package config
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// DefaultConfigPath is read when no configuration file is given and it exists
+const DefaultConfigPath = "appsettings.json"
+
+// defaultPostgresPort is used for PostgreSQL databases defined only through the environment
+const defaultPostgresPort = 5432
+
+// EnvDefaults returns the settings used for values an environment-only
+// configuration does not set
+func EnvDefaults() Config {
+	return Config{
+		Backup: BackupConfig{
+			RetentionDays: 2,
+			Schedule:      "0 */3 * * *",
+			BackupPrefix:  "postgres-backup",
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: "text",
+		},
+	}
+}
+
+// resolveConfigPath returns the configuration file to read, or "" to run from
+// environment variables only when no path is given and the default file is absent
+func resolveConfigPath(configPath string) string {
+	if configPath != "" {
+		return configPath
+	}
+	if _, err := os.Stat(DefaultConfigPath); err == nil {
+		return DefaultConfigPath
+	}
+	return ""
+}
+
+// LoadEnvConfig builds a backup configuration from defaults and environment
+// variables only, discovering databases from the indexed DB_N_* variables
+func LoadEnvConfig(defaults Config) (*Config, error) {
+	config := defaults
+	if err := applyEnvOverrides(&config); err != nil {
+		return nil, fmt.Errorf("failed to apply environment overrides: %w", err)
+	}
+
+	if missing := config.missingEnvSettings(); len(missing) > 0 {
+		return nil, fmt.Errorf("no configuration file and missing required environment variables: %s", strings.Join(missing, ", "))
+	}
+
+	if err := config.ValidateForBackup(); err != nil {
+		return nil, fmt.Errorf("configuration validation failed: %w", err)
+	}
+
+	return &config, nil
+}
+
+// discoverDatabases reads the databases defined by DB_N_* variables from index
+// start onwards, stopping at the first index without any. As with configuration
+// files, unindexed DB_* variables apply to the first database.
+func discoverDatabases(start int) ([]DatabaseConfig, error) {
+	var databases []DatabaseConfig
+	for i := start; ; i++ {
+		prefix := fmt.Sprintf("DB_%d_", i)
+		unindexed := i == 0 && databaseEnvSet("DB_")
+		if !unindexed && !databaseEnvSet(prefix) {
+			return databases, nil
+		}
+
+		var db DatabaseConfig
+		if unindexed {
+			if err := parseDatabaseEnv(&db, "DB_"); err != nil {
+				return nil, fmt.Errorf("failed to parse database %d environment variables: %w", i, err)
+			}
+		}
+		if err := parseDatabaseEnv(&db, prefix); err != nil {
+			return nil, fmt.Errorf("failed to parse database %d environment variables: %w", i, err)
+		}
+
+		if db.EngineType() == EngineTypePostgres && db.Port == 0 {
+			db.Port = defaultPostgresPort
+		}
+		databases = append(databases, db)
+	}
+}
+
+// databaseEnvSet returns true if any variable identifying a database is set under prefix
+func databaseEnvSet(prefix string) bool {
+	for _, key := range []string{"TYPE", "HOST", "PATH", "DATABASE"} {
+		if os.Getenv(prefix+key) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// missingEnvSettings lists the environment variables a configuration built from
+// the environment still needs before it can be used for backups
+func (c *Config) missingEnvSettings() []string {
+	var missing []string
+	if len(c.Databases) == 0 {
+		missing = append(missing, "DB_0_HOST (or DB_0_TYPE with its settings)")
+	}
+
+	for i, db := range c.Databases {
+		prefix := fmt.Sprintf("DB_%d_", i)
+		require := func(value, key string) {
+			if value == "" {
+				missing = append(missing, prefix+key)
+			}
+		}
+
+		require(db.Database, "DATABASE")
+		switch db.EngineType() {
+		case EngineTypePostgres, EngineTypeMSSQL:
+			require(db.Host, "HOST")
+			require(db.Username, "USERNAME")
+			if !db.IAMAuth {
+				require(db.Password, "PASSWORD")
+			}
+			if db.EngineType() == EngineTypeMSSQL && db.MSSQL.BackupMethod() == MSSQLMethodBackup {
+				require(db.MSSQL.ServerBackupDir, "MSSQL_SERVER_BACKUP_DIR")
+			}
+		case EngineTypeSQLite, EngineTypeFilesystem:
+			require(db.Path, "PATH")
+		case EngineTypeRedis:
+			require(db.Redis.Host, "REDIS_HOST")
+		case EngineTypeCommand:
+			require(db.Command.Dump, "COMMAND_DUMP")
+		}
+	}
+
+	switch {
+	case c.Local.Path != "", c.Rclone.Remote != "", c.IsPluginStorage():
+	case c.AWS.Bucket == "":
+		missing = append(missing, "LOCAL_BACKUP_PATH (or AWS_BUCKET with AWS_REGION and credentials, RCLONE_REMOTE or STORAGE_PLUGIN)")
+	default:
+		if c.AWS.Region == "" {
+			missing = append(missing, "AWS_REGION")
+		}
+		if !c.AWS.HasCredentials() {
+			missing = append(missing, "AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY (or AWS_ROLE_ARN)")
+		}
+	}
+	return missing
+}
+
+// secretFile is a credential that may be read from the file named by its
+// variable with a _FILE suffix
+type secretFile struct {
+	name  string
+	value *string
+	list  *[]string
+}
+
+// applySecretFiles reads the credentials whose variables carry a _FILE
+// suffix from the files they name. A trailing line ending is ignored.
+func applySecretFiles(config *Config) error {
+	secrets := []secretFile{
+		{name: "AWS_ACCESS_KEY_ID", value: &config.AWS.AccessKeyID},
+		{name: "AWS_SECRET_ACCESS_KEY", value: &config.AWS.SecretAccessKey},
+		{name: "AWS_SESSION_TOKEN", value: &config.AWS.SessionToken},
+		{name: "AWS_SSE_CUSTOMER_KEY", value: &config.AWS.SSECustomerKey},
+		{name: "AWS_SSE_PREVIOUS_KEYS", list: &config.AWS.SSEPreviousKeys},
+		{name: "IMPORT_DB_PASSWORD", value: &config.Import.TargetDatabase.Password},
+	}
+	for i := range config.Databases {
+		db := &config.Databases[i]
+		prefixes := []string{fmt.Sprintf("DB_%d_", i)}
+		if i == 0 {
+			prefixes = append([]string{"DB_"}, prefixes...)
+		}
+		for _, prefix := range prefixes {
+			secrets = append(secrets,
+				secretFile{name: prefix + "PASSWORD", value: &db.Password},
+				secretFile{name: prefix + "REDIS_PASSWORD", value: &db.Redis.Password})
+		}
+	}
+
+	for _, secret := range secrets {
+		path := os.Getenv(secret.name + "_FILE")
+		if path == "" {
+			continue
+		}
+		if os.Getenv(secret.name) != "" {
+			return fmt.Errorf("%s and %s_FILE are both set; set only one of them", secret.name, secret.name)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s_FILE: %w", secret.name, err)
+		}
+		value := strings.TrimRight(string(data), "\r\n")
+		if secret.list != nil {
+			*secret.list = strings.Split(value, ",")
+		} else {
+			*secret.value = value
+		}
+	}
+	return nil
+}
+
+// EnvVar is an environment variable carrying a configuration value
+type EnvVar struct {
+	Name  string
+	Value string
+	// Secret is set for credentials, which deployments should not store in plain text
+	Secret bool
+}
+
+// BackupEnvironment returns the environment variables with which
+// LoadEnvConfig rebuilds the backup settings of c: the databases, storage,
+// backup, logging, status, audit, metrics and compliance sections. Settings
+// only a configuration file can hold are returned by their JSON path.
+func (c *Config) BackupEnvironment() (vars []EnvVar, unsupported []string) {
+	secrets := make(map[string]bool)
+	for _, secret := range c.Secrets() {
+		if secret != "" {
+			secrets[secret] = true
+		}
+	}
+	w := &envWriter{secrets: secrets}
+	for i := range c.Databases {
+		w.rename = func(name string) string { return fmt.Sprintf("DB_%d_%s", i, strings.TrimPrefix(name, "DB_")) }
+		w.walk(reflect.ValueOf(c.Databases[i]), fmt.Sprintf("databases[%d]", i))
+	}
+	w.rename = nil
+	sections := []struct {
+		path  string
+		value any
+	}{
+		{"aws", c.AWS},
+		{"backup", c.Backup},
+		{"logging", c.Logging},
+		{"status", c.Status},
+		{"audit", c.Audit},
+		{"metrics", c.Metrics},
+		{"compliance", c.Compliance},
+	}
+	for _, section := range sections {
+		w.walk(reflect.ValueOf(section.value), section.path)
+	}
+	return w.vars, w.unsupported
+}
+
+// envWriter collects the variables of the env tagged fields of a section
+type envWriter struct {
+	secrets     map[string]bool
+	rename      func(string) string
+	vars        []EnvVar
+	unsupported []string
+}
+
+// walk adds the set fields of the struct v, whose JSON path is path
+func (w *envWriter) walk(v reflect.Value, path string) {
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || value.IsZero() {
+			continue
+		}
+		fieldPath := path + "." + name
+		key := field.Tag.Get("env")
+		switch {
+		case key != "":
+			if w.rename != nil {
+				key = w.rename(key)
+			}
+			w.add(key, value, field.Tag)
+		case value.Kind() == reflect.Struct:
+			w.walk(value, fieldPath)
+		default:
+			w.unsupported = append(w.unsupported, fieldPath)
+		}
+	}
+}
+
+// add formats value the way the env package parses it back
+func (w *envWriter) add(key string, value reflect.Value, tag reflect.StructTag) {
+	if value.Kind() == reflect.Pointer {
+		value = value.Elem()
+	}
+	var items []string
+	switch value.Kind() {
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			items = append(items, fmt.Sprint(value.Index(i).Interface()))
+		}
+	case reflect.Map:
+		for _, k := range value.MapKeys() {
+			items = append(items, fmt.Sprintf("%v%s%v", k.Interface(), cmp.Or(tag.Get("envKeyValSeparator"), ":"), value.MapIndex(k).Interface()))
+		}
+		slices.Sort(items)
+	default:
+		items = []string{fmt.Sprint(value.Interface())}
+	}
+	secret := slices.ContainsFunc(items, func(item string) bool { return w.secrets[item] })
+	w.vars = append(w.vars, EnvVar{Name: key, Value: strings.Join(items, cmp.Or(tag.Get("envSeparator"), ",")), Secret: secret})
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// includeKey lists the files merged underneath a configuration file
+const includeKey = "include"
+
+// readConfigDocument reads a configuration file as a JSON object with the
+// files it includes merged underneath it, in order, so the including file
+// wins. Included files may include others; paths are relative to the file
+// naming them. seen holds the files being read, to reject include cycles.
+func readConfigDocument(configPath string, opts LoadOptions, seen []string) (map[string]any, error) {
+	absPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve config file %s: %w", configPath, err)
+	}
+	if slices.Contains(seen, absPath) {
+		return nil, fmt.Errorf("config file %s includes itself through %s", seen[0], strings.Join(append(seen[1:], absPath), " -> "))
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		if len(seen) > 0 {
+			return nil, fmt.Errorf("failed to open config file %s included by %s: %w", configPath, seen[len(seen)-1], err)
+		}
+		return nil, fmt.Errorf("failed to open config file: %w", err)
+	}
+
+	if opts.Strict {
+		if err := checkStrict(configPath, data); err != nil {
+			return nil, err
+		}
+	}
+
+	var document map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("failed to decode config %s: %w", configPath, err)
+	}
+
+	if _, err := documentVersion(document); err != nil {
+		return nil, fmt.Errorf("%s: %w", configPath, err)
+	}
+	delete(document, versionKey)
+
+	includes, err := includedFiles(document)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", configPath, err)
+	}
+	delete(document, includeKey)
+	if len(includes) == 0 {
+		return document, nil
+	}
+
+	merged := map[string]any{}
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(configPath), include)
+		}
+		included, err := readConfigDocument(include, opts, append(seen, absPath))
+		if err != nil {
+			return nil, err
+		}
+		merged = mergeObjects(merged, included)
+	}
+	return mergeObjects(merged, document), nil
+}
+
+// includedFiles returns the files listed under the include key
+func includedFiles(document map[string]any) ([]string, error) {
+	switch raw := document[includeKey].(type) {
+	case nil:
+		return nil, nil
+	case []any:
+		files := make([]string, 0, len(raw))
+		for _, entry := range raw {
+			file, ok := entry.(string)
+			if !ok || file == "" {
+				return nil, fmt.Errorf("%q must list file paths", includeKey)
+			}
+			files = append(files, file)
+		}
+		return files, nil
+	default:
+		return nil, fmt.Errorf("%q must be a list of file paths", includeKey)
+	}
+}
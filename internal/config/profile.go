@@ -0,0 +1,135 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ProfileEnvVar selects a configuration profile when none is given on the command line
+const ProfileEnvVar = "CONFIG_PROFILE"
+
+// profilesKey is the top-level configuration key holding the named profiles
+const profilesKey = "profiles"
+
+// extendsKey names the profile a profile inherits from
+const extendsKey = "extends"
+
+// decodeConfigFile reads a configuration file and applies the selected profile.
+// An empty profile falls back to the CONFIG_PROFILE environment variable.
+func decodeConfigFile(configPath string, opts LoadOptions) (*Config, error) {
+	document, err := readConfigDocument(configPath, opts, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := opts.Profile
+	if profile == "" {
+		profile = os.Getenv(ProfileEnvVar)
+	}
+	merged, err := applyProfile(document, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode profile %s: %w", profile, err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to decode config: %w", err)
+	}
+	config.Profile = profile
+	return &config, nil
+}
+
+// applyProfile overlays a profile and the profiles it extends onto the base document
+func applyProfile(document map[string]any, profile string) (map[string]any, error) {
+	profiles := map[string]any{}
+	if raw, ok := document[profilesKey]; ok {
+		if profiles, ok = raw.(map[string]any); !ok {
+			return nil, fmt.Errorf("%q must be an object of named profiles", profilesKey)
+		}
+	}
+	delete(document, profilesKey)
+
+	if profile == "" {
+		return document, nil
+	}
+
+	// Walk up the inheritance chain, then apply it from the root down
+	var chain []map[string]any
+	var names []string
+	for name := profile; name != ""; {
+		if slices.Contains(names, name) {
+			return nil, fmt.Errorf("profile %s extends itself through %s", profile, strings.Join(append(names, name), " -> "))
+		}
+		raw, ok := profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(profileNames(profiles), ", "))
+		}
+		overlay, ok := raw.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("profile %s must be an object", name)
+		}
+
+		parent := ""
+		if raw, ok := overlay[extendsKey]; ok {
+			if parent, ok = raw.(string); !ok {
+				return nil, fmt.Errorf("profile %s: %q must be a profile name", name, extendsKey)
+			}
+		}
+
+		names = append(names, name)
+		chain = append(chain, overlay)
+		name = parent
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		overlay := make(map[string]any, len(chain[i]))
+		for key, value := range chain[i] {
+			if key != extendsKey {
+				overlay[key] = value
+			}
+		}
+		document = mergeObjects(document, overlay)
+	}
+	return document, nil
+}
+
+// mergeObjects merges overlay into base. Nested objects are merged key by key;
+// arrays and scalar values replace the base value.
+func mergeObjects(base, overlay map[string]any) map[string]any {
+	merged := make(map[string]any, len(base)+len(overlay))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range overlay {
+		baseObject, baseIsObject := merged[key].(map[string]any)
+		overlayObject, overlayIsObject := value.(map[string]any)
+		if baseIsObject && overlayIsObject {
+			merged[key] = mergeObjects(baseObject, overlayObject)
+		} else {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// profileNames returns the sorted names of the defined profiles
+func profileNames(profiles map[string]any) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return []string{"none"}
+	}
+	return names
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// SchemaURL identifies the JSON Schema dialect of the generated schema
+const SchemaURL = "https://json-schema.org/draft/2020-12/schema"
+
+// profileDocument is a profile overlay as written in a configuration file
+type profileDocument struct {
+	Config
+	Extends string `json:"extends"`
+}
+
+// fileDocument is a configuration file, including its named profiles, the
+// files it includes, the schema version it was written for and the optional
+// $schema reference editors use for completion
+type fileDocument struct {
+	Config
+	SchemaRef     string                     `json:"$schema"`
+	ConfigVersion int                        `json:"config_version"`
+	Include       []string                   `json:"include"`
+	Profiles      map[string]profileDocument `json:"profiles"`
+}
+
+// Schema returns a JSON Schema describing the configuration file
+func Schema() map[string]any {
+	schema := schemaFor(reflect.TypeOf(fileDocument{}))
+	schema["$schema"] = SchemaURL
+	schema["title"] = "db-backuper configuration"
+	return schema
+}
+
+// schemaFor returns the JSON Schema of a Go type as encoded by encoding/json
+func schemaFor(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaFor(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]any{}
+		for name, field := range jsonFields(t) {
+			properties[name] = schemaFor(field.Type)
+		}
+		return map[string]any{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	}
+	return map[string]any{}
+}
+
+// jsonFields returns the fields of a struct type by their JSON name, including
+// the fields of embedded structs and skipping fields not encoded in JSON
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			for name, embedded := range jsonFields(field.Type) {
+				fields[name] = embedded
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field
+	}
+	return fields
+}
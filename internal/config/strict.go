@@ -0,0 +1,207 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// strictChecker walks a configuration file token by token and reports every
+// unknown field and mistyped value with its line and column
+type strictChecker struct {
+	name    string
+	data    []byte
+	decoder *json.Decoder
+	errs    []error
+}
+
+// checkStrict validates a configuration file against the configuration types
+func checkStrict(name string, data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	c := &strictChecker{name: name, data: data, decoder: decoder}
+
+	if err := c.value(reflect.TypeOf(fileDocument{}), ""); err != nil {
+		return fmt.Errorf("failed to decode config: %w", err)
+	}
+	return errors.Join(c.errs...)
+}
+
+// value checks the next JSON value against type t. Mismatches are recorded and
+// the value skipped; only malformed JSON stops the walk.
+func (c *strictChecker) value(t reflect.Type, path string) error {
+	offset := c.nextOffset()
+	token, err := c.decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	// null leaves any field unset
+	if token == nil {
+		return nil
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if token != json.Delim('{') {
+			return c.mismatch(offset, path, "an object", token)
+		}
+		fields := jsonFields(t)
+		for c.decoder.More() {
+			keyOffset := c.nextOffset()
+			key, err := c.decoder.Token()
+			if err != nil {
+				return err
+			}
+			name := key.(string)
+			field, ok := fields[name]
+			if !ok {
+				c.errorf(keyOffset, "unknown field %q%s", name, c.within(path))
+				if err := c.skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := c.value(field.Type, joinPath(path, name)); err != nil {
+				return err
+			}
+		}
+		_, err := c.decoder.Token()
+		return err
+	case reflect.Map:
+		if token != json.Delim('{') {
+			return c.mismatch(offset, path, "an object", token)
+		}
+		for c.decoder.More() {
+			key, err := c.decoder.Token()
+			if err != nil {
+				return err
+			}
+			if err := c.value(t.Elem(), joinPath(path, key.(string))); err != nil {
+				return err
+			}
+		}
+		_, err := c.decoder.Token()
+		return err
+	case reflect.Slice, reflect.Array:
+		if token != json.Delim('[') {
+			return c.mismatch(offset, path, "an array", token)
+		}
+		for i := 0; c.decoder.More(); i++ {
+			if err := c.value(t.Elem(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		_, err := c.decoder.Token()
+		return err
+	case reflect.String:
+		if _, ok := token.(string); !ok {
+			return c.mismatch(offset, path, "a string", token)
+		}
+	case reflect.Bool:
+		if _, ok := token.(bool); !ok {
+			return c.mismatch(offset, path, "a boolean", token)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, ok := token.(json.Number)
+		if !ok {
+			return c.mismatch(offset, path, "an integer", token)
+		}
+		if _, err := number.Int64(); err != nil {
+			c.errorf(offset, "%s must be an integer, got %s", path, number)
+		}
+	}
+	return nil
+}
+
+// mismatch records a value of the wrong type and skips the rest of it
+func (c *strictChecker) mismatch(offset int64, path, expected string, token json.Token) error {
+	if path == "" {
+		path = "configuration"
+	}
+	c.errorf(offset, "%s must be %s, got %s", path, expected, describeToken(token))
+	if delim, ok := token.(json.Delim); ok && (delim == '{' || delim == '[') {
+		return c.skipRest()
+	}
+	return nil
+}
+
+// skip skips the next value
+func (c *strictChecker) skip() error {
+	var discard json.RawMessage
+	return c.decoder.Decode(&discard)
+}
+
+// skipRest skips the remainder of an object or array whose opening token was read
+func (c *strictChecker) skipRest() error {
+	for depth := 1; depth > 0; {
+		token, err := c.decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
+
+// nextOffset returns the offset of the next token, past whitespace and separators
+func (c *strictChecker) nextOffset() int64 {
+	offset := c.decoder.InputOffset()
+	for offset < int64(len(c.data)) && strings.IndexByte(" \t\r\n,:", c.data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// errorf records an error at the line and column of offset
+func (c *strictChecker) errorf(offset int64, format string, args ...any) {
+	before := c.data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := int(offset) - bytes.LastIndexByte(before, '\n')
+	c.errs = append(c.errs, fmt.Errorf("%s:%d:%d: %s", c.name, line, column, fmt.Sprintf(format, args...)))
+}
+
+// within describes the object containing a field for error messages
+func (c *strictChecker) within(path string) string {
+	if path == "" {
+		return ""
+	}
+	return " in " + path
+}
+
+// joinPath appends a field name to a dotted path
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// describeToken names the JSON type of a token for error messages
+func describeToken(token json.Token) string {
+	switch v := token.(type) {
+	case json.Delim:
+		if v == '{' {
+			return "an object"
+		}
+		return "an array"
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case json.Number:
+		return "number " + v.String()
+	case bool:
+		return fmt.Sprintf("boolean %t", v)
+	}
+	return fmt.Sprintf("%v", token)
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// validTenantName matches the configuration file names usable as tenant names,
+// which also name the tenant's state directory and metric dimension
+var validTenantName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Tenant is one configuration of a multi-tenant scheduler, named after its file
+type Tenant struct {
+	Name   string
+	Path   string
+	Config *Config
+}
+
+// LoadTenants loads every .json file in dir as the configuration of a tenant,
+// in name order. A tenant that cannot be loaded is left out and its error
+// returned alongside the others, so one broken file does not stop the rest.
+// Tenants storing backups in the same place as an earlier tenant are
+// rejected, since their retention cleanup would delete each other's backups.
+func LoadTenants(dir string, opts LoadOptions) ([]Tenant, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenant configurations: %w", err)
+	}
+	sort.Strings(paths)
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no tenant configuration files (*.json) in %s", dir)
+	}
+
+	var tenants []Tenant
+	var errs []error
+	owners := make(map[string]string)
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		if !validTenantName.MatchString(name) {
+			errs = append(errs, fmt.Errorf("tenant %q: file names may only contain letters, digits, '.', '_' and '-'", name))
+			continue
+		}
+		cfg, err := LoadTenantConfig(path, opts)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", name, err))
+			continue
+		}
+		location := cfg.storageIdentity()
+		if owner, ok := owners[location]; ok {
+			errs = append(errs, fmt.Errorf("tenant %s: stores backups in the same location and prefix as tenant %s", name, owner))
+			continue
+		}
+		owners[location] = name
+		tenants = append(tenants, Tenant{Name: name, Path: path, Config: cfg})
+	}
+	return tenants, errors.Join(errs...)
+}
+
+// LoadTenantConfig loads the configuration file of a tenant. The settings of
+// the environment apply as for a single configuration, except the DB_*
+// variables, which would otherwise change the databases of every tenant.
+func LoadTenantConfig(path string, opts LoadOptions) (*Config, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("failed to open config file: %w", err)
+	}
+	config, err := decodeConfigFile(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := parseConfigSections(config); err != nil {
+		return nil, fmt.Errorf("failed to parse environment variables: %w", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("configuration validation failed: %w", err)
+	}
+	return config, nil
+}
+
+// storageIdentity identifies where the backups of a configuration are stored
+func (c *Config) storageIdentity() string {
+	var plugin string
+	if c.IsPluginStorage() {
+		plugin = strings.Join(append([]string{c.StoragePlugin.Command()}, c.StoragePlugin.Args...), " ")
+	}
+	return strings.Join([]string{c.AWS.Bucket, c.Local.Path, c.Rclone.Remote, plugin, c.Backup.BackupPrefix}, "\x00")
+}
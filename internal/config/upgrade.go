@@ -0,0 +1,90 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// versionKey records the schema version a configuration file was written for
+const versionKey = "config_version"
+
+// CurrentVersion is the configuration schema version of this release. Files
+// without config_version were written for version 1.
+const CurrentVersion = 1
+
+// migration rewrites a configuration document of version to the next one,
+// describing each change it made
+type migration struct {
+	version int
+	apply   func(document map[string]any) []string
+}
+
+// migrations bring documents up to CurrentVersion one version at a time.
+// Up to version 1 the schema has only gained fields, so files written for
+// any earlier release load unchanged and need none.
+var migrations []migration
+
+// documentVersion returns the schema version of a configuration document
+func documentVersion(document map[string]any) (int, error) {
+	raw, ok := document[versionKey]
+	if !ok {
+		return 1, nil
+	}
+	number, ok := raw.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("%q must be an integer", versionKey)
+	}
+	version, err := number.Int64()
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("%q must be a positive integer, got %s", versionKey, number)
+	}
+	if version > CurrentVersion {
+		return 0, fmt.Errorf("written for configuration version %d, but this release reads up to version %d; upgrade db-backuper", version, CurrentVersion)
+	}
+	return int(version), nil
+}
+
+// Upgrade rewrites a configuration file for CurrentVersion, including its
+// profiles, and describes the changes. A current file is returned as is.
+// Included files are upgraded on their own.
+func Upgrade(data []byte) ([]byte, []string, error) {
+	var document map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode config: %w", err)
+	}
+	version, err := documentVersion(document)
+	if err != nil {
+		return nil, nil, err
+	}
+	if version == CurrentVersion {
+		return data, nil, nil
+	}
+
+	var changes []string
+	for _, m := range migrations {
+		if m.version < version {
+			continue
+		}
+		changes = append(changes, m.apply(document)...)
+		if profiles, ok := document[profilesKey].(map[string]any); ok {
+			for name, overlay := range profiles {
+				if overlay, ok := overlay.(map[string]any); ok {
+					for _, change := range m.apply(overlay) {
+						changes = append(changes, fmt.Sprintf("profile %s: %s", name, change))
+					}
+				}
+			}
+		}
+	}
+	document[versionKey] = CurrentVersion
+	changes = append(changes, fmt.Sprintf("set %s to %d", versionKey, CurrentVersion))
+
+	upgraded, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	return append(upgraded, '\n'), changes, nil
+}
@@ -0,0 +1,190 @@
+package control
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"db-backuper/internal/pause"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TriggerFunc starts an out-of-schedule backup and reports whether it was started
+type TriggerFunc func(trigger string) bool
+
+// SocketServer listens on a local Unix socket for operator commands
+type SocketServer struct {
+	path     string
+	trigger  TriggerFunc
+	pauses   *pause.File
+	logger   *logrus.Logger
+	listener net.Listener
+}
+
+// NewSocketServer creates a new control socket server instance
+func NewSocketServer(path string, trigger TriggerFunc, logger *logrus.Logger) *SocketServer {
+	return &SocketServer{
+		path:    path,
+		trigger: trigger,
+		logger:  logger,
+	}
+}
+
+// SetPauses enables the pause and resume commands, which write to pauses
+func (s *SocketServer) SetPauses(pauses *pause.File) {
+	s.pauses = pauses
+}
+
+// Start begins listening on the control socket
+func (s *SocketServer) Start() error {
+	// Remove a stale socket left behind by a previous process
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale control socket %s: %w", s.path, err)
+	}
+
+	listener, err := net.Listen("unix", s.path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket %s: %w", s.path, err)
+	}
+
+	// Only the owning user may send commands
+	if err := os.Chmod(s.path, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to set control socket permissions: %w", err)
+	}
+
+	s.listener = listener
+	s.logger.Infof("Control socket listening on %s", s.path)
+
+	go s.acceptLoop()
+	return nil
+}
+
+// Stop closes the control socket
+func (s *SocketServer) Stop() error {
+	if s.listener == nil {
+		return nil
+	}
+	err := s.listener.Close()
+	s.listener = nil
+	return err
+}
+
+// acceptLoop accepts connections until the listener is closed
+func (s *SocketServer) acceptLoop() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.logger.Warnf("Failed to accept control connection: %v", err)
+			continue
+		}
+		go s.handleConnection(conn)
+	}
+}
+
+// handleConnection reads newline separated commands and writes one response line per command
+func (s *SocketServer) handleConnection(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		command := strings.TrimSpace(scanner.Text())
+		if command == "" {
+			continue
+		}
+
+		response := s.handleCommand(command)
+		if _, err := fmt.Fprintln(conn, response); err != nil {
+			s.logger.Warnf("Failed to write control response: %v", err)
+			return
+		}
+	}
+}
+
+// handleCommand executes a single control command and returns the response line
+func (s *SocketServer) handleCommand(command string) string {
+	fields := strings.Fields(command)
+	switch fields[0] {
+	case "pause", "resume", "pauses":
+		if s.pauses == nil {
+			return "ERROR pausing is not enabled"
+		}
+		return s.handlePause(fields)
+	}
+
+	switch command {
+	case "backup":
+		s.logger.Info("Received backup command on control socket")
+		if !s.trigger("control-socket") {
+			return "BUSY backup already running"
+		}
+		return "OK backup started"
+	case "ping":
+		return "OK pong"
+	default:
+		return fmt.Sprintf("ERROR unknown command: %s", command)
+	}
+}
+
+// handlePause executes "pause <duration> [database]", "resume [database]" and
+// "pauses"; without a database, pause and resume apply to every database
+func (s *SocketServer) handlePause(fields []string) string {
+	switch fields[0] {
+	case "pause":
+		if len(fields) < 2 || len(fields) > 3 {
+			return "ERROR usage: pause <duration> [database]"
+		}
+		duration, err := time.ParseDuration(fields[1])
+		if err != nil || duration <= 0 {
+			return fmt.Sprintf("ERROR invalid duration: %s", fields[1])
+		}
+		database := ""
+		if len(fields) == 3 {
+			database = fields[2]
+		}
+		p, err := s.pauses.Pause(database, time.Now().Add(duration), "control socket")
+		if err != nil {
+			return fmt.Sprintf("ERROR %v", err)
+		}
+		s.logger.Infof("Scheduled backups of %s", p)
+		return "OK " + p.String()
+	case "resume":
+		if len(fields) > 2 {
+			return "ERROR usage: resume [database]"
+		}
+		database := ""
+		if len(fields) == 2 {
+			database = fields[1]
+		}
+		resumed, err := s.pauses.Resume(database)
+		if err != nil {
+			return fmt.Sprintf("ERROR %v", err)
+		}
+		if !resumed {
+			return "OK nothing to resume"
+		}
+		s.logger.Infof("Scheduled backups resumed by control socket command %q", strings.Join(fields, " "))
+		return "OK resumed"
+	default:
+		active, err := s.pauses.Active(time.Now())
+		if err != nil {
+			return fmt.Sprintf("ERROR %v", err)
+		}
+		if len(active) == 0 {
+			return "OK no pauses"
+		}
+		descriptions := make([]string, 0, len(active))
+		for _, p := range active {
+			descriptions = append(descriptions, p.String())
+		}
+		return "OK " + strings.Join(descriptions, "; ")
+	}
+}
@@ -0,0 +1,267 @@
+// Package docker is a minimal client of the Docker Engine API, enough to run
+// disposable database containers without the docker CLI
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultHost is the Docker daemon socket used when neither the configuration
+// nor DOCKER_HOST name one
+const DefaultHost = "unix:///var/run/docker.sock"
+
+// Client talks to a Docker daemon over a Unix socket or TCP
+type Client struct {
+	http    *http.Client
+	baseURL string
+	host    string
+}
+
+// ContainerSpec describes a container to create
+type ContainerSpec struct {
+	Name   string
+	Image  string
+	Env    map[string]string
+	Labels map[string]string
+	// Port is a container port such as 5432/tcp published on a random host port
+	Port string
+}
+
+// NewClient creates a client of the daemon at host, such as
+// unix:///var/run/docker.sock or tcp://10.0.0.5:2375. An empty host falls
+// back to DOCKER_HOST, then to DefaultHost.
+func NewClient(host string) (*Client, error) {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = DefaultHost
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker host %q: %w", host, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &Client{http: &http.Client{Transport: transport}, baseURL: "http://docker", host: "127.0.0.1"}, nil
+	case "tcp", "http":
+		return &Client{http: &http.Client{}, baseURL: "http://" + u.Host, host: u.Hostname()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported docker host %q: expected a unix:// or tcp:// address", host)
+	}
+}
+
+// Host returns the address published container ports are reached on
+func (c *Client) Host() string {
+	return c.host
+}
+
+// Pull downloads image unless the daemon already has it
+func (c *Client) Pull(image string) error {
+	name, tag := splitImage(image)
+	query := url.Values{"fromImage": {name}, "tag": {tag}}
+	resp, err := c.do(http.MethodPost, "/images/create?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to pull %s: %w", image, err)
+	}
+	defer resp.Body.Close()
+
+	// The daemon streams progress messages and reports failures in-band
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var message struct {
+			Error string `json:"error"`
+		}
+		if err := decoder.Decode(&message); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read pull progress of %s: %w", image, err)
+		}
+		if message.Error != "" {
+			return fmt.Errorf("failed to pull %s: %s", image, message.Error)
+		}
+	}
+}
+
+// Create creates a container and returns its ID
+func (c *Client) Create(spec ContainerSpec) (string, error) {
+	env := make([]string, 0, len(spec.Env))
+	for name, value := range spec.Env {
+		env = append(env, name+"="+value)
+	}
+	body := map[string]any{
+		"Image":  spec.Image,
+		"Env":    env,
+		"Labels": spec.Labels,
+	}
+	if spec.Port != "" {
+		body["ExposedPorts"] = map[string]any{spec.Port: struct{}{}}
+		body["HostConfig"] = map[string]any{
+			"PortBindings": map[string]any{
+				spec.Port: []map[string]string{{"HostIp": c.bindAddress(), "HostPort": ""}},
+			},
+		}
+	}
+
+	path := "/containers/create"
+	if spec.Name != "" {
+		path += "?" + url.Values{"name": {spec.Name}}.Encode()
+	}
+	resp, err := c.do(http.MethodPost, path, body)
+	if err != nil {
+		return "", fmt.Errorf("failed to create container: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", fmt.Errorf("failed to decode created container: %w", err)
+	}
+	return created.ID, nil
+}
+
+// Start starts a created container
+func (c *Client) Start(id string) error {
+	resp, err := c.do(http.MethodPost, "/containers/"+id+"/start", nil)
+	if err != nil {
+		return fmt.Errorf("failed to start container %s: %w", ShortID(id), err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// HostPort returns the host port a container port is published on
+func (c *Client) HostPort(id, port string) (string, error) {
+	resp, err := c.do(http.MethodGet, "/containers/"+id+"/json", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect container %s: %w", ShortID(id), err)
+	}
+	defer resp.Body.Close()
+
+	var inspect struct {
+		NetworkSettings struct {
+			Ports map[string][]struct {
+				HostPort string `json:"HostPort"`
+			} `json:"Ports"`
+		} `json:"NetworkSettings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&inspect); err != nil {
+		return "", fmt.Errorf("failed to decode container %s: %w", ShortID(id), err)
+	}
+	bindings := inspect.NetworkSettings.Ports[port]
+	if len(bindings) == 0 || bindings[0].HostPort == "" {
+		return "", fmt.Errorf("container %s does not publish %s", ShortID(id), port)
+	}
+	return bindings[0].HostPort, nil
+}
+
+// Remove stops and deletes a container along with its anonymous volumes
+func (c *Client) Remove(id string) error {
+	resp, err := c.do(http.MethodDelete, "/containers/"+id+"?force=true&v=true", nil)
+	if err != nil {
+		return fmt.Errorf("failed to remove container %s: %w", ShortID(id), err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// bindAddress returns the host address ports are published on: loopback for
+// a local daemon, every interface for a remote one
+func (c *Client) bindAddress() string {
+	if c.host == "127.0.0.1" {
+		return "127.0.0.1"
+	}
+	return ""
+}
+
+// do sends a request and turns error responses into errors
+func (c *Client) do(method, path string, body any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer cancel()
+		defer resp.Body.Close()
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			return nil, fmt.Errorf("docker returned %s: %s", resp.Status, apiErr.Message)
+		}
+		return nil, fmt.Errorf("docker returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases the request context once the body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close closes the body and releases the request context
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// splitImage splits an image reference into its name and tag, defaulting to latest
+func splitImage(image string) (string, string) {
+	// A colon before the last slash belongs to a registry port
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, "latest"
+}
+
+// ShortID returns the abbreviated form of a container ID used by the docker CLI
+func ShortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,284 @@
+// Package iampolicy builds the least privilege IAM policy needed by the
+// operations a configuration performs against AWS
+package iampolicy
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"db-backuper/internal/config"
+)
+
+// Access selects the operations a policy grants
+type Access struct {
+	// ReadOnly grants only what list, download and restore need, for hosts
+	// that never write or delete backups
+	ReadOnly bool
+	// Setup adds what init-storage and apply-lifecycle need to create and
+	// configure the buckets
+	Setup bool
+}
+
+// Document is an IAM policy document
+type Document struct {
+	Version   string      `json:"Version"`
+	Statement []Statement `json:"Statement"`
+}
+
+// Statement is one statement of a policy document
+type Statement struct {
+	Sid       string                         `json:"Sid"`
+	Effect    string                         `json:"Effect"`
+	Action    []string                       `json:"Action"`
+	Resource  []string                       `json:"Resource"`
+	Condition map[string]map[string][]string `json:"Condition,omitempty"`
+}
+
+// bucket is a bucket in use with the backup prefixes stored in it
+type bucket struct {
+	name     string
+	prefixes []string
+}
+
+// Generate builds the policy granting the access of cfg's S3 storage,
+// metrics and RDS IAM authentication, scoped to the configured buckets,
+// prefixes and keys
+func Generate(cfg *config.Config, access Access) (*Document, error) {
+	if !cfg.IsAWSStorage() {
+		return nil, fmt.Errorf("IAM policies are only generated for AWS S3 storage")
+	}
+	partition := partitionOf(cfg.AWS.Region)
+	doc := &Document{Version: "2012-10-17"}
+
+	buckets := bucketsOf(cfg)
+	for i, b := range buckets {
+		actions := []string{"s3:ListBucket"}
+		if !access.ReadOnly {
+			// gc lists the incomplete uploads under each prefix
+			actions = append(actions, "s3:ListBucketMultipartUploads")
+		}
+		var patterns []string
+		for _, prefix := range b.prefixes {
+			patterns = append(patterns, prefix+"/*")
+		}
+		if b.name == cfg.AWS.Bucket && cfg.Audit.S3Prefix != "" {
+			patterns = append(patterns, strings.TrimSuffix(cfg.Audit.S3Prefix, "/")+"/*")
+		}
+		doc.Statement = append(doc.Statement, Statement{
+			Sid:       numbered("ListBackups", i),
+			Effect:    "Allow",
+			Action:    actions,
+			Resource:  []string{bucketARN(partition, b.name)},
+			Condition: map[string]map[string][]string{"StringLike": {"s3:prefix": patterns}},
+		})
+	}
+
+	var backupObjects []string
+	for _, b := range buckets {
+		for _, prefix := range b.prefixes {
+			backupObjects = append(backupObjects, objectARN(partition, b.name, prefix+"/*"))
+		}
+	}
+	if access.ReadOnly {
+		doc.Statement = append(doc.Statement, Statement{
+			Sid:      "ReadBackups",
+			Effect:   "Allow",
+			Action:   []string{"s3:GetObject"},
+			Resource: backupObjects,
+		})
+	} else {
+		doc.Statement = append(doc.Statement, Statement{
+			Sid:    "ReadWriteBackups",
+			Effect: "Allow",
+			Action: []string{
+				"s3:AbortMultipartUpload",
+				"s3:DeleteObject",
+				"s3:GetObject",
+				"s3:GetObjectTagging",
+				"s3:ListMultipartUploadParts",
+				"s3:PutObject",
+				"s3:PutObjectTagging",
+			},
+			Resource: backupObjects,
+		})
+	}
+
+	if cfg.Status.S3Key != "" {
+		actions := []string{"s3:GetObject", "s3:PutObject"}
+		if access.ReadOnly {
+			actions = actions[:1]
+		}
+		doc.Statement = append(doc.Statement, Statement{
+			Sid:      "StatusFile",
+			Effect:   "Allow",
+			Action:   actions,
+			Resource: []string{objectARN(partition, cfg.AWS.Bucket, cfg.Status.S3Key)},
+		})
+	}
+	// Read-only hosts still record their restores in the audit log
+	if cfg.Audit.S3Prefix != "" {
+		doc.Statement = append(doc.Statement, Statement{
+			Sid:      "AuditLog",
+			Effect:   "Allow",
+			Action:   []string{"s3:GetObject", "s3:PutObject"},
+			Resource: []string{objectARN(partition, cfg.AWS.Bucket, strings.TrimSuffix(cfg.Audit.S3Prefix, "/")+"/*")},
+		})
+	}
+
+	if statement := kmsStatement(cfg, partition, access); statement != nil {
+		doc.Statement = append(doc.Statement, *statement)
+	}
+
+	if cfg.Metrics.CloudWatch.Namespace != "" && !access.ReadOnly {
+		doc.Statement = append(doc.Statement, Statement{
+			Sid:       "PublishMetrics",
+			Effect:    "Allow",
+			Action:    []string{"cloudwatch:PutMetricData"},
+			Resource:  []string{"*"},
+			Condition: map[string]map[string][]string{"StringEquals": {"cloudwatch:namespace": {cfg.Metrics.CloudWatch.Namespace}}},
+		})
+	}
+
+	if resources := iamAuthUsers(cfg, partition, access); len(resources) > 0 {
+		doc.Statement = append(doc.Statement, Statement{
+			Sid:      "ConnectWithIAM",
+			Effect:   "Allow",
+			Action:   []string{"rds-db:connect"},
+			Resource: resources,
+		})
+	}
+
+	if access.Setup {
+		var resources []string
+		for _, b := range buckets {
+			resources = append(resources, bucketARN(partition, b.name))
+		}
+		doc.Statement = append(doc.Statement, Statement{
+			Sid:    "SetUpBuckets",
+			Effect: "Allow",
+			Action: []string{
+				"s3:CreateBucket",
+				"s3:GetBucketVersioning",
+				"s3:GetEncryptionConfiguration",
+				"s3:GetLifecycleConfiguration",
+				"s3:ListBucket",
+				"s3:PutBucketVersioning",
+				"s3:PutEncryptionConfiguration",
+				"s3:PutLifecycleConfiguration",
+			},
+			Resource: resources,
+		})
+	}
+	return doc, nil
+}
+
+// bucketsOf returns the buckets and prefixes of the default storage and of
+// every database, in the order they are configured
+func bucketsOf(cfg *config.Config) []bucket {
+	var buckets []bucket
+	add := func(name, prefix string) {
+		index := slices.IndexFunc(buckets, func(b bucket) bool { return b.name == name })
+		if index < 0 {
+			buckets = append(buckets, bucket{name: name})
+			index = len(buckets) - 1
+		}
+		if !slices.Contains(buckets[index].prefixes, prefix) {
+			buckets[index].prefixes = append(buckets[index].prefixes, prefix)
+		}
+	}
+	add(cfg.AWS.Bucket, cfg.Backup.BackupPrefix)
+	for _, db := range cfg.Databases {
+		resolved := cfg.StorageFor(db.Database)
+		add(resolved.Bucket, resolved.Prefix)
+	}
+	return buckets
+}
+
+// kmsStatement grants the use of the KMS key of the default bucket
+// encryption. The AWS managed key needs no grant, since its key policy
+// allows every principal of the account to use it through S3.
+func kmsStatement(cfg *config.Config, partition string, access Access) *Statement {
+	key := cfg.AWS.KMSKeyID
+	if cfg.AWS.DefaultEncryption != config.EncryptionKMS || key == "" {
+		return nil
+	}
+	actions := []string{"kms:Decrypt", "kms:GenerateDataKey"}
+	if access.ReadOnly {
+		actions = actions[:1]
+	}
+	statement := &Statement{
+		Sid:      "UseBackupKey",
+		Effect:   "Allow",
+		Action:   actions,
+		Resource: []string{key},
+	}
+	region := cmp.Or(cfg.AWS.Region, "us-east-1")
+	// IAM matches keys by their key ARN only, so an alias is matched through
+	// the aliases of the key S3 uses
+	if _, alias, ok := strings.Cut(key, "alias/"); ok {
+		statement.Resource = []string{"*"}
+		statement.Condition = map[string]map[string][]string{
+			"StringEquals":             {"kms:ViaService": {"s3." + region + ".amazonaws.com"}},
+			"ForAnyValue:StringEquals": {"kms:ResourceAliases": {"alias/" + alias}},
+		}
+	} else if !strings.HasPrefix(key, "arn:") {
+		statement.Resource = []string{fmt.Sprintf("arn:%s:kms:%s:*:key/%s", partition, region, key)}
+	}
+	return statement
+}
+
+// iamAuthUsers returns the ARNs of the database users that connect with RDS
+// IAM authentication: those of the backed up databases, unless access is
+// read-only, and the user restores connect as
+func iamAuthUsers(cfg *config.Config, partition string, access Access) []string {
+	var users []string
+	add := func(region, username string) {
+		user := fmt.Sprintf("arn:%s:rds-db:%s:*:dbuser:*/%s", partition, cmp.Or(region, cfg.AWS.Region, "*"), username)
+		if !slices.Contains(users, user) {
+			users = append(users, user)
+		}
+	}
+	if !access.ReadOnly {
+		for _, db := range cfg.Databases {
+			if db.IAMAuth {
+				add(db.IAMRegion, db.Username)
+			}
+		}
+	}
+	if target := cfg.Import.TargetDatabase; target.IAMAuth {
+		add(target.IAMRegion, target.Username)
+	}
+	return users
+}
+
+// partitionOf returns the ARN partition of an AWS region
+func partitionOf(region string) string {
+	switch {
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	default:
+		return "aws"
+	}
+}
+
+// bucketARN returns the ARN of a bucket
+func bucketARN(partition, bucket string) string {
+	return fmt.Sprintf("arn:%s:s3:::%s", partition, bucket)
+}
+
+// objectARN returns the ARN of the objects matching key in a bucket
+func objectARN(partition, bucket, key string) string {
+	return fmt.Sprintf("arn:%s:s3:::%s/%s", partition, bucket, key)
+}
+
+// numbered returns sid, followed by the number of the bucket after the first
+func numbered(sid string, index int) string {
+	if index == 0 {
+		return sid
+	}
+	return fmt.Sprintf("%s%d", sid, index+1)
+}
@@ -0,0 +1,109 @@
+// Package infra generates the infrastructure definitions deploying the
+// backup Lambda function for a configuration, as Terraform or an AWS SAM
+// template
+package infra
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/iampolicy"
+)
+
+// DefaultFunctionName names the function unless another name is given
+const DefaultFunctionName = "db-backuper"
+
+// DefaultPackage is the deployment package built from Dockerfile.lambda
+const DefaultPackage = "db-backuper-lambda.zip"
+
+// reservedEnv are set by the Lambda runtime and cannot be configured. The
+// function takes its region and credentials from the runtime instead.
+var reservedEnv = []string{"AWS_REGION", "AWS_DEFAULT_REGION", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"}
+
+// Options describe the function being deployed
+type Options struct {
+	// FunctionName names the function and prefixes its other resources
+	FunctionName string
+	// Package is the path of the zip holding the bootstrap binary
+	Package string
+	// Layers are the ARNs of layers added to the function, such as one with
+	// the PostgreSQL client tools
+	Layers []string
+}
+
+// Stack is the deployment of the backup function for a configuration
+type Stack struct {
+	Options
+	// Region is the region of the backup bucket, where the function runs
+	Region string
+	// Schedule is the EventBridge expression of backup.schedule
+	Schedule string
+	// Env are the variables the function loads its configuration from
+	Env []config.EnvVar
+	// Policy grants the function's role the access of the configuration
+	Policy *iampolicy.Document
+	// Warnings are the settings the function will not receive
+	Warnings []string
+}
+
+// New derives the deployment of the backup function from cfg
+func New(cfg *config.Config, opts Options) (*Stack, error) {
+	if !cfg.IsAWSStorage() {
+		return nil, fmt.Errorf("the Lambda function only stores backups in AWS S3; configure aws.bucket and aws.region")
+	}
+	opts.FunctionName = cmp.Or(opts.FunctionName, DefaultFunctionName)
+	opts.Package = cmp.Or(opts.Package, DefaultPackage)
+
+	schedule, err := ScheduleExpression(cfg.Backup.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := iampolicy.Generate(cfg, iampolicy.Access{})
+	if err != nil {
+		return nil, err
+	}
+	// With role_arn the function assumes the backup role, which holds the
+	// S3 permissions in its own policy
+	if cfg.AWS.RoleARN != "" {
+		policy.Statement = append(policy.Statement, iampolicy.Statement{
+			Sid:      "AssumeBackupRole",
+			Effect:   "Allow",
+			Action:   []string{"sts:AssumeRole"},
+			Resource: []string{cfg.AWS.RoleARN},
+		})
+	}
+
+	stack := &Stack{Options: opts, Region: cfg.AWS.Region, Schedule: schedule, Policy: policy}
+	vars, unsupported := cfg.BackupEnvironment()
+	for _, v := range vars {
+		if slices.Contains(reservedEnv, v.Name) {
+			continue
+		}
+		stack.Env = append(stack.Env, v)
+	}
+	if cfg.AWS.AccessKeyID != "" && cfg.AWS.RoleARN == "" {
+		stack.Warnings = append(stack.Warnings, "aws.access_key_id is not passed to the function, which uses the credentials of its execution role")
+	}
+	for _, path := range unsupported {
+		stack.Warnings = append(stack.Warnings, fmt.Sprintf("%s has no environment variable and is not passed to the function", path))
+	}
+	return stack, nil
+}
+
+// secretName returns the name of the Terraform variable or template
+// parameter holding the secret environment variable name
+func secretName(name string, upperCamel bool) string {
+	if !upperCamel {
+		return strings.ToLower(name)
+	}
+	var b strings.Builder
+	for _, word := range strings.Split(strings.ToLower(name), "_") {
+		if word != "" {
+			b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,69 @@
+package infra
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SAM renders the stack as an AWS SAM template in JSON, which CloudFormation
+// deploys with the AWS::Serverless transform. Secrets become NoEcho
+// parameters.
+func (s *Stack) SAM() ([]byte, error) {
+	parameters := make(map[string]any)
+	variables := make(map[string]any)
+	for _, v := range s.Env {
+		if !v.Secret {
+			variables[v.Name] = v.Value
+			continue
+		}
+		parameter := secretName(v.Name, true)
+		parameters[parameter] = map[string]any{
+			"Type":        "String",
+			"NoEcho":      true,
+			"Description": "Value of " + v.Name,
+		}
+		variables[v.Name] = map[string]string{"Ref": parameter}
+	}
+
+	function := map[string]any{
+		"FunctionName": s.FunctionName,
+		"CodeUri":      s.Package,
+		"Handler":      "bootstrap",
+		"Runtime":      "provided.al2",
+		"Timeout":      900,
+		"MemorySize":   512,
+		// SAM adds AWSLambdaBasicExecutionRole for the logs
+		"Policies":    []any{s.Policy},
+		"Environment": map[string]any{"Variables": variables},
+		"Events": map[string]any{
+			"BackupSchedule": map[string]any{
+				"Type":       "Schedule",
+				"Properties": map[string]string{"Schedule": s.Schedule},
+			},
+		},
+	}
+	if len(s.Layers) > 0 {
+		function["Layers"] = s.Layers
+	}
+
+	template := map[string]any{
+		"AWSTemplateFormatVersion": "2010-09-09",
+		"Transform":                "AWS::Serverless-2016-10-31",
+		"Description":              "db-backuper backups, generated by generate-infra; regenerate after changing the configuration",
+		"Resources": map[string]any{
+			"BackupFunction": map[string]any{
+				"Type":       "AWS::Serverless::Function",
+				"Properties": function,
+			},
+		},
+	}
+	if len(parameters) > 0 {
+		template["Parameters"] = parameters
+	}
+
+	data, err := json.MarshalIndent(template, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode SAM template: %w", err)
+	}
+	return append(data, '\n'), nil
+}
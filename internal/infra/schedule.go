@@ -0,0 +1,119 @@
+package infra
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// descriptors are the cron descriptors with a fixed EventBridge equivalent
+var descriptors = map[string]string{
+	"@yearly":   "cron(0 0 1 1 ? *)",
+	"@annually": "cron(0 0 1 1 ? *)",
+	"@monthly":  "cron(0 0 1 * ? *)",
+	"@weekly":   "cron(0 0 ? * 1 *)",
+	"@daily":    "cron(0 0 * * ? *)",
+	"@midnight": "cron(0 0 * * ? *)",
+	"@hourly":   "cron(0 * * * ? *)",
+}
+
+// ScheduleExpression converts a backup schedule in the standard cron format
+// of backup.schedule into an EventBridge schedule expression. EventBridge
+// rules run in UTC and cannot match both a day of the month and a day of
+// the week, so such schedules are rejected.
+func ScheduleExpression(spec string) (string, error) {
+	spec = strings.TrimSpace(spec)
+	if _, err := cron.ParseStandard(spec); err != nil {
+		return "", fmt.Errorf("invalid backup schedule %q: %w", spec, err)
+	}
+	if strings.HasPrefix(spec, "TZ=") || strings.HasPrefix(spec, "CRON_TZ=") {
+		return "", fmt.Errorf("backup schedule %q sets a time zone, but EventBridge rules run in UTC", spec)
+	}
+	if expression, ok := descriptors[spec]; ok {
+		return expression, nil
+	}
+	if every, ok := strings.CutPrefix(spec, "@every "); ok {
+		return rateExpression(every)
+	}
+
+	fields := strings.Fields(spec)
+	minute, hour, dom, month, dow := fields[0], fields[1], fields[2], fields[3], fields[4]
+	minute, hour = startSteps(minute, "0"), startSteps(hour, "0")
+	dom, month = startSteps(dom, "1"), startSteps(month, "1")
+	switch {
+	case dow == "*":
+		dow = "?"
+	case dom == "*":
+		dom = "?"
+		var err error
+		if dow, err = eventBridgeWeekdays(dow); err != nil {
+			return "", fmt.Errorf("backup schedule %q: %w", spec, err)
+		}
+	default:
+		return "", fmt.Errorf("backup schedule %q restricts both the day of the month and the day of the week, which EventBridge cannot express", spec)
+	}
+	return fmt.Sprintf("cron(%s %s %s %s %s *)", minute, hour, dom, month, dow), nil
+}
+
+// rateExpression converts the interval of an @every schedule into a rate
+func rateExpression(every string) (string, error) {
+	interval, err := time.ParseDuration(every)
+	if err != nil || interval < time.Minute || interval%time.Minute != 0 {
+		return "", fmt.Errorf("backup schedule @every %s must be a whole number of minutes for EventBridge", every)
+	}
+	minutes := int(interval / time.Minute)
+	switch {
+	case minutes%(24*60) == 0:
+		return plural(minutes/(24*60), "day"), nil
+	case minutes%60 == 0:
+		return plural(minutes/60, "hour"), nil
+	default:
+		return plural(minutes, "minute"), nil
+	}
+}
+
+// plural returns the rate of n units, which EventBridge wants singular for 1
+func plural(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("rate(1 %s)", unit)
+	}
+	return fmt.Sprintf("rate(%d %ss)", n, unit)
+}
+
+// startSteps rewrites the */n steps of a field, which EventBridge writes
+// from the first value of the field
+func startSteps(field, first string) string {
+	if step, ok := strings.CutPrefix(field, "*/"); ok {
+		return first + "/" + step
+	}
+	return field
+}
+
+// eventBridgeWeekdays renumbers the days of the week from cron's 0-6,
+// starting on Sunday, to EventBridge's 1-7. Names are the same in both.
+func eventBridgeWeekdays(field string) (string, error) {
+	if strings.Contains(field, "/") {
+		return "", fmt.Errorf("EventBridge does not support steps in the day of the week")
+	}
+	var days []string
+	for _, part := range strings.Split(field, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		day := weekday(from)
+		if isRange {
+			day += "-" + weekday(to)
+		}
+		days = append(days, day)
+	}
+	return strings.Join(days, ","), nil
+}
+
+// weekday renumbers a single day of the week, leaving names alone
+func weekday(day string) string {
+	if n, err := strconv.Atoi(day); err == nil {
+		return strconv.Itoa(n + 1)
+	}
+	return day
+}
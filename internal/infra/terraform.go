@@ -0,0 +1,166 @@
+package infra
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// interpolationEscaper escapes the sequences Terraform would otherwise
+// interpolate in strings and heredocs
+var interpolationEscaper = strings.NewReplacer("${", "$${", "%{", "%%{")
+
+// hclEscaper escapes a value inside a quoted HCL string
+var hclEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`, "${", "$${", "%{", "%%{")
+
+// hclString quotes s as an HCL string
+func hclString(s string) string {
+	return `"` + hclEscaper.Replace(s) + `"`
+}
+
+// Terraform renders the stack as a Terraform module: the function, its
+// execution role and log group, and the EventBridge rule invoking it on the
+// backup schedule. Secrets become sensitive variables.
+func (s *Stack) Terraform() (string, error) {
+	policy, err := json.MarshalIndent(s.Policy, "  ", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode IAM policy: %w", err)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `# Generated by db-backuper generate-infra; regenerate after changing the configuration
+
+terraform {
+  required_version = ">= 1.0"
+  required_providers {
+    aws = {
+      source  = "hashicorp/aws"
+      version = "~> 5.0"
+    }
+  }
+}
+
+provider "aws" {
+  region = %s
+}
+
+data "aws_partition" "current" {}
+
+variable "lambda_package" {
+  description = "Zip holding the bootstrap binary built from Dockerfile.lambda"
+  type        = string
+  default     = %s
+}
+`, hclString(s.Region), hclString(s.Package))
+
+	for _, v := range s.Env {
+		if v.Secret {
+			fmt.Fprintf(&b, `
+variable %s {
+  description = %s
+  type        = string
+  sensitive   = true
+}
+`, hclString(secretName(v.Name, false)), hclString("Value of "+v.Name))
+		}
+	}
+
+	fmt.Fprintf(&b, `
+resource "aws_iam_role" "backup" {
+  name = %s
+
+  assume_role_policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Action    = "sts:AssumeRole"
+        Effect    = "Allow"
+        Principal = { Service = "lambda.amazonaws.com" }
+      }
+    ]
+  })
+}
+
+resource "aws_iam_role_policy" "backup" {
+  name   = "backups"
+  role   = aws_iam_role.backup.id
+  policy = <<-EOT
+  %s
+  EOT
+}
+
+resource "aws_iam_role_policy_attachment" "logs" {
+  role       = aws_iam_role.backup.name
+  policy_arn = "arn:${data.aws_partition.current.partition}:iam::aws:policy/service-role/AWSLambdaBasicExecutionRole"
+}
+
+resource "aws_cloudwatch_log_group" "backup" {
+  name              = %s
+  retention_in_days = 14
+}
+
+resource "aws_lambda_function" "backup" {
+  function_name    = %s
+  role             = aws_iam_role.backup.arn
+  filename         = var.lambda_package
+  source_code_hash = filebase64sha256(var.lambda_package)
+  handler          = "bootstrap"
+  runtime          = "provided.al2"
+  timeout          = 900
+  memory_size      = 512
+`, hclString(s.FunctionName+"-role"), interpolationEscaper.Replace(string(policy)), hclString("/aws/lambda/"+s.FunctionName), hclString(s.FunctionName))
+
+	if len(s.Layers) > 0 {
+		layers := make([]string, len(s.Layers))
+		for i, layer := range s.Layers {
+			layers[i] = hclString(layer)
+		}
+		fmt.Fprintf(&b, "  layers           = [%s]\n", strings.Join(layers, ", "))
+	}
+
+	b.WriteString(`
+  environment {
+    variables = {
+`)
+	// Aligned the way terraform fmt aligns them
+	width := 0
+	for _, v := range s.Env {
+		width = max(width, len(hclString(v.Name)))
+	}
+	for _, v := range s.Env {
+		value := hclString(v.Value)
+		if v.Secret {
+			value = "var." + secretName(v.Name, false)
+		}
+		fmt.Fprintf(&b, "      %-*s = %s\n", width, hclString(v.Name), value)
+	}
+	fmt.Fprintf(&b, `    }
+  }
+
+  depends_on = [
+    aws_iam_role_policy_attachment.logs,
+    aws_cloudwatch_log_group.backup
+  ]
+}
+
+resource "aws_cloudwatch_event_rule" "backup_schedule" {
+  name                = %s
+  description         = "Runs the backups of db-backuper"
+  schedule_expression = %s
+}
+
+resource "aws_cloudwatch_event_target" "backup" {
+  rule = aws_cloudwatch_event_rule.backup_schedule.name
+  arn  = aws_lambda_function.backup.arn
+}
+
+resource "aws_lambda_permission" "allow_eventbridge" {
+  statement_id  = "AllowExecutionFromEventBridge"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.backup.function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.backup_schedule.arn
+}
+`, hclString(s.FunctionName+"-schedule"), hclString(s.Schedule))
+	return b.String(), nil
+}
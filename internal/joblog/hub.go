@@ -0,0 +1,210 @@
+// Package joblog keeps the log lines of recent backup runs in memory and
+// hands them to followers as they are written, so runs can be watched
+// remotely while they are in progress
+package joblog
+
+import (
+	"sync"
+	"time"
+
+	"db-backuper/internal/redact"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Limits of the lines kept in memory
+const (
+	// MaxJobs is how many of the most recent jobs keep their lines
+	MaxJobs = 20
+	// MaxLines is how many of the most recent lines of a job are kept
+	MaxLines = 10000
+	// followerBuffer is how many lines a follower may fall behind before
+	// further lines are dropped for it
+	followerBuffer = 1024
+)
+
+// Job describes a job whose lines are kept. Jobs are backup runs, identified
+// by their run ID.
+type Job struct {
+	ID         string     `json:"id"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Result is the outcome of a finished job, such as success or failed
+	Result string `json:"result,omitempty"`
+	Lines  int    `json:"lines"`
+}
+
+// Line is one log line of a job, numbered from 1 in the order written and
+// encoded as a JSON log entry
+type Line struct {
+	N    int
+	Data []byte
+}
+
+// Hub is a logrus hook collecting the lines logged with a run_id field
+type Hub struct {
+	formatter logrus.Formatter
+
+	mu    sync.Mutex
+	jobs  map[string]*job
+	order []string
+}
+
+// job is a job with its kept lines and followers
+type job struct {
+	Job
+	lines     []Line
+	followers map[chan Line]struct{}
+}
+
+// NewHub creates a hub encoding lines as JSON with credentials masked by redactor
+func NewHub(redactor *redact.Redactor) *Hub {
+	return &Hub{
+		formatter: redact.NewFormatter(&logrus.JSONFormatter{}, redactor),
+		jobs:      make(map[string]*job),
+	}
+}
+
+// Levels returns the levels collected by the hub
+func (h *Hub) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire records a log entry under the job named by its run_id field
+func (h *Hub) Fire(entry *logrus.Entry) error {
+	id, ok := entry.Data["run_id"].(string)
+	if !ok || id == "" {
+		return nil
+	}
+	data, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	if n := len(data); n > 0 && data[n-1] == '\n' {
+		data = data[:n-1]
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	j := h.jobs[id]
+	if j == nil {
+		j = h.start(id, entry.Time)
+	}
+	if j.FinishedAt != nil {
+		// Late lines of a finished job have no followers left to reach
+		return nil
+	}
+	j.Lines++
+	line := Line{N: j.Lines, Data: data}
+	if len(j.lines) == MaxLines {
+		j.lines = j.lines[1:]
+	}
+	j.lines = append(j.lines, line)
+	for follower := range j.followers {
+		select {
+		case follower <- line:
+		default:
+			// Never hold up the job for a follower that is not keeping up
+		}
+	}
+	return nil
+}
+
+// Start adds a job before it logs anything, so it can be followed while
+// it waits to run
+func (h *Hub) Start(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.jobs[id] == nil {
+		h.start(id, time.Now())
+	}
+}
+
+// start adds a job, forgetting the oldest once more than MaxJobs are kept
+func (h *Hub) start(id string, startedAt time.Time) *job {
+	j := &job{Job: Job{ID: id, StartedAt: startedAt}, followers: make(map[chan Line]struct{})}
+	h.jobs[id] = j
+	h.order = append(h.order, id)
+	for len(h.order) > MaxJobs {
+		oldest := h.jobs[h.order[0]]
+		for follower := range oldest.followers {
+			close(follower)
+		}
+		oldest.followers = nil
+		delete(h.jobs, h.order[0])
+		h.order = h.order[1:]
+	}
+	return j
+}
+
+// Finish records the result of a job and ends its followers' streams
+func (h *Hub) Finish(id, result string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	j := h.jobs[id]
+	if j == nil || j.FinishedAt != nil {
+		return
+	}
+	now := time.Now()
+	j.FinishedAt = &now
+	j.Result = result
+	for follower := range j.followers {
+		close(follower)
+	}
+	j.followers = nil
+}
+
+// Jobs returns the jobs whose lines are kept, most recent first
+func (h *Hub) Jobs() []Job {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	jobs := make([]Job, 0, len(h.order))
+	for i := len(h.order) - 1; i >= 0; i-- {
+		jobs = append(jobs, h.jobs[h.order[i]].Job)
+	}
+	return jobs
+}
+
+// Job returns the job with the given ID
+func (h *Hub) Job(id string) (Job, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	j := h.jobs[id]
+	if j == nil {
+		return Job{}, false
+	}
+	return j.Job, true
+}
+
+// Follow returns the kept lines of a job numbered after the given line and,
+// while it runs, a channel receiving its next lines that is closed when it
+// finishes. The channel is nil for a finished job. stop must be called once
+// the caller no longer reads the channel.
+func (h *Hub) Follow(id string, after int) (backlog []Line, lines <-chan Line, stop func(), ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	j := h.jobs[id]
+	if j == nil {
+		return nil, nil, nil, false
+	}
+	for _, line := range j.lines {
+		if line.N > after {
+			backlog = append(backlog, line)
+		}
+	}
+	if j.FinishedAt != nil {
+		return backlog, nil, func() {}, true
+	}
+
+	follower := make(chan Line, followerBuffer)
+	j.followers[follower] = struct{}{}
+	stop = func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, following := j.followers[follower]; following {
+			delete(j.followers, follower)
+			close(follower)
+		}
+	}
+	return backlog, follower, stop, true
+}
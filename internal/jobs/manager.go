@@ -0,0 +1,447 @@
+// Package jobs queues the jobs of scheduler mode, such as backup runs,
+// starting them as capacity allows and keeping the queue across restarts
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"db-backuper/internal/runid"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FileName is the name of the job queue file within the state directory
+const FileName = "jobs.json"
+
+// MaxFinished is how many finished jobs the queue file keeps
+const MaxFinished = 100
+
+// Kinds of jobs
+const (
+	// KindBackup backs up databases
+	KindBackup = "backup"
+	// KindRestore restores a backup into the import target database
+	KindRestore = "restore"
+)
+
+// States of a job
+const (
+	// StatePendingApproval marks a job waiting for a second user to approve it
+	StatePendingApproval = "pending_approval"
+	StateQueued          = "queued"
+	StateRunning         = "running"
+	StateCompleted       = "completed"
+	StateCancelled       = "cancelled"
+	// StateInterrupted marks a job that was running when the process stopped
+	StateInterrupted = "interrupted"
+)
+
+// ErrNotFound is returned for a job that is not known
+var ErrNotFound = errors.New("job not found")
+
+// ErrSelfApproval is returned when a user approves a job they requested
+var ErrSelfApproval = errors.New("a job must be approved by another user than the one who requested it")
+
+// Request describes a job to queue
+type Request struct {
+	Kind    string
+	Trigger string
+	// Databases restricts the job to these databases; empty means all
+	Databases []string
+	// Backup is the storage key of the backup a restore job restores
+	Backup string
+	// RequestedBy names the user who asked for the job, if any
+	RequestedBy string
+	// NeedsApproval holds the job until another user approves it
+	NeedsApproval bool
+}
+
+// Job is one queued, running or finished job. Backup jobs use their ID as
+// the run ID, so it also identifies their logs and results.
+type Job struct {
+	ID      string `json:"id"`
+	Kind    string `json:"kind"`
+	Trigger string `json:"trigger"`
+	// Databases restricts the job to these databases; empty means all
+	Databases []string `json:"databases,omitempty"`
+	// Backup is the storage key of the backup a restore job restores
+	Backup      string `json:"backup,omitempty"`
+	RequestedBy string `json:"requested_by,omitempty"`
+	ApprovedBy  string `json:"approved_by,omitempty"`
+	State       string `json:"state"`
+	// Result is the outcome of a completed job, such as success or failed
+	Result     string     `json:"result,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	QueuedAt   time.Time  `json:"queued_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Finished reports whether the job will not run (any more)
+func (j Job) Finished() bool {
+	return j.State != StatePendingApproval && j.State != StateQueued && j.State != StateRunning
+}
+
+// conflicts reports whether two jobs touch a common database, and so must
+// not run at once. A job of every database conflicts with any other.
+func (j Job) conflicts(other Job) bool {
+	if len(j.Databases) == 0 || len(other.Databases) == 0 {
+		return true
+	}
+	for _, name := range j.Databases {
+		if slices.Contains(other.Databases, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Kind runs the jobs of one kind
+type Kind struct {
+	// Validate rejects a job before it is queued, such as one naming an
+	// unknown database. It may be nil.
+	Validate func(job Job) error
+	// Run runs a job until it finishes or ctx is cancelled, returning its result
+	Run func(ctx context.Context, job Job) (result string, err error)
+}
+
+// Manager queues jobs and runs up to a maximum of them at once, never two
+// touching the same database. It is safe for concurrent use.
+type Manager struct {
+	path       string
+	maxRunning int
+	logger     logrus.FieldLogger
+
+	mu       sync.Mutex
+	kinds    map[string]Kind
+	jobs     []*Job
+	cancels  map[string]context.CancelFunc
+	observer func(Job)
+	started  bool
+	stopped  bool
+	wg       sync.WaitGroup
+}
+
+// NewManager loads the job queue file at path, starting afresh when there is
+// none. Jobs that were running when the process stopped are marked
+// interrupted; queued ones run once the manager is started, and pending
+// ones keep waiting for approval.
+func NewManager(path string, maxRunning int, logger logrus.FieldLogger) (*Manager, error) {
+	if maxRunning < 1 {
+		maxRunning = 1
+	}
+	m := &Manager{
+		path:       path,
+		maxRunning: maxRunning,
+		logger:     logger,
+		kinds:      make(map[string]Kind),
+		cancels:    make(map[string]context.CancelFunc),
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read job queue: %w", err)
+	}
+	if err := json.Unmarshal(data, &m.jobs); err != nil {
+		return nil, fmt.Errorf("failed to parse job queue %s: %w", path, err)
+	}
+	for _, job := range m.jobs {
+		if job.State == StateRunning {
+			now := time.Now()
+			job.State = StateInterrupted
+			job.Error = "the service stopped while the job was running"
+			job.FinishedAt = &now
+		}
+	}
+	return m, m.save()
+}
+
+// Handle registers how jobs of a kind are run
+func (m *Manager) Handle(kind string, k Kind) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.kinds[kind] = k
+}
+
+// Observe calls fn with a copy of a job whenever it is queued, started or
+// finished. fn is called with the manager locked and must not call it back.
+func (m *Manager) Observe(fn func(Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observer = fn
+}
+
+// Start begins running queued jobs
+func (m *Manager) Start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.started = true
+	m.dispatch()
+}
+
+// Stop stops starting jobs and waits for the running ones to finish. Jobs
+// still queued stay in the queue file and run after a restart.
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	m.stopped = true
+	m.mu.Unlock()
+	m.wg.Wait()
+}
+
+// Submit queues a job. When a job of the same kind, databases and backup
+// is already pending, queued or running, that job is returned instead and
+// queued is false.
+func (m *Manager) Submit(request Request) (job Job, queued bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.kinds[request.Kind]
+	if !ok {
+		return Job{}, false, fmt.Errorf("unknown job kind %s", request.Kind)
+	}
+	if m.stopped {
+		return Job{}, false, errors.New("the service is shutting down")
+	}
+	added := &Job{
+		ID:          runid.New(),
+		Kind:        request.Kind,
+		Trigger:     request.Trigger,
+		Databases:   slices.Compact(slices.Sorted(slices.Values(request.Databases))),
+		Backup:      request.Backup,
+		RequestedBy: request.RequestedBy,
+		State:       StateQueued,
+		QueuedAt:    time.Now(),
+	}
+	if request.NeedsApproval {
+		added.State = StatePendingApproval
+	}
+	if k.Validate != nil {
+		if err := k.Validate(*added); err != nil {
+			return Job{}, false, err
+		}
+	}
+	for _, existing := range m.jobs {
+		if !existing.Finished() && existing.Kind == added.Kind && slices.Equal(existing.Databases, added.Databases) && existing.Backup == added.Backup {
+			return *existing, false, nil
+		}
+	}
+
+	m.jobs = append(m.jobs, added)
+	m.changed(added)
+	m.dispatch()
+	return *added, true, nil
+}
+
+// Approve queues a job pending approval on behalf of approver, who must not
+// be the user who requested it
+func (m *Manager) Approve(id, approver string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job := m.find(id)
+	if job == nil {
+		return Job{}, ErrNotFound
+	}
+	if job.State != StatePendingApproval {
+		return *job, fmt.Errorf("job %s is %s, not pending approval", id, job.State)
+	}
+	if approver == "" || approver == job.RequestedBy {
+		return *job, ErrSelfApproval
+	}
+	job.State = StateQueued
+	job.ApprovedBy = approver
+	m.changed(job)
+	m.dispatch()
+	return *job, nil
+}
+
+// Cancel cancels a job. A pending or queued job never starts; a running
+// one has its context cancelled and stops at its next cancellation point,
+// or completes when it gets that far regardless.
+func (m *Manager) Cancel(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job := m.find(id)
+	if job == nil {
+		return Job{}, ErrNotFound
+	}
+	switch job.State {
+	case StatePendingApproval, StateQueued:
+		now := time.Now()
+		job.State = StateCancelled
+		job.FinishedAt = &now
+		m.changed(job)
+		m.dispatch()
+	case StateRunning:
+		m.cancels[id]()
+	default:
+		return *job, fmt.Errorf("job %s already %s", id, job.State)
+	}
+	return *job, nil
+}
+
+// Jobs returns every job known, most recently queued first
+func (m *Manager) Jobs() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]Job, 0, len(m.jobs))
+	for i := len(m.jobs) - 1; i >= 0; i-- {
+		jobs = append(jobs, *m.jobs[i])
+	}
+	return jobs
+}
+
+// Job returns the job with the given ID
+func (m *Manager) Job(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job := m.find(id); job != nil {
+		return *job, true
+	}
+	return Job{}, false
+}
+
+// find returns the job with the given ID. m.mu must be held.
+func (m *Manager) find(id string) *Job {
+	for _, job := range m.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// dispatch starts the queued jobs, oldest first, that fit under the limit
+// and touch no database of a running or older queued job. m.mu must be held.
+func (m *Manager) dispatch() {
+	if !m.started || m.stopped {
+		return
+	}
+	var busy []*Job
+	for _, job := range m.jobs {
+		if job.State == StateRunning {
+			busy = append(busy, job)
+		}
+	}
+	running := len(busy)
+	for _, job := range m.jobs {
+		if job.State != StateQueued {
+			continue
+		}
+		if running < m.maxRunning && !slices.ContainsFunc(busy, func(other *Job) bool { return job.conflicts(*other) }) {
+			m.run(job)
+			running++
+		}
+		busy = append(busy, job)
+	}
+}
+
+// run starts a queued job in the background. m.mu must be held.
+func (m *Manager) run(job *Job) {
+	ctx, cancel := context.WithCancel(context.Background())
+	now := time.Now()
+	job.State = StateRunning
+	job.StartedAt = &now
+	m.cancels[job.ID] = cancel
+	m.changed(job)
+
+	k := m.kinds[job.Kind]
+	snapshot := *job
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer cancel()
+		logger := m.logger.WithField("job", snapshot.ID)
+		logger.Infof("Starting %s %s job", snapshot.Trigger, snapshot.Kind)
+		result, err := m.runKind(ctx, k, snapshot)
+		cancelled := ctx.Err() != nil && err != nil
+		switch {
+		case cancelled:
+			logger.Warnf("%s %s job cancelled", snapshot.Trigger, snapshot.Kind)
+		case err != nil:
+			logger.Errorf("%s %s job failed: %v", snapshot.Trigger, snapshot.Kind, err)
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		finished := time.Now()
+		job.FinishedAt = &finished
+		job.State = StateCompleted
+		if cancelled {
+			job.State = StateCancelled
+		}
+		job.Result = result
+		if err != nil {
+			job.Error = err.Error()
+		}
+		delete(m.cancels, job.ID)
+		m.changed(job)
+		m.dispatch()
+	}()
+}
+
+// runKind runs a job, turning a panic into an error so it cannot take down
+// the scheduler
+func (m *Manager) runKind(ctx context.Context, k Kind, job Job) (result string, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("job panicked: %v", recovered)
+		}
+	}()
+	return k.Run(ctx, job)
+}
+
+// changed persists the queue and tells the observer about a job. m.mu must be held.
+func (m *Manager) changed(job *Job) {
+	if err := m.save(); err != nil {
+		m.logger.Warnf("Failed to save job queue: %v", err)
+	}
+	if m.observer != nil {
+		m.observer(*job)
+	}
+}
+
+// save writes the queue file through a temporary file in the same
+// directory, keeping the most recent MaxFinished finished jobs. m.mu must be held.
+func (m *Manager) save() error {
+	finished := 0
+	for i := len(m.jobs) - 1; i >= 0; i-- {
+		if m.jobs[i].Finished() {
+			if finished++; finished > MaxFinished {
+				m.jobs = slices.Delete(m.jobs, i, i+1)
+			}
+		}
+	}
+	data, err := json.MarshalIndent(m.jobs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode job queue: %w", err)
+	}
+	dir := filepath.Dir(m.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create job queue directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, FileName+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write job queue: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write job queue: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write job queue: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), m.path); err != nil {
+		return fmt.Errorf("failed to replace job queue: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"fmt"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/s3"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+)
+
+// cloudWatchBatchSize is the number of datums sent per PutMetricData call
+const cloudWatchBatchSize = 500
+
+// CloudWatch publishes metrics to a CloudWatch namespace, with a Database
+// dimension on per-database metrics and a Tenant dimension in multi-tenant mode
+type CloudWatch struct {
+	namespace string
+	client    cloudwatchiface.CloudWatchAPI
+}
+
+// NewCloudWatch creates a CloudWatch sink using the credentials of the AWS configuration
+func NewCloudWatch(cwConfig *config.CloudWatchConfig, awsConfig config.AWSConfig) (*CloudWatch, error) {
+	if cwConfig.Region != "" {
+		awsConfig.Region = cwConfig.Region
+	}
+	sess, err := s3.NewSession(&awsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return NewCloudWatchWithClient(cwConfig.Namespace, cloudwatch.New(sess)), nil
+}
+
+// NewCloudWatchWithClient creates a CloudWatch sink sending through client
+func NewCloudWatchWithClient(namespace string, client cloudwatchiface.CloudWatchAPI) *CloudWatch {
+	return &CloudWatch{namespace: namespace, client: client}
+}
+
+// Name returns the name of the sink
+func (c *CloudWatch) Name() string {
+	return "cloudwatch"
+}
+
+// Publish puts the samples into the namespace
+func (c *CloudWatch) Publish(samples []Sample) error {
+	datums := make([]*cloudwatch.MetricDatum, 0, len(samples))
+	for _, sample := range samples {
+		timestamp := sample.Timestamp
+		if timestamp.IsZero() {
+			timestamp = time.Now()
+		}
+		datum := &cloudwatch.MetricDatum{
+			MetricName: aws.String(sample.Name),
+			Value:      aws.Float64(sample.Value),
+			Unit:       aws.String(sample.Unit),
+			Timestamp:  aws.Time(timestamp),
+		}
+		if sample.Database != "" {
+			datum.Dimensions = append(datum.Dimensions, &cloudwatch.Dimension{
+				Name:  aws.String("Database"),
+				Value: aws.String(sample.Database),
+			})
+		}
+		if sample.Tenant != "" {
+			datum.Dimensions = append(datum.Dimensions, &cloudwatch.Dimension{
+				Name:  aws.String("Tenant"),
+				Value: aws.String(sample.Tenant),
+			})
+		}
+		datums = append(datums, datum)
+	}
+
+	for start := 0; start < len(datums); start += cloudWatchBatchSize {
+		end := min(start+cloudWatchBatchSize, len(datums))
+		if _, err := c.client.PutMetricData(&cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(c.namespace),
+			MetricData: datums[start:end],
+		}); err != nil {
+			return fmt.Errorf("failed to put metric data: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+// Package metrics publishes the metrics of backup runs to monitoring systems
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/status"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Metric names
+const (
+	BackupSuccess         = "BackupSuccess"
+	BackupDurationSeconds = "BackupDurationSeconds"
+	BackupSizeBytes       = "BackupSizeBytes"
+	ObjectsDeleted        = "ObjectsDeleted"
+	BackupAgeSeconds      = "BackupAgeSeconds"
+	SLAViolation          = "SLAViolation"
+	// RestoreDurationSeconds is published for each measured restore
+	RestoreDurationSeconds = "RestoreDurationSeconds"
+)
+
+// Units of the metrics, named as in CloudWatch
+const (
+	UnitCount   = "Count"
+	UnitSeconds = "Seconds"
+	UnitBytes   = "Bytes"
+)
+
+// Sample is one metric value. Per-database metrics name their database;
+// run-wide metrics leave it empty. Tenant is set in multi-tenant mode.
+type Sample struct {
+	Name      string
+	Value     float64
+	Unit      string
+	Database  string
+	Tenant    string
+	Timestamp time.Time
+}
+
+// Sink receives the samples of a backup run or SLA check
+type Sink interface {
+	Name() string
+	Publish(samples []Sample) error
+}
+
+// Publisher sends the metrics of every run to the configured sinks
+type Publisher struct {
+	sinks  []Sink
+	tenant string
+	logger logrus.FieldLogger
+}
+
+// NewPublisher creates a publisher for the sinks enabled in cfg
+func NewPublisher(cfg *config.Config, logger logrus.FieldLogger) (*Publisher, error) {
+	p := &Publisher{logger: logger}
+	if cfg.Metrics.CloudWatch.Namespace != "" {
+		sink, err := NewCloudWatch(&cfg.Metrics.CloudWatch, cfg.AWS)
+		if err != nil {
+			return nil, err
+		}
+		p.sinks = append(p.sinks, sink)
+	}
+	if cfg.Metrics.StatsD.Address != "" {
+		p.sinks = append(p.sinks, NewStatsD(&cfg.Metrics.StatsD))
+	}
+	return p, nil
+}
+
+// SetTenant tags every published sample with the tenant it belongs to
+func (p *Publisher) SetTenant(tenant string) {
+	p.tenant = tenant
+}
+
+// Publish sends the metrics of a run to every sink
+func (p *Publisher) Publish(summary *status.RunSummary) error {
+	if len(p.sinks) == 0 {
+		return nil
+	}
+	return p.PublishSamples(Collect(summary))
+}
+
+// PublishSamples sends samples collected outside of a run to every sink
+func (p *Publisher) PublishSamples(samples []Sample) error {
+	if p.tenant != "" {
+		for i := range samples {
+			samples[i].Tenant = p.tenant
+		}
+	}
+	var errs []error
+	for _, sink := range p.sinks {
+		if err := sink.Publish(samples); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sink.Name(), err))
+			continue
+		}
+		p.logger.Debugf("Published %d metrics to %s", len(samples), sink.Name())
+	}
+	return errors.Join(errs...)
+}
+
+// Collect returns the samples of a run: success, duration and, for
+// successful backups, size per database, and the objects deleted by retention
+func Collect(summary *status.RunSummary) []Sample {
+	var samples []Sample
+	for _, result := range summary.Databases {
+		success := 0.0
+		if result.Status == status.ResultSuccess {
+			success = 1
+		}
+		timestamp := result.FinishedAt
+		samples = append(samples,
+			Sample{Name: BackupSuccess, Value: success, Unit: UnitCount, Database: result.Database, Timestamp: timestamp},
+			Sample{Name: BackupDurationSeconds, Value: result.DurationSeconds, Unit: UnitSeconds, Database: result.Database, Timestamp: timestamp},
+		)
+		if success == 1 {
+			samples = append(samples, Sample{Name: BackupSizeBytes, Value: float64(result.SizeBytes), Unit: UnitBytes, Database: result.Database, Timestamp: timestamp})
+		}
+	}
+	samples = append(samples, Sample{Name: ObjectsDeleted, Value: float64(summary.ObjectsDeleted), Unit: UnitCount, Timestamp: summary.FinishedAt})
+	return samples
+}
+
+// CollectRestore returns the samples of a measured restore of database
+func CollectRestore(database string, restore status.RestoreSample) []Sample {
+	return []Sample{{Name: RestoreDurationSeconds, Value: restore.DurationSeconds, Unit: UnitSeconds, Database: database, Timestamp: restore.FinishedAt}}
+}
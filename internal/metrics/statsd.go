@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"db-backuper/internal/config"
+)
+
+// statsDPacketSize keeps each datagram within a typical network MTU
+const statsDPacketSize = 1432
+
+// statsDNames maps the metric names to StatsD names, appended to the prefix
+var statsDNames = map[string]string{
+	BackupSuccess:          "success",
+	BackupDurationSeconds:  "duration_seconds",
+	BackupSizeBytes:        "size_bytes",
+	ObjectsDeleted:         "objects_deleted",
+	BackupAgeSeconds:       "age_seconds",
+	SLAViolation:           "sla_violation",
+	RestoreDurationSeconds: "restore_duration_seconds",
+}
+
+// StatsD sends metrics as gauges to a StatsD server or Datadog agent over
+// UDP, tagged with the database and environment in the DogStatsD format
+type StatsD struct {
+	address string
+	prefix  string
+	tags    []string
+}
+
+// NewStatsD creates a StatsD sink
+func NewStatsD(statsdConfig *config.StatsDConfig) *StatsD {
+	tags := append([]string(nil), statsdConfig.Tags...)
+	if statsdConfig.Environment != "" {
+		tags = append(tags, "env:"+statsdConfig.Environment)
+	}
+	return &StatsD{
+		address: statsdConfig.Address,
+		prefix:  statsdConfig.MetricPrefix(),
+		tags:    tags,
+	}
+}
+
+// Name returns the name of the sink
+func (s *StatsD) Name() string {
+	return "statsd"
+}
+
+// Publish sends the samples, packing as many lines into each datagram as fit
+func (s *StatsD) Publish(samples []Sample) error {
+	conn, err := net.Dial("udp", s.address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", s.address, err)
+	}
+	defer conn.Close()
+
+	var packet strings.Builder
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := conn.Write([]byte(packet.String()))
+		packet.Reset()
+		if err != nil {
+			return fmt.Errorf("failed to send metrics to %s: %w", s.address, err)
+		}
+		return nil
+	}
+
+	for _, sample := range samples {
+		line := s.line(sample)
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsDPacketSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	return flush()
+}
+
+// line formats a sample as a gauge, e.g. db_backup.success:1|g|#database:orders,env:prod
+func (s *StatsD) line(sample Sample) string {
+	name, ok := statsDNames[sample.Name]
+	if !ok {
+		name = sample.Name
+	}
+	line := s.prefix + name + ":" + strconv.FormatFloat(sample.Value, 'f', -1, 64) + "|g"
+
+	tags := s.tags
+	if sample.Tenant != "" {
+		tags = append([]string{"tenant:" + sample.Tenant}, tags...)
+	}
+	if sample.Database != "" {
+		tags = append([]string{"database:" + sample.Database}, tags...)
+	}
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
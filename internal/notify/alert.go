@@ -0,0 +1,22 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Alert sends a message raised outside of a backup run, such as an SLA
+// violation, to every channel regardless of its trigger. Alerts are not
+// deduplicated; the caller only raises them when a condition changes.
+func (n *Notifier) Alert(subject, body string, failed bool) error {
+	msg := &Message{Subject: subject, Body: body, Failed: failed}
+	var errs []error
+	for _, ch := range n.channels {
+		if err := n.post(ch, msg); err != nil {
+			errs = append(errs, fmt.Errorf("notification channel %s: %w", ch.config.DisplayName(), err))
+			continue
+		}
+		n.logger.Infof("Alert sent to %s", ch.config.DisplayName())
+	}
+	return errors.Join(errs...)
+}
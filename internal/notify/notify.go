@@ -0,0 +1,283 @@
+// Package notify sends backup run summaries to chat, webhook and plugin channels
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/plugin"
+	"db-backuper/internal/progress"
+	"db-backuper/internal/status"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultSubject is the subject template of channels that set none
+const DefaultSubject = `Backup run {{.RunID}}: {{.Successful}} succeeded, {{.Failed}} failed{{if .Skipped}}, {{.Skipped}} skipped{{end}}`
+
+// DefaultBody is the body template of channels that set none
+const DefaultBody = `{{if .Escalated}}Escalated after repeated failures: {{join .Escalated ", "}}
+{{end}}{{range .Databases}}- {{.Database}}: {{.Status}}{{if .Error}} ({{.Error}}){{else}}, {{bytes .SizeBytes}} in {{seconds .DurationSeconds}}{{end}}
+{{end}}{{if .Suppressed}}{{.Suppressed}} identical notification(s) suppressed since the last one{{end}}`
+
+// Run is what notification templates execute against: the run summary and
+// the notifier's view of repeated failures
+type Run struct {
+	*status.RunSummary
+	// Escalated lists the failed databases that reached the escalation threshold
+	Escalated []string
+	// Suppressed counts the identical notifications suppressed since the previous one
+	Suppressed int
+}
+
+// Message is a notification rendered for one channel. Summary is nil for
+// alerts that are not about a single run.
+type Message struct {
+	Subject string
+	Body    string
+	Failed  bool
+	Summary *status.RunSummary
+}
+
+// Notifier sends one digest of each run to the configured channels,
+// suppressing repeated identical failures and escalating persistent ones
+type Notifier struct {
+	config   *config.NotificationsConfig
+	channels []*channel
+	state    *state
+	client   *http.Client
+	logger   logrus.FieldLogger
+}
+
+// channel is a configured channel with its parsed templates
+type channel struct {
+	config  config.ChannelConfig
+	subject *template.Template
+	body    *template.Template
+}
+
+// Funcs are the functions available to notification templates in addition to the built-in ones
+var Funcs = template.FuncMap{
+	"bytes":   progress.FormatBytes,
+	"seconds": formatSeconds,
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"join":    strings.Join,
+	"time": func(t time.Time, layout string) string {
+		return t.Format(layout)
+	},
+	"failed": func(run *Run) []status.DatabaseResult {
+		var failed []status.DatabaseResult
+		for _, result := range run.Databases {
+			if result.Status != status.ResultSuccess {
+				failed = append(failed, result)
+			}
+		}
+		return failed
+	},
+}
+
+// NewNotifier creates a notifier for the configured channels, parsing their
+// templates and loading the state of previous runs
+func NewNotifier(notificationsConfig *config.NotificationsConfig, logger logrus.FieldLogger) (*Notifier, error) {
+	s, err := loadState(notificationsConfig.StatePath)
+	if err != nil {
+		return nil, err
+	}
+	n := &Notifier{
+		config: notificationsConfig,
+		state:  s,
+		client: &http.Client{Timeout: 30 * time.Second},
+		logger: logger,
+	}
+	for _, channelConfig := range notificationsConfig.Channels {
+		subject, err := parseTemplate(channelConfig.DisplayName()+" subject", channelConfig.Subject, DefaultSubject)
+		if err != nil {
+			return nil, err
+		}
+		// Teams cards list every database as a fact, so their body is empty by default
+		defaultBody := DefaultBody
+		if channelConfig.Type == config.ChannelTeams {
+			defaultBody = ""
+		}
+		body, err := parseTemplate(channelConfig.DisplayName()+" body", channelConfig.Body, defaultBody)
+		if err != nil {
+			return nil, err
+		}
+		n.channels = append(n.channels, &channel{config: channelConfig, subject: subject, body: body})
+	}
+	return n, nil
+}
+
+// parseTemplate parses a notification template, falling back to the default when text is empty
+func parseTemplate(name, text, fallback string) (*template.Template, error) {
+	if text == "" {
+		text = fallback
+	}
+	tmpl, err := template.New(name).Funcs(Funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// Render executes the templates of the named channel against a run summary
+func (n *Notifier) Render(name string, summary *status.RunSummary) (*Message, error) {
+	for _, ch := range n.channels {
+		if ch.config.DisplayName() == name {
+			return ch.render(&Run{RunSummary: summary})
+		}
+	}
+	return nil, fmt.Errorf("unknown notification channel %s", name)
+}
+
+// Notify sends the run summary to every channel whose trigger matches the
+// run. A failed run identical to the previous notification is suppressed
+// until the repeat interval has passed, unless a database has just reached
+// the escalation threshold.
+func (n *Notifier) Notify(summary *status.RunSummary) error {
+	var errs []error
+	newlyEscalated := n.state.record(summary, n.config.EscalateAfter)
+	run := &Run{RunSummary: summary, Escalated: n.state.escalated(summary, n.config.EscalateAfter)}
+
+	suppressed := false
+	if summary.Unsuccessful() > 0 {
+		now := time.Now()
+		current := fingerprint(summary)
+		if current == n.state.Fingerprint && len(newlyEscalated) == 0 && now.Sub(n.state.LastSent) < n.config.RepeatInterval() {
+			suppressed = true
+			n.state.Suppressed++
+			n.logger.Infof("Suppressing notification identical to the one sent at %s", n.state.LastSent.Format(time.RFC3339))
+		} else {
+			run.Suppressed = n.state.Suppressed
+			n.state.Fingerprint, n.state.LastSent, n.state.Suppressed = current, now, 0
+		}
+	} else {
+		n.state.Fingerprint, n.state.Suppressed = "", 0
+	}
+	if err := n.state.save(n.config.StatePath); err != nil {
+		errs = append(errs, err)
+	}
+	if len(newlyEscalated) > 0 {
+		n.logger.Warnf("Escalating failures of %s after %d consecutive failed runs", strings.Join(newlyEscalated, ", "), n.config.EscalateAfter)
+	}
+	if suppressed {
+		return errors.Join(errs...)
+	}
+
+	for _, ch := range n.channels {
+		switch ch.config.Trigger() {
+		case config.NotifyOnFailure:
+			if summary.Unsuccessful() == 0 {
+				continue
+			}
+		case config.NotifyOnEscalation:
+			if len(run.Escalated) == 0 {
+				continue
+			}
+		}
+		if err := n.send(ch, run); err != nil {
+			errs = append(errs, fmt.Errorf("notification channel %s: %w", ch.config.DisplayName(), err))
+			continue
+		}
+		n.logger.Infof("Notification sent to %s", ch.config.DisplayName())
+	}
+	return errors.Join(errs...)
+}
+
+// send renders and posts the message of one channel
+func (n *Notifier) send(ch *channel, run *Run) error {
+	msg, err := ch.render(run)
+	if err != nil {
+		return err
+	}
+	return n.post(ch, msg)
+}
+
+// post sends a rendered message to a channel
+func (n *Notifier) post(ch *channel, msg *Message) error {
+	payload, err := json.Marshal(ch.payload(msg))
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+	if ch.config.Type == config.ChannelPlugin {
+		return runPlugin(ch, payload)
+	}
+
+	resp, err := n.client.Post(ch.config.URL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to send notification: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("notification rejected with status %s", resp.Status)
+	}
+	return nil
+}
+
+// runPlugin delivers an encoded notification through the plugin of a channel
+func runPlugin(ch *channel, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), plugin.Timeout)
+	defer cancel()
+	args := append(append([]string{}, ch.config.Args...), "notify")
+	if _, err := plugin.Run(ctx, ch.config.Command(), args, bytes.NewReader(payload)); err != nil {
+		return fmt.Errorf("failed to send notification: %w", err)
+	}
+	return nil
+}
+
+// render executes the channel's templates against a run
+func (ch *channel) render(run *Run) (*Message, error) {
+	var subject, body strings.Builder
+	if err := ch.subject.Execute(&subject, run); err != nil {
+		return nil, fmt.Errorf("failed to render subject: %w", err)
+	}
+	if err := ch.body.Execute(&body, run); err != nil {
+		return nil, fmt.Errorf("failed to render body: %w", err)
+	}
+	return &Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    strings.TrimSpace(body.String()),
+		Failed:  run.Unsuccessful() > 0,
+		Summary: run.RunSummary,
+	}, nil
+}
+
+// payload returns the JSON document the channel type expects
+func (ch *channel) payload(msg *Message) any {
+	switch ch.config.Type {
+	case config.ChannelSlack:
+		return map[string]string{"text": "*" + msg.Subject + "*\n" + msg.Body}
+	case config.ChannelDiscord:
+		return map[string]string{"content": "**" + msg.Subject + "**\n" + msg.Body}
+	case config.ChannelTeams:
+		return teamsPayload(msg)
+	default:
+		payload := map[string]any{
+			"subject": msg.Subject,
+			"body":    msg.Body,
+			"failed":  msg.Failed,
+		}
+		if msg.Summary != nil {
+			payload["run_id"] = msg.Summary.RunID
+			payload["successful"] = msg.Summary.Successful
+			payload["failures"] = msg.Summary.Failed
+			payload["skipped"] = msg.Summary.Skipped
+			payload["databases"] = msg.Summary.Databases
+		}
+		return payload
+	}
+}
+
+// formatSeconds formats a duration in seconds, rounded to the second
+func formatSeconds(seconds float64) string {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second).String()
+}
@@ -0,0 +1,122 @@
+package notify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"db-backuper/internal/status"
+)
+
+// state is what the notifier remembers between runs to deduplicate and escalate failures
+type state struct {
+	// ConsecutiveFailures counts the runs in a row each database failed
+	ConsecutiveFailures map[string]int `json:"consecutive_failures"`
+	// Fingerprint identifies the failures of the last notification sent
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// LastSent is when the last failure notification was sent
+	LastSent time.Time `json:"last_sent,omitzero"`
+	// Suppressed counts the identical notifications suppressed since then
+	Suppressed int `json:"suppressed,omitempty"`
+}
+
+// loadState reads the notification state file, starting afresh when there is none
+func loadState(path string) (*state, error) {
+	s := &state{ConsecutiveFailures: make(map[string]int)}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notification state: %w", err)
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse notification state %s: %w", path, err)
+	}
+	if s.ConsecutiveFailures == nil {
+		s.ConsecutiveFailures = make(map[string]int)
+	}
+	return s, nil
+}
+
+// save writes the notification state file, replacing it atomically
+func (s *state) save(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode notification state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create notification state directory: %w", err)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write notification state: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace notification state: %w", err)
+	}
+	return nil
+}
+
+// record updates the consecutive failure counts with a run and returns the
+// databases reaching the escalation threshold for the first time
+func (s *state) record(summary *status.RunSummary, escalateAfter int) []string {
+	var escalated []string
+	for _, result := range summary.Databases {
+		if result.Status == status.ResultSuccess {
+			delete(s.ConsecutiveFailures, result.Database)
+			continue
+		}
+		s.ConsecutiveFailures[result.Database]++
+		if escalateAfter > 0 && s.ConsecutiveFailures[result.Database] == escalateAfter {
+			escalated = append(escalated, result.Database)
+		}
+	}
+	return escalated
+}
+
+// escalated returns the databases that failed in the run and have failed
+// escalateAfter or more runs in a row
+func (s *state) escalated(summary *status.RunSummary, escalateAfter int) []string {
+	var names []string
+	if escalateAfter == 0 {
+		return names
+	}
+	for _, result := range summary.Databases {
+		if result.Status != status.ResultSuccess && s.ConsecutiveFailures[result.Database] >= escalateAfter {
+			names = append(names, result.Database)
+		}
+	}
+	return names
+}
+
+// fingerprint identifies the set of failures of a run, so an identical
+// repeat can be recognised regardless of timings and run IDs
+func fingerprint(summary *status.RunSummary) string {
+	var failures []string
+	for _, result := range summary.Databases {
+		if result.Status != status.ResultSuccess {
+			failures = append(failures, result.Database+"\x00"+result.Error)
+		}
+	}
+	if len(failures) == 0 {
+		return ""
+	}
+	sort.Strings(failures)
+	hash := sha256.New()
+	for _, failure := range failures {
+		hash.Write([]byte(failure + "\n"))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
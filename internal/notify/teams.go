@@ -0,0 +1,95 @@
+package notify
+
+import (
+	"fmt"
+
+	"db-backuper/internal/progress"
+	"db-backuper/internal/status"
+)
+
+// adaptiveCardContentType is the attachment content type of an Adaptive Card
+const adaptiveCardContentType = "application/vnd.microsoft.card.adaptive"
+
+// teamsMessage is the document a Teams incoming webhook accepts
+type teamsMessage struct {
+	Type        string            `json:"type"`
+	Attachments []teamsAttachment `json:"attachments"`
+}
+
+type teamsAttachment struct {
+	ContentType string       `json:"contentType"`
+	Content     adaptiveCard `json:"content"`
+}
+
+type adaptiveCard struct {
+	Schema  string        `json:"$schema"`
+	Type    string        `json:"type"`
+	Version string        `json:"version"`
+	Body    []cardElement `json:"body"`
+}
+
+// cardElement is an Adaptive Card element; only the fields of its type are set
+type cardElement struct {
+	Type   string        `json:"type"`
+	Style  string        `json:"style,omitempty"`
+	Bleed  bool          `json:"bleed,omitempty"`
+	Items  []cardElement `json:"items,omitempty"`
+	Text   string        `json:"text,omitempty"`
+	Size   string        `json:"size,omitempty"`
+	Weight string        `json:"weight,omitempty"`
+	Color  string        `json:"color,omitempty"`
+	Wrap   bool          `json:"wrap,omitempty"`
+	Facts  []cardFact    `json:"facts,omitempty"`
+}
+
+type cardFact struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+// teamsPayload builds an Adaptive Card with a header colored by the run
+// outcome, the rendered body and one fact per database
+func teamsPayload(msg *Message) teamsMessage {
+	style, color := "good", "Good"
+	if msg.Failed {
+		style, color = "attention", "Attention"
+	}
+
+	body := []cardElement{{
+		Type:  "Container",
+		Style: style,
+		Bleed: true,
+		Items: []cardElement{{Type: "TextBlock", Text: msg.Subject, Size: "Medium", Weight: "Bolder", Color: color, Wrap: true}},
+	}}
+	if msg.Body != "" {
+		body = append(body, cardElement{Type: "TextBlock", Text: msg.Body, Wrap: true})
+	}
+	if msg.Summary != nil && len(msg.Summary.Databases) > 0 {
+		facts := make([]cardFact, 0, len(msg.Summary.Databases))
+		for _, result := range msg.Summary.Databases {
+			facts = append(facts, cardFact{Title: result.Database, Value: describeResult(result)})
+		}
+		body = append(body, cardElement{Type: "FactSet", Facts: facts})
+	}
+
+	return teamsMessage{
+		Type: "message",
+		Attachments: []teamsAttachment{{
+			ContentType: adaptiveCardContentType,
+			Content: adaptiveCard{
+				Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+				Type:    "AdaptiveCard",
+				Version: "1.4",
+				Body:    body,
+			},
+		}},
+	}
+}
+
+// describeResult summarises a database result in one line
+func describeResult(result status.DatabaseResult) string {
+	if result.Status != status.ResultSuccess {
+		return fmt.Sprintf("%s: %s", result.Status, result.Error)
+	}
+	return fmt.Sprintf("success, %s in %s", progress.FormatBytes(result.SizeBytes), formatSeconds(result.DurationSeconds))
+}
@@ -0,0 +1,98 @@
+// Package output writes the results of the read commands as a table, JSON
+// or YAML, the formats selected by their -output flag
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of the read commands
+const (
+	Table = "table"
+	JSON  = "json"
+	YAML  = "yaml"
+)
+
+// Formats lists the values accepted by -output
+var Formats = []string{Table, JSON, YAML}
+
+// Format is the value of an -output flag. It rejects unknown formats when
+// the flag is parsed, and shell completion offers Formats for it.
+type Format string
+
+// String returns the format
+func (f *Format) String() string {
+	return string(*f)
+}
+
+// Set selects a format, which must be one of Formats
+func (f *Format) Set(value string) error {
+	if !slices.Contains(Formats, value) {
+		return fmt.Errorf("unknown output format %q, expected table, json or yaml", value)
+	}
+	*f = Format(value)
+	return nil
+}
+
+// WriteList writes results like Write, printing an empty list rather than
+// null in JSON and YAML when there are none
+func WriteList[T any](w io.Writer, format Format, results []T, table func(w io.Writer)) error {
+	if results == nil {
+		results = []T{}
+	}
+	return Write(w, format, results, table)
+}
+
+// Write writes result in format. A table is written by table through a
+// tabwriter; JSON and YAML use the JSON field names of result.
+func Write(w io.Writer, format Format, result any, table func(w io.Writer)) error {
+	switch format {
+	case JSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	case YAML:
+		return WriteYAML(w, result)
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		table(tw)
+		return tw.Flush()
+	}
+}
+
+// WriteYAML writes value as YAML with the field names and order of its JSON
+// encoding, so both formats describe results the same way
+func WriteYAML(w io.Writer, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode output: %w", err)
+	}
+	// JSON is YAML, and decoding it into a node keeps the field order
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return fmt.Errorf("failed to encode output: %w", err)
+	}
+	blockStyle(&node)
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return fmt.Errorf("failed to encode output: %w", err)
+	}
+	return encoder.Close()
+}
+
+// blockStyle drops the flow style and quoting taken over from JSON, leaving
+// strings quoted only where YAML needs it
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
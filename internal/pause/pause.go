@@ -0,0 +1,184 @@
+// Package pause keeps the maintenance pauses of scheduled backups in a file
+// shared by the scheduler, the control socket and the pause command
+package pause
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FileName is the name of the pause file within the state directory
+const FileName = "pauses.json"
+
+// Pause suspends the scheduled backups of one database, or of every database
+// when Database is empty, until a deadline
+type Pause struct {
+	Database string    `json:"database,omitempty"`
+	Until    time.Time `json:"until"`
+	Reason   string    `json:"reason,omitempty"`
+	PausedAt time.Time `json:"paused_at"`
+}
+
+// Global returns true if the pause applies to every database
+func (p Pause) Global() bool {
+	return p.Database == ""
+}
+
+// String describes the pause in one line
+func (p Pause) String() string {
+	target := p.Database
+	if p.Global() {
+		target = "all databases"
+	}
+	text := fmt.Sprintf("%s paused until %s", target, p.Until.Format(time.RFC3339))
+	if p.Reason != "" {
+		text += " (" + p.Reason + ")"
+	}
+	return text
+}
+
+// File reads and writes the pause file. The file is read on every check, so
+// pauses written by another process take effect straight away.
+type File struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Open returns the pause file at path; the file is created by the first pause
+func Open(path string) *File {
+	return &File{path: path}
+}
+
+// Path returns the location of the pause file
+func (f *File) Path() string {
+	return f.path
+}
+
+// Pause suspends the scheduled backups of database, or of every database
+// when it is empty, until the deadline, replacing any pause of the same scope
+func (f *File) Pause(database string, until time.Time, reason string) (Pause, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	if !until.After(now) {
+		return Pause{}, fmt.Errorf("pause deadline %s is in the past", until.Format(time.RFC3339))
+	}
+	pauses, err := f.read(now)
+	if err != nil {
+		return Pause{}, err
+	}
+	p := Pause{Database: database, Until: until, Reason: reason, PausedAt: now}
+	kept := []Pause{p}
+	for _, existing := range pauses {
+		if existing.Database != database {
+			kept = append(kept, existing)
+		}
+	}
+	return p, f.write(kept)
+}
+
+// Resume lifts the pause of database, or the global pause when it is empty,
+// and reports whether there was one
+func (f *File) Resume(database string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pauses, err := f.read(time.Now())
+	if err != nil {
+		return false, err
+	}
+	var kept []Pause
+	for _, p := range pauses {
+		if p.Database != database {
+			kept = append(kept, p)
+		}
+	}
+	if len(kept) == len(pauses) {
+		return false, nil
+	}
+	return true, f.write(kept)
+}
+
+// Active returns the pauses whose deadline has not passed, global pause first
+func (f *File) Active(now time.Time) ([]Pause, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.read(now)
+}
+
+// Paused returns the pause in effect for a database, either its own or the
+// global one, whichever lasts longer. An unreadable pause file pauses nothing.
+func (f *File) Paused(database string, now time.Time) (Pause, bool) {
+	pauses, err := f.Active(now)
+	if err != nil {
+		return Pause{}, false
+	}
+	return Find(pauses, database)
+}
+
+// Find returns the pause among pauses in effect for a database, either its
+// own or the global one, whichever lasts longer
+func Find(pauses []Pause, database string) (Pause, bool) {
+	var found Pause
+	ok := false
+	for _, p := range pauses {
+		if (p.Global() || p.Database == database) && (!ok || p.Until.After(found.Until)) {
+			found, ok = p, true
+		}
+	}
+	return found, ok
+}
+
+// read returns the unexpired pauses in the file
+func (f *File) read(now time.Time) ([]Pause, error) {
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pause file: %w", err)
+	}
+	var pauses []Pause
+	if err := json.Unmarshal(data, &pauses); err != nil {
+		return nil, fmt.Errorf("failed to parse pause file %s: %w", f.path, err)
+	}
+
+	active := pauses[:0]
+	for _, p := range pauses {
+		if p.Until.After(now) {
+			active = append(active, p)
+		}
+	}
+	sort.SliceStable(active, func(i, j int) bool {
+		return active[i].Database < active[j].Database
+	})
+	return active, nil
+}
+
+// write replaces the pause file atomically, dropping expired pauses
+func (f *File) write(pauses []Pause) error {
+	if pauses == nil {
+		pauses = []Pause{}
+	}
+	data, err := json.MarshalIndent(pauses, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode pause file: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return fmt.Errorf("failed to create pause file directory: %w", err)
+	}
+	tmpPath := f.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write pause file: %w", err)
+	}
+	if err := os.Rename(tmpPath, f.path); err != nil {
+		return fmt.Errorf("failed to replace pause file: %w", err)
+	}
+	return nil
+}
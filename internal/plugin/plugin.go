@@ -0,0 +1,85 @@
+// Package plugin runs external programs that add storage backends and
+// notification channels. A plugin is any executable speaking the protocol
+// below, so third parties can ship one in any language without changing
+// this service.
+//
+// The service runs the plugin once per operation, appending the operation
+// and its operands to the configured arguments:
+//
+//	test                  check that the storage can be reached
+//	list <prefix>         print a JSON array of {"key", "size", "modified"}
+//	                      for every object whose key starts with prefix
+//	put <key> <file>      store the contents of file under key
+//	get <key> <file>      write the object stored under key to file
+//	delete <key>          delete the object stored under key
+//	notify                deliver the JSON notification read from stdin
+//
+// Keys use forward slashes. A plugin exits 0 on success and 3 when the object
+// or prefix of get, delete or list does not exist; any other status is a
+// failure described by its standard error. ProtocolEnv carries the
+// protocol version so plugins can refuse one they do not speak.
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Protocol is the version of the plugin protocol this service speaks
+const Protocol = "1"
+
+// ProtocolEnv is the environment variable passing Protocol to plugins
+const ProtocolEnv = "DB_BACKUPER_PLUGIN_PROTOCOL"
+
+// ExitNotFound is the exit status of a plugin reporting a missing object
+const ExitNotFound = 3
+
+// Timeout bounds operations without a transfer, such as notifications
+const Timeout = 30 * time.Second
+
+// Run runs command with args, feeding stdin when not nil, and returns its
+// standard output
+func Run(ctx context.Context, command string, args []string, stdin io.Reader) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Env = append(os.Environ(), ProtocolEnv+"="+Protocol)
+	cmd.Stdin = stdin
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, &RunError{Command: command, Args: args, Stderr: strings.TrimSpace(stderr.String()), Err: err}
+	}
+	return stdout.Bytes(), nil
+}
+
+// RunError is a failed plugin invocation
+type RunError struct {
+	Command string
+	Args    []string
+	Stderr  string
+	Err     error
+}
+
+func (e *RunError) Error() string {
+	if e.Stderr != "" {
+		return fmt.Sprintf("plugin %s %s failed: %v: %s", e.Command, strings.Join(e.Args, " "), e.Err, e.Stderr)
+	}
+	return fmt.Sprintf("plugin %s %s failed: %v", e.Command, strings.Join(e.Args, " "), e.Err)
+}
+
+func (e *RunError) Unwrap() error {
+	return e.Err
+}
+
+// IsNotFound reports whether err is a plugin reporting a missing object
+func IsNotFound(err error) bool {
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr) && exitErr.ExitCode() == ExitNotFound
+}
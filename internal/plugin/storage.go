@@ -0,0 +1,406 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"db-backuper/internal/audit"
+	"db-backuper/internal/config"
+	"db-backuper/internal/provenance"
+	"db-backuper/internal/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Storage stores backups through a storage plugin. Provenance metadata is
+// kept in a sidecar object next to each backup, so plugins only move bytes.
+type Storage struct {
+	config   *config.StoragePluginConfig
+	logger   logrus.FieldLogger
+	auditLog *audit.Log
+
+	modTimeRetention bool
+	transferJobs     int
+}
+
+// Object is one entry of the listing a storage plugin prints
+type Object struct {
+	Key      string    `json:"key"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// NewStorage creates a backend storing backups through the configured plugin
+func NewStorage(pluginConfig *config.StoragePluginConfig, logger logrus.FieldLogger) *Storage {
+	return &Storage{
+		config: pluginConfig,
+		logger: logger,
+	}
+}
+
+// WithLogger returns a copy of the storage that logs through logger
+func (s *Storage) WithLogger(logger logrus.FieldLogger) storage.Backend {
+	return &Storage{
+		config:   s.config,
+		logger:   logger,
+		auditLog: s.auditLog,
+
+		modTimeRetention: s.modTimeRetention,
+		transferJobs:     s.transferJobs,
+	}
+}
+
+// SetAuditLog records deletions made by this storage to the audit log
+func (s *Storage) SetAuditLog(auditLog *audit.Log) {
+	s.auditLog = auditLog
+}
+
+// SetModTimeRetention ages out objects outside the prefix/database/date
+// layout by their modification time instead of keeping them forever
+func (s *Storage) SetModTimeRetention(enabled bool) {
+	s.modTimeRetention = enabled
+}
+
+// SetTransferJobs sets how many files or parts of a backup are uploaded at once
+func (s *Storage) SetTransferJobs(jobs int) {
+	s.transferJobs = jobs
+}
+
+// Location returns a human readable description of the storage target
+func (s *Storage) Location() string {
+	return "plugin:" + s.config.DisplayName()
+}
+
+// run runs one operation of the plugin and returns its output
+func (s *Storage) run(operation string, operands ...string) ([]byte, error) {
+	args := append(append(append([]string{}, s.config.Args...), operation), operands...)
+	return Run(context.Background(), s.config.Command(), args, nil)
+}
+
+// list returns every object whose key starts with prefix, in key order
+func (s *Storage) list(prefix string) ([]Object, error) {
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		prefix += "/"
+	}
+	output, err := s.run("list", prefix)
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list %s: %w", prefix, err)
+	}
+
+	var objects []Object
+	if err := json.Unmarshal(output, &objects); err != nil {
+		return nil, fmt.Errorf("failed to parse listing of %s: %w", prefix, err)
+	}
+	// Plugins may match prefixes loosely; only keys under prefix belong to it
+	filtered := objects[:0]
+	for _, obj := range objects {
+		if strings.HasPrefix(obj.Key, prefix) {
+			filtered = append(filtered, obj)
+		}
+	}
+	sort.Slice(filtered, func(i, j int) bool { return filtered[i].Key < filtered[j].Key })
+	return filtered, nil
+}
+
+// ListKeys returns the keys of every backup under prefix
+func (s *Storage) ListKeys(prefix string) ([]string, error) {
+	objects, err := s.list(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, obj := range objects {
+		if provenance.IsSidecar(obj.Key) || path.Base(obj.Key) == storage.LatestObject {
+			continue
+		}
+		keys = append(keys, obj.Key)
+	}
+	return keys, nil
+}
+
+// ListBackups returns one page of the backups selected by query, in key order
+func (s *Storage) ListBackups(query storage.ListQuery) (*storage.ListPage, error) {
+	objects, err := s.list(query.KeyPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	page := &storage.ListPage{}
+	for _, obj := range objects {
+		if query.PageToken != "" && obj.Key <= query.PageToken {
+			continue
+		}
+		if query.PastUntil(obj.Key) {
+			break
+		}
+		entry, ok := query.Match(obj.Key)
+		if !ok || provenance.IsSidecar(obj.Key) {
+			continue
+		}
+		if len(page.Entries) == query.Limit() {
+			page.NextPageToken = page.Entries[len(page.Entries)-1].Key
+			break
+		}
+		entry.Size = obj.Size
+		entry.LastModified = obj.Modified
+		page.Entries = append(page.Entries, entry)
+	}
+	return page, nil
+}
+
+// SaveBackup uploads a backup file under <prefix>/<database>/<date>/ and
+// returns its key
+func (s *Storage) SaveBackup(localFilePath, backupPrefix, databaseName string, meta *provenance.Metadata) (string, error) {
+	key := path.Join(backupPrefix, databaseName, time.Now().Format(storage.DateLayout), storage.BackupName(filepath.Base(localFilePath), meta))
+	// The files of a directory backup or the parts of a split one are uploaded before their index
+	if storage.IsIndex(localFilePath) {
+		if err := storage.UploadDirectory(s, localFilePath, key, s.transferJobs); err != nil {
+			return "", fmt.Errorf("failed to upload backup files: %w", err)
+		}
+	}
+	if err := s.UploadFile(localFilePath, key, meta); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// UploadFile stores the local file at localPath under key, uploading meta
+// to a sidecar object next to it when given
+func (s *Storage) UploadFile(localPath, key string, meta *provenance.Metadata) error {
+	if _, err := s.run("put", key, localPath); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", localPath, err)
+	}
+
+	if meta != nil {
+		data, err := json.MarshalIndent(meta, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode backup metadata: %w", err)
+		}
+		if err := s.put(key+provenance.SidecarSuffix, append(data, '\n')); err != nil {
+			return err
+		}
+	}
+
+	s.logger.Infof("Backup uploaded to %s/%s", s.Location(), key)
+	return nil
+}
+
+// put writes a small object such as a sidecar or pointer file
+func (s *Storage) put(key string, data []byte) error {
+	tmpFile, err := os.CreateTemp("", "db-backuper-plugin-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	if _, err := s.run("put", key, tmpFile.Name()); err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	return nil
+}
+
+// UpdateLatest points the database's latest.json at the backup uploaded to key
+func (s *Storage) UpdateLatest(backupPrefix, databaseName, key string, sizeBytes int64, meta *provenance.Metadata) error {
+	pointer := storage.LatestPointer{
+		Database:  databaseName,
+		Key:       key,
+		SizeBytes: sizeBytes,
+		UpdatedAt: time.Now().UTC(),
+	}
+	if meta != nil {
+		pointer.SHA256 = meta.SHA256
+	}
+	data, err := json.MarshalIndent(pointer, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode latest pointer: %w", err)
+	}
+
+	latestKey := storage.LatestKey(backupPrefix, databaseName)
+	if err := s.put(latestKey, append(data, '\n')); err != nil {
+		return err
+	}
+	s.logger.Infof("Updated %s to point at %s", latestKey, key)
+	return nil
+}
+
+// Download writes the backup stored under key to destPath
+func (s *Storage) Download(key, destPath string) error {
+	tmpPath := destPath + ".part"
+	if _, err := s.run("get", key, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		return fmt.Errorf("failed to move download into place: %w", err)
+	}
+
+	s.logger.Infof("Downloaded %s to %s", key, destPath)
+	return nil
+}
+
+// Metadata returns the provenance recorded in the sidecar of the backup
+// stored under key, or nil when it has none
+func (s *Storage) Metadata(key string) (*provenance.Metadata, error) {
+	tmpFile, err := os.CreateTemp("", "db-backuper-plugin-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := s.run("get", key+provenance.SidecarSuffix, tmpFile.Name()); err != nil {
+		if IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read backup metadata: %w", err)
+	}
+	data, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup metadata: %w", err)
+	}
+
+	var meta provenance.Metadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse backup metadata of %s: %w", key, err)
+	}
+	return &meta, nil
+}
+
+// DeleteBackups deletes the given keys and their sidecars and returns the keys that were deleted
+func (s *Storage) DeleteBackups(keys []string) ([]string, error) {
+	requested := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		requested[key] = true
+	}
+	var deleted []string
+	for _, key := range keys {
+		if index, ok := storage.IndexOf(key); ok && requested[index] {
+			// Deleted with its index
+			deleted = append(deleted, key)
+			continue
+		}
+		if _, err := s.run("delete", key); err != nil && !IsNotFound(err) {
+			return deleted, fmt.Errorf("failed to delete %s: %w", key, err)
+		}
+		if _, err := s.run("delete", key+provenance.SidecarSuffix); err != nil && !IsNotFound(err) {
+			s.logger.Warnf("Failed to delete metadata of %s: %v", key, err)
+		}
+		if storage.IsIndex(key) {
+			if err := s.deleteFiles(key); err != nil {
+				s.logger.Warnf("Failed to delete files of %s: %v", key, err)
+			}
+		}
+		deleted = append(deleted, key)
+	}
+
+	s.logger.Infof("Deleted %d backup files", len(deleted))
+	return deleted, nil
+}
+
+// deleteFiles deletes the files or parts stored next to the index under key
+func (s *Storage) deleteFiles(key string) error {
+	objects, err := s.list(storage.DirectoryPath(key))
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if _, err := s.run("delete", obj.Key); err != nil && !IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteOldBackups deletes backups dated before the retention period and
+// returns the number of objects deleted
+func (s *Storage) DeleteOldBackups(backupPrefix string, retentionDays int) (int, error) {
+	cutoffDate := time.Now().AddDate(0, 0, -retentionDays)
+	s.logger.Infof("Deleting backups older than %d days (before %s)", retentionDays, cutoffDate.Format(storage.DateLayout))
+
+	held, err := storage.HeldKeys(s, backupPrefix)
+	if err != nil {
+		return 0, err
+	}
+	objects, err := s.list(backupPrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	var keys []string
+	for _, obj := range objects {
+		if provenance.IsSidecar(obj.Key) {
+			continue
+		}
+		if storage.IsHeld(held, obj.Key) {
+			s.logger.Infof("Keeping held backup: %s", obj.Key)
+			continue
+		}
+		_, date, ok := storage.ParseKey(backupPrefix, obj.Key)
+		if !ok {
+			_, date, ok = storage.ParseChangeKey(backupPrefix, obj.Key)
+		}
+		if index, isFile := storage.IndexOf(obj.Key); !ok && isFile {
+			// The files or parts of a backup are dated by its index
+			_, date, ok = storage.ParseKey(backupPrefix, index)
+		}
+		if ok {
+			if date.Before(cutoffDate) {
+				s.logger.Infof("Marking for deletion: %s (date: %s)", obj.Key, date.Format(storage.DateLayout))
+				keys = append(keys, obj.Key)
+			}
+			continue
+		}
+
+		// Renamed or legacy objects carry no date; age them by modification time if enabled
+		if s.modTimeRetention && !storage.IsReserved(backupPrefix, obj.Key) && path.Base(obj.Key) != storage.LatestObject &&
+			obj.Modified.Before(cutoffDate) {
+			s.logger.Infof("Marking for deletion: %s (last modified: %s)", obj.Key, obj.Modified.Format(storage.DateLayout))
+			keys = append(keys, obj.Key)
+		}
+	}
+
+	if len(keys) == 0 {
+		s.logger.Info("No old backups found to delete")
+		return 0, nil
+	}
+
+	deleted, err := s.DeleteBackups(keys)
+	if len(deleted) > 0 {
+		if auditErr := s.auditLog.Record(audit.Event{
+			Action:  audit.ActionRetentionDelete,
+			Storage: s.Location(),
+			Targets: deleted,
+			Details: map[string]string{"retention_days": strconv.Itoa(retentionDays)},
+		}); auditErr != nil {
+			s.logger.Errorf("Failed to record retention deletions in audit log: %v", auditErr)
+		}
+	}
+	return len(deleted), err
+}
+
+// TestConnection checks that the plugin runs and can reach its storage
+func (s *Storage) TestConnection() error {
+	if _, err := s.run("test"); err != nil {
+		return err
+	}
+	s.logger.Info("Storage plugin connection test successful")
+	return nil
+}
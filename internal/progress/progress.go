@@ -0,0 +1,178 @@
+package progress
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultInterval is how often file progress is logged
+const DefaultInterval = 30 * time.Second
+
+// Ticker calls a function periodically until it is stopped
+type Ticker struct {
+	startTime time.Time
+	done      chan struct{}
+	stopOnce  sync.Once
+	wg        sync.WaitGroup
+}
+
+// StartTicker calls fn with the elapsed time on every interval until Stop is called
+func StartTicker(interval time.Duration, fn func(elapsed time.Duration)) *Ticker {
+	t := &Ticker{
+		startTime: time.Now(),
+		done:      make(chan struct{}),
+	}
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-t.done:
+				return
+			case <-ticker.C:
+				fn(time.Since(t.startTime))
+			}
+		}
+	}()
+	return t
+}
+
+// Stop stops the ticker; it is safe to call more than once
+func (t *Ticker) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.done)
+		t.wg.Wait()
+	})
+}
+
+// Elapsed returns the time since the ticker was started
+func (t *Ticker) Elapsed() time.Duration {
+	return time.Since(t.startTime)
+}
+
+// FileReporter periodically logs the size and throughput of a growing file
+type FileReporter struct {
+	path   string
+	label  string
+	logger logrus.FieldLogger
+	ticker *Ticker
+}
+
+// StartFileReporter starts logging the progress of the file at path until Stop is called
+func StartFileReporter(path, label string, interval time.Duration, logger logrus.FieldLogger) *FileReporter {
+	r := &FileReporter{
+		path:   path,
+		label:  label,
+		logger: logger,
+	}
+	r.ticker = StartTicker(interval, func(elapsed time.Duration) {
+		r.report("in progress", elapsed)
+	})
+	return r
+}
+
+// Stop stops the reporter; it is safe to call more than once
+func (r *FileReporter) Stop() {
+	r.ticker.Stop()
+}
+
+// Finish stops the reporter and logs the final size and throughput
+func (r *FileReporter) Finish() {
+	r.ticker.Stop()
+	r.report("completed", r.ticker.Elapsed())
+}
+
+// report logs the current file size and average throughput
+func (r *FileReporter) report(state string, elapsed time.Duration) {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		r.logger.Debugf("Failed to stat %s for progress: %v", r.path, err)
+		return
+	}
+
+	r.logger.Infof("%s %s: %s written in %v (%s/s)",
+		r.label, state, FormatBytes(info.Size()), elapsed.Round(time.Second), FormatBytes(throughput(info.Size(), elapsed)))
+}
+
+// StreamLines logs each line read from reader as it arrives and returns the last maxTail lines
+func StreamLines(reader io.Reader, logger logrus.FieldLogger, prefix string, maxTail int) []string {
+	return StreamLinesFunc(reader, logger, prefix, maxTail, nil)
+}
+
+// StreamLinesFunc is StreamLines that also passes every line to handle
+func StreamLinesFunc(reader io.Reader, logger logrus.FieldLogger, prefix string, maxTail int, handle func(string)) []string {
+	var tail []string
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		logger.Infof("%s: %s", prefix, line)
+		if handle != nil {
+			handle(line)
+		}
+
+		tail = append(tail, line)
+		if len(tail) > maxTail {
+			tail = tail[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logger.Warnf("%s: failed to read output: %v", prefix, err)
+	}
+	return tail
+}
+
+// FormatBytes formats a byte count using binary units
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// throughput returns the average bytes per second over elapsed
+func throughput(size int64, elapsed time.Duration) int64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(size) / elapsed.Seconds())
+}
+
+// RunStreaming runs a command, streaming its combined output through the
+// logger and keeping the last lines for the error message
+func RunStreaming(cmd *exec.Cmd, logger logrus.FieldLogger, prefix string) error {
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to capture %s output: %w", prefix, err)
+	}
+	cmd.Stderr = cmd.Stdout
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", prefix, err)
+	}
+
+	tail := StreamLines(stdout, logger, prefix, 20)
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%s failed: %w\nOutput: %s", prefix, err, strings.Join(tail, "\n"))
+	}
+	return nil
+}
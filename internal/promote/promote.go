@@ -0,0 +1,153 @@
+// Package promote copies backups to another prefix, bucket or backend, such
+// as a verified nightly backup into a long-term archive, along with their
+// provenance and restore points
+package promote
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"db-backuper/internal/catalog"
+	"db-backuper/internal/s3"
+	"db-backuper/internal/storage"
+)
+
+// Target is a storage backend and the backup prefix a backup is copied from or to
+type Target struct {
+	Backend storage.Backend
+	Prefix  string
+}
+
+// Promote copies the backup stored under srcKey in from to the same
+// database/date/file layout under the prefix of to, and returns the key of
+// the copy. The restore points naming the backup in the catalog of from are
+// recorded for the copy in the catalog of to.
+func Promote(from, to Target, srcKey string, jobs int) (string, error) {
+	destKey := Key(srcKey, from.Prefix, to.Prefix)
+	if to.Backend.Location() == from.Backend.Location() && destKey == srcKey {
+		return "", fmt.Errorf("source and destination are the same: %s", srcKey)
+	}
+
+	if err := Copy(from.Backend, to.Backend, srcKey, destKey, jobs); err != nil {
+		return "", err
+	}
+	if err := tagCopy(from, to, srcKey, destKey); err != nil {
+		return destKey, fmt.Errorf("copied %s to %s, but failed to update the catalog: %w", srcKey, destKey, err)
+	}
+	return destKey, nil
+}
+
+// tagCopy records the restore points of srcKey in from for destKey in to.
+// A point of the same name already naming the copy is left as it is.
+func tagCopy(from, to Target, srcKey, destKey string) error {
+	if to.Backend.Location() == from.Backend.Location() && to.Prefix == from.Prefix {
+		// Restore point names are unique within a catalog
+		return nil
+	}
+	points, err := catalog.New(from.Backend, from.Prefix).RestorePoints()
+	if err != nil {
+		return err
+	}
+	destCatalog := catalog.New(to.Backend, to.Prefix)
+	for _, point := range points {
+		if point.Key != srcKey {
+			continue
+		}
+		if existing, err := destCatalog.Get(point.Name); err == nil && existing.Key == destKey {
+			continue
+		}
+		copied := catalog.RestorePoint{Name: point.Name, Database: point.Database, Key: destKey, Note: point.Note}
+		if err := destCatalog.Tag(copied, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Key replaces the source backup prefix of key with destPrefix, keeping the
+// database/date/file layout below it
+func Key(key, srcPrefix, destPrefix string) string {
+	rest := key
+	if srcPrefix != "" && strings.HasPrefix(key, srcPrefix+"/") {
+		rest = strings.TrimPrefix(key, srcPrefix+"/")
+	}
+	return path.Join(destPrefix, rest)
+}
+
+// Copy copies srcKey from source to destKey in dest, server-side where
+// possible. The files of a directory backup and the parts of a split one are
+// copied jobs at a time.
+func Copy(source, dest storage.Backend, srcKey, destKey string, jobs int) error {
+	if storage.IsIndex(srcKey) {
+		return copyDirectoryBackup(source, dest, srcKey, destKey, jobs)
+	}
+	srcS3, srcIsS3 := source.(*s3.S3Manager)
+	destS3, destIsS3 := dest.(*s3.S3Manager)
+	if srcIsS3 && destIsS3 {
+		return destS3.CopyFrom(srcS3, srcKey, destKey)
+	}
+
+	tmpFile, err := os.CreateTemp("", "db-backuper-copy-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	tmpFile.Close()
+	defer os.Remove(tmpPath)
+
+	// Carry the backup's provenance over to the copy
+	meta, err := source.Metadata(srcKey)
+	if err != nil {
+		return err
+	}
+	if err := source.Download(srcKey, tmpPath); err != nil {
+		return err
+	}
+	return dest.UploadFile(tmpPath, destKey, meta)
+}
+
+// copyDirectoryBackup copies the files or parts of a backup, jobs at a time,
+// and then its index, so the copy is only listed once it is complete
+func copyDirectoryBackup(source, dest storage.Backend, srcKey, destKey string, jobs int) error {
+	workDir, err := os.MkdirTemp("", "db-backuper-copy-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	srcS3, srcIsS3 := source.(*s3.S3Manager)
+	destS3, destIsS3 := dest.(*s3.S3Manager)
+	indexPath := filepath.Join(workDir, path.Base(srcKey))
+	if srcIsS3 && destIsS3 {
+		if err := source.Download(srcKey, indexPath); err != nil {
+			return err
+		}
+		index, err := storage.ReadDirectoryIndex(indexPath)
+		if err != nil {
+			return err
+		}
+		srcDir, destDir := storage.DirectoryPath(srcKey), storage.DirectoryPath(destKey)
+		err = storage.EachDirectoryFile(index, jobs, func(file storage.DirectoryFile) error {
+			return destS3.CopyFrom(srcS3, path.Join(srcDir, file.Name), path.Join(destDir, file.Name))
+		})
+		if err != nil {
+			return err
+		}
+		return destS3.CopyFrom(srcS3, srcKey, destKey)
+	}
+
+	meta, err := source.Metadata(srcKey)
+	if err != nil {
+		return err
+	}
+	if err := storage.FetchFiles(source, srcKey, indexPath, jobs); err != nil {
+		return err
+	}
+	if err := storage.UploadDirectory(dest, indexPath, destKey, jobs); err != nil {
+		return err
+	}
+	return dest.UploadFile(indexPath, destKey, meta)
+}
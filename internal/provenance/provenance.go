@@ -0,0 +1,270 @@
+package provenance
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ToolVersion is the db-backuper version recorded with each backup. Release
+// builds set it with -ldflags "-X db-backuper/internal/provenance.ToolVersion=<version>".
+var ToolVersion = ""
+
+// SidecarSuffix is appended to a locally stored backup's path to name the
+// file holding its metadata
+const SidecarSuffix = ".meta.json"
+
+// Compression values recorded for a backup
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+)
+
+// Metadata keys used for S3 object metadata
+const (
+	keySourceHost    = "source-host"
+	keyDatabase      = "database"
+	keyEngine        = "engine"
+	keyServerVersion = "server-version"
+	keyToolVersion   = "tool-version"
+	keyChecksum      = "sha256"
+	keyCompression   = "compression"
+	keyEncrypted     = "encrypted"
+	keyCreatedAt     = "created-at"
+	keyRunID         = "run-id"
+	keyInstance      = "instance"
+)
+
+// Metadata describes where a backup came from and how it was written, so a
+// backup found without any other context can still be identified and checked
+type Metadata struct {
+	SourceHost    string    `json:"source_host,omitempty"`
+	Database      string    `json:"database"`
+	Engine        string    `json:"engine,omitempty"`
+	ServerVersion string    `json:"server_version,omitempty"`
+	ToolVersion   string    `json:"tool_version"`
+	SHA256        string    `json:"sha256"`
+	Compression   string    `json:"compression"`
+	Encrypted     bool      `json:"encrypted"`
+	CreatedAt     time.Time `json:"created_at"`
+	// RunID is the ULID of the run that took the backup, also part of its key
+	RunID string `json:"run_id,omitempty"`
+	// Instance names the deployment that took the backup
+	Instance string `json:"instance,omitempty"`
+	// Tables is the manifest of the tables in the backup. It is too large for
+	// S3 object metadata, so S3 keeps it in a sidecar object like other storage.
+	Tables []TableStats `json:"tables,omitempty"`
+}
+
+// TableStats describes a table as it was when the backup was taken
+type TableStats struct {
+	// Name is the schema-qualified table name, such as public.orders
+	Name string `json:"name"`
+	// Rows is the exact row count, recorded by SQL backups which count every
+	// table they dump
+	Rows *int64 `json:"rows,omitempty"`
+	// EstimatedRows is the planner's estimate from pg_class
+	EstimatedRows int64 `json:"estimated_rows"`
+	// SizeBytes is the size of the table with its indexes and TOAST data
+	SizeBytes int64 `json:"size_bytes"`
+}
+
+// Version returns the version of this build of db-backuper
+func Version() string {
+	if ToolVersion != "" {
+		return ToolVersion
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// Describe records the checksum and compression of the backup file at path
+// along with the tool version; the caller fills in the source details
+func Describe(path string) (*Metadata, error) {
+	checksum, err := Checksum(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Metadata{
+		ToolVersion: Version(),
+		SHA256:      checksum,
+		Compression: compressionOf(path),
+		CreatedAt:   time.Now().UTC(),
+	}, nil
+}
+
+// Checksum returns the hex encoded SHA-256 of the file at path
+func Checksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to checksum %s: %w", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// compressionOf derives the compression of a backup from its file name
+func compressionOf(path string) string {
+	if strings.HasSuffix(path, ".gz") {
+		return CompressionGzip
+	}
+	return CompressionNone
+}
+
+// Headers returns the metadata as S3 user metadata
+func (m *Metadata) Headers() map[string]string {
+	headers := map[string]string{
+		keyDatabase:    m.Database,
+		keyToolVersion: m.ToolVersion,
+		keyChecksum:    m.SHA256,
+		keyCompression: m.Compression,
+		keyEncrypted:   strconv.FormatBool(m.Encrypted),
+		keyCreatedAt:   m.CreatedAt.Format(time.RFC3339),
+	}
+	if m.SourceHost != "" {
+		headers[keySourceHost] = m.SourceHost
+	}
+	if m.Engine != "" {
+		headers[keyEngine] = m.Engine
+	}
+	if m.ServerVersion != "" {
+		headers[keyServerVersion] = m.ServerVersion
+	}
+	if m.RunID != "" {
+		headers[keyRunID] = m.RunID
+	}
+	if m.Instance != "" {
+		headers[keyInstance] = m.Instance
+	}
+	return headers
+}
+
+// FromHeaders reads metadata back from S3 user metadata. S3 returns the keys
+// in canonical header form, so they are matched case-insensitively. It
+// returns nil when the object carries no backup metadata.
+func FromHeaders(headers map[string]string) *Metadata {
+	values := make(map[string]string, len(headers))
+	for key, value := range headers {
+		values[strings.ToLower(key)] = value
+	}
+	if values[keyChecksum] == "" && values[keyToolVersion] == "" {
+		return nil
+	}
+
+	m := &Metadata{
+		SourceHost:    values[keySourceHost],
+		Database:      values[keyDatabase],
+		Engine:        values[keyEngine],
+		ServerVersion: values[keyServerVersion],
+		ToolVersion:   values[keyToolVersion],
+		SHA256:        values[keyChecksum],
+		Compression:   values[keyCompression],
+		RunID:         values[keyRunID],
+		Instance:      values[keyInstance],
+	}
+	m.Encrypted, _ = strconv.ParseBool(values[keyEncrypted])
+	m.CreatedAt, _ = time.Parse(time.RFC3339, values[keyCreatedAt])
+	return m
+}
+
+// WriteSidecar saves the metadata next to the backup stored at backupPath
+func (m *Metadata) WriteSidecar(backupPath string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode backup metadata: %w", err)
+	}
+
+	// Written under a hidden temporary name, which local storage leaves out
+	// of listings, and renamed so a crash never leaves a truncated sidecar
+	sidecarPath := backupPath + SidecarSuffix
+	tmpPath := filepath.Join(filepath.Dir(sidecarPath), "."+filepath.Base(sidecarPath)+".part")
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to write backup metadata: %w", err)
+	}
+	if _, err := file.Write(append(data, '\n')); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, sidecarPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write backup metadata: %w", err)
+	}
+	return nil
+}
+
+// ReadSidecar loads the metadata saved next to the backup stored at
+// backupPath. It returns nil when the backup has no sidecar.
+func ReadSidecar(backupPath string) (*Metadata, error) {
+	data, err := os.ReadFile(backupPath + SidecarSuffix)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup metadata: %w", err)
+	}
+
+	var m Metadata
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse backup metadata %s: %w", backupPath+SidecarSuffix, err)
+	}
+	return &m, nil
+}
+
+// IsSidecar reports whether key names a metadata sidecar rather than a backup
+func IsSidecar(key string) bool {
+	return strings.HasSuffix(key, SidecarSuffix)
+}
+
+// Summary returns a one line description of the backup's provenance
+func (m *Metadata) Summary() string {
+	parts := []string{"database=" + m.Database}
+	if m.Engine != "" {
+		parts = append(parts, "engine="+m.Engine)
+	}
+	if m.SourceHost != "" {
+		parts = append(parts, "host="+m.SourceHost)
+	}
+	if m.ServerVersion != "" {
+		parts = append(parts, "server_version="+m.ServerVersion)
+	}
+	parts = append(parts,
+		"tool_version="+m.ToolVersion,
+		"compression="+m.Compression,
+		"encrypted="+strconv.FormatBool(m.Encrypted),
+		"sha256="+m.SHA256,
+	)
+	if !m.CreatedAt.IsZero() {
+		parts = append(parts, "created="+m.CreatedAt.Format(time.RFC3339))
+	}
+	if m.RunID != "" {
+		parts = append(parts, "run_id="+m.RunID)
+	}
+	if m.Instance != "" {
+		parts = append(parts, "instance="+m.Instance)
+	}
+	if len(m.Tables) > 0 {
+		parts = append(parts, "tables="+strconv.Itoa(len(m.Tables)))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,429 @@
+package rclone
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"db-backuper/internal/audit"
+	"db-backuper/internal/config"
+	"db-backuper/internal/provenance"
+	"db-backuper/internal/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// rclone exit codes for missing directories and files
+const (
+	exitDirNotFound  = 3
+	exitFileNotFound = 4
+)
+
+// Remote stores backups on an rclone remote by running the rclone binary, so
+// any provider rclone supports can hold backups without a native backend.
+// Provenance metadata is kept in a sidecar object next to each backup, since
+// not every provider supports object metadata.
+type Remote struct {
+	config   *config.RcloneConfig
+	logger   logrus.FieldLogger
+	auditLog *audit.Log
+
+	modTimeRetention bool
+	transferJobs     int
+}
+
+// object is one entry of rclone lsjson output
+type object struct {
+	Path    string    `json:"Path"`
+	Size    int64     `json:"Size"`
+	ModTime time.Time `json:"ModTime"`
+	IsDir   bool      `json:"IsDir"`
+}
+
+// NewRemote creates a backend storing backups on the configured rclone remote
+func NewRemote(rcloneConfig *config.RcloneConfig, logger logrus.FieldLogger) *Remote {
+	return &Remote{
+		config: rcloneConfig,
+		logger: logger,
+	}
+}
+
+// WithLogger returns a copy of the remote that logs through logger
+func (r *Remote) WithLogger(logger logrus.FieldLogger) storage.Backend {
+	return &Remote{
+		config:   r.config,
+		logger:   logger,
+		auditLog: r.auditLog,
+
+		modTimeRetention: r.modTimeRetention,
+		transferJobs:     r.transferJobs,
+	}
+}
+
+// SetAuditLog records deletions made by this remote to the audit log
+func (r *Remote) SetAuditLog(auditLog *audit.Log) {
+	r.auditLog = auditLog
+}
+
+// SetModTimeRetention ages out objects outside the prefix/database/date
+// layout by their modification time instead of keeping them forever
+func (r *Remote) SetModTimeRetention(enabled bool) {
+	r.modTimeRetention = enabled
+}
+
+// SetTransferJobs sets how many files or parts of a backup are uploaded at once
+func (r *Remote) SetTransferJobs(jobs int) {
+	r.transferJobs = jobs
+}
+
+// Location returns a human readable description of the storage target
+func (r *Remote) Location() string {
+	return "rclone:" + r.config.Remote
+}
+
+// path returns the rclone path of key on the remote
+func (r *Remote) path(key string) string {
+	remote := r.config.Remote
+	if key == "" || strings.HasSuffix(remote, ":") || strings.HasSuffix(remote, "/") {
+		return remote + key
+	}
+	return remote + "/" + key
+}
+
+// run runs rclone with the configured flags followed by args and returns its output
+func (r *Remote) run(args ...string) ([]byte, error) {
+	cmd := exec.Command(r.config.Command(), append(append([]string{}, r.config.Flags...), args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, &runError{args: args, stderr: strings.TrimSpace(stderr.String()), err: err}
+	}
+	return stdout.Bytes(), nil
+}
+
+// runError is a failed rclone invocation
+type runError struct {
+	args   []string
+	stderr string
+	err    error
+}
+
+func (e *runError) Error() string {
+	if e.stderr != "" {
+		return fmt.Sprintf("rclone %s failed: %v: %s", strings.Join(e.args, " "), e.err, e.stderr)
+	}
+	return fmt.Sprintf("rclone %s failed: %v", strings.Join(e.args, " "), e.err)
+}
+
+func (e *runError) Unwrap() error {
+	return e.err
+}
+
+// isNotFound reports whether err is rclone reporting a missing directory or file
+func isNotFound(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	return exitErr.ExitCode() == exitDirNotFound || exitErr.ExitCode() == exitFileNotFound
+}
+
+// list returns every file under prefix, with keys relative to the remote
+func (r *Remote) list(prefix string) ([]object, error) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	output, err := r.run("lsjson", "--recursive", "--files-only", r.path(prefix))
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list %s: %w", r.path(prefix), err)
+	}
+
+	var objects []object
+	if err := json.Unmarshal(output, &objects); err != nil {
+		return nil, fmt.Errorf("failed to parse listing of %s: %w", r.path(prefix), err)
+	}
+	for i := range objects {
+		objects[i].Path = path.Join(prefix, objects[i].Path)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Path < objects[j].Path })
+	return objects, nil
+}
+
+// ListKeys returns the keys of every backup under prefix
+func (r *Remote) ListKeys(prefix string) ([]string, error) {
+	objects, err := r.list(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, obj := range objects {
+		if provenance.IsSidecar(obj.Path) || path.Base(obj.Path) == storage.LatestObject {
+			continue
+		}
+		keys = append(keys, obj.Path)
+	}
+	return keys, nil
+}
+
+// ListBackups returns one page of the backups selected by query, in key order
+func (r *Remote) ListBackups(query storage.ListQuery) (*storage.ListPage, error) {
+	objects, err := r.list(query.KeyPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	page := &storage.ListPage{}
+	for _, obj := range objects {
+		if query.PageToken != "" && obj.Path <= query.PageToken {
+			continue
+		}
+		if query.PastUntil(obj.Path) {
+			break
+		}
+		entry, ok := query.Match(obj.Path)
+		if !ok || provenance.IsSidecar(obj.Path) {
+			continue
+		}
+		if len(page.Entries) == query.Limit() {
+			page.NextPageToken = page.Entries[len(page.Entries)-1].Key
+			break
+		}
+		entry.Size = obj.Size
+		entry.LastModified = obj.ModTime
+		page.Entries = append(page.Entries, entry)
+	}
+	return page, nil
+}
+
+// SaveBackup uploads a backup file under <prefix>/<database>/<date>/ and
+// returns its key
+func (r *Remote) SaveBackup(localFilePath, backupPrefix, databaseName string, meta *provenance.Metadata) (string, error) {
+	key := path.Join(backupPrefix, databaseName, time.Now().Format(storage.DateLayout), storage.BackupName(filepath.Base(localFilePath), meta))
+	// The files of a directory backup or the parts of a split one are uploaded before their index
+	if storage.IsIndex(localFilePath) {
+		if err := storage.UploadDirectory(r, localFilePath, key, r.transferJobs); err != nil {
+			return "", fmt.Errorf("failed to upload backup files: %w", err)
+		}
+	}
+	if err := r.UploadFile(localFilePath, key, meta); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// UploadFile copies the local file at localPath to key on the remote,
+// uploading meta to a sidecar object next to it when given
+func (r *Remote) UploadFile(localPath, key string, meta *provenance.Metadata) error {
+	if _, err := r.run("copyto", localPath, r.path(key)); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", localPath, err)
+	}
+
+	if meta != nil {
+		data, err := json.MarshalIndent(meta, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode backup metadata: %w", err)
+		}
+		if err := r.put(key+provenance.SidecarSuffix, append(data, '\n')); err != nil {
+			return err
+		}
+	}
+
+	r.logger.Infof("Backup uploaded to %s", r.path(key))
+	return nil
+}
+
+// put writes a small object such as a sidecar or pointer file to the remote
+func (r *Remote) put(key string, data []byte) error {
+	tmpFile, err := os.CreateTemp("", "db-backuper-rclone-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	if _, err := r.run("copyto", tmpFile.Name(), r.path(key)); err != nil {
+		return fmt.Errorf("failed to write %s: %w", r.path(key), err)
+	}
+	return nil
+}
+
+// UpdateLatest points the database's latest.json at the backup uploaded to key
+func (r *Remote) UpdateLatest(backupPrefix, databaseName, key string, sizeBytes int64, meta *provenance.Metadata) error {
+	pointer := storage.LatestPointer{
+		Database:  databaseName,
+		Key:       key,
+		SizeBytes: sizeBytes,
+		UpdatedAt: time.Now().UTC(),
+	}
+	if meta != nil {
+		pointer.SHA256 = meta.SHA256
+	}
+	data, err := json.MarshalIndent(pointer, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode latest pointer: %w", err)
+	}
+
+	latestKey := storage.LatestKey(backupPrefix, databaseName)
+	if err := r.put(latestKey, append(data, '\n')); err != nil {
+		return err
+	}
+	r.logger.Infof("Updated %s to point at %s", r.path(latestKey), key)
+	return nil
+}
+
+// Download copies the backup stored under key to destPath
+func (r *Remote) Download(key, destPath string) error {
+	tmpPath := destPath + ".part"
+	if _, err := r.run("copyto", r.path(key), tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to download %s: %w", r.path(key), err)
+	}
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		return fmt.Errorf("failed to move download into place: %w", err)
+	}
+
+	r.logger.Infof("Downloaded %s to %s", r.path(key), destPath)
+	return nil
+}
+
+// Metadata returns the provenance recorded in the sidecar of the backup
+// stored under key, or nil when it has none
+func (r *Remote) Metadata(key string) (*provenance.Metadata, error) {
+	output, err := r.run("cat", r.path(key+provenance.SidecarSuffix))
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read backup metadata: %w", err)
+	}
+
+	var meta provenance.Metadata
+	if err := json.Unmarshal(output, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse backup metadata of %s: %w", key, err)
+	}
+	return &meta, nil
+}
+
+// DeleteBackups deletes the given keys and their sidecars and returns the keys that were deleted
+func (r *Remote) DeleteBackups(keys []string) ([]string, error) {
+	requested := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		requested[key] = true
+	}
+	var deleted []string
+	for _, key := range keys {
+		if index, ok := storage.IndexOf(key); ok && requested[index] {
+			// Purged with its index
+			deleted = append(deleted, key)
+			continue
+		}
+		if _, err := r.run("deletefile", r.path(key)); err != nil {
+			return deleted, fmt.Errorf("failed to delete %s: %w", r.path(key), err)
+		}
+		if _, err := r.run("deletefile", r.path(key+provenance.SidecarSuffix)); err != nil && !isNotFound(err) {
+			r.logger.Warnf("Failed to delete metadata of %s: %v", r.path(key), err)
+		}
+		if storage.IsIndex(key) {
+			if _, err := r.run("purge", r.path(storage.DirectoryPath(key))); err != nil && !isNotFound(err) {
+				r.logger.Warnf("Failed to delete files of %s: %v", r.path(key), err)
+			}
+		}
+		deleted = append(deleted, key)
+	}
+
+	r.logger.Infof("Deleted %d backup files", len(deleted))
+	return deleted, nil
+}
+
+// DeleteOldBackups deletes backups dated before the retention period and
+// returns the number of objects deleted
+func (r *Remote) DeleteOldBackups(backupPrefix string, retentionDays int) (int, error) {
+	cutoffDate := time.Now().AddDate(0, 0, -retentionDays)
+	r.logger.Infof("Deleting backups older than %d days (before %s)", retentionDays, cutoffDate.Format(storage.DateLayout))
+
+	held, err := storage.HeldKeys(r, backupPrefix)
+	if err != nil {
+		return 0, err
+	}
+	objects, err := r.list(backupPrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	var keys []string
+	for _, obj := range objects {
+		if provenance.IsSidecar(obj.Path) {
+			continue
+		}
+		if storage.IsHeld(held, obj.Path) {
+			r.logger.Infof("Keeping held backup: %s", obj.Path)
+			continue
+		}
+		_, date, ok := storage.ParseKey(backupPrefix, obj.Path)
+		if !ok {
+			_, date, ok = storage.ParseChangeKey(backupPrefix, obj.Path)
+		}
+		if index, isFile := storage.IndexOf(obj.Path); !ok && isFile {
+			// The files or parts of a backup are dated by its index
+			_, date, ok = storage.ParseKey(backupPrefix, index)
+		}
+		if ok {
+			if date.Before(cutoffDate) {
+				r.logger.Infof("Marking for deletion: %s (date: %s)", obj.Path, date.Format(storage.DateLayout))
+				keys = append(keys, obj.Path)
+			}
+			continue
+		}
+
+		// Renamed or legacy objects carry no date; age them by modification time if enabled
+		if r.modTimeRetention && !storage.IsReserved(backupPrefix, obj.Path) && path.Base(obj.Path) != storage.LatestObject &&
+			obj.ModTime.Before(cutoffDate) {
+			r.logger.Infof("Marking for deletion: %s (last modified: %s)", obj.Path, obj.ModTime.Format(storage.DateLayout))
+			keys = append(keys, obj.Path)
+		}
+	}
+
+	if len(keys) == 0 {
+		r.logger.Info("No old backups found to delete")
+		return 0, nil
+	}
+
+	deleted, err := r.DeleteBackups(keys)
+	if len(deleted) > 0 {
+		if auditErr := r.auditLog.Record(audit.Event{
+			Action:  audit.ActionRetentionDelete,
+			Storage: r.Location(),
+			Targets: deleted,
+			Details: map[string]string{"retention_days": strconv.Itoa(retentionDays)},
+		}); auditErr != nil {
+			r.logger.Errorf("Failed to record retention deletions in audit log: %v", auditErr)
+		}
+	}
+	return len(deleted), err
+}
+
+// TestConnection checks that rclone runs and the remote can be listed
+func (r *Remote) TestConnection() error {
+	if _, err := r.run("lsjson", "--max-depth", "1", r.path("")); err != nil && !isNotFound(err) {
+		return err
+	}
+	r.logger.Info("rclone remote connection test successful")
+	return nil
+}
@@ -0,0 +1,43 @@
+package rdsauth
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"db-backuper/internal/compliance"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/rds/rdsutils"
+)
+
+// Token returns an IAM authentication token to use as the password of user on
+// the RDS instance at host:port. Tokens are valid for 15 minutes, so one is
+// generated for every connection. An empty region falls back to the AWS
+// environment and shared configuration.
+func Token(host string, port int, user, region string) (string, error) {
+	options := session.Options{SharedConfigState: session.SharedConfigEnable}
+	if region != "" {
+		options.Config.Region = aws.String(region)
+	}
+	if client := compliance.HTTPClient(); client != nil {
+		options.Config.HTTPClient = client
+	}
+
+	sess, err := session.NewSessionWithOptions(options)
+	if err != nil {
+		return "", fmt.Errorf("failed to create AWS session: %w", err)
+	}
+	region = aws.StringValue(sess.Config.Region)
+	if region == "" {
+		return "", fmt.Errorf("no AWS region configured for IAM authentication to %s", host)
+	}
+
+	endpoint := net.JoinHostPort(host, strconv.Itoa(port))
+	token, err := rdsutils.BuildAuthToken(endpoint, region, user, sess.Config.Credentials)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate IAM authentication token for %s: %w", endpoint, err)
+	}
+	return token, nil
+}
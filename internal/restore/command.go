@@ -0,0 +1,124 @@
+package restore
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"db-backuper/internal/audit"
+	"db-backuper/internal/backup"
+	"db-backuper/internal/config"
+	"db-backuper/internal/progress"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CommandRestore restores backups of the command engine with the user's restore template
+type CommandRestore struct {
+	config   *config.DatabaseConfig
+	logger   logrus.FieldLogger
+	auditLog *audit.Log
+}
+
+// NewCommandRestore creates a new command restore instance
+func NewCommandRestore(dbConfig *config.DatabaseConfig, logger logrus.FieldLogger) *CommandRestore {
+	return &CommandRestore{
+		config: dbConfig,
+		logger: logger,
+	}
+}
+
+// SetAuditLog records restores to the audit log
+func (cr *CommandRestore) SetAuditLog(auditLog *audit.Log) {
+	cr.auditLog = auditLog
+}
+
+// Restore runs the restore command for backupFile. Compressed backups are
+// decompressed first; without an {input} placeholder the backup is piped to stdin.
+func (cr *CommandRestore) Restore(backupFile string) error {
+	template := cr.config.Command.Restore
+	if template == "" {
+		return fmt.Errorf("no restore command is configured for database %s", cr.config.Database)
+	}
+	if _, err := os.Stat(backupFile); err != nil {
+		return fmt.Errorf("backup file is not accessible: %w", err)
+	}
+
+	// Restore commands may overwrite anything, so every run is recorded
+	if err := cr.auditLog.Record(audit.Event{
+		Action:  audit.ActionRestoreCommand,
+		Targets: []string{cr.config.Database},
+		Details: map[string]string{"backup_path": backupFile},
+	}); err != nil {
+		return fmt.Errorf("failed to record restore in audit log: %w", err)
+	}
+
+	input := backupFile
+	if strings.HasSuffix(backupFile, ".gz") {
+		decompressed, err := gunzipToTemp(backupFile)
+		if err != nil {
+			return fmt.Errorf("failed to decompress backup: %w", err)
+		}
+		defer os.Remove(decompressed)
+		input = decompressed
+	}
+
+	values := backup.CommandPlaceholders(cr.config)
+	values["input"] = input
+	script, err := backup.ExpandCommand(template, values)
+	if err != nil {
+		return fmt.Errorf("invalid restore command: %w", err)
+	}
+
+	cr.logger.Infof("Running restore command: %s", script)
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Env = append(os.Environ(), backup.CommandEnv(cr.config)...)
+
+	if !backup.UsesPlaceholder(template, "input") {
+		file, err := os.Open(input)
+		if err != nil {
+			return fmt.Errorf("failed to open backup: %w", err)
+		}
+		defer file.Close()
+		cmd.Stdin = file
+	}
+
+	if err := progress.RunStreaming(cmd, cr.logger, "restore"); err != nil {
+		return fmt.Errorf("restore command failed: %w", err)
+	}
+
+	cr.logger.Infof("Command restore completed successfully")
+	return nil
+}
+
+// gunzipToTemp decompresses a gzip file into a temporary file and returns its path
+func gunzipToTemp(path string) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := os.CreateTemp("", "db-backuper-restore-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, gz); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return out.Name(), nil
+}
@@ -0,0 +1,301 @@
+package restore
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/provenance"
+)
+
+// DumpVersions are the PostgreSQL versions a dump was taken from and with
+type DumpVersions struct {
+	// Server is the version of the dumped server
+	Server string
+	// PgDump is the version of pg_dump, empty for backups written by the service itself
+	PgDump string
+}
+
+// dumpVersionHeader matches the version comments of pg_dump scripts, of
+// pg_restore listings and of SQL backups written by the service
+var dumpVersionHeader = regexp.MustCompile(`Dumped (from database|by pg_dump) version:? (\S+)`)
+
+// dumpHeaderLines is how many lines of a script are searched for its versions
+const dumpHeaderLines = 64
+
+// ReadDumpVersions reads the versions from the header of a SQL script or
+// the output of pg_restore --list
+func ReadDumpVersions(r io.Reader) DumpVersions {
+	var versions DumpVersions
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for i := 0; i < dumpHeaderLines && scanner.Scan(); i++ {
+		match := dumpVersionHeader.FindStringSubmatch(scanner.Text())
+		switch {
+		case match == nil:
+		case match[1] == "from database":
+			versions.Server = match[2]
+		default:
+			versions.PgDump = match[2]
+		}
+	}
+	return versions
+}
+
+// VersionNum converts a PostgreSQL version such as 16.2, 9.6.24 or 17beta1
+// to the form of server_version_num, 0 when it cannot be parsed
+func VersionNum(version string) int {
+	var parts []int
+	for _, field := range strings.SplitN(version, ".", 3) {
+		end := 0
+		for end < len(field) && field[end] >= '0' && field[end] <= '9' {
+			end++
+		}
+		n, err := strconv.Atoi(field[:end])
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+		if end < len(field) {
+			break
+		}
+	}
+	for len(parts) < 3 {
+		parts = append(parts, 0)
+	}
+	if parts[0] >= 10 {
+		return parts[0]*10000 + parts[1]
+	}
+	return parts[0]*10000 + parts[1]*100 + parts[2]
+}
+
+// majorKey returns a number ordering server_version_nums by major version
+func majorKey(num int) int {
+	if num >= 100000 {
+		return num / 10000 * 100
+	}
+	return num / 100
+}
+
+// majorVersion returns the major version of a server_version_num, such as 16 or 9.6
+func majorVersion(num int) string {
+	if num >= 100000 {
+		return strconv.Itoa(num / 10000)
+	}
+	return fmt.Sprintf("%d.%d", num/10000, num/100%100)
+}
+
+// compatFixup is a statement of newer dumps that older servers reject, and
+// the first server version accepting it
+type compatFixup struct {
+	rule        config.TransformRule
+	sinceTarget int
+}
+
+// compatFixups drop the session settings and statements pg_dump writes for
+// newer servers; leaving them out does not change what is restored
+var compatFixups = []compatFixup{
+	{config.TransformRule{Name: "SET transaction_timeout (PostgreSQL 17)", Match: `^SET transaction_timeout\b`, Drop: true}, 170000},
+	{config.TransformRule{Name: "SET default_toast_compression (PostgreSQL 14)", Match: `^SET default_toast_compression\b`, Drop: true}, 140000},
+	{config.TransformRule{Name: "pg_database_owner schema owner (PostgreSQL 14)", Match: `^ALTER SCHEMA public OWNER TO pg_database_owner;`, Drop: true}, 140000},
+	{config.TransformRule{Name: "SET default_table_access_method (PostgreSQL 12)", Match: `^SET default_table_access_method\b`, Drop: true}, 120000},
+	{config.TransformRule{Name: "SET idle_in_transaction_session_timeout (PostgreSQL 9.6)", Match: `^SET idle_in_transaction_session_timeout\b`, Drop: true}, 90600},
+	{config.TransformRule{Name: "SET row_security (PostgreSQL 9.5)", Match: `^SET row_security\b`, Drop: true}, 90500},
+}
+
+// removedFeatures are statements of older dumps that newer servers reject
+// and that cannot be dropped without changing what is restored
+var removedFeatures = []struct {
+	match        *regexp.Regexp
+	removedSince int
+	warning      string
+}{
+	{regexp.MustCompile(`^SET default_with_oids = true;`), 120000, "the backup has tables WITH OIDS, which PostgreSQL 12 and later cannot create; recreate them WITHOUT OIDS on the source before dumping"},
+}
+
+// CheckCompatibility returns the fixups restoring a dump into a server of
+// targetNum needs, and warnings about what they cannot bridge
+func CheckCompatibility(dump DumpVersions, targetNum int) ([]config.TransformRule, []string) {
+	if targetNum == 0 {
+		return nil, nil
+	}
+	var fixups []config.TransformRule
+	var warnings []string
+
+	source := VersionNum(dump.Server)
+	if tool := VersionNum(dump.PgDump); tool > source {
+		// The statements written depend on pg_dump, not on the dumped server
+		source = tool
+	}
+	for _, fixup := range compatFixups {
+		if targetNum < fixup.sinceTarget && source >= fixup.sinceTarget {
+			fixups = append(fixups, fixup.rule)
+		}
+	}
+	if majorKey(source) > majorKey(targetNum) {
+		warnings = append(warnings, fmt.Sprintf("restoring a dump of PostgreSQL %s into older PostgreSQL %s is not supported by pg_dump; objects using newer features will fail to restore",
+			majorVersion(source), majorVersion(targetNum)))
+	}
+	return fixups, warnings
+}
+
+// checkRemovedFeatures warns about statements of the script at path, a dump
+// of sourceNum, that a server of targetNum no longer accepts. Only dumps of
+// servers older than the removal are searched.
+func checkRemovedFeatures(path string, sourceNum, targetNum int) ([]string, error) {
+	var warnings []string
+	for _, feature := range removedFeatures {
+		if targetNum < feature.removedSince || sourceNum == 0 || sourceNum >= feature.removedSince {
+			continue
+		}
+		found, err := scriptContains(path, feature.match)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			warnings = append(warnings, feature.warning)
+		}
+	}
+	return warnings, nil
+}
+
+// scriptContains reports whether a line of the script at path outside COPY
+// data matches pattern
+func scriptContains(path string, pattern *regexp.Regexp) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	inCopy := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case inCopy:
+			inCopy = line != `\.`
+		case strings.HasPrefix(line, "COPY ") && strings.HasSuffix(line, "FROM stdin;"):
+			inCopy = true
+		case pattern.MatchString(line):
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// scriptVersions returns the versions of a SQL backup from its header,
+// falling back to the server version in its provenance sidecar
+func scriptVersions(path string) (DumpVersions, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return DumpVersions{}, err
+	}
+	defer file.Close()
+
+	versions := ReadDumpVersions(file)
+	if versions.Server == "" {
+		if meta, err := provenance.ReadSidecar(path); err == nil && meta != nil {
+			versions.Server = meta.ServerVersion
+		}
+	}
+	return versions, nil
+}
+
+// archiveVersions returns the versions of a pg_dump directory archive from
+// its table of contents
+func archiveVersions(dumpDir string, env []string) (DumpVersions, error) {
+	cmd := exec.Command("pg_restore", "--list", dumpDir)
+	cmd.Env = env
+	output, err := cmd.Output()
+	if err != nil {
+		return DumpVersions{}, fmt.Errorf("failed to list archive: %w", err)
+	}
+	return ReadDumpVersions(strings.NewReader(string(output))), nil
+}
+
+// pgRestoreVersion returns the version of the local pg_restore
+func pgRestoreVersion() (string, error) {
+	output, err := exec.Command("pg_restore", "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run pg_restore --version: %w", err)
+	}
+	fields := strings.Fields(string(output))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("unexpected pg_restore --version output %q", output)
+	}
+	return fields[len(fields)-1], nil
+}
+
+// checkCompatibility compares the versions of a dump with the target server
+// and logs what restoring across them needs. The fixups are kept for the
+// restore unless compatibility is warn. scriptPath, when set, is the SQL
+// script searched for features the target no longer supports.
+func (pi *PostgresImport) checkCompatibility(dump DumpVersions, scriptPath string) {
+	if dump.Server == "" && dump.PgDump == "" {
+		pi.logger.Debugf("Backup records no PostgreSQL version; skipping the compatibility check")
+		return
+	}
+	pi.logger.Infof("Restoring a backup of PostgreSQL %s%s into PostgreSQL %s",
+		cmp.Or(dump.Server, "unknown"), pgDumpSuffix(dump.PgDump), majorVersion(pi.targetVersion))
+
+	fixups, warnings := CheckCompatibility(dump, pi.targetVersion)
+	if scriptPath != "" {
+		removed, err := checkRemovedFeatures(scriptPath, VersionNum(dump.Server), pi.targetVersion)
+		if err != nil {
+			pi.logger.Warnf("Failed to search the backup for removed features: %v", err)
+		}
+		warnings = append(warnings, removed...)
+	}
+	for _, warning := range warnings {
+		pi.logger.Warnf("Compatibility: %s", warning)
+	}
+	if len(fixups) == 0 {
+		return
+	}
+
+	names := make([]string, len(fixups))
+	for i, fixup := range fixups {
+		names[i] = fixup.Name
+	}
+	if pi.config.Compatibility == config.ImportCompatibilityWarn {
+		pi.logger.Warnf("Compatibility: PostgreSQL %s rejects these statements of the backup, which is restored unchanged: %s",
+			majorVersion(pi.targetVersion), strings.Join(names, ", "))
+		return
+	}
+	pi.logger.Infof("Compatibility: dropping statements PostgreSQL %s rejects: %s", majorVersion(pi.targetVersion), strings.Join(names, ", "))
+	pi.fixups = fixups
+}
+
+// checkPgRestoreVersion warns when the local pg_restore is older than the
+// pg_dump that wrote an archive, which it may not be able to read
+func (pi *PostgresImport) checkPgRestoreVersion(dump DumpVersions) {
+	if dump.PgDump == "" {
+		return
+	}
+	local, err := pgRestoreVersion()
+	if err != nil {
+		pi.logger.Warnf("Compatibility: %v", err)
+		return
+	}
+	if dumpNum := VersionNum(dump.PgDump); majorKey(VersionNum(local)) < majorKey(dumpNum) {
+		pi.logger.Warnf("Compatibility: pg_restore %s may not read archives written by pg_dump %s; install PostgreSQL %s or newer client tools",
+			local, dump.PgDump, majorVersion(dumpNum))
+	}
+}
+
+// pgDumpSuffix describes the pg_dump version of a dump for logs
+func pgDumpSuffix(version string) string {
+	if version == "" {
+		return ""
+	}
+	return " (pg_dump " + version + ")"
+}
@@ -0,0 +1,128 @@
+package restore
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxStatementLength bounds the statement text kept for each failure
+const maxStatementLength = 500
+
+// psqlError matches an error reported by psql for a line of its input
+var psqlError = regexp.MustCompile(`^psql:.*?:(\d+): ERROR:\s+(.*)$`)
+
+// pgRestoreError matches a statement pg_restore failed to execute
+var pgRestoreError = regexp.MustCompile(`^pg_restore: error: .*?ERROR:\s+(.*)$`)
+
+// errorContext matches the DETAIL and HINT lines following an error
+var errorContext = regexp.MustCompile(`^(?:psql:.*?:\d+: )?(DETAIL|HINT):\s+(.*)$`)
+
+// StatementError is a statement the restore could not execute
+type StatementError struct {
+	Line      int    `json:"line,omitempty"`
+	Message   string `json:"message"`
+	Detail    string `json:"detail,omitempty"`
+	Hint      string `json:"hint,omitempty"`
+	Statement string `json:"statement,omitempty"`
+}
+
+// ErrorReport lists the statements that failed during a restore
+type ErrorReport struct {
+	BackupPath string           `json:"backup_path"`
+	Stopped    bool             `json:"stopped"`
+	Errors     []StatementError `json:"errors"`
+
+	// awaitingCommand is set after a pg_restore error until its "Command was" line
+	awaitingCommand bool
+}
+
+// Parse records the failure described by a line of psql or pg_restore output
+func (r *ErrorReport) Parse(line string) {
+	if m := psqlError.FindStringSubmatch(line); m != nil {
+		lineNumber, _ := strconv.Atoi(m[1])
+		r.Errors = append(r.Errors, StatementError{Line: lineNumber, Message: m[2]})
+		r.awaitingCommand = false
+		return
+	}
+	if m := pgRestoreError.FindStringSubmatch(line); m != nil {
+		r.Errors = append(r.Errors, StatementError{Message: m[1]})
+		r.awaitingCommand = true
+		return
+	}
+	if len(r.Errors) == 0 {
+		return
+	}
+
+	last := &r.Errors[len(r.Errors)-1]
+	if m := errorContext.FindStringSubmatch(line); m != nil {
+		if m[1] == "DETAIL" {
+			last.Detail = m[2]
+		} else {
+			last.Hint = m[2]
+		}
+		return
+	}
+	if statement, ok := strings.CutPrefix(line, "Command was: "); ok && r.awaitingCommand {
+		last.Statement = truncateStatement(statement)
+		r.awaitingCommand = false
+	}
+}
+
+// fillStatements copies the failing lines of a SQL script into the report
+func (r *ErrorReport) fillStatements(scriptPath string) error {
+	wanted := map[int][]int{}
+	for i, e := range r.Errors {
+		if e.Line > 0 && e.Statement == "" {
+			wanted[e.Line] = append(wanted[e.Line], i)
+		}
+	}
+	if len(wanted) == 0 {
+		return nil
+	}
+
+	file, err := os.Open(scriptPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for lineNumber := 1; len(wanted) > 0; lineNumber++ {
+		line, err := reader.ReadString('\n')
+		if indexes, ok := wanted[lineNumber]; ok {
+			for _, i := range indexes {
+				r.Errors[i].Statement = truncateStatement(strings.TrimRight(line, "\r\n"))
+			}
+			delete(wanted, lineNumber)
+		}
+		if err != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// write saves the report as JSON
+func (r *ErrorReport) write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode error report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write error report: %w", err)
+	}
+	return nil
+}
+
+// truncateStatement shortens long statements such as bulk inserts for the report
+func truncateStatement(statement string) string {
+	if len(statement) <= maxStatementLength {
+		return statement
+	}
+	return statement[:maxStatementLength] + "..."
+}
@@ -0,0 +1,215 @@
+package restore
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"db-backuper/internal/audit"
+	"db-backuper/internal/backup"
+	"db-backuper/internal/config"
+	"db-backuper/internal/progress"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MSSQLRestore restores SQL Server .bak and .bacpac backups
+type MSSQLRestore struct {
+	config   *config.DatabaseConfig
+	logger   logrus.FieldLogger
+	auditLog *audit.Log
+}
+
+// NewMSSQLRestore creates a new SQL Server restore instance for the configured server
+func NewMSSQLRestore(dbConfig *config.DatabaseConfig, logger logrus.FieldLogger) *MSSQLRestore {
+	return &MSSQLRestore{
+		config: dbConfig,
+		logger: logger,
+	}
+}
+
+// SetAuditLog records destructive restores to the audit log
+func (mr *MSSQLRestore) SetAuditLog(auditLog *audit.Log) {
+	mr.auditLog = auditLog
+}
+
+// Restore restores backupFile into targetDatabase. An existing database is
+// only overwritten when replace is set.
+func (mr *MSSQLRestore) Restore(backupFile, targetDatabase string, replace bool) error {
+	if _, err := os.Stat(backupFile); err != nil {
+		return fmt.Errorf("backup file is not accessible: %w", err)
+	}
+
+	mr.logger.Infof("Restoring %s to %s:%d/%s", backupFile, mr.config.Host, mr.config.Port, targetDatabase)
+
+	if replace {
+		// Record the intent before anything is overwritten so the audit trail is never missing
+		if err := mr.auditLog.Record(audit.Event{
+			Action:  audit.ActionRestoreDropExisting,
+			Targets: []string{fmt.Sprintf("%s:%d/%s", mr.config.Host, mr.config.Port, targetDatabase)},
+			Details: map[string]string{"backup_path": backupFile},
+		}); err != nil {
+			return fmt.Errorf("failed to record restore in audit log: %w", err)
+		}
+	}
+
+	switch strings.ToLower(filepath.Ext(backupFile)) {
+	case ".bak":
+		return mr.restoreBackup(backupFile, targetDatabase, replace)
+	case ".bacpac":
+		return mr.importBacpac(backupFile, targetDatabase, replace)
+	default:
+		return fmt.Errorf("unsupported SQL Server backup file: %s (expected .bak or .bacpac)", backupFile)
+	}
+}
+
+// restoreBackup runs RESTORE DATABASE from the shared backup directory
+func (mr *MSSQLRestore) restoreBackup(backupFile, targetDatabase string, replace bool) error {
+	if mr.config.MSSQL.ServerBackupDir == "" {
+		return fmt.Errorf("mssql server_backup_dir is required to restore .bak files")
+	}
+
+	// SQL Server can only read files on its own filesystem, so stage the
+	// backup in the shared directory first
+	filename := filepath.Base(backupFile)
+	stagedPath := filepath.Join(mr.config.MSSQL.LocalDir(), filename)
+	if !samePath(backupFile, stagedPath) {
+		if err := copyFile(backupFile, stagedPath); err != nil {
+			return fmt.Errorf("failed to stage backup in %s: %w", mr.config.MSSQL.LocalDir(), err)
+		}
+		defer os.Remove(stagedPath)
+	}
+	serverPath := mr.config.MSSQL.ServerPath(filename)
+
+	options := []string{"CHECKSUM", "STATS = 10"}
+	if replace {
+		options = append(options, "REPLACE")
+	}
+
+	// Restoring under another name would collide with the source database's files
+	if targetDatabase != mr.config.Database {
+		moves, err := mr.relocateFiles(serverPath, targetDatabase)
+		if err != nil {
+			return err
+		}
+		options = append(options, moves...)
+	}
+
+	query := fmt.Sprintf("RESTORE DATABASE %s FROM DISK = %s WITH %s",
+		backup.QuoteMSSQLName(targetDatabase), backup.QuoteMSSQLString(serverPath), strings.Join(options, ", "))
+	if err := progress.RunStreaming(backup.SQLCmd(mr.config, query), mr.logger, "sqlcmd"); err != nil {
+		return fmt.Errorf("sql server restore failed: %w", err)
+	}
+
+	mr.logger.Infof("SQL Server restore completed successfully")
+	return nil
+}
+
+// relocateFiles builds MOVE options placing the restored files in the
+// server's default directories under the target database name
+func (mr *MSSQLRestore) relocateFiles(serverPath, targetDatabase string) ([]string, error) {
+	rows, err := mr.query(fmt.Sprintf("SET NOCOUNT ON; RESTORE FILELISTONLY FROM DISK = %s", backup.QuoteMSSQLString(serverPath)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup file list: %w", err)
+	}
+
+	dirs, err := mr.query("SET NOCOUNT ON; SELECT CAST(SERVERPROPERTY('InstanceDefaultDataPath') AS nvarchar(4000)), CAST(SERVERPROPERTY('InstanceDefaultLogPath') AS nvarchar(4000))")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read default data directories: %w", err)
+	}
+	if len(dirs) == 0 || len(dirs[0]) < 2 {
+		return nil, fmt.Errorf("server did not report its default data directories")
+	}
+
+	var moves []string
+	for _, row := range rows {
+		if len(row) < 3 {
+			continue
+		}
+		logicalName, physicalName, fileType := row[0], row[1], row[2]
+
+		dir := dirs[0][0]
+		if fileType == "L" {
+			dir = dirs[0][1]
+		}
+		ext := ""
+		if i := strings.LastIndex(physicalName, "."); i >= 0 {
+			ext = physicalName[i:]
+		}
+
+		target := dir + targetDatabase + "_" + logicalName + ext
+		moves = append(moves, fmt.Sprintf("MOVE %s TO %s", backup.QuoteMSSQLString(logicalName), backup.QuoteMSSQLString(target)))
+	}
+	return moves, nil
+}
+
+// importBacpac imports a bacpac with sqlpackage, dropping the target first when replacing
+func (mr *MSSQLRestore) importBacpac(backupFile, targetDatabase string, replace bool) error {
+	if replace {
+		name := backup.QuoteMSSQLName(targetDatabase)
+		query := fmt.Sprintf("IF DB_ID(%s) IS NOT NULL BEGIN ALTER DATABASE %s SET SINGLE_USER WITH ROLLBACK IMMEDIATE; DROP DATABASE %s; END",
+			backup.QuoteMSSQLString(targetDatabase), name, name)
+		if err := progress.RunStreaming(backup.SQLCmd(mr.config, query), mr.logger, "sqlcmd"); err != nil {
+			return fmt.Errorf("failed to drop existing database: %w", err)
+		}
+	}
+
+	cmd, cleanup, err := backup.SQLPackage(mr.config, "Target", targetDatabase, "/Action:Import", "/SourceFile:"+backupFile)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if err := progress.RunStreaming(cmd, mr.logger, "sqlpackage"); err != nil {
+		return fmt.Errorf("sqlpackage import failed: %w", err)
+	}
+
+	mr.logger.Infof("SQL Server bacpac import completed successfully")
+	return nil
+}
+
+// query runs a query and returns its rows as pipe separated columns
+func (mr *MSSQLRestore) query(query string) ([][]string, error) {
+	cmd := backup.SQLCmd(mr.config, query)
+	cmd.Args = append(cmd.Args, "-h", "-1", "-W", "-s", "|")
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%w\nOutput: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	var rows [][]string
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			rows = append(rows, strings.Split(line, "|"))
+		}
+	}
+	return rows, nil
+}
+
+// samePath reports whether two paths refer to the same location
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// copyFile copies src to dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -1,13 +1,26 @@
 package restore
 
 import (
+	"bufio"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"db-backuper/internal/audit"
+	"db-backuper/internal/backup"
 	"db-backuper/internal/config"
+	"db-backuper/internal/progress"
+	"db-backuper/internal/rdsauth"
+	"db-backuper/internal/storage"
+	"db-backuper/internal/transform"
 
 	_ "github.com/lib/pq"
 	"github.com/sirupsen/logrus"
@@ -15,18 +28,28 @@ import (
 
 // PostgresImport handles PostgreSQL database import operations
 type PostgresImport struct {
-	config *config.ImportConfig
-	logger *logrus.Logger
+	config   *config.ImportConfig
+	logger   logrus.FieldLogger
+	auditLog *audit.Log
+	// targetVersion is the server_version_num of the target database
+	targetVersion int
+	// fixups drop the statements of the backup the target server rejects
+	fixups []config.TransformRule
 }
 
 // NewPostgresImport creates a new PostgreSQL import instance
-func NewPostgresImport(importConfig *config.ImportConfig, logger *logrus.Logger) *PostgresImport {
+func NewPostgresImport(importConfig *config.ImportConfig, logger logrus.FieldLogger) *PostgresImport {
 	return &PostgresImport{
 		config: importConfig,
 		logger: logger,
 	}
 }
 
+// SetAuditLog records destructive restores to the audit log
+func (pi *PostgresImport) SetAuditLog(auditLog *audit.Log) {
+	pi.auditLog = auditLog
+}
+
 // ImportBackup imports a backup file to the target database
 func (pi *PostgresImport) ImportBackup() error {
 	// Validate backup file exists
@@ -48,6 +71,16 @@ func (pi *PostgresImport) ImportBackup() error {
 
 	// Drop existing database if requested
 	if pi.config.DropExisting {
+		// Record the intent before anything is dropped so the audit trail is never missing
+		if err := pi.auditLog.Record(audit.Event{
+			Action: audit.ActionRestoreDropExisting,
+			Targets: []string{fmt.Sprintf("%s:%d/%s",
+				pi.config.TargetDatabase.Host, pi.config.TargetDatabase.Port, pi.config.TargetDatabase.Database)},
+			Details: map[string]string{"backup_path": pi.config.BackupPath},
+		}); err != nil {
+			return fmt.Errorf("failed to record restore in audit log: %w", err)
+		}
+
 		if err := pi.dropDatabase(); err != nil {
 			return fmt.Errorf("failed to drop existing database: %w", err)
 		}
@@ -57,6 +90,15 @@ func (pi *PostgresImport) ImportBackup() error {
 	if err := pi.importBackupFile(); err != nil {
 		return fmt.Errorf("failed to import backup: %w", err)
 	}
+	// Transforms may rename or drop tables, so the manifest no longer describes the result
+	switch {
+	case len(pi.config.Transforms) > 0 && !pi.config.SchemaOnly:
+		pi.logger.Info("Skipping the row count check of a transformed restore")
+	case !pi.config.SchemaOnly:
+		if err := pi.checkRowCounts(); err != nil {
+			return fmt.Errorf("row count check failed: %w", err)
+		}
+	}
 
 	pi.logger.Info("Import completed successfully")
 	return nil
@@ -64,11 +106,15 @@ func (pi *PostgresImport) ImportBackup() error {
 
 // testConnection tests the connection to the target database
 func (pi *PostgresImport) testConnection() error {
+	password, err := pi.password()
+	if err != nil {
+		return err
+	}
 	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
 		pi.config.TargetDatabase.Host,
 		pi.config.TargetDatabase.Port,
 		pi.config.TargetDatabase.Username,
-		pi.config.TargetDatabase.Password,
+		password,
 		pi.config.TargetDatabase.Database,
 		pi.config.TargetDatabase.SSLMode)
 
@@ -78,9 +124,11 @@ func (pi *PostgresImport) testConnection() error {
 	}
 	defer db.Close()
 
-	if err := db.Ping(); err != nil {
+	var version string
+	if err := db.QueryRow("SHOW server_version_num").Scan(&version); err != nil {
 		return fmt.Errorf("failed to ping database: %w", err)
 	}
+	pi.targetVersion, _ = strconv.Atoi(version)
 
 	pi.logger.Info("Database connection test successful")
 	return nil
@@ -91,11 +139,15 @@ func (pi *PostgresImport) dropDatabase() error {
 	pi.logger.Warnf("Dropping existing database: %s", pi.config.TargetDatabase.Database)
 
 	// Connect to postgres database to drop the target database
+	password, err := pi.password()
+	if err != nil {
+		return err
+	}
 	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=postgres sslmode=%s",
 		pi.config.TargetDatabase.Host,
 		pi.config.TargetDatabase.Port,
 		pi.config.TargetDatabase.Username,
-		pi.config.TargetDatabase.Password,
+		password,
 		pi.config.TargetDatabase.SSLMode)
 
 	db, err := sql.Open("postgres", dsn)
@@ -133,36 +185,441 @@ func (pi *PostgresImport) dropDatabase() error {
 
 // importBackupFile imports the backup file using psql
 func (pi *PostgresImport) importBackupFile() error {
-	// Build psql command
-	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+	if strings.HasSuffix(pi.config.BackupPath, backup.PostgresDirectorySuffix) || storage.IsDirectoryIndex(pi.config.BackupPath) {
+		return pi.restoreDirectoryBackup()
+	}
+
+	// Build psql command; the password is passed via PGPASSWORD so it never
+	// appears in the process list or in logs
+	dsn := fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=%s",
 		pi.config.TargetDatabase.Host,
 		pi.config.TargetDatabase.Port,
 		pi.config.TargetDatabase.Username,
-		pi.config.TargetDatabase.Password,
 		pi.config.TargetDatabase.Database,
 		pi.config.TargetDatabase.SSLMode)
 
 	// Set PGPASSWORD environment variable
+	password, err := pi.password()
+	if err != nil {
+		return err
+	}
 	env := os.Environ()
-	env = append(env, fmt.Sprintf("PGPASSWORD=%s", pi.config.TargetDatabase.Password))
+	env = append(env, fmt.Sprintf("PGPASSWORD=%s", password))
+
+	versions, err := scriptVersions(pi.config.BackupPath)
+	if err != nil {
+		return fmt.Errorf("failed to read backup header: %w", err)
+	}
+	pi.checkCompatibility(versions, pi.config.BackupPath)
+	pi.warnIgnoredOptions()
 
 	// Set working directory to the backup file's directory
-	backupDir := filepath.Dir(pi.config.BackupPath)
-	backupFile := filepath.Base(pi.config.BackupPath)
+	scriptPath := pi.config.BackupPath
+	rewrite := len(pi.transforms()) > 0 || len(pi.config.RoleMap) > 0
+	if pi.config.SchemaOnly || rewrite {
+		workDir, err := os.MkdirTemp("", "db-backuper-restore-*")
+		if err != nil {
+			return fmt.Errorf("failed to create script directory: %w", err)
+		}
+		defer os.RemoveAll(workDir)
+		if pi.config.SchemaOnly {
+			schemaPath := filepath.Join(workDir, "schema_"+filepath.Base(pi.config.BackupPath))
+			if err := writeSchemaOnly(scriptPath, schemaPath); err != nil {
+				return fmt.Errorf("failed to extract schema: %w", err)
+			}
+			scriptPath = schemaPath
+		}
+		if rewrite {
+			transformedPath := filepath.Join(workDir, filepath.Base(pi.config.BackupPath))
+			if err := pi.rewriteScriptFile(scriptPath, transformedPath); err != nil {
+				return err
+			}
+			scriptPath = transformedPath
+		}
+	}
+	backupDir := filepath.Dir(scriptPath)
+	backupFile := filepath.Base(scriptPath)
+
+	startTime := time.Now()
 
 	// Build command with just the filename since we're setting the working directory
-	cmd := exec.Command("psql", dsn, "-f", backupFile)
+	args := []string{dsn}
+	if pi.config.OnErrorStop {
+		args = append(args, "-v", "ON_ERROR_STOP=1")
+	}
+	if pi.config.Role != "" {
+		args = append(args, "-c", "SET ROLE "+quoteIdentifier(pi.config.Role))
+	}
+	args = append(args, "-f", backupFile)
+	cmd := exec.Command("psql", args...)
 	cmd.Env = env
 	cmd.Dir = backupDir
 
-	pi.logger.Infof("Executing import command: psql %s -f %s (working dir: %s)", dsn, backupFile, backupDir)
+	pi.logger.Infof("Executing import command: psql %s (working dir: %s)", strings.Join(args, " "), backupDir)
+
+	reporter := pi.startProgressReporter()
+	defer reporter.Stop()
+
+	if err := pi.runWithErrorPolicy(cmd, "psql", scriptPath); err != nil {
+		return err
+	}
+
+	reporter.Stop()
+	pi.logger.Infof("Import of %s finished in %v", progress.FormatBytes(pi.backupSize()), time.Since(startTime).Round(time.Second))
+	return nil
+}
+
+// password returns the password for the target database: a fresh IAM
+// authentication token when iam_auth is enabled, otherwise the configured password
+func (pi *PostgresImport) password() (string, error) {
+	target := pi.config.TargetDatabase
+	if !target.IAMAuth {
+		return target.Password, nil
+	}
+	return rdsauth.Token(target.Host, target.Port, target.Username, target.IAMRegion)
+}
+
+// startProgressReporter periodically logs that the import is still running
+func (pi *PostgresImport) startProgressReporter() *progress.Ticker {
+	size := progress.FormatBytes(pi.backupSize())
+	return progress.StartTicker(progress.DefaultInterval, func(elapsed time.Duration) {
+		pi.logger.Infof("Import of %s still running after %v", size, elapsed.Round(time.Second))
+	})
+}
+
+// backupSize returns the size of the backup file being imported
+func (pi *PostgresImport) backupSize() int64 {
+	info, err := os.Stat(pi.config.BackupPath)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// restoreDirectoryBackup unpacks a directory format backup and restores it
+// with pg_restore, running the configured number of parallel jobs. Backups
+// stored as one object per file are restored from the directory next to
+// their index, where they were downloaded.
+func (pi *PostgresImport) restoreDirectoryBackup() error {
+	dumpDir := storage.DirectoryPath(pi.config.BackupPath)
+	if !storage.IsDirectoryIndex(pi.config.BackupPath) {
+		workDir, err := os.MkdirTemp("", "db-backuper-restore-*")
+		if err != nil {
+			return fmt.Errorf("failed to create extraction directory: %w", err)
+		}
+		defer os.RemoveAll(workDir)
+
+		pi.logger.Infof("Extracting %s", pi.config.BackupPath)
+		if err := extractTarGz(pi.config.BackupPath, workDir); err != nil {
+			return fmt.Errorf("failed to extract backup: %w", err)
+		}
+		dumpDir = filepath.Join(workDir, backup.PostgresDumpDir)
+	} else if _, err := os.Stat(dumpDir); err != nil {
+		return fmt.Errorf("files of directory backup %s not found: %w", pi.config.BackupPath, err)
+	}
+
+	dsn := fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=%s",
+		pi.config.TargetDatabase.Host,
+		pi.config.TargetDatabase.Port,
+		pi.config.TargetDatabase.Username,
+		pi.config.TargetDatabase.Database,
+		pi.config.TargetDatabase.SSLMode)
+	password, err := pi.password()
+	if err != nil {
+		return err
+	}
+	env := append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", password))
+
+	if versions, err := archiveVersions(dumpDir, env); err != nil {
+		pi.logger.Warnf("Skipping the compatibility check: %v", err)
+	} else {
+		pi.checkCompatibility(versions, "")
+		pi.checkPgRestoreVersion(versions)
+	}
+
+	startTime := time.Now()
+	reporter := pi.startProgressReporter()
+	defer reporter.Stop()
+
+	if len(pi.config.RoleMap) > 0 || len(pi.transforms()) > 0 {
+		if err := pi.restoreThroughScript(dumpDir, dsn, env); err != nil {
+			return err
+		}
+	} else {
+		jobs := pi.config.Jobs
+		if jobs < 1 {
+			jobs = 1
+		}
+		args := append([]string{"--jobs", strconv.Itoa(jobs)}, pi.restoreOptions()...)
+		args = append(args, "--dbname", dsn, dumpDir)
+
+		cmd := exec.Command("pg_restore", args...)
+		cmd.Env = env
+
+		pi.logger.Infof("Executing import command: pg_restore %s", strings.Join(args, " "))
+		if err := pi.runWithErrorPolicy(cmd, "pg_restore", ""); err != nil {
+			return err
+		}
+	}
+
+	reporter.Stop()
+	pi.logger.Infof("Import of %s finished in %v", progress.FormatBytes(pi.backupSize()), time.Since(startTime).Round(time.Second))
+	return nil
+}
+
+// restoreOptions returns the pg_restore options for ownership, privileges and cleanup
+func (pi *PostgresImport) restoreOptions() []string {
+	var args []string
+	if pi.config.NoOwner {
+		args = append(args, "--no-owner")
+	}
+	if pi.config.NoPrivileges {
+		args = append(args, "--no-privileges")
+	}
+	if pi.config.Clean {
+		args = append(args, "--clean")
+	}
+	if pi.config.IfExists {
+		args = append(args, "--if-exists")
+	}
+	if pi.config.SchemaOnly {
+		args = append(args, "--schema-only")
+	}
+	if pi.config.Role != "" {
+		args = append(args, "--role="+pi.config.Role)
+	}
+	if pi.config.OnErrorStop {
+		args = append(args, "--exit-on-error")
+	}
+	return args
+}
+
+// restoreThroughScript turns the dump into a script with pg_restore,
+// renames the roles it references, applies the transforms and runs it with
+// psql. Scripts run on a single connection, so the configured jobs do not
+// apply.
+func (pi *PostgresImport) restoreThroughScript(dumpDir, dsn string, env []string) error {
+	if pi.config.Jobs > 1 {
+		pi.logger.Warnf("Ignoring %d import jobs: restores with a role map or transforms run on a single connection", pi.config.Jobs)
+	}
+	pipeline, err := transform.New(pi.transforms())
+	if err != nil {
+		return err
+	}
+
+	args := append(pi.restoreOptions(), "--file=-", dumpDir)
+	restoreCmd := exec.Command("pg_restore", args...)
+	restoreCmd.Env = env
+	script, err := restoreCmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to capture pg_restore output: %w", err)
+	}
+	restoreErr, err := restoreCmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("failed to capture pg_restore errors: %w", err)
+	}
+
+	psqlArgs := []string{dsn}
+	if pi.config.OnErrorStop {
+		psqlArgs = append(psqlArgs, "-v", "ON_ERROR_STOP=1")
+	}
+	psqlCmd := exec.Command("psql", psqlArgs...)
+	psqlCmd.Env = env
+	psqlIn, err := psqlCmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open psql input: %w", err)
+	}
+
+	pi.logger.Infof("Executing import command: pg_restore %s | psql %s (roles remapped: %d, transforms: %d)", strings.Join(args, " "), dsn, len(pi.config.RoleMap), len(pi.transforms()))
+	if err := restoreCmd.Start(); err != nil {
+		return fmt.Errorf("failed to start pg_restore: %w", err)
+	}
+
+	rewriteDone := make(chan error, 1)
+	go func() {
+		err := rewriteScript(psqlIn, script, pi.config.RoleMap, pipeline, pi.logger)
+		psqlIn.Close()
+		if err != nil {
+			// Keep pg_restore from blocking on a reader that went away
+			io.Copy(io.Discard, script)
+		}
+		rewriteDone <- err
+	}()
+	go progress.StreamLines(restoreErr, pi.logger, "pg_restore", 20)
+
+	psqlErr := pi.runWithErrorPolicy(psqlCmd, "psql", "")
+	rewriteErr := <-rewriteDone
+	if err := restoreCmd.Wait(); err != nil {
+		return fmt.Errorf("pg_restore command failed: %w", err)
+	}
+	if rewriteErr != nil {
+		return fmt.Errorf("failed to rewrite restore script: %w", rewriteErr)
+	}
+	return psqlErr
+}
+
+// transforms returns the compatibility fixups followed by the configured transforms
+func (pi *PostgresImport) transforms() []config.TransformRule {
+	return append(slices.Clone(pi.fixups), pi.config.Transforms...)
+}
+
+// rewriteScriptFile writes the SQL script at src to dst with its roles
+// renamed by the role map and then through the fixups and transforms
+func (pi *PostgresImport) rewriteScriptFile(src, dst string) error {
+	pipeline, err := transform.New(pi.transforms())
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+	if err := rewriteScript(out, in, pi.config.RoleMap, pipeline, pi.logger); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to rewrite restore script: %w", err)
+	}
+	return out.Close()
+}
+
+// warnIgnoredOptions warns about the pg_restore options set for a plain SQL
+// backup, which psql runs as it is
+func (pi *PostgresImport) warnIgnoredOptions() {
+	var ignored []string
+	for _, option := range []struct {
+		name string
+		set  bool
+	}{
+		{"no_owner", pi.config.NoOwner},
+		{"no_privileges", pi.config.NoPrivileges},
+		{"clean", pi.config.Clean},
+		{"if_exists", pi.config.IfExists},
+	} {
+		if option.set {
+			ignored = append(ignored, option.name)
+		}
+	}
+	if len(ignored) > 0 {
+		pi.logger.Warnf("Ignoring %s for plain SQL backup %s: they only apply to directory format backups; use drop_existing instead of clean, and role_map or transforms for ownership and privileges",
+			strings.Join(ignored, ", "), filepath.Base(pi.config.BackupPath))
+	}
+}
+
+// rewriteScript copies a pg_restore script, renaming its roles and then
+// applying the transforms
+func rewriteScript(dst io.Writer, src io.Reader, roles map[string]string, pipeline *transform.Pipeline, logger logrus.FieldLogger) error {
+	if pipeline.Empty() {
+		return rewriteRoleScript(dst, src, roles)
+	}
+
+	renamed, renamedWriter := io.Pipe()
+	go func() {
+		renamedWriter.CloseWithError(rewriteRoleScript(renamedWriter, src, roles))
+	}()
+	stats, err := pipeline.Copy(dst, renamed)
+	renamed.CloseWithError(err)
+	if err != nil {
+		return err
+	}
+	logger.Infof("Transformed the backup: %s", stats)
+	return nil
+}
 
-	// Run the command
-	output, err := cmd.CombinedOutput()
+// runWithErrorPolicy runs psql or pg_restore, collecting the statements that
+// failed and holding them against the allowed number of errors. scriptPath,
+// when set, is the SQL file whose failing lines are copied into the report.
+func (pi *PostgresImport) runWithErrorPolicy(cmd *exec.Cmd, prefix, scriptPath string) error {
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return fmt.Errorf("psql command failed: %w\nOutput: %s", err, string(output))
+		return fmt.Errorf("failed to capture %s output: %w", prefix, err)
+	}
+	cmd.Stderr = cmd.Stdout
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", prefix, err)
+	}
+
+	report := &ErrorReport{BackupPath: pi.config.BackupPath}
+	tail := progress.StreamLinesFunc(stdout, pi.logger, prefix, 20, report.Parse)
+	waitErr := cmd.Wait()
+
+	if scriptPath != "" {
+		if err := report.fillStatements(scriptPath); err != nil {
+			pi.logger.Warnf("Failed to read failed statements from %s: %v", scriptPath, err)
+		}
+	}
+	report.Stopped = pi.config.OnErrorStop && waitErr != nil && len(report.Errors) > 0
+	if pi.config.ErrorReport != "" {
+		if err := report.write(pi.config.ErrorReport); err != nil {
+			return err
+		}
+		pi.logger.Infof("Wrote restore error report to %s", pi.config.ErrorReport)
+	}
+
+	if len(report.Errors) > 0 {
+		pi.logger.Warnf("%d statements failed during the restore", len(report.Errors))
+		for _, failure := range report.Errors[:min(len(report.Errors), 5)] {
+			pi.logger.Warnf("Failed statement (line %d): %s", failure.Line, failure.Message)
+		}
+	}
+	if maxErrors := pi.config.MaxErrors; maxErrors != nil && len(report.Errors) > *maxErrors {
+		return fmt.Errorf("%d statements failed, more than the %d allowed", len(report.Errors), *maxErrors)
 	}
 
-	pi.logger.Infof("Import command output: %s", string(output))
+	if waitErr != nil {
+		// pg_restore exits with status 1 after ignoring errors, which an
+		// explicit error threshold has already accepted
+		var exitErr *exec.ExitError
+		if prefix == "pg_restore" && pi.config.MaxErrors != nil && !report.Stopped && len(report.Errors) > 0 &&
+			errors.As(waitErr, &exitErr) && exitErr.ExitCode() == 1 {
+			return nil
+		}
+		return fmt.Errorf("%s command failed: %w\nOutput: %s", prefix, waitErr, strings.Join(tail, "\n"))
+	}
 	return nil
 }
+
+// writeSchemaOnly copies the schema statements of a SQL backup to dst. Backups
+// written by this service end their schema at the data section; COPY blocks of
+// pg_dump scripts are skipped wherever they appear.
+func writeSchemaOnly(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	writer := bufio.NewWriter(out)
+	inCopy := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case inCopy:
+			inCopy = line != `\.`
+			continue
+		case line == backup.PostgresDataSection:
+			return writer.Flush()
+		case strings.HasPrefix(line, "COPY ") && strings.HasSuffix(line, "FROM stdin;"):
+			inCopy = true
+			continue
+		}
+		if _, err := writer.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
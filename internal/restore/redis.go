@@ -0,0 +1,87 @@
+package restore
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"db-backuper/internal/config"
+)
+
+// RedisPlacement describes where a Redis server loads its data from on startup
+type RedisPlacement struct {
+	Dir        string
+	DBFilename string
+	AppendOnly bool
+}
+
+// InspectRedis reads the data file settings of a running Redis server
+func InspectRedis(redisConfig *config.RedisConfig) (*RedisPlacement, error) {
+	values, err := redisConfigGet(redisConfig, "dir", "dbfilename", "appendonly")
+	if err != nil {
+		return nil, err
+	}
+
+	placement := &RedisPlacement{
+		Dir:        values["dir"],
+		DBFilename: values["dbfilename"],
+		AppendOnly: values["appendonly"] == "yes",
+	}
+	if placement.Dir == "" || placement.DBFilename == "" {
+		return nil, fmt.Errorf("server did not report its data directory (CONFIG may be disabled)")
+	}
+	return placement, nil
+}
+
+// RedisRestoreInstructions returns the steps to restore an RDB backup by placing
+// it in the server's data directory. A nil placement produces generic steps.
+func RedisRestoreInstructions(backupFile string, redisConfig *config.RedisConfig, placement *RedisPlacement) string {
+	target := "<dir>/<dbfilename>"
+	if placement != nil {
+		target = filepath.Join(placement.Dir, placement.DBFilename)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "To restore %s to Redis at %s:\n\n", backupFile, redisConfig.Host)
+	fmt.Fprintf(&b, "  1. Stop the Redis server.\n")
+	fmt.Fprintf(&b, "  2. Replace the RDB file with the backup and give it to the Redis user:\n")
+	fmt.Fprintf(&b, "       cp %s %s\n", backupFile, target)
+	if placement == nil {
+		fmt.Fprintf(&b, "     Find <dir> and <dbfilename> with: redis-cli CONFIG GET dir / CONFIG GET dbfilename\n")
+	}
+	step := 3
+	if placement == nil || placement.AppendOnly {
+		fmt.Fprintf(&b, "  %d. If appendonly is enabled, Redis loads the AOF instead of the RDB file.\n", step)
+		fmt.Fprintf(&b, "     Set \"appendonly no\" in redis.conf before starting, then run\n")
+		fmt.Fprintf(&b, "     CONFIG SET appendonly yes once the data is loaded to rebuild the AOF.\n")
+		step++
+	}
+	fmt.Fprintf(&b, "  %d. Start the Redis server and check the key counts with: redis-cli INFO keyspace\n", step)
+	return b.String()
+}
+
+// redisConfigGet reads configuration parameters with CONFIG GET
+func redisConfigGet(redisConfig *config.RedisConfig, params ...string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, param := range params {
+		cmd := exec.Command("redis-cli", append(redisConfig.GetCLIArgs(), "CONFIG", "GET", param)...)
+		cmd.Env = os.Environ()
+		if redisConfig.Password != "" {
+			cmd.Env = append(cmd.Env, "REDISCLI_AUTH="+redisConfig.Password)
+		}
+
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("redis CONFIG GET %s failed: %w\nOutput: %s", param, err, strings.TrimSpace(string(output)))
+		}
+
+		// Replies are name/value pairs on consecutive lines
+		lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+		for i := 0; i+1 < len(lines); i += 2 {
+			values[strings.TrimSpace(lines[i])] = strings.TrimSpace(lines[i+1])
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,308 @@
+package restore
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/docker"
+	"db-backuper/internal/schemadiff"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Rehearsal containers are labelled so leftovers can be found with
+// docker ps --filter label=db-backuper.rehearsal
+const (
+	RehearsalLabel = "db-backuper.rehearsal"
+	rehearsalPort  = "5432/tcp"
+	rehearsalUser  = "postgres"
+)
+
+// Rehearsal restores a backup into a disposable PostgreSQL container and
+// validates the result, so restores can be practised without a scratch server
+type Rehearsal struct {
+	config *config.RehearsalConfig
+	docker *docker.Client
+	logger logrus.FieldLogger
+}
+
+// RehearsalResult describes a rehearsal container and what was found in it
+type RehearsalResult struct {
+	ContainerID string
+	Image       string
+	Host        string
+	Port        int
+	Database    string
+	Password    string
+	// RestoreDuration is how long the restore itself took
+	RestoreDuration time.Duration
+	Tables          int
+	Checks          []CheckResult
+}
+
+// RehearsalOptions select what a rehearsal restores
+type RehearsalOptions struct {
+	// Version is the PostgreSQL version of the container, empty for the configured one
+	Version string
+	// SchemaOnly restores the schema without data and skips the checks
+	SchemaOnly bool
+	// SkipChecks restores the data without running the configured checks
+	SkipChecks bool
+	// Transforms rewrite the SQL of the backup as it is restored
+	Transforms []config.TransformRule
+}
+
+// CheckResult is the outcome of one validation query
+type CheckResult struct {
+	Name   string
+	Passed bool
+	Value  string
+	Error  string
+}
+
+// ConnectionString returns the URL operators connect to the restored database with
+func (r *RehearsalResult) ConnectionString() string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(rehearsalUser, r.Password),
+		Host:     net.JoinHostPort(r.Host, strconv.Itoa(r.Port)),
+		Path:     "/" + r.Database,
+		RawQuery: "sslmode=disable",
+	}
+	return u.String()
+}
+
+// DatabaseConfig returns the configuration backing up the restored database
+func (r *RehearsalResult) DatabaseConfig() config.DatabaseConfig {
+	return config.DatabaseConfig{
+		Type:     config.EngineTypePostgres,
+		Host:     r.Host,
+		Port:     r.Port,
+		Username: rehearsalUser,
+		Password: r.Password,
+		Database: r.Database,
+		SSLMode:  "disable",
+	}
+}
+
+// Failed returns the checks that did not pass
+func (r *RehearsalResult) Failed() []CheckResult {
+	var failed []CheckResult
+	for _, check := range r.Checks {
+		if !check.Passed {
+			failed = append(failed, check)
+		}
+	}
+	return failed
+}
+
+// NewRehearsal creates a rehearsal using the Docker daemon of the configuration
+func NewRehearsal(rehearsalConfig *config.RehearsalConfig, logger logrus.FieldLogger) (*Rehearsal, error) {
+	client, err := docker.NewClient(rehearsalConfig.DockerHost)
+	if err != nil {
+		return nil, err
+	}
+	return &Rehearsal{
+		config: rehearsalConfig,
+		docker: client,
+		logger: logger,
+	}, nil
+}
+
+// Run starts a PostgreSQL container, restores backupPath into database and
+// runs the validations. Once the container exists the result is returned
+// even when a later step fails, so the caller decides whether to keep or
+// Remove it.
+func (r *Rehearsal) Run(backupPath, database string, opts RehearsalOptions) (*RehearsalResult, error) {
+	image := r.config.ImageReference(opts.Version)
+	r.logger.Infof("Pulling %s", image)
+	if err := r.docker.Pull(image); err != nil {
+		return nil, err
+	}
+
+	password, err := randomPassword()
+	if err != nil {
+		return nil, err
+	}
+	id, err := r.docker.Create(docker.ContainerSpec{
+		Image: image,
+		Env: map[string]string{
+			"POSTGRES_PASSWORD": password,
+			"POSTGRES_DB":       database,
+		},
+		Labels: map[string]string{RehearsalLabel: database},
+		Port:   rehearsalPort,
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := &RehearsalResult{ContainerID: id, Image: image, Host: r.docker.Host(), Database: database, Password: password}
+
+	if err := r.docker.Start(id); err != nil {
+		return result, err
+	}
+	hostPort, err := r.docker.HostPort(id, rehearsalPort)
+	if err != nil {
+		return result, err
+	}
+	if result.Port, err = strconv.Atoi(hostPort); err != nil {
+		return result, fmt.Errorf("invalid host port %q: %w", hostPort, err)
+	}
+
+	r.logger.Infof("Waiting for %s to accept connections on %s:%d", image, result.Host, result.Port)
+	db, err := r.waitReady(result)
+	if err != nil {
+		return result, err
+	}
+	defer db.Close()
+
+	// The container has none of the source roles, so ownership and grants
+	// are left out rather than failing every statement that names them
+	importer := NewPostgresImport(&config.ImportConfig{
+		TargetDatabase: config.ImportDatabaseConfig{
+			Host:     result.Host,
+			Port:     result.Port,
+			Username: rehearsalUser,
+			Password: password,
+			Database: database,
+			SSLMode:  "disable",
+		},
+		BackupPath:   backupPath,
+		NoOwner:      true,
+		NoPrivileges: true,
+		SchemaOnly:   opts.SchemaOnly,
+		MaxErrors:    r.config.MaxErrors,
+		Transforms:   opts.Transforms,
+	}, r.logger)
+	startTime := time.Now()
+	if err := importer.ImportBackup(); err != nil {
+		return result, err
+	}
+	result.RestoreDuration = time.Since(startTime)
+
+	if err := r.validate(db, result, !opts.SchemaOnly && !opts.SkipChecks); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// Remove deletes the container of a rehearsal
+func (r *Rehearsal) Remove(result *RehearsalResult) error {
+	r.logger.Infof("Removing rehearsal container %s", docker.ShortID(result.ContainerID))
+	return r.docker.Remove(result.ContainerID)
+}
+
+// Columns returns the columns of the tables restored by a rehearsal
+func (r *Rehearsal) Columns(result *RehearsalResult) ([]schemadiff.Column, error) {
+	db, err := r.Open(result)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return schemadiff.Read(context.Background(), db)
+}
+
+// Open connects to the database restored by a rehearsal
+func (r *Rehearsal) Open(result *RehearsalResult) (*sql.DB, error) {
+	db, err := sql.Open("postgres", result.ConnectionString())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open rehearsal database: %w", err)
+	}
+	return db, nil
+}
+
+// waitReady connects to the container until it accepts connections or the
+// startup timeout passes. The image's first start runs its init scripts
+// without listening on TCP, so the first successful connection is to the
+// final server.
+func (r *Rehearsal) waitReady(result *RehearsalResult) (*sql.DB, error) {
+	db, err := sql.Open("postgres", result.ConnectionString())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open rehearsal database: %w", err)
+	}
+
+	deadline := time.Now().Add(r.config.StartupTimeout())
+	for {
+		err := db.Ping()
+		if err == nil {
+			return db, nil
+		}
+		if time.Now().After(deadline) {
+			db.Close()
+			return nil, fmt.Errorf("rehearsal database did not accept connections within %s: %w", r.config.StartupTimeout(), err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// validate checks that the restore produced tables and, with checks set,
+// runs the configured checks
+func (r *Rehearsal) validate(db *sql.DB, result *RehearsalResult, checks bool) error {
+	err := db.QueryRow(`SELECT count(*) FROM information_schema.tables
+		WHERE table_type = 'BASE TABLE' AND table_schema NOT IN ('pg_catalog', 'information_schema')`).Scan(&result.Tables)
+	if err != nil {
+		return fmt.Errorf("failed to count restored tables: %w", err)
+	}
+	if result.Tables == 0 {
+		return fmt.Errorf("restored database %s has no tables", result.Database)
+	}
+	r.logger.Infof("Restored %d tables in %v", result.Tables, result.RestoreDuration.Round(time.Second))
+	if !checks {
+		return nil
+	}
+
+	for _, check := range r.config.Checks {
+		outcome := runCheck(db, check)
+		if outcome.Passed {
+			r.logger.Infof("Check %s passed", outcome.Name)
+		} else {
+			r.logger.Warnf("Check %s failed: %s", outcome.Name, cmp.Or(outcome.Error, "returned "+outcome.Value))
+		}
+		result.Checks = append(result.Checks, outcome)
+	}
+	if failed := result.Failed(); len(failed) > 0 {
+		return fmt.Errorf("%d of %d rehearsal checks failed", len(failed), len(result.Checks))
+	}
+	return nil
+}
+
+// runCheck runs a validation query. It passes when the first column of the
+// first row is true or a non-zero number.
+func runCheck(db *sql.DB, check config.RehearsalCheck) CheckResult {
+	outcome := CheckResult{Name: cmp.Or(check.Name, check.Query)}
+	var value sql.NullString
+	if err := db.QueryRow(check.Query).Scan(&value); err != nil {
+		outcome.Error = err.Error()
+		return outcome
+	}
+	outcome.Value = value.String
+	if !value.Valid {
+		outcome.Value = "NULL"
+		return outcome
+	}
+	if n, err := strconv.ParseFloat(value.String, 64); err == nil {
+		outcome.Passed = n != 0
+	} else {
+		outcome.Passed = value.String == "t" || strings.EqualFold(value.String, "true")
+	}
+	return outcome
+}
+
+// randomPassword returns a password for the rehearsal superuser
+func randomPassword() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate rehearsal password: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
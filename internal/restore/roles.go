@@ -0,0 +1,72 @@
+package restore
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// roleStatement matches the statements of a pg_restore script that name a role
+var roleStatement = regexp.MustCompile(`^(ALTER|GRANT|REVOKE|SET SESSION AUTHORIZATION|SET ROLE)\b`)
+
+// roleReference matches a role named after OWNER TO, TO, FROM or AUTHORIZATION,
+// either bare or as a quoted identifier
+var roleReference = regexp.MustCompile(`\b(OWNER TO|TO|FROM|AUTHORIZATION|ROLE) ("(?:[^"]|"")+"|[A-Za-z_][A-Za-z0-9_$]*)`)
+
+// RewriteRoles replaces the roles referenced by an ownership or privilege
+// statement according to roles; other lines are returned unchanged
+func RewriteRoles(line string, roles map[string]string) string {
+	if len(roles) == 0 || !roleStatement.MatchString(line) {
+		return line
+	}
+
+	return roleReference.ReplaceAllStringFunc(line, func(match string) string {
+		parts := roleReference.FindStringSubmatch(match)
+		keyword, role := parts[1], parts[2]
+		name := role
+		if strings.HasPrefix(role, `"`) {
+			name = strings.ReplaceAll(role[1:len(role)-1], `""`, `"`)
+		}
+		target, ok := roles[name]
+		if !ok {
+			return match
+		}
+		return keyword + " " + quoteIdentifier(target)
+	})
+}
+
+// quoteIdentifier quotes a PostgreSQL identifier
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// rewriteRoleScript copies a pg_restore script, renaming roles in its
+// statements while leaving COPY data untouched
+func rewriteRoleScript(dst io.Writer, src io.Reader, roles map[string]string) error {
+	reader := bufio.NewReader(src)
+	writer := bufio.NewWriter(dst)
+	inCopy := false
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			switch {
+			case inCopy:
+				inCopy = strings.TrimRight(line, "\r\n") != `\.`
+			case strings.HasPrefix(line, "COPY ") && strings.HasSuffix(strings.TrimRight(line, "\r\n"), "FROM stdin;"):
+				inCopy = true
+			default:
+				line = RewriteRoles(line, roles)
+			}
+			if _, err := writer.WriteString(line); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return writer.Flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package restore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"db-backuper/internal/provenance"
+)
+
+// TableCount compares the rows of a restored table with the backup's manifest
+type TableCount struct {
+	Table    string `json:"table"`
+	Expected int64  `json:"expected"`
+	Restored int64  `json:"restored"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Truncated returns true if the table lost rows in the restore or could not be counted
+func (c TableCount) Truncated() bool {
+	return c.Error != "" || c.Restored < c.Expected
+}
+
+// RowCountReport lists the tables whose restored row counts differ from the
+// counts recorded when the backup was taken
+type RowCountReport struct {
+	BackupPath    string       `json:"backup_path"`
+	Tables        int          `json:"tables"`
+	Discrepancies []TableCount `json:"discrepancies"`
+}
+
+// CompareRowCounts compares the restored row counts, keyed by schema-qualified
+// table name, with the manifest. failures holds the errors of tables that
+// could not be counted, such as ones missing from the restored database.
+// Tables recorded without an exact row count are left out.
+func CompareRowCounts(manifest []provenance.TableStats, restored map[string]int64, failures map[string]string) *RowCountReport {
+	report := &RowCountReport{Discrepancies: []TableCount{}}
+	for _, table := range manifest {
+		if table.Rows == nil {
+			continue
+		}
+		report.Tables++
+		count := TableCount{Table: table.Name, Expected: *table.Rows, Restored: restored[table.Name], Error: failures[table.Name]}
+		if count.Error != "" || count.Restored != count.Expected {
+			report.Discrepancies = append(report.Discrepancies, count)
+		}
+	}
+	return report
+}
+
+// Truncated returns the tables that lost rows in the restore
+func (r *RowCountReport) Truncated() []TableCount {
+	var truncated []TableCount
+	for _, count := range r.Discrepancies {
+		if count.Truncated() {
+			truncated = append(truncated, count)
+		}
+	}
+	return truncated
+}
+
+// write saves the report as JSON
+func (r *RowCountReport) write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode row count report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write row count report: %w", err)
+	}
+	return nil
+}
+
+// checkRowCounts counts the rows of every table in the manifest saved next
+// to the backup and fails the import when a table came back with fewer rows
+// than were backed up. Tables with more rows, such as ones restored into a
+// database that already held data, are only reported.
+func (pi *PostgresImport) checkRowCounts() error {
+	meta, err := provenance.ReadSidecar(pi.config.BackupPath)
+	if err != nil {
+		return err
+	}
+	if meta == nil || !slices.ContainsFunc(meta.Tables, func(table provenance.TableStats) bool { return table.Rows != nil }) {
+		pi.logger.Debug("Backup has no row counts in its manifest, skipping the row count check")
+		return nil
+	}
+
+	password, err := pi.password()
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open("postgres", fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		pi.config.TargetDatabase.Host,
+		pi.config.TargetDatabase.Port,
+		pi.config.TargetDatabase.Username,
+		password,
+		pi.config.TargetDatabase.Database,
+		pi.config.TargetDatabase.SSLMode))
+	if err != nil {
+		return fmt.Errorf("failed to open database connection: %w", err)
+	}
+	defer db.Close()
+
+	restored := make(map[string]int64, len(meta.Tables))
+	failures := make(map[string]string)
+	for _, table := range meta.Tables {
+		if table.Rows == nil {
+			continue
+		}
+		schema, name, _ := strings.Cut(table.Name, ".")
+		var count int64
+		if err := db.QueryRow("SELECT count(*) FROM " + quoteIdentifier(schema) + "." + quoteIdentifier(name)).Scan(&count); err != nil {
+			failures[table.Name] = err.Error()
+			continue
+		}
+		restored[table.Name] = count
+	}
+
+	report := CompareRowCounts(meta.Tables, restored, failures)
+	report.BackupPath = pi.config.BackupPath
+	if pi.config.RowCountReport != "" {
+		if err := report.write(pi.config.RowCountReport); err != nil {
+			return err
+		}
+		pi.logger.Infof("Wrote row count report to %s", pi.config.RowCountReport)
+	}
+
+	for _, count := range report.Discrepancies {
+		if count.Error != "" {
+			pi.logger.Warnf("Table %s (%d rows backed up) could not be counted: %s", count.Table, count.Expected, count.Error)
+		} else {
+			pi.logger.Warnf("Table %s has %d rows, %d were backed up", count.Table, count.Restored, count.Expected)
+		}
+	}
+	if truncated := report.Truncated(); len(truncated) > 0 {
+		return fmt.Errorf("%d of %d tables have fewer rows than were backed up", len(truncated), report.Tables)
+	}
+	pi.logger.Infof("Row counts of %d tables match the backup manifest", report.Tables-len(report.Discrepancies))
+	return nil
+}
@@ -0,0 +1,481 @@
+package restore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"db-backuper/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TableSubset is the number of rows of a table before and after it was reduced
+type TableSubset struct {
+	Table  string `json:"table"`
+	Before int64  `json:"rows_before"`
+	After  int64  `json:"rows_after"`
+}
+
+// ForeignKey is a foreign key between two tables, named as regclass text so
+// they can be used in queries as they are
+type ForeignKey struct {
+	Table      string
+	Columns    []string
+	RefTable   string
+	RefColumns []string
+}
+
+// matches returns the condition joining a row c of the referencing table to
+// the row p of the referenced table it references
+func (k ForeignKey) matches() string {
+	matches := make([]string, len(k.Columns))
+	for i, column := range k.Columns {
+		matches[i] = "p." + k.RefColumns[i] + " = c." + column
+	}
+	return strings.Join(matches, " AND ")
+}
+
+// SampleRows keeps percent of the rows of every table of db, chosen at random.
+// Tables are sampled parents first, and rows referencing rows removed from a
+// parent are dropped before a table is sampled, so every foreign key still
+// holds afterwards. Child tables keep fewer rows when their parents lost the
+// rows they referenced.
+func SampleRows(ctx context.Context, db *sql.DB, percent float64, logger logrus.FieldLogger) ([]TableSubset, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin sampling transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Foreign key triggers would refuse deleting referenced rows; the
+	// references are kept intact by removing orphans instead
+	if _, err := tx.ExecContext(ctx, "SET LOCAL session_replication_role = replica"); err != nil {
+		return nil, fmt.Errorf("failed to disable foreign key triggers: %w", err)
+	}
+	tables, err := readTables(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := readForeignKeys(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	subsets := make([]TableSubset, 0, len(tables))
+	for _, table := range ParentsFirst(tables, keys) {
+		before, err := countRows(ctx, tx, table)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if key.Table == table {
+				if _, err := deleteOrphans(ctx, tx, key); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		// ctid is only unique within a partition, so rows are identified with their tableoid
+		keep := SampleSize(before, percent)
+		query := fmt.Sprintf("DELETE FROM %[1]s WHERE (tableoid, ctid) IN (SELECT tableoid, ctid FROM %[1]s ORDER BY random() OFFSET $1)", table)
+		if _, err := tx.ExecContext(ctx, query, keep); err != nil {
+			return nil, fmt.Errorf("failed to sample %s: %w", table, err)
+		}
+		subsets = append(subsets, TableSubset{Table: table, Before: before})
+	}
+
+	// Tables referencing each other, or themselves, can only be made
+	// consistent once all of them are sampled
+	if err := removeOrphans(ctx, tx, keys); err != nil {
+		return nil, err
+	}
+	for i := range subsets {
+		if subsets[i].After, err = countRows(ctx, tx, subsets[i].Table); err != nil {
+			return nil, err
+		}
+		logger.Debugf("Kept %d of %d rows of %s", subsets[i].After, subsets[i].Before, subsets[i].Table)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit sampled rows: %w", err)
+	}
+	return subsets, nil
+}
+
+// Subset reduces db to the rows selected by rules. The rows of the root
+// tables are kept first and, with FollowChildren, the rows referencing them,
+// table by table away from the roots. Every row a kept row references is then
+// kept as well, so every foreign key still holds. Tables no rule reaches are
+// emptied.
+func Subset(ctx context.Context, db *sql.DB, rules *config.SubsetConfig, logger logrus.FieldLogger) ([]TableSubset, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin subset transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SET LOCAL session_replication_role = replica"); err != nil {
+		return nil, fmt.Errorf("failed to disable foreign key triggers: %w", err)
+	}
+	tables, err := readTables(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := readForeignKeys(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The kept rows of every table are collected in a temporary table. ctid
+	// is only unique within a partition, so rows are identified with their
+	// tableoid as well.
+	kept := make(map[string]string, len(tables))
+	for i, table := range tables {
+		name := fmt.Sprintf("subset_keep_%d", i)
+		if _, err := tx.ExecContext(ctx, "CREATE TEMP TABLE "+name+" (rel oid, row_id tid, PRIMARY KEY (rel, row_id)) ON COMMIT DROP"); err != nil {
+			return nil, fmt.Errorf("failed to prepare subset: %w", err)
+		}
+		kept[table] = name
+	}
+	resolve := func(name string) (string, error) {
+		var table sql.NullString
+		if err := tx.QueryRowContext(ctx, "SELECT to_regclass($1)::text", name).Scan(&table); err != nil {
+			return "", fmt.Errorf("failed to look up subset table %s: %w", name, err)
+		}
+		if _, ok := kept[table.String]; !table.Valid || !ok {
+			return "", fmt.Errorf("unknown subset table %s", name)
+		}
+		return table.String, nil
+	}
+
+	for _, name := range rules.KeepTables {
+		table, err := resolve(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := keepRows(ctx, tx, kept[table], table, "", 0); err != nil {
+			return nil, err
+		}
+	}
+	var roots []string
+	for _, root := range rules.Roots {
+		table, err := resolve(root.Table)
+		if err != nil {
+			return nil, err
+		}
+		n, err := keepRows(ctx, tx, kept[table], table, root.Where, root.Limit)
+		if err != nil {
+			return nil, err
+		}
+		logger.Infof("Selected %d rows of %s", n, table)
+		roots = append(roots, table)
+	}
+	if rules.FollowChildren {
+		if err := keepChildren(ctx, tx, keys, kept, roots, rules.ChildLimit); err != nil {
+			return nil, err
+		}
+	}
+	if err := keepReferenced(ctx, tx, keys, kept); err != nil {
+		return nil, err
+	}
+
+	subsets := make([]TableSubset, 0, len(tables))
+	for _, table := range tables {
+		before, err := countRows(ctx, tx, table)
+		if err != nil {
+			return nil, err
+		}
+		query := fmt.Sprintf("DELETE FROM %s AS c WHERE NOT EXISTS (%s)", table, keptRow(kept[table], "c"))
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return nil, fmt.Errorf("failed to remove rows of %s outside the subset: %w", table, err)
+		}
+		after, err := countRows(ctx, tx, table)
+		if err != nil {
+			return nil, err
+		}
+		logger.Debugf("Kept %d of %d rows of %s", after, before, table)
+		subsets = append(subsets, TableSubset{Table: table, Before: before, After: after})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit subset: %w", err)
+	}
+	return subsets, nil
+}
+
+// keepRows adds the rows of table matching where, at most limit of them
+// when limit is positive, to the kept rows in keep
+func keepRows(ctx context.Context, tx *sql.Tx, keep, table, where string, limit int) (int64, error) {
+	query := "INSERT INTO " + keep + " SELECT tableoid, ctid FROM " + table
+	if where != "" {
+		query += " WHERE (" + where + ")"
+	}
+	if limit > 0 {
+		query += " LIMIT " + strconv.Itoa(limit)
+	}
+	result, err := tx.ExecContext(ctx, query+" ON CONFLICT DO NOTHING")
+	if err != nil {
+		return 0, fmt.Errorf("failed to select rows of %s: %w", table, err)
+	}
+	return result.RowsAffected()
+}
+
+// keepChildren keeps the rows referencing kept rows of the roots, then the
+// rows referencing those, each table at most limit rows when limit is
+// positive. References of a table to itself are not followed.
+func keepChildren(ctx context.Context, tx *sql.Tx, keys []ForeignKey, kept map[string]string, roots []string, limit int) error {
+	visited := make(map[string]bool)
+	for _, root := range roots {
+		visited[root] = true
+	}
+	queue := slices.Clone(roots)
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		for _, key := range keys {
+			if key.RefTable != parent || key.Table == parent || kept[key.Table] == "" {
+				continue
+			}
+			query := fmt.Sprintf(`INSERT INTO %s SELECT c.tableoid, c.ctid FROM %s AS c
+				WHERE NOT EXISTS (%s) AND EXISTS (SELECT 1 FROM %s AS p WHERE %s AND EXISTS (%s))`,
+				kept[key.Table], key.Table, keptRow(kept[key.Table], "c"), parent, key.matches(), keptRow(kept[parent], "p"))
+			if limit > 0 {
+				var count int
+				if err := tx.QueryRowContext(ctx, "SELECT count(*) FROM "+kept[key.Table]).Scan(&count); err != nil {
+					return fmt.Errorf("failed to count kept rows of %s: %w", key.Table, err)
+				}
+				if count >= limit {
+					continue
+				}
+				query += " LIMIT " + strconv.Itoa(limit-count)
+			}
+			if _, err := tx.ExecContext(ctx, query); err != nil {
+				return fmt.Errorf("failed to select rows of %s referencing %s: %w", key.Table, parent, err)
+			}
+			if !visited[key.Table] {
+				visited[key.Table] = true
+				queue = append(queue, key.Table)
+			}
+		}
+	}
+	return nil
+}
+
+// keepReferenced keeps every row referenced by a kept row, until the kept
+// rows reference no row outside them
+func keepReferenced(ctx context.Context, tx *sql.Tx, keys []ForeignKey, kept map[string]string) error {
+	for {
+		var added int64
+		for _, key := range keys {
+			if kept[key.Table] == "" || kept[key.RefTable] == "" {
+				continue
+			}
+			query := fmt.Sprintf(`INSERT INTO %s SELECT p.tableoid, p.ctid FROM %s AS p
+				WHERE NOT EXISTS (%s) AND EXISTS (SELECT 1 FROM %s AS c WHERE %s AND EXISTS (%s))`,
+				kept[key.RefTable], key.RefTable, keptRow(kept[key.RefTable], "p"), key.Table, key.matches(), keptRow(kept[key.Table], "c"))
+			result, err := tx.ExecContext(ctx, query)
+			if err != nil {
+				return fmt.Errorf("failed to select rows of %s referenced by %s: %w", key.RefTable, key.Table, err)
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			added += n
+		}
+		if added == 0 {
+			return nil
+		}
+	}
+}
+
+// keptRow returns a query finding the row alias among the kept rows in keep
+func keptRow(keep, alias string) string {
+	return fmt.Sprintf("SELECT 1 FROM %s AS k WHERE k.rel = %s.tableoid AND k.row_id = %s.ctid", keep, alias, alias)
+}
+
+// readTables returns the user tables of the database. Partitions are left
+// out, since their rows are reached through the partitioned table.
+func readTables(ctx context.Context, tx *sql.Tx) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT c.oid::regclass::text
+		FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p') AND NOT c.relispartition
+			AND n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg_toast%'
+		ORDER BY 1`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tables: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, fmt.Errorf("failed to read tables: %w", err)
+		}
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+// readForeignKeys returns the foreign keys between the user tables
+func readForeignKeys(ctx context.Context, tx *sql.Tx) ([]ForeignKey, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT c.conrelid::regclass::text, c.confrelid::regclass::text,
+			json_agg(quote_ident(a.attname) ORDER BY k.n), json_agg(quote_ident(r.attname) ORDER BY k.n)
+		FROM pg_constraint c
+			CROSS JOIN LATERAL unnest(c.conkey, c.confkey) WITH ORDINALITY AS k(attnum, refattnum, n)
+			JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = k.attnum
+			JOIN pg_attribute r ON r.attrelid = c.confrelid AND r.attnum = k.refattnum
+			JOIN pg_namespace ns ON ns.oid = c.connamespace
+		WHERE c.contype = 'f' AND c.conparentid = 0 AND ns.nspname NOT IN ('pg_catalog', 'information_schema')
+		GROUP BY c.oid, c.conrelid, c.confrelid
+		ORDER BY 1, 2`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read foreign keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []ForeignKey
+	for rows.Next() {
+		var key ForeignKey
+		var columns, refColumns string
+		if err := rows.Scan(&key.Table, &key.RefTable, &columns, &refColumns); err != nil {
+			return nil, fmt.Errorf("failed to read foreign keys: %w", err)
+		}
+		if err := json.Unmarshal([]byte(columns), &key.Columns); err != nil {
+			return nil, fmt.Errorf("failed to read foreign keys: %w", err)
+		}
+		if err := json.Unmarshal([]byte(refColumns), &key.RefColumns); err != nil {
+			return nil, fmt.Errorf("failed to read foreign keys: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// SampleSize returns how many of rows SampleRows keeps for percent. It rounds
+// up, so a table with rows keeps at least one for any percent above zero.
+func SampleSize(rows int64, percent float64) int64 {
+	return int64(math.Ceil(float64(rows) * percent / 100))
+}
+
+// ParentsFirst orders tables so that referenced tables come before the
+// tables referencing them. A reference cycle is broken at its first table
+// in the original order; references of a table to itself are ignored.
+func ParentsFirst(tables []string, keys []ForeignKey) []string {
+	parents := make(map[string]map[string]bool)
+	for _, key := range keys {
+		if key.Table == key.RefTable {
+			continue
+		}
+		if parents[key.Table] == nil {
+			parents[key.Table] = make(map[string]bool)
+		}
+		parents[key.Table][key.RefTable] = true
+	}
+
+	ordered := make([]string, 0, len(tables))
+	placed := make(map[string]bool)
+	// blocked reports whether table waits for a parent among tables not yet placed
+	blocked := func(table string) bool {
+		for parent := range parents[table] {
+			if !placed[parent] && slices.Contains(tables, parent) {
+				return true
+			}
+		}
+		return false
+	}
+	// cyclic reports whether table references itself through tables not yet placed
+	cyclic := func(table string) bool {
+		seen := map[string]bool{}
+		next := []string{table}
+		for len(next) > 0 {
+			current := next[len(next)-1]
+			next = next[:len(next)-1]
+			for parent := range parents[current] {
+				if parent == table {
+					return true
+				}
+				if !seen[parent] && !placed[parent] && slices.Contains(tables, parent) {
+					seen[parent] = true
+					next = append(next, parent)
+				}
+			}
+		}
+		return false
+	}
+	for len(ordered) < len(tables) {
+		progress := false
+		for _, table := range tables {
+			if placed[table] {
+				continue
+			}
+			if !blocked(table) {
+				ordered = append(ordered, table)
+				placed[table] = true
+				progress = true
+			}
+		}
+		if progress {
+			continue
+		}
+		// Every table left waits for another, so at least one is in a cycle
+		for _, table := range tables {
+			if !placed[table] && cyclic(table) {
+				ordered = append(ordered, table)
+				placed[table] = true
+				break
+			}
+		}
+	}
+	return ordered
+}
+
+// removeOrphans deletes rows referencing missing rows until no foreign key
+// is violated
+func removeOrphans(ctx context.Context, tx *sql.Tx, keys []ForeignKey) error {
+	for {
+		var deleted int64
+		for _, key := range keys {
+			n, err := deleteOrphans(ctx, tx, key)
+			if err != nil {
+				return err
+			}
+			deleted += n
+		}
+		if deleted == 0 {
+			return nil
+		}
+	}
+}
+
+// deleteOrphans deletes the rows of a table whose foreign key references a
+// missing row. As in PostgreSQL, a key with a NULL column references nothing.
+func deleteOrphans(ctx context.Context, tx *sql.Tx, key ForeignKey) (int64, error) {
+	var set []string
+	for _, column := range key.Columns {
+		set = append(set, "c."+column+" IS NOT NULL")
+	}
+	query := fmt.Sprintf("DELETE FROM %s AS c WHERE %s AND NOT EXISTS (SELECT 1 FROM %s AS p WHERE %s)",
+		key.Table, strings.Join(set, " AND "), key.RefTable, key.matches())
+	result, err := tx.ExecContext(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to remove rows of %s referencing missing rows of %s: %w", key.Table, key.RefTable, err)
+	}
+	return result.RowsAffected()
+}
+
+// countRows returns the number of rows of a table
+func countRows(ctx context.Context, tx *sql.Tx, table string) (int64, error) {
+	var count int64
+	if err := tx.QueryRowContext(ctx, "SELECT count(*) FROM "+table).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count rows of %s: %w", table, err)
+	}
+	return count, nil
+}
@@ -0,0 +1,160 @@
+package retention
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"db-backuper/internal/storage"
+)
+
+// Policy decides which backups to keep. A backup is kept when any rule keeps
+// it, or when it is held; rules left at zero keep nothing.
+type Policy struct {
+	// Days keeps backups dated within the last Days days, like retention_days
+	Days int `json:"days,omitempty"`
+	// KeepLast keeps the newest backups of each database
+	KeepLast int `json:"keep_last,omitempty"`
+	// KeepDaily, KeepWeekly, KeepMonthly and KeepYearly keep the newest
+	// backup of each of the most recent days, ISO weeks, months and years
+	// that have backups of a database
+	KeepDaily   int `json:"keep_daily,omitempty"`
+	KeepWeekly  int `json:"keep_weekly,omitempty"`
+	KeepMonthly int `json:"keep_monthly,omitempty"`
+	KeepYearly  int `json:"keep_yearly,omitempty"`
+}
+
+// Validate checks that the policy has a rule and no negative counts
+func (p Policy) Validate() error {
+	rules := p.rules()
+	if !slices.ContainsFunc(rules, func(r rule) bool { return r.count != 0 }) {
+		return fmt.Errorf("a retention policy needs days, a count or a GFS rule")
+	}
+	for _, r := range rules {
+		if r.count < 0 {
+			return fmt.Errorf("%s cannot be negative", r.name)
+		}
+	}
+	return nil
+}
+
+// String describes the rules of the policy
+func (p Policy) String() string {
+	var parts []string
+	for _, r := range p.rules() {
+		if r.count > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", r.name, r.count))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// rule is one rule of a policy: keep backups of count periods, or the last
+// count backups when period is nil
+type rule struct {
+	name   string
+	count  int
+	period func(date time.Time) string
+}
+
+// rules returns the count and GFS rules of the policy; Days is applied on its own
+func (p Policy) rules() []rule {
+	return []rule{
+		{name: "days", count: p.Days},
+		{name: "last", count: p.KeepLast},
+		{name: "daily", count: p.KeepDaily, period: func(d time.Time) string { return d.Format(storage.DateLayout) }},
+		{name: "weekly", count: p.KeepWeekly, period: func(d time.Time) string {
+			year, week := d.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{name: "monthly", count: p.KeepMonthly, period: func(d time.Time) string { return d.Format("2006-01") }},
+		{name: "yearly", count: p.KeepYearly, period: func(d time.Time) string { return d.Format("2006") }},
+	}
+}
+
+// Decision is what a policy does with one backup
+type Decision struct {
+	Storage  string `json:"storage"`
+	Key      string `json:"key"`
+	Database string `json:"database"`
+	Date     string `json:"date"`
+	Size     int64  `json:"size"`
+	// StorageClass is the S3 storage class of the backup, if any
+	StorageClass string `json:"storage_class,omitempty"`
+	Delete       bool   `json:"delete"`
+	// KeptBy lists the rules keeping the backup, such as "days 7" or "weekly 2024-W03"
+	KeptBy []string `json:"kept_by,omitempty"`
+}
+
+// Backup is a stored backup a policy is applied to
+type Backup struct {
+	Storage string
+	storage.Entry
+	Held bool
+}
+
+// Simulate applies policy to backups as of now without deleting anything,
+// returning a decision per backup, newest first within each database. Ages
+// are measured like retention cleanup does, by the date directory of a
+// backup, and backups of a database are grouped per storage.
+func Simulate(policy Policy, backups []Backup, now time.Time) []Decision {
+	backups = slices.Clone(backups)
+	slices.SortFunc(backups, func(a, b Backup) int {
+		return cmp.Or(
+			cmp.Compare(a.Storage, b.Storage),
+			cmp.Compare(a.Database, b.Database),
+			b.Date.Compare(a.Date),
+			cmp.Compare(b.Key, a.Key),
+		)
+	})
+
+	cutoff := now.AddDate(0, 0, -policy.Days)
+	decisions := make([]Decision, 0, len(backups))
+	var seen map[string]map[string]bool
+	var kept map[string]int
+	for i, backup := range backups {
+		if i == 0 || backup.Storage != backups[i-1].Storage || backup.Database != backups[i-1].Database {
+			seen = make(map[string]map[string]bool)
+			kept = make(map[string]int)
+		}
+		decision := Decision{
+			Storage:      backup.Storage,
+			Key:          backup.Key,
+			Database:     backup.Database,
+			Date:         backup.Date.Format(storage.DateLayout),
+			Size:         backup.Size,
+			StorageClass: backup.StorageClass,
+		}
+		if backup.Held {
+			decision.KeptBy = append(decision.KeptBy, "hold")
+		}
+		if policy.Days > 0 && !backup.Date.Before(cutoff) {
+			decision.KeptBy = append(decision.KeptBy, fmt.Sprintf("days %d", policy.Days))
+		}
+		for _, r := range policy.rules()[1:] {
+			if r.count <= 0 {
+				continue
+			}
+			if r.period == nil {
+				if kept[r.name] < r.count {
+					kept[r.name]++
+					decision.KeptBy = append(decision.KeptBy, fmt.Sprintf("last %d", r.count))
+				}
+				continue
+			}
+			period := r.period(backup.Date)
+			if seen[r.name] == nil {
+				seen[r.name] = make(map[string]bool)
+			}
+			if !seen[r.name][period] && len(seen[r.name]) < r.count {
+				seen[r.name][period] = true
+				decision.KeptBy = append(decision.KeptBy, r.name+" "+period)
+			}
+		}
+		decision.Delete = len(decision.KeptBy) == 0
+		decisions = append(decisions, decision)
+	}
+	return decisions
+}
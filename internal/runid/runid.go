@@ -0,0 +1,84 @@
+package runid
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+)
+
+// crockford is the Crockford base32 alphabet used by ULIDs
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// New returns a new ULID: a 26 character, lexically sortable identifier made of
+// a millisecond timestamp followed by 80 random bits
+func New() string {
+	return NewAt(time.Now())
+}
+
+// NewAt returns a new ULID for the given time
+func NewAt(t time.Time) string {
+	var id [16]byte
+
+	ms := uint64(t.UnixMilli())
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], ms)
+	copy(id[:6], ts[2:])
+
+	if _, err := rand.Read(id[6:]); err != nil {
+		// crypto/rand never fails on supported platforms; fall back to the clock
+		binary.BigEndian.PutUint64(id[8:], uint64(t.UnixNano()))
+	}
+
+	return encode(id)
+}
+
+// Time returns the timestamp encoded in a ULID
+func Time(id string) (time.Time, bool) {
+	if len(id) != 26 {
+		return time.Time{}, false
+	}
+
+	var ms uint64
+	for i := 0; i < 10; i++ {
+		v := indexOf(id[i])
+		if v < 0 {
+			return time.Time{}, false
+		}
+		ms = ms<<5 | uint64(v)
+	}
+	return time.UnixMilli(int64(ms)), true
+}
+
+// encode encodes 128 bits as 26 base32 characters
+func encode(id [16]byte) string {
+	out := make([]byte, 26)
+
+	// The 128 bit value is padded with two leading zero bits to 130 bits
+	var bitBuf uint64
+	var bits uint
+	pos := 0
+
+	bitBuf = 0
+	bits = 2
+	for _, b := range id {
+		bitBuf = bitBuf<<8 | uint64(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[pos] = crockford[(bitBuf>>bits)&0x1f]
+			pos++
+		}
+	}
+
+	return string(out)
+}
+
+// indexOf returns the value of a base32 character or -1
+func indexOf(c byte) int {
+	for i := 0; i < len(crockford); i++ {
+		if crockford[i] == c {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,240 @@
+// Package runstate persists the state of scheduler mode across restarts
+package runstate
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"db-backuper/internal/status"
+)
+
+// FileName is the name of the state file within the state directory
+const FileName = "scheduler.json"
+
+// MaxRuns is how many runs the run history keeps
+const MaxRuns = 100
+
+// RunRecord is one backup run in the run history
+type RunRecord struct {
+	RunID      string    `json:"run_id"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Successful int       `json:"successful"`
+	Failed     int       `json:"failed"`
+	Skipped    int       `json:"skipped"`
+	// FailedDatabases lists the databases that failed or were skipped
+	FailedDatabases []string `json:"failed_databases,omitempty"`
+	ObjectsDeleted  int      `json:"objects_deleted"`
+}
+
+// DatabaseState is what the scheduler remembers about one database
+type DatabaseState struct {
+	LastRunAt  time.Time `json:"last_run_at"`
+	LastStatus string    `json:"last_status"`
+	// LastSuccessAt and LastSuccessStartedAt are when the last successful
+	// backup finished and its recovery point
+	LastSuccessAt        *time.Time `json:"last_success_at,omitempty"`
+	LastSuccessStartedAt *time.Time `json:"last_success_started_at,omitempty"`
+	// ConsecutiveFailures counts the runs in a row the database failed
+	ConsecutiveFailures int `json:"consecutive_failures"`
+}
+
+// document is the layout of the state file
+type document struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	// LastRunAt is when the last run started, whatever triggered it
+	LastRunAt time.Time `json:"last_run_at,omitzero"`
+	// NextRunAt is the next scheduled run known when the state was saved
+	NextRunAt time.Time                `json:"next_run_at,omitzero"`
+	Databases map[string]DatabaseState `json:"databases"`
+	// SLAViolations lists the SLA violations already alerted on
+	SLAViolations []string `json:"sla_violations,omitempty"`
+	// Runs holds the most recent runs, oldest first
+	Runs []RunRecord `json:"runs,omitempty"`
+}
+
+// Store holds the scheduler state and writes it back to its file on every
+// change. It is safe for concurrent use.
+type Store struct {
+	path string
+
+	mu  sync.Mutex
+	doc document
+}
+
+// Open loads the state file at path, starting afresh when there is none
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, doc: document{Databases: make(map[string]DatabaseState)}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scheduler state: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.doc); err != nil {
+		return nil, fmt.Errorf("failed to parse scheduler state %s: %w", path, err)
+	}
+	if s.doc.Databases == nil {
+		s.doc.Databases = make(map[string]DatabaseState)
+	}
+	return s, nil
+}
+
+// RecordRun updates the state of every database in the run
+func (s *Store) RecordRun(summary *status.RunSummary) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.doc.LastRunAt = summary.StartedAt
+	for _, result := range summary.Databases {
+		db := s.doc.Databases[result.Database]
+		db.LastRunAt = result.FinishedAt
+		db.LastStatus = result.Status
+		if result.Status == status.ResultSuccess {
+			startedAt, finishedAt := result.StartedAt, result.FinishedAt
+			db.LastSuccessAt = &finishedAt
+			db.LastSuccessStartedAt = &startedAt
+			db.ConsecutiveFailures = 0
+		} else {
+			db.ConsecutiveFailures++
+		}
+		s.doc.Databases[result.Database] = db
+	}
+
+	record := RunRecord{
+		RunID:          summary.RunID,
+		StartedAt:      summary.StartedAt,
+		FinishedAt:     summary.FinishedAt,
+		Successful:     summary.Successful,
+		Failed:         summary.Failed,
+		Skipped:        summary.Skipped,
+		ObjectsDeleted: summary.ObjectsDeleted,
+	}
+	for _, result := range summary.Databases {
+		if result.Status != status.ResultSuccess {
+			record.FailedDatabases = append(record.FailedDatabases, result.Database)
+		}
+	}
+	s.doc.Runs = append(s.doc.Runs, record)
+	if len(s.doc.Runs) > MaxRuns {
+		s.doc.Runs = s.doc.Runs[len(s.doc.Runs)-MaxRuns:]
+	}
+	return s.save()
+}
+
+// Runs returns the run history, oldest first
+func (s *Store) Runs() []RunRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.doc.Runs)
+}
+
+// SetNextRun records when the next scheduled run is due
+func (s *Store) SetNextRun(next time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.doc.NextRunAt = next
+	return s.save()
+}
+
+// Missed returns the scheduled run that was due before now but never
+// started, such as one that fell while the service was stopped
+func (s *Store) Missed(now time.Time) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := s.doc.NextRunAt
+	if next.IsZero() || !next.Before(now) || !s.doc.LastRunAt.Before(next) {
+		return time.Time{}, false
+	}
+	return next, true
+}
+
+// Database returns the state of a database
+func (s *Store) Database(name string) (DatabaseState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db, ok := s.doc.Databases[name]
+	return db, ok
+}
+
+// Report returns the last results of the databases as a status report, or
+// nil when no run has been recorded
+func (s *Store) Report() *status.Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.doc.Databases) == 0 {
+		return nil
+	}
+
+	report := &status.Report{SchemaVersion: status.SchemaVersion, UpdatedAt: s.doc.UpdatedAt}
+	for name, db := range s.doc.Databases {
+		report.Databases = append(report.Databases, status.DatabaseResult{
+			Database:             name,
+			Status:               db.LastStatus,
+			FinishedAt:           db.LastRunAt,
+			LastSuccessAt:        db.LastSuccessAt,
+			LastSuccessStartedAt: db.LastSuccessStartedAt,
+		})
+	}
+	sort.Slice(report.Databases, func(i, j int) bool {
+		return report.Databases[i].Database < report.Databases[j].Database
+	})
+	return report
+}
+
+// SLAViolations returns the SLA violations already alerted on
+func (s *Store) SLAViolations() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.doc.SLAViolations)
+}
+
+// SetSLAViolations records the SLA violations alerted on, writing the state
+// file only when they changed
+func (s *Store) SetSLAViolations(keys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys = slices.Sorted(slices.Values(keys))
+	if slices.Equal(keys, s.doc.SLAViolations) {
+		return nil
+	}
+	s.doc.SLAViolations = keys
+	return s.save()
+}
+
+// save writes the state file through a temporary file in the same
+// directory, so readers and other writers never see a partial document
+func (s *Store) save() error {
+	s.doc.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(s.doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode scheduler state: %w", err)
+	}
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create scheduler state directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, FileName+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write scheduler state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write scheduler state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write scheduler state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace scheduler state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package s3
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"db-backuper/internal/progress"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// defaultDownloadChunkSize is the size of the ranges large objects are
+// downloaded in; objects no larger than one chunk are downloaded in a
+// single stream
+const defaultDownloadChunkSize = 64 * 1024 * 1024
+
+// downloadChunkRetries is how many times a failed range is requested again
+// before the download stops
+const downloadChunkRetries = 5
+
+// downloadStateSuffix marks the file next to a partial download recording
+// the ranges already downloaded
+const downloadStateSuffix = ".json"
+
+// downloadState records the ranges of an object already written to a
+// partial download, so that running the download again resumes it
+type downloadState struct {
+	ETag      string  `json:"etag"`
+	Size      int64   `json:"size"`
+	ChunkSize int64   `json:"chunk_size"`
+	Done      []int64 `json:"done"`
+
+	path string
+}
+
+// downloadRanged downloads a large object in ranges, transferJobs at a time,
+// into destPath. Each range is retried on its own, and ranges already
+// written by an earlier attempt at the same destPath are skipped as long as
+// the object is unchanged.
+func (s *S3Manager) downloadRanged(key, destPath string, head *s3.HeadObjectOutput, customerKey string) error {
+	tmpPath := destPath + ".part"
+	size, etag := aws.Int64Value(head.ContentLength), aws.StringValue(head.ETag)
+	state := loadDownloadState(tmpPath+downloadStateSuffix, etag, size, s.chunkSize())
+
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmpPath, err)
+	}
+	if len(state.Done) == 0 {
+		if err := file.Truncate(0); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to clear %s: %w", tmpPath, err)
+		}
+	}
+
+	done := make(map[int64]bool, len(state.Done))
+	for _, chunk := range state.Done {
+		done[chunk] = true
+	}
+	chunks := (size + state.ChunkSize - 1) / state.ChunkSize
+	if len(done) > 0 {
+		s.logger.Infof("Resuming download of s3://%s/%s: %d of %d chunks already downloaded", s.config.Bucket, key, len(done), chunks)
+	} else {
+		s.logger.Infof("Downloading s3://%s/%s (%s) in %d chunks to %s", s.config.Bucket, key, progress.FormatBytes(size), chunks, destPath)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	slots := make(chan struct{}, cmp.Or(s.transferJobs, resumableConcurrency))
+	for chunk := int64(0); chunk < chunks; chunk++ {
+		if done[chunk] {
+			continue
+		}
+		slots <- struct{}{}
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			<-slots
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			start := chunk * state.ChunkSize
+			end := min(start+state.ChunkSize, size) - 1
+			err := s.downloadChunk(key, etag, customerKey, file, start, end)
+			// Flush the range before recording it, so that after a crash the
+			// state never lists a range the file lost
+			if err == nil {
+				if err = file.Sync(); err != nil {
+					err = fmt.Errorf("failed to flush %s: %w", tmpPath, err)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			state.Done = append(state.Done, chunk)
+			if err := state.save(); err != nil {
+				s.logger.Warnf("Failed to record download progress: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	closeErr := file.Close()
+	if firstErr != nil {
+		return fmt.Errorf("failed to download s3://%s/%s after %d of %d chunks, run the download again to resume it: %w",
+			s.config.Bucket, key, len(state.Done), chunks, firstErr)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to write %s: %w", tmpPath, closeErr)
+	}
+
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		return fmt.Errorf("failed to move download into place: %w", err)
+	}
+	os.Remove(state.path)
+	s.logger.Infof("Downloaded %d bytes to %s", size, destPath)
+	return nil
+}
+
+// downloadChunk writes the bytes start to end of the object to file,
+// requesting the range again when the transfer fails
+func (s *S3Manager) downloadChunk(key, etag, customerKey string, file *os.File, start, end int64) error {
+	algorithm, sseKey := sseFor(customerKey)
+	var err error
+	for attempt := 1; attempt <= downloadChunkRetries; attempt++ {
+		var output *s3.GetObjectOutput
+		output, err = s.s3.GetObject(&s3.GetObjectInput{
+			Bucket:               aws.String(s.config.Bucket),
+			Key:                  aws.String(key),
+			Range:                aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+			IfMatch:              aws.String(etag),
+			SSECustomerAlgorithm: algorithm,
+			SSECustomerKey:       sseKey,
+		})
+		if err == nil {
+			var n int64
+			n, err = io.Copy(io.NewOffsetWriter(file, start), output.Body)
+			output.Body.Close()
+			if err == nil && n != end-start+1 {
+				err = fmt.Errorf("received %d of %d bytes", n, end-start+1)
+			}
+			if err == nil {
+				return nil
+			}
+		}
+		if !isRetryableDownload(err) || attempt == downloadChunkRetries {
+			break
+		}
+		s.logger.Warnf("Failed to download bytes %d-%d of %s (attempt %d of %d): %v", start, end, key, attempt, downloadChunkRetries, err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+	return fmt.Errorf("bytes %d-%d: %w", start, end, err)
+}
+
+// isRetryableDownload reports whether requesting a range again may succeed.
+// A changed, missing or forbidden object fails the same way every time.
+func isRetryableDownload(err error) bool {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		switch awsErr.Code() {
+		case "PreconditionFailed", s3.ErrCodeNoSuchKey, "AccessDenied":
+			return false
+		}
+	}
+	return true
+}
+
+// chunkSize returns the size of the ranges large objects are downloaded in
+func (s *S3Manager) chunkSize() int64 {
+	return cmp.Or(s.downloadChunkSize, defaultDownloadChunkSize)
+}
+
+// loadDownloadState reads the progress of an earlier download of an object
+// with etag and size in chunks of chunkSize from path. Progress of a
+// different object or a different chunk size is discarded.
+func loadDownloadState(path, etag string, size, chunkSize int64) *downloadState {
+	fresh := &downloadState{ETag: etag, Size: size, ChunkSize: chunkSize, path: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fresh
+	}
+	var state downloadState
+	if err := json.Unmarshal(data, &state); err != nil || state.ETag != etag || state.Size != size || state.ChunkSize != chunkSize {
+		return fresh
+	}
+	state.path = path
+	return &state
+}
+
+// save writes the download state next to the partial download
+func (d *downloadState) save() error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(d.path, data, 0644)
+}
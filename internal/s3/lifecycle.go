@@ -0,0 +1,149 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"db-backuper/internal/config"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// LifecycleRuleIDPrefix starts the IDs of the lifecycle rules managed by
+// apply-lifecycle, followed by the prefix a rule covers
+const LifecycleRuleIDPrefix = "db-backuper:"
+
+// Changes made to a managed lifecycle rule
+const (
+	LifecycleCreate    = "create"
+	LifecycleUpdate    = "update"
+	LifecycleDelete    = "delete"
+	LifecycleUnchanged = "unchanged"
+)
+
+// LifecycleChange is what applying the configured lifecycle does to one rule
+type LifecycleChange struct {
+	ID     string
+	Prefix string
+	Action string
+}
+
+// LifecycleRules returns the managed rules applying lifecycle to the
+// backups under each of prefixes, such as "postgres-backup/orders/"
+func LifecycleRules(prefixes []string, lifecycle config.LifecycleConfig) []*s3.LifecycleRule {
+	if !lifecycle.Enabled() {
+		return nil
+	}
+	var rules []*s3.LifecycleRule
+	for _, prefix := range prefixes {
+		rule := &s3.LifecycleRule{
+			ID:     aws.String(LifecycleRuleIDPrefix + prefix),
+			Status: aws.String(s3.ExpirationStatusEnabled),
+			Filter: &s3.LifecycleRuleFilter{Prefix: aws.String(prefix)},
+		}
+		for _, transition := range lifecycle.Transitions {
+			rule.Transitions = append(rule.Transitions, &s3.Transition{
+				Days:         aws.Int64(int64(transition.Days)),
+				StorageClass: aws.String(transition.StorageClass),
+			})
+		}
+		if lifecycle.ExpirationDays > 0 {
+			rule.Expiration = &s3.LifecycleExpiration{Days: aws.Int64(int64(lifecycle.ExpirationDays))}
+		}
+		if lifecycle.NoncurrentDays > 0 {
+			rule.NoncurrentVersionExpiration = &s3.NoncurrentVersionExpiration{NoncurrentDays: aws.Int64(int64(lifecycle.NoncurrentDays))}
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// MergeLifecycleRules replaces the managed rules of existing that cover one
+// of managedPrefixes with desired, keeping every other rule of the bucket,
+// and returns the merged rules with the change made to each managed rule
+func MergeLifecycleRules(existing, desired []*s3.LifecycleRule, managedPrefixes []string) ([]*s3.LifecycleRule, []LifecycleChange) {
+	wanted := make(map[string]*s3.LifecycleRule, len(desired))
+	for _, rule := range desired {
+		wanted[aws.StringValue(rule.ID)] = rule
+	}
+
+	var merged []*s3.LifecycleRule
+	var changes []LifecycleChange
+	found := make(map[string]bool)
+	for _, rule := range existing {
+		id := aws.StringValue(rule.ID)
+		if replacement, ok := wanted[id]; ok {
+			found[id] = true
+			action := LifecycleUpdate
+			if replacement.String() == rule.String() {
+				action = LifecycleUnchanged
+			}
+			merged = append(merged, replacement)
+			changes = append(changes, LifecycleChange{ID: id, Prefix: aws.StringValue(replacement.Filter.Prefix), Action: action})
+			continue
+		}
+		if managedRule(id, managedPrefixes) {
+			changes = append(changes, LifecycleChange{ID: id, Prefix: strings.TrimPrefix(id, LifecycleRuleIDPrefix), Action: LifecycleDelete})
+			continue
+		}
+		merged = append(merged, rule)
+	}
+	for _, rule := range desired {
+		id := aws.StringValue(rule.ID)
+		if !found[id] {
+			merged = append(merged, rule)
+			changes = append(changes, LifecycleChange{ID: id, Prefix: aws.StringValue(rule.Filter.Prefix), Action: LifecycleCreate})
+		}
+	}
+	return merged, changes
+}
+
+// managedRule reports whether a rule ID is one apply-lifecycle manages under
+// one of prefixes, leaving the rules of other deployments sharing the bucket
+func managedRule(id string, prefixes []string) bool {
+	covered, ok := strings.CutPrefix(id, LifecycleRuleIDPrefix)
+	return ok && slices.ContainsFunc(prefixes, func(prefix string) bool { return strings.HasPrefix(covered, prefix) })
+}
+
+// ApplyLifecycle makes the managed lifecycle rules under managedPrefixes of
+// the bucket match rules, keeping its other rules. With dryRun the changes
+// are only returned.
+func (s *S3Manager) ApplyLifecycle(rules []*s3.LifecycleRule, managedPrefixes []string, dryRun bool) ([]LifecycleChange, error) {
+	current, err := s.s3.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(s.config.Bucket),
+	})
+	var aerr awserr.Error
+	if err != nil && !(errors.As(err, &aerr) && aerr.Code() == "NoSuchLifecycleConfiguration") {
+		return nil, fmt.Errorf("failed to read the lifecycle rules of s3://%s: %w", s.config.Bucket, err)
+	}
+	var existing []*s3.LifecycleRule
+	if current != nil {
+		existing = current.Rules
+	}
+
+	merged, changes := MergeLifecycleRules(existing, rules, managedPrefixes)
+	modified := slices.ContainsFunc(changes, func(change LifecycleChange) bool { return change.Action != LifecycleUnchanged })
+	if dryRun || !modified {
+		return changes, nil
+	}
+
+	// A lifecycle configuration needs a rule, so the last one is removed by
+	// deleting the configuration
+	if len(merged) == 0 {
+		_, err = s.s3.DeleteBucketLifecycle(&s3.DeleteBucketLifecycleInput{Bucket: aws.String(s.config.Bucket)})
+	} else {
+		_, err = s.s3.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+			Bucket:                 aws.String(s.config.Bucket),
+			LifecycleConfiguration: &s3.BucketLifecycleConfiguration{Rules: merged},
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update the lifecycle rules of s3://%s: %w", s.config.Bucket, err)
+	}
+	s.logger.Infof("Updated the lifecycle rules of s3://%s", s.config.Bucket)
+	return changes, nil
+}
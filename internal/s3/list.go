@@ -0,0 +1,96 @@
+package s3
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"db-backuper/internal/provenance"
+	"db-backuper/internal/storage"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// rateLimiter spaces requests evenly so listings of large buckets stay
+// below S3's request rate limits. A nil limiter does not wait.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newRateLimiter allows perSecond requests per second
+func newRateLimiter(perSecond int) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Second / time.Duration(perSecond)}
+}
+
+// wait blocks until the next request may be made
+func (r *rateLimiter) wait() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if r.next.After(now) {
+		time.Sleep(r.next.Sub(now))
+		now = r.next
+	}
+	r.next = now.Add(r.interval)
+}
+
+// ListBackups returns one page of the backups selected by query, in key order
+func (s *S3Manager) ListBackups(query storage.ListQuery) (*storage.ListPage, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(s.config.Bucket),
+		Prefix:  aws.String(query.KeyPrefix()),
+		MaxKeys: aws.Int64(int64(query.Limit())),
+	}
+	if query.PageToken != "" {
+		input.ContinuationToken = aws.String(query.PageToken)
+	} else if query.Database != "" && !query.Since.IsZero() {
+		// Date directories sort chronologically, so skip straight to the first day
+		input.StartAfter = aws.String(query.KeyPrefix() + query.Since.Format(storage.DateLayout))
+	}
+
+	page := &storage.ListPage{}
+	for {
+		s.limiter.wait()
+		output, err := s.s3.ListObjectsV2(input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list backups in %s: %w", s.Location(), err)
+		}
+
+		for _, obj := range output.Contents {
+			key := aws.StringValue(obj.Key)
+			if query.PastUntil(key) {
+				return page, nil
+			}
+			entry, ok := query.Match(key)
+			if !ok || provenance.IsSidecar(key) {
+				continue
+			}
+			entry.Size = aws.Int64Value(obj.Size)
+			entry.LastModified = aws.TimeValue(obj.LastModified)
+			entry.StorageClass = aws.StringValue(obj.StorageClass)
+			page.Entries = append(page.Entries, entry)
+		}
+
+		if !aws.BoolValue(output.IsTruncated) {
+			return page, nil
+		}
+		if len(page.Entries) > 0 {
+			page.NextPageToken = aws.StringValue(output.NextContinuationToken)
+			return page, nil
+		}
+
+		// Every key of this batch was filtered out; keep going rather than return an empty page
+		input.ContinuationToken = output.NextContinuationToken
+		input.StartAfter = nil
+	}
+}
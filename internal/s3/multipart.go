@@ -0,0 +1,418 @@
+package s3
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"db-backuper/internal/audit"
+	"db-backuper/internal/provenance"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// minResumablePartSize is the smallest part of a resumable upload; files no
+// larger than one part are uploaded in a single request
+const minResumablePartSize = 16 * 1024 * 1024
+
+// resumableConcurrency is the number of parts uploaded at once
+const resumableConcurrency = 4
+
+// uploadState records an in-progress multipart upload so that it can be
+// resumed, or aborted, after the process dies
+type uploadState struct {
+	UploadID  string    `json:"upload_id"`
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	LocalPath string    `json:"local_path"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mod_time"`
+	PartSize  int64     `json:"part_size"`
+	StartedAt time.Time `json:"started_at"`
+
+	path string
+}
+
+// SetUploadStateDir tracks multipart uploads in dir so that uploads cut short
+// by a crash are resumed by ResumeUploads on the next start
+func (s *S3Manager) SetUploadStateDir(dir string) {
+	s.uploadStateDir = dir
+}
+
+// partSizeFor returns the part size for a file, growing past the minimum so
+// that the largest files stay within S3's part limit
+func partSizeFor(size int64) int64 {
+	partSize := int64(minResumablePartSize)
+	if needed := (size + s3manager.MaxUploadParts - 1) / s3manager.MaxUploadParts; needed > partSize {
+		partSize = needed
+	}
+	return partSize
+}
+
+// uploadResumable uploads a large file part by part, recording the upload in
+// the state directory until it completes. It reports false when the file is
+// small enough for a single request.
+func (s *S3Manager) uploadResumable(localPath, key string, meta *provenance.Metadata) (bool, error) {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return true, fmt.Errorf("failed to stat %s: %w", localPath, err)
+	}
+	partSize := partSizeFor(info.Size())
+	if info.Size() <= partSize {
+		return false, nil
+	}
+
+	input := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(key),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey = s.sse()
+	if meta != nil {
+		input.Metadata = aws.StringMap(meta.Headers())
+	}
+	created, err := s.s3.CreateMultipartUpload(input)
+	if err != nil {
+		return true, fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+
+	state := &uploadState{
+		UploadID:  aws.StringValue(created.UploadId),
+		Bucket:    s.config.Bucket,
+		Key:       key,
+		LocalPath: localPath,
+		Size:      info.Size(),
+		ModTime:   info.ModTime(),
+		PartSize:  partSize,
+		StartedAt: time.Now(),
+		path:      filepath.Join(s.uploadStateDir, stateFileName(s.config.Bucket, key)),
+	}
+	if err := state.save(); err != nil {
+		s.abortUpload(state)
+		return true, err
+	}
+
+	if err := s.uploadParts(state, nil); err != nil {
+		// Errors within a run are not resumed: the backup file is about to be removed
+		s.abortUpload(state)
+		state.remove()
+		return true, err
+	}
+	state.remove()
+	return true, nil
+}
+
+// uploadParts uploads every part not already in done and completes the upload
+func (s *S3Manager) uploadParts(state *uploadState, done map[int64]*s3.CompletedPart) error {
+	file, err := os.Open(state.LocalPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", state.LocalPath, err)
+	}
+	defer file.Close()
+
+	partCount := (state.Size + state.PartSize - 1) / state.PartSize
+	parts := make([]*s3.CompletedPart, 0, partCount)
+	for _, part := range done {
+		parts = append(parts, part)
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		slots    = make(chan struct{}, resumableConcurrency)
+	)
+	for number := int64(1); number <= partCount; number++ {
+		if _, ok := done[number]; ok {
+			continue
+		}
+		offset := (number - 1) * state.PartSize
+		length := state.PartSize
+		if offset+length > state.Size {
+			length = state.Size - offset
+		}
+
+		slots <- struct{}{}
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			<-slots
+			break
+		}
+
+		wg.Add(1)
+		go func(number, offset, length int64) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			input := &s3.UploadPartInput{
+				Bucket:     aws.String(state.Bucket),
+				Key:        aws.String(state.Key),
+				UploadId:   aws.String(state.UploadID),
+				PartNumber: aws.Int64(number),
+				Body:       io.NewSectionReader(file, offset, length),
+			}
+			input.SSECustomerAlgorithm, input.SSECustomerKey = s.sse()
+			result, err := s.s3.UploadPart(input)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("failed to upload part %d of %s: %w", number, state.Key, err)
+				}
+				return
+			}
+			parts = append(parts, &s3.CompletedPart{ETag: result.ETag, PartNumber: aws.Int64(number)})
+		}(number, offset, length)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return aws.Int64Value(parts[i].PartNumber) < aws.Int64Value(parts[j].PartNumber)
+	})
+	if _, err := s.s3.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(state.Bucket),
+		Key:             aws.String(state.Key),
+		UploadId:        aws.String(state.UploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	}); err != nil {
+		return fmt.Errorf("failed to complete multipart upload of %s: %w", state.Key, err)
+	}
+	return nil
+}
+
+// ResumeUploads finishes the multipart uploads recorded in the state
+// directory by a previous process. Uploads whose backup file is gone or has
+// changed are aborted so their parts do not linger in the bucket.
+func (s *S3Manager) ResumeUploads() error {
+	if s.uploadStateDir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(s.uploadStateDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read upload state directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		state, err := loadUploadState(filepath.Join(s.uploadStateDir, entry.Name()))
+		if err != nil {
+			s.logger.Warnf("Skipping upload state %s: %v", entry.Name(), err)
+			continue
+		}
+		s.resumeUpload(state)
+	}
+	return nil
+}
+
+// resumeUpload completes or aborts one interrupted upload
+func (s *S3Manager) resumeUpload(state *uploadState) {
+	location := fmt.Sprintf("s3://%s/%s", state.Bucket, state.Key)
+	info, err := os.Stat(state.LocalPath)
+	if err != nil || info.Size() != state.Size || !info.ModTime().Equal(state.ModTime) {
+		s.logger.Warnf("Backup file for interrupted upload of %s is missing or changed, aborting the upload", location)
+		s.abortUpload(state)
+		state.remove()
+		return
+	}
+
+	done := map[int64]*s3.CompletedPart{}
+	err = s.s3.ListPartsPages(&s3.ListPartsInput{
+		Bucket:   aws.String(state.Bucket),
+		Key:      aws.String(state.Key),
+		UploadId: aws.String(state.UploadID),
+	}, func(page *s3.ListPartsOutput, lastPage bool) bool {
+		for _, part := range page.Parts {
+			done[aws.Int64Value(part.PartNumber)] = &s3.CompletedPart{ETag: part.ETag, PartNumber: part.PartNumber}
+		}
+		return true
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchUpload {
+			s.logger.Warnf("Interrupted upload of %s no longer exists in S3, forgetting it", location)
+			state.remove()
+			return
+		}
+		s.logger.Errorf("Failed to list uploaded parts of %s, will retry on next start: %v", location, err)
+		return
+	}
+
+	s.logger.Infof("Resuming interrupted upload of %s (%d parts already uploaded)", location, len(done))
+	if err := s.uploadParts(state, done); err != nil {
+		s.logger.Errorf("Failed to resume upload of %s, will retry on next start: %v", location, err)
+		return
+	}
+	state.remove()
+
+	// The backup file belonged to the run that died; nothing else will remove it
+	if err := os.Remove(state.LocalPath); err != nil && !os.IsNotExist(err) {
+		s.logger.Warnf("Failed to remove uploaded backup file %s: %v", state.LocalPath, err)
+	}
+	s.logger.Infof("Resumed upload of %s completed", location)
+}
+
+// IncompleteUpload is a multipart upload that was started but never completed
+type IncompleteUpload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
+// AbortIncompleteUploads aborts the multipart uploads under prefix started
+// more than olderThan ago, so the parts of failed runs stop incurring storage
+// costs. Uploads tracked for resumption are left alone. With dryRun the
+// uploads are only listed.
+func (s *S3Manager) AbortIncompleteUploads(prefix string, olderThan time.Duration, dryRun bool) ([]IncompleteUpload, error) {
+	cutoff := time.Now().Add(-olderThan)
+	tracked := s.trackedUploads()
+
+	var stale []IncompleteUpload
+	err := s.s3.ListMultipartUploadsPages(&s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s.config.Bucket),
+		Prefix: aws.String(prefix + "/"),
+	}, func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+		for _, upload := range page.Uploads {
+			uploadID := aws.StringValue(upload.UploadId)
+			if tracked[uploadID] || !aws.TimeValue(upload.Initiated).Before(cutoff) {
+				continue
+			}
+			stale = append(stale, IncompleteUpload{
+				Key:       aws.StringValue(upload.Key),
+				UploadID:  uploadID,
+				Initiated: aws.TimeValue(upload.Initiated),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list incomplete uploads in %s: %w", s.Location(), err)
+	}
+	if dryRun || len(stale) == 0 {
+		return stale, nil
+	}
+
+	var aborted []IncompleteUpload
+	var keys []string
+	var abortErr error
+	for _, upload := range stale {
+		if _, err := s.s3.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.config.Bucket),
+			Key:      aws.String(upload.Key),
+			UploadId: aws.String(upload.UploadID),
+		}); err != nil {
+			abortErr = fmt.Errorf("failed to abort upload of s3://%s/%s: %w", s.config.Bucket, upload.Key, err)
+			break
+		}
+		aborted = append(aborted, upload)
+		keys = append(keys, upload.Key)
+	}
+
+	s.logger.Infof("Aborted %d incomplete uploads older than %v", len(aborted), olderThan)
+	if len(keys) > 0 {
+		if err := s.auditLog.Record(audit.Event{
+			Action:  audit.ActionAbortUpload,
+			Storage: s.Location(),
+			Targets: keys,
+			Details: map[string]string{"older_than": olderThan.String()},
+		}); err != nil {
+			s.logger.Errorf("Failed to record aborted uploads in audit log: %v", err)
+		}
+	}
+	return aborted, abortErr
+}
+
+// trackedUploads returns the IDs of the uploads recorded in the state directory
+func (s *S3Manager) trackedUploads() map[string]bool {
+	tracked := map[string]bool{}
+	if s.uploadStateDir == "" {
+		return tracked
+	}
+	entries, err := os.ReadDir(s.uploadStateDir)
+	if err != nil {
+		return tracked
+	}
+	for _, entry := range entries {
+		if state, err := loadUploadState(filepath.Join(s.uploadStateDir, entry.Name())); err == nil {
+			tracked[state.UploadID] = true
+		}
+	}
+	return tracked
+}
+
+// abortUpload aborts a multipart upload, discarding its uploaded parts
+func (s *S3Manager) abortUpload(state *uploadState) {
+	if _, err := s.s3.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(state.Bucket),
+		Key:      aws.String(state.Key),
+		UploadId: aws.String(state.UploadID),
+	}); err != nil {
+		s.logger.Warnf("Failed to abort multipart upload of s3://%s/%s: %v", state.Bucket, state.Key, err)
+	}
+}
+
+// stateFileName names the state file of an upload to key in bucket
+func stateFileName(bucket, key string) string {
+	sum := sha256.Sum256([]byte(bucket + "/" + key))
+	return hex.EncodeToString(sum[:16]) + ".json"
+}
+
+// loadUploadState reads an upload state file
+func loadUploadState(path string) (*uploadState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state uploadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	if state.UploadID == "" || state.Bucket == "" || state.Key == "" || state.PartSize <= 0 {
+		return nil, fmt.Errorf("incomplete upload state")
+	}
+	state.path = path
+	return &state, nil
+}
+
+// save writes the state file, replacing it atomically
+func (u *uploadState) save() error {
+	if err := os.MkdirAll(filepath.Dir(u.path), 0755); err != nil {
+		return fmt.Errorf("failed to create upload state directory: %w", err)
+	}
+	data, err := json.MarshalIndent(u, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode upload state: %w", err)
+	}
+	tmpPath := u.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write upload state: %w", err)
+	}
+	if err := os.Rename(tmpPath, u.path); err != nil {
+		return fmt.Errorf("failed to write upload state: %w", err)
+	}
+	return nil
+}
+
+// remove deletes the state file once the upload is finished or abandoned
+func (u *uploadState) remove() {
+	os.Remove(u.path)
+}
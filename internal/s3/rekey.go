@@ -0,0 +1,117 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// sseFor returns the SSE-C algorithm and key of requests using customerKey,
+// or nils for objects stored without one. The SDK base64 encodes the key and
+// adds its MD5.
+func sseFor(customerKey string) (algorithm, key *string) {
+	if customerKey == "" {
+		return nil, nil
+	}
+	return aws.String(sseCustomerAlgorithm), aws.String(customerKey)
+}
+
+// readKeys returns the customer keys objects may be encrypted with, in the
+// order they are tried: the current key, the previous keys of a rotation and,
+// once a key is configured, none for objects stored before it was
+func (s *S3Manager) readKeys() []string {
+	keys := append([]string{s.customerKey}, s.previousKeys...)
+	if s.customerKey != "" {
+		keys = append(keys, "")
+	}
+	return keys
+}
+
+// withReadKeys runs a request reading object data with each customer key the
+// object may be encrypted with until one is accepted, and returns that key
+func (s *S3Manager) withReadKeys(request func(algorithm, customerKey *string) error) (string, error) {
+	var firstErr error
+	for _, key := range s.readKeys() {
+		err := request(sseFor(key))
+		if err == nil {
+			return key, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if !isKeyMismatch(err) {
+			break
+		}
+	}
+	return "", firstErr
+}
+
+// isKeyMismatch reports whether S3 rejected a request for using the wrong
+// customer key, which it answers with 400 or 403
+func isKeyMismatch(err error) bool {
+	var reqErr awserr.RequestFailure
+	if !errors.As(err, &reqErr) {
+		return false
+	}
+	return reqErr.StatusCode() == http.StatusBadRequest || reqErr.StatusCode() == http.StatusForbidden
+}
+
+// headObject reads the object's headers and returns the customer key it is
+// encrypted with
+func (s *S3Manager) headObject(key string) (*s3.HeadObjectOutput, string, error) {
+	var head *s3.HeadObjectOutput
+	customerKey, err := s.withReadKeys(func(algorithm, customerKey *string) error {
+		var err error
+		head, err = s.s3.HeadObject(&s3.HeadObjectInput{
+			Bucket:               aws.String(s.config.Bucket),
+			Key:                  aws.String(key),
+			SSECustomerAlgorithm: algorithm,
+			SSECustomerKey:       customerKey,
+		})
+		return err
+	})
+	return head, customerKey, err
+}
+
+// Rekey re-encrypts the object stored under key with the current customer
+// key when it is encrypted with a previous key or none. It reports whether
+// the object needed it; with dryRun nothing is rewritten.
+func (s *S3Manager) Rekey(key string, dryRun bool) (bool, error) {
+	head, customerKey, err := s.headObject(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read s3://%s/%s with any configured key: %w", s.config.Bucket, key, err)
+	}
+	if customerKey == s.customerKey {
+		return false, nil
+	}
+	if dryRun {
+		return true, nil
+	}
+
+	if aws.Int64Value(head.ContentLength) > maxCopyObjectSize {
+		return true, s.copyViaTempFile(s, key, key, head)
+	}
+
+	// Copying an object onto itself re-encrypts it server-side
+	metadata, _ := s.encryptionMetadata(head.Metadata)
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(s.config.Bucket),
+		Key:               aws.String(key),
+		CopySource:        aws.String(copySource(s.config.Bucket, key)),
+		MetadataDirective: aws.String(s3.MetadataDirectiveReplace),
+		Metadata:          metadata,
+		ContentType:       head.ContentType,
+	}
+	input.CopySourceSSECustomerAlgorithm, input.CopySourceSSECustomerKey = sseFor(customerKey)
+	input.SSECustomerAlgorithm, input.SSECustomerKey = s.sse()
+	if _, err := s.s3.CopyObject(input); err != nil {
+		return true, fmt.Errorf("failed to re-encrypt s3://%s/%s: %w", s.config.Bucket, key, err)
+	}
+
+	s.logger.Infof("Re-encrypted s3://%s/%s with the current customer key", s.config.Bucket, key)
+	return true, nil
+}
@@ -1,55 +1,228 @@
 package s3
 
 import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"db-backuper/internal/audit"
+	"db-backuper/internal/compliance"
 	"db-backuper/internal/config"
+	"db-backuper/internal/provenance"
+	"db-backuper/internal/storage"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/sirupsen/logrus"
 )
 
+// ErrObjectNotFound is returned when a requested object does not exist
+var ErrObjectNotFound = errors.New("object not found")
+
 // S3Manager handles AWS S3 operations
 type S3Manager struct {
-	config *config.AWSConfig
-	logger *logrus.Logger
-	s3     *s3.S3
+	config   *config.AWSConfig
+	logger   logrus.FieldLogger
+	s3       s3iface.S3API
+	auditLog *audit.Log
+	limiter  *rateLimiter
+
+	uploadStateDir    string
+	modTimeRetention  bool
+	customerKey       string
+	previousKeys      []string
+	transferJobs      int
+	downloadChunkSize int64
 }
 
+// sseCustomerAlgorithm is the only algorithm S3 supports for SSE-C
+const sseCustomerAlgorithm = "AES256"
+
 // NewS3Manager creates a new S3 manager instance
-func NewS3Manager(awsConfig *config.AWSConfig, logger *logrus.Logger) (*S3Manager, error) {
+func NewS3Manager(awsConfig *config.AWSConfig, logger logrus.FieldLogger) (*S3Manager, error) {
+	sess, err := NewSession(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+	if awsConfig.RoleARN != "" {
+		logger.Infof("Using AWS role %s for S3", awsConfig.RoleARN)
+	}
+	return NewS3ManagerWithClient(awsConfig, s3.New(sess), logger)
+}
+
+// NewS3ManagerWithClient creates an S3 manager sending requests through client
+func NewS3ManagerWithClient(awsConfig *config.AWSConfig, client s3iface.S3API, logger logrus.FieldLogger) (*S3Manager, error) {
+	customerKey, err := awsConfig.CustomerKey()
+	if err != nil {
+		return nil, err
+	}
+	previous, err := awsConfig.PreviousCustomerKeys()
+	if err != nil {
+		return nil, err
+	}
+	previousKeys := make([]string, 0, len(previous))
+	for _, key := range previous {
+		previousKeys = append(previousKeys, string(key))
+	}
+
+	return &S3Manager{
+		config:       awsConfig,
+		logger:       logger,
+		s3:           client,
+		limiter:      newRateLimiter(awsConfig.ListRate()),
+		customerKey:  string(customerKey),
+		previousKeys: previousKeys,
+	}, nil
+}
+
+// sse returns the SSE-C algorithm and key sent with every request writing
+// object data, or nils when no customer key is configured
+func (s *S3Manager) sse() (algorithm, key *string) {
+	return sseFor(s.customerKey)
+}
+
+// markEncrypted records in meta whether the object is encrypted with a customer key
+func (s *S3Manager) markEncrypted(meta *provenance.Metadata) {
+	if meta != nil && s.customerKey != "" {
+		meta.Encrypted = true
+	}
+}
+
+// NewSession creates the AWS session for S3 and the other AWS services. Static keys take precedence over
+// the default credential chain, and a configured role is assumed on top of
+// either, through a web identity token when one is configured.
+func NewSession(awsConfig *config.AWSConfig) (*session.Session, error) {
 	// Create AWS session configuration
 	awsConfigObj := &aws.Config{
 		Region: aws.String(awsConfig.Region),
 	}
+	if client := compliance.HTTPClient(); client != nil {
+		// Regulated mode: FIPS endpoints over the restricted TLS policy
+		awsConfigObj.HTTPClient = client
+		awsConfigObj.UseFIPSEndpoint = endpoints.FIPSEndpointStateEnabled
+	}
+	if awsConfig.AccessKeyID != "" {
+		awsConfigObj.Credentials = credentials.NewStaticCredentials(
+			awsConfig.AccessKeyID, awsConfig.SecretAccessKey, awsConfig.SessionToken)
+	}
 
 	// Create AWS session
 	sess, err := session.NewSession(awsConfigObj)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create AWS session: %w", err)
 	}
+	if awsConfig.RoleARN == "" {
+		return sess, nil
+	}
+
+	var roleCredentials *credentials.Credentials
+	if awsConfig.WebIdentityTokenFile != "" {
+		roleCredentials = stscreds.NewWebIdentityCredentials(
+			sess, awsConfig.RoleARN, awsConfig.SessionName(), awsConfig.WebIdentityTokenFile)
+	} else {
+		roleCredentials = stscreds.NewCredentials(sess, awsConfig.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = awsConfig.SessionName()
+			if awsConfig.ExternalID != "" {
+				p.ExternalID = aws.String(awsConfig.ExternalID)
+			}
+		})
+	}
+	return sess.Copy(&aws.Config{Credentials: roleCredentials}), nil
+}
 
+// WithLogger returns a copy of the S3 manager that logs through logger
+func (s *S3Manager) WithLogger(logger logrus.FieldLogger) storage.Backend {
 	return &S3Manager{
-		config: awsConfig,
-		logger: logger,
-		s3:     s3.New(sess),
-	}, nil
+		config:   s.config,
+		logger:   logger,
+		s3:       s.s3,
+		auditLog: s.auditLog,
+		limiter:  s.limiter,
+
+		uploadStateDir:    s.uploadStateDir,
+		modTimeRetention:  s.modTimeRetention,
+		customerKey:       s.customerKey,
+		previousKeys:      s.previousKeys,
+		transferJobs:      s.transferJobs,
+		downloadChunkSize: s.downloadChunkSize,
+	}
+}
+
+// SetAuditLog records deletions made by this manager to the audit log
+func (s *S3Manager) SetAuditLog(auditLog *audit.Log) {
+	s.auditLog = auditLog
+}
+
+// SetModTimeRetention ages out objects whose keys carry no backup date by
+// their LastModified time instead of keeping them forever
+func (s *S3Manager) SetModTimeRetention(enabled bool) {
+	s.modTimeRetention = enabled
 }
 
-// UploadBackup uploads a backup file to S3
-func (s *S3Manager) UploadBackup(localFilePath, backupPrefix, databaseName string) (string, error) {
+// SetTransferJobs sets how many files or parts of a backup are uploaded at once
+func (s *S3Manager) SetTransferJobs(jobs int) {
+	s.transferJobs = jobs
+}
+
+// SetDownloadChunkSize sets the size of the ranges objects larger than one
+// range are downloaded and resumed in (default: 64 MiB)
+func (s *S3Manager) SetDownloadChunkSize(size int64) {
+	s.downloadChunkSize = size
+}
+
+// Location returns a human readable description of the storage target
+func (s *S3Manager) Location() string {
+	return fmt.Sprintf("s3://%s", s.config.Bucket)
+}
+
+// UploadBackup uploads a backup file to S3, attaching meta as object metadata when given
+func (s *S3Manager) UploadBackup(localFilePath, backupPrefix, databaseName string, meta *provenance.Metadata) (string, error) {
 	// Generate S3 key with database-specific path and timestamp
 	timestamp := time.Now().Format("2006-01-02_15-04-05")
-	filename := filepath.Base(localFilePath)
+	filename := storage.BackupName(filepath.Base(localFilePath), meta)
 	s3Key := fmt.Sprintf("%s/%s/%s/%s", backupPrefix, databaseName, timestamp[:10], filename)
 
+	s.logger.Infof("Uploading backup to S3: s3://%s/%s", s.config.Bucket, s3Key)
+	s.markEncrypted(meta)
+
+	// The files of a directory backup or the parts of a split one are uploaded before their index
+	if storage.IsIndex(localFilePath) {
+		if err := storage.UploadDirectory(s, localFilePath, s3Key, s.transferJobs); err != nil {
+			return "", fmt.Errorf("failed to upload backup files to S3: %w", err)
+		}
+	}
+
+	// Large backups are uploaded part by part so a crash can be resumed
+	if s.uploadStateDir != "" {
+		resumable, err := s.uploadResumable(localFilePath, s3Key, meta)
+		if err != nil {
+			return "", fmt.Errorf("failed to upload file to S3: %w", err)
+		}
+		if resumable {
+			if err := s.putManifest(s3Key, meta); err != nil {
+				return "", err
+			}
+			s.logger.Infof("Backup uploaded successfully to: s3://%s/%s", s.config.Bucket, s3Key)
+			return s3Key, nil
+		}
+	}
+
 	// Open the file
 	file, err := os.Open(localFilePath)
 	if err != nil {
@@ -61,27 +234,40 @@ func (s *S3Manager) UploadBackup(localFilePath, backupPrefix, databaseName strin
 	uploader := s3manager.NewUploaderWithClient(s.s3)
 
 	// Upload the file
-	s.logger.Infof("Uploading backup to S3: s3://%s/%s", s.config.Bucket, s3Key)
 
-	result, err := uploader.Upload(&s3manager.UploadInput{
+	input := &s3manager.UploadInput{
 		Bucket: aws.String(s.config.Bucket),
 		Key:    aws.String(s3Key),
 		Body:   file,
-	})
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey = s.sse()
+	if meta != nil {
+		input.Metadata = aws.StringMap(meta.Headers())
+	}
+	result, err := uploader.Upload(input)
 	if err != nil {
 		return "", fmt.Errorf("failed to upload file to S3: %w", err)
 	}
+	if err := s.putManifest(s3Key, meta); err != nil {
+		return "", err
+	}
 
 	s.logger.Infof("Backup uploaded successfully to: %s", result.Location)
 	return s3Key, nil
 }
 
-// DeleteOldBackups deletes backup files older than the specified retention period
-func (s *S3Manager) DeleteOldBackups(backupPrefix string, retentionDays int) error {
+// DeleteOldBackups deletes backup files older than the specified retention
+// period and returns the number of objects deleted
+func (s *S3Manager) DeleteOldBackups(backupPrefix string, retentionDays int) (int, error) {
 	cutoffDate := time.Now().AddDate(0, 0, -retentionDays)
 
 	s.logger.Infof("Deleting backups older than %d days (before %s)", retentionDays, cutoffDate.Format("2006-01-02"))
 
+	held, err := storage.HeldKeys(s, backupPrefix)
+	if err != nil {
+		return 0, err
+	}
+
 	// List objects with the backup prefix
 	listInput := &s3.ListObjectsV2Input{
 		Bucket: aws.String(s.config.Bucket),
@@ -89,37 +275,59 @@ func (s *S3Manager) DeleteOldBackups(backupPrefix string, retentionDays int) err
 	}
 
 	var objectsToDelete []*s3.ObjectIdentifier
-	err := s.s3.ListObjectsV2Pages(listInput, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+	err = s.s3.ListObjectsV2Pages(listInput, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
 		for _, obj := range page.Contents {
-			// Parse the date from the S3 key
-			// Expected format: backup-prefix/database-name/YYYY-MM-DD/filename
-			keyParts := strings.Split(*obj.Key, "/")
-			if len(keyParts) >= 3 {
-				dateStr := keyParts[2]
-				if objDate, err := time.Parse("2006-01-02", dateStr); err == nil {
-					if objDate.Before(cutoffDate) {
-						objectsToDelete = append(objectsToDelete, &s3.ObjectIdentifier{
-							Key: obj.Key,
-						})
-						s.logger.Infof("Marking for deletion: %s (date: %s)", *obj.Key, dateStr)
-					}
+			if storage.IsHeld(held, *obj.Key) {
+				if !provenance.IsSidecar(*obj.Key) {
+					s.logger.Infof("Keeping held backup: %s", *obj.Key)
+				}
+				continue
+			}
+
+			// Sidecars are dated like their backups, as the prefix may
+			// have several segments
+			_, date, ok := storage.ParseKey(backupPrefix, *obj.Key)
+			if !ok {
+				_, date, ok = storage.ParseChangeKey(backupPrefix, *obj.Key)
+			}
+			if index, isFile := storage.IndexOf(*obj.Key); !ok && isFile {
+				// The files or parts of a backup are dated by its index
+				_, date, ok = storage.ParseKey(backupPrefix, index)
+			}
+			if ok {
+				if date.Before(cutoffDate) {
+					objectsToDelete = append(objectsToDelete, &s3.ObjectIdentifier{
+						Key: obj.Key,
+					})
+					s.logger.Infof("Marking for deletion: %s (date: %s)", *obj.Key, date.Format(storage.DateLayout))
 				}
+				continue
+			}
+
+			// Renamed or legacy keys carry no date; age them by LastModified if enabled
+			if s.modTimeRetention && !storage.IsReserved(backupPrefix, *obj.Key) && filepath.Base(*obj.Key) != storage.LatestObject &&
+				aws.TimeValue(obj.LastModified).Before(cutoffDate) {
+				objectsToDelete = append(objectsToDelete, &s3.ObjectIdentifier{
+					Key: obj.Key,
+				})
+				s.logger.Infof("Marking for deletion: %s (last modified: %s)", *obj.Key, aws.TimeValue(obj.LastModified).Format("2006-01-02"))
 			}
 		}
 		return true
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to list objects: %w", err)
+		return 0, fmt.Errorf("failed to list objects: %w", err)
 	}
 
 	if len(objectsToDelete) == 0 {
 		s.logger.Info("No old backups found to delete")
-		return nil
+		return 0, nil
 	}
 
 	// Delete objects in batches
 	const maxBatchSize = 1000
+	deleted := 0
 	for i := 0; i < len(objectsToDelete); i += maxBatchSize {
 		end := i + maxBatchSize
 		if end > len(objectsToDelete) {
@@ -136,10 +344,12 @@ func (s *S3Manager) DeleteOldBackups(backupPrefix string, retentionDays int) err
 
 		result, err := s.s3.DeleteObjects(deleteInput)
 		if err != nil {
-			return fmt.Errorf("failed to delete objects: %w", err)
+			return deleted, fmt.Errorf("failed to delete objects: %w", err)
 		}
 
 		s.logger.Infof("Deleted %d backup files", len(result.Deleted))
+		deleted += len(result.Deleted)
+		s.recordRetentionDeletes(result.Deleted, retentionDays)
 		if len(result.Errors) > 0 {
 			s.logger.Warnf("Encountered %d errors during deletion", len(result.Errors))
 			for _, err := range result.Errors {
@@ -148,9 +358,447 @@ func (s *S3Manager) DeleteOldBackups(backupPrefix string, retentionDays int) err
 		}
 	}
 
+	return deleted, nil
+}
+
+// ListKeys returns every object key under prefix
+func (s *S3Manager) ListKeys(prefix string) ([]string, error) {
+	var keys []string
+	err := s.s3.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.config.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.StringValue(obj.Key))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	return keys, nil
+}
+
+// DeleteBackups deletes the given keys and their manifest sidecars and
+// returns the keys that were deleted
+func (s *S3Manager) DeleteBackups(keys []string) ([]string, error) {
+	requested := make(map[string]bool, len(keys))
+	var objects []string
+	for _, key := range keys {
+		requested[key] = true
+	}
+	for _, key := range keys {
+		objects = append(objects, key)
+		if sidecar := key + provenance.SidecarSuffix; !provenance.IsSidecar(key) && !requested[sidecar] {
+			objects = append(objects, sidecar)
+		}
+		if storage.IsIndex(key) {
+			files, err := s.ListKeys(storage.DirectoryPath(key) + "/")
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range files {
+				if !requested[file] {
+					objects = append(objects, file)
+				}
+			}
+		}
+	}
+
+	var deleted []string
+	const maxBatchSize = 1000
+	for i := 0; i < len(objects); i += maxBatchSize {
+		end := i + maxBatchSize
+		if end > len(objects) {
+			end = len(objects)
+		}
+
+		batch := make([]*s3.ObjectIdentifier, 0, end-i)
+		for _, key := range objects[i:end] {
+			batch = append(batch, &s3.ObjectIdentifier{Key: aws.String(key)})
+		}
+
+		result, err := s.s3.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(s.config.Bucket),
+			Delete: &s3.Delete{Objects: batch},
+		})
+		if err != nil {
+			return deleted, fmt.Errorf("failed to delete objects: %w", err)
+		}
+
+		for _, obj := range result.Deleted {
+			// S3 reports missing sidecars as deleted too, so only requested keys are returned
+			if key := aws.StringValue(obj.Key); requested[key] {
+				deleted = append(deleted, key)
+			}
+		}
+		if len(result.Errors) > 0 {
+			for _, e := range result.Errors {
+				s.logger.Errorf("Failed to delete %s: %s", aws.StringValue(e.Key), aws.StringValue(e.Message))
+			}
+			return deleted, fmt.Errorf("failed to delete %d objects", len(result.Errors))
+		}
+	}
+
+	s.logger.Infof("Deleted %d backup files", len(deleted))
+	return deleted, nil
+}
+
+// Download downloads the object stored under key to destPath. Objects
+// larger than one chunk are downloaded in ranges that are retried on their
+// own and resumed by downloading to the same destPath again.
+func (s *S3Manager) Download(key, destPath string) error {
+	if head, customerKey, err := s.headObject(key); err == nil && aws.Int64Value(head.ContentLength) > s.chunkSize() {
+		return s.downloadRanged(key, destPath, head, customerKey)
+	}
+
+	// Download to a temporary file so an interrupted transfer never looks complete
+	tmpPath := destPath + ".part"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmpPath, err)
+	}
+
+	s.logger.Infof("Downloading s3://%s/%s to %s", s.config.Bucket, key, destPath)
+	downloader := s3manager.NewDownloaderWithClient(s.s3)
+	var n int64
+	_, err = s.withReadKeys(func(algorithm, customerKey *string) error {
+		var err error
+		n, err = downloader.Download(file, &s3.GetObjectInput{
+			Bucket:               aws.String(s.config.Bucket),
+			Key:                  aws.String(key),
+			SSECustomerAlgorithm: algorithm,
+			SSECustomerKey:       customerKey,
+		})
+		return err
+	})
+	closeErr := file.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to download s3://%s/%s: %w", s.config.Bucket, key, err)
+	}
+	if closeErr != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write %s: %w", tmpPath, closeErr)
+	}
+
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		return fmt.Errorf("failed to move download into place: %w", err)
+	}
+
+	s.logger.Infof("Downloaded %d bytes to %s", n, destPath)
+	return nil
+}
+
+// Metadata returns the provenance attached to the object stored under key,
+// or nil when it was uploaded without any
+func (s *S3Manager) Metadata(key string) (*provenance.Metadata, error) {
+	head, _, err := s.headObject(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata of s3://%s/%s: %w", s.config.Bucket, key, err)
+	}
+	meta := provenance.FromHeaders(aws.StringValueMap(head.Metadata))
+	if meta == nil {
+		return nil, nil
+	}
+
+	data, err := s.GetObject(key + provenance.SidecarSuffix)
+	if errors.Is(err, ErrObjectNotFound) {
+		return meta, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sidecar provenance.Metadata
+	if err := json.Unmarshal(data, &sidecar); err != nil {
+		return nil, fmt.Errorf("failed to parse backup metadata of %s: %w", key, err)
+	}
+	meta.Tables = sidecar.Tables
+	return meta, nil
+}
+
+// UploadFile uploads the local file at localPath to key, attaching meta as
+// object metadata when given
+func (s *S3Manager) UploadFile(localPath, key string, meta *provenance.Metadata) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", localPath, err)
+	}
+	defer file.Close()
+
+	s.logger.Infof("Uploading %s to s3://%s/%s", localPath, s.config.Bucket, key)
+	uploader := s3manager.NewUploaderWithClient(s.s3)
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(key),
+		Body:   file,
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey = s.sse()
+	s.markEncrypted(meta)
+	if meta != nil {
+		input.Metadata = aws.StringMap(meta.Headers())
+	}
+	if _, err := uploader.Upload(input); err != nil {
+		return fmt.Errorf("failed to upload file to S3: %w", err)
+	}
+	return s.putManifest(key, meta)
+}
+
+// putManifest saves the table manifest of meta, which is too large for
+// object metadata, to a sidecar object next to key
+func (s *S3Manager) putManifest(key string, meta *provenance.Metadata) error {
+	if meta == nil || len(meta.Tables) == 0 {
+		return nil
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode backup metadata: %w", err)
+	}
+	return s.PutObject(key+provenance.SidecarSuffix, append(data, '\n'), "application/json")
+}
+
+// maxCopyObjectSize is the largest object S3 can copy in a single CopyObject call
+const maxCopyObjectSize = 5 * 1024 * 1024 * 1024
+
+// CopyFrom copies srcKey from src into destKey server-side, preserving object metadata.
+// Objects too large for a single copy are transferred through a temporary file.
+func (s *S3Manager) CopyFrom(src *S3Manager, srcKey, destKey string) error {
+	head, srcCustomerKey, err := src.headObject(srcKey)
+	if err != nil {
+		return fmt.Errorf("failed to read s3://%s/%s: %w", src.config.Bucket, srcKey, err)
+	}
+
+	if aws.Int64Value(head.ContentLength) > maxCopyObjectSize {
+		if err := s.copyViaTempFile(src, srcKey, destKey, head); err != nil {
+			return err
+		}
+		return s.copyManifest(src, srcKey, destKey)
+	}
+
+	s.logger.Infof("Copying s3://%s/%s to s3://%s/%s", src.config.Bucket, srcKey, s.config.Bucket, destKey)
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(s.config.Bucket),
+		Key:               aws.String(destKey),
+		CopySource:        aws.String(copySource(src.config.Bucket, srcKey)),
+		MetadataDirective: aws.String(s3.MetadataDirectiveCopy),
+	}
+	input.CopySourceSSECustomerAlgorithm, input.CopySourceSSECustomerKey = sseFor(srcCustomerKey)
+	input.SSECustomerAlgorithm, input.SSECustomerKey = s.sse()
+	if metadata, changed := s.encryptionMetadata(head.Metadata); changed {
+		// The copy is encrypted differently from the source, so its
+		// provenance is rewritten rather than copied
+		input.MetadataDirective = aws.String(s3.MetadataDirectiveReplace)
+		input.Metadata = metadata
+		input.ContentType = head.ContentType
+	}
+	_, err = s.s3.CopyObject(input)
+	if err != nil {
+		return fmt.Errorf("failed to copy object: %w", err)
+	}
+	return s.copyManifest(src, srcKey, destKey)
+}
+
+// copyManifest copies the table manifest sidecar of srcKey, if it has one
+func (s *S3Manager) copyManifest(src *S3Manager, srcKey, destKey string) error {
+	data, err := src.GetObject(srcKey + provenance.SidecarSuffix)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.PutObject(destKey+provenance.SidecarSuffix, data, "application/json")
+}
+
+// copySource builds the URL-encoded bucket/key value for CopyObject
+func copySource(bucket, key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return bucket + "/" + strings.Join(segments, "/")
+}
+
+// copyViaTempFile copies a large object by downloading and re-uploading it with the same metadata
+func (s *S3Manager) copyViaTempFile(src *S3Manager, srcKey, destKey string, head *s3.HeadObjectOutput) error {
+	tmpFile, err := os.CreateTemp("", "db-backuper-copy-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	tmpFile.Close()
+	defer os.Remove(tmpPath)
+
+	if err := src.Download(srcKey, tmpPath); err != nil {
+		return err
+	}
+
+	file, err := os.Open(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to open temporary file: %w", err)
+	}
+	defer file.Close()
+
+	s.logger.Infof("Uploading large object to s3://%s/%s", s.config.Bucket, destKey)
+	uploader := s3manager.NewUploaderWithClient(s.s3)
+	metadata, _ := s.encryptionMetadata(head.Metadata)
+	input := &s3manager.UploadInput{
+		Bucket:      aws.String(s.config.Bucket),
+		Key:         aws.String(destKey),
+		Body:        file,
+		Metadata:    metadata,
+		ContentType: head.ContentType,
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey = s.sse()
+	if _, err := uploader.Upload(input); err != nil {
+		return fmt.Errorf("failed to upload file to S3: %w", err)
+	}
+	return nil
+}
+
+// encryptionMetadata returns the object metadata of a copy stored by this
+// manager, with the provenance's encrypted flag updated to match its customer
+// key. changed reports whether the flag differs from the source's.
+func (s *S3Manager) encryptionMetadata(source map[string]*string) (metadata map[string]*string, changed bool) {
+	meta := provenance.FromHeaders(aws.StringValueMap(source))
+	if meta == nil || meta.Encrypted == (s.customerKey != "") {
+		return source, false
+	}
+	meta.Encrypted = s.customerKey != ""
+	return aws.StringMap(meta.Headers()), true
+}
+
+// recordRetentionDeletes writes deleted objects to the audit log
+func (s *S3Manager) recordRetentionDeletes(deleted []*s3.DeletedObject, retentionDays int) {
+	if len(deleted) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(deleted))
+	for _, obj := range deleted {
+		keys = append(keys, aws.StringValue(obj.Key))
+	}
+
+	if err := s.auditLog.Record(audit.Event{
+		Action:  audit.ActionRetentionDelete,
+		Storage: s.Location(),
+		Targets: keys,
+		Details: map[string]string{"retention_days": strconv.Itoa(retentionDays)},
+	}); err != nil {
+		s.logger.Errorf("Failed to record retention deletions in audit log: %v", err)
+	}
+}
+
+// UpdateLatest records key as the newest backup of the database in its
+// latest.json pointer object
+func (s *S3Manager) UpdateLatest(backupPrefix, databaseName, key string, sizeBytes int64, meta *provenance.Metadata) error {
+	pointer := storage.LatestPointer{
+		Database:  databaseName,
+		Key:       key,
+		SizeBytes: sizeBytes,
+		UpdatedAt: time.Now().UTC(),
+	}
+	if meta != nil {
+		pointer.SHA256 = meta.SHA256
+	}
+	data, err := json.MarshalIndent(pointer, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode latest pointer: %w", err)
+	}
+
+	latestKey := storage.LatestKey(backupPrefix, databaseName)
+	if err := s.PutObject(latestKey, append(data, '\n'), "application/json"); err != nil {
+		return err
+	}
+	s.logger.Infof("Updated s3://%s/%s to point at %s", s.config.Bucket, latestKey, key)
+	return nil
+}
+
+// HoldTag is the object tag marking a backup under a retention hold, so the
+// hold is visible in the console and to lifecycle rules
+const HoldTag = "db-backuper-hold"
+
+// SetHoldTag adds or removes the retention hold tag of a backup, keeping its
+// other tags
+func (s *S3Manager) SetHoldTag(key, reason string, held bool) error {
+	current, err := s.s3.GetObjectTagging(&s3.GetObjectTaggingInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read tags of s3://%s/%s: %w", s.config.Bucket, key, err)
+	}
+
+	var tags []*s3.Tag
+	for _, tag := range current.TagSet {
+		if aws.StringValue(tag.Key) != HoldTag {
+			tags = append(tags, tag)
+		}
+	}
+	if held {
+		// Tag values are limited to 256 characters
+		value := cmp.Or(reason, "true")
+		if len(value) > 256 {
+			value = value[:256]
+		}
+		tags = append(tags, &s3.Tag{Key: aws.String(HoldTag), Value: aws.String(value)})
+	}
+
+	if _, err := s.s3.PutObjectTagging(&s3.PutObjectTaggingInput{
+		Bucket:  aws.String(s.config.Bucket),
+		Key:     aws.String(key),
+		Tagging: &s3.Tagging{TagSet: tags},
+	}); err != nil {
+		return fmt.Errorf("failed to tag s3://%s/%s: %w", s.config.Bucket, key, err)
+	}
 	return nil
 }
 
+// PutObject writes a small object such as a status or pointer file to S3
+func (s *S3Manager) PutObject(key string, data []byte, contentType string) error {
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.config.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey = s.sse()
+	_, err := s.s3.PutObject(input)
+	if err != nil {
+		return fmt.Errorf("failed to write s3://%s/%s: %w", s.config.Bucket, key, err)
+	}
+	return nil
+}
+
+// GetObject reads a small object from S3, returning ErrObjectNotFound if it does not exist
+func (s *S3Manager) GetObject(key string) ([]byte, error) {
+	var result *s3.GetObjectOutput
+	_, err := s.withReadKeys(func(algorithm, customerKey *string) error {
+		var err error
+		result, err = s.s3.GetObject(&s3.GetObjectInput{
+			Bucket:               aws.String(s.config.Bucket),
+			Key:                  aws.String(key),
+			SSECustomerAlgorithm: algorithm,
+			SSECustomerKey:       customerKey,
+		})
+		return err
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("failed to read s3://%s/%s: %w", s.config.Bucket, key, err)
+	}
+	defer result.Body.Close()
+
+	data, err := io.ReadAll(result.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read s3://%s/%s: %w", s.config.Bucket, key, err)
+	}
+	return data, nil
+}
+
 // TestConnection tests the S3 connection
 func (s *S3Manager) TestConnection() error {
 	_, err := s.s3.HeadBucket(&s3.HeadBucketInput{
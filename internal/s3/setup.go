@@ -0,0 +1,129 @@
+package s3
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"db-backuper/internal/config"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// EnsureBucket creates the bucket in the configured region unless it
+// exists, reporting whether it was created
+func (s *S3Manager) EnsureBucket() (bool, error) {
+	_, err := s.s3.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String(s.config.Bucket)})
+	var reqErr awserr.RequestFailure
+	switch {
+	case err == nil:
+		return false, nil
+	case errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusForbidden:
+		return false, fmt.Errorf("bucket s3://%s exists but cannot be accessed; it may belong to another account: %w", s.config.Bucket, err)
+	case !errors.As(err, &reqErr) || reqErr.StatusCode() != http.StatusNotFound:
+		return false, fmt.Errorf("failed to check bucket s3://%s: %w", s.config.Bucket, err)
+	}
+
+	input := &s3.CreateBucketInput{Bucket: aws.String(s.config.Bucket)}
+	// us-east-1 is the default location and cannot be named
+	if s.config.Region != "" && s.config.Region != "us-east-1" {
+		input.CreateBucketConfiguration = &s3.CreateBucketConfiguration{LocationConstraint: aws.String(s.config.Region)}
+	}
+	if _, err := s.s3.CreateBucket(input); err != nil {
+		return false, fmt.Errorf("failed to create bucket s3://%s: %w", s.config.Bucket, err)
+	}
+	if err := s.s3.WaitUntilBucketExists(&s3.HeadBucketInput{Bucket: aws.String(s.config.Bucket)}); err != nil {
+		return true, fmt.Errorf("bucket s3://%s was created but is not available yet: %w", s.config.Bucket, err)
+	}
+	return true, nil
+}
+
+// EnableVersioning turns on versioning of the bucket, reporting whether it
+// was off. Versioning is never suspended, since that loses the protection of
+// versions kept so far.
+func (s *S3Manager) EnableVersioning() (bool, error) {
+	current, err := s.s3.GetBucketVersioning(&s3.GetBucketVersioningInput{Bucket: aws.String(s.config.Bucket)})
+	if err != nil {
+		return false, fmt.Errorf("failed to read versioning of s3://%s: %w", s.config.Bucket, err)
+	}
+	if aws.StringValue(current.Status) == s3.BucketVersioningStatusEnabled {
+		return false, nil
+	}
+	if _, err := s.s3.PutBucketVersioning(&s3.PutBucketVersioningInput{
+		Bucket:                  aws.String(s.config.Bucket),
+		VersioningConfiguration: &s3.VersioningConfiguration{Status: aws.String(s3.BucketVersioningStatusEnabled)},
+	}); err != nil {
+		return false, fmt.Errorf("failed to enable versioning of s3://%s: %w", s.config.Bucket, err)
+	}
+	return true, nil
+}
+
+// SetDefaultEncryption sets the encryption S3 applies to objects written
+// without any, reporting whether it changed. kmsKeyID only applies to
+// aws:kms and may be empty for the AWS managed key.
+func (s *S3Manager) SetDefaultEncryption(algorithm, kmsKeyID string) (bool, error) {
+	rule := &s3.ServerSideEncryptionRule{
+		ApplyServerSideEncryptionByDefault: &s3.ServerSideEncryptionByDefault{SSEAlgorithm: aws.String(algorithm)},
+	}
+	if algorithm == config.EncryptionKMS {
+		// Bucket keys cut the KMS requests, and charges, of every upload
+		rule.BucketKeyEnabled = aws.Bool(true)
+		if kmsKeyID != "" {
+			rule.ApplyServerSideEncryptionByDefault.KMSMasterKeyID = aws.String(kmsKeyID)
+		}
+	}
+
+	current, err := s.s3.GetBucketEncryption(&s3.GetBucketEncryptionInput{Bucket: aws.String(s.config.Bucket)})
+	var aerr awserr.Error
+	if err != nil && !(errors.As(err, &aerr) && aerr.Code() == "ServerSideEncryptionConfigurationNotFoundError") {
+		return false, fmt.Errorf("failed to read encryption of s3://%s: %w", s.config.Bucket, err)
+	}
+	if current != nil && current.ServerSideEncryptionConfiguration != nil &&
+		slices.ContainsFunc(current.ServerSideEncryptionConfiguration.Rules, func(r *s3.ServerSideEncryptionRule) bool { return r.String() == rule.String() }) {
+		return false, nil
+	}
+
+	if _, err := s.s3.PutBucketEncryption(&s3.PutBucketEncryptionInput{
+		Bucket:                            aws.String(s.config.Bucket),
+		ServerSideEncryptionConfiguration: &s3.ServerSideEncryptionConfiguration{Rules: []*s3.ServerSideEncryptionRule{rule}},
+	}); err != nil {
+		return false, fmt.Errorf("failed to set encryption of s3://%s: %w", s.config.Bucket, err)
+	}
+	return true, nil
+}
+
+// VerifyAccess writes, reads, lists and deletes a test object under prefix,
+// checking the permissions backups and retention cleanup need
+func (s *S3Manager) VerifyAccess(prefix string) error {
+	key := fmt.Sprintf("%s/.db-backuper-init-%d", prefix, time.Now().UnixNano())
+	data := []byte("db-backuper init-storage test object\n")
+	if err := s.PutObject(key, data, "text/plain"); err != nil {
+		return fmt.Errorf("write check failed: %w", err)
+	}
+	read, err := s.GetObject(key)
+	if err != nil {
+		return fmt.Errorf("read check failed: %w", err)
+	}
+	if !bytes.Equal(read, data) {
+		return fmt.Errorf("read check failed: s3://%s/%s was read back with different contents", s.config.Bucket, key)
+	}
+	keys, err := s.ListKeys(key)
+	if err != nil {
+		return fmt.Errorf("list check failed: %w", err)
+	}
+	if !slices.Contains(keys, key) {
+		return fmt.Errorf("list check failed: s3://%s/%s is missing from the listing", s.config.Bucket, key)
+	}
+	if _, err := s.s3.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(key),
+	}); err != nil {
+		return fmt.Errorf("delete check failed, leaving s3://%s/%s behind: %w", s.config.Bucket, key, err)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package s3
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// PresignDownload returns a URL downloading the object stored under key
+// without AWS credentials until ttl has passed. Objects encrypted with a
+// customer key cannot be shared, since the key would have to travel with
+// the URL.
+func (s *S3Manager) PresignDownload(key string, ttl time.Duration) (string, error) {
+	_, customerKey, err := s.headObject(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to read s3://%s/%s: %w", s.config.Bucket, key, err)
+	}
+	if customerKey != "" {
+		return "", fmt.Errorf("s3://%s/%s is encrypted with a customer key, which a pre-signed URL cannot carry; download the backup and hand over the file instead", s.config.Bucket, key)
+	}
+
+	req, _ := s.s3.GetObjectRequest(&s3.GetObjectInput{
+		Bucket:                     aws.String(s.config.Bucket),
+		Key:                        aws.String(key),
+		ResponseContentDisposition: aws.String(mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(key)})),
+	})
+	url, err := req.Presign(ttl)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign a URL for s3://%s/%s: %w", s.config.Bucket, key, err)
+	}
+	return url, nil
+}
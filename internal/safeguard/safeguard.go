@@ -0,0 +1,164 @@
+// Package safeguard guards a risky command, such as a schema migration, with
+// backups: it runs the command only once every backup taken for it was read
+// back from storage and verified, and tags them as restore points
+package safeguard
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"db-backuper/internal/backup"
+	"db-backuper/internal/catalog"
+	"db-backuper/internal/config"
+	"db-backuper/internal/status"
+	"db-backuper/internal/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Backup is a backup taken for the command and the storage holding it
+type Backup struct {
+	Result  status.DatabaseResult
+	Backend storage.Backend
+	// Prefix is the backup prefix of the database, which holds its restore points
+	Prefix string
+}
+
+// Options name the restore points and the command to run
+type Options struct {
+	// Label names the restore points, <label>-<database>
+	Label string
+	// Command is the program to run and its arguments
+	Command []string
+	// RunID identifies the backup run, passed on to the command
+	RunID string
+	// Jobs is how many files or parts of a backup are downloaded at once
+	Jobs int
+}
+
+// Run verifies every backup, tags each as restore point <label>-<database>
+// and then runs the command. Nothing is tagged or run when a backup fails
+// verification.
+func Run(backups []Backup, opts Options, logger logrus.FieldLogger) error {
+	keys := make([]string, len(backups))
+	for i, b := range backups {
+		key, err := Verify(b.Backend, b.Result, opts.Jobs)
+		if err != nil {
+			return fmt.Errorf("safeguard backup of %s could not be verified, not running the command: %w", b.Result.Database, err)
+		}
+		keys[i] = key
+	}
+
+	for i, b := range backups {
+		name := opts.Label + "-" + b.Result.Database
+		point := catalog.RestorePoint{
+			Name:     name,
+			Database: b.Result.Database,
+			Key:      keys[i],
+			Note:     fmt.Sprintf("safeguard before: %s", strings.Join(opts.Command, " ")),
+		}
+		if err := catalog.New(b.Backend, b.Prefix).Tag(point, true); err != nil {
+			return fmt.Errorf("failed to tag safeguard backup of %s: %w", b.Result.Database, err)
+		}
+		logger.Infof("Verified %s and tagged it as restore point %s", keys[i], name)
+	}
+
+	logger.Infof("Safeguard backups complete, running %s", strings.Join(opts.Command, " "))
+	return runWrapped(opts.Command, opts.Label, opts.RunID)
+}
+
+// Verify downloads the backup of result and checks it the way the verify
+// command does, returning its storage key. The download must match the
+// checksum recorded with the backup, and a plain SQL backup must end with
+// its completion marker and hold the tables and rows of its manifest.
+func Verify(backend storage.Backend, result status.DatabaseResult, jobs int) (string, error) {
+	if result.SizeBytes == 0 {
+		return "", fmt.Errorf("backup is empty")
+	}
+	keys, err := backend.ListKeys(result.Location)
+	if err != nil {
+		return "", err
+	}
+	key := storedKey(keys, result.Location)
+	if key == "" {
+		return "", fmt.Errorf("%s not found in %s", result.Location, backend.Location())
+	}
+
+	workDir, err := os.MkdirTemp("", "db-backuper-safeguard-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create download directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+	backupPath, err := storage.Fetch(backend, key, filepath.Join(workDir, path.Base(key)), jobs)
+	if err != nil {
+		return "", err
+	}
+	meta, err := storage.VerifyDownload(backend, key, backupPath)
+	if err != nil {
+		return "", err
+	}
+	// The files of a directory backup are checked against their index by Fetch
+	if meta == nil || (meta.SHA256 == "" && !storage.IsDirectoryIndex(key)) {
+		return "", fmt.Errorf("%s has no recorded checksum to verify it against", key)
+	}
+
+	if meta.Engine != config.EngineTypePostgres || storage.IsDirectoryIndex(key) || strings.HasSuffix(key, backup.PostgresDirectorySuffix) {
+		return key, nil
+	}
+	verification, err := backup.VerifySQLDump(backupPath, meta.Tables)
+	if err != nil {
+		return "", err
+	}
+	if !verification.OK() {
+		return "", fmt.Errorf("%s", strings.Join(verification.Problems, "; "))
+	}
+	return key, nil
+}
+
+// storedKey returns the key of keys naming the backup stored at location,
+// which may be a path or URL ending with the key
+func storedKey(keys []string, location string) string {
+	for _, key := range keys {
+		if key == location || strings.HasSuffix(filepath.ToSlash(location), "/"+key) {
+			return key
+		}
+	}
+	return ""
+}
+
+// runWrapped runs the wrapped command with the terminal attached, forwarding
+// interrupts so it can shut down cleanly. Its exit status becomes ours.
+func runWrapped(command []string, label, runID string) error {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"DB_BACKUP_SAFEGUARD_LABEL="+label,
+		"DB_BACKUP_RUN_ID="+runID,
+	)
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", command[0], err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%s failed: %w", command[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+// Package schemadiff compares the tables and columns of two PostgreSQL
+// databases, such as a restored backup and the live database
+package schemadiff
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+)
+
+// ColumnsQuery lists the columns of the user tables of a database
+const ColumnsQuery = `SELECT c.table_schema, c.table_name, c.column_name, c.data_type
+	FROM information_schema.columns c
+	JOIN information_schema.tables t ON t.table_schema = c.table_schema AND t.table_name = c.table_name
+	WHERE t.table_type = 'BASE TABLE' AND c.table_schema NOT IN ('pg_catalog', 'information_schema')
+	ORDER BY c.table_schema, c.table_name, c.ordinal_position`
+
+// Column is a column of a table
+type Column struct {
+	Schema string `json:"schema"`
+	Table  string `json:"table"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+}
+
+// TableName returns the schema-qualified name of the column's table
+func (c Column) TableName() string {
+	return c.Schema + "." + c.Table
+}
+
+// Querier runs the columns query; *sql.DB and *bun.DB both satisfy it
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// Read returns the columns of every user table in a database
+func Read(ctx context.Context, db Querier) ([]Column, error) {
+	rows, err := db.QueryContext(ctx, ColumnsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+	defer rows.Close()
+
+	var columns []Column
+	for rows.Next() {
+		var column Column
+		if err := rows.Scan(&column.Schema, &column.Table, &column.Name, &column.Type); err != nil {
+			return nil, fmt.Errorf("failed to read schema: %w", err)
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+// ColumnChange is a column whose type differs between the backup and the live database
+type ColumnChange struct {
+	Column     string `json:"column"`
+	BackupType string `json:"backup_type"`
+	LiveType   string `json:"live_type"`
+}
+
+// Diff lists the differences of the live database from a backup. Added
+// objects exist only in the live database and would be lost by restoring the
+// backup; dropped objects exist only in the backup and would come back.
+type Diff struct {
+	AddedTables    []string       `json:"added_tables"`
+	DroppedTables  []string       `json:"dropped_tables"`
+	AddedColumns   []string       `json:"added_columns"`
+	DroppedColumns []string       `json:"dropped_columns"`
+	ChangedColumns []ColumnChange `json:"changed_columns"`
+}
+
+// Compare returns the differences of live from backup. Columns are only
+// compared for tables present in both.
+func Compare(backup, live []Column) Diff {
+	backupTables, liveTables := group(backup), group(live)
+
+	var diff Diff
+	for _, table := range sortedKeys(liveTables) {
+		if _, ok := backupTables[table]; !ok {
+			diff.AddedTables = append(diff.AddedTables, table)
+		}
+	}
+	for _, table := range sortedKeys(backupTables) {
+		liveColumns, ok := liveTables[table]
+		if !ok {
+			diff.DroppedTables = append(diff.DroppedTables, table)
+			continue
+		}
+		backupColumns := backupTables[table]
+		for _, name := range sortedKeys(liveColumns) {
+			if _, ok := backupColumns[name]; !ok {
+				diff.AddedColumns = append(diff.AddedColumns, table+"."+name)
+			}
+		}
+		for _, name := range sortedKeys(backupColumns) {
+			liveType, ok := liveColumns[name]
+			switch {
+			case !ok:
+				diff.DroppedColumns = append(diff.DroppedColumns, table+"."+name)
+			case liveType != backupColumns[name]:
+				diff.ChangedColumns = append(diff.ChangedColumns, ColumnChange{
+					Column:     table + "." + name,
+					BackupType: backupColumns[name],
+					LiveType:   liveType,
+				})
+			}
+		}
+	}
+	return diff
+}
+
+// Empty returns true if the schemas match
+func (d Diff) Empty() bool {
+	return len(d.AddedTables)+len(d.DroppedTables)+len(d.AddedColumns)+len(d.DroppedColumns)+len(d.ChangedColumns) == 0
+}
+
+// Write prints the differences, one per line, prefixed with + for objects
+// only in the live database, - for objects only in the backup and ~ for
+// changed column types
+func (d Diff) Write(w io.Writer) {
+	if d.Empty() {
+		fmt.Fprintln(w, "No schema differences")
+		return
+	}
+	for _, table := range d.AddedTables {
+		fmt.Fprintf(w, "+ table %s (live only, dropped by a restore)\n", table)
+	}
+	for _, table := range d.DroppedTables {
+		fmt.Fprintf(w, "- table %s (backup only, recreated by a restore)\n", table)
+	}
+	for _, column := range d.AddedColumns {
+		fmt.Fprintf(w, "+ column %s (live only)\n", column)
+	}
+	for _, column := range d.DroppedColumns {
+		fmt.Fprintf(w, "- column %s (backup only)\n", column)
+	}
+	for _, change := range d.ChangedColumns {
+		fmt.Fprintf(w, "~ column %s: %s in backup, %s live\n", change.Column, change.BackupType, change.LiveType)
+	}
+}
+
+// group indexes columns by table and column name
+func group(columns []Column) map[string]map[string]string {
+	tables := make(map[string]map[string]string)
+	for _, column := range columns {
+		table := column.TableName()
+		if tables[table] == nil {
+			tables[table] = make(map[string]string)
+		}
+		tables[table][column.Name] = column.Type
+	}
+	return tables
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	return slices.Sorted(maps.Keys(m))
+}
@@ -0,0 +1,137 @@
+// Package service generates the definitions registering the backup
+// scheduler as a system service: systemd units, launchd daemons and Windows
+// services run through NSSM
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DefaultName is the name services are registered under unless another is given
+const DefaultName = "db-backuper"
+
+// validName matches the names every supported service manager accepts
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidateName checks that name can identify a service
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid service name %q: use letters, digits, dots, dashes and underscores", name)
+	}
+	return nil
+}
+
+// Definition describes the service running the scheduler
+type Definition struct {
+	// Name identifies the service to the service manager
+	Name string
+	// Executable is the absolute path of the db-backuper binary
+	Executable string
+	// Args follow the executable, starting with the serve command
+	Args []string
+	// WorkingDir is where relative paths of the configuration are resolved
+	WorkingDir string
+}
+
+// SystemdUnitPath returns where the systemd unit of a service is installed
+func SystemdUnitPath(name string) string {
+	return filepath.Join("/etc/systemd/system", name+".service")
+}
+
+// LaunchdPlistPath returns where the launchd daemon of a service is installed
+func LaunchdPlistPath(name string) string {
+	return filepath.Join("/Library/LaunchDaemons", name+".plist")
+}
+
+// SystemdUnit returns a Type=notify unit whose watchdog restarts a wedged
+// scheduler. A running backup is given an hour to finish on stop, and the
+// scheduler state is kept in /var/lib/<name>.
+func (d Definition) SystemdUnit() string {
+	command := make([]string, 0, len(d.Args)+1)
+	for _, arg := range append([]string{d.Executable}, d.Args...) {
+		command = append(command, systemdQuote(arg))
+	}
+
+	return fmt.Sprintf(`[Unit]
+Description=Database backup service (%s)
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=notify
+ExecStart=%s
+WorkingDirectory=%s
+StateDirectory=%s
+WatchdogSec=60
+Restart=on-failure
+TimeoutStopSec=1h
+
+[Install]
+WantedBy=multi-user.target
+`, d.Name, strings.Join(command, " "), strings.ReplaceAll(d.WorkingDir, "%", "%%"), d.Name)
+}
+
+// systemdQuote quotes an argument of a unit file when it holds spaces,
+// quotes or backslashes, and escapes the % of specifiers
+func systemdQuote(arg string) string {
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\") {
+		return arg
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+// LaunchdPlist returns a launchd daemon started at boot and restarted
+// whenever it exits
+func (d Definition) LaunchdPlist() string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	b.WriteString(`<plist version="1.0">` + "\n<dict>\n")
+	fmt.Fprintf(&b, "\t<key>Label</key>\n\t<string>%s</string>\n", xmlEscape(d.Name))
+	b.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, arg := range append([]string{d.Executable}, d.Args...) {
+		fmt.Fprintf(&b, "\t\t<string>%s</string>\n", xmlEscape(arg))
+	}
+	b.WriteString("\t</array>\n")
+	fmt.Fprintf(&b, "\t<key>WorkingDirectory</key>\n\t<string>%s</string>\n", xmlEscape(d.WorkingDir))
+	b.WriteString("\t<key>RunAtLoad</key>\n\t<true/>\n")
+	b.WriteString("\t<key>KeepAlive</key>\n\t<true/>\n")
+	fmt.Fprintf(&b, "\t<key>StandardOutPath</key>\n\t<string>/Library/Logs/%s.log</string>\n", xmlEscape(d.Name))
+	fmt.Fprintf(&b, "\t<key>StandardErrorPath</key>\n\t<string>/Library/Logs/%s.log</string>\n", xmlEscape(d.Name))
+	b.WriteString("</dict>\n</plist>\n")
+	return b.String()
+}
+
+// xmlEscape escapes text for a plist string
+func xmlEscape(text string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(text))
+	return b.String()
+}
+
+// NSSMInstall returns the nssm commands registering the service on Windows.
+// NSSM stops it with Ctrl+C, which lets a running backup finish within ten
+// minutes.
+func (d Definition) NSSMInstall() [][]string {
+	return [][]string{
+		append([]string{"install", d.Name, d.Executable}, d.Args...),
+		{"set", d.Name, "AppDirectory", d.WorkingDir},
+		{"set", d.Name, "AppStopMethodSkip", "6"},
+		{"set", d.Name, "AppStopMethodConsole", "600000"},
+		{"start", d.Name},
+	}
+}
+
+// NSSMUninstall returns the nssm commands removing the service on Windows
+func (d Definition) NSSMUninstall() [][]string {
+	return [][]string{
+		{"stop", d.Name},
+		{"remove", d.Name, "confirm"},
+	}
+}
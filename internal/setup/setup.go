@@ -0,0 +1,356 @@
+// Package setup asks a new user for the settings of a first configuration
+// file, testing each part as it is entered
+package setup
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"db-backuper/internal/config"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Defaults of the answers, which match the example configurations
+const (
+	DefaultSchedule      = "0 2 * * *"
+	DefaultRetentionDays = 7
+	DefaultBackupPrefix  = "postgres-backup"
+)
+
+// Checks test the answers as they are given. A nil check is skipped.
+type Checks struct {
+	// Database connects to a database
+	Database func(db *config.DatabaseConfig) error
+	// Storage writes, reads and deletes a test file in the storage of cfg
+	Storage func(cfg *config.Config) error
+}
+
+// Wizard asks for the settings of a configuration on a terminal
+type Wizard struct {
+	in     *bufio.Reader
+	out    io.Writer
+	checks Checks
+}
+
+// New returns a wizard reading answers from in and writing prompts to out
+func New(in io.Reader, out io.Writer, checks Checks) *Wizard {
+	return &Wizard{in: bufio.NewReader(in), out: out, checks: checks}
+}
+
+// Run asks for the databases, the storage, the schedule and the retention
+// of a configuration, and returns it once it is valid for backups
+func (w *Wizard) Run() (*config.Config, error) {
+	cfg := &config.Config{Logging: config.LoggingConfig{Level: "info", Format: "text"}}
+
+	fmt.Fprintln(w.out, "Databases")
+	for {
+		db, err := w.database()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Databases = append(cfg.Databases, *db)
+		more, err := w.yesNo("Add another database?", false)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
+	}
+
+	fmt.Fprintln(w.out, "\nStorage")
+	if err := w.storage(cfg); err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintln(w.out, "\nSchedule and retention")
+	schedule, err := w.askValid("Backup schedule (cron: minute hour day month weekday)", DefaultSchedule, func(answer string) error {
+		_, err := cron.ParseStandard(answer)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	cfg.Backup.Schedule = schedule
+	if cfg.Backup.RetentionDays, err = w.askNumber("Days to keep backups", DefaultRetentionDays, 1, 0); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.ValidateForBackup(); err != nil {
+		return nil, fmt.Errorf("the answers do not make a valid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// database asks for a PostgreSQL database until it connects, or the user
+// keeps it anyway
+func (w *Wizard) database() (*config.DatabaseConfig, error) {
+	for {
+		db := &config.DatabaseConfig{}
+		var err error
+		if db.Host, err = w.ask("Host", "localhost"); err != nil {
+			return nil, err
+		}
+		if db.Port, err = w.askNumber("Port", 5432, 1, 65535); err != nil {
+			return nil, err
+		}
+		if db.Database, err = w.askRequired("Database name"); err != nil {
+			return nil, err
+		}
+		if db.Username, err = w.ask("Username", "postgres"); err != nil {
+			return nil, err
+		}
+		if db.Password, err = w.askRequired("Password (shown as typed)"); err != nil {
+			return nil, err
+		}
+		if db.SSLMode, err = w.askChoice("SSL mode", "require", "disable", "require", "verify-ca", "verify-full"); err != nil {
+			return nil, err
+		}
+
+		if w.checks.Database == nil {
+			return db, nil
+		}
+		fmt.Fprintf(w.out, "Connecting to %s@%s:%d/%s... ", db.Username, db.Host, db.Port, db.Database)
+		err = w.checks.Database(db)
+		if keep, askErr := w.result(err); askErr != nil || keep {
+			return db, askErr
+		}
+	}
+}
+
+// storage asks for local or S3 storage until a test file can be written
+// to it, or the user keeps it anyway
+func (w *Wizard) storage(cfg *config.Config) error {
+	for {
+		cfg.Local, cfg.AWS = config.LocalConfig{}, config.AWSConfig{}
+		kind, err := w.askChoice("Store backups in", "local", "local", "s3")
+		if err != nil {
+			return err
+		}
+		if kind == "local" {
+			if cfg.Local.Path, err = w.ask("Backup directory", "/var/backups/db-backuper"); err != nil {
+				return err
+			}
+		} else if err := w.s3(&cfg.AWS); err != nil {
+			return err
+		}
+		if cfg.Backup.BackupPrefix, err = w.ask("Prefix of the backup keys", DefaultBackupPrefix); err != nil {
+			return err
+		}
+
+		if w.checks.Storage == nil {
+			return nil
+		}
+		fmt.Fprint(w.out, "Writing a test file... ")
+		err = w.checks.Storage(cfg)
+		if keep, askErr := w.result(err); askErr != nil || keep {
+			return askErr
+		}
+	}
+}
+
+// s3 asks for the bucket of S3 storage and the credentials reaching it
+func (w *Wizard) s3(aws *config.AWSConfig) error {
+	var err error
+	if aws.Region, err = w.ask("AWS region", "us-east-1"); err != nil {
+		return err
+	}
+	if aws.Bucket, err = w.askRequired("Bucket"); err != nil {
+		return err
+	}
+	useRole, err := w.yesNo("Assume an IAM role instead of using access keys?", false)
+	if err != nil {
+		return err
+	}
+	if useRole {
+		aws.RoleARN, err = w.askRequired("Role ARN")
+		return err
+	}
+	if aws.AccessKeyID, err = w.askRequired("Access key ID"); err != nil {
+		return err
+	}
+	aws.SecretAccessKey, err = w.askRequired("Secret access key (shown as typed)")
+	return err
+}
+
+// result reports the outcome of a check and, when it failed, whether to
+// keep the answers anyway instead of entering them again
+func (w *Wizard) result(err error) (bool, error) {
+	if err == nil {
+		fmt.Fprintln(w.out, "ok")
+		return true, nil
+	}
+	fmt.Fprintf(w.out, "failed: %v\n", err)
+	retry, askErr := w.yesNo("Enter these settings again?", true)
+	return !retry, askErr
+}
+
+// ask prompts for a value, returning def for an empty answer
+func (w *Wizard) ask(prompt, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", prompt, def)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", prompt)
+	}
+	line, err := w.in.ReadString('\n')
+	if err != nil && (line == "" || !errors.Is(err, io.EOF)) {
+		if errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("input ended before the configuration was complete")
+		}
+		return "", err
+	}
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer, nil
+	}
+	return def, nil
+}
+
+// askValid prompts until validate accepts the answer
+func (w *Wizard) askValid(prompt, def string, validate func(string) error) (string, error) {
+	for {
+		answer, err := w.ask(prompt, def)
+		if err != nil {
+			return "", err
+		}
+		if err := validate(answer); err != nil {
+			fmt.Fprintf(w.out, "  %v\n", err)
+			continue
+		}
+		return answer, nil
+	}
+}
+
+// askRequired prompts until the answer is not empty
+func (w *Wizard) askRequired(prompt string) (string, error) {
+	return w.askValid(prompt, "", func(answer string) error {
+		if answer == "" {
+			return fmt.Errorf("a value is required")
+		}
+		return nil
+	})
+}
+
+// askChoice prompts until the answer is one of choices
+func (w *Wizard) askChoice(prompt, def string, choices ...string) (string, error) {
+	return w.askValid(fmt.Sprintf("%s (%s)", prompt, strings.Join(choices, ", ")), def, func(answer string) error {
+		for _, choice := range choices {
+			if answer == choice {
+				return nil
+			}
+		}
+		return fmt.Errorf("choose one of %s", strings.Join(choices, ", "))
+	})
+}
+
+// askNumber prompts until the answer is a whole number of at least min and,
+// unless max is 0, at most max
+func (w *Wizard) askNumber(prompt string, def, min, max int) (int, error) {
+	answer, err := w.askValid(prompt, strconv.Itoa(def), func(answer string) error {
+		n, err := strconv.Atoi(answer)
+		if err != nil || n < min || (max > 0 && n > max) {
+			if max > 0 {
+				return fmt.Errorf("enter a number from %d to %d", min, max)
+			}
+			return fmt.Errorf("enter a number of at least %d", min)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(answer)
+}
+
+// yesNo asks a yes or no question
+func (w *Wizard) yesNo(prompt string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	answer, err := w.askValid(fmt.Sprintf("%s [%s]", prompt, hint), "", func(answer string) error {
+		switch strings.ToLower(answer) {
+		case "", "y", "yes", "n", "no":
+			return nil
+		}
+		return fmt.Errorf("answer y or n")
+	})
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return true, nil
+	case "n", "no":
+		return false, nil
+	}
+	return def, nil
+}
+
+// Marshal encodes cfg as a configuration file holding only the settings
+// that are set, stamped with the current config_version
+func Marshal(cfg *config.Config) ([]byte, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	var document map[string]any
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	document = prune(document).(map[string]any)
+	document["config_version"] = config.CurrentVersion
+
+	data, err = json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// prune drops the unset values of a decoded JSON document, returning nil
+// when nothing is left
+func prune(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			if child = prune(child); child == nil {
+				delete(v, key)
+			} else {
+				v[key] = child
+			}
+		}
+		if len(v) == 0 {
+			return nil
+		}
+	case []any:
+		kept := v[:0]
+		for _, child := range v {
+			if child = prune(child); child != nil {
+				kept = append(kept, child)
+			}
+		}
+		if len(kept) == 0 {
+			return nil
+		}
+		return kept
+	case string:
+		if v == "" {
+			return nil
+		}
+	case float64:
+		if v == 0 {
+			return nil
+		}
+	case bool:
+		if !v {
+			return nil
+		}
+	}
+	return value
+}
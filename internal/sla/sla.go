@@ -0,0 +1,325 @@
+// Package sla evaluates the backup freshness objectives of databases
+package sla
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/metrics"
+	"db-backuper/internal/pause"
+	"db-backuper/internal/status"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CheckInterval is how often the monitor evaluates the objectives
+const CheckInterval = time.Minute
+
+// Kinds of violations
+const (
+	KindMaxAge = "max_age"
+	KindMaxRPO = "max_rpo"
+)
+
+// Alerter sends alerts raised by the monitor
+type Alerter interface {
+	Alert(subject, body string, failed bool) error
+}
+
+// ViolationStore keeps the violations already alerted on across restarts
+type ViolationStore interface {
+	SLAViolations() []string
+	SetSLAViolations(keys []string) error
+}
+
+// Violation describes a database exceeding one of its objectives
+type Violation struct {
+	Database string
+	Kind     string
+	Age      time.Duration
+	Limit    time.Duration
+	// NeverSucceeded is set when no successful backup of the database is
+	// known, in which case Age is measured from when the monitor started
+	NeverSucceeded bool
+}
+
+// String describes the violation in one line
+func (v Violation) String() string {
+	switch {
+	case v.NeverSucceeded:
+		return fmt.Sprintf("%s: no successful backup in %s, exceeding its %s of %s", v.Database, formatDuration(v.Age), v.objective(), formatDuration(v.Limit))
+	case v.Kind == KindMaxRPO:
+		return fmt.Sprintf("%s: recovery point is %s old, exceeding its %s of %s", v.Database, formatDuration(v.Age), v.objective(), formatDuration(v.Limit))
+	default:
+		return fmt.Sprintf("%s: last successful backup is %s old, exceeding its %s of %s", v.Database, formatDuration(v.Age), v.objective(), formatDuration(v.Limit))
+	}
+}
+
+// objective names the violated objective
+func (v Violation) objective() string {
+	if v.Kind == KindMaxRPO {
+		return "max RPO"
+	}
+	return "max age"
+}
+
+// key identifies the objective the violation is about
+func (v Violation) key() string {
+	return v.Database + "/" + v.Kind
+}
+
+// Monitor checks the databases with objectives against the latest backup
+// results, alerting when a database enters or leaves violation and
+// publishing the backup age of each database
+type Monitor struct {
+	databases []config.DatabaseConfig
+	alerter   Alerter
+	publisher *metrics.Publisher
+	store     ViolationStore
+	pauses    *pause.File
+	logger    logrus.FieldLogger
+	startedAt time.Time
+
+	mu        sync.Mutex
+	report    *status.Report
+	violating map[string]Violation
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// NewMonitor creates a monitor for the databases of cfg that define an SLA.
+// report holds the results of previous runs and may be nil, as may alerter
+// and publisher.
+func NewMonitor(cfg *config.Config, report *status.Report, alerter Alerter, publisher *metrics.Publisher, logger logrus.FieldLogger) *Monitor {
+	m := &Monitor{
+		alerter:   alerter,
+		publisher: publisher,
+		logger:    logger,
+		startedAt: time.Now(),
+		report:    report,
+		violating: make(map[string]Violation),
+	}
+	for _, db := range cfg.Databases {
+		if db.IsEnabled() && db.SLA.Enabled() {
+			m.databases = append(m.databases, db)
+		}
+	}
+	return m
+}
+
+// Enabled returns true if any database defines an SLA
+func (m *Monitor) Enabled() bool {
+	return len(m.databases) > 0
+}
+
+// Persist keeps the violations alerted on in store, restoring those of the
+// previous process so a violation that outlives a restart is not alerted on again
+func (m *Monitor) Persist(store ViolationStore) {
+	m.store = store
+	for _, key := range store.SLAViolations() {
+		i := strings.LastIndex(key, "/")
+		if i < 0 {
+			continue
+		}
+		m.violating[key] = Violation{Database: key[:i], Kind: key[i+1:]}
+	}
+}
+
+// SkipPaused leaves databases with a maintenance pause in pauses out of the
+// checks. Violations alerted on before the pause are neither restored nor
+// alerted on again while it lasts.
+func (m *Monitor) SkipPaused(pauses *pause.File) {
+	m.pauses = pauses
+}
+
+// paused returns true if the database has a maintenance pause at now
+func (m *Monitor) paused(database string, now time.Time) bool {
+	if m.pauses == nil {
+		return false
+	}
+	_, ok := m.pauses.Paused(database, now)
+	return ok
+}
+
+// Update merges the results of a run into the monitored status
+func (m *Monitor) Update(summary *status.RunSummary) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.report = status.Merge(m.report, summary)
+}
+
+// Start checks the objectives now and then every CheckInterval until Stop is called
+func (m *Monitor) Start() {
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(CheckInterval)
+		defer ticker.Stop()
+		for {
+			m.Check(time.Now())
+			select {
+			case <-ticker.C:
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the periodic checks
+func (m *Monitor) Stop() {
+	if m.stop == nil {
+		return
+	}
+	close(m.stop)
+	<-m.done
+	m.stop = nil
+}
+
+// Evaluate returns the objectives violated at now, ordered by database
+func (m *Monitor) Evaluate(now time.Time) []Violation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var violations []Violation
+	for _, db := range m.databases {
+		if m.paused(db.Database, now) {
+			continue
+		}
+		finishedAt, startedAt, ok := m.lastSuccess(db.Database)
+		check := func(kind string, since time.Time, limit time.Duration) {
+			if limit <= 0 {
+				return
+			}
+			if age := now.Sub(since); age > limit {
+				violations = append(violations, Violation{Database: db.Database, Kind: kind, Age: age, Limit: limit, NeverSucceeded: !ok})
+			}
+		}
+		check(KindMaxAge, finishedAt, db.SLA.MaxAge())
+		check(KindMaxRPO, startedAt, db.SLA.MaxRPO())
+	}
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Database < violations[j].Database
+	})
+	return violations
+}
+
+// Check evaluates the objectives, alerts on every violation that started or
+// ended since the previous check and publishes the metrics of each database
+func (m *Monitor) Check(now time.Time) []Violation {
+	violations := m.Evaluate(now)
+
+	current := make(map[string]Violation, len(violations))
+	var started, ended []string
+	for _, v := range violations {
+		current[v.key()] = v
+		if _, ok := m.violating[v.key()]; !ok {
+			started = append(started, v.String())
+			m.logger.Warnf("SLA violated: %s", v)
+		}
+	}
+	for key, v := range m.violating {
+		if _, ok := current[key]; !ok {
+			if m.paused(v.Database, now) {
+				current[key] = v
+				continue
+			}
+			if !slices.Contains(ended, v.Database) {
+				ended = append(ended, v.Database)
+			}
+			m.logger.Infof("SLA restored: %s is within its %s", v.Database, v.objective())
+		}
+	}
+	m.violating = current
+	slices.Sort(ended)
+	if m.store != nil {
+		if err := m.store.SetSLAViolations(slices.Collect(maps.Keys(current))); err != nil {
+			m.logger.Warnf("Failed to save SLA state: %v", err)
+		}
+	}
+
+	if m.alerter != nil {
+		if len(started) > 0 {
+			subject := fmt.Sprintf("Backup SLA violated for %d database(s)", len(started))
+			if err := m.alerter.Alert(subject, strings.Join(started, "\n"), true); err != nil {
+				m.logger.Warnf("Failed to send SLA alert: %v", err)
+			}
+		}
+		if len(ended) > 0 {
+			subject := "Backup SLA restored for " + strings.Join(ended, ", ")
+			if err := m.alerter.Alert(subject, "", false); err != nil {
+				m.logger.Warnf("Failed to send SLA alert: %v", err)
+			}
+		}
+	}
+
+	if m.publisher != nil {
+		if err := m.publisher.PublishSamples(m.samples(now, current)); err != nil {
+			m.logger.Warnf("Failed to publish SLA metrics: %v", err)
+		}
+	}
+	return violations
+}
+
+// samples returns the backup age and violation state of each monitored database
+func (m *Monitor) samples(now time.Time, violating map[string]Violation) []metrics.Sample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	samples := make([]metrics.Sample, 0, 2*len(m.databases))
+	for _, db := range m.databases {
+		finishedAt, _, _ := m.lastSuccess(db.Database)
+		violated := 0.0
+		for _, kind := range []string{KindMaxAge, KindMaxRPO} {
+			if _, ok := violating[db.Database+"/"+kind]; ok {
+				violated = 1
+			}
+		}
+		samples = append(samples,
+			metrics.Sample{Name: metrics.BackupAgeSeconds, Value: now.Sub(finishedAt).Seconds(), Unit: metrics.UnitSeconds, Database: db.Database, Timestamp: now},
+			metrics.Sample{Name: metrics.SLAViolation, Value: violated, Unit: metrics.UnitCount, Database: db.Database, Timestamp: now},
+		)
+	}
+	return samples
+}
+
+// lastSuccess returns when the last successful backup of a database finished
+// and started. Without one, both are the monitor's start time, so a
+// scheduler that never completes a backup still violates its objectives.
+func (m *Monitor) lastSuccess(database string) (finishedAt, startedAt time.Time, ok bool) {
+	if m.report != nil {
+		for _, result := range m.report.Databases {
+			if result.Database != database || result.LastSuccessAt == nil {
+				continue
+			}
+			finishedAt = *result.LastSuccessAt
+			// Status files written before recovery points were recorded
+			// only know when the backup finished
+			startedAt = finishedAt
+			if result.LastSuccessStartedAt != nil {
+				startedAt = *result.LastSuccessStartedAt
+			}
+			return finishedAt, startedAt, true
+		}
+	}
+	return m.startedAt, m.startedAt, false
+}
+
+// formatDuration formats a duration rounded to the minute, e.g. 3h or 2h30m
+func formatDuration(d time.Duration) string {
+	if d < time.Minute {
+		return d.Round(time.Second).String()
+	}
+	text := strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+	if strings.HasSuffix(text, "h0m") {
+		text = strings.TrimSuffix(text, "0m")
+	}
+	return text
+}
@@ -0,0 +1,70 @@
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"db-backuper/internal/progress"
+)
+
+// RunResult is the outcome of a one-time run, as printed on exit and written
+// to the summary file
+type RunResult struct {
+	Tenant    string           `json:"tenant,omitempty"`
+	Run       *RunSummary      `json:"run"`
+	Databases []DatabaseResult `json:"databases"`
+}
+
+// NewRunResult returns the outcome of a run of a tenant, or of the only configuration when tenant is empty
+func NewRunResult(tenant string, summary *RunSummary) RunResult {
+	return RunResult{Tenant: tenant, Run: summary, Databases: summary.Databases}
+}
+
+// PrintRunResult prints a table of the databases of a run and its totals
+func PrintRunResult(out io.Writer, result RunResult) {
+	if result.Tenant != "" {
+		fmt.Fprintf(out, "\nTenant %s\n", result.Tenant)
+	} else {
+		fmt.Fprintln(out)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DATABASE\tSTATUS\tSIZE\tDURATION\tDESTINATION")
+	for _, db := range result.Databases {
+		size, destination := "-", "-"
+		if db.Status == ResultSuccess {
+			size, destination = progress.FormatBytes(db.SizeBytes), db.Location
+		}
+		duration := roundDuration(time.Duration(db.DurationSeconds * float64(time.Second)))
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", db.Database, db.Status, size, duration, destination)
+	}
+	w.Flush()
+
+	run := result.Run
+	fmt.Fprintf(out, "%d successful, %d failed, %d skipped in %s\n", run.Successful, run.Failed, run.Skipped, roundDuration(run.FinishedAt.Sub(run.StartedAt)))
+}
+
+// roundDuration rounds a duration to seconds, or to milliseconds below a second
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(time.Second)
+}
+
+// WriteSummaryFile writes the outcome of a one-time run, a RunResult or a
+// slice of them, as JSON
+func WriteSummaryFile(path string, results any) error {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode run summary: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write run summary: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+package status
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/s3"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SchemaVersion is bumped whenever the status file layout changes incompatibly
+const SchemaVersion = 1
+
+// Result values for a database backup
+const (
+	ResultSuccess  = "success"
+	ResultFailed   = "failed"
+	ResultSkipped  = "skipped"
+	ResultDisabled = "disabled"
+)
+
+// Sources of a measured restore
+const (
+	RestoreSourceRehearsal = "rehearsal"
+	RestoreSourceImport    = "import"
+)
+
+// MaxRestoreSamples is how many measured restores are kept per database
+const MaxRestoreSamples = 10
+
+// RestoreSample is the measured end-to-end duration of one restore of a
+// database, from the start of the download until the restored data was checked
+type RestoreSample struct {
+	Source          string    `json:"source"`
+	Key             string    `json:"key,omitempty"`
+	FinishedAt      time.Time `json:"finished_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	SizeBytes       int64     `json:"size_bytes,omitempty"`
+}
+
+// DatabaseResult holds the outcome of backing up a single database
+type DatabaseResult struct {
+	Database        string     `json:"database"`
+	Group           string     `json:"group,omitempty"`
+	Status          string     `json:"status"`
+	RunID           string     `json:"run_id"`
+	StartedAt       time.Time  `json:"started_at"`
+	FinishedAt      time.Time  `json:"finished_at"`
+	DurationSeconds float64    `json:"duration_seconds"`
+	SizeBytes       int64      `json:"size_bytes"`
+	Location        string     `json:"location,omitempty"`
+	Error           string     `json:"error,omitempty"`
+	LastSuccessAt   *time.Time `json:"last_success_at,omitempty"`
+	// LastSuccessStartedAt is the recovery point of the last successful backup
+	LastSuccessStartedAt *time.Time `json:"last_success_started_at,omitempty"`
+	// Disabled is set while the database is disabled; its last result is kept
+	Disabled bool `json:"disabled,omitempty"`
+	// Restores holds the most recent measured restores, oldest first
+	Restores []RestoreSample `json:"restores,omitempty"`
+	// RTOSeconds is the longest of the recent restores, the measured restore time objective
+	RTOSeconds float64 `json:"rto_seconds,omitempty"`
+}
+
+// RunSummary holds the outcome of a complete backup cycle
+type RunSummary struct {
+	RunID      string    `json:"run_id"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Storage    string    `json:"storage"`
+	Successful int       `json:"successful"`
+	Failed     int       `json:"failed"`
+	// Skipped counts the databases left out because the run budget ran out
+	Skipped int `json:"skipped"`
+	// ObjectsDeleted counts the backups removed by retention cleanup
+	ObjectsDeleted int `json:"objects_deleted"`
+	// Disabled lists the configured databases left out of the run because they are disabled
+	Disabled  []string         `json:"disabled,omitempty"`
+	Databases []DatabaseResult `json:"-"`
+}
+
+// Add records a database result and updates the counters
+func (r *RunSummary) Add(result DatabaseResult) {
+	switch result.Status {
+	case ResultSuccess:
+		r.Successful++
+	case ResultSkipped:
+		r.Skipped++
+	default:
+		r.Failed++
+	}
+	r.Databases = append(r.Databases, result)
+}
+
+// Unsuccessful returns the number of databases that failed or were skipped
+func (r *RunSummary) Unsuccessful() int {
+	return r.Failed + r.Skipped
+}
+
+// Result returns the outcome of the run: failed when any database failed,
+// skipped when any was skipped and success otherwise
+func (r *RunSummary) Result() string {
+	switch {
+	case r.Failed > 0:
+		return ResultFailed
+	case r.Skipped > 0:
+		return ResultSkipped
+	}
+	return ResultSuccess
+}
+
+// SkippedResult returns the result of a database left out of a run for reason
+func SkippedResult(database, reason string) DatabaseResult {
+	now := time.Now()
+	return DatabaseResult{
+		Database:   database,
+		Status:     ResultSkipped,
+		StartedAt:  now,
+		FinishedAt: now,
+		Error:      reason,
+	}
+}
+
+// Report is the document written to the status file
+type Report struct {
+	SchemaVersion int              `json:"schema_version"`
+	UpdatedAt     time.Time        `json:"updated_at"`
+	LastRun       RunSummary       `json:"last_run"`
+	Databases     []DatabaseResult `json:"databases"`
+}
+
+// Writer publishes the status file after each run
+type Writer struct {
+	config    *config.StatusConfig
+	s3Manager *s3.S3Manager
+	logger    logrus.FieldLogger
+}
+
+// NewWriter creates a new status writer; s3Manager may be nil when S3 is not used
+func NewWriter(statusConfig *config.StatusConfig, s3Manager *s3.S3Manager, logger logrus.FieldLogger) *Writer {
+	return &Writer{
+		config:    statusConfig,
+		s3Manager: s3Manager,
+		logger:    logger,
+	}
+}
+
+// Enabled returns true if a status file destination is configured
+func (w *Writer) Enabled() bool {
+	return w.config.Path != "" || (w.config.S3Key != "" && w.s3Manager != nil)
+}
+
+// Update merges the run into the existing status file and writes it back
+func (w *Writer) Update(summary *RunSummary) error {
+	if !w.Enabled() {
+		return nil
+	}
+
+	return w.update(func(previous *Report) *Report { return Merge(previous, summary) })
+}
+
+// RecordRestore adds a measured restore of database to the status file
+func (w *Writer) RecordRestore(database string, sample RestoreSample) error {
+	if !w.Enabled() {
+		return nil
+	}
+	return w.update(func(previous *Report) *Report { return AddRestore(previous, database, sample) })
+}
+
+// update applies a change to the status file in every configured destination
+func (w *Writer) update(apply func(previous *Report) *Report) error {
+	var errs []error
+	if w.config.Path != "" {
+		if err := w.updateLocal(apply); err != nil {
+			errs = append(errs, err)
+		} else {
+			w.logger.Infof("Status file updated: %s", w.config.Path)
+		}
+	}
+	if w.config.S3Key != "" && w.s3Manager != nil {
+		if err := w.updateS3(apply); err != nil {
+			errs = append(errs, err)
+		} else {
+			w.logger.Infof("Status object updated: %s", w.config.S3Key)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Load returns the published status document, or nil when there is none
+// yet or it cannot be read
+func (w *Writer) Load() *Report {
+	if w.config.Path != "" {
+		if data, err := os.ReadFile(w.config.Path); err == nil {
+			return w.decode(data)
+		}
+	}
+	if w.config.S3Key != "" && w.s3Manager != nil {
+		if data, err := w.s3Manager.GetObject(w.config.S3Key); err == nil {
+			return w.decode(data)
+		}
+	}
+	return nil
+}
+
+// updateLocal updates the status file on the local filesystem
+func (w *Writer) updateLocal(apply func(previous *Report) *Report) error {
+	var previous *Report
+	if data, err := os.ReadFile(w.config.Path); err == nil {
+		previous = w.decode(data)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read status file: %w", err)
+	}
+
+	data, err := json.MarshalIndent(apply(previous), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode status file: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(w.config.Path), 0755); err != nil {
+		return fmt.Errorf("failed to create status directory: %w", err)
+	}
+
+	// Write to a temporary file first so readers never see a partial document
+	tmpPath := w.config.Path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write status file: %w", err)
+	}
+	if err := os.Rename(tmpPath, w.config.Path); err != nil {
+		return fmt.Errorf("failed to replace status file: %w", err)
+	}
+	return nil
+}
+
+// updateS3 updates the status object in S3
+func (w *Writer) updateS3(apply func(previous *Report) *Report) error {
+	var previous *Report
+	data, err := w.s3Manager.GetObject(w.config.S3Key)
+	if err == nil {
+		previous = w.decode(data)
+	} else if !errors.Is(err, s3.ErrObjectNotFound) {
+		return fmt.Errorf("failed to read status object: %w", err)
+	}
+
+	data, err = json.MarshalIndent(apply(previous), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode status object: %w", err)
+	}
+	return w.s3Manager.PutObject(w.config.S3Key, data, "application/json")
+}
+
+// decode parses a previous status document, ignoring unreadable or incompatible ones
+func (w *Writer) decode(data []byte) *Report {
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		w.logger.Warnf("Ignoring unreadable status file: %v", err)
+		return nil
+	}
+	if report.SchemaVersion != SchemaVersion {
+		w.logger.Warnf("Ignoring status file with schema version %d", report.SchemaVersion)
+		return nil
+	}
+	return &report
+}
+
+// Merge combines a previous report with a new run. Databases not part of the
+// run keep their previous entry, and the last success times carry over
+// across failed runs. Disabled databases keep their previous result, marked
+// as disabled.
+func Merge(previous *Report, summary *RunSummary) *Report {
+	byName := make(map[string]DatabaseResult)
+	if previous != nil {
+		for _, db := range previous.Databases {
+			byName[db.Database] = db
+		}
+	}
+
+	for _, result := range summary.Databases {
+		if result.Status == ResultSuccess {
+			startedAt, finishedAt := result.StartedAt, result.FinishedAt
+			result.LastSuccessAt = &finishedAt
+			result.LastSuccessStartedAt = &startedAt
+		} else if prev, ok := byName[result.Database]; ok {
+			result.LastSuccessAt = prev.LastSuccessAt
+			result.LastSuccessStartedAt = prev.LastSuccessStartedAt
+		}
+		if prev, ok := byName[result.Database]; ok {
+			result.Restores, result.RTOSeconds = prev.Restores, prev.RTOSeconds
+		}
+		byName[result.Database] = result
+	}
+	for _, name := range summary.Disabled {
+		result, ok := byName[name]
+		if !ok {
+			result = DatabaseResult{Database: name, Status: ResultDisabled}
+		}
+		result.Disabled = true
+		byName[name] = result
+	}
+
+	databases := make([]DatabaseResult, 0, len(byName))
+	for _, db := range byName {
+		databases = append(databases, db)
+	}
+	sort.Slice(databases, func(i, j int) bool {
+		return databases[i].Database < databases[j].Database
+	})
+
+	return &Report{
+		SchemaVersion: SchemaVersion,
+		UpdatedAt:     summary.FinishedAt,
+		LastRun:       *summary,
+		Databases:     databases,
+	}
+}
+
+// AddRestore adds a measured restore of database to a previous report. Only
+// the last MaxRestoreSamples restores are kept, and the longest of them
+// becomes the database's restore time objective.
+func AddRestore(previous *Report, database string, sample RestoreSample) *Report {
+	report := &Report{SchemaVersion: SchemaVersion}
+	if previous != nil {
+		report = previous
+	}
+	report.UpdatedAt = sample.FinishedAt
+
+	index := slices.IndexFunc(report.Databases, func(db DatabaseResult) bool { return db.Database == database })
+	if index < 0 {
+		report.Databases = append(report.Databases, DatabaseResult{Database: database})
+		sort.Slice(report.Databases, func(i, j int) bool {
+			return report.Databases[i].Database < report.Databases[j].Database
+		})
+		index = slices.IndexFunc(report.Databases, func(db DatabaseResult) bool { return db.Database == database })
+	}
+
+	db := &report.Databases[index]
+	db.Restores = append(db.Restores, sample)
+	if len(db.Restores) > MaxRestoreSamples {
+		db.Restores = db.Restores[len(db.Restores)-MaxRestoreSamples:]
+	}
+	db.RTOSeconds = 0
+	for _, restore := range db.Restores {
+		db.RTOSeconds = max(db.RTOSeconds, restore.DurationSeconds)
+	}
+	return report
+}
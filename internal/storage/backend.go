@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"db-backuper/internal/provenance"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Backend is implemented by every backup storage backend. Keys are slash
+// separated paths relative to the storage root, e.g.
+// backup-prefix/database/YYYY-MM-DD/database_YYYY-MM-DD_HH-MM-SS.sql
+type Backend interface {
+	// Location returns a human readable description of the storage target
+	Location() string
+	// ListKeys returns the keys of every backup under prefix
+	ListKeys(prefix string) ([]string, error)
+	// ListBackups returns one page of the backups selected by query
+	ListBackups(query ListQuery) (*ListPage, error)
+	// DeleteBackups deletes the given keys and returns the keys that were deleted
+	DeleteBackups(keys []string) ([]string, error)
+	// Download copies the backup stored under key to destPath
+	Download(key, destPath string) error
+	// UploadFile stores the local file at localPath under key, recording
+	// meta with it when given
+	UploadFile(localPath, key string, meta *provenance.Metadata) error
+	// Metadata returns the provenance recorded with the backup stored under
+	// key, or nil when it was stored without any
+	Metadata(key string) (*provenance.Metadata, error)
+	// DeleteOldBackups deletes the backups under backupPrefix older than
+	// retentionDays and returns how many objects were deleted
+	DeleteOldBackups(backupPrefix string, retentionDays int) (int, error)
+	// TestConnection checks that the storage target can be reached
+	TestConnection() error
+	// WithLogger returns a copy of the backend that logs through logger
+	WithLogger(logger logrus.FieldLogger) Backend
+}
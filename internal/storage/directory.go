@@ -0,0 +1,370 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"db-backuper/internal/provenance"
+)
+
+// DirectorySuffix marks the directory holding the files of a backup stored
+// as one object per file
+const DirectorySuffix = ".dir"
+
+// PartsSuffix marks the directory holding the parts of a backup split into
+// fixed-size objects
+const PartsSuffix = ".parts"
+
+// DirectoryIndexSuffix marks the index of a backup stored as one object per
+// file. The files are stored under the index key without its .json suffix,
+// e.g. <prefix>/<database>/<YYYY-MM-DD>/<name>.dir/toc.dat next to
+// <prefix>/<database>/<YYYY-MM-DD>/<name>.dir.json. The index is stored
+// last, so a listed backup always has all of its files.
+const DirectoryIndexSuffix = DirectorySuffix + ".json"
+
+// PartsIndexSuffix marks the index of a backup split into parts, laid out
+// like a directory backup: <name>.parts/part-00001 and so on next to
+// <name>.parts.json. The parts are concatenated again when downloaded.
+const PartsIndexSuffix = PartsSuffix + ".json"
+
+// DirectoryIndex lists the files of a backup stored as several objects,
+// either the files of a directory backup or the parts of a split one
+type DirectoryIndex struct {
+	Files []DirectoryFile `json:"files"`
+}
+
+// DirectoryFile is one file of a directory backup or one part of a split backup
+type DirectoryFile struct {
+	// Name is the slash separated path of the file within the directory
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// IsDirectoryIndex reports whether key or path is the index of a directory backup
+func IsDirectoryIndex(key string) bool {
+	return strings.HasSuffix(key, DirectoryIndexSuffix)
+}
+
+// IsPartsIndex reports whether key or path is the index of a split backup
+func IsPartsIndex(key string) bool {
+	return strings.HasSuffix(key, PartsIndexSuffix)
+}
+
+// IsIndex reports whether key or path is the index of a backup stored as
+// several objects, a directory backup or a split one
+func IsIndex(key string) bool {
+	return IsDirectoryIndex(key) || IsPartsIndex(key)
+}
+
+// DirectoryPath returns the directory holding the files or parts of the
+// backup whose index is stored under key or path
+func DirectoryPath(index string) string {
+	return strings.TrimSuffix(index, ".json")
+}
+
+// IndexOf returns the index of the backup key belongs to when key is one of
+// the files or parts stored next to an index, or their directory
+func IndexOf(key string) (string, bool) {
+	for _, suffix := range []string{DirectorySuffix, PartsSuffix} {
+		if i := strings.Index(key+"/", suffix+"/"); i >= 0 {
+			return key[:i] + suffix + ".json", true
+		}
+	}
+	return "", false
+}
+
+// WriteDirectoryIndex lists the files under dir with their sizes and
+// checksums in an index written to indexPath
+func WriteDirectoryIndex(dir, indexPath string) error {
+	var index DirectoryIndex
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		checksum, err := provenance.Checksum(p)
+		if err != nil {
+			return err
+		}
+		index.Files = append(index.Files, DirectoryFile{Name: filepath.ToSlash(rel), Size: info.Size(), SHA256: checksum})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to index %s: %w", dir, err)
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode directory index: %w", err)
+	}
+	if err := os.WriteFile(indexPath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write directory index: %w", err)
+	}
+	return nil
+}
+
+// ReadDirectoryIndex reads the index of a directory backup. File names
+// leaving the directory are rejected.
+func ReadDirectoryIndex(indexPath string) (*DirectoryIndex, error) {
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory index: %w", err)
+	}
+	var index DirectoryIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse directory index %s: %w", indexPath, err)
+	}
+	for _, file := range index.Files {
+		if !fs.ValidPath(file.Name) || file.Name == "." {
+			return nil, fmt.Errorf("invalid file name %q in directory index %s", file.Name, indexPath)
+		}
+	}
+	return &index, nil
+}
+
+// EachDirectoryFile runs fn for every file of index, at most jobs at a
+// time, and returns the first error. No file is started after a failure.
+func EachDirectoryFile(index *DirectoryIndex, jobs int, fn func(DirectoryFile) error) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	slots := make(chan struct{}, max(jobs, 1))
+	for _, file := range index.Files {
+		slots <- struct{}{}
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			<-slots
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if err := fn(file); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s: %w", file.Name, err)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// uploadRetries is how many times a file or part that fails to upload is
+// uploaded again on its own before the upload fails
+const uploadRetries = 3
+
+// UploadDirectory stores the files listed in the index at indexPath next to
+// indexKey, uploading jobs files at a time. A file that fails is uploaded
+// again on its own. The index itself is left to the caller, to be stored
+// once every file is.
+func UploadDirectory(backend Backend, indexPath, indexKey string, jobs int) error {
+	index, err := ReadDirectoryIndex(indexPath)
+	if err != nil {
+		return err
+	}
+	localDir, keyDir := DirectoryPath(indexPath), DirectoryPath(indexKey)
+	return EachDirectoryFile(index, jobs, func(file DirectoryFile) error {
+		var err error
+		for attempt := 1; attempt <= uploadRetries; attempt++ {
+			if err = backend.UploadFile(filepath.Join(localDir, filepath.FromSlash(file.Name)), path.Join(keyDir, file.Name), nil); err == nil {
+				return nil
+			}
+		}
+		return err
+	})
+}
+
+// Fetch downloads the backup stored under key to destPath and returns the
+// path of the downloaded backup. The files of a directory backup are
+// downloaded jobs at a time into the directory next to destPath, and the
+// parts of a split backup are concatenated into destPath without its
+// .parts.json suffix, which is returned instead.
+func Fetch(backend Backend, key, destPath string, jobs int) (string, error) {
+	if IsPartsIndex(key) && !IsPartsIndex(destPath) {
+		destPath += PartsIndexSuffix
+	}
+	if err := FetchFiles(backend, key, destPath, jobs); err != nil {
+		return "", err
+	}
+	if !IsPartsIndex(key) {
+		return destPath, nil
+	}
+	return AssembleParts(destPath)
+}
+
+// FetchFiles downloads the object stored under key to destPath and, when it
+// is an index, its files or parts jobs at a time into the directory next to
+// destPath, checking each against the sizes and checksums of the index
+func FetchFiles(backend Backend, key, destPath string, jobs int) error {
+	if err := backend.Download(key, destPath); err != nil {
+		return err
+	}
+	if !IsIndex(key) {
+		return nil
+	}
+
+	index, err := ReadDirectoryIndex(destPath)
+	if err != nil {
+		return err
+	}
+	localDir, keyDir := DirectoryPath(destPath), DirectoryPath(key)
+	if err := os.RemoveAll(localDir); err != nil {
+		return fmt.Errorf("failed to clear %s: %w", localDir, err)
+	}
+	return EachDirectoryFile(index, jobs, func(file DirectoryFile) error {
+		filePath := filepath.Join(localDir, filepath.FromSlash(file.Name))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		if err := backend.Download(path.Join(keyDir, file.Name), filePath); err != nil {
+			return err
+		}
+		return checkDirectoryFile(filePath, file)
+	})
+}
+
+// SplitFile splits the file at filePath into parts of at most partSize
+// bytes, written to <filePath>.parts/ with their index at
+// <filePath>.parts.json, and returns the path of the index
+func SplitFile(filePath string, partSize int64) (string, error) {
+	indexPath := filePath + PartsIndexSuffix
+	partsDir := DirectoryPath(indexPath)
+	if err := os.RemoveAll(partsDir); err != nil {
+		return "", fmt.Errorf("failed to clear %s: %w", partsDir, err)
+	}
+	if err := os.MkdirAll(partsDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", partsDir, err)
+	}
+
+	in, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	for part := 1; ; part++ {
+		partPath := filepath.Join(partsDir, fmt.Sprintf("part-%05d", part))
+		out, err := os.Create(partPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to create part: %w", err)
+		}
+		n, err := io.CopyN(out, in, partSize)
+		closeErr := out.Close()
+		if err != nil && err != io.EOF {
+			return "", fmt.Errorf("failed to write part: %w", err)
+		}
+		if closeErr != nil {
+			return "", fmt.Errorf("failed to write part: %w", closeErr)
+		}
+		if n == 0 && part > 1 {
+			os.Remove(partPath)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if err := WriteDirectoryIndex(partsDir, indexPath); err != nil {
+		return "", err
+	}
+	return indexPath, nil
+}
+
+// AssembleParts concatenates the parts of a split backup downloaded next to
+// its index at indexPath, in the order of the index, into the file the
+// backup was split from and removes the parts and the index
+func AssembleParts(indexPath string) (string, error) {
+	index, err := ReadDirectoryIndex(indexPath)
+	if err != nil {
+		return "", err
+	}
+	destPath := strings.TrimSuffix(indexPath, PartsIndexSuffix)
+	out, err := os.Create(destPath + ".part")
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", destPath, err)
+	}
+	for _, file := range index.Files {
+		if err := appendFile(out, filepath.Join(DirectoryPath(indexPath), filepath.FromSlash(file.Name))); err != nil {
+			out.Close()
+			os.Remove(out.Name())
+			return "", fmt.Errorf("failed to assemble %s: %w", destPath, err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("failed to assemble %s: %w", destPath, err)
+	}
+	if err := os.Rename(out.Name(), destPath); err != nil {
+		return "", fmt.Errorf("failed to move %s into place: %w", destPath, err)
+	}
+	if err := RemoveDirectoryBackup(indexPath); err != nil {
+		return "", err
+	}
+	return destPath, nil
+}
+
+// appendFile copies the file at src to the end of out
+func appendFile(out io.Writer, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// checkDirectoryFile compares a downloaded file with its entry in the index
+func checkDirectoryFile(filePath string, file DirectoryFile) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	if info.Size() != file.Size {
+		return fmt.Errorf("size mismatch: expected %d bytes, downloaded %d", file.Size, info.Size())
+	}
+	checksum, err := provenance.Checksum(filePath)
+	if err != nil {
+		return err
+	}
+	if checksum != file.SHA256 {
+		return fmt.Errorf("checksum mismatch: expected sha256 %s, downloaded file has %s", file.SHA256, checksum)
+	}
+	return nil
+}
+
+// RemoveDirectoryBackup removes a local backup stored as several objects:
+// its index and the directory of files or parts next to it
+func RemoveDirectoryBackup(indexPath string) error {
+	if err := os.RemoveAll(DirectoryPath(indexPath)); err != nil {
+		return err
+	}
+	if err := os.Remove(indexPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package storage
+
+import "errors"
+
+// freeBytes is not supported on this platform
+func freeBytes(path string) (uint64, error) {
+	return 0, errors.New("free space checks are not supported on this platform")
+}
+
+// sameDevice cannot tell filesystems apart on this platform
+func sameDevice(a, b string) bool {
+	return false
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"db-backuper/internal/provenance"
+)
+
+// DownloadDestination resolves the -output flag of a download to a file
+// path: the current directory without one, a directory holding filename
+// when output is a directory or ends with a separator, else output itself.
+// Missing directories are created.
+func DownloadDestination(output, filename string) (string, error) {
+	if output == "" {
+		return filename, nil
+	}
+
+	// A trailing separator names a directory that may not exist yet
+	if strings.HasSuffix(output, "/") || strings.HasSuffix(output, string(filepath.Separator)) {
+		if err := os.MkdirAll(output, 0755); err != nil {
+			return "", fmt.Errorf("failed to create output directory: %w", err)
+		}
+		return filepath.Join(output, filename), nil
+	}
+
+	info, err := os.Stat(output)
+	if err == nil && info.IsDir() {
+		return filepath.Join(output, filename), nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to access %s: %w", output, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return "", fmt.Errorf("failed to create output directory: %w", err)
+	}
+	return output, nil
+}
+
+// VerifyDownload checks the copy of the backup stored under key downloaded
+// to destPath against the checksum recorded with it, returning the recorded
+// provenance. Backups stored without provenance are accepted as they are. A
+// table manifest is saved next to the download for the row count check of a
+// later import.
+func VerifyDownload(backend Backend, key, destPath string) (*provenance.Metadata, error) {
+	meta, err := backend.Metadata(key)
+	if err != nil || meta == nil || meta.SHA256 == "" {
+		return meta, err
+	}
+	checksum, err := provenance.Checksum(destPath)
+	if err != nil {
+		return meta, err
+	}
+	if checksum != meta.SHA256 {
+		return meta, fmt.Errorf("checksum mismatch for %s: expected sha256 %s, downloaded file has %s", key, meta.SHA256, checksum)
+	}
+	if len(meta.Tables) > 0 {
+		return meta, meta.WriteSidecar(destPath)
+	}
+	return meta, nil
+}
+
+// Decompress replaces the gzip compressed file at path, named .gz, with its
+// contents and returns the path of the decompressed file. A manifest saved
+// next to it is moved along with the checksum of the decompressed file.
+// Files not named .gz are left as they are.
+func Decompress(path string) (string, error) {
+	decompressed, ok := strings.CutSuffix(path, ".gz")
+	if !ok {
+		return path, nil
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer in.Close()
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress %s: %w", path, err)
+	}
+
+	// Write next to the result so an interrupted run never leaves it half written
+	tmpPath := decompressed + ".part"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", tmpPath, err)
+	}
+	if _, err := io.Copy(out, gz); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to decompress %s: %w", path, err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to write %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, decompressed); err != nil {
+		return "", fmt.Errorf("failed to move decompressed file into place: %w", err)
+	}
+	in.Close()
+	if err := os.Remove(path); err != nil {
+		return "", fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+
+	meta, err := provenance.ReadSidecar(path)
+	if err != nil || meta == nil {
+		return decompressed, err
+	}
+	if meta.SHA256, err = provenance.Checksum(decompressed); err != nil {
+		return "", err
+	}
+	meta.Compression = provenance.CompressionNone
+	if err := meta.WriteSidecar(decompressed); err != nil {
+		return "", err
+	}
+	os.Remove(path + provenance.SidecarSuffix)
+	return decompressed, nil
+}
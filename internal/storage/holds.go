@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"db-backuper/internal/provenance"
+)
+
+// CatalogDir is the folder under the backup prefix holding the restore point
+// catalog and the retention holds
+const CatalogDir = "_catalog"
+
+// holdsFile is the name of the object listing the retention holds
+const holdsFile = "holds.json"
+
+// Hold exempts a backup from retention cleanup, for example during a legal
+// hold or an investigation, until it is released
+type Hold struct {
+	Key       string    `json:"key"`
+	Database  string    `json:"database,omitempty"`
+	Reason    string    `json:"reason"`
+	Actor     string    `json:"actor,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// holdsDocument is the holds object stored next to the catalog
+type holdsDocument struct {
+	Holds []Hold `json:"holds"`
+}
+
+// HoldsKey returns the key of the holds object of the backups under backupPrefix
+func HoldsKey(backupPrefix string) string {
+	return path.Join(backupPrefix, CatalogDir, holdsFile)
+}
+
+// LoadHolds returns the retention holds of the backups under backupPrefix,
+// oldest first
+func LoadHolds(backend Backend, backupPrefix string) ([]Hold, error) {
+	key := HoldsKey(backupPrefix)
+	keys, err := backend.ListKeys(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up retention holds: %w", err)
+	}
+	if !slices.Contains(keys, key) {
+		return nil, nil
+	}
+
+	tmp, err := os.CreateTemp("", "db-backuper-holds-*.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary holds file: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := backend.Download(key, tmp.Name()); err != nil {
+		return nil, fmt.Errorf("failed to read retention holds: %w", err)
+	}
+	data, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read retention holds: %w", err)
+	}
+
+	var doc holdsDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode retention holds %s: %w", key, err)
+	}
+	return doc.Holds, nil
+}
+
+// SaveHolds replaces the retention holds of the backups under backupPrefix
+func SaveHolds(backend Backend, backupPrefix string, holds []Hold) error {
+	data, err := json.MarshalIndent(holdsDocument{Holds: holds}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode retention holds: %w", err)
+	}
+
+	tmp, err := os.CreateTemp("", "db-backuper-holds-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary holds file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary holds file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write temporary holds file: %w", err)
+	}
+
+	if err := backend.UploadFile(tmp.Name(), HoldsKey(backupPrefix), nil); err != nil {
+		return fmt.Errorf("failed to write retention holds: %w", err)
+	}
+	return nil
+}
+
+// HeldKeys returns the set of held backup keys under backupPrefix. Retention
+// cleanup stops when it fails, so a hold is never missed.
+func HeldKeys(backend Backend, backupPrefix string) (map[string]bool, error) {
+	holds, err := LoadHolds(backend, backupPrefix)
+	if err != nil {
+		return nil, err
+	}
+	held := make(map[string]bool, len(holds))
+	for _, hold := range holds {
+		held[hold.Key] = true
+	}
+	return held, nil
+}
+
+// IsHeld reports whether key is a held backup, the metadata sidecar of one
+// or one of the files or parts of a held backup
+func IsHeld(held map[string]bool, key string) bool {
+	if index, ok := IndexOf(key); ok && held[index] {
+		return true
+	}
+	return held[strings.TrimSuffix(key, provenance.SidecarSuffix)]
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// instancesFile is the name of the object recording the instances backing up
+// into a prefix
+const instancesFile = "instances.json"
+
+// instanceForgetAfter is how long an instance that stopped backing up into a
+// prefix stays recorded
+const instanceForgetAfter = 30 * 24 * time.Hour
+
+// InstanceClaim records the last run of an instance backing up into a prefix
+type InstanceClaim struct {
+	Instance string    `json:"instance"`
+	Host     string    `json:"host,omitempty"`
+	RunID    string    `json:"run_id,omitempty"`
+	LastRun  time.Time `json:"last_run"`
+}
+
+// instancesDocument is the instances object stored next to the catalog
+type instancesDocument struct {
+	Instances []InstanceClaim `json:"instances"`
+}
+
+// InstancesKey returns the key of the instances object of the backups under backupPrefix
+func InstancesKey(backupPrefix string) string {
+	return path.Join(backupPrefix, CatalogDir, instancesFile)
+}
+
+// ClaimPrefix records a run of claim.Instance under backupPrefix. It returns
+// the other instances that ran there since the previous run of this one,
+// which means two deployments share the prefix. On the first run of an
+// instance every other recorded instance is returned and first is true,
+// since it may be the same deployment under its former name.
+func ClaimPrefix(backend Backend, backupPrefix string, claim InstanceClaim) (others []InstanceClaim, first bool, err error) {
+	key := InstancesKey(backupPrefix)
+	var doc instancesDocument
+	keys, err := backend.ListKeys(key)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to look up instances: %w", err)
+	}
+	if slices.Contains(keys, key) {
+		if err := downloadJSON(backend, key, &doc); err != nil {
+			return nil, false, fmt.Errorf("failed to read instances: %w", err)
+		}
+	}
+
+	var previous time.Time
+	if i := slices.IndexFunc(doc.Instances, func(c InstanceClaim) bool { return c.Instance == claim.Instance }); i >= 0 {
+		previous = doc.Instances[i].LastRun
+	}
+	first = previous.IsZero()
+	kept := []InstanceClaim{claim}
+	for _, c := range doc.Instances {
+		if c.Instance == claim.Instance || claim.LastRun.Sub(c.LastRun) > instanceForgetAfter {
+			continue
+		}
+		if c.LastRun.After(previous) {
+			others = append(others, c)
+		}
+		kept = append(kept, c)
+	}
+
+	if err := uploadJSON(backend, key, instancesDocument{Instances: kept}); err != nil {
+		return others, first, fmt.Errorf("failed to record instance: %w", err)
+	}
+	return others, first, nil
+}
+
+// CheckInstance records a run of claim.Instance under backupPrefix and logs
+// the other deployments found backing up into the same prefix
+func CheckInstance(backend Backend, backupPrefix string, claim InstanceClaim, logger logrus.FieldLogger) {
+	others, first, err := ClaimPrefix(backend, backupPrefix, claim)
+	if err != nil {
+		logger.Warnf("Failed to record instance %s under %s in %s: %v", claim.Instance, backupPrefix, backend.Location(), err)
+	}
+	for _, other := range others {
+		if first {
+			logger.Infof("Backups under %s in %s were previously written by instance %s (host %s) at %s",
+				backupPrefix, backend.Location(), other.Instance, other.Host, other.LastRun.Format(time.RFC3339))
+			continue
+		}
+		logger.Warnf("Instance %s (host %s) also backs up into %s in %s, last at %s; give each deployment its own backup prefix, or set backup.instance when it is this deployment under another name",
+			other.Instance, other.Host, backupPrefix, backend.Location(), other.LastRun.Format(time.RFC3339))
+	}
+}
+
+// downloadJSON decodes the JSON object stored under key into v
+func downloadJSON(backend Backend, key string, v any) error {
+	tmp, err := os.CreateTemp("", "db-backuper-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := backend.Download(key, tmp.Name()); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", key, err)
+	}
+	return nil
+}
+
+// uploadJSON stores v as a JSON object under key
+func uploadJSON(backend Backend, key string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", key, err)
+	}
+	tmp, err := os.CreateTemp("", "db-backuper-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	return backend.UploadFile(tmp.Name(), key, nil)
+}
@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"path"
+	"time"
+)
+
+// LatestLink names the symlink in a local database directory that points at
+// the database's newest backup
+const LatestLink = "latest"
+
+// LatestObject names the object in an S3 database prefix that records the
+// database's newest backup
+const LatestObject = "latest.json"
+
+// LatestPointer records the newest backup of a database so downstream jobs
+// can fetch it without listing
+type LatestPointer struct {
+	Database  string    `json:"database"`
+	Key       string    `json:"key"`
+	SizeBytes int64     `json:"size_bytes"`
+	SHA256    string    `json:"sha256,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// LatestKey returns the key of the latest pointer object of a database
+func LatestKey(backupPrefix, databaseName string) string {
+	return path.Join(backupPrefix, databaseName, LatestObject)
+}
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"db-backuper/internal/provenance"
+)
+
+// DefaultPageSize is the number of entries returned per page when a query sets none
+const DefaultPageSize = 1000
+
+// DateLayout is the layout of the date directory in backup keys
+const DateLayout = "2006-01-02"
+
+// Entry describes a stored backup
+type Entry struct {
+	Key          string    `json:"key"`
+	Database     string    `json:"database"`
+	Date         time.Time `json:"date"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	// StorageClass is the S3 storage class of the backup, empty for other storage
+	StorageClass string `json:"storage_class,omitempty"`
+}
+
+// ListQuery selects the backups returned by ListBackups
+type ListQuery struct {
+	// Prefix is the backup prefix the backups are stored under
+	Prefix string
+	// Database restricts the listing to one database; empty lists every database
+	Database string
+	// Since and Until bound the backup date, inclusive; zero values leave the range open
+	Since time.Time
+	Until time.Time
+	// PageSize is the maximum number of entries per page (default: DefaultPageSize)
+	PageSize int
+	// PageToken continues the listing after the page that returned it
+	PageToken string
+}
+
+// ListPage is one page of a backup listing. NextPageToken is empty on the last page.
+type ListPage struct {
+	Entries       []Entry
+	NextPageToken string
+}
+
+// Limit returns the maximum number of entries per page
+func (q ListQuery) Limit() int {
+	if q.PageSize <= 0 {
+		return DefaultPageSize
+	}
+	return q.PageSize
+}
+
+// KeyPrefix returns the key prefix covering the query's backups
+func (q ListQuery) KeyPrefix() string {
+	prefix := strings.TrimSuffix(q.Prefix, "/") + "/"
+	if q.Database != "" {
+		prefix += q.Database + "/"
+	}
+	return prefix
+}
+
+// Match parses key and reports whether it is a backup selected by the query
+func (q ListQuery) Match(key string) (Entry, bool) {
+	database, date, ok := ParseKey(q.Prefix, key)
+	if !ok {
+		return Entry{}, false
+	}
+	if q.Database != "" && database != q.Database {
+		return Entry{}, false
+	}
+	if !q.Since.IsZero() && date.Before(truncateDay(q.Since)) {
+		return Entry{}, false
+	}
+	if !q.Until.IsZero() && date.After(truncateDay(q.Until)) {
+		return Entry{}, false
+	}
+	return Entry{Key: key, Database: database, Date: date}, true
+}
+
+// PastUntil reports whether a key of a single database listing sorts after
+// the query's date range, so the rest of the listing can be skipped
+func (q ListQuery) PastUntil(key string) bool {
+	if q.Database == "" || q.Until.IsZero() {
+		return false
+	}
+	_, date, ok := ParseKey(q.Prefix, key)
+	return ok && date.After(truncateDay(q.Until))
+}
+
+// ParseKey splits a key laid out as <prefix>/<database>/<YYYY-MM-DD>/<file>
+// into its database and date. Keys laid out differently, such as the
+// restore point catalog, are reported as not ok.
+func ParseKey(prefix, key string) (string, time.Time, bool) {
+	rest, ok := strings.CutPrefix(key, strings.TrimSuffix(prefix, "/")+"/")
+	if !ok {
+		return "", time.Time{}, false
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return "", time.Time{}, false
+	}
+	date, err := time.Parse(DateLayout, parts[1])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return parts[0], date, true
+}
+
+// backupTimestamp matches the timestamp in the names of backup files
+var backupTimestamp = regexp.MustCompile(`\d{4}-\d{2}-\d{2}_\d{2}-\d{2}-\d{2}`)
+
+// BackupName returns the name a backup file is stored under: its local name
+// with the ID of the run that took it after the timestamp, such as
+// orders_2024-01-15_02-00-00_01HM7Z8X4T2V6C9R3K5N1QWJBE.sql, so backups of a
+// database taken in the same second, or by parallel runs on several hosts,
+// never share a key. Names are kept when meta records no run.
+func BackupName(filename string, meta *provenance.Metadata) string {
+	if meta == nil || meta.RunID == "" || strings.Contains(filename, meta.RunID) {
+		return filename
+	}
+	at := len(filename)
+	if loc := backupTimestamp.FindStringIndex(filename); loc != nil {
+		at = loc[1]
+	} else if dot := strings.Index(filename, "."); dot > 0 {
+		at = dot
+	}
+	return filename[:at] + "_" + meta.RunID + filename[at:]
+}
+
+// ChangesDir is the directory within a date directory holding the change
+// batches captured between full dumps
+const ChangesDir = "changes"
+
+// ParseChangeKey splits the key of a change batch, laid out as
+// <prefix>/<database>/<YYYY-MM-DD>/changes/<file>, into its database and date
+func ParseChangeKey(prefix, key string) (string, time.Time, bool) {
+	dir, file := path.Split(key)
+	dir = path.Clean(dir)
+	if file == "" || path.Base(dir) != ChangesDir {
+		return "", time.Time{}, false
+	}
+	return ParseKey(prefix, path.Join(path.Dir(dir), file))
+}
+
+// IsReserved reports whether key belongs to the service's own bookkeeping,
+// such as the restore point catalog, which lives in directories under the
+// prefix whose names start with an underscore
+func IsReserved(prefix, key string) bool {
+	rest, ok := strings.CutPrefix(key, strings.TrimSuffix(prefix, "/")+"/")
+	return ok && strings.HasPrefix(rest, "_")
+}
+
+// truncateDay drops the time of day so dates compare by calendar day
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// AllBackups follows the pages of a listing and returns every entry
+func AllBackups(backend Backend, query ListQuery) ([]Entry, error) {
+	var entries []Entry
+	for {
+		page, err := backend.ListBackups(query)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, page.Entries...)
+		if page.NextPageToken == "" {
+			return entries, nil
+		}
+		query.PageToken = page.NextPageToken
+	}
+}
@@ -1,25 +1,41 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"db-backuper/internal/audit"
 	"db-backuper/internal/config"
+	"db-backuper/internal/provenance"
 
 	"github.com/sirupsen/logrus"
 )
 
+// PartialSuffix ends the hidden temporary name a file is written under in
+// local storage until it is complete
+const PartialSuffix = ".part"
+
 // LocalStorage handles local file system operations
 type LocalStorage struct {
-	config *config.LocalConfig
-	logger *logrus.Logger
+	config   *config.LocalConfig
+	logger   logrus.FieldLogger
+	auditLog *audit.Log
+
+	modTimeRetention bool
+	transferJobs     int
+	instance         string
 }
 
 // NewLocalStorage creates a new local storage instance
-func NewLocalStorage(localConfig *config.LocalConfig, logger *logrus.Logger) (*LocalStorage, error) {
+func NewLocalStorage(localConfig *config.LocalConfig, logger logrus.FieldLogger) (*LocalStorage, error) {
 	// Ensure the backup directory exists
 	if err := os.MkdirAll(localConfig.Path, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create backup directory %s: %w", localConfig.Path, err)
@@ -31,9 +47,50 @@ func NewLocalStorage(localConfig *config.LocalConfig, logger *logrus.Logger) (*L
 	}, nil
 }
 
-// SaveBackup saves a backup file to local storage
-func (ls *LocalStorage) SaveBackup(localFilePath, backupPrefix, databaseName string) (string, error) {
-	filename := filepath.Base(localFilePath)
+// WithLogger returns a copy of the local storage that logs through logger
+func (ls *LocalStorage) WithLogger(logger logrus.FieldLogger) Backend {
+	return &LocalStorage{
+		config:   ls.config,
+		logger:   logger,
+		auditLog: ls.auditLog,
+
+		modTimeRetention: ls.modTimeRetention,
+		transferJobs:     ls.transferJobs,
+		instance:         ls.instance,
+	}
+}
+
+// SetAuditLog records deletions made by this storage to the audit log
+func (ls *LocalStorage) SetAuditLog(auditLog *audit.Log) {
+	ls.auditLog = auditLog
+}
+
+// SetModTimeRetention ages out files outside the prefix/database/date layout,
+// such as backups saved by older versions or copied in by hand, by their
+// modification time instead of keeping them forever
+func (ls *LocalStorage) SetModTimeRetention(enabled bool) {
+	ls.modTimeRetention = enabled
+}
+
+// SetTransferJobs sets how many files or parts of a backup are saved at once
+func (ls *LocalStorage) SetTransferJobs(jobs int) {
+	ls.transferJobs = jobs
+}
+
+// SetInstance sets the name this deployment is known by in lock files
+func (ls *LocalStorage) SetInstance(name string) {
+	ls.instance = name
+}
+
+// Location returns a human readable description of the storage target
+func (ls *LocalStorage) Location() string {
+	return fmt.Sprintf("local:%s", ls.config.Path)
+}
+
+// SaveBackup saves a backup file to local storage, writing meta to a sidecar
+// file next to it when given
+func (ls *LocalStorage) SaveBackup(localFilePath, backupPrefix, databaseName string, meta *provenance.Metadata) (string, error) {
+	filename := BackupName(filepath.Base(localFilePath), meta)
 
 	// Create database-specific and date-based directory structure
 	dateDir := time.Now().Format("2006-01-02")
@@ -46,35 +103,129 @@ func (ls *LocalStorage) SaveBackup(localFilePath, backupPrefix, databaseName str
 	// Generate final backup path
 	finalBackupPath := filepath.Join(backupDir, filename)
 
-	// Copy the file to the final location
-	if err := ls.copyFile(localFilePath, finalBackupPath); err != nil {
+	unlock, err := ls.lock()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	if err := ls.ensureFreeSpace(backupPrefix, ls.spaceNeeded(localFilePath, backupDir)); err != nil {
+		return "", err
+	}
+
+	// The files of a directory backup or the parts of a split one are saved before their index
+	if IsIndex(localFilePath) {
+		if err := ls.saveDirectory(localFilePath, finalBackupPath); err != nil {
+			return "", err
+		}
+	}
+
+	// Move the file to the final location
+	if err := ls.retryIO("saving "+finalBackupPath, func() error {
+		return ls.transferFile(localFilePath, finalBackupPath)
+	}); err != nil {
 		return "", fmt.Errorf("failed to copy backup file: %w", err)
 	}
+	if err := ls.finishStored(finalBackupPath, meta); err != nil {
+		return "", err
+	}
 
 	ls.logger.Infof("Backup saved to local storage: %s", finalBackupPath)
 	return finalBackupPath, nil
 }
 
-// DeleteOldBackups deletes backup files older than the specified retention period
-func (ls *LocalStorage) DeleteOldBackups(backupPrefix string, retentionDays int) error {
+// saveDirectory saves the files of the directory backup indexed at
+// indexPath next to destIndexPath, transferJobs files at a time
+func (ls *LocalStorage) saveDirectory(indexPath, destIndexPath string) error {
+	index, err := ReadDirectoryIndex(indexPath)
+	if err != nil {
+		return err
+	}
+	srcDir, destDir := DirectoryPath(indexPath), DirectoryPath(destIndexPath)
+	err = EachDirectoryFile(index, ls.transferJobs, func(file DirectoryFile) error {
+		src := filepath.Join(srcDir, filepath.FromSlash(file.Name))
+		dst := filepath.Join(destDir, filepath.FromSlash(file.Name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := ls.retryIO("saving "+dst, func() error { return ls.transferFile(src, dst) }); err != nil {
+			return err
+		}
+		return ls.applyOwnership(dst)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save backup files: %w", err)
+	}
+	ls.logger.Infof("Saved %d backup files to %s", len(index.Files), destDir)
+	return nil
+}
+
+// UpdateLatest points the database's latest symlink at the backup saved to
+// backupPath, replacing the previous link atomically
+func (ls *LocalStorage) UpdateLatest(backupPrefix, databaseName, backupPath string) error {
+	databaseDir := filepath.Join(ls.config.Path, backupPrefix, databaseName)
+	target, err := filepath.Rel(databaseDir, backupPath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve latest backup path: %w", err)
+	}
+
+	unlock, err := ls.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	linkPath := filepath.Join(databaseDir, LatestLink)
+	tmpPath := fmt.Sprintf("%s.tmp-%d", linkPath, os.Getpid())
+	os.Remove(tmpPath)
+	if err := os.Symlink(target, tmpPath); err != nil {
+		return fmt.Errorf("failed to create latest link: %w", err)
+	}
+	if err := os.Rename(tmpPath, linkPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to update latest link: %w", err)
+	}
+	if err := syncDir(databaseDir); err != nil {
+		return err
+	}
+
+	ls.logger.Infof("Updated %s to point at %s", linkPath, target)
+	return nil
+}
+
+// DeleteOldBackups deletes backup files older than the specified retention
+// period and returns the number of date directories and files deleted
+func (ls *LocalStorage) DeleteOldBackups(backupPrefix string, retentionDays int) (int, error) {
 	cutoffDate := time.Now().AddDate(0, 0, -retentionDays)
 	backupBaseDir := filepath.Join(ls.config.Path, backupPrefix)
 
 	ls.logger.Infof("Deleting backups older than %d days (before %s)", retentionDays, cutoffDate.Format("2006-01-02"))
 
+	held, err := HeldKeys(ls, backupPrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	unlock, err := ls.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
 	// Check if backup directory exists
 	if _, err := os.Stat(backupBaseDir); os.IsNotExist(err) {
 		ls.logger.Info("Backup directory does not exist, nothing to clean up")
-		return nil
+		return 0, nil
 	}
 
 	// Read the backup directory to find database directories
 	entries, err := os.ReadDir(backupBaseDir)
 	if err != nil {
-		return fmt.Errorf("failed to read backup directory: %w", err)
+		return 0, fmt.Errorf("failed to read backup directory: %w", err)
 	}
 
 	var totalDeletedCount int
+	var deletedDirs []string
 	for _, entry := range entries {
 		if !entry.IsDir() {
 			continue
@@ -112,15 +263,12 @@ func (ls *LocalStorage) DeleteOldBackups(backupPrefix string, retentionDays int)
 
 			// Check if directory is older than retention period
 			if dirDate.Before(cutoffDate) {
-				dirPath := filepath.Join(databaseDir, dirName)
-				ls.logger.Infof("Deleting old backup directory: %s", dirPath)
-
-				if err := os.RemoveAll(dirPath); err != nil {
-					ls.logger.Errorf("Failed to delete directory %s: %v", dirPath, err)
-					continue
+				deleted, err := ls.deleteExpiredDir(filepath.Join(databaseDir, dirName), held)
+				if err != nil {
+					ls.logger.Errorf("Failed to delete directory %s: %v", filepath.Join(databaseDir, dirName), err)
 				}
-
-				deletedCount++
+				deletedCount += len(deleted)
+				deletedDirs = append(deletedDirs, deleted...)
 			}
 		}
 
@@ -129,9 +277,353 @@ func (ls *LocalStorage) DeleteOldBackups(backupPrefix string, retentionDays int)
 	}
 
 	ls.logger.Infof("Total deleted %d old backup directories across all databases", totalDeletedCount)
+
+	if ls.modTimeRetention {
+		deletedFiles, err := ls.deleteUndatedBackups(backupBaseDir, cutoffDate, held)
+		if err != nil {
+			ls.logger.Warnf("Failed to clean up backups outside the date layout: %v", err)
+		}
+		deletedDirs = append(deletedDirs, deletedFiles...)
+	}
+
+	if len(deletedDirs) > 0 {
+		if err := ls.auditLog.Record(audit.Event{
+			Action:  audit.ActionRetentionDelete,
+			Storage: ls.Location(),
+			Targets: deletedDirs,
+			Details: map[string]string{"retention_days": strconv.Itoa(retentionDays)},
+		}); err != nil {
+			ls.logger.Errorf("Failed to record retention deletions in audit log: %v", err)
+		}
+	}
+	return len(deletedDirs), nil
+}
+
+// deleteExpiredDir deletes a date directory past the retention period and
+// returns the deleted paths. When it holds backups under a retention hold,
+// only the other files are deleted.
+func (ls *LocalStorage) deleteExpiredDir(dirPath string, held map[string]bool) ([]string, error) {
+	relDir, err := filepath.Rel(ls.config.Path, dirPath)
+	if err != nil {
+		return nil, err
+	}
+	holdsBackups := false
+	for key := range held {
+		if strings.HasPrefix(key, filepath.ToSlash(relDir)+"/") {
+			holdsBackups = true
+			break
+		}
+	}
+	if !holdsBackups {
+		ls.logger.Infof("Deleting old backup directory: %s", dirPath)
+		if err := os.RemoveAll(dirPath); err != nil {
+			return nil, err
+		}
+		return []string{dirPath}, nil
+	}
+
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	var deleted []string
+	for _, entry := range entries {
+		filePath := filepath.Join(dirPath, entry.Name())
+		if IsHeld(held, path.Join(filepath.ToSlash(relDir), entry.Name())) {
+			ls.logger.Infof("Keeping held backup: %s", filePath)
+			continue
+		}
+		ls.logger.Infof("Deleting old backup file: %s", filePath)
+		if err := os.RemoveAll(filePath); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, filePath)
+	}
+	return deleted, nil
+}
+
+// deleteUndatedBackups deletes the files under baseDir that are not in a
+// database/date directory and were last modified before cutoff. The
+// service's own directories starting with an underscore and held backups
+// are left alone.
+func (ls *LocalStorage) deleteUndatedBackups(baseDir string, cutoff time.Time, held map[string]bool) ([]string, error) {
+	var deleted []string
+	err := filepath.WalkDir(baseDir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(baseDir, p)
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if d.IsDir() {
+			if rel != "." && strings.HasPrefix(parts[0], "_") {
+				return filepath.SkipDir
+			}
+			// Dated directories are aged out by their date above
+			if len(parts) == 2 {
+				if _, err := time.Parse("2006-01-02", parts[1]); err == nil {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if provenance.IsSidecar(d.Name()) || d.Type()&os.ModeSymlink != 0 {
+			return nil
+		}
+		if key, err := filepath.Rel(ls.config.Path, p); err == nil && held[filepath.ToSlash(key)] {
+			ls.logger.Infof("Keeping held backup: %s", p)
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.ModTime().Before(cutoff) {
+			return nil
+		}
+		ls.logger.Infof("Deleting old backup file: %s (last modified: %s)", p, info.ModTime().Format("2006-01-02"))
+		if err := os.Remove(p); err != nil {
+			ls.logger.Errorf("Failed to delete file %s: %v", p, err)
+			return nil
+		}
+		os.Remove(p + provenance.SidecarSuffix)
+		deleted = append(deleted, p)
+		return nil
+	})
+	for _, p := range deleted {
+		ls.removeEmptyParents(filepath.Dir(p))
+	}
+
+	ls.logger.Infof("Deleted %d old backup files outside the date layout", len(deleted))
+	return deleted, err
+}
+
+// ListKeys returns the keys of every backup file under prefix. Keys are
+// slash separated paths relative to the storage root; prefix may also be an
+// absolute path inside the root.
+func (ls *LocalStorage) ListKeys(prefix string) ([]string, error) {
+	relPrefix, err := ls.relativeKey(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	root := filepath.Join(ls.config.Path, filepath.FromSlash(relPrefix))
+	var keys []string
+	err = filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// The files of a directory backup and the parts of a split one belong to their index
+		if d.IsDir() && (strings.HasSuffix(d.Name(), DirectorySuffix) || strings.HasSuffix(d.Name(), PartsSuffix)) {
+			if _, err := os.Stat(p + ".json"); err == nil {
+				return filepath.SkipDir
+			}
+		}
+		// Latest links are pointers, not backups
+		if d.IsDir() || d.Type()&os.ModeSymlink != 0 || provenance.IsSidecar(d.Name()) || isPartial(d.Name()) || d.Name() == LockFileName {
+			return nil
+		}
+		rel, err := filepath.Rel(ls.config.Path, p)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	return keys, nil
+}
+
+// ListBackups returns one page of the backups selected by query, in key order
+func (ls *LocalStorage) ListBackups(query ListQuery) (*ListPage, error) {
+	keys, err := ls.ListKeys(query.KeyPrefix())
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	page := &ListPage{}
+	for _, key := range keys {
+		if query.PageToken != "" && key <= query.PageToken {
+			continue
+		}
+		if query.PastUntil(key) {
+			break
+		}
+		entry, ok := query.Match(key)
+		if !ok {
+			continue
+		}
+		if len(page.Entries) == query.Limit() {
+			page.NextPageToken = page.Entries[len(page.Entries)-1].Key
+			break
+		}
+
+		info, err := os.Stat(filepath.Join(ls.config.Path, filepath.FromSlash(key)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat backup %s: %w", key, err)
+		}
+		entry.Size = info.Size()
+		entry.LastModified = info.ModTime()
+		page.Entries = append(page.Entries, entry)
+	}
+	return page, nil
+}
+
+// DeleteBackups deletes the given backup files and returns the keys that were deleted.
+// Directories left empty by the deletion are removed as well.
+func (ls *LocalStorage) DeleteBackups(keys []string) ([]string, error) {
+	unlock, err := ls.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return ls.deleteBackups(keys)
+}
+
+// deleteBackups deletes the given backup files with the lock already held
+func (ls *LocalStorage) deleteBackups(keys []string) ([]string, error) {
+	var deleted []string
+	for _, key := range keys {
+		relKey, err := ls.relativeKey(key)
+		if err != nil {
+			return deleted, err
+		}
+
+		filePath := filepath.Join(ls.config.Path, filepath.FromSlash(relKey))
+		if err := ls.retryIO("deleting "+filePath, func() error { return os.Remove(filePath) }); err != nil {
+			return deleted, fmt.Errorf("failed to delete %s: %w", filePath, err)
+		}
+		if IsIndex(filePath) {
+			if err := os.RemoveAll(DirectoryPath(filePath)); err != nil {
+				ls.logger.Warnf("Failed to delete files of %s: %v", filePath, err)
+			}
+		}
+		if err := os.Remove(filePath + provenance.SidecarSuffix); err != nil && !os.IsNotExist(err) {
+			ls.logger.Warnf("Failed to delete metadata of %s: %v", filePath, err)
+		}
+		deleted = append(deleted, relKey)
+		ls.removeEmptyParents(filepath.Dir(filePath))
+	}
+
+	ls.logger.Infof("Deleted %d backup files", len(deleted))
+	return deleted, nil
+}
+
+// Download copies the backup stored under key to destPath
+func (ls *LocalStorage) Download(key, destPath string) error {
+	relKey, err := ls.relativeKey(key)
+	if err != nil {
+		return err
+	}
+
+	srcPath := filepath.Join(ls.config.Path, filepath.FromSlash(relKey))
+	if err := ls.retryIO("reading "+srcPath, func() error { return ls.copyFile(srcPath, destPath) }); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", srcPath, err)
+	}
+
+	ls.logger.Infof("Copied %s to %s", srcPath, destPath)
+	return nil
+}
+
+// Metadata returns the provenance recorded in the sidecar of the backup
+// stored under key, or nil when it has none
+func (ls *LocalStorage) Metadata(key string) (*provenance.Metadata, error) {
+	relKey, err := ls.relativeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return provenance.ReadSidecar(filepath.Join(ls.config.Path, filepath.FromSlash(relKey)))
+}
+
+// UploadFile copies the local file at localPath into storage under key,
+// writing meta to a sidecar file next to it when given
+func (ls *LocalStorage) UploadFile(localPath, key string, meta *provenance.Metadata) error {
+	relKey, err := ls.relativeKey(key)
+	if err != nil {
+		return err
+	}
+
+	destPath := filepath.Join(ls.config.Path, filepath.FromSlash(relKey))
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory %s: %w", filepath.Dir(destPath), err)
+	}
+
+	unlock, err := ls.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := ls.retryIO("saving "+destPath, func() error { return ls.copyFile(localPath, destPath) }); err != nil {
+		return fmt.Errorf("failed to copy backup file: %w", err)
+	}
+	if err := ls.finishStored(destPath, meta); err != nil {
+		return err
+	}
+
+	ls.logger.Infof("Backup saved to local storage: %s", destPath)
 	return nil
 }
 
+// finishStored writes meta to a sidecar next to the file stored at path when
+// given, and applies the configured permissions and owner to both
+func (ls *LocalStorage) finishStored(path string, meta *provenance.Metadata) error {
+	if err := ls.applyOwnership(path); err != nil {
+		return err
+	}
+	if meta == nil {
+		return nil
+	}
+	if err := ls.retryIO("saving "+path+provenance.SidecarSuffix, func() error { return meta.WriteSidecar(path) }); err != nil {
+		return err
+	}
+	return ls.applyOwnership(path + provenance.SidecarSuffix)
+}
+
+// relativeKey converts a key or an absolute path inside the storage root to a root-relative key
+func (ls *LocalStorage) relativeKey(key string) (string, error) {
+	if !filepath.IsAbs(key) {
+		key = filepath.Join(ls.config.Path, filepath.FromSlash(key))
+	}
+
+	rel, err := filepath.Rel(ls.config.Path, key)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %s is outside the backup directory %s", key, ls.config.Path)
+	}
+	if rel == "." {
+		return "", nil
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// removeEmptyParents removes empty directories from dir up to the storage
+// root. Paths are compared with filepath.Rel, which ignores case on Windows.
+func (ls *LocalStorage) removeEmptyParents(dir string) {
+	root := filepath.Clean(ls.config.Path)
+	for dir = filepath.Clean(dir); ; dir = filepath.Dir(dir) {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil || len(entries) > 0 {
+			return
+		}
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
+
 // TestConnection tests the local storage connection
 func (ls *LocalStorage) TestConnection() error {
 	// Test if we can write to the backup directory
@@ -152,7 +644,42 @@ func (ls *LocalStorage) TestConnection() error {
 	return nil
 }
 
-// copyFile copies a file from src to dst
+// transferFile moves a finished dump from src to dst using the configured
+// transfer mode. Renaming and hard linking avoid writing the dump a second
+// time; when src and dst are on different filesystems it falls back to a copy.
+func (ls *LocalStorage) transferFile(src, dst string) error {
+	switch ls.config.Transfer {
+	case config.LocalTransferRename:
+		// The dump is flushed before it is renamed so that dst is never a
+		// name for unwritten data
+		if err := syncFile(src); err != nil {
+			return err
+		}
+		err := os.Rename(src, dst)
+		if err == nil {
+			return syncDir(filepath.Dir(dst))
+		}
+		if !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+		ls.logger.Debugf("Cannot rename %s across filesystems, copying instead", src)
+	case config.LocalTransferLink:
+		if err := syncFile(src); err != nil {
+			return err
+		}
+		os.Remove(dst)
+		err := os.Link(src, dst)
+		if err == nil {
+			return syncDir(filepath.Dir(dst))
+		}
+		ls.logger.Debugf("Cannot hard link %s (%v), copying instead", src, err)
+	}
+	return ls.copyFile(src, dst)
+}
+
+// copyFile copies a file from src to dst. The copy is written under a
+// temporary name, flushed to disk and renamed into place, so a crash or power
+// loss never leaves a truncated file at dst that looks like a valid backup.
 func (ls *LocalStorage) copyFile(src, dst string) error {
 	sourceFile, err := os.Open(src)
 	if err != nil {
@@ -160,13 +687,54 @@ func (ls *LocalStorage) copyFile(src, dst string) error {
 	}
 	defer sourceFile.Close()
 
-	destFile, err := os.Create(dst)
+	tmpPath := partialPath(dst)
+	destFile, err := os.Create(tmpPath)
 	if err != nil {
 		return err
 	}
-	defer destFile.Close()
 
 	// Copy file contents
-	_, err = destFile.ReadFrom(sourceFile)
-	return err
+	if _, err := destFile.ReadFrom(sourceFile); err != nil {
+		destFile.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := destFile.Sync(); err != nil {
+		destFile.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to flush %s: %w", dst, err)
+	}
+	if err := destFile.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, dst); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return syncDir(filepath.Dir(dst))
+}
+
+// partialPath returns the temporary name a file is written under before it is
+// renamed to path
+func partialPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+PartialSuffix)
+}
+
+// isPartial reports whether name is a file still being written
+func isPartial(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, PartialSuffix)
+}
+
+// syncFile flushes the contents of the file at path to disk
+func syncFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to flush %s: %w", path, err)
+	}
+	return nil
 }
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// LockFileName is the lock file created in the backup directory of a network
+// mount while a host writes to or deletes from it
+const LockFileName = ".db-backuper.lock"
+
+const (
+	// lockRefreshInterval is how often a held lock file is touched
+	lockRefreshInterval = 30 * time.Second
+	// lockStaleAfter is how long a lock file may go untouched before it is
+	// considered left behind by a crashed host and broken
+	lockStaleAfter = 5 * time.Minute
+	// lockWaitTimeout is how long to wait for another host's lock
+	lockWaitTimeout  = 30 * time.Minute
+	lockPollInterval = 2 * time.Second
+
+	ioRetryDelay = time.Second
+)
+
+// lock takes the backup directory's lock file when it is a network mount and
+// returns the function releasing it. Lock files are used rather than flock,
+// which NFS and SMB do not reliably honour between hosts. The lock is
+// touched while held, so a lock that stops being touched is known to be stale.
+func (ls *LocalStorage) lock() (func(), error) {
+	if !ls.config.NetworkMount {
+		return func() {}, nil
+	}
+
+	lockPath := filepath.Join(ls.config.Path, LockFileName)
+	deadline := time.Now().Add(lockWaitTimeout)
+	for {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			// The holder is named by its instance, so a lock held by another
+			// deployment on the same host can be told apart
+			hostname, _ := os.Hostname()
+			fmt.Fprintf(file, "%s %s %d %s\n", cmp.Or(ls.instance, hostname), hostname, os.Getpid(), time.Now().UTC().Format(time.RFC3339))
+			file.Close()
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create lock file %s: %w", lockPath, err)
+		}
+
+		holder, _ := os.ReadFile(lockPath)
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > lockStaleAfter {
+			ls.logger.Warnf("Breaking stale lock %s held by %s", lockPath, strings.TrimSpace(string(holder)))
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for lock %s held by %s", lockPath, strings.TrimSpace(string(holder)))
+		}
+		ls.logger.Debugf("Waiting for lock %s held by %s", lockPath, strings.TrimSpace(string(holder)))
+		time.Sleep(lockPollInterval)
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(lockRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				if err := os.Chtimes(lockPath, now, now); err != nil {
+					ls.logger.Warnf("Failed to refresh lock %s: %v", lockPath, err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		if err := os.Remove(lockPath); err != nil && !os.IsNotExist(err) {
+			ls.logger.Warnf("Failed to release lock %s: %v", lockPath, err)
+		}
+	}, nil
+}
+
+// retryIO runs fn, retrying it with a growing delay while it fails with a
+// transient error such as the EIO network mounts report during a failover
+func (ls *LocalStorage) retryIO(what string, fn func() error) error {
+	retries := ls.config.Retries()
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt > retries || !isTransientIOError(err) {
+			return err
+		}
+		delay := time.Duration(attempt) * ioRetryDelay
+		ls.logger.Warnf("Transient I/O error %s, retrying in %v (attempt %d/%d): %v", what, delay, attempt, retries, err)
+		time.Sleep(delay)
+	}
+}
+
+// isTransientIOError reports whether err is an I/O error a network mount may
+// recover from
+func isTransientIOError(err error) bool {
+	return errors.Is(err, syscall.EIO) || errors.Is(err, syscall.ESTALE)
+}
+
+// applyOwnership sets the configured permissions and owner on a stored file
+func (ls *LocalStorage) applyOwnership(path string) error {
+	mode, ok, err := ls.config.Permissions()
+	if err != nil {
+		return err
+	}
+	if ok {
+		if err := os.Chmod(path, mode); err != nil {
+			return fmt.Errorf("failed to set permissions of %s: %w", path, err)
+		}
+	}
+
+	uid, gid, err := ls.config.Owner()
+	if err != nil {
+		return err
+	}
+	if uid != -1 || gid != -1 {
+		if err := os.Chown(path, uid, gid); err != nil {
+			return fmt.Errorf("failed to set owner of %s: %w", path, err)
+		}
+	}
+	return nil
+}
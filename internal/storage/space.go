@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"db-backuper/internal/audit"
+	"db-backuper/internal/config"
+	"db-backuper/internal/progress"
+)
+
+// CheckFreeSpace makes sure the backup disk is above its free space watermark
+// before a backup starts, pruning the oldest backups under backupPrefix when
+// configured to
+func (ls *LocalStorage) CheckFreeSpace(backupPrefix string) error {
+	if ls.config.MinFreeMB <= 0 {
+		return nil
+	}
+	unlock, err := ls.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return ls.ensureFreeSpace(backupPrefix, 0)
+}
+
+// ensureFreeSpace makes sure that writing needed more bytes leaves at least
+// the configured free space on the backup disk. Depending on the low space
+// action it refuses, or deletes the oldest backups until there is room.
+func (ls *LocalStorage) ensureFreeSpace(backupPrefix string, needed int64) error {
+	if ls.config.MinFreeMB <= 0 {
+		return nil
+	}
+	watermark := uint64(ls.config.MinFreeMB) << 20
+
+	free, err := freeBytes(ls.config.Path)
+	if err != nil {
+		ls.logger.Warnf("Skipping free space check of %s: %v", ls.config.Path, err)
+		return nil
+	}
+	if free >= watermark+uint64(needed) {
+		return nil
+	}
+
+	if ls.config.LowSpace == config.LowSpacePrune {
+		free, err = ls.pruneForSpace(backupPrefix, watermark+uint64(needed))
+		if err != nil {
+			return err
+		}
+		if free >= watermark+uint64(needed) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("refusing to back up: %s has %s free, below the %s watermark after a %s backup",
+		ls.config.Path, progress.FormatBytes(int64(free)), progress.FormatBytes(int64(watermark)), progress.FormatBytes(needed))
+}
+
+// pruneForSpace deletes backups under backupPrefix, oldest first, until at
+// least want bytes are free. The newest backup of each database and held
+// backups are always kept. It returns the free space left.
+func (ls *LocalStorage) pruneForSpace(backupPrefix string, want uint64) (uint64, error) {
+	entries, err := AllBackups(ls, ListQuery{Prefix: backupPrefix})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list backups to prune: %w", err)
+	}
+	held, err := HeldKeys(ls, backupPrefix)
+	if err != nil {
+		return 0, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].Date.Equal(entries[j].Date) {
+			return entries[i].Date.Before(entries[j].Date)
+		}
+		return entries[i].Key < entries[j].Key
+	})
+
+	remaining := make(map[string]int)
+	for _, entry := range entries {
+		remaining[entry.Database]++
+	}
+
+	free, err := freeBytes(ls.config.Path)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted []string
+	for _, entry := range entries {
+		if free >= want {
+			break
+		}
+		if remaining[entry.Database] <= 1 || held[entry.Key] {
+			continue
+		}
+
+		filePath := filepath.Join(ls.config.Path, filepath.FromSlash(entry.Key))
+		ls.logger.Warnf("Low on disk space, deleting oldest backup: %s", filePath)
+		if _, err := ls.deleteBackups([]string{entry.Key}); err != nil {
+			ls.logger.Errorf("Failed to delete %s: %v", filePath, err)
+			continue
+		}
+		remaining[entry.Database]--
+		deleted = append(deleted, filePath)
+
+		if free, err = freeBytes(ls.config.Path); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(deleted) > 0 {
+		if err := ls.auditLog.Record(audit.Event{
+			Action:  audit.ActionLowSpacePrune,
+			Storage: ls.Location(),
+			Targets: deleted,
+			Details: map[string]string{"min_free_mb": strconv.FormatInt(ls.config.MinFreeMB, 10)},
+		}); err != nil {
+			ls.logger.Errorf("Failed to record low space deletions in audit log: %v", err)
+		}
+	}
+	return free, nil
+}
+
+// spaceNeeded returns how many bytes saving the dump at src into dir takes.
+// Linking or renaming within one filesystem takes none.
+func (ls *LocalStorage) spaceNeeded(src, dir string) int64 {
+	if ls.config.Transfer == config.LocalTransferLink || ls.config.Transfer == config.LocalTransferRename {
+		if sameDevice(src, dir) {
+			return 0
+		}
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return 0
+	}
+	size := info.Size()
+	if IsIndex(src) {
+		if index, err := ReadDirectoryIndex(src); err == nil {
+			for _, file := range index.Files {
+				size += file.Size
+			}
+		}
+	}
+	return size
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package storage
+
+// syncDir does nothing on this platform: directories cannot be opened for
+// flushing on Windows, where NTFS journals renames itself
+func syncDir(dir string) error {
+	return nil
+}
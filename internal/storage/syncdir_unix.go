@@ -0,0 +1,22 @@
+//go:build unix
+
+package storage
+
+import (
+	"fmt"
+	"os"
+)
+
+// syncDir flushes dir to disk so that files created or renamed in it survive
+// a power loss
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to flush directory %s: %w", dir, err)
+	}
+	return nil
+}
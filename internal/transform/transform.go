@@ -0,0 +1,149 @@
+// Package transform rewrites SQL scripts line by line as they are restored,
+// for targets the script does not load into as it is
+package transform
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"db-backuper/internal/config"
+)
+
+// Pipeline applies transform rules to the lines of a SQL script in order
+type Pipeline struct {
+	rules []rule
+}
+
+// rule is a compiled transform rule
+type rule struct {
+	name    string
+	match   *regexp.Regexp
+	replace string
+	drop    bool
+	data    bool
+}
+
+// Stats counts the lines each rule changed, by rule name
+type Stats map[string]int
+
+// New compiles transform rules. Rules without a name are named after their expression.
+func New(rules []config.TransformRule) (*Pipeline, error) {
+	p := &Pipeline{}
+	for i, r := range rules {
+		match, err := regexp.Compile(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid match of transform %d: %w", i, err)
+		}
+		name := r.Name
+		if name == "" {
+			name = r.Match
+		}
+		p.rules = append(p.rules, rule{name: name, match: match, replace: r.Replace, drop: r.Drop, data: r.Data})
+	}
+	return p, nil
+}
+
+// Empty reports whether the pipeline has no rules
+func (p *Pipeline) Empty() bool {
+	return len(p.rules) == 0
+}
+
+// Line applies the rules to a line without its line ending and reports
+// whether the line is kept. data tells whether the line is COPY data.
+func (p *Pipeline) Line(line string, data bool, stats Stats) (string, bool) {
+	for _, r := range p.rules {
+		if data && !r.data || !r.match.MatchString(line) {
+			continue
+		}
+		stats[r.name]++
+		if r.drop {
+			return "", false
+		}
+		line = r.match.ReplaceAllString(line, r.replace)
+	}
+	return line, true
+}
+
+// Copy copies a SQL script from src to dst through the rules. Dropping the
+// COPY line of a table drops its data as well.
+func (p *Pipeline) Copy(dst io.Writer, src io.Reader) (Stats, error) {
+	stats := make(Stats)
+	reader := bufio.NewReader(src)
+	writer := bufio.NewWriter(dst)
+	inCopy, skipCopy := false, false
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			text := strings.TrimRight(line, "\r\n")
+			ending := line[len(text):]
+			switch {
+			case inCopy:
+				inCopy = text != `\.`
+				if skipCopy {
+					text, ending = "", ""
+				} else if inCopy {
+					var kept bool
+					if text, kept = p.Line(text, true, stats); !kept {
+						ending = ""
+					}
+				}
+			default:
+				isCopy := strings.HasPrefix(text, "COPY ") && strings.HasSuffix(text, "FROM stdin;")
+				var kept bool
+				if text, kept = p.Line(text, false, stats); !kept {
+					ending = ""
+				}
+				inCopy, skipCopy = isCopy, isCopy && !kept
+			}
+			if _, err := writer.WriteString(text + ending); err != nil {
+				return stats, err
+			}
+		}
+		if err == io.EOF {
+			return stats, writer.Flush()
+		}
+		if err != nil {
+			return stats, err
+		}
+	}
+}
+
+// CopyFile writes the SQL script at src to dst through the rules
+func (p *Pipeline) CopyFile(src, dst string) (Stats, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open script: %w", err)
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transformed script: %w", err)
+	}
+	stats, err := p.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return stats, fmt.Errorf("failed to transform script: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return stats, fmt.Errorf("failed to write transformed script: %w", err)
+	}
+	return stats, nil
+}
+
+// String summarizes the lines changed by each rule
+func (s Stats) String() string {
+	if len(s) == 0 {
+		return "no lines changed"
+	}
+	var parts []string
+	for name, lines := range s {
+		parts = append(parts, fmt.Sprintf("%s: %d lines", name, lines))
+	}
+	slices.Sort(parts)
+	return strings.Join(parts, ", ")
+}
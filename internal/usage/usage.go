@@ -0,0 +1,258 @@
+// Package usage summarizes the space taken by stored backups, estimates
+// what S3 charges for it and suggests cheaper retention and storage classes
+package usage
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"db-backuper/internal/retention"
+	"db-backuper/internal/storage"
+)
+
+// S3 storage classes
+const (
+	ClassStandard           = "STANDARD"
+	ClassStandardIA         = "STANDARD_IA"
+	ClassOneZoneIA          = "ONEZONE_IA"
+	ClassIntelligentTiering = "INTELLIGENT_TIERING"
+	ClassGlacierIR          = "GLACIER_IR"
+	ClassGlacier            = "GLACIER"
+	ClassDeepArchive        = "DEEP_ARCHIVE"
+)
+
+// Kinds of suggestions
+const (
+	SuggestionStorageClass = "storage_class"
+	SuggestionRetention    = "retention"
+)
+
+// DefaultPrices are the S3 storage prices in USD per GB-month in us-east-1
+var DefaultPrices = map[string]float64{
+	ClassStandard:           0.023,
+	ClassStandardIA:         0.0125,
+	ClassOneZoneIA:          0.01,
+	ClassIntelligentTiering: 0.023,
+	ClassGlacierIR:          0.004,
+	ClassGlacier:            0.0036,
+	ClassDeepArchive:        0.00099,
+}
+
+// transitions are the classes suggested for older backups with the minimum
+// storage duration S3 charges for. Only classes whose objects can be
+// downloaded without a restore request are suggested, so downloads and
+// restores keep working.
+var transitions = []struct {
+	class       string
+	minimumDays int
+}{
+	{ClassStandardIA, 30},
+	{ClassGlacierIR, 90},
+}
+
+// transitionAges are the backup ages, in days, considered for a transition
+var transitionAges = []int{30, 60, 90, 180, 365}
+
+// retentionPeriods are the retention periods, in days, considered
+// instead of a longer one
+var retentionPeriods = []int{7, 14, 30, 90, 180, 365}
+
+// gfsPolicy is the rotation suggested instead of long retention periods
+var gfsPolicy = retention.Policy{Days: 7, KeepWeekly: 4, KeepMonthly: 12}
+
+// bytesPerGB is the gigabyte S3 bills by
+const bytesPerGB = 1 << 30
+
+// Report is the storage usage of the backups of every database with its
+// estimated monthly cost and suggestions to lower it
+type Report struct {
+	GeneratedAt   time.Time       `json:"generated_at"`
+	RetentionDays int             `json:"retention_days"`
+	Backups       int             `json:"backups"`
+	Bytes         int64           `json:"bytes"`
+	MonthlyCost   float64         `json:"monthly_cost_usd"`
+	Databases     []DatabaseUsage `json:"databases"`
+	Suggestions   []Suggestion    `json:"suggestions"`
+}
+
+// DatabaseUsage is the storage usage of the backups of one database in one storage
+type DatabaseUsage struct {
+	Storage  string `json:"storage"`
+	Database string `json:"database"`
+	Backups  int    `json:"backups"`
+	Bytes    int64  `json:"bytes"`
+	Oldest   string `json:"oldest"`
+	Newest   string `json:"newest"`
+	// BytesByClass splits the bytes stored in S3 by storage class
+	BytesByClass map[string]int64 `json:"bytes_by_class,omitempty"`
+	// MonthlyCost is the estimated S3 storage charge, zero for other storage
+	MonthlyCost float64 `json:"monthly_cost_usd"`
+}
+
+// Suggestion is a change that lowers the monthly storage cost
+type Suggestion struct {
+	Kind           string  `json:"kind"`
+	Description    string  `json:"description"`
+	MonthlySavings float64 `json:"monthly_savings_usd"`
+	// Class and AfterDays describe a storage class transition
+	Class     string `json:"class,omitempty"`
+	AfterDays int    `json:"after_days,omitempty"`
+	// Policy is a suggested retention policy
+	Policy *retention.Policy `json:"policy,omitempty"`
+}
+
+// Analyze summarizes backups, judging retention by retentionDays as of now.
+// prices override DefaultPrices by storage class. Only backups listed with an
+// S3 storage class are priced; suggestions assume the backups stored today
+// are typical of the steady state.
+func Analyze(backups []retention.Backup, retentionDays int, prices map[string]float64, now time.Time) *Report {
+	prices = mergePrices(prices)
+	report := &Report{
+		GeneratedAt:   now.UTC(),
+		RetentionDays: retentionDays,
+		Databases:     []DatabaseUsage{},
+		Suggestions:   []Suggestion{},
+	}
+
+	byDatabase := make(map[[2]string]*DatabaseUsage)
+	for _, backup := range backups {
+		id := [2]string{backup.Storage, backup.Database}
+		db := byDatabase[id]
+		if db == nil {
+			db = &DatabaseUsage{Storage: backup.Storage, Database: backup.Database}
+			byDatabase[id] = db
+		}
+		date := backup.Date.Format(storage.DateLayout)
+		if db.Oldest == "" || date < db.Oldest {
+			db.Oldest = date
+		}
+		if date > db.Newest {
+			db.Newest = date
+		}
+		db.Backups++
+		db.Bytes += backup.Size
+		if backup.StorageClass != "" {
+			if db.BytesByClass == nil {
+				db.BytesByClass = make(map[string]int64)
+			}
+			db.BytesByClass[backup.StorageClass] += backup.Size
+			db.MonthlyCost += cost(backup, prices)
+		}
+	}
+	for _, id := range slices.SortedFunc(maps.Keys(byDatabase), func(a, b [2]string) int {
+		return cmp.Or(cmp.Compare(a[0], b[0]), cmp.Compare(a[1], b[1]))
+	}) {
+		db := byDatabase[id]
+		db.MonthlyCost = roundCents(db.MonthlyCost)
+		report.Databases = append(report.Databases, *db)
+		report.Backups += db.Backups
+		report.Bytes += db.Bytes
+		report.MonthlyCost += db.MonthlyCost
+	}
+	report.MonthlyCost = roundCents(report.MonthlyCost)
+
+	report.Suggestions = append(report.Suggestions, classSuggestions(backups, retentionDays, prices, now)...)
+	report.Suggestions = append(report.Suggestions, retentionSuggestions(backups, retentionDays, prices, now)...)
+	slices.SortStableFunc(report.Suggestions, func(a, b Suggestion) int { return cmp.Compare(b.MonthlySavings, a.MonthlySavings) })
+	return report
+}
+
+// classSuggestions suggests moving backups to a cheaper storage class once
+// they reach an age, choosing for each class the youngest age that saves the
+// most while leaving the backups in the class for its minimum duration
+func classSuggestions(backups []retention.Backup, retentionDays int, prices map[string]float64, now time.Time) []Suggestion {
+	var suggestions []Suggestion
+	for _, transition := range transitions {
+		for _, age := range transitionAges {
+			// Backups deleted before the minimum duration are charged for it anyway
+			if retentionDays > 0 && retentionDays-age < transition.minimumDays {
+				break
+			}
+			cutoff := now.AddDate(0, 0, -age)
+			var savings float64
+			for _, backup := range backups {
+				current, ok := prices[backup.StorageClass]
+				if !ok || !backup.Date.Before(cutoff) || current <= prices[transition.class] {
+					continue
+				}
+				savings += float64(backup.Size) / bytesPerGB * (current - prices[transition.class])
+			}
+			if savings = roundCents(savings); savings > 0 {
+				suggestions = append(suggestions, Suggestion{
+					Kind:           SuggestionStorageClass,
+					Description:    fmt.Sprintf("Moving backups older than %d days to %s would save ~$%.2f/mo", age, transition.class, savings),
+					MonthlySavings: savings,
+					Class:          transition.class,
+					AfterDays:      age,
+				})
+				break
+			}
+		}
+	}
+	return suggestions
+}
+
+// retentionSuggestions suggests the next shorter retention period, and a
+// GFS rotation instead of a long one, with the storage their deletions free
+func retentionSuggestions(backups []retention.Backup, retentionDays int, prices map[string]float64, now time.Time) []Suggestion {
+	var policies []retention.Policy
+	for _, days := range slices.Backward(retentionPeriods) {
+		if days < retentionDays {
+			policies = append(policies, retention.Policy{Days: days})
+			break
+		}
+	}
+	if retentionDays > 30 {
+		policies = append(policies, gfsPolicy)
+	}
+
+	var suggestions []Suggestion
+	for _, policy := range policies {
+		var savings float64
+		deleted := 0
+		for _, decision := range retention.Simulate(policy, backups, now) {
+			if !decision.Delete {
+				continue
+			}
+			deleted++
+			if price, ok := prices[decision.StorageClass]; ok {
+				savings += float64(decision.Size) / bytesPerGB * price
+			}
+		}
+		if savings = roundCents(savings); savings <= 0 {
+			continue
+		}
+		description := fmt.Sprintf("Keeping backups for %d instead of %d days would delete %d backups and save ~$%.2f/mo", policy.Days, retentionDays, deleted, savings)
+		if policy == gfsPolicy {
+			description = fmt.Sprintf("Keeping %d days of backups plus %d weekly and %d monthly ones instead of %d days would delete %d backups and save ~$%.2f/mo",
+				policy.Days, policy.KeepWeekly, policy.KeepMonthly, retentionDays, deleted, savings)
+		}
+		suggestions = append(suggestions, Suggestion{
+			Kind:           SuggestionRetention,
+			Description:    description,
+			MonthlySavings: savings,
+			Policy:         &policy,
+		})
+	}
+	return suggestions
+}
+
+// cost returns the monthly storage charge of a backup stored in S3
+func cost(backup retention.Backup, prices map[string]float64) float64 {
+	return float64(backup.Size) / bytesPerGB * prices[backup.StorageClass]
+}
+
+// mergePrices returns DefaultPrices with overrides applied
+func mergePrices(overrides map[string]float64) map[string]float64 {
+	prices := maps.Clone(DefaultPrices)
+	maps.Copy(prices, overrides)
+	return prices
+}
+
+// roundCents rounds an amount in USD to whole cents
+func roundCents(amount float64) float64 {
+	return float64(int64(amount*100+0.5)) / 100
+}
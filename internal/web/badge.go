@@ -0,0 +1,84 @@
+package web
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"db-backuper/internal/status"
+)
+
+// Badge colors, matching the shields.io palette
+const (
+	badgeGreen  = "#4c1"
+	badgeRed    = "#e05d44"
+	badgeOrange = "#fe7d37"
+	badgeGrey   = "#9f9f9f"
+)
+
+// handleBadge serves /badge/{database}.svg: green with the age of the last
+// backup, red when the last backup failed, orange when it was skipped and
+// grey for disabled and unknown databases
+func (s *Server) handleBadge(w http.ResponseWriter, r *http.Request) {
+	database, ok := strings.CutSuffix(r.PathValue("database"), ".svg")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	code, message, color := http.StatusOK, "", badgeGreen
+	result, found := s.result(database)
+	switch {
+	case !found || result.Status == "":
+		// Databases only known from a measured restore have no backup yet
+		code, message, color = http.StatusNotFound, "unknown", badgeGrey
+	case result.Disabled:
+		message, color = "disabled", badgeGrey
+	case result.Status == status.ResultSkipped:
+		message, color = "skipped", badgeOrange
+	case result.Status != status.ResultSuccess:
+		message, color = "failed", badgeRed
+	default:
+		message = formatAge(time.Since(result.FinishedAt))
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-cache, max-age=0")
+	w.WriteHeader(code)
+	fmt.Fprint(w, renderBadge("backup", message, color))
+}
+
+// formatAge describes how long ago something happened in its largest unit
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	}
+}
+
+// renderBadge renders a flat shields-style badge. Text widths are estimated
+// from the character count, which is close enough for short labels.
+func renderBadge(label, message, color string) string {
+	labelWidth := 10 + 7*len(label)
+	messageWidth := 10 + 7*len(message)
+	width := labelWidth + messageWidth
+	title := html.EscapeString(label + ": " + message)
+
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[2]s">`+
+		`<title>%[2]s</title>`+
+		`<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`+
+		`<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>`+
+		`<g clip-path="url(#r)"><rect width="%[3]d" height="20" fill="#555"/><rect x="%[3]d" width="%[4]d" height="20" fill="%[5]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>`+
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`+
+		`<text x="%[6]d" y="14">%[7]s</text><text x="%[8]d" y="14">%[9]s</text></g></svg>`,
+		width, title, labelWidth, messageWidth, color,
+		labelWidth/2, html.EscapeString(label), labelWidth+messageWidth/2, html.EscapeString(message))
+}
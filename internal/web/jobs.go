@@ -0,0 +1,81 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"db-backuper/internal/joblog"
+)
+
+// keepAliveInterval is how often an idle log stream sends a comment, so
+// proxies do not close it while a long step logs nothing
+const keepAliveInterval = 15 * time.Second
+
+// handleJobs serves /jobs: the jobs whose logs can be streamed, most recent first
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.jobs.Jobs())
+}
+
+// handleJobLogs serves /jobs/{id}/logs as server-sent events: a "log" event
+// with a JSON log entry per line, numbered by the event ID, and an "end"
+// event with the job once it has finished. The kept lines are sent first,
+// or only those after the Last-Event-ID of a reconnecting client. With
+// follow=false the stream ends after the kept lines.
+func (s *Server) handleJobLogs(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	after, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	backlog, lines, stop, ok := s.jobs.Follow(id, after)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown job %s", id), http.StatusNotFound)
+		return
+	}
+	defer stop()
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	for _, line := range backlog {
+		writeLine(w, line)
+	}
+	flusher.Flush()
+
+	if lines != nil && r.URL.Query().Get("follow") != "false" {
+		keepAlive := time.NewTicker(keepAliveInterval)
+		defer keepAlive.Stop()
+	stream:
+		for {
+			select {
+			case line, open := <-lines:
+				if !open {
+					break stream
+				}
+				writeLine(w, line)
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			case <-r.Context().Done():
+				return
+			}
+			flusher.Flush()
+		}
+	}
+
+	if job, found := s.jobs.Job(id); found && job.FinishedAt != nil {
+		data, _ := json.Marshal(job)
+		fmt.Fprintf(w, "event: end\ndata: %s\n\n", data)
+		flusher.Flush()
+	}
+}
+
+// writeLine writes a log line as a server-sent event
+func writeLine(w http.ResponseWriter, line joblog.Line) {
+	fmt.Fprintf(w, "id: %d\nevent: log\ndata: %s\n\n", line.N, line.Data)
+}
@@ -0,0 +1,198 @@
+package web
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"db-backuper/internal/audit"
+	"db-backuper/internal/config"
+	"db-backuper/internal/jobs"
+)
+
+// JobRequest is the body of a request starting a job
+type JobRequest struct {
+	// Kind defaults to a backup
+	Kind string `json:"kind,omitempty"`
+	// Databases restricts a backup to these databases; empty means all
+	Databases []string `json:"databases,omitempty"`
+	// Backup is the storage key of the backup a restore restores
+	Backup string `json:"backup,omitempty"`
+}
+
+// APITrigger is the trigger recorded for jobs started through the HTTP API
+const APITrigger = "api"
+
+// SetJobQueue enables the endpoints listing, starting and cancelling the
+// jobs of queue. With users configured in api, every job endpoint requires
+// one of their tokens.
+func (s *Server) SetJobQueue(queue *jobs.Manager, api config.APIConfig) {
+	s.queue = queue
+	s.api = api
+}
+
+// SetAuditLog records the restores requested, approved and cancelled
+// through the API in log, which may be nil
+func (s *Server) SetAuditLog(log *audit.Log) {
+	s.audit = log
+}
+
+// authenticated wraps a job endpoint so it requires the token of a
+// configured user, when there are any, answering 401 otherwise
+func (s *Server) authenticated(handler func(w http.ResponseWriter, r *http.Request, user *config.APIUser)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.api.Users) == 0 {
+			handler(w, r, nil)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok {
+			digest := sha256.Sum256([]byte(token))
+			for i := range s.api.Users {
+				user := &s.api.Users[i]
+				expected, err := hex.DecodeString(user.TokenSHA256)
+				if err == nil && subtle.ConstantTimeCompare(digest[:], expected) == 1 {
+					handler(w, r, user)
+					return
+				}
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="db-backuper"`)
+		http.Error(w, "a valid API token is required", http.StatusUnauthorized)
+	}
+}
+
+// userName returns the name of an authenticated user, or "" without users
+func userName(user *config.APIUser) string {
+	if user == nil {
+		return ""
+	}
+	return user.Name
+}
+
+// handleQueue serves GET /jobs: every job of the queue, most recently queued first
+func (s *Server) handleQueue(w http.ResponseWriter, r *http.Request, _ *config.APIUser) {
+	writeJSON(w, http.StatusOK, s.queue.Jobs())
+}
+
+// handleQueuedJob serves GET /jobs/{id}
+func (s *Server) handleQueuedJob(w http.ResponseWriter, r *http.Request, _ *config.APIUser) {
+	job, ok := s.queue.Job(r.PathValue("id"))
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown job %s", r.PathValue("id")), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// handleSubmitJob serves POST /jobs, queueing a job described by a
+// JobRequest. It answers 202 with the queued job, or 200 with the job of
+// the same kind and databases already queued or running. Restores need
+// configured users and, with restore approval, wait for a second user.
+func (s *Server) handleSubmitJob(w http.ResponseWriter, r *http.Request, user *config.APIUser) {
+	var request JobRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&request); err != nil {
+			http.Error(w, fmt.Sprintf("invalid job request: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if request.Kind == "" {
+		request.Kind = jobs.KindBackup
+	}
+	restore := request.Kind == jobs.KindRestore
+	if restore && user == nil {
+		http.Error(w, "restores through the API require api users", http.StatusForbidden)
+		return
+	}
+
+	job, queued, err := s.queue.Submit(jobs.Request{
+		Kind:          request.Kind,
+		Trigger:       APITrigger,
+		Databases:     request.Databases,
+		Backup:        request.Backup,
+		RequestedBy:   userName(user),
+		NeedsApproval: restore && s.api.RestoreApproval,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	code := http.StatusOK
+	if queued {
+		code = http.StatusAccepted
+		s.logger.Infof("Queued %s job %s requested over HTTP", job.Kind, job.ID)
+		if restore {
+			s.recordRestore(audit.ActionRestoreRequest, job, user)
+		}
+	}
+	writeJSON(w, code, job)
+}
+
+// handleApproveJob serves POST /jobs/{id}/approve, queueing a job pending
+// approval on behalf of an approver other than the user who requested it
+func (s *Server) handleApproveJob(w http.ResponseWriter, r *http.Request, user *config.APIUser) {
+	id := r.PathValue("id")
+	if user == nil || !user.Approver {
+		http.Error(w, "approving jobs requires an approver", http.StatusForbidden)
+		return
+	}
+	job, err := s.queue.Approve(id, user.Name)
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		http.Error(w, fmt.Sprintf("unknown job %s", id), http.StatusNotFound)
+	case errors.Is(err, jobs.ErrSelfApproval):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		s.logger.Infof("Job %s approved by %s", id, user.Name)
+		s.recordRestore(audit.ActionRestoreApprove, job, user)
+		writeJSON(w, http.StatusAccepted, job)
+	}
+}
+
+// handleCancelJob serves POST /jobs/{id}/cancel, answering with the job
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request, user *config.APIUser) {
+	id := r.PathValue("id")
+	job, err := s.queue.Cancel(id)
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		http.Error(w, fmt.Sprintf("unknown job %s", id), http.StatusNotFound)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		s.logger.Infof("Cancelling job %s as requested over HTTP", id)
+		if job.Kind == jobs.KindRestore {
+			s.recordRestore(audit.ActionRestoreCancel, job, user)
+		}
+		writeJSON(w, http.StatusAccepted, job)
+	}
+}
+
+// recordRestore adds a step of a restore job's approval to the audit log
+func (s *Server) recordRestore(action string, job jobs.Job, user *config.APIUser) {
+	details := map[string]string{"job_id": job.ID, "state": job.State}
+	if job.RequestedBy != "" {
+		details["requested_by"] = job.RequestedBy
+	}
+	if job.ApprovedBy != "" {
+		details["approved_by"] = job.ApprovedBy
+	}
+	if err := s.audit.Record(audit.Event{Action: action, Actor: userName(user), Targets: []string{job.Backup}, Details: details}); err != nil {
+		s.logger.Warnf("Failed to record audit event: %v", err)
+	}
+}
+
+// writeJSON writes v as a JSON response that is never cached
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache, max-age=0")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
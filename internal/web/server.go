@@ -0,0 +1,128 @@
+// Package web serves the HTTP endpoints of scheduler mode
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"db-backuper/internal/audit"
+	"db-backuper/internal/config"
+	"db-backuper/internal/joblog"
+	"db-backuper/internal/jobs"
+	"db-backuper/internal/status"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Server serves status badges of the databases backed up by the scheduler
+// and, when enabled, its job queue, the logs of its runs and storage usage
+type Server struct {
+	addr     string
+	logger   *logrus.Logger
+	server   *http.Server
+	listener net.Listener
+	jobs     *joblog.Hub
+	queue    *jobs.Manager
+	api      config.APIConfig
+	audit    *audit.Log
+	usage    *usageCache
+
+	mu     sync.RWMutex
+	report *status.Report
+}
+
+// NewServer creates a new HTTP server instance; report holds the results of
+// previous runs and may be nil
+func NewServer(addr string, report *status.Report, logger *logrus.Logger) *Server {
+	return &Server{
+		addr:   addr,
+		logger: logger,
+		report: report,
+	}
+}
+
+// SetJobLogs enables the endpoints streaming the logs of runs kept by jobs
+func (s *Server) SetJobLogs(jobs *joblog.Hub) {
+	s.jobs = jobs
+}
+
+// Handler returns the HTTP handler serving every endpoint
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /badge/{database}", s.handleBadge)
+	if s.queue != nil {
+		mux.HandleFunc("GET /jobs", s.authenticated(s.handleQueue))
+		mux.HandleFunc("POST /jobs", s.authenticated(s.handleSubmitJob))
+		mux.HandleFunc("GET /jobs/{id}", s.authenticated(s.handleQueuedJob))
+		mux.HandleFunc("POST /jobs/{id}/approve", s.authenticated(s.handleApproveJob))
+		mux.HandleFunc("POST /jobs/{id}/cancel", s.authenticated(s.handleCancelJob))
+	} else if s.jobs != nil {
+		mux.HandleFunc("GET /jobs", s.handleJobs)
+	}
+	if s.jobs != nil {
+		mux.HandleFunc("GET /jobs/{id}/logs", s.authenticated(func(w http.ResponseWriter, r *http.Request, _ *config.APIUser) {
+			s.handleJobLogs(w, r)
+		}))
+	}
+	if s.usage != nil {
+		mux.HandleFunc("GET /usage", s.authenticated(s.handleUsage))
+	}
+	return mux
+}
+
+// Start begins listening on the server address
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.addr, err)
+	}
+	s.listener = listener
+	s.server = &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	s.logger.Infof("HTTP server listening on %s", listener.Addr())
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Errorf("HTTP server stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Stop closes the HTTP server
+func (s *Server) Stop() error {
+	if s.server == nil {
+		return nil
+	}
+	err := s.server.Close()
+	s.server = nil
+	return err
+}
+
+// Update merges the results of a run into the served status
+func (s *Server) Update(summary *status.RunSummary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.report = status.Merge(s.report, summary)
+}
+
+// result returns the latest result of a database
+func (s *Server) result(database string) (status.DatabaseResult, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.report == nil {
+		return status.DatabaseResult{}, false
+	}
+	for _, result := range s.report.Databases {
+		if result.Database == database {
+			return result, true
+		}
+	}
+	return status.DatabaseResult{}, false
+}
@@ -0,0 +1,56 @@
+package web
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/usage"
+)
+
+// usageMaxAge is how long a usage report is served before backups are
+// listed again, since listing a large bucket is slow and billed
+const usageMaxAge = 15 * time.Minute
+
+// usageCache computes usage reports, keeping the latest for usageMaxAge
+type usageCache struct {
+	compute func() (*usage.Report, error)
+
+	mu         sync.Mutex
+	report     *usage.Report
+	computedAt time.Time
+}
+
+// SetUsage enables the endpoint serving the storage usage and cost
+// suggestions computed by compute
+func (s *Server) SetUsage(compute func() (*usage.Report, error)) {
+	s.usage = &usageCache{compute: compute}
+}
+
+// get returns the cached report, computing a new one when it is stale
+func (c *usageCache) get() (*usage.Report, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.report != nil && time.Since(c.computedAt) < usageMaxAge {
+		return c.report, nil
+	}
+	report, err := c.compute()
+	if err != nil {
+		return nil, err
+	}
+	c.report, c.computedAt = report, time.Now()
+	return report, nil
+}
+
+// handleUsage serves GET /usage: the space and estimated monthly cost of
+// the stored backups with suggestions to lower it
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request, _ *config.APIUser) {
+	report, err := s.usage.get()
+	if err != nil {
+		s.logger.Errorf("Failed to compute storage usage: %v", err)
+		http.Error(w, "failed to list backups", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,241 @@
+// Package dbbackup embeds the backup service in other Go programs. A Runner
+// collects databases and a storage backend, then backs them up and restores
+// them the same way the db-backuper binary does, without shelling out to it.
+//
+//	runner := dbbackup.NewRunner(logger)
+//	runner.AddDatabase(dbbackup.DatabaseConfig{Host: "localhost", Username: "backup", Password: pass, Database: "orders"})
+//	runner.SetStorage(dbbackup.Storage{Local: &dbbackup.LocalConfig{Path: "/var/backups"}})
+//	summary, err := runner.Run(ctx)
+package dbbackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"db-backuper/internal/backup"
+	"db-backuper/internal/config"
+	"db-backuper/internal/plugin"
+	"db-backuper/internal/rclone"
+	"db-backuper/internal/restore"
+	"db-backuper/internal/runid"
+	"db-backuper/internal/s3"
+	"db-backuper/internal/status"
+	"db-backuper/internal/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Settings shared with the configuration file of the binary
+type (
+	// DatabaseConfig describes a database to back up
+	DatabaseConfig = config.DatabaseConfig
+	// LocalConfig keeps backups in a local directory
+	LocalConfig = config.LocalConfig
+	// AWSConfig keeps backups in an S3 bucket
+	AWSConfig = config.AWSConfig
+	// RcloneConfig keeps backups on an rclone remote
+	RcloneConfig = config.RcloneConfig
+	// StoragePluginConfig keeps backups through an external storage plugin
+	StoragePluginConfig = config.StoragePluginConfig
+	// BackupConfig holds the key prefix, retention and transfer settings
+	BackupConfig = config.BackupConfig
+	// ImportConfig describes how a backup is restored
+	ImportConfig = config.ImportConfig
+	// ImportDatabaseConfig describes the database a backup is restored into
+	ImportDatabaseConfig = config.ImportDatabaseConfig
+)
+
+// Results of a run, as written to the status file by the binary
+type (
+	// Summary describes a run over every database
+	Summary = status.RunSummary
+	// Result describes the backup of one database
+	Result = status.DatabaseResult
+)
+
+// Storage selects where backups are kept; set exactly one field
+type Storage struct {
+	Local  *LocalConfig
+	S3     *AWSConfig
+	Rclone *RcloneConfig
+	Plugin *StoragePluginConfig
+}
+
+// Runner backs up and restores the databases added to it
+type Runner struct {
+	cfg    config.Config
+	logger *logrus.Logger
+}
+
+// NewRunner creates a runner logging to logger, or to a standard logrus
+// logger when nil. Backups are kept for the retention of the binary's
+// environment-only defaults until SetBackupOptions changes it.
+func NewRunner(logger *logrus.Logger) *Runner {
+	if logger == nil {
+		logger = logrus.StandardLogger()
+	}
+	defaults := config.EnvDefaults()
+	return &Runner{
+		cfg:    config.Config{Backup: defaults.Backup, Logging: defaults.Logging},
+		logger: logger,
+	}
+}
+
+// AddDatabase adds a database to back up. PostgreSQL databases default to
+// port 5432. Storage overrides of single databases are not supported.
+func (r *Runner) AddDatabase(db DatabaseConfig) error {
+	if db.Storage != (config.StorageConfig{}) {
+		return fmt.Errorf("database %s: storage overrides are not supported by the runner", db.Database)
+	}
+	if db.EngineType() == config.EngineTypePostgres && db.Port == 0 {
+		db.Port = 5432
+	}
+	r.cfg.Databases = append(r.cfg.Databases, db)
+	return nil
+}
+
+// SetStorage selects where backups are kept, replacing an earlier choice
+func (r *Runner) SetStorage(s Storage) {
+	r.cfg.Local, r.cfg.AWS, r.cfg.Rclone, r.cfg.StoragePlugin = LocalConfig{}, AWSConfig{}, RcloneConfig{}, StoragePluginConfig{}
+	if s.Local != nil {
+		r.cfg.Local = *s.Local
+	}
+	if s.S3 != nil {
+		r.cfg.AWS = *s.S3
+	}
+	if s.Rclone != nil {
+		r.cfg.Rclone = *s.Rclone
+	}
+	if s.Plugin != nil {
+		r.cfg.StoragePlugin = *s.Plugin
+	}
+}
+
+// SetBackupOptions replaces the key prefix, retention and transfer settings
+func (r *Runner) SetBackupOptions(opts BackupConfig) {
+	r.cfg.Backup = opts
+}
+
+// Run backs up every enabled database, highest priority first, then deletes
+// the backups past the retention period. Databases not started when ctx is
+// done are skipped. The summary is returned along with an error when any
+// database failed or was skipped.
+func (r *Runner) Run(ctx context.Context) (*Summary, error) {
+	if err := r.cfg.ValidateForBackup(); err != nil {
+		return nil, fmt.Errorf("invalid runner configuration: %w", err)
+	}
+	backend, err := r.newBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &Summary{
+		RunID:     runid.New(),
+		StartedAt: time.Now(),
+		Storage:   backend.Location(),
+		Disabled:  r.cfg.DisabledDatabases(),
+	}
+	runLogger := r.logger.WithFields(logrus.Fields{"run_id": summary.RunID, "operation": "backup"})
+	for _, dbConfig := range r.cfg.DatabasesByPriority() {
+		if !dbConfig.IsEnabled() {
+			continue
+		}
+		dbLogger := runLogger.WithField("database", dbConfig.Database)
+		var result Result
+		if err := ctx.Err(); err != nil {
+			result = status.SkippedResult(dbConfig.Database, err.Error())
+		} else if engine, err := backup.NewEngine(&dbConfig, dbLogger); err != nil {
+			now := time.Now()
+			result = Result{Database: dbConfig.Database, Status: status.ResultFailed, StartedAt: now, FinishedAt: now, Error: err.Error()}
+		} else {
+			result = backup.BackupDatabase(engine, &dbConfig, backend, &r.cfg.Backup, summary.RunID, dbLogger)
+		}
+		result.RunID = summary.RunID
+		summary.Add(result)
+	}
+
+	if r.cfg.Backup.RetentionDays > 0 {
+		deleted, err := backend.DeleteOldBackups(r.cfg.Backup.BackupPrefix, r.cfg.Backup.RetentionDays)
+		if err != nil {
+			runLogger.Warnf("Failed to clean up old backups: %v", err)
+		}
+		summary.ObjectsDeleted = deleted
+	}
+	summary.FinishedAt = time.Now()
+
+	switch {
+	case summary.Failed > 0:
+		return summary, fmt.Errorf("%d of %d databases failed to back up", summary.Failed, len(summary.Databases))
+	case summary.Skipped > 0:
+		return summary, fmt.Errorf("%d of %d databases were skipped: %w", summary.Skipped, len(summary.Databases), ctx.Err())
+	}
+	return summary, nil
+}
+
+// Restore imports a PostgreSQL backup into the database of target. key
+// selects a backup in the runner's storage, which is downloaded first; when
+// empty, target.BackupPath names a local backup file instead.
+func (r *Runner) Restore(ctx context.Context, key string, target ImportConfig) error {
+	if key != "" {
+		backend, err := r.newBackend()
+		if err != nil {
+			return err
+		}
+		workDir, err := os.MkdirTemp("", "db-backuper-restore-*")
+		if err != nil {
+			return fmt.Errorf("failed to create download directory: %w", err)
+		}
+		defer os.RemoveAll(workDir)
+
+		destPath := filepath.Join(workDir, path.Base(key))
+		if target.BackupPath, err = storage.Fetch(backend, key, destPath, r.cfg.Backup.Transfers()); err != nil {
+			return fmt.Errorf("failed to download %s: %w", key, err)
+		}
+	}
+	if target.BackupPath == "" {
+		return errors.New("no backup to restore: pass a key or set BackupPath")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return restore.NewPostgresImport(&target, r.logger).ImportBackup()
+}
+
+// newBackend creates the selected storage backend
+func (r *Runner) newBackend() (storage.Backend, error) {
+	switch {
+	case r.cfg.IsLocalStorage():
+		localStorage, err := storage.NewLocalStorage(&r.cfg.Local, r.logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize local storage: %w", err)
+		}
+		localStorage.SetModTimeRetention(r.cfg.Backup.RetentionModTimeFallback)
+		localStorage.SetTransferJobs(r.cfg.Backup.Transfers())
+		localStorage.SetInstance(r.cfg.Backup.InstanceName())
+		return localStorage, nil
+	case r.cfg.IsAWSStorage():
+		s3Manager, err := s3.NewS3Manager(&r.cfg.AWS, r.logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize S3 manager: %w", err)
+		}
+		s3Manager.SetModTimeRetention(r.cfg.Backup.RetentionModTimeFallback)
+		s3Manager.SetTransferJobs(r.cfg.Backup.Transfers())
+		return s3Manager, nil
+	case r.cfg.IsRcloneStorage():
+		remote := rclone.NewRemote(&r.cfg.Rclone, r.logger)
+		remote.SetModTimeRetention(r.cfg.Backup.RetentionModTimeFallback)
+		remote.SetTransferJobs(r.cfg.Backup.Transfers())
+		return remote, nil
+	case r.cfg.IsPluginStorage():
+		pluginStorage := plugin.NewStorage(&r.cfg.StoragePlugin, r.logger)
+		pluginStorage.SetModTimeRetention(r.cfg.Backup.RetentionModTimeFallback)
+		pluginStorage.SetTransferJobs(r.cfg.Backup.Transfers())
+		return pluginStorage, nil
+	}
+	return nil, errors.New("no storage selected: call SetStorage first")
+}
@@ -47,7 +47,7 @@ func TestMain(m *testing.M) {
 
 	// Load test configuration
 	var err error
-	testConfig, err = config.LoadConfig("test/appsettings.test.json")
+	testConfig, err = config.LoadConfig("test/appsettings.test.json", config.LoadOptions{})
 	if err != nil {
 		logrus.Fatalf("Failed to load test configuration: %v", err)
 	}
@@ -65,7 +65,7 @@ func TestMain(m *testing.M) {
 
 	// Setup local storage for testing
 	testLocalStorage, err = storage.NewLocalStorage(&config.LocalConfig{
-		Path: "/tmp/test-backups",
+		Path: testBackupDir,
 	}, logrus.New())
 	if err != nil {
 		logrus.Fatalf("Failed to create local storage: %v", err)
@@ -161,14 +161,14 @@ INSERT INTO test_products VALUES (3, 'Keyboard', 79.99, 'Mechanical keyboard', '
 `
 
 	// Create temporary backup file
-	tempFile := "/tmp/test_backup.sql"
+	tempFile := filepath.Join(os.TempDir(), "test_backup.sql")
 	if err := os.WriteFile(tempFile, []byte(testBackupContent), 0644); err != nil {
 		t.Fatalf("Failed to create test backup file: %v", err)
 	}
 	defer os.Remove(tempFile)
 
 	// Save backup to local storage
-	backupPath, err := testLocalStorage.SaveBackup(tempFile, "test-backup", "testdb1")
+	backupPath, err := testLocalStorage.SaveBackup(tempFile, "test-backup", "testdb1", nil)
 	if err != nil {
 		t.Fatalf("Failed to save backup to local storage: %v", err)
 	}
@@ -254,14 +254,14 @@ INSERT INTO test_products VALUES (3, 'Keyboard', 79.99, 'Mechanical keyboard', '
 `
 
 	// Create temporary backup file
-	tempFile := "/tmp/test_s3_backup.sql"
+	tempFile := filepath.Join(os.TempDir(), "test_s3_backup.sql")
 	if err := os.WriteFile(tempFile, []byte(testBackupContent), 0644); err != nil {
 		t.Fatalf("Failed to create test backup file: %v", err)
 	}
 	defer os.Remove(tempFile)
 
 	// Upload backup to S3
-	s3Key, err := testS3Manager.UploadBackup(tempFile, "test-backup", "testdb1")
+	s3Key, err := testS3Manager.UploadBackup(tempFile, "test-backup", "testdb1", nil)
 	if err != nil {
 		t.Fatalf("Failed to upload backup to S3: %v", err)
 	}
@@ -294,7 +294,7 @@ func testLocalCleanup(t *testing.T) {
 	newDate := time.Now().Format("2006-01-02")
 
 	// Create old backup directory
-	oldBackupDir := filepath.Join("/tmp/test-backups", "test-backup", "testdb1", oldDate)
+	oldBackupDir := filepath.Join(testBackupDir, "test-backup", "testdb1", oldDate)
 	if err := os.MkdirAll(oldBackupDir, 0755); err != nil {
 		t.Fatalf("Failed to create old backup directory: %v", err)
 	}
@@ -306,7 +306,7 @@ func testLocalCleanup(t *testing.T) {
 	}
 
 	// Create new backup directory
-	newBackupDir := filepath.Join("/tmp/test-backups", "test-backup", "testdb1", newDate)
+	newBackupDir := filepath.Join(testBackupDir, "test-backup", "testdb1", newDate)
 	if err := os.MkdirAll(newBackupDir, 0755); err != nil {
 		t.Fatalf("Failed to create new backup directory: %v", err)
 	}
@@ -326,7 +326,7 @@ func testLocalCleanup(t *testing.T) {
 	}
 
 	// Run cleanup with 1 day retention
-	if err := testLocalStorage.DeleteOldBackups("test-backup", 1); err != nil {
+	if _, err := testLocalStorage.DeleteOldBackups("test-backup", 1); err != nil {
 		t.Fatalf("Failed to cleanup old backups: %v", err)
 	}
 
@@ -375,7 +375,7 @@ func testS3Cleanup(t *testing.T) {
 	}
 
 	// Run cleanup with 1 day retention
-	if err := testS3Manager.DeleteOldBackups("test-backup", 1); err != nil {
+	if _, err := testS3Manager.DeleteOldBackups("test-backup", 1); err != nil {
 		t.Fatalf("Failed to cleanup old S3 backups: %v", err)
 	}
 
@@ -412,7 +412,7 @@ func TestIntegration(t *testing.T) {
 // testIntegrationWithStorage tests the full integration with a specific storage type
 func testIntegrationWithStorage(t *testing.T, configPath string) {
 	// Run the backup service once
-	cmd := exec.Command("go", "run", "./cmd/main.go", "-config", configPath, "-once")
+	cmd := exec.Command("go", "run", "./cmd", "-config", configPath, "-once")
 	cmd.Dir = ".."
 
 	var stdout, stderr bytes.Buffer
@@ -493,7 +493,7 @@ func verifyS3Backup(key string) error {
 }
 
 func verifyLocalBackups(t *testing.T) {
-	backupDir := "/tmp/test-backups/test-backup"
+	backupDir := filepath.Join(testBackupDir, "test-backup")
 
 	// Check that backup directories exist for both databases
 	for _, dbName := range []string{"testdb1", "testdb2"} {
@@ -591,7 +591,7 @@ func TestRestoreFunctionality(t *testing.T) {
 func testLocalRestore(t *testing.T) {
 	// Create a test backup file
 	testBackupContent := createTestBackupContent()
-	tempBackupFile := "/tmp/test_restore_backup.sql"
+	tempBackupFile := filepath.Join(os.TempDir(), "test_restore_backup.sql")
 
 	if err := os.WriteFile(tempBackupFile, []byte(testBackupContent), 0644); err != nil {
 		t.Fatalf("Failed to create test backup file: %v", err)
@@ -627,7 +627,7 @@ func testLocalRestore(t *testing.T) {
 func testRestoreWithVerification(t *testing.T) {
 	// Create a test backup file
 	testBackupContent := createTestBackupContent()
-	tempBackupFile := "/tmp/test_restore_verify_backup.sql"
+	tempBackupFile := filepath.Join(os.TempDir(), "test_restore_verify_backup.sql")
 
 	if err := os.WriteFile(tempBackupFile, []byte(testBackupContent), 0644); err != nil {
 		t.Fatalf("Failed to create test backup file: %v", err)
@@ -88,7 +88,7 @@ aws --endpoint-url=http://localhost:4566 s3 mb s3://test-backup-bucket --region
 
 # Build the application
 print_status "Building the application..."
-go build -o db-backuper ./cmd/main.go
+go build -o db-backuper ./cmd
 
 # Run tests
 print_status "Running tests..."
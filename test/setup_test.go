@@ -5,11 +5,15 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"time"
 
 	_ "github.com/lib/pq"
 )
 
+// testBackupDir is the local storage root of the integration tests
+var testBackupDir = filepath.Join(os.TempDir(), "test-backups")
+
 // TestDatabase represents a test database connection
 type TestDatabase struct {
 	Host     string
@@ -241,7 +245,7 @@ func SetupTestEnvironment() error {
 	}
 
 	// Create test backup directory
-	if err := os.MkdirAll("/tmp/test-backups", 0755); err != nil {
+	if err := os.MkdirAll(testBackupDir, 0755); err != nil {
 		return fmt.Errorf("failed to create test backup directory: %w", err)
 	}
 
@@ -251,7 +255,7 @@ func SetupTestEnvironment() error {
 // CleanupTestEnvironment cleans up the test environment
 func CleanupTestEnvironment() error {
 	// Remove test backup directory
-	if err := os.RemoveAll("/tmp/test-backups"); err != nil {
+	if err := os.RemoveAll(testBackupDir); err != nil {
 		log.Printf("Warning: failed to cleanup test backup directory: %v", err)
 	}
 	return nil
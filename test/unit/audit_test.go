@@ -0,0 +1,102 @@
+package unit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"db-backuper/internal/audit"
+	"db-backuper/internal/config"
+	"db-backuper/internal/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakeObjectWriter records objects written by the audit log
+type fakeObjectWriter struct {
+	objects map[string][]byte
+}
+
+// PutObject stores the object in memory
+func (f *fakeObjectWriter) PutObject(key string, data []byte, contentType string) error {
+	f.objects[key] = data
+	return nil
+}
+
+// TestAuditLogRetentionDeletes tests that retention cleanup is recorded in the audit log
+func TestAuditLogRetentionDeletes(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "audit-log")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	backupRoot := filepath.Join(tempDir, "backups")
+	auditPath := filepath.Join(tempDir, "audit", "audit.log")
+	objects := &fakeObjectWriter{objects: make(map[string][]byte)}
+
+	auditLog := audit.NewLog(&config.AuditConfig{Path: auditPath, S3Prefix: "audit"}, objects)
+
+	localStorage, err := storage.NewLocalStorage(&config.LocalConfig{Path: backupRoot}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+	localStorage.SetAuditLog(auditLog)
+
+	oldDate := time.Now().AddDate(0, 0, -5).Format("2006-01-02")
+	oldDir := filepath.Join(backupRoot, "test-backup", "testdb", oldDate)
+	if err := os.MkdirAll(oldDir, 0755); err != nil {
+		t.Fatalf("Failed to create old backup directory: %v", err)
+	}
+
+	if _, err := localStorage.DeleteOldBackups("test-backup", 1); err != nil {
+		t.Fatalf("Failed to cleanup old backups: %v", err)
+	}
+
+	file, err := os.Open(auditPath)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer file.Close()
+
+	var events []audit.Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event audit.Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Failed to decode audit event: %v", err)
+		}
+		events = append(events, event)
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 audit event, got %d", len(events))
+	}
+	event := events[0]
+	if event.Action != audit.ActionRetentionDelete {
+		t.Errorf("Expected action %s, got %s", audit.ActionRetentionDelete, event.Action)
+	}
+	if len(event.Targets) != 1 || event.Targets[0] != oldDir {
+		t.Errorf("Expected target %s, got %v", oldDir, event.Targets)
+	}
+	if event.Actor == "" || event.ID == "" || event.Time.IsZero() {
+		t.Errorf("Expected actor, id and time to be set: %+v", event)
+	}
+	if len(objects.objects) != 1 {
+		t.Errorf("Expected 1 audit object, got %d", len(objects.objects))
+	}
+}
+
+// TestAuditLogDisabled tests that an unconfigured audit log records nothing
+func TestAuditLogDisabled(t *testing.T) {
+	auditLog := audit.NewLog(&config.AuditConfig{}, nil)
+	if auditLog != nil {
+		t.Fatalf("Expected nil audit log when not configured")
+	}
+	if err := auditLog.Record(audit.Event{Action: audit.ActionDelete}); err != nil {
+		t.Errorf("Expected nil audit log to ignore events, got: %v", err)
+	}
+}
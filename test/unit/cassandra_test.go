@@ -0,0 +1,117 @@
+package unit
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/backup"
+	"db-backuper/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakeNodetool creates and clears snapshot directories the way nodetool does
+const fakeNodetool = `#!/bin/sh
+case "$1" in
+  info) exit 0 ;;
+  snapshot)
+    dir="$DATA_DIR/$4/users-1234/snapshots/$3"
+    mkdir -p "$dir" && echo sstable > "$dir/nb-1-big-Data.db" && echo schema > "$dir/schema.cql" ;;
+  clearsnapshot)
+    rm -rf "$DATA_DIR"/"$5"/*/snapshots/"$3" ;;
+esac
+`
+
+// TestCassandraSnapshotBackup tests snapshotting, archiving and clearing a keyspace snapshot
+func TestCassandraSnapshotBackup(t *testing.T) {
+	dir := t.TempDir()
+	dataDir := filepath.Join(dir, "data")
+	if err := os.MkdirAll(filepath.Join(dataDir, "shop", "users-1234"), 0755); err != nil {
+		t.Fatalf("Failed to create data directory: %v", err)
+	}
+
+	nodetool := filepath.Join(dir, "nodetool")
+	if err := os.WriteFile(nodetool, []byte(fakeNodetool), 0755); err != nil {
+		t.Fatalf("Failed to write fake nodetool: %v", err)
+	}
+	t.Setenv("DATA_DIR", dataDir)
+
+	hookLog := filepath.Join(dir, "hooks.log")
+	engine := backup.NewCassandraBackup(&config.DatabaseConfig{
+		Type:     config.EngineTypeCassandra,
+		Database: "shop",
+		Cassandra: config.CassandraConfig{
+			DataDir:          dataDir,
+			Nodetool:         nodetool,
+			Node:             "node1",
+			PreSnapshotHook:  "echo pre $DB_BACKUP_KEYSPACE $DB_BACKUP_NODE >> " + hookLog,
+			PostSnapshotHook: "echo post >> " + hookLog,
+		},
+	}, logrus.New())
+
+	if err := engine.TestConnection(); err != nil {
+		t.Fatalf("Connection test failed: %v", err)
+	}
+
+	backupPath, err := engine.CreateBackup()
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	defer engine.CleanupBackup(backupPath)
+
+	if !strings.HasSuffix(backupPath, "_node1.tar.gz") {
+		t.Errorf("Expected node name in backup filename, got %s", backupPath)
+	}
+
+	names := archiveNames(t, backupPath)
+	expected := []string{"users-1234/", "users-1234/nb-1-big-Data.db", "users-1234/schema.cql"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected archive entries %v, got %v", expected, names)
+	}
+
+	snapshots, _ := filepath.Glob(filepath.Join(dataDir, "shop", "*", "snapshots", "*"))
+	if len(snapshots) != 0 {
+		t.Errorf("Expected snapshot to be cleared, found %v", snapshots)
+	}
+
+	hooks, err := os.ReadFile(hookLog)
+	if err != nil {
+		t.Fatalf("Failed to read hook log: %v", err)
+	}
+	if string(hooks) != "pre shop node1\npost\n" {
+		t.Errorf("Unexpected hook output: %q", hooks)
+	}
+}
+
+// archiveNames lists the entries of a tar.gz file
+func archiveNames(t *testing.T, path string) []string {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+
+	var names []string
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, header.Name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,207 @@
+package unit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"db-backuper/internal/audit"
+	"db-backuper/internal/catalog"
+	"db-backuper/internal/config"
+	"db-backuper/internal/provenance"
+	"db-backuper/internal/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestRestorePointCatalog tests tagging, looking up and removing restore points
+func TestRestorePointCatalog(t *testing.T) {
+	root := t.TempDir()
+	backend, err := storage.NewLocalStorage(&config.LocalConfig{Path: root}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+	cat := catalog.New(backend, "postgres-backup")
+
+	if points, err := cat.RestorePoints(); err != nil || len(points) != 0 {
+		t.Fatalf("Expected an empty catalog, got %v (%v)", points, err)
+	}
+
+	first := catalog.RestorePoint{
+		Name:      "pre-migration-v42",
+		Database:  "orders",
+		Key:       "postgres-backup/orders/2024-01-15/orders_2024-01-15_02-00-00.sql",
+		CreatedAt: time.Date(2024, 1, 15, 2, 5, 0, 0, time.UTC),
+	}
+	second := catalog.RestorePoint{
+		Name:     "release-7",
+		Database: "orders",
+		Key:      "postgres-backup/orders/2024-02-01/orders_2024-02-01_02-00-00.sql",
+	}
+	for _, point := range []catalog.RestorePoint{first, second} {
+		if err := cat.Tag(point, false); err != nil {
+			t.Fatalf("Failed to tag %s: %v", point.Name, err)
+		}
+	}
+
+	got, err := cat.Get("pre-migration-v42")
+	if err != nil || got.Key != first.Key {
+		t.Errorf("Expected %s, got %+v (%v)", first.Key, got, err)
+	}
+
+	// Names are unique unless the point is explicitly moved
+	moved := first
+	moved.Key = second.Key
+	if err := cat.Tag(moved, false); err == nil {
+		t.Error("Expected a duplicate name to be rejected")
+	}
+	if err := cat.Tag(moved, true); err != nil {
+		t.Errorf("Failed to replace restore point: %v", err)
+	}
+
+	points, err := cat.RestorePoints()
+	if err != nil || len(points) != 2 || points[0].Name != "release-7" {
+		t.Errorf("Expected two restore points, newest first, got %+v (%v)", points, err)
+	}
+
+	if err := cat.Untag("release-7"); err != nil {
+		t.Errorf("Failed to untag: %v", err)
+	}
+	if _, err := cat.Get("release-7"); !errors.Is(err, catalog.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	if err := cat.Tag(catalog.RestorePoint{Name: "../escape", Key: first.Key}, false); err == nil {
+		t.Error("Expected an invalid name to be rejected")
+	}
+
+	// Retention cleanup must leave the catalog alone
+	if _, err := backend.DeleteOldBackups("postgres-backup", 0); err != nil {
+		t.Fatalf("Retention cleanup failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "postgres-backup", catalog.Dir, "catalog.json")); err != nil {
+		t.Errorf("Expected the catalog to survive retention cleanup: %v", err)
+	}
+}
+
+// TestCatalogExport tests the retention decisions, event selection and
+// encodings of a catalog export
+func TestCatalogExport(t *testing.T) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	export := catalog.NewExport(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC), 30, "auditor@host", now)
+
+	holds := []storage.Hold{{Key: "postgres-backup/orders/2024-01-15/orders_2024-01-15_02-00-00.sql", Reason: "legal hold 42"}}
+	meta := &provenance.Metadata{Database: "orders", SHA256: "abc123", RunID: "01HM7Z8X4T2V6C9R3K5N1QWJBE", CreatedAt: time.Date(2024, 3, 20, 2, 0, 0, 0, time.UTC)}
+	export.AddBackup("/var/backups", storage.Entry{Key: "postgres-backup/orders/2024-03-20/orders_2024-03-20_02-00-00.sql", Database: "orders", Date: time.Date(2024, 3, 20, 0, 0, 0, 0, time.UTC), Size: 1024}, meta, holds)
+	export.AddBackup("/var/backups", storage.Entry{Key: "postgres-backup/orders/2024-03-01/orders_2024-03-01_02-00-00.sql", Database: "orders", Date: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}, nil, holds)
+	export.AddBackup("/var/backups", storage.Entry{Key: "postgres-backup/orders/2024-01-15/orders_2024-01-15_02-00-00.sql", Database: "orders", Date: time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)}, nil, holds)
+
+	// Events are read back from an audit file, keeping those of the range
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	auditLog := audit.NewLog(&config.AuditConfig{Path: auditPath}, nil)
+	for _, event := range []audit.Event{
+		{Action: audit.ActionRetentionDelete, Time: time.Date(2024, 2, 1, 2, 0, 0, 0, time.UTC), Targets: []string{"postgres-backup/orders/2023-12-31"}},
+		{Action: audit.ActionShare, Time: time.Date(2024, 2, 2, 2, 0, 0, 0, time.UTC), Targets: []string{"postgres-backup/orders/2024-02-01/x.sql"}},
+		{Action: audit.ActionRestore, Time: time.Date(2024, 3, 31, 23, 59, 0, 0, time.UTC), Actor: "alice", Targets: []string{"postgres-backup/orders/2024-03-20/x.sql"}, Details: map[string]string{"approved_by": "bob"}},
+		{Action: audit.ActionDelete, Time: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), Targets: []string{"postgres-backup/orders/2024-03-01/x.sql"}},
+	} {
+		if err := auditLog.Record(event); err != nil {
+			t.Fatalf("Failed to record audit event: %v", err)
+		}
+	}
+	events, err := audit.ReadFile(auditPath)
+	if err != nil || len(events) != 4 {
+		t.Fatalf("Expected 4 audit events, got %d (%v)", len(events), err)
+	}
+	export.AddAuditEvents(events)
+	export.AddRestore("orders", "rehearsal", "postgres-backup/orders/2024-03-01/x.sql", time.Date(2024, 3, 2, 4, 0, 0, 0, time.UTC), map[string]string{"duration_seconds": "60"})
+	export.AddRestore("orders", "import", "", time.Date(2023, 12, 31, 4, 0, 0, 0, time.UTC), nil)
+
+	data, err := export.JSON()
+	if err != nil {
+		t.Fatalf("Failed to encode export: %v", err)
+	}
+	var decoded catalog.Export
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Invalid JSON export: %v", err)
+	}
+	want := map[string][2]string{
+		"2024-01-15": {catalog.RetentionHeld, "2024-02-14"},
+		"2024-03-01": {catalog.RetentionExpired, "2024-03-31"},
+		"2024-03-20": {catalog.RetentionKept, "2024-04-19"},
+	}
+	if len(decoded.Backups) != 3 {
+		t.Fatalf("Expected 3 backups, got %+v", decoded.Backups)
+	}
+	for _, backup := range decoded.Backups {
+		if got := [2]string{backup.Retention, backup.ExpiresOn}; got != want[backup.Date] {
+			t.Errorf("Expected backup of %s to be %v, got %v", backup.Date, want[backup.Date], got)
+		}
+	}
+	if decoded.Backups[0].HoldReason != "legal hold 42" || decoded.Backups[2].SHA256 != "abc123" {
+		t.Errorf("Expected the hold reason and checksum to be exported, got %+v", decoded.Backups)
+	}
+	var actions []string
+	for _, event := range decoded.Events {
+		actions = append(actions, event.Category+" "+event.Action)
+	}
+	if wantActions := []string{"retention retention_delete", "restore rehearsal", "restore restore"}; !slices.Equal(actions, wantActions) {
+		t.Errorf("Expected events %v, got %v", wantActions, actions)
+	}
+
+	data, err = export.CSV()
+	if err != nil {
+		t.Fatalf("Failed to encode export: %v", err)
+	}
+	rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil || len(rows) != 7 {
+		t.Fatalf("Expected a header and 6 rows, got %d (%v)", len(rows), err)
+	}
+	if rows[1][0] != "backup" || rows[1][12] != "hold_reason=legal hold 42" || rows[6][0] != "restore" || rows[6][11] != "alice" || rows[6][12] != "approved_by=bob" {
+		t.Errorf("Unexpected CSV rows %q", rows)
+	}
+}
+
+// TestCatalogExportSigningKey tests loading the key that signs exports
+func TestCatalogExportSigningKey(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("Failed to encode key: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "catalog-key.pem")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	key, err := catalog.LoadSigningKey(keyPath)
+	if err != nil {
+		t.Fatalf("Failed to load signing key: %v", err)
+	}
+	report := []byte("record,time\n")
+	if !ed25519.Verify(public, report, ed25519.Sign(key, report)) {
+		t.Error("Expected the signature to verify with the public key")
+	}
+	if len(catalog.KeyFingerprint(key)) != 64 {
+		t.Errorf("Expected a hex SHA-256 fingerprint, got %q", catalog.KeyFingerprint(key))
+	}
+
+	if err := os.WriteFile(keyPath, []byte("not a key"), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	if _, err := catalog.LoadSigningKey(keyPath); err == nil {
+		t.Error("Expected a file without a PEM key to be rejected")
+	}
+}
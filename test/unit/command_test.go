@@ -0,0 +1,80 @@
+package unit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/backup"
+	"db-backuper/internal/config"
+	"db-backuper/internal/restore"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestExpandCommand tests placeholder substitution in command templates
+func TestExpandCommand(t *testing.T) {
+	values := map[string]string{"database": "it's", "output": "/tmp/out"}
+
+	got, err := backup.ExpandCommand(`dump --db {database} > {output} && echo ${HOME}`, values)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `dump --db 'it'\''s' > '/tmp/out' && echo ${HOME}`
+	if got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	if _, err := backup.ExpandCommand("dump {unknown}", values); err == nil {
+		t.Error("Expected error for unknown placeholder")
+	}
+}
+
+// TestCommandBackupAndRestore tests a compressed stdout dump and its restore through stdin
+func TestCommandBackupAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	restored := filepath.Join(dir, "restored.txt")
+
+	dbConfig := &config.DatabaseConfig{
+		Type:     config.EngineTypeCommand,
+		Database: "notes",
+		Password: "hunter22",
+		Command: config.CommandConfig{
+			Dump:      `echo data for {database} "$DB_BACKUP_PASSWORD"`,
+			Restore:   "cat > " + restored,
+			Extension: "txt",
+			Compress:  true,
+		},
+	}
+
+	engine, err := backup.NewEngine(dbConfig, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := engine.TestConnection(); err != nil {
+		t.Fatalf("Template check failed: %v", err)
+	}
+
+	backupPath, err := engine.CreateBackup()
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	defer engine.CleanupBackup(backupPath)
+
+	if !strings.HasSuffix(backupPath, ".txt.gz") {
+		t.Errorf("Expected compressed backup, got %s", backupPath)
+	}
+
+	if err := restore.NewCommandRestore(dbConfig, logrus.New()).Restore(backupPath); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	data, err := os.ReadFile(restored)
+	if err != nil {
+		t.Fatalf("Failed to read restored data: %v", err)
+	}
+	if string(data) != "data for notes hunter22\n" {
+		t.Errorf("Unexpected restored data: %q", data)
+	}
+}
@@ -0,0 +1,140 @@
+package unit
+
+import (
+	"bytes"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/completion"
+	"db-backuper/internal/output"
+)
+
+// completionCommands returns a list command with an -output flag and a
+// retention command with a simulate action
+func completionCommands(built *int) []completion.Command {
+	listFlags := func() *flag.FlagSet {
+		*built++
+		fs := flag.NewFlagSet("list", flag.ContinueOnError)
+		fs.String("database", "", "Only list backups of this database")
+		fs.Bool("all", false, "List every backup\nincluding held ones")
+		format := output.Format(output.Table)
+		fs.Var(&format, "output", "Output format: table, json or yaml")
+		return fs
+	}
+	simulateFlags := func() *flag.FlagSet {
+		fs := flag.NewFlagSet("retention simulate", flag.ContinueOnError)
+		fs.Int("keep-last", 0, "Keep the newest backups of each database")
+		fs.Int("keep-daily", 0, "Keep the newest backup of each day")
+		return fs
+	}
+	return []completion.Command{
+		{Name: "list", Description: "List stored backups", Flags: listFlags},
+		{Name: "restore", Description: "Import a backup", Flags: func() *flag.FlagSet {
+			return flag.NewFlagSet("restore", flag.ContinueOnError)
+		}},
+		{Name: "completion", Description: "Print a completion script", Args: completion.Shells, Flags: func() *flag.FlagSet {
+			return flag.NewFlagSet("completion", flag.ContinueOnError)
+		}},
+		{Name: "retention", Description: "Simulate a retention policy", Actions: []completion.Command{
+			{Name: "simulate", Description: "Report the backups a policy would delete", Flags: simulateFlags},
+		}},
+	}
+}
+
+// candidateValues returns the values of candidates
+func candidateValues(candidates []completion.Candidate) []string {
+	var result []string
+	for _, candidate := range candidates {
+		result = append(result, candidate.Value)
+	}
+	return result
+}
+
+// TestComplete tests completing command and action names, flags in either
+// dash style and the formats of -output
+func TestComplete(t *testing.T) {
+	var built int
+	commands := completionCommands(&built)
+
+	tests := []struct {
+		words    []string
+		expected []string
+		files    bool
+	}{
+		{words: nil, expected: []string{"list", "restore", "completion", "retention"}},
+		{words: []string{"re"}, expected: []string{"restore", "retention"}},
+		{words: []string{"list", "-"}, expected: []string{"-all", "-database", "-output"}},
+		{words: []string{"list", "--d"}, expected: []string{"--database"}},
+		{words: []string{"list", "-output", ""}, expected: []string{"table", "json", "yaml"}},
+		{words: []string{"list", "--output", "j"}, expected: []string{"json"}},
+		{words: []string{"list", "-output=y"}, expected: []string{"-output=yaml"}},
+		{words: []string{"list", "-database", ""}, files: true},
+		{words: []string{"list", "-all", ""}, files: true},
+		{words: []string{"retention", ""}, expected: []string{"simulate"}},
+		{words: []string{"retention", "simulate", "-keep-"}, expected: []string{"-keep-daily", "-keep-last"}},
+		{words: []string{"restore", ""}, files: true},
+		{words: []string{"completion", ""}, expected: []string{"bash", "zsh", "fish"}},
+		{words: []string{"completion", "f"}, expected: []string{"fish"}},
+		{words: []string{"unknown", "-"}},
+	}
+	for _, tt := range tests {
+		candidates, files := completion.Complete(commands, tt.words)
+		if got := candidateValues(candidates); !reflect.DeepEqual(got, tt.expected) || files != tt.files {
+			t.Errorf("%q: expected %v (files %v), got %v (files %v)", tt.words, tt.expected, tt.files, got, files)
+		}
+	}
+
+	candidates, _ := completion.Complete(commands, []string{"list", "-a"})
+	if len(candidates) != 1 || candidates[0].Description != "List every backup" {
+		t.Errorf("Expected the first line of the usage as description, got %+v", candidates)
+	}
+	candidates, _ = completion.Complete(commands, []string{"l"})
+	if len(candidates) != 1 || candidates[0].Description != "List stored backups" {
+		t.Errorf("Expected the command description, got %+v", candidates)
+	}
+	if built == 0 {
+		t.Error("Expected completion to build the flag set of list")
+	}
+}
+
+// TestCompletionWrite tests the answer to a completion request
+func TestCompletionWrite(t *testing.T) {
+	var buf bytes.Buffer
+	candidates := []completion.Candidate{{Value: "list", Description: "List stored backups"}, {Value: "json"}}
+	if err := completion.Write(&buf, candidates, false); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "list\tList stored backups\njson\n"; buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := completion.Write(&buf, candidates, true); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != ":files\n" {
+		t.Errorf("Expected the files directive alone, got %q", buf.String())
+	}
+}
+
+// TestCompletionScript tests that each shell gets a script running the
+// completion request and that other shells are rejected
+func TestCompletionScript(t *testing.T) {
+	for _, shell := range completion.Shells {
+		script, err := completion.Script(shell)
+		if err != nil {
+			t.Fatalf("%s: %v", shell, err)
+		}
+		if !strings.Contains(script, completion.RequestCommand) || !strings.Contains(script, ":files") {
+			t.Errorf("%s: expected the script to run %s and handle the files directive", shell, completion.RequestCommand)
+		}
+		if strings.Contains(script, "{{") {
+			t.Errorf("%s: unreplaced placeholder in script", shell)
+		}
+	}
+	if _, err := completion.Script("powershell"); err == nil {
+		t.Error("Expected an unknown shell to be rejected")
+	}
+}
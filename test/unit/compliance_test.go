@@ -0,0 +1,76 @@
+package unit
+
+import (
+	"testing"
+
+	"db-backuper/internal/compliance"
+	"db-backuper/internal/config"
+)
+
+// TestComplianceReport tests checking configurations against the regulated mode requirements
+func TestComplianceReport(t *testing.T) {
+	compliant := func() *config.Config {
+		return &config.Config{
+			Databases: []config.DatabaseConfig{{
+				Host:     "db.internal",
+				Username: "backup",
+				Database: "orders",
+				SSLMode:  "verify-full",
+				IAMAuth:  true,
+			}},
+			AWS: config.AWSConfig{
+				Region:  "us-gov-west-1",
+				Bucket:  "backups",
+				RoleARN: "arn:aws-us-gov:iam::123456789012:role/backup",
+			},
+			Compliance: config.ComplianceConfig{Regulated: true},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*config.Config)
+		failed string
+	}{
+		{"compliant", func(c *config.Config) {}, ""},
+		{"static keys", func(c *config.Config) {
+			c.AWS.AccessKeyID = "AKIAEXAMPLE"
+			c.AWS.SecretAccessKey = "secret"
+		}, "S3 credentials"},
+		{"plaintext password", func(c *config.Config) {
+			c.Databases[0].IAMAuth = false
+			c.Databases[0].Password = "secret"
+		}, "database orders credentials"},
+		{"unencrypted connection", func(c *config.Config) {
+			c.Databases[0].SSLMode = "prefer"
+		}, "database orders TLS"},
+		{"import target", func(c *config.Config) {
+			c.Import.TargetDatabase.Host = "restore.internal"
+			c.Import.TargetDatabase.SSLMode = "disable"
+		}, "import target database TLS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := compliant()
+			tt.modify(cfg)
+			err := compliance.Evaluate(cfg).Err()
+			if tt.failed == "" {
+				if err != nil {
+					t.Errorf("Expected compliance, got %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Expected %s to fail", tt.failed)
+			}
+			if err.Error() != "regulated mode requirements not met: "+tt.failed {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+
+	if compliance.HTTPClient() != nil {
+		t.Errorf("Expected the default HTTP client outside regulated mode")
+	}
+}
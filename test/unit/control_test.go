@@ -0,0 +1,82 @@
+package unit
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"db-backuper/internal/control"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestControlSocketCommands tests the on-demand backup control socket
+func TestControlSocketCommands(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "control-socket")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	socketPath := filepath.Join(tempDir, "control.sock")
+
+	var mu sync.Mutex
+	var triggers []string
+	busy := false
+	trigger := func(name string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		triggers = append(triggers, name)
+		return !busy
+	}
+
+	server := control.NewSocketServer(socketPath, trigger, logrus.New())
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start control socket: %v", err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Failed to connect to control socket: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	send := func(command string) string {
+		if _, err := fmt.Fprintln(conn, command); err != nil {
+			t.Fatalf("Failed to send command: %v", err)
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		return line[:len(line)-1]
+	}
+
+	if resp := send("ping"); resp != "OK pong" {
+		t.Errorf("Expected 'OK pong', got '%s'", resp)
+	}
+	if resp := send("backup"); resp != "OK backup started" {
+		t.Errorf("Expected 'OK backup started', got '%s'", resp)
+	}
+	mu.Lock()
+	busy = true
+	mu.Unlock()
+	if resp := send("backup"); resp != "BUSY backup already running" {
+		t.Errorf("Expected busy response, got '%s'", resp)
+	}
+	if resp := send("bogus"); resp != "ERROR unknown command: bogus" {
+		t.Errorf("Expected unknown command error, got '%s'", resp)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(triggers) != 2 || triggers[0] != "control-socket" {
+		t.Errorf("Expected 2 control-socket triggers, got %v", triggers)
+	}
+}
@@ -0,0 +1,62 @@
+package unit
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"db-backuper/pkg/dbbackup"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestRunnerBacksUpToLocalStorage tests embedding the backup service through the public package
+func TestRunnerBacksUpToLocalStorage(t *testing.T) {
+	uploads := filepath.Join(t.TempDir(), "uploads")
+	if err := os.MkdirAll(uploads, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(uploads, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	backupDir := t.TempDir()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	runner := dbbackup.NewRunner(logger)
+	if err := runner.AddDatabase(dbbackup.DatabaseConfig{Type: "filesystem", Database: "uploads", Path: uploads}); err != nil {
+		t.Fatalf("Failed to add database: %v", err)
+	}
+
+	// Nothing runs before a storage backend is selected
+	if _, err := runner.Run(context.Background()); err == nil {
+		t.Fatal("Expected a run without storage to fail")
+	}
+
+	runner.SetStorage(dbbackup.Storage{Local: &dbbackup.LocalConfig{Path: backupDir}})
+	summary, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if summary.Successful != 1 || len(summary.Databases) != 1 {
+		t.Fatalf("Expected one successful backup, got %+v", summary)
+	}
+	location := summary.Databases[0].Location
+	if !strings.HasPrefix(location, filepath.Join(backupDir, "postgres-backup", "uploads")) {
+		t.Errorf("Expected the backup under the default prefix, got %s", location)
+	}
+	if _, err := os.Stat(location); err != nil {
+		t.Errorf("Expected the backup to be stored: %v", err)
+	}
+
+	// A cancelled run skips the databases it has not started
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	summary, err = runner.Run(ctx)
+	if err == nil || summary.Skipped != 1 {
+		t.Errorf("Expected the database to be skipped, got %v, %+v", err, summary)
+	}
+}
@@ -0,0 +1,117 @@
+package unit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/docker"
+)
+
+// TestDockerClient tests the container lifecycle calls against a fake Docker daemon
+func TestDockerClient(t *testing.T) {
+	var requests []string
+	var created map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.URL.Path == "/images/create":
+			if r.URL.Query().Get("fromImage") != "postgres" || r.URL.Query().Get("tag") != "15" {
+				t.Errorf("Unexpected pull query: %s", r.URL.RawQuery)
+			}
+			fmt.Fprintln(w, `{"status":"Pulling from library/postgres"}`)
+			fmt.Fprintln(w, `{"status":"Download complete"}`)
+		case r.URL.Path == "/containers/create":
+			json.NewDecoder(r.Body).Decode(&created)
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"Id":"0123456789abcdef0123"}`)
+		case strings.HasSuffix(r.URL.Path, "/start"):
+			w.WriteHeader(http.StatusNoContent)
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			fmt.Fprint(w, `{"NetworkSettings":{"Ports":{"5432/tcp":[{"HostIp":"0.0.0.0","HostPort":"49153"}]}}}`)
+		case r.Method == http.MethodDelete:
+			if r.URL.Query().Get("force") != "true" {
+				t.Errorf("Expected a forced removal, got %s", r.URL.RawQuery)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message":"page not found"}`)
+		}
+	}))
+	defer server.Close()
+
+	client, err := docker.NewClient("tcp://" + strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if client.Host() != "127.0.0.1" {
+		t.Errorf("Expected ports to be reached on the daemon host, got %s", client.Host())
+	}
+
+	if err := client.Pull("postgres:15"); err != nil {
+		t.Fatalf("Failed to pull: %v", err)
+	}
+	id, err := client.Create(docker.ContainerSpec{Image: "postgres:15", Env: map[string]string{"POSTGRES_DB": "orders"}, Port: "5432/tcp"})
+	if err != nil || id != "0123456789abcdef0123" {
+		t.Fatalf("Failed to create container: %q, %v", id, err)
+	}
+	if env, _ := created["Env"].([]any); len(env) != 1 || env[0] != "POSTGRES_DB=orders" {
+		t.Errorf("Unexpected container environment: %v", created["Env"])
+	}
+	if err := client.Start(id); err != nil {
+		t.Fatalf("Failed to start container: %v", err)
+	}
+	if port, err := client.HostPort(id, "5432/tcp"); err != nil || port != "49153" {
+		t.Errorf("Expected host port 49153, got %q (%v)", port, err)
+	}
+	if _, err := client.HostPort(id, "6379/tcp"); err == nil {
+		t.Error("Expected an unpublished port to be an error")
+	}
+	if err := client.Remove(id); err != nil {
+		t.Fatalf("Failed to remove container: %v", err)
+	}
+	if docker.ShortID(id) != "0123456789ab" {
+		t.Errorf("Unexpected short ID: %s", docker.ShortID(id))
+	}
+	if len(requests) != 6 {
+		t.Errorf("Unexpected requests: %v", requests)
+	}
+
+	if _, err := docker.NewClient("ssh://docker.example.com"); err == nil {
+		t.Error("Expected an unsupported docker host to be rejected")
+	}
+}
+
+// TestDockerPullError tests that pull failures reported in the progress stream are returned
+func TestDockerPullError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"status":"Pulling from library/postgres"}`)
+		fmt.Fprintln(w, `{"error":"manifest for postgres:99 not found"}`)
+	}))
+	defer server.Close()
+
+	client, _ := docker.NewClient("tcp://" + strings.TrimPrefix(server.URL, "http://"))
+	if err := client.Pull("postgres:99"); err == nil || !strings.Contains(err.Error(), "manifest for postgres:99 not found") {
+		t.Errorf("Expected the pull error, got %v", err)
+	}
+}
+
+// TestRehearsalImageReference tests the image and version defaults of rehearsals
+func TestRehearsalImageReference(t *testing.T) {
+	var rehearsal config.RehearsalConfig
+	if image := rehearsal.ImageReference(""); image != "postgres:16" {
+		t.Errorf("Expected the default image, got %s", image)
+	}
+	rehearsal = config.RehearsalConfig{Image: "registry.example.com:5000/postgres", PostgresVersion: "15"}
+	if image := rehearsal.ImageReference(""); image != "registry.example.com:5000/postgres:15" {
+		t.Errorf("Expected the configured image, got %s", image)
+	}
+	if image := rehearsal.ImageReference("13"); image != "registry.example.com:5000/postgres:13" {
+		t.Errorf("Expected the version flag to win, got %s", image)
+	}
+}
@@ -0,0 +1,70 @@
+package unit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"db-backuper/internal/config"
+)
+
+// TestLoadDotEnv tests parsing a .env file without overriding exported variables
+func TestLoadDotEnv(t *testing.T) {
+	// Register cleanup for every variable, then start with them unset
+	for _, key := range []string{"DOTENV_HOST", "DOTENV_PASSWORD", "DOTENV_SINGLE", "DOTENV_COMMENT", "DOTENV_EMPTY", "DOTENV_EXPORTED"} {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+	t.Setenv("DOTENV_EXPORTED", "from-shell")
+
+	path := filepath.Join(t.TempDir(), ".env")
+	content := `# Local development settings
+DOTENV_HOST=localhost
+export DOTENV_PASSWORD="p@ss \"word\"\nline"
+DOTENV_SINGLE='literal $HOME \n'
+DOTENV_COMMENT=value # trailing comment
+DOTENV_EMPTY=
+DOTENV_EXPORTED=from-file
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write env file: %v", err)
+	}
+
+	if err := config.LoadDotEnv(path); err != nil {
+		t.Fatalf("Failed to load env file: %v", err)
+	}
+
+	expected := map[string]string{
+		"DOTENV_HOST":     "localhost",
+		"DOTENV_PASSWORD": "p@ss \"word\"\nline",
+		"DOTENV_SINGLE":   `literal $HOME \n`,
+		"DOTENV_COMMENT":  "value",
+		"DOTENV_EMPTY":    "",
+		"DOTENV_EXPORTED": "from-shell",
+	}
+	for key, want := range expected {
+		got, ok := os.LookupEnv(key)
+		if !ok {
+			t.Errorf("Expected %s to be set", key)
+		} else if got != want {
+			t.Errorf("Expected %s=%q, got %q", key, want, got)
+		}
+	}
+}
+
+// TestLoadDotEnvInvalid tests that malformed lines are rejected
+func TestLoadDotEnvInvalid(t *testing.T) {
+	for _, content := range []string{"NOT A VARIABLE\n", "KEY=\"unterminated\n"} {
+		path := filepath.Join(t.TempDir(), ".env")
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write env file: %v", err)
+		}
+		if err := config.LoadDotEnv(path); err == nil {
+			t.Errorf("Expected %q to be rejected", content)
+		}
+	}
+
+	if err := config.LoadDotEnv(filepath.Join(t.TempDir(), "missing.env")); err == nil {
+		t.Error("Expected a missing env file to be reported")
+	}
+}
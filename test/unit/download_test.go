@@ -0,0 +1,149 @@
+package unit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/provenance"
+	"db-backuper/internal/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestDownloadDestination tests resolving -output to the path a backup is
+// downloaded to
+func TestDownloadDestination(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing")
+	if err := os.Mkdir(existing, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		output   string
+		expected string
+	}{
+		{"", "orders.sql"},
+		{existing, filepath.Join(existing, "orders.sql")},
+		{filepath.Join(dir, "new") + string(filepath.Separator), filepath.Join(dir, "new", "orders.sql")},
+		{filepath.Join(dir, "nested", "copy.sql"), filepath.Join(dir, "nested", "copy.sql")},
+	}
+	for _, tt := range tests {
+		got, err := storage.DownloadDestination(tt.output, "orders.sql")
+		if err != nil {
+			t.Fatalf("DownloadDestination(%q) failed: %v", tt.output, err)
+		}
+		if got != tt.expected {
+			t.Errorf("DownloadDestination(%q) = %q, expected %q", tt.output, got, tt.expected)
+		}
+		if _, err := os.Stat(filepath.Dir(got)); err != nil {
+			t.Errorf("Expected the directory of %s to be created: %v", got, err)
+		}
+	}
+}
+
+// storeWithProvenance stores content as a backup of orders in local storage
+// under dir, with provenance recording its checksum and a table manifest
+func storeWithProvenance(t *testing.T, dir, name string, content []byte) (*storage.LocalStorage, string) {
+	t.Helper()
+	localStorage, err := storage.NewLocalStorage(&config.LocalConfig{Path: filepath.Join(dir, "backups")}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+	dumpFile := filepath.Join(dir, name)
+	if err := os.WriteFile(dumpFile, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := provenance.Describe(dumpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.Database = "orders"
+	rows := int64(2)
+	meta.Tables = []provenance.TableStats{{Name: "public.orders", Rows: &rows}}
+	key := "db-backup/orders/2024-01-15/" + name
+	if err := localStorage.UploadFile(dumpFile, key, meta); err != nil {
+		t.Fatalf("Failed to store backup: %v", err)
+	}
+	return localStorage, key
+}
+
+// TestVerifyDownload tests checking a downloaded backup against the checksum
+// recorded with it
+func TestVerifyDownload(t *testing.T) {
+	dir := t.TempDir()
+	localStorage, key := storeWithProvenance(t, dir, "orders_2024-01-15_02-00-00.sql", []byte("backup"))
+
+	destPath := filepath.Join(dir, "download.sql")
+	if err := localStorage.Download(key, destPath); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	meta, err := storage.VerifyDownload(localStorage, key, destPath)
+	if err != nil || meta == nil || meta.Database != "orders" {
+		t.Fatalf("Expected the download to match its provenance, got %+v (%v)", meta, err)
+	}
+	if saved, err := provenance.ReadSidecar(destPath); err != nil || saved == nil || len(saved.Tables) != 1 {
+		t.Errorf("Expected the table manifest next to the download, got %+v (%v)", saved, err)
+	}
+
+	// A corrupted copy is rejected
+	if err := os.WriteFile(destPath, []byte("backuq"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.VerifyDownload(localStorage, key, destPath); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("Expected a checksum mismatch, got %v", err)
+	}
+}
+
+// TestDecompressDownload tests decompressing a downloaded gzip backup along
+// with its manifest
+func TestDecompressDownload(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte("SELECT 1;\n"))
+	gz.Close()
+
+	dir := t.TempDir()
+	localStorage, key := storeWithProvenance(t, dir, "orders_2024-01-15_02-00-00.sql.gz", compressed.Bytes())
+	destPath := filepath.Join(dir, "download", "orders.sql.gz")
+	os.Mkdir(filepath.Dir(destPath), 0755)
+	if err := localStorage.Download(key, destPath); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if _, err := storage.VerifyDownload(localStorage, key, destPath); err != nil {
+		t.Fatalf("Verification failed: %v", err)
+	}
+
+	decompressed, err := storage.Decompress(destPath)
+	if err != nil {
+		t.Fatalf("Decompress failed: %v", err)
+	}
+	if decompressed != strings.TrimSuffix(destPath, ".gz") {
+		t.Errorf("Expected the .gz suffix to be dropped, got %s", decompressed)
+	}
+	if data, err := os.ReadFile(decompressed); err != nil || string(data) != "SELECT 1;\n" {
+		t.Errorf("Expected the decompressed backup, got %q (%v)", data, err)
+	}
+	if _, err := os.Stat(destPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the compressed download to be removed")
+	}
+
+	meta, err := provenance.ReadSidecar(decompressed)
+	if err != nil || meta == nil {
+		t.Fatalf("Expected the manifest to move with the backup, got %+v (%v)", meta, err)
+	}
+	checksum, _ := provenance.Checksum(decompressed)
+	if meta.SHA256 != checksum || meta.Compression != provenance.CompressionNone || len(meta.Tables) != 1 {
+		t.Errorf("Expected the manifest to describe the decompressed backup, got %+v", meta)
+	}
+
+	// Uncompressed backups are left alone
+	if path, err := storage.Decompress(decompressed); err != nil || path != decompressed {
+		t.Errorf("Expected an uncompressed backup to be kept, got %s (%v)", path, err)
+	}
+}
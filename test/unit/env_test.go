@@ -1,8 +1,12 @@
 package unit
 
 import (
+	"bytes"
+	"encoding/base64"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"db-backuper/internal/config"
@@ -37,11 +41,7 @@ func TestEnvironmentVariableOverrides(t *testing.T) {
 	}()
 
 	// Create a temporary config file
-	tempDir := "/tmp/test_env_config"
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		t.Fatalf("Failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(tempDir)
+	tempDir := t.TempDir()
 
 	configFile := filepath.Join(tempDir, "test_config.json")
 	configContent := `{
@@ -109,7 +109,7 @@ func TestEnvironmentVariableOverrides(t *testing.T) {
 	}()
 
 	// Load configuration
-	cfg, err := config.LoadConfig(configFile)
+	cfg, err := config.LoadConfig(configFile, config.LoadOptions{})
 	if err != nil {
 		t.Fatalf("Failed to load config: %v", err)
 	}
@@ -207,11 +207,7 @@ func TestDatabaseIndexEnvironmentVariables(t *testing.T) {
 	}()
 
 	// Create a temporary config file with multiple databases
-	tempDir := "/tmp/test_db_index_config"
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		t.Fatalf("Failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(tempDir)
+	tempDir := t.TempDir()
 
 	configFile := filepath.Join(tempDir, "test_config.json")
 	configContent := `{
@@ -270,7 +266,7 @@ func TestDatabaseIndexEnvironmentVariables(t *testing.T) {
 	}()
 
 	// Load configuration
-	cfg, err := config.LoadConfig(configFile)
+	cfg, err := config.LoadConfig(configFile, config.LoadOptions{})
 	if err != nil {
 		t.Fatalf("Failed to load config: %v", err)
 	}
@@ -322,11 +318,7 @@ func TestDatabaseIndexEnvironmentVariables(t *testing.T) {
 // TestImportEnvironmentVariables tests import-specific environment variables
 func TestImportEnvironmentVariables(t *testing.T) {
 	// Create a temporary config file for import
-	tempDir := "/tmp/test_import_env_config"
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		t.Fatalf("Failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(tempDir)
+	tempDir := t.TempDir()
 
 	configFile := filepath.Join(tempDir, "test_config.json")
 	configContent := `{
@@ -376,7 +368,7 @@ func TestImportEnvironmentVariables(t *testing.T) {
 	}()
 
 	// Load configuration for import
-	cfg, err := config.LoadConfigForImport(configFile)
+	cfg, err := config.LoadConfigForImport(configFile, config.LoadOptions{})
 	if err != nil {
 		t.Fatalf("Failed to load import config: %v", err)
 	}
@@ -440,11 +432,7 @@ func TestEnvironmentVariablePriority(t *testing.T) {
 	}()
 
 	// Create a temporary config file
-	tempDir := "/tmp/test_env_priority"
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		t.Fatalf("Failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(tempDir)
+	tempDir := t.TempDir()
 
 	configFile := filepath.Join(tempDir, "test_config.json")
 	configContent := `{
@@ -483,7 +471,7 @@ func TestEnvironmentVariablePriority(t *testing.T) {
 	os.Setenv("BACKUP_RETENTION_DAYS", "14")
 
 	// Load configuration
-	cfg, err := config.LoadConfig(configFile)
+	cfg, err := config.LoadConfig(configFile, config.LoadOptions{})
 	if err != nil {
 		t.Fatalf("Failed to load config: %v", err)
 	}
@@ -516,3 +504,237 @@ func TestEnvironmentVariablePriority(t *testing.T) {
 		t.Errorf("Expected schedule '0 2 * * *' (from config), got '%s'", cfg.Backup.Schedule)
 	}
 }
+
+// TestLoadEnvConfig tests building a configuration from indexed environment variables only
+func TestLoadEnvConfig(t *testing.T) {
+	t.Setenv("DB_HOST", "")
+	t.Setenv("DB_0_HOST", "pg.internal")
+	t.Setenv("DB_0_USERNAME", "backup")
+	t.Setenv("DB_0_PASSWORD", "secret")
+	t.Setenv("DB_0_DATABASE", "orders")
+	t.Setenv("DB_1_TYPE", "sqlite")
+	t.Setenv("DB_1_DATABASE", "cache")
+	t.Setenv("DB_1_PATH", "/var/lib/cache.db")
+	t.Setenv("DB_2_HOST", "")
+	t.Setenv("LOCAL_BACKUP_PATH", "/tmp/backups")
+	t.Setenv("AWS_BUCKET", "")
+	t.Setenv("BACKUP_RETENTION_DAYS", "9")
+
+	cfg, err := config.LoadEnvConfig(config.Config{
+		Backup: config.BackupConfig{RetentionDays: 2, BackupPrefix: "postgres-backup"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to load env config: %v", err)
+	}
+
+	if len(cfg.Databases) != 2 {
+		t.Fatalf("Expected 2 databases, got %d", len(cfg.Databases))
+	}
+	if db := cfg.Databases[0]; db.Host != "pg.internal" || db.Port != 5432 || db.Database != "orders" {
+		t.Errorf("Unexpected first database: %+v", db)
+	}
+	if db := cfg.Databases[1]; db.EngineType() != config.EngineTypeSQLite || db.Path != "/var/lib/cache.db" || db.Port != 0 {
+		t.Errorf("Unexpected second database: %+v", db)
+	}
+	if cfg.Backup.RetentionDays != 9 || cfg.Backup.BackupPrefix != "postgres-backup" {
+		t.Errorf("Expected overrides on top of defaults, got %+v", cfg.Backup)
+	}
+}
+
+// TestLoadEnvConfigWithoutDatabases tests that an env-only configuration needs a database
+func TestLoadEnvConfigWithoutDatabases(t *testing.T) {
+	for _, key := range []string{"DB_TYPE", "DB_HOST", "DB_PATH", "DB_DATABASE", "DB_0_TYPE", "DB_0_HOST", "DB_0_PATH", "DB_0_DATABASE"} {
+		t.Setenv(key, "")
+	}
+	t.Setenv("LOCAL_BACKUP_PATH", "/tmp/backups")
+	t.Setenv("AWS_BUCKET", "")
+
+	if _, err := config.LoadEnvConfig(config.Config{}); err == nil {
+		t.Error("Expected an error when no database variables are set")
+	}
+}
+
+// TestIndexedDatabasesExtendFile tests that DB_N_* variables past the file's databases add databases
+func TestIndexedDatabasesExtendFile(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "appsettings.json")
+	content := `{
+		"databases": [
+			{"host": "localhost", "port": 5432, "username": "user", "password": "pass", "database": "orders"}
+		],
+		"local": {"path": "/tmp/backups"}
+	}`
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	t.Setenv("DB_1_HOST", "reporting.internal")
+	t.Setenv("DB_1_USERNAME", "report")
+	t.Setenv("DB_1_PASSWORD", "secret")
+	t.Setenv("DB_1_DATABASE", "reporting")
+	t.Setenv("DB_2_HOST", "")
+
+	cfg, err := config.LoadConfig(configFile, config.LoadOptions{})
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(cfg.Databases) != 2 || cfg.Databases[1].Database != "reporting" || cfg.Databases[1].Port != 5432 {
+		t.Errorf("Expected the reporting database to be added from the environment, got %+v", cfg.Databases)
+	}
+}
+
+// TestLoadConfigEnvOnly tests running without a configuration file
+func TestLoadConfigEnvOnly(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("DB_HOST", "")
+	t.Setenv("DB_0_HOST", "pg.internal")
+	t.Setenv("DB_0_USERNAME", "backup")
+	t.Setenv("DB_0_PASSWORD", "")
+	t.Setenv("DB_0_DATABASE", "orders")
+	t.Setenv("DB_1_HOST", "")
+	t.Setenv("LOCAL_BACKUP_PATH", "")
+	t.Setenv("AWS_BUCKET", "")
+
+	// Every missing variable is reported at once
+	_, err := config.LoadConfig("", config.LoadOptions{})
+	if err == nil {
+		t.Fatal("Expected missing variables to be reported")
+	}
+	for _, name := range []string{"DB_0_PASSWORD", "LOCAL_BACKUP_PATH"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Expected error to mention %s, got: %v", name, err)
+		}
+	}
+
+	t.Setenv("DB_0_PASSWORD", "secret")
+	t.Setenv("LOCAL_BACKUP_PATH", "/tmp/backups")
+	cfg, err := config.LoadConfig("", config.LoadOptions{})
+	if err != nil {
+		t.Fatalf("Failed to load env-only config: %v", err)
+	}
+	if cfg.Databases[0].Host != "pg.internal" || cfg.Backup.RetentionDays != 2 {
+		t.Errorf("Expected env settings on top of the defaults, got %+v", cfg)
+	}
+
+	if _, err := config.LoadConfig("", config.LoadOptions{Profile: "prod"}); err == nil {
+		t.Error("Expected a profile without a configuration file to be rejected")
+	}
+}
+
+// TestSecretFiles tests reading credentials from the files named by *_FILE variables
+func TestSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeSecret := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write secret: %v", err)
+		}
+		return path
+	}
+
+	t.Setenv("DB_HOST", "")
+	t.Setenv("DB_PASSWORD", "")
+	t.Setenv("DB_0_HOST", "pg.internal")
+	t.Setenv("DB_0_USERNAME", "backup")
+	t.Setenv("DB_0_PASSWORD", "")
+	t.Setenv("DB_0_PASSWORD_FILE", writeSecret("db_password", "s3cret\n"))
+	t.Setenv("DB_0_DATABASE", "orders")
+	t.Setenv("DB_1_HOST", "")
+	t.Setenv("LOCAL_BACKUP_PATH", "/tmp/backups")
+	t.Setenv("AWS_BUCKET", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY_FILE", writeSecret("aws_secret", "abc/def\r\n"))
+	t.Setenv("AWS_SSE_PREVIOUS_KEYS", "")
+	oldKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	t.Setenv("AWS_SSE_PREVIOUS_KEYS_FILE", writeSecret("sse_keys", oldKey+","+oldKey+"\n"))
+
+	cfg, err := config.LoadEnvConfig(config.EnvDefaults())
+	if err != nil {
+		t.Fatalf("Failed to load env config: %v", err)
+	}
+	if cfg.Databases[0].Password != "s3cret" {
+		t.Errorf("Expected the database password from its file, got %q", cfg.Databases[0].Password)
+	}
+	if cfg.AWS.SecretAccessKey != "abc/def" {
+		t.Errorf("Expected the AWS secret from its file, got %q", cfg.AWS.SecretAccessKey)
+	}
+	if len(cfg.AWS.SSEPreviousKeys) != 2 || cfg.AWS.SSEPreviousKeys[1] != oldKey {
+		t.Errorf("Expected two previous SSE keys from their file, got %v", cfg.AWS.SSEPreviousKeys)
+	}
+
+	// A secret set both ways is ambiguous
+	t.Setenv("DB_0_PASSWORD", "inline")
+	if _, err := config.LoadEnvConfig(config.EnvDefaults()); err == nil || !strings.Contains(err.Error(), "DB_0_PASSWORD_FILE") {
+		t.Errorf("Expected an error for a secret set both inline and as a file, got %v", err)
+	}
+
+	t.Setenv("DB_0_PASSWORD", "")
+	t.Setenv("DB_0_PASSWORD_FILE", filepath.Join(dir, "missing"))
+	if _, err := config.LoadEnvConfig(config.EnvDefaults()); err == nil {
+		t.Error("Expected an error for a missing secret file")
+	}
+}
+
+// TestBackupEnvironment tests that the environment of a configuration loads
+// back into the same backup settings
+func TestBackupEnvironment(t *testing.T) {
+	disabled := false
+	lockID := int64(42)
+	original := &config.Config{
+		Databases: []config.DatabaseConfig{
+			{
+				Host: "pg.internal", Port: 5432, Username: "backup", Password: "secret", Database: "orders", SSLMode: "require",
+				Postgres: config.PostgresConfig{Format: config.PostgresFormatDirectory, DumpJobs: 4},
+				Quiesce:  config.QuiesceConfig{AdvisoryLock: &lockID},
+				SLA:      config.SLAConfig{MaxAgeMinutes: 1500},
+				Storage:  config.StorageConfig{Prefix: "orders-backup"},
+				Priority: 10,
+			},
+			{Type: config.EngineTypeSQLite, Path: "/var/lib/cache.db", Database: "cache", Enabled: &disabled},
+		},
+		AWS: config.AWSConfig{
+			Region: "eu-west-1", Bucket: "company-backups", AccessKeyID: "test-key", SecretAccessKey: "test-secret",
+			SSEPreviousKeys: []string{base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)), base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))},
+			Lifecycle: config.LifecycleConfig{
+				Transitions:    []config.LifecycleTransition{{Days: 30, StorageClass: "GLACIER_IR"}},
+				ExpirationDays: 400,
+			},
+		},
+		Backup:  config.BackupConfig{RetentionDays: 30, Schedule: "0 2 * * *", BackupPrefix: "postgres-backup", MaxRunMinutes: 45},
+		Logging: config.LoggingConfig{Level: "debug", Format: "json"},
+		Metrics: config.MetricsConfig{StatsD: config.StatsDConfig{Address: "127.0.0.1:8125", Tags: []string{"env:prod", "team:data"}}},
+	}
+
+	vars, unsupported := original.BackupEnvironment()
+	if len(unsupported) != 1 || unsupported[0] != "aws.lifecycle.transitions" {
+		t.Errorf("Expected only the lifecycle transitions to be unsupported, got %v", unsupported)
+	}
+	secrets := make(map[string]bool)
+	for _, v := range vars {
+		t.Setenv(v.Name, v.Value)
+		if v.Secret {
+			secrets[v.Name] = true
+		}
+	}
+	if len(secrets) != 3 || !secrets["DB_0_PASSWORD"] || !secrets["AWS_SECRET_ACCESS_KEY"] || !secrets["AWS_SSE_PREVIOUS_KEYS"] {
+		t.Errorf("Unexpected secret variables: %v", secrets)
+	}
+
+	loaded, err := config.LoadEnvConfig(config.EnvDefaults())
+	if err != nil {
+		t.Fatalf("Failed to load env config: %v", err)
+	}
+	original.AWS.Lifecycle.Transitions = nil
+	if !reflect.DeepEqual(loaded.Databases, original.Databases) {
+		t.Errorf("Expected databases %+v, got %+v", original.Databases, loaded.Databases)
+	}
+	for name, pair := range map[string][2]any{
+		"aws":     {original.AWS, loaded.AWS},
+		"backup":  {original.Backup, loaded.Backup},
+		"logging": {original.Logging, loaded.Logging},
+		"metrics": {original.Metrics, loaded.Metrics},
+	} {
+		if !reflect.DeepEqual(pair[0], pair[1]) {
+			t.Errorf("Expected %s settings %+v, got %+v", name, pair[0], pair[1])
+		}
+	}
+}
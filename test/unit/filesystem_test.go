@@ -0,0 +1,79 @@
+package unit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/backup"
+	"db-backuper/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestFilesystemBackup tests archiving a directory with exclude patterns
+func TestFilesystemBackup(t *testing.T) {
+	uploads := filepath.Join(t.TempDir(), "uploads")
+	files := map[string]string{
+		"avatars/1.png":     "png",
+		"docs/readme.txt":   "text",
+		"cache/tmp.bin":     "cache",
+		"docs/debug.log":    "log",
+		"docs/nested/a.txt": "nested",
+	}
+	for name, content := range files {
+		path := filepath.Join(uploads, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	engine, err := backup.NewEngine(&config.DatabaseConfig{
+		Type:     config.EngineTypeFilesystem,
+		Database: "uploads",
+		Path:     uploads,
+		Filesystem: config.FilesystemConfig{
+			Exclude: []string{"cache", "*.log"},
+		},
+	}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	backupPath, err := engine.CreateBackup()
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	defer engine.CleanupBackup(backupPath)
+
+	names := archiveNames(t, backupPath)
+	expected := []string{
+		"uploads/",
+		"uploads/avatars/",
+		"uploads/avatars/1.png",
+		"uploads/docs/",
+		"uploads/docs/nested/",
+		"uploads/docs/nested/a.txt",
+		"uploads/docs/readme.txt",
+	}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected archive entries %v, got %v", expected, names)
+	}
+}
+
+// TestFilesystemBackupMissingDirectory tests that a missing directory fails the connection test
+func TestFilesystemBackupMissingDirectory(t *testing.T) {
+	engine := backup.NewFilesystemBackup(&config.DatabaseConfig{
+		Type:     config.EngineTypeFilesystem,
+		Database: "missing",
+		Path:     filepath.Join(t.TempDir(), "missing"),
+	}, logrus.New())
+
+	if err := engine.TestConnection(); err == nil {
+		t.Error("Expected error for missing directory")
+	}
+}
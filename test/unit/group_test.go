@@ -0,0 +1,132 @@
+package unit
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/backup"
+	"db-backuper/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakePinner is a backup engine recording how its snapshot was pinned
+type fakePinner struct {
+	name     string
+	key      string
+	pinErr   error
+	imported string
+	pinned   bool
+	events   *[]string
+}
+
+func (f *fakePinner) DatabaseName() string                        { return f.name }
+func (f *fakePinner) TestConnection() error                       { return nil }
+func (f *fakePinner) CreateBackup() (string, error)               { return "", nil }
+func (f *fakePinner) CleanupBackup(string) error                  { return nil }
+func (f *fakePinner) WithLogger(logrus.FieldLogger) backup.Engine { return f }
+func (f *fakePinner) SnapshotKey() string                         { return f.key }
+func (f *fakePinner) ReleaseSnapshot() error                      { f.pinned = false; return nil }
+
+func (f *fakePinner) Quiesce() (func(), error) {
+	if f.events == nil {
+		return func() {}, nil
+	}
+	*f.events = append(*f.events, "quiesce "+f.name)
+	return func() { *f.events = append(*f.events, "resume "+f.name) }, nil
+}
+
+func (f *fakePinner) PinSnapshot(importID string) (string, error) {
+	if f.events != nil {
+		*f.events = append(*f.events, "pin "+f.name)
+	}
+	if f.pinErr != nil {
+		return "", f.pinErr
+	}
+	f.imported = importID
+	f.pinned = true
+	return "snap-" + f.name, nil
+}
+
+// TestPinGroups tests that group members share snapshots within a database
+func TestPinGroups(t *testing.T) {
+	orders := &fakePinner{name: "orders", key: "db1/shop"}
+	ordersReplica := &fakePinner{name: "orders-audit", key: "db1/shop"}
+	billing := &fakePinner{name: "billing", key: "db2/billing"}
+	engines := []backup.Engine{orders, ordersReplica, billing}
+
+	groups := []config.GroupConfig{{
+		Name:           "commerce",
+		Databases:      []string{"orders", "orders-audit", "billing"},
+		SharedSnapshot: true,
+	}}
+
+	release, failed := backup.PinGroups(groups, engines, logrus.New())
+	if len(failed) != 0 {
+		t.Fatalf("Unexpected failures: %v", failed)
+	}
+	if orders.imported != "" || billing.imported != "" {
+		t.Errorf("Expected the first member of each database to take its own snapshot")
+	}
+	if ordersReplica.imported != "snap-orders" {
+		t.Errorf("Expected second member of the same database to import snap-orders, got %q", ordersReplica.imported)
+	}
+
+	release()
+	for _, p := range []*fakePinner{orders, ordersReplica, billing} {
+		if p.pinned {
+			t.Errorf("Expected snapshot of %s to be released", p.name)
+		}
+	}
+}
+
+// TestPinGroupsFailure tests that a failed pin fails every member of the group
+func TestPinGroupsFailure(t *testing.T) {
+	orders := &fakePinner{name: "orders", key: "db1/shop"}
+	billing := &fakePinner{name: "billing", key: "db2/billing", pinErr: errors.New("connection refused")}
+	standalone := &fakePinner{name: "standalone", key: "db3/other"}
+
+	groups := []config.GroupConfig{{
+		Name:           "commerce",
+		Databases:      []string{"orders", "billing"},
+		SharedSnapshot: true,
+	}}
+
+	release, failed := backup.PinGroups(groups, []backup.Engine{orders, billing, standalone}, logrus.New())
+	defer release()
+
+	if len(failed) != 2 || failed["orders"] == nil || failed["billing"] == nil {
+		t.Errorf("Expected both group members to fail, got %v", failed)
+	}
+	if orders.pinned {
+		t.Error("Expected the already pinned member to be released")
+	}
+	if standalone.pinned {
+		t.Error("Did not expect databases outside the group to be pinned")
+	}
+}
+
+// TestPinGroupsQuiesce tests that every member is quiesced before any snapshot is taken
+func TestPinGroupsQuiesce(t *testing.T) {
+	var events []string
+	orders := &fakePinner{name: "orders", key: "db1/shop", events: &events}
+	billing := &fakePinner{name: "billing", key: "db2/billing", events: &events}
+
+	groups := []config.GroupConfig{{
+		Name:           "commerce",
+		Databases:      []string{"orders", "billing"},
+		SharedSnapshot: true,
+	}}
+
+	release, failed := backup.PinGroups(groups, []backup.Engine{orders, billing}, logrus.New())
+	defer release()
+	if len(failed) != 0 {
+		t.Fatalf("Unexpected failures: %v", failed)
+	}
+
+	expected := []string{"quiesce orders", "quiesce billing", "pin orders", "pin billing", "resume orders", "resume billing"}
+	if strings.Join(events, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected events %v, got %v", expected, events)
+	}
+}
@@ -0,0 +1,124 @@
+package unit
+
+import (
+	"slices"
+	"testing"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/iampolicy"
+)
+
+// iamPolicyConfig returns a configuration storing one database in its own
+// bucket, with a status object, an audit prefix, a KMS key and metrics
+func iamPolicyConfig() *config.Config {
+	return &config.Config{
+		Databases: []config.DatabaseConfig{
+			{Host: "localhost", Username: "backup", Database: "orders", IAMAuth: true},
+			{Host: "localhost", Username: "backup", Database: "invoices", IAMAuth: true, IAMRegion: "us-gov-east-1"},
+			{Host: "localhost", Username: "user", Password: "pass", Database: "users", Storage: config.StorageConfig{Bucket: "archive", Prefix: "users"}},
+		},
+		AWS: config.AWSConfig{
+			Region:            "us-gov-west-1",
+			Bucket:            "company-backups",
+			AccessKeyID:       "test-key",
+			SecretAccessKey:   "test-secret",
+			DefaultEncryption: config.EncryptionKMS,
+			KMSKeyID:          "1234abcd-12ab-34cd-56ef-1234567890ab",
+		},
+		Backup:  config.BackupConfig{BackupPrefix: "postgres-backup"},
+		Status:  config.StatusConfig{S3Key: "status/backups.json"},
+		Audit:   config.AuditConfig{S3Prefix: "audit/"},
+		Metrics: config.MetricsConfig{CloudWatch: config.CloudWatchConfig{Namespace: "DBBackups"}},
+	}
+}
+
+// statementsBySid indexes the statements of a policy
+func statementsBySid(policy *iampolicy.Document) map[string]iampolicy.Statement {
+	statements := make(map[string]iampolicy.Statement)
+	for _, statement := range policy.Statement {
+		statements[statement.Sid] = statement
+	}
+	return statements
+}
+
+// TestIAMPolicy tests the statements and scoping of a generated policy
+func TestIAMPolicy(t *testing.T) {
+	policy, err := iampolicy.Generate(iamPolicyConfig(), iampolicy.Access{})
+	if err != nil {
+		t.Fatalf("Failed to generate policy: %v", err)
+	}
+	statements := statementsBySid(policy)
+
+	list := statements["ListBackups"]
+	if !slices.Equal(list.Resource, []string{"arn:aws-us-gov:s3:::company-backups"}) ||
+		!slices.Equal(list.Condition["StringLike"]["s3:prefix"], []string{"postgres-backup/*", "audit/*"}) {
+		t.Errorf("Unexpected listing of the default bucket: %+v", list)
+	}
+	if archive := statements["ListBackups2"]; !slices.Equal(archive.Resource, []string{"arn:aws-us-gov:s3:::archive"}) {
+		t.Errorf("Expected a listing of the archive bucket, got %+v", archive)
+	}
+	objects := statements["ReadWriteBackups"]
+	if !slices.Equal(objects.Resource, []string{"arn:aws-us-gov:s3:::company-backups/postgres-backup/*", "arn:aws-us-gov:s3:::archive/users/*"}) ||
+		!slices.Contains(objects.Action, "s3:DeleteObject") || !slices.Contains(objects.Action, "s3:PutObjectTagging") {
+		t.Errorf("Unexpected backup object access: %+v", objects)
+	}
+	if status := statements["StatusFile"]; !slices.Equal(status.Resource, []string{"arn:aws-us-gov:s3:::company-backups/status/backups.json"}) {
+		t.Errorf("Unexpected status object access: %+v", status)
+	}
+	if audit := statements["AuditLog"]; !slices.Equal(audit.Resource, []string{"arn:aws-us-gov:s3:::company-backups/audit/*"}) {
+		t.Errorf("Unexpected audit access: %+v", audit)
+	}
+	if kms := statements["UseBackupKey"]; !slices.Equal(kms.Resource, []string{"arn:aws-us-gov:kms:us-gov-west-1:*:key/1234abcd-12ab-34cd-56ef-1234567890ab"}) ||
+		!slices.Equal(kms.Action, []string{"kms:Decrypt", "kms:GenerateDataKey"}) {
+		t.Errorf("Unexpected key access: %+v", kms)
+	}
+	if metrics := statements["PublishMetrics"]; metrics.Condition["StringEquals"]["cloudwatch:namespace"][0] != "DBBackups" {
+		t.Errorf("Expected metrics scoped to the namespace, got %+v", metrics)
+	}
+	if connect := statements["ConnectWithIAM"]; !slices.Equal(connect.Resource, []string{"arn:aws-us-gov:rds-db:us-gov-west-1:*:dbuser:*/backup", "arn:aws-us-gov:rds-db:us-gov-east-1:*:dbuser:*/backup"}) {
+		t.Errorf("Unexpected RDS access: %+v", connect)
+	}
+	if _, ok := statements["SetUpBuckets"]; ok {
+		t.Error("Expected no bucket setup access without -setup")
+	}
+}
+
+// TestIAMPolicyReadOnly tests that a read-only policy cannot write or delete backups
+func TestIAMPolicyReadOnly(t *testing.T) {
+	cfg := iamPolicyConfig()
+	cfg.AWS.KMSKeyID = "alias/backups"
+	policy, err := iampolicy.Generate(cfg, iampolicy.Access{ReadOnly: true, Setup: true})
+	if err != nil {
+		t.Fatalf("Failed to generate policy: %v", err)
+	}
+	statements := statementsBySid(policy)
+
+	if _, ok := statements["ReadWriteBackups"]; ok {
+		t.Error("Expected no write access to backups")
+	}
+	if read := statements["ReadBackups"]; !slices.Equal(read.Action, []string{"s3:GetObject"}) {
+		t.Errorf("Expected read access to backups, got %+v", read)
+	}
+	if status := statements["StatusFile"]; !slices.Equal(status.Action, []string{"s3:GetObject"}) {
+		t.Errorf("Expected the status object to be read-only, got %+v", status)
+	}
+	kms := statements["UseBackupKey"]
+	if !slices.Equal(kms.Action, []string{"kms:Decrypt"}) || !slices.Equal(kms.Resource, []string{"*"}) ||
+		kms.Condition["ForAnyValue:StringEquals"]["kms:ResourceAliases"][0] != "alias/backups" {
+		t.Errorf("Expected decryption with the aliased key only, got %+v", kms)
+	}
+	for _, sid := range []string{"PublishMetrics", "ConnectWithIAM"} {
+		if _, ok := statements[sid]; ok {
+			t.Errorf("Expected no %s statement in a read-only policy", sid)
+		}
+	}
+	if setup := statements["SetUpBuckets"]; len(setup.Resource) != 2 || !slices.Contains(setup.Action, "s3:PutLifecycleConfiguration") {
+		t.Errorf("Expected bucket setup access to both buckets, got %+v", setup)
+	}
+
+	cfg.AWS = config.AWSConfig{}
+	cfg.Local.Path = "/tmp/backups"
+	if _, err := iampolicy.Generate(cfg, iampolicy.Access{}); err == nil {
+		t.Error("Expected local storage to be rejected")
+	}
+}
@@ -0,0 +1,158 @@
+package unit
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/infra"
+)
+
+// TestScheduleExpression tests converting backup schedules into EventBridge expressions
+func TestScheduleExpression(t *testing.T) {
+	tests := []struct {
+		schedule string
+		expected string
+	}{
+		{"0 2 * * *", "cron(0 2 * * ? *)"},
+		{"0 */3 * * *", "cron(0 0/3 * * ? *)"},
+		{"30 1 1 * *", "cron(30 1 1 * ? *)"},
+		{"0 4 * * 0", "cron(0 4 ? * 1 *)"},
+		{"0 4 * * 1-5", "cron(0 4 ? * 2-6 *)"},
+		{"0 4 * * 0,6", "cron(0 4 ? * 1,7 *)"},
+		{"0 4 * * MON,WED", "cron(0 4 ? * MON,WED *)"},
+		{"@daily", "cron(0 0 * * ? *)"},
+		{"@every 6h", "rate(6 hours)"},
+		{"@every 1h", "rate(1 hour)"},
+		{"@every 90m", "rate(90 minutes)"},
+	}
+	for _, tt := range tests {
+		expression, err := infra.ScheduleExpression(tt.schedule)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.schedule, err)
+		} else if expression != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.schedule, tt.expected, expression)
+		}
+	}
+
+	for _, schedule := range []string{"0 4 1 * 1", "0 4 * * */2", "@every 90s", "CRON_TZ=Europe/Paris 0 2 * * *", "not a schedule"} {
+		if expression, err := infra.ScheduleExpression(schedule); err == nil {
+			t.Errorf("%q: expected an error, got %s", schedule, expression)
+		}
+	}
+}
+
+// infraConfig returns a configuration with a database password and static AWS keys
+func infraConfig() *config.Config {
+	return &config.Config{
+		Databases: []config.DatabaseConfig{
+			{Host: "localhost", Port: 5432, Username: "user", Password: `pa"ss${x}`, Database: "testdb"},
+		},
+		AWS: config.AWSConfig{
+			Region:          "us-east-1",
+			Bucket:          "test-bucket",
+			AccessKeyID:     "test-key",
+			SecretAccessKey: "test-secret",
+		},
+		Backup: config.BackupConfig{RetentionDays: 7, Schedule: "0 2 * * *", BackupPrefix: "test-backup"},
+	}
+}
+
+// TestGenerateInfraTerraform tests that the Terraform module passes the
+// configuration without credentials in plain text
+func TestGenerateInfraTerraform(t *testing.T) {
+	stack, err := infra.New(infraConfig(), infra.Options{Layers: []string{"arn:aws:lambda:us-east-1:123456789012:layer:postgres:3"}})
+	if err != nil {
+		t.Fatalf("Failed to generate stack: %v", err)
+	}
+	module, err := stack.Terraform()
+	if err != nil {
+		t.Fatalf("Failed to render Terraform: %v", err)
+	}
+
+	// Compare without the alignment of the assignments
+	compact := strings.Join(strings.Fields(module), " ")
+	for _, expected := range []string{
+		`variable "db_0_password" {`,
+		`"DB_0_PASSWORD" = var.db_0_password`,
+		`"AWS_BUCKET" = "test-bucket"`,
+		`function_name = "db-backuper"`,
+		`layers = ["arn:aws:lambda:us-east-1:123456789012:layer:postgres:3"]`,
+		`schedule_expression = "cron(0 2 * * ? *)"`,
+		`"arn:aws:s3:::test-bucket/test-backup/*"`,
+	} {
+		if !strings.Contains(compact, expected) {
+			t.Errorf("Expected the module to contain %s", expected)
+		}
+	}
+	for _, leaked := range []string{"test-secret", "test-key", "pa\\\"ss", "AWS_REGION"} {
+		if strings.Contains(module, leaked) {
+			t.Errorf("Expected the module not to contain %s", leaked)
+		}
+	}
+	if len(stack.Warnings) != 1 || !strings.Contains(stack.Warnings[0], "aws.access_key_id") {
+		t.Errorf("Expected a warning about the unused access key, got %v", stack.Warnings)
+	}
+}
+
+// TestGenerateInfraSAM tests the SAM template of a configuration assuming a backup role
+func TestGenerateInfraSAM(t *testing.T) {
+	cfg := infraConfig()
+	cfg.AWS.AccessKeyID, cfg.AWS.SecretAccessKey = "", ""
+	cfg.AWS.RoleARN = "arn:aws:iam::123456789012:role/db-backup-writer"
+	stack, err := infra.New(cfg, infra.Options{FunctionName: "nightly-backups"})
+	if err != nil {
+		t.Fatalf("Failed to generate stack: %v", err)
+	}
+	data, err := stack.SAM()
+	if err != nil {
+		t.Fatalf("Failed to render SAM template: %v", err)
+	}
+
+	var template struct {
+		Parameters map[string]struct{ NoEcho bool }
+		Resources  map[string]struct {
+			Type       string
+			Properties struct {
+				FunctionName string
+				Environment  struct{ Variables map[string]any }
+				Policies     []struct{ Statement []struct{ Sid string } }
+				Events       map[string]struct{ Properties struct{ Schedule string } }
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &template); err != nil {
+		t.Fatalf("Failed to parse SAM template: %v", err)
+	}
+	if !template.Parameters["Db0Password"].NoEcho {
+		t.Errorf("Expected the password as a NoEcho parameter, got %+v", template.Parameters)
+	}
+	function := template.Resources["BackupFunction"]
+	if function.Type != "AWS::Serverless::Function" || function.Properties.FunctionName != "nightly-backups" {
+		t.Errorf("Unexpected function: %+v", function)
+	}
+	variables := function.Properties.Environment.Variables
+	if variables["AWS_ROLE_ARN"] != cfg.AWS.RoleARN || variables["BACKUP_PREFIX"] != "test-backup" {
+		t.Errorf("Unexpected environment: %v", variables)
+	}
+	if ref, ok := variables["DB_0_PASSWORD"].(map[string]any); !ok || ref["Ref"] != "Db0Password" {
+		t.Errorf("Expected the password to reference its parameter, got %v", variables["DB_0_PASSWORD"])
+	}
+	if schedule := function.Properties.Events["BackupSchedule"].Properties.Schedule; schedule != "cron(0 2 * * ? *)" {
+		t.Errorf("Unexpected schedule: %s", schedule)
+	}
+	statements := function.Properties.Policies[0].Statement
+	if statements[len(statements)-1].Sid != "AssumeBackupRole" {
+		t.Errorf("Expected the function to assume the backup role, got %+v", statements)
+	}
+	if len(stack.Warnings) != 0 {
+		t.Errorf("Expected no warnings, got %v", stack.Warnings)
+	}
+
+	cfg.AWS = config.AWSConfig{}
+	cfg.Local.Path = "/tmp/backups"
+	if _, err := infra.New(cfg, infra.Options{}); err == nil {
+		t.Error("Expected local storage to be rejected")
+	}
+}
@@ -0,0 +1,528 @@
+package unit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"db-backuper/internal/audit"
+	"db-backuper/internal/config"
+	"db-backuper/internal/jobs"
+	"db-backuper/internal/status"
+	"db-backuper/internal/web"
+
+	"github.com/sirupsen/logrus"
+)
+
+// blockingKind runs jobs until they are released or cancelled, rejecting
+// jobs of databases other than orders, users and events
+type blockingKind struct {
+	mu      sync.Mutex
+	release map[string]chan struct{}
+	// done releases every job, so a failing test never hangs stopping its queue
+	done chan struct{}
+}
+
+// newBlockingKind creates a kind whose jobs all wait to be released
+func newBlockingKind() *blockingKind {
+	return &blockingKind{release: make(map[string]chan struct{}), done: make(chan struct{})}
+}
+
+// releaseAll releases every job, running or not yet started
+func (k *blockingKind) releaseAll() {
+	close(k.done)
+}
+
+// channel returns the channel releasing a job
+func (k *blockingKind) channel(id string) chan struct{} {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.release[id] == nil {
+		k.release[id] = make(chan struct{})
+	}
+	return k.release[id]
+}
+
+// kind returns the kind to register with a queue
+func (k *blockingKind) kind() jobs.Kind {
+	return jobs.Kind{
+		Validate: func(job jobs.Job) error {
+			for _, name := range job.Databases {
+				if !slices.Contains([]string{"orders", "users", "events"}, name) {
+					return fmt.Errorf("unknown database %s", name)
+				}
+			}
+			return nil
+		},
+		Run: func(ctx context.Context, job jobs.Job) (string, error) {
+			select {
+			case <-k.channel(job.ID):
+				return status.ResultSuccess, nil
+			case <-k.done:
+				return status.ResultSuccess, nil
+			case <-ctx.Done():
+				return status.ResultSkipped, ctx.Err()
+			}
+		},
+	}
+}
+
+// waitForState waits until a job reaches a state
+func waitForState(t *testing.T, queue *jobs.Manager, id, state string) jobs.Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, ok := queue.Job(id)
+		if !ok {
+			t.Fatalf("Job %s not found", id)
+		}
+		if job.State == state {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected job %s to be %s, it is %s", id, state, job.State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// submit queues a job, failing the test when it is not queued
+func submit(t *testing.T, queue *jobs.Manager, trigger string, databases ...string) jobs.Job {
+	t.Helper()
+	job, queued, err := queue.Submit(jobs.Request{Kind: jobs.KindBackup, Trigger: trigger, Databases: databases})
+	if err != nil || !queued {
+		t.Fatalf("Expected a %s job of %v to be queued, got %+v (%v)", trigger, databases, job, err)
+	}
+	return job
+}
+
+// TestJobQueue tests deduplication, the concurrency limit, database
+// conflicts and cancellation of queued and running jobs
+func TestJobQueue(t *testing.T) {
+	queue, err := jobs.NewManager(filepath.Join(t.TempDir(), jobs.FileName), 2, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create job queue: %v", err)
+	}
+	kind := newBlockingKind()
+	queue.Handle(jobs.KindBackup, kind.kind())
+	var observed []string
+	var observedMu sync.Mutex
+	queue.Observe(func(job jobs.Job) {
+		observedMu.Lock()
+		defer observedMu.Unlock()
+		observed = append(observed, job.ID+" "+job.State)
+	})
+	queue.Start()
+	defer queue.Stop()
+	defer kind.releaseAll()
+
+	if _, _, err := queue.Submit(jobs.Request{Kind: jobs.KindBackup, Trigger: "api", Databases: []string{"missing"}}); err == nil {
+		t.Error("Expected a job of an unknown database to be rejected")
+	}
+	if _, _, err := queue.Submit(jobs.Request{Kind: "drill", Trigger: "api"}); err == nil {
+		t.Error("Expected a job of an unknown kind to be rejected")
+	}
+
+	orders := submit(t, queue, "api", "orders")
+	waitForState(t, queue, orders.ID, jobs.StateRunning)
+	if job, queued, err := queue.Submit(jobs.Request{Kind: jobs.KindBackup, Trigger: "scheduled", Databases: []string{"orders", "orders"}}); err != nil || queued || job.ID != orders.ID {
+		t.Errorf("Expected the running job of orders to be returned, got %+v queued=%t (%v)", job, queued, err)
+	}
+
+	// A job of other databases runs alongside, one of every database waits
+	users := submit(t, queue, "api", "users")
+	waitForState(t, queue, users.ID, jobs.StateRunning)
+	all := submit(t, queue, "scheduled")
+	// Nor does a later job of a free database overtake it, being over the limit
+	events := submit(t, queue, "api", "events")
+	time.Sleep(50 * time.Millisecond)
+	if job, _ := queue.Job(all.ID); job.State != jobs.StateQueued {
+		t.Errorf("Expected the job of every database to wait, it is %s", job.State)
+	}
+	if job, _ := queue.Job(events.ID); job.State != jobs.StateQueued {
+		t.Errorf("Expected the job over the limit to wait, it is %s", job.State)
+	}
+
+	// A queued job that is cancelled never starts
+	if _, err := queue.Cancel(all.ID); err != nil {
+		t.Fatalf("Failed to cancel queued job: %v", err)
+	}
+	if job := waitForState(t, queue, all.ID, jobs.StateCancelled); job.StartedAt != nil {
+		t.Error("Expected the cancelled job never to start")
+	}
+	if _, err := queue.Cancel(all.ID); err == nil {
+		t.Error("Expected cancelling a cancelled job to fail")
+	}
+	if _, err := queue.Cancel("unknown"); err != jobs.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an unknown job, got %v", err)
+	}
+
+	// Finishing a job makes room for the next one
+	close(kind.channel(orders.ID))
+	if job := waitForState(t, queue, orders.ID, jobs.StateCompleted); job.Result != status.ResultSuccess || job.FinishedAt == nil {
+		t.Errorf("Unexpected completed job %+v", job)
+	}
+	waitForState(t, queue, events.ID, jobs.StateRunning)
+
+	// Cancelling a running job cancels its context
+	if _, err := queue.Cancel(users.ID); err != nil {
+		t.Fatalf("Failed to cancel running job: %v", err)
+	}
+	if job := waitForState(t, queue, users.ID, jobs.StateCancelled); job.Error == "" {
+		t.Errorf("Expected the cancelled job to record why it stopped, got %+v", job)
+	}
+	close(kind.channel(events.ID))
+	waitForState(t, queue, events.ID, jobs.StateCompleted)
+
+	// A job sharing a database with a running one waits despite a free
+	// slot, while a later job of other databases starts
+	first := submit(t, queue, "api", "orders")
+	waitForState(t, queue, first.ID, jobs.StateRunning)
+	overlapping := submit(t, queue, "api", "orders", "users")
+	other := submit(t, queue, "api", "events")
+	waitForState(t, queue, other.ID, jobs.StateRunning)
+	if job, _ := queue.Job(overlapping.ID); job.State != jobs.StateQueued {
+		t.Errorf("Expected the overlapping job to wait, it is %s", job.State)
+	}
+	close(kind.channel(other.ID))
+	close(kind.channel(first.ID))
+	waitForState(t, queue, overlapping.ID, jobs.StateRunning)
+	close(kind.channel(overlapping.ID))
+	waitForState(t, queue, overlapping.ID, jobs.StateCompleted)
+
+	list := queue.Jobs()
+	if len(list) != 7 || list[0].ID != other.ID || list[6].ID != orders.ID {
+		t.Errorf("Expected the jobs most recently queued first, got %+v", list)
+	}
+	observedMu.Lock()
+	defer observedMu.Unlock()
+	for _, want := range []string{orders.ID + " queued", orders.ID + " running", orders.ID + " completed", all.ID + " cancelled"} {
+		if !slices.Contains(observed, want) {
+			t.Errorf("Expected the observer to see %q, got %v", want, observed)
+		}
+	}
+}
+
+// TestJobQueuePersistence tests that queued jobs survive a restart and jobs
+// running when the process stopped are marked interrupted
+func TestJobQueuePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), jobs.FileName)
+	queue, err := jobs.NewManager(path, 1, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create job queue: %v", err)
+	}
+	crashed := newBlockingKind()
+	queue.Handle(jobs.KindBackup, crashed.kind())
+	queue.Start()
+	defer queue.Stop()
+	defer crashed.releaseAll()
+	running := submit(t, queue, "scheduled", "orders")
+	waitForState(t, queue, running.ID, jobs.StateRunning)
+	queued := submit(t, queue, "api", "users")
+
+	// Reopen the queue file while the first queue still runs its job, as
+	// after a crash
+	reopened, err := jobs.NewManager(path, 1, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to reopen job queue: %v", err)
+	}
+	if job, ok := reopened.Job(running.ID); !ok || job.State != jobs.StateInterrupted || job.FinishedAt == nil {
+		t.Errorf("Expected the running job to be interrupted, got %+v", job)
+	}
+	if job, ok := reopened.Job(queued.ID); !ok || job.State != jobs.StateQueued || job.Trigger != "api" || !slices.Equal(job.Databases, []string{"users"}) {
+		t.Errorf("Expected the queued job to be kept, got %+v", job)
+	}
+
+	// The queued job runs once the reopened queue starts
+	kind := newBlockingKind()
+	reopened.Handle(jobs.KindBackup, kind.kind())
+	reopened.Start()
+	defer reopened.Stop()
+	defer kind.releaseAll()
+	waitForState(t, reopened, queued.ID, jobs.StateRunning)
+	close(kind.channel(queued.ID))
+	waitForState(t, reopened, queued.ID, jobs.StateCompleted)
+}
+
+// TestJobQueueEndpoints tests starting, inspecting and cancelling jobs over HTTP
+func TestJobQueueEndpoints(t *testing.T) {
+	queue, err := jobs.NewManager(filepath.Join(t.TempDir(), jobs.FileName), 1, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create job queue: %v", err)
+	}
+	kind := newBlockingKind()
+	queue.Handle(jobs.KindBackup, kind.kind())
+	queue.Start()
+	defer queue.Stop()
+	defer kind.releaseAll()
+	server := web.NewServer(":0", nil, logrus.New())
+	server.SetJobQueue(queue, config.APIConfig{})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	post := func(path, body string) (*http.Response, jobs.Job) {
+		t.Helper()
+		resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		var job jobs.Job
+		json.NewDecoder(resp.Body).Decode(&job)
+		return resp, job
+	}
+
+	resp, job := post("/jobs", `{"databases":["orders"]}`)
+	if resp.StatusCode != http.StatusAccepted || job.Trigger != web.APITrigger || job.Kind != jobs.KindBackup {
+		t.Fatalf("Expected a queued backup job, got %d %+v", resp.StatusCode, job)
+	}
+	if resp, again := post("/jobs", `{"databases":["orders"]}`); resp.StatusCode != http.StatusOK || again.ID != job.ID {
+		t.Errorf("Expected the queued job to be returned, got %d %+v", resp.StatusCode, again)
+	}
+	if resp, _ := post("/jobs", `{"databases":["missing"]}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown database, got %d", resp.StatusCode)
+	}
+
+	got, err := http.Get(ts.URL + "/jobs/" + job.ID)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	var fetched jobs.Job
+	json.NewDecoder(got.Body).Decode(&fetched)
+	got.Body.Close()
+	if fetched.ID != job.ID {
+		t.Errorf("Expected job %s, got %+v", job.ID, fetched)
+	}
+
+	if resp, _ := post("/jobs/"+job.ID+"/cancel", ""); resp.StatusCode != http.StatusAccepted {
+		t.Errorf("Expected the cancellation to be accepted, got %d", resp.StatusCode)
+	}
+	waitForState(t, queue, job.ID, jobs.StateCancelled)
+	if resp, _ := post("/jobs/"+job.ID+"/cancel", ""); resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected 409 cancelling a finished job, got %d", resp.StatusCode)
+	}
+	if resp, _ := post("/jobs/unknown/cancel", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown job, got %d", resp.StatusCode)
+	}
+
+	list, err := http.Get(ts.URL + "/jobs")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer list.Body.Close()
+	var listed []jobs.Job
+	if err := json.NewDecoder(list.Body).Decode(&listed); err != nil || len(listed) != 1 || listed[0].State != jobs.StateCancelled {
+		t.Errorf("Expected the cancelled job to be listed, got %+v (%v)", listed, err)
+	}
+}
+
+// TestJobApproval tests that a job pending approval only runs once another
+// user approves it, and survives a restart while it waits
+func TestJobApproval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), jobs.FileName)
+	queue, err := jobs.NewManager(path, 1, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create job queue: %v", err)
+	}
+	kind := newBlockingKind()
+	queue.Handle(jobs.KindRestore, kind.kind())
+	queue.Start()
+	defer queue.Stop()
+	defer kind.releaseAll()
+
+	request := jobs.Request{Kind: jobs.KindRestore, Trigger: "api", Backup: "backups/orders.sql.gz", RequestedBy: "alice", NeedsApproval: true}
+	job, queued, err := queue.Submit(request)
+	if err != nil || !queued || job.State != jobs.StatePendingApproval {
+		t.Fatalf("Expected a job pending approval, got %+v queued=%t (%v)", job, queued, err)
+	}
+	if again, queued, _ := queue.Submit(request); queued || again.ID != job.ID {
+		t.Errorf("Expected the pending job to be returned, got %+v queued=%t", again, queued)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got, _ := queue.Job(job.ID); got.State != jobs.StatePendingApproval || got.StartedAt != nil {
+		t.Errorf("Expected the job to wait for approval, got %+v", got)
+	}
+
+	reopened, err := jobs.NewManager(path, 1, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to reopen job queue: %v", err)
+	}
+	if got, ok := reopened.Job(job.ID); !ok || got.State != jobs.StatePendingApproval || got.RequestedBy != "alice" {
+		t.Errorf("Expected the pending job to be kept, got %+v", got)
+	}
+
+	if _, err := queue.Approve(job.ID, "alice"); err != jobs.ErrSelfApproval {
+		t.Errorf("Expected ErrSelfApproval approving one's own job, got %v", err)
+	}
+	if _, err := queue.Approve("unknown", "bob"); err != jobs.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an unknown job, got %v", err)
+	}
+	if approved, err := queue.Approve(job.ID, "bob"); err != nil || approved.ApprovedBy != "bob" {
+		t.Fatalf("Failed to approve job: %+v (%v)", approved, err)
+	}
+	waitForState(t, queue, job.ID, jobs.StateRunning)
+	if _, err := queue.Approve(job.ID, "carol"); err == nil {
+		t.Error("Expected approving a running job to fail")
+	}
+	close(kind.channel(job.ID))
+	waitForState(t, queue, job.ID, jobs.StateCompleted)
+
+	// A pending job can be cancelled instead
+	request.Backup = "backups/users.sql.gz"
+	rejected, _, err := queue.Submit(request)
+	if err != nil {
+		t.Fatalf("Failed to submit job: %v", err)
+	}
+	if _, err := queue.Cancel(rejected.ID); err != nil {
+		t.Fatalf("Failed to cancel pending job: %v", err)
+	}
+	if got := waitForState(t, queue, rejected.ID, jobs.StateCancelled); got.StartedAt != nil {
+		t.Error("Expected the cancelled job never to start")
+	}
+}
+
+// TestRestoreApprovalEndpoints tests API tokens, approval of restores over
+// HTTP and the audit trail they leave
+func TestRestoreApprovalEndpoints(t *testing.T) {
+	queue, err := jobs.NewManager(filepath.Join(t.TempDir(), jobs.FileName), 1, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create job queue: %v", err)
+	}
+	kind := newBlockingKind()
+	queue.Handle(jobs.KindRestore, kind.kind())
+	queue.Start()
+	defer queue.Stop()
+	defer kind.releaseAll()
+
+	digest := func(token string) string {
+		sum := sha256.Sum256([]byte(token))
+		return hex.EncodeToString(sum[:])
+	}
+	api := config.APIConfig{
+		RestoreApproval: true,
+		Users: []config.APIUser{
+			{Name: "alice", TokenSHA256: digest("alice-token"), Approver: true},
+			{Name: "bob", TokenSHA256: digest("bob-token"), Approver: true},
+			{Name: "ci", TokenSHA256: digest("ci-token")},
+		},
+	}
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	server := web.NewServer(":0", nil, logrus.New())
+	server.SetJobQueue(queue, api)
+	server.SetAuditLog(audit.NewLog(&config.AuditConfig{Path: auditPath}, nil))
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	call := func(method, token, path, body string) (*http.Response, jobs.Job) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		var job jobs.Job
+		json.NewDecoder(resp.Body).Decode(&job)
+		return resp, job
+	}
+	post := func(token, path, body string) (*http.Response, jobs.Job) {
+		t.Helper()
+		return call(http.MethodPost, token, path, body)
+	}
+
+	for _, token := range []string{"", "wrong-token"} {
+		if resp, _ := call(http.MethodGet, token, "/jobs", ""); resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("Expected 401 with token %q, got %d", token, resp.StatusCode)
+		}
+	}
+	if resp, _ := call(http.MethodGet, "ci-token", "/jobs", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected a valid token to list jobs, got %d", resp.StatusCode)
+	}
+
+	resp, job := post("ci-token", "/jobs", `{"kind":"restore","backup":"backups/orders.sql.gz"}`)
+	if resp.StatusCode != http.StatusAccepted || job.State != jobs.StatePendingApproval || job.RequestedBy != "ci" {
+		t.Fatalf("Expected a restore pending approval, got %d %+v", resp.StatusCode, job)
+	}
+	if resp, _ := post("ci-token", "/jobs/"+job.ID+"/approve", ""); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 approving without being an approver, got %d", resp.StatusCode)
+	}
+	if resp, _ := post("alice-token", "/jobs/unknown/approve", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 approving an unknown job, got %d", resp.StatusCode)
+	}
+	resp, approved := post("alice-token", "/jobs/"+job.ID+"/approve", "")
+	if resp.StatusCode != http.StatusAccepted || approved.ApprovedBy != "alice" {
+		t.Fatalf("Expected the approval to be accepted, got %d %+v", resp.StatusCode, approved)
+	}
+	waitForState(t, queue, job.ID, jobs.StateRunning)
+	if resp, _ := post("bob-token", "/jobs/"+job.ID+"/approve", ""); resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected 409 approving a running job, got %d", resp.StatusCode)
+	}
+	close(kind.channel(job.ID))
+	waitForState(t, queue, job.ID, jobs.StateCompleted)
+
+	// An approver's own restore needs another approver
+	_, own := post("bob-token", "/jobs", `{"kind":"restore","backup":"backups/users.sql.gz"}`)
+	if resp, _ := post("bob-token", "/jobs/"+own.ID+"/approve", ""); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 approving one's own restore, got %d", resp.StatusCode)
+	}
+	if resp, _ := post("alice-token", "/jobs/"+own.ID+"/cancel", ""); resp.StatusCode != http.StatusAccepted {
+		t.Errorf("Expected the pending restore to be cancelled, got %d", resp.StatusCode)
+	}
+
+	data, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	var events []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var event audit.Event
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("Invalid audit line %q: %v", line, err)
+		}
+		events = append(events, event.Action+" "+event.Actor)
+	}
+	want := []string{"restore_request ci", "restore_approve alice", "restore_request bob", "restore_cancel alice"}
+	if !slices.Equal(events, want) {
+		t.Errorf("Expected audit events %v, got %v", want, events)
+	}
+}
+
+// TestRestoreWithoutAPIUsers tests that restores are refused when the API
+// has no users to attribute them to
+func TestRestoreWithoutAPIUsers(t *testing.T) {
+	queue, err := jobs.NewManager(filepath.Join(t.TempDir(), jobs.FileName), 1, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create job queue: %v", err)
+	}
+	queue.Handle(jobs.KindRestore, newBlockingKind().kind())
+	server := web.NewServer(":0", nil, logrus.New())
+	server.SetJobQueue(queue, config.APIConfig{RestoreApproval: true})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/jobs", "application/json", strings.NewReader(`{"kind":"restore","backup":"backups/orders.sql.gz"}`))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 for a restore without API users, got %d", resp.StatusCode)
+	}
+	if list := queue.Jobs(); len(list) != 0 {
+		t.Errorf("Expected no job to be queued, got %+v", list)
+	}
+}
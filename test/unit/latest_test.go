@@ -0,0 +1,64 @@
+package unit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestLocalLatestPointer tests maintaining the latest symlink of a database
+func TestLocalLatestPointer(t *testing.T) {
+	root := t.TempDir()
+	localStorage, err := storage.NewLocalStorage(&config.LocalConfig{Path: filepath.Join(root, "backups")}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+
+	save := func(name string) string {
+		file := filepath.Join(root, name)
+		if err := os.WriteFile(file, []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write backup: %v", err)
+		}
+		stored, err := localStorage.SaveBackup(file, "db-backup", "orders", nil)
+		if err != nil {
+			t.Fatalf("Failed to save backup: %v", err)
+		}
+		if err := localStorage.UpdateLatest("db-backup", "orders", stored); err != nil {
+			t.Fatalf("Failed to update latest pointer: %v", err)
+		}
+		return stored
+	}
+
+	save("orders_2024-01-15_02-00-00.sql")
+	newest := save("orders_2024-01-15_14-00-00.sql")
+
+	link := filepath.Join(root, "backups", "db-backup", "orders", storage.LatestLink)
+	content, err := os.ReadFile(link)
+	if err != nil {
+		t.Fatalf("Failed to read through latest link: %v", err)
+	}
+	if string(content) != filepath.Base(newest) {
+		t.Errorf("Expected latest link to point at %s, got content %q", newest, content)
+	}
+
+	target, err := os.Readlink(link)
+	if err != nil {
+		t.Fatalf("Failed to read latest link: %v", err)
+	}
+	if filepath.IsAbs(target) {
+		t.Errorf("Expected a relative link so the backup directory can be moved, got %s", target)
+	}
+
+	keys, err := localStorage.ListKeys("db-backup")
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("Expected the latest link to be left out of listings, got %v", keys)
+	}
+}
@@ -0,0 +1,94 @@
+package unit
+
+import (
+	"testing"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/s3"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+)
+
+// TestLifecycleRules tests the managed rules built from the lifecycle configuration
+func TestLifecycleRules(t *testing.T) {
+	lifecycle := config.LifecycleConfig{
+		Transitions: []config.LifecycleTransition{
+			{Days: 30, StorageClass: "STANDARD_IA"},
+			{Days: 90, StorageClass: "GLACIER_IR"},
+		},
+		ExpirationDays: 400,
+		NoncurrentDays: 30,
+	}
+	rules := s3.LifecycleRules([]string{"postgres-backup/orders/", "postgres-backup/users/"}, lifecycle)
+	if len(rules) != 2 {
+		t.Fatalf("Expected a rule per database, got %d", len(rules))
+	}
+	rule := rules[0]
+	if aws.StringValue(rule.ID) != "db-backuper:postgres-backup/orders/" || aws.StringValue(rule.Filter.Prefix) != "postgres-backup/orders/" {
+		t.Errorf("Unexpected rule scope: %s", rule)
+	}
+	if len(rule.Transitions) != 2 || aws.StringValue(rule.Transitions[1].StorageClass) != "GLACIER_IR" || aws.Int64Value(rule.Transitions[1].Days) != 90 {
+		t.Errorf("Unexpected transitions: %s", rule)
+	}
+	if rule.Expiration == nil || aws.Int64Value(rule.Expiration.Days) != 400 {
+		t.Errorf("Expected expiration after 400 days, got %s", rule)
+	}
+	if rule.NoncurrentVersionExpiration == nil || aws.Int64Value(rule.NoncurrentVersionExpiration.NoncurrentDays) != 30 {
+		t.Errorf("Expected noncurrent versions to expire after 30 days, got %s", rule)
+	}
+
+	if rules := s3.LifecycleRules([]string{"postgres-backup/orders/"}, config.LifecycleConfig{}); rules != nil {
+		t.Errorf("Expected no rules without a lifecycle configuration, got %v", rules)
+	}
+}
+
+// TestMergeLifecycleRules tests that managed rules are created, updated and
+// removed while the other rules of the bucket are kept
+func TestMergeLifecycleRules(t *testing.T) {
+	lifecycle := config.LifecycleConfig{Transitions: []config.LifecycleTransition{{Days: 30, StorageClass: "GLACIER_IR"}}}
+	desired := s3.LifecycleRules([]string{"postgres-backup/orders/", "postgres-backup/users/"}, lifecycle)
+	outdated := s3.LifecycleRules([]string{"postgres-backup/orders/"}, config.LifecycleConfig{ExpirationDays: 90})[0]
+	unchanged := s3.LifecycleRules([]string{"postgres-backup/users/"}, lifecycle)[0]
+	removed := s3.LifecycleRules([]string{"postgres-backup/legacy/"}, lifecycle)[0]
+	otherDeployment := s3.LifecycleRules([]string{"staging-backup/orders/"}, lifecycle)[0]
+	logs := &awss3.LifecycleRule{ID: aws.String("expire-logs"), Status: aws.String("Enabled"), Filter: &awss3.LifecycleRuleFilter{Prefix: aws.String("logs/")}}
+
+	merged, changes := s3.MergeLifecycleRules([]*awss3.LifecycleRule{logs, outdated, unchanged, removed, otherDeployment}, desired, []string{"postgres-backup/"})
+
+	actions := make(map[string]string)
+	for _, change := range changes {
+		actions[change.Prefix] = change.Action
+	}
+	expected := map[string]string{
+		"postgres-backup/orders/": s3.LifecycleUpdate,
+		"postgres-backup/users/":  s3.LifecycleUnchanged,
+		"postgres-backup/legacy/": s3.LifecycleDelete,
+	}
+	if len(actions) != len(expected) {
+		t.Errorf("Expected changes %v, got %v", expected, actions)
+	}
+	for prefix, action := range expected {
+		if actions[prefix] != action {
+			t.Errorf("Expected %s to be %s, got %q", prefix, action, actions[prefix])
+		}
+	}
+
+	var ids []string
+	for _, rule := range merged {
+		ids = append(ids, aws.StringValue(rule.ID))
+	}
+	want := []string{"expire-logs", "db-backuper:postgres-backup/orders/", "db-backuper:postgres-backup/users/", "db-backuper:staging-backup/orders/"}
+	if len(ids) != len(want) {
+		t.Fatalf("Expected rules %v, got %v", want, ids)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Errorf("Expected rules %v, got %v", want, ids)
+			break
+		}
+	}
+	if merged[1].Expiration != nil || len(merged[1].Transitions) != 1 {
+		t.Errorf("Expected the outdated rule to be replaced, got %s", merged[1])
+	}
+}
@@ -0,0 +1,177 @@
+package unit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/provenance"
+	"db-backuper/internal/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestParseKey tests splitting backup keys into database and date
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		key      string
+		database string
+		date     string
+		ok       bool
+	}{
+		{"db-backup/orders/2024-01-15/orders_2024-01-15_02-00-00.sql", "orders", "2024-01-15", true},
+		{"db-backup/_catalog/catalog.json", "", "", false},
+		{"db-backup/orders/latest/orders.sql", "", "", false},
+		{"other/orders/2024-01-15/orders_2024-01-15_02-00-00.sql", "", "", false},
+		{"db-backup/orders/2024-01-15/nested/file.sql", "", "", false},
+	}
+
+	for _, tt := range tests {
+		database, date, ok := storage.ParseKey("db-backup", tt.key)
+		if ok != tt.ok {
+			t.Errorf("%s: expected ok=%v, got %v", tt.key, tt.ok, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if database != tt.database || date.Format(storage.DateLayout) != tt.date {
+			t.Errorf("%s: expected %s on %s, got %s on %s", tt.key, tt.database, tt.date, database, date.Format(storage.DateLayout))
+		}
+	}
+}
+
+// TestBackupName tests placing the run ID in the names backups are stored under
+func TestBackupName(t *testing.T) {
+	meta := &provenance.Metadata{RunID: "01HM7Z8X4T2V6C9R3K5N1QWJBE"}
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{"orders_2024-01-15_02-00-00.sql", "orders_2024-01-15_02-00-00_01HM7Z8X4T2V6C9R3K5N1QWJBE.sql"},
+		{"orders_2024-01-15_02-00-00.dir.json", "orders_2024-01-15_02-00-00_01HM7Z8X4T2V6C9R3K5N1QWJBE.dir.json"},
+		{"orders_2024-01-15_02-00-00.sql.parts.json", "orders_2024-01-15_02-00-00_01HM7Z8X4T2V6C9R3K5N1QWJBE.sql.parts.json"},
+		{"assets.tar.gz", "assets_01HM7Z8X4T2V6C9R3K5N1QWJBE.tar.gz"},
+		{"dump", "dump_01HM7Z8X4T2V6C9R3K5N1QWJBE"},
+		{"orders_2024-01-15_02-00-00_01HM7Z8X4T2V6C9R3K5N1QWJBE.sql", "orders_2024-01-15_02-00-00_01HM7Z8X4T2V6C9R3K5N1QWJBE.sql"},
+	}
+	for _, tt := range tests {
+		if got := storage.BackupName(tt.filename, meta); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.filename, tt.want, got)
+		}
+	}
+	if got := storage.BackupName("orders_2024-01-15_02-00-00.sql", nil); got != "orders_2024-01-15_02-00-00.sql" {
+		t.Errorf("Expected a backup without a run to keep its name, got %s", got)
+	}
+}
+
+// TestParseChangeKey tests recognising the change batches stored within a date directory
+func TestParseChangeKey(t *testing.T) {
+	database, date, ok := storage.ParseChangeKey("db-backup", "db-backup/orders/2024-01-15/changes/orders_changes_2024-01-15_02-05-00_0-16B3748.jsonl.gz")
+	if !ok || database != "orders" || date.Format(storage.DateLayout) != "2024-01-15" {
+		t.Errorf("Unexpected change key: %s on %s (%v)", database, date, ok)
+	}
+	if _, _, ok := storage.ParseChangeKey("db-backup", "db-backup/orders/2024-01-15/orders_2024-01-15_02-00-00.sql"); ok {
+		t.Errorf("Expected a backup key not to be a change batch")
+	}
+	if _, _, ok := storage.ParseKey("db-backup", "db-backup/orders/2024-01-15/changes/orders_changes.jsonl.gz"); ok {
+		t.Errorf("Expected change batches not to be listed as backups")
+	}
+
+	capture := config.ChangeCaptureConfig{}
+	if slot := capture.SlotName("Orders-EU"); slot != "db_backuper_orders_eu" {
+		t.Errorf("Unexpected default slot name %s", slot)
+	}
+}
+
+// TestIsReserved tests recognising the service's own objects under the backup prefix
+func TestIsReserved(t *testing.T) {
+	if !storage.IsReserved("db-backup", "db-backup/_catalog/catalog.json") {
+		t.Errorf("Expected the catalog to be reserved")
+	}
+	if storage.IsReserved("db-backup", "db-backup/orders/orders.sql") {
+		t.Errorf("Expected a backup not to be reserved")
+	}
+	if storage.IsReserved("db-backup", "other/_catalog/catalog.json") {
+		t.Errorf("Expected keys outside the prefix not to be reserved")
+	}
+}
+
+// TestLocalListBackups tests filtering and paging through local backups
+func TestLocalListBackups(t *testing.T) {
+	root := t.TempDir()
+	localStorage, err := storage.NewLocalStorage(&config.LocalConfig{Path: root}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+
+	files := []string{
+		"db-backup/orders/2024-01-14/orders_2024-01-14_02-00-00.sql",
+		"db-backup/orders/2024-01-15/orders_2024-01-15_02-00-00.sql",
+		"db-backup/orders/2024-01-16/orders_2024-01-16_02-00-00.sql",
+		"db-backup/users/2024-01-15/users_2024-01-15_02-00-00.sql",
+		"db-backup/_catalog/catalog.json",
+	}
+	for _, file := range files {
+		path := filepath.Join(root, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("backup"), 0644); err != nil {
+			t.Fatalf("Failed to write backup: %v", err)
+		}
+	}
+
+	// Every database, catalog excluded
+	entries, err := storage.AllBackups(localStorage, storage.ListQuery{Prefix: "db-backup"})
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 backups, got %d", len(entries))
+	}
+	if entries[0].Database != "orders" || entries[0].Size != int64(len("backup")) {
+		t.Errorf("Unexpected first entry: %+v", entries[0])
+	}
+
+	// One database within a date range
+	day := func(s string) time.Time {
+		d, _ := time.Parse(storage.DateLayout, s)
+		return d
+	}
+	entries, err = storage.AllBackups(localStorage, storage.ListQuery{
+		Prefix:   "db-backup",
+		Database: "orders",
+		Since:    day("2024-01-15"),
+		Until:    day("2024-01-16"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Key != files[1] || entries[1].Key != files[2] {
+		t.Errorf("Unexpected entries for date range: %+v", entries)
+	}
+
+	// Pages of two
+	query := storage.ListQuery{Prefix: "db-backup", PageSize: 2}
+	page, err := localStorage.ListBackups(query)
+	if err != nil {
+		t.Fatalf("Failed to list first page: %v", err)
+	}
+	if len(page.Entries) != 2 || page.NextPageToken == "" {
+		t.Fatalf("Expected a full first page with a token, got %d entries and token %q", len(page.Entries), page.NextPageToken)
+	}
+	query.PageToken = page.NextPageToken
+	page, err = localStorage.ListBackups(query)
+	if err != nil {
+		t.Fatalf("Failed to list second page: %v", err)
+	}
+	if len(page.Entries) != 2 || page.NextPageToken != "" {
+		t.Errorf("Expected a final page of 2, got %d entries and token %q", len(page.Entries), page.NextPageToken)
+	}
+	if page.Entries[0].Key != files[2] {
+		t.Errorf("Expected second page to start at %s, got %s", files[2], page.Entries[0].Key)
+	}
+}
@@ -0,0 +1,472 @@
+package unit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"db-backuper/internal/backup"
+	"db-backuper/internal/config"
+	"db-backuper/internal/provenance"
+	"db-backuper/internal/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestLocalTransferModes tests moving a finished dump into local storage
+// by copying, hard linking and renaming
+func TestLocalTransferModes(t *testing.T) {
+	for _, mode := range []string{config.LocalTransferCopy, config.LocalTransferLink, config.LocalTransferRename} {
+		t.Run(mode, func(t *testing.T) {
+			dir := t.TempDir()
+			localStorage, err := storage.NewLocalStorage(&config.LocalConfig{
+				Path:     filepath.Join(dir, "backups"),
+				Transfer: mode,
+			}, logrus.New())
+			if err != nil {
+				t.Fatalf("Failed to create local storage: %v", err)
+			}
+
+			dumpFile := filepath.Join(dir, "orders_2024-01-15_02-00-00.sql")
+			if err := os.WriteFile(dumpFile, []byte("backup"), 0644); err != nil {
+				t.Fatalf("Failed to write dump: %v", err)
+			}
+
+			storedPath, err := localStorage.SaveBackup(dumpFile, "db-backup", "orders", nil)
+			if err != nil {
+				t.Fatalf("Failed to save backup: %v", err)
+			}
+			data, err := os.ReadFile(storedPath)
+			if err != nil || string(data) != "backup" {
+				t.Fatalf("Expected stored backup to hold the dump, got %q (%v)", data, err)
+			}
+
+			dumpInfo, dumpErr := os.Stat(dumpFile)
+			storedInfo, _ := os.Stat(storedPath)
+			switch mode {
+			case config.LocalTransferCopy:
+				if dumpErr != nil || os.SameFile(dumpInfo, storedInfo) {
+					t.Errorf("Expected an independent copy of the dump")
+				}
+			case config.LocalTransferLink:
+				if dumpErr != nil || !os.SameFile(dumpInfo, storedInfo) {
+					t.Errorf("Expected the stored backup to be a hard link to the dump")
+				}
+			case config.LocalTransferRename:
+				if !os.IsNotExist(dumpErr) {
+					t.Errorf("Expected the dump to be moved into storage")
+				}
+			}
+		})
+	}
+}
+
+// TestLocalAtomicWrite tests that backups are written under a temporary name
+// and that files left half-written by a crash are not listed as backups
+func TestLocalAtomicWrite(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "backups")
+	localStorage, err := storage.NewLocalStorage(&config.LocalConfig{Path: root}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+
+	dumpFile := filepath.Join(dir, "orders_2024-01-15_02-00-00.sql")
+	if err := os.WriteFile(dumpFile, []byte("backup"), 0644); err != nil {
+		t.Fatalf("Failed to write dump: %v", err)
+	}
+	storedPath, err := localStorage.SaveBackup(dumpFile, "db-backup", "orders", nil)
+	if err != nil {
+		t.Fatalf("Failed to save backup: %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(storedPath))
+	if err != nil {
+		t.Fatalf("Failed to read backup directory: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != filepath.Base(storedPath) {
+		t.Errorf("Expected only the backup in its directory, got %v", entries)
+	}
+
+	// A crash mid-copy leaves the hidden temporary file behind
+	leftover := filepath.Join(filepath.Dir(storedPath), ".orders_2024-01-15_03-00-00.sql"+storage.PartialSuffix)
+	if err := os.WriteFile(leftover, []byte("back"), 0644); err != nil {
+		t.Fatalf("Failed to write partial file: %v", err)
+	}
+	keys, err := localStorage.ListKeys("db-backup")
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(keys) != 1 {
+		t.Errorf("Expected the partial file not to be listed, got %v", keys)
+	}
+}
+
+// TestLocalFreeSpaceWatermark tests refusing backups below the free space
+// watermark and pruning the oldest backups to make room
+func TestLocalFreeSpaceWatermark(t *testing.T) {
+	root := t.TempDir()
+	files := []string{
+		"db-backup/orders/2024-01-14/orders_2024-01-14_02-00-00.sql",
+		"db-backup/orders/2024-01-15/orders_2024-01-15_02-00-00.sql",
+		"db-backup/orders/2024-01-16/orders_2024-01-16_02-00-00.sql",
+		"db-backup/users/2024-01-14/users_2024-01-14_02-00-00.sql",
+	}
+	for _, file := range files {
+		path := filepath.Join(root, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("backup"), 0644); err != nil {
+			t.Fatalf("Failed to write backup: %v", err)
+		}
+	}
+
+	// No disk has an exabyte free, so the watermark can never be met
+	localConfig := &config.LocalConfig{Path: root, MinFreeMB: 1 << 40}
+	localStorage, err := storage.NewLocalStorage(localConfig, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+
+	if err := localStorage.CheckFreeSpace("db-backup"); err == nil {
+		t.Fatalf("Expected the backup to be refused below the watermark")
+	}
+	keys, err := localStorage.ListKeys("db-backup")
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(keys) != len(files) {
+		t.Errorf("Expected refusing to keep every backup, got %v", keys)
+	}
+
+	localConfig.LowSpace = config.LowSpacePrune
+	if err := localStorage.CheckFreeSpace("db-backup"); err == nil {
+		t.Errorf("Expected the backup to be refused when pruning cannot free enough space")
+	}
+	keys, err = localStorage.ListKeys("db-backup")
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("Expected only the newest backup of each database to be kept, got %v", keys)
+	}
+	for _, key := range keys {
+		if key != files[2] && key != files[3] {
+			t.Errorf("Unexpected backup kept: %s", key)
+		}
+	}
+
+	localConfig.MinFreeMB = 0
+	if err := localStorage.CheckFreeSpace("db-backup"); err != nil {
+		t.Errorf("Expected no check without a watermark: %v", err)
+	}
+}
+
+// TestLocalNetworkMount tests the lock file, stale lock recovery and file
+// ownership settings for backup directories on network mounts
+func TestLocalNetworkMount(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "backups")
+	localStorage, err := storage.NewLocalStorage(&config.LocalConfig{
+		Path:         root,
+		NetworkMount: true,
+		FileMode:     "0600",
+		FileOwner:    fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+	}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+
+	// A lock left behind by a crashed host is broken once it goes stale
+	lockPath := filepath.Join(root, storage.LockFileName)
+	if err := os.WriteFile(lockPath, []byte("crashed-host 1234"), 0644); err != nil {
+		t.Fatalf("Failed to write lock file: %v", err)
+	}
+	stale := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(lockPath, stale, stale); err != nil {
+		t.Fatalf("Failed to age lock file: %v", err)
+	}
+
+	dumpFile := filepath.Join(dir, "orders_2024-01-15_02-00-00.sql")
+	if err := os.WriteFile(dumpFile, []byte("backup"), 0644); err != nil {
+		t.Fatalf("Failed to write dump: %v", err)
+	}
+	meta := &provenance.Metadata{Database: "orders", SHA256: "abc123"}
+	storedPath, err := localStorage.SaveBackup(dumpFile, "db-backup", "orders", meta)
+	if err != nil {
+		t.Fatalf("Failed to save backup: %v", err)
+	}
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the lock to be released after saving")
+	}
+
+	for _, path := range []string{storedPath, storedPath + provenance.SidecarSuffix} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", path, err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("Expected %s to have mode 0600, got %v", path, info.Mode().Perm())
+		}
+	}
+}
+
+// TestLocalRetentionHolds tests that retention cleanup keeps held backups and
+// their sidecars while deleting the rest of an expired date directory
+func TestLocalRetentionHolds(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "backups")
+	localStorage, err := storage.NewLocalStorage(&config.LocalConfig{Path: root}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+
+	dateDir := filepath.Join(root, "db-backup", "orders", "2020-01-01")
+	if err := os.MkdirAll(dateDir, 0755); err != nil {
+		t.Fatalf("Failed to create date directory: %v", err)
+	}
+	for _, name := range []string{"orders_held.sql", "orders_held.sql" + provenance.SidecarSuffix, "orders_expired.sql"} {
+		if err := os.WriteFile(filepath.Join(dateDir, name), []byte("backup"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	heldKey := "db-backup/orders/2020-01-01/orders_held.sql"
+	hold := storage.Hold{Key: heldKey, Database: "orders", Reason: "legal hold", CreatedAt: time.Now().UTC()}
+	if err := storage.SaveHolds(localStorage, "db-backup", []storage.Hold{hold}); err != nil {
+		t.Fatalf("Failed to save holds: %v", err)
+	}
+	holds, err := storage.LoadHolds(localStorage, "db-backup")
+	if err != nil || len(holds) != 1 || holds[0].Key != heldKey || holds[0].Reason != "legal hold" {
+		t.Fatalf("Expected the saved hold back, got %+v (%v)", holds, err)
+	}
+
+	if _, err := localStorage.DeleteOldBackups("db-backup", 1); err != nil {
+		t.Fatalf("Retention cleanup failed: %v", err)
+	}
+	for _, name := range []string{"orders_held.sql", "orders_held.sql" + provenance.SidecarSuffix} {
+		if _, err := os.Stat(filepath.Join(dateDir, name)); err != nil {
+			t.Errorf("Expected held %s to survive retention cleanup: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dateDir, "orders_expired.sql")); !os.IsNotExist(err) {
+		t.Errorf("Expected the unheld backup to be deleted, got %v", err)
+	}
+
+	// Once released, the backup expires like any other
+	if err := storage.SaveHolds(localStorage, "db-backup", nil); err != nil {
+		t.Fatalf("Failed to release hold: %v", err)
+	}
+	if _, err := localStorage.DeleteOldBackups("db-backup", 1); err != nil {
+		t.Fatalf("Retention cleanup failed: %v", err)
+	}
+	if _, err := os.Stat(dateDir); !os.IsNotExist(err) {
+		t.Errorf("Expected the released backup's directory to be deleted, got %v", err)
+	}
+}
+
+// TestLocalDirectoryBackup tests saving a directory backup file by file,
+// fetching it back in parallel, and deleting its files with its index
+func TestLocalDirectoryBackup(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "backups")
+	localStorage, err := storage.NewLocalStorage(&config.LocalConfig{Path: root}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+	localStorage.SetTransferJobs(2)
+
+	indexPath := filepath.Join(dir, "orders_2024-01-15_02-00-00"+storage.DirectoryIndexSuffix)
+	files := map[string]string{"toc.dat": "toc", "3001.dat.gz": "rows", "3002.dat.gz": "more rows"}
+	for name, content := range files {
+		path := filepath.Join(storage.DirectoryPath(indexPath), name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write dump file: %v", err)
+		}
+	}
+	if err := storage.WriteDirectoryIndex(storage.DirectoryPath(indexPath), indexPath); err != nil {
+		t.Fatalf("Failed to index dump: %v", err)
+	}
+
+	storedPath, err := localStorage.SaveBackup(indexPath, "db-backup", "orders", nil)
+	if err != nil {
+		t.Fatalf("Failed to save backup: %v", err)
+	}
+	key, err := filepath.Rel(root, storedPath)
+	if err != nil {
+		t.Fatalf("Failed to resolve key: %v", err)
+	}
+	key = filepath.ToSlash(key)
+
+	// Only the index is listed; its files belong to it
+	keys, err := localStorage.ListKeys("db-backup")
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != key {
+		t.Errorf("Expected only %s to be listed, got %v", key, keys)
+	}
+	if !storage.IsHeld(map[string]bool{key: true}, storage.DirectoryPath(key)+"/toc.dat") {
+		t.Error("Expected the files of a held directory backup to be held")
+	}
+
+	destPath := filepath.Join(dir, "restore", filepath.Base(key))
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if _, err := storage.Fetch(localStorage, key, destPath, 2); err != nil {
+		t.Fatalf("Failed to fetch backup: %v", err)
+	}
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(storage.DirectoryPath(destPath), name))
+		if err != nil {
+			t.Fatalf("Failed to read fetched file: %v", err)
+		}
+		if string(data) != content {
+			t.Errorf("Expected %s to hold %q, got %q", name, content, data)
+		}
+	}
+
+	// A file changed in storage fails the fetch
+	tampered := filepath.Join(storage.DirectoryPath(storedPath), "toc.dat")
+	if err := os.WriteFile(tampered, []byte("TOC"), 0644); err != nil {
+		t.Fatalf("Failed to tamper with file: %v", err)
+	}
+	if _, err := storage.Fetch(localStorage, key, destPath, 2); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("Expected a checksum mismatch, got %v", err)
+	}
+
+	if _, err := localStorage.DeleteBackups([]string{key}); err != nil {
+		t.Fatalf("Failed to delete backup: %v", err)
+	}
+	if _, err := os.Stat(storage.DirectoryPath(storedPath)); !os.IsNotExist(err) {
+		t.Errorf("Expected the files to be deleted with the index, got %v", err)
+	}
+}
+
+// TestLocalSplitBackup tests splitting a backup into parts, saving them and
+// reassembling the backup when it is fetched
+func TestLocalSplitBackup(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "backups")
+	localStorage, err := storage.NewLocalStorage(&config.LocalConfig{Path: root}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+
+	backupPath := filepath.Join(dir, "orders_2024-01-15_02-00-00.sql.gz")
+	content := strings.Repeat("0123456789", 25)
+	if err := os.WriteFile(backupPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write backup: %v", err)
+	}
+	indexPath, err := backup.Split(backupPath, 100, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to split backup: %v", err)
+	}
+	if !storage.IsPartsIndex(indexPath) {
+		t.Fatalf("Expected a parts index, got %s", indexPath)
+	}
+	index, err := storage.ReadDirectoryIndex(indexPath)
+	if err != nil {
+		t.Fatalf("Failed to read parts index: %v", err)
+	}
+	if len(index.Files) != 3 || index.Files[2].Size != 50 {
+		t.Errorf("Expected parts of 100, 100 and 50 bytes, got %+v", index.Files)
+	}
+	if _, err := os.Stat(backupPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the split backup to be removed, got %v", err)
+	}
+
+	storedPath, err := localStorage.SaveBackup(indexPath, "db-backup", "orders", nil)
+	if err != nil {
+		t.Fatalf("Failed to save backup: %v", err)
+	}
+	key, err := filepath.Rel(root, storedPath)
+	if err != nil {
+		t.Fatalf("Failed to resolve key: %v", err)
+	}
+	key = filepath.ToSlash(key)
+	keys, err := localStorage.ListKeys("db-backup")
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != key {
+		t.Errorf("Expected only %s to be listed, got %v", key, keys)
+	}
+
+	destPath := filepath.Join(dir, "restore.sql.gz")
+	fetched, err := storage.Fetch(localStorage, key, destPath, 2)
+	if err != nil {
+		t.Fatalf("Failed to fetch backup: %v", err)
+	}
+	if fetched != destPath {
+		t.Errorf("Expected the backup to be reassembled at %s, got %s", destPath, fetched)
+	}
+	data, err := os.ReadFile(fetched)
+	if err != nil {
+		t.Fatalf("Failed to read fetched backup: %v", err)
+	}
+	if string(data) != content {
+		t.Errorf("Expected the reassembled backup to match, got %d bytes", len(data))
+	}
+	if _, err := os.Stat(storage.DirectoryPath(destPath + storage.PartsIndexSuffix)); !os.IsNotExist(err) {
+		t.Errorf("Expected the downloaded parts to be removed, got %v", err)
+	}
+
+	// Backups within the part size are kept whole
+	small := filepath.Join(dir, "small.sql.gz")
+	if err := os.WriteFile(small, []byte("small"), 0644); err != nil {
+		t.Fatalf("Failed to write backup: %v", err)
+	}
+	if got, err := backup.Split(small, 100, logrus.New()); err != nil || got != small {
+		t.Errorf("Expected %s to be kept whole, got %s, %v", small, got, err)
+	}
+}
+
+// TestClaimPrefix tests spotting another instance backing up into the same prefix
+func TestClaimPrefix(t *testing.T) {
+	localStorage, err := storage.NewLocalStorage(&config.LocalConfig{Path: t.TempDir()}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+	start := time.Date(2024, 1, 15, 2, 0, 0, 0, time.UTC)
+	claim := func(instance string, hours int) ([]storage.InstanceClaim, bool) {
+		others, first, err := storage.ClaimPrefix(localStorage, "db-backup", storage.InstanceClaim{Instance: instance, LastRun: start.Add(time.Duration(hours) * time.Hour)})
+		if err != nil {
+			t.Fatalf("Failed to claim prefix for %s: %v", instance, err)
+		}
+		return others, first
+	}
+
+	if others, first := claim("primary", 0); !first || len(others) != 0 {
+		t.Errorf("Expected the first instance to find nothing, got %v (first=%v)", others, first)
+	}
+	if others, first := claim("primary", 24); first || len(others) != 0 {
+		t.Errorf("Expected no other instance, got %v (first=%v)", others, first)
+	}
+	// A new instance only learns who wrote before it
+	if others, first := claim("staging", 25); !first || len(others) != 1 || others[0].Instance != "primary" {
+		t.Errorf("Expected the new instance to find primary, got %v (first=%v)", others, first)
+	}
+	// Another instance running since the previous run means a shared prefix
+	if others, first := claim("primary", 48); first || len(others) != 1 || others[0].Instance != "staging" {
+		t.Errorf("Expected primary to spot staging, got %v (first=%v)", others, first)
+	}
+	if others, _ := claim("primary", 72); len(others) != 0 {
+		t.Errorf("Expected staging not to be reported again, got %v", others)
+	}
+
+	keys, err := localStorage.ListBackups(storage.ListQuery{Prefix: "db-backup"})
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(keys.Entries) != 0 {
+		t.Errorf("Expected the instances object not to be listed as a backup, got %v", keys.Entries)
+	}
+}
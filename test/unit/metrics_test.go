@@ -0,0 +1,112 @@
+package unit
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/metrics"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+)
+
+// fakeCloudWatch records the metric data put into it
+type fakeCloudWatch struct {
+	cloudwatchiface.CloudWatchAPI
+	inputs []*cloudwatch.PutMetricDataInput
+}
+
+func (f *fakeCloudWatch) PutMetricData(input *cloudwatch.PutMetricDataInput) (*cloudwatch.PutMetricDataOutput, error) {
+	f.inputs = append(f.inputs, input)
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+// TestCollectMetrics tests the samples recorded for a run
+func TestCollectMetrics(t *testing.T) {
+	summary := testSummary()
+	summary.ObjectsDeleted = 4
+	samples := metrics.Collect(summary)
+
+	// Success, duration and size for orders; no size for the failed users backup
+	if len(samples) != 6 {
+		t.Fatalf("Expected 6 samples, got %d: %+v", len(samples), samples)
+	}
+	want := []metrics.Sample{
+		{Name: metrics.BackupSuccess, Value: 1, Database: "orders"},
+		{Name: metrics.BackupDurationSeconds, Value: 3, Database: "orders"},
+		{Name: metrics.BackupSizeBytes, Value: 2048, Database: "orders"},
+		{Name: metrics.BackupSuccess, Value: 0, Database: "users"},
+		{Name: metrics.BackupDurationSeconds, Value: 0, Database: "users"},
+		{Name: metrics.ObjectsDeleted, Value: 4},
+	}
+	for i, sample := range samples {
+		if sample.Name != want[i].Name || sample.Value != want[i].Value || sample.Database != want[i].Database {
+			t.Errorf("Sample %d: expected %+v, got %+v", i, want[i], sample)
+		}
+	}
+}
+
+// TestCloudWatchSink tests putting samples into a CloudWatch namespace
+func TestCloudWatchSink(t *testing.T) {
+	client := &fakeCloudWatch{}
+	sink := metrics.NewCloudWatchWithClient("DBBackup", client)
+	if err := sink.Publish(metrics.Collect(testSummary())); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	if len(client.inputs) != 1 || aws.StringValue(client.inputs[0].Namespace) != "DBBackup" {
+		t.Fatalf("Expected one PutMetricData call to DBBackup, got %+v", client.inputs)
+	}
+	data := client.inputs[0].MetricData
+	first := data[0]
+	if aws.StringValue(first.MetricName) != metrics.BackupSuccess || aws.StringValue(first.Unit) != metrics.UnitCount ||
+		len(first.Dimensions) != 1 || aws.StringValue(first.Dimensions[0].Value) != "orders" {
+		t.Errorf("Unexpected first datum: %+v", first)
+	}
+	last := data[len(data)-1]
+	if aws.StringValue(last.MetricName) != metrics.ObjectsDeleted || len(last.Dimensions) != 0 || last.Timestamp == nil {
+		t.Errorf("Expected a run-wide ObjectsDeleted datum, got %+v", last)
+	}
+}
+
+// TestStatsDSink tests sending tagged gauges over UDP
+func TestStatsDSink(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	sink := metrics.NewStatsD(&config.StatsDConfig{
+		Address:     listener.LocalAddr().String(),
+		Environment: "prod",
+		Tags:        []string{"team:data"},
+	})
+	if err := sink.Publish(metrics.Collect(testSummary())); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	buf := make([]byte, 2048)
+	listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := listener.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Failed to read metrics: %v", err)
+	}
+	lines := strings.Split(string(buf[:n]), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("Expected 6 metrics in one packet, got %q", lines)
+	}
+	if lines[0] != "db_backup.success:1|g|#database:orders,team:data,env:prod" {
+		t.Errorf("Unexpected first metric %q", lines[0])
+	}
+	if lines[2] != "db_backup.size_bytes:2048|g|#database:orders,team:data,env:prod" {
+		t.Errorf("Unexpected size metric %q", lines[2])
+	}
+	if lines[5] != "db_backup.objects_deleted:0|g|#team:data,env:prod" {
+		t.Errorf("Unexpected run-wide metric %q", lines[5])
+	}
+}
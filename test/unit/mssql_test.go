@@ -0,0 +1,119 @@
+package unit
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/backup"
+	"db-backuper/internal/config"
+)
+
+// TestMSSQLServerPath tests joining backup filenames onto Linux and Windows server directories
+func TestMSSQLServerPath(t *testing.T) {
+	tests := []struct {
+		dir      string
+		expected string
+	}{
+		{dir: "/var/opt/mssql/backup", expected: "/var/opt/mssql/backup/db.bak"},
+		{dir: "/var/opt/mssql/backup/", expected: "/var/opt/mssql/backup/db.bak"},
+		{dir: `D:\Backups`, expected: `D:\Backups\db.bak`},
+		{dir: `D:\Backups\`, expected: `D:\Backups\db.bak`},
+	}
+
+	for _, tt := range tests {
+		mssqlConfig := config.MSSQLConfig{ServerBackupDir: tt.dir}
+		if got := mssqlConfig.ServerPath("db.bak"); got != tt.expected {
+			t.Errorf("ServerPath(%q) = %q, expected %q", tt.dir, got, tt.expected)
+		}
+	}
+}
+
+// TestMSSQLCommand tests that sqlcmd receives the password through the environment
+func TestMSSQLCommand(t *testing.T) {
+	dbConfig := &config.DatabaseConfig{
+		Type:     config.EngineTypeMSSQL,
+		Host:     "sql",
+		Username: "sa",
+		Password: "Sup3rSecret",
+		Database: "orders",
+		MSSQL:    config.MSSQLConfig{TrustServerCertificate: true},
+	}
+
+	cmd := backup.SQLCmd(dbConfig, "SELECT 1")
+	args := strings.Join(cmd.Args, " ")
+	if strings.Contains(args, "Sup3rSecret") {
+		t.Errorf("Password must not be passed on the command line: %s", args)
+	}
+	if !strings.Contains(args, "-S sql,1433") || !strings.Contains(args, "-C") {
+		t.Errorf("Unexpected sqlcmd arguments: %s", args)
+	}
+
+	found := false
+	for _, e := range cmd.Env {
+		if e == "SQLCMDPASSWORD=Sup3rSecret" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected SQLCMDPASSWORD in the command environment")
+	}
+}
+
+// TestMSSQLQuoting tests T-SQL identifier and string quoting
+func TestMSSQLQuoting(t *testing.T) {
+	if got := backup.QuoteMSSQLName("odd]name"); got != "[odd]]name]" {
+		t.Errorf("Unexpected quoted name: %s", got)
+	}
+	if got := backup.QuoteMSSQLString("it's"); got != "N'it''s'" {
+		t.Errorf("Unexpected quoted string: %s", got)
+	}
+}
+
+// TestMSSQLPackageCommand tests that sqlpackage receives the password in a
+// response file readable only by its owner
+func TestMSSQLPackageCommand(t *testing.T) {
+	dbConfig := &config.DatabaseConfig{
+		Type:     config.EngineTypeMSSQL,
+		Host:     "sql",
+		Username: "sa",
+		Password: `Sup3r"Secret\`,
+		Database: "orders",
+	}
+
+	for _, arg := range dbConfig.GetSQLPackageArgs("Source", "orders") {
+		if strings.Contains(arg, "Sup3r") || strings.Contains(arg, "Password") {
+			t.Errorf("Password must not be in the sqlpackage arguments: %s", arg)
+		}
+	}
+
+	cmd, cleanup, err := backup.SQLPackage(dbConfig, "Source", "orders", "/Action:Export", "/TargetFile:orders.bacpac")
+	if err != nil {
+		t.Fatalf("Failed to build sqlpackage command: %v", err)
+	}
+	args := strings.Join(cmd.Args, " ")
+	if strings.Contains(args, "Sup3r") {
+		t.Errorf("Password must not be passed on the command line: %s", args)
+	}
+	if !strings.Contains(args, "/Action:Export /TargetFile:orders.bacpac /SourceServerName:sql,1433") {
+		t.Errorf("Unexpected sqlpackage arguments: %s", args)
+	}
+
+	responseFile := strings.TrimPrefix(cmd.Args[len(cmd.Args)-1], "@")
+	data, err := os.ReadFile(responseFile)
+	if err != nil {
+		t.Fatalf("Failed to read response file: %v", err)
+	}
+	if expected := `"/SourcePassword:Sup3r\"Secret\\"` + "\n"; string(data) != expected {
+		t.Errorf("Expected response file %q, got %q", expected, data)
+	}
+	if info, err := os.Stat(responseFile); err == nil && runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the response file to have mode 0600, got %v", info.Mode().Perm())
+	}
+
+	cleanup()
+	if _, err := os.Stat(responseFile); !os.IsNotExist(err) {
+		t.Errorf("Expected cleanup to remove the response file, got %v", err)
+	}
+}
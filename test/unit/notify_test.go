@@ -0,0 +1,232 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/notify"
+	"db-backuper/internal/status"
+
+	"github.com/sirupsen/logrus"
+)
+
+// testSummary returns a run summary with one successful and one failed database
+func testSummary() *status.RunSummary {
+	summary := &status.RunSummary{RunID: "run-1"}
+	summary.Add(status.DatabaseResult{Database: "orders", Status: status.ResultSuccess, SizeBytes: 2048, DurationSeconds: 3})
+	summary.Add(status.DatabaseResult{Database: "users", Status: status.ResultFailed, Error: "connection refused"})
+	return summary
+}
+
+// TestNotificationTemplates tests rendering default and custom channel templates
+func TestNotificationTemplates(t *testing.T) {
+	notifier, err := notify.NewNotifier(&config.NotificationsConfig{Channels: []config.ChannelConfig{
+		{Type: config.ChannelWebhook, URL: "http://localhost"},
+		{
+			Name:    "incidents",
+			Type:    config.ChannelSlack,
+			URL:     "http://localhost",
+			Subject: `[SEV3] {{.Failed}} backup(s) failed`,
+			Body:    `{{range failed .}}{{upper .Database}}: {{.Error}}{{end}}`,
+		},
+	}}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create notifier: %v", err)
+	}
+
+	msg, err := notifier.Render("webhook", testSummary())
+	if err != nil {
+		t.Fatalf("Failed to render default templates: %v", err)
+	}
+	if msg.Subject != "Backup run run-1: 1 succeeded, 1 failed" {
+		t.Errorf("Unexpected default subject %q", msg.Subject)
+	}
+	if msg.Body != "- orders: success, 2.0 KiB in 3s\n- users: failed (connection refused)" {
+		t.Errorf("Unexpected default body %q", msg.Body)
+	}
+
+	msg, err = notifier.Render("incidents", testSummary())
+	if err != nil {
+		t.Fatalf("Failed to render custom templates: %v", err)
+	}
+	if msg.Subject != "[SEV3] 1 backup(s) failed" || msg.Body != "USERS: connection refused" {
+		t.Errorf("Unexpected custom message %+v", msg)
+	}
+
+	_, err = notify.NewNotifier(&config.NotificationsConfig{Channels: []config.ChannelConfig{
+		{Type: config.ChannelWebhook, URL: "http://localhost", Body: "{{.Missing"},
+	}}, logrus.New())
+	if err == nil {
+		t.Errorf("Expected an invalid template to be rejected")
+	}
+}
+
+// TestNotificationDelivery tests posting channel payloads and skipping failure-only channels
+func TestNotificationDelivery(t *testing.T) {
+	var received []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Failed to decode payload: %v", err)
+		}
+		received = append(received, payload)
+	}))
+	defer server.Close()
+
+	notifier, err := notify.NewNotifier(&config.NotificationsConfig{Channels: []config.ChannelConfig{
+		{Type: config.ChannelSlack, URL: server.URL},
+		{Type: config.ChannelDiscord, URL: server.URL, On: config.NotifyAlways},
+	}}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create notifier: %v", err)
+	}
+
+	// A successful run only reaches the channel notified always
+	success := &status.RunSummary{RunID: "run-2"}
+	success.Add(status.DatabaseResult{Database: "orders", Status: status.ResultSuccess})
+	if err := notifier.Notify(success); err != nil {
+		t.Fatalf("Failed to notify: %v", err)
+	}
+	if len(received) != 1 || received[0]["content"] == nil {
+		t.Fatalf("Expected one Discord message, got %v", received)
+	}
+
+	received = nil
+	if err := notifier.Notify(testSummary()); err != nil {
+		t.Fatalf("Failed to notify: %v", err)
+	}
+	if len(received) != 2 || received[0]["text"] == nil {
+		t.Errorf("Expected Slack and Discord messages, got %v", received)
+	}
+
+	// Databases skipped by the run budget notify the failure channels too
+	received = nil
+	skipped := &status.RunSummary{RunID: "run-3"}
+	skipped.Add(status.DatabaseResult{Database: "orders", Status: status.ResultSuccess})
+	skipped.Add(status.SkippedResult("analytics", "run budget of 1h0m0s exhausted"))
+	if err := notifier.Notify(skipped); err != nil {
+		t.Fatalf("Failed to notify: %v", err)
+	}
+	if len(received) != 2 {
+		t.Errorf("Expected Slack and Discord messages for a run with skipped databases, got %v", received)
+	}
+}
+
+// TestTeamsNotification tests the Adaptive Card posted to Teams channels
+func TestTeamsNotification(t *testing.T) {
+	var card struct {
+		Type        string `json:"type"`
+		Attachments []struct {
+			ContentType string `json:"contentType"`
+			Content     struct {
+				Type string `json:"type"`
+				Body []struct {
+					Type  string `json:"type"`
+					Style string `json:"style"`
+					Facts []struct {
+						Title string `json:"title"`
+						Value string `json:"value"`
+					} `json:"facts"`
+				} `json:"body"`
+			} `json:"content"`
+		} `json:"attachments"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&card); err != nil {
+			t.Errorf("Failed to decode card: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	notifier, err := notify.NewNotifier(&config.NotificationsConfig{Channels: []config.ChannelConfig{
+		{Type: config.ChannelTeams, URL: server.URL},
+	}}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create notifier: %v", err)
+	}
+	if err := notifier.Notify(testSummary()); err != nil {
+		t.Fatalf("Failed to notify: %v", err)
+	}
+
+	if card.Type != "message" || len(card.Attachments) != 1 || card.Attachments[0].ContentType != "application/vnd.microsoft.card.adaptive" {
+		t.Fatalf("Unexpected Teams message: %+v", card)
+	}
+	body := card.Attachments[0].Content.Body
+	if len(body) != 2 || body[0].Style != "attention" || body[1].Type != "FactSet" {
+		t.Fatalf("Expected a failure header and a fact set, got %+v", body)
+	}
+	facts := body[1].Facts
+	if len(facts) != 2 || facts[0].Value != "success, 2.0 KiB in 3s" || facts[1].Value != "failed: connection refused" {
+		t.Errorf("Unexpected database facts: %+v", facts)
+	}
+}
+
+// TestNotificationDeduplication tests suppressing repeated failures and escalating persistent ones
+func TestNotificationDeduplication(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Failed to decode payload: %v", err)
+		}
+		received = append(received, r.URL.Path+" "+payload["body"].(string))
+	}))
+	defer server.Close()
+
+	notificationsConfig := &config.NotificationsConfig{
+		Channels: []config.ChannelConfig{
+			{Name: "team", Type: config.ChannelWebhook, URL: server.URL + "/team"},
+			{Name: "oncall", Type: config.ChannelWebhook, URL: server.URL + "/oncall", On: config.NotifyOnEscalation},
+		},
+		RepeatIntervalMinutes: 60,
+		EscalateAfter:         3,
+		StatePath:             t.TempDir() + "/notifications.json",
+	}
+	notifier, err := notify.NewNotifier(notificationsConfig, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create notifier: %v", err)
+	}
+	notifyRun := func(n *notify.Notifier, summary *status.RunSummary) []string {
+		received = nil
+		if err := n.Notify(summary); err != nil {
+			t.Fatalf("Failed to notify: %v", err)
+		}
+		return received
+	}
+
+	if got := notifyRun(notifier, testSummary()); len(got) != 1 || !strings.HasPrefix(got[0], "/team ") {
+		t.Fatalf("Expected the first failure to reach the team channel, got %v", got)
+	}
+	if got := notifyRun(notifier, testSummary()); len(got) != 0 {
+		t.Fatalf("Expected an identical failure to be suppressed, got %v", got)
+	}
+
+	// The third failure in a row escalates despite the repeat interval
+	got := notifyRun(notifier, testSummary())
+	if len(got) != 2 || !strings.HasPrefix(got[1], "/oncall Escalated after repeated failures: users") {
+		t.Fatalf("Expected the failure to be escalated, got %v", got)
+	}
+	if !strings.HasSuffix(got[0], "1 identical notification(s) suppressed since the last one") {
+		t.Errorf("Expected the suppressed count in %q", got[0])
+	}
+
+	// Suppression survives a restart through the state file
+	restarted, err := notify.NewNotifier(notificationsConfig, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create notifier: %v", err)
+	}
+	if got := notifyRun(restarted, testSummary()); len(got) != 0 {
+		t.Fatalf("Expected the failure to stay suppressed after a restart, got %v", got)
+	}
+
+	// A different failure is sent right away
+	different := testSummary()
+	different.Databases[1].Error = "disk full"
+	if got := notifyRun(restarted, different); len(got) != 2 {
+		t.Errorf("Expected a different failure to reach both channels, got %v", got)
+	}
+}
@@ -0,0 +1,73 @@
+package unit
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"testing"
+
+	"db-backuper/internal/output"
+)
+
+// outputRow is a result of a read command
+type outputRow struct {
+	Database string `json:"database"`
+	Size     int64  `json:"size"`
+}
+
+// TestOutputFormat tests that -output accepts only table, json and yaml
+func TestOutputFormat(t *testing.T) {
+	for _, format := range output.Formats {
+		fs := flag.NewFlagSet("list", flag.ContinueOnError)
+		value := output.Format(output.Table)
+		fs.Var(&value, "output", "Output format")
+		if err := fs.Parse([]string{"-output", format}); err != nil || string(value) != format {
+			t.Errorf("Expected -output %s to be accepted, got %q (%v)", format, value, err)
+		}
+	}
+
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	value := output.Format(output.Table)
+	fs.Var(&value, "output", "Output format")
+	if err := fs.Parse([]string{"-output", "xml"}); err == nil {
+		t.Error("Expected an unknown format to be rejected")
+	}
+	if value != output.Table {
+		t.Errorf("Expected the format to stay table, got %q", value)
+	}
+}
+
+// TestOutputWrite tests that results are written as a table or with their
+// JSON field names in JSON and YAML, and that no results make an empty list
+func TestOutputWrite(t *testing.T) {
+	rows := []outputRow{{Database: "orders", Size: 42}, {Database: "users", Size: 7}}
+	table := func(w io.Writer) {
+		fmt.Fprintln(w, "DATABASE\tSIZE")
+		for _, row := range rows {
+			fmt.Fprintf(w, "%s\t%d\n", row.Database, row.Size)
+		}
+	}
+
+	tests := []struct {
+		format   output.Format
+		results  []outputRow
+		expected string
+	}{
+		{output.Table, rows, "DATABASE  SIZE\norders    42\nusers     7\n"},
+		{output.JSON, rows, "[\n  {\n    \"database\": \"orders\",\n    \"size\": 42\n  },\n  {\n    \"database\": \"users\",\n    \"size\": 7\n  }\n]\n"},
+		{output.YAML, rows, "- database: orders\n  size: 42\n- database: users\n  size: 7\n"},
+		{output.JSON, nil, "[]\n"},
+		{output.YAML, nil, "[]\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := output.WriteList(&buf, tt.format, tt.results, table); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.format, err)
+		}
+		if buf.String() != tt.expected {
+			t.Errorf("%s: expected\n%q\ngot\n%q", tt.format, tt.expected, buf.String())
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package unit
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/control"
+	"db-backuper/internal/pause"
+	"db-backuper/internal/sla"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestPauseFile tests pausing databases, expiry and resuming
+func TestPauseFile(t *testing.T) {
+	pauses := pause.Open(filepath.Join(t.TempDir(), "state", pause.FileName))
+	now := time.Now()
+
+	if _, err := pauses.Pause("orders", now.Add(-time.Minute), ""); err == nil {
+		t.Error("Expected a deadline in the past to be rejected")
+	}
+	if _, err := pauses.Pause("orders", now.Add(2*time.Hour), "schema migration"); err != nil {
+		t.Fatalf("Failed to pause orders: %v", err)
+	}
+	if _, err := pauses.Pause("", now.Add(time.Hour), ""); err != nil {
+		t.Fatalf("Failed to pause every database: %v", err)
+	}
+
+	// Another process sees the pauses through the file
+	reader := pause.Open(pauses.Path())
+	if p, ok := reader.Paused("orders", now); !ok || p.Reason != "schema migration" {
+		t.Errorf("Expected the longer pause of orders, got %+v", p)
+	}
+	if p, ok := reader.Paused("users", now); !ok || !p.Global() {
+		t.Errorf("Expected users to be paused globally, got %+v", p)
+	}
+	if _, ok := reader.Paused("users", now.Add(90*time.Minute)); ok {
+		t.Error("Expected the global pause to expire")
+	}
+	if _, ok := reader.Paused("orders", now.Add(90*time.Minute)); !ok {
+		t.Error("Expected orders to stay paused after the global pause expired")
+	}
+
+	if resumed, err := pauses.Resume("orders"); err != nil || !resumed {
+		t.Fatalf("Failed to resume orders: %v", err)
+	}
+	if resumed, _ := pauses.Resume("orders"); resumed {
+		t.Error("Expected nothing left to resume for orders")
+	}
+	if _, ok := reader.Paused("orders", now.Add(90*time.Minute)); ok {
+		t.Error("Expected orders to be resumed")
+	}
+}
+
+// TestControlSocketPause tests the pause, pauses and resume control commands
+func TestControlSocketPause(t *testing.T) {
+	dir := t.TempDir()
+	pauses := pause.Open(filepath.Join(dir, pause.FileName))
+	server := control.NewSocketServer(filepath.Join(dir, "control.sock"), func(string) bool { return true }, logrus.New())
+	server.SetPauses(pauses)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start control socket: %v", err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("unix", filepath.Join(dir, "control.sock"))
+	if err != nil {
+		t.Fatalf("Failed to connect to control socket: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	send := func(command string) string {
+		fmt.Fprintln(conn, command)
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		return strings.TrimSpace(line)
+	}
+
+	if resp := send("pause 30m orders"); !strings.HasPrefix(resp, "OK orders paused until") {
+		t.Errorf("Unexpected pause response: %s", resp)
+	}
+	if resp := send("pause soon"); resp != "ERROR invalid duration: soon" {
+		t.Errorf("Unexpected response to an invalid duration: %s", resp)
+	}
+	if resp := send("pauses"); !strings.Contains(resp, "orders paused until") {
+		t.Errorf("Unexpected pauses response: %s", resp)
+	}
+	if _, ok := pauses.Paused("orders", time.Now()); !ok {
+		t.Error("Expected orders to be paused")
+	}
+	if resp := send("resume orders"); resp != "OK resumed" {
+		t.Errorf("Unexpected resume response: %s", resp)
+	}
+	if resp := send("pauses"); resp != "OK no pauses" {
+		t.Errorf("Expected no pauses, got %s", resp)
+	}
+}
+
+// TestSLAMonitorSkipsPausedDatabases tests that a pause neither raises nor
+// clears SLA violations
+func TestSLAMonitorSkipsPausedDatabases(t *testing.T) {
+	cfg := &config.Config{Databases: []config.DatabaseConfig{
+		{Database: "orders", SLA: config.SLAConfig{MaxAgeMinutes: 60}},
+	}}
+	pauses := pause.Open(filepath.Join(t.TempDir(), pause.FileName))
+	alerter := &recordingAlerter{}
+	monitor := sla.NewMonitor(cfg, nil, alerter, nil, logrus.New())
+	monitor.SkipPaused(pauses)
+
+	later := time.Now().Add(2 * time.Hour)
+	monitor.Check(later)
+	if _, err := pauses.Pause("orders", later.Add(time.Hour), ""); err != nil {
+		t.Fatalf("Failed to pause orders: %v", err)
+	}
+	if violations := monitor.Check(later); len(violations) != 0 {
+		t.Errorf("Expected paused orders to be skipped, got %v", violations)
+	}
+	if len(alerter.subjects) != 1 {
+		t.Errorf("Expected only the alert raised before the pause, got %v", alerter.subjects)
+	}
+}
@@ -0,0 +1,158 @@
+package unit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/notify"
+	"db-backuper/internal/plugin"
+	"db-backuper/internal/provenance"
+	"db-backuper/internal/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakePlugin implements the plugin protocol on the directory passed as its
+// first argument
+const fakePlugin = `#!/bin/sh
+root="$1"; shift
+[ "$DB_BACKUPER_PLUGIN_PROTOCOL" = 1 ] || { echo "unsupported protocol" >&2; exit 1; }
+case "$1" in
+  test) test -d "$root" ;;
+  list)
+    cd "$root" || exit 1
+    printf '['
+    find . -type f | sed 's|^\./||' | sort | {
+      sep=
+      while read -r key; do
+        case "$key" in "$2"*)
+          printf '%s{"key":"%s","size":%s,"modified":"2024-01-15T02:00:00Z"}' "$sep" "$key" "$(wc -c < "$key" | tr -d ' ')"
+          sep=, ;;
+        esac
+      done
+    }
+    printf ']' ;;
+  put) mkdir -p "$root/$(dirname "$2")" && cp "$3" "$root/$2" ;;
+  get) [ -f "$root/$2" ] || exit 3; cp "$root/$2" "$3" ;;
+  delete) [ -f "$root/$2" ] || exit 3; rm "$root/$2" ;;
+  notify) cat > "$root/notification.json" ;;
+  *) echo "unknown operation $1" >&2; exit 1 ;;
+esac
+`
+
+// writeFakePlugin writes the fake plugin and the directory it stores into
+func writeFakePlugin(t *testing.T) (binary, root string) {
+	dir := t.TempDir()
+	binary = filepath.Join(dir, "db-backuper-storage-fake")
+	if err := os.WriteFile(binary, []byte(fakePlugin), 0755); err != nil {
+		t.Fatalf("Failed to write fake plugin: %v", err)
+	}
+	root = filepath.Join(dir, "store")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatalf("Failed to create plugin storage: %v", err)
+	}
+	return binary, root
+}
+
+// TestPluginStorage tests storing, listing, downloading and deleting backups through a storage plugin
+func TestPluginStorage(t *testing.T) {
+	binary, root := writeFakePlugin(t)
+	pluginStorage := plugin.NewStorage(&config.StoragePluginConfig{Binary: binary, Args: []string{root}}, logrus.New())
+	if err := pluginStorage.TestConnection(); err != nil {
+		t.Fatalf("Connection test failed: %v", err)
+	}
+	if pluginStorage.Location() != "plugin:db-backuper-storage-fake" {
+		t.Errorf("Unexpected location %s", pluginStorage.Location())
+	}
+
+	backupFile := filepath.Join(t.TempDir(), "orders_2024-01-16_02-00-00.sql.gz")
+	if err := os.WriteFile(backupFile, []byte("backup"), 0644); err != nil {
+		t.Fatalf("Failed to write backup: %v", err)
+	}
+	key, err := pluginStorage.SaveBackup(backupFile, "db-backup", "orders", &provenance.Metadata{Database: "orders", SHA256: "abc123"})
+	if err != nil {
+		t.Fatalf("Failed to save backup: %v", err)
+	}
+	if err := pluginStorage.UpdateLatest("db-backup", "orders", key, 6, nil); err != nil {
+		t.Fatalf("Failed to update latest pointer: %v", err)
+	}
+
+	entries, err := storage.AllBackups(pluginStorage, storage.ListQuery{Prefix: "db-backup"})
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Key != key || entries[0].Size != 6 {
+		t.Fatalf("Expected only %s of 6 bytes, got %+v", key, entries)
+	}
+
+	meta, err := pluginStorage.Metadata(key)
+	if err != nil || meta == nil || meta.SHA256 != "abc123" {
+		t.Errorf("Expected the uploaded metadata, got %+v (%v)", meta, err)
+	}
+	if meta, err := pluginStorage.Metadata("db-backup/orders/missing.sql.gz"); err != nil || meta != nil {
+		t.Errorf("Expected no metadata for a missing backup, got %+v (%v)", meta, err)
+	}
+
+	destPath := filepath.Join(t.TempDir(), "download.sql.gz")
+	if err := pluginStorage.Download(key, destPath); err != nil {
+		t.Fatalf("Failed to download backup: %v", err)
+	}
+	if data, err := os.ReadFile(destPath); err != nil || string(data) != "backup" {
+		t.Errorf("Unexpected download %q (%v)", data, err)
+	}
+	if err := pluginStorage.Download("db-backup/orders/missing.sql.gz", destPath+".missing"); err == nil {
+		t.Error("Expected downloading a missing backup to fail")
+	}
+
+	deleted, err := pluginStorage.DeleteBackups([]string{key})
+	if err != nil || len(deleted) != 1 {
+		t.Fatalf("Failed to delete backup: %v (%v)", deleted, err)
+	}
+	keys, err := pluginStorage.ListKeys("db-backup")
+	if err != nil || len(keys) != 0 {
+		t.Errorf("Expected no backups left besides the latest pointer, got %v (%v)", keys, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(key)+provenance.SidecarSuffix)); !os.IsNotExist(err) {
+		t.Errorf("Expected the metadata sidecar to be deleted with the backup, got %v", err)
+	}
+}
+
+// TestPluginNotificationChannel tests delivering notifications through a plugin
+func TestPluginNotificationChannel(t *testing.T) {
+	binary, root := writeFakePlugin(t)
+	notifier, err := notify.NewNotifier(&config.NotificationsConfig{Channels: []config.ChannelConfig{
+		{Name: "pager", Type: config.ChannelPlugin, Binary: binary, Args: []string{root}},
+	}}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create notifier: %v", err)
+	}
+	if err := notifier.Notify(testSummary()); err != nil {
+		t.Fatalf("Failed to notify: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "notification.json"))
+	if err != nil {
+		t.Fatalf("Expected the plugin to receive the notification: %v", err)
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(data, &payload); err != nil {
+		t.Fatalf("Failed to parse notification: %v", err)
+	}
+	if payload["run_id"] != "run-1" || payload["failed"] != true || payload["failures"] != float64(1) {
+		t.Errorf("Unexpected notification %s", data)
+	}
+
+	// A plugin failing is a failed delivery
+	notifier, err = notify.NewNotifier(&config.NotificationsConfig{Channels: []config.ChannelConfig{
+		{Type: config.ChannelPlugin, Binary: binary, Args: []string{filepath.Join(root, "missing")}},
+	}}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create notifier: %v", err)
+	}
+	if err := notifier.Notify(testSummary()); err == nil {
+		t.Error("Expected a failing plugin to fail the notification")
+	}
+}
@@ -0,0 +1,175 @@
+package unit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/backup"
+	"db-backuper/internal/config"
+	"db-backuper/internal/provenance"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakePgDump records its arguments and writes a directory format dump
+const fakePgDump = `#!/bin/sh
+echo "$@ $PGPASSWORD" > "$ARGS_LOG"
+for arg in "$@"; do
+  case "$arg" in
+    --file=*) dir="${arg#--file=}" ;;
+  esac
+done
+mkdir -p "$dir" && echo PGDMP > "$dir/toc.dat" && echo data > "$dir/3001.dat.gz"
+`
+
+// TestPostgresDirectoryBackup tests dumping with parallel pg_dump jobs and archiving the directory
+func TestPostgresDirectoryBackup(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "pg_dump"), []byte(fakePgDump), 0755); err != nil {
+		t.Fatalf("Failed to write fake pg_dump: %v", err)
+	}
+	argsLog := filepath.Join(dir, "args.log")
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("ARGS_LOG", argsLog)
+
+	engine := backup.NewPostgresBackup(&config.DatabaseConfig{
+		Host:     "db.internal",
+		Port:     5432,
+		Username: "backup",
+		Password: "secret",
+		Database: "shop",
+		Postgres: config.PostgresConfig{
+			Format:   config.PostgresFormatDirectory,
+			DumpJobs: 4,
+		},
+	}, logrus.New())
+
+	backupPath, err := engine.CreateBackup()
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	defer engine.CleanupBackup(backupPath)
+
+	if !strings.HasSuffix(backupPath, backup.PostgresDirectorySuffix) {
+		t.Errorf("Expected %s suffix, got %s", backup.PostgresDirectorySuffix, backupPath)
+	}
+	if _, err := os.Stat(strings.TrimSuffix(backupPath, backup.PostgresDirectorySuffix)); !os.IsNotExist(err) {
+		t.Errorf("Expected dump directory to be removed after archiving")
+	}
+
+	names := archiveNames(t, backupPath)
+	expected := []string{"dump/", "dump/3001.dat.gz", "dump/toc.dat"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected archive entries %v, got %v", expected, names)
+	}
+
+	args, err := os.ReadFile(argsLog)
+	if err != nil {
+		t.Fatalf("Failed to read pg_dump arguments: %v", err)
+	}
+	for _, want := range []string{"--format=directory", "--jobs=4", "--dbname=shop", "--no-password", " secret"} {
+		if !strings.Contains(string(args), want) {
+			t.Errorf("Expected pg_dump invocation to contain %q, got %q", want, args)
+		}
+	}
+	if strings.Contains(string(args), "--snapshot") {
+		t.Errorf("Expected no snapshot without a backup group, got %q", args)
+	}
+}
+
+// TestPostgresDirectoryBackupInvalidDump tests that a dump without a pg_dump table of contents fails the backup
+func TestPostgresDirectoryBackupInvalidDump(t *testing.T) {
+	dir := t.TempDir()
+	fake := strings.Replace(fakePgDump, "echo PGDMP >", ": >", 1)
+	if err := os.WriteFile(filepath.Join(dir, "pg_dump"), []byte(fake), 0755); err != nil {
+		t.Fatalf("Failed to write fake pg_dump: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("ARGS_LOG", filepath.Join(dir, "args.log"))
+
+	engine := backup.NewPostgresBackup(&config.DatabaseConfig{
+		Host:     "db.internal",
+		Port:     5432,
+		Username: "backup",
+		Database: "shop",
+		Postgres: config.PostgresConfig{Format: config.PostgresFormatDirectory},
+	}, logrus.New())
+
+	backupPath, err := engine.CreateBackup()
+	defer engine.CleanupBackup(backupPath)
+	if err == nil || !strings.Contains(err.Error(), "not a pg_dump table of contents") {
+		t.Errorf("Expected an empty table of contents to fail the backup, got %v", err)
+	}
+}
+
+// TestVerifySQLDump tests checking SQL backups against their manifest without restoring them
+func TestVerifySQLDump(t *testing.T) {
+	dir := t.TempDir()
+	rows := func(n int64) *int64 { return &n }
+	manifest := []provenance.TableStats{
+		{Name: "public.orders", Rows: rows(2)},
+		{Name: "public.items", Rows: rows(0)},
+	}
+	complete := strings.Join([]string{
+		"-- PostgreSQL database backup created by db-backuper",
+		"CREATE TABLE orders (",
+		"    id integer NOT NULL",
+		");",
+		"CREATE TABLE items (id integer);",
+		"",
+		backup.PostgresDataSection,
+		"INSERT INTO orders (id) VALUES ('1');",
+		"INSERT INTO orders (id) VALUES ('2');",
+		"",
+		backup.PostgresCompletionMarker + "2024-01-15T02:00:00Z",
+		"",
+	}, "\n")
+
+	path := filepath.Join(dir, "orders.sql")
+	os.WriteFile(path, []byte(complete), 0644)
+	verification, err := backup.VerifySQLDump(path, manifest)
+	if err != nil {
+		t.Fatalf("Verification failed: %v", err)
+	}
+	if !verification.OK() || !verification.Complete || verification.Tables != 2 {
+		t.Errorf("Expected a complete dump of 2 tables, got %+v", verification)
+	}
+
+	// A dump cut off in the middle of the data, as by a full disk
+	truncated := filepath.Join(dir, "truncated.sql")
+	os.WriteFile(truncated, []byte(complete[:strings.Index(complete, "INSERT INTO orders (id) VALUES ('2')")]), 0644)
+	verification, err = backup.VerifySQLDump(truncated, append(manifest, provenance.TableStats{Name: "public.audit"}))
+	if err != nil {
+		t.Fatalf("Verification failed: %v", err)
+	}
+	if verification.OK() || verification.Complete || len(verification.Problems) != 3 {
+		t.Errorf("Expected the truncation, missing rows and missing table to be reported, got %+v", verification)
+	}
+
+	// pg_dump scripts carry their data in COPY blocks
+	pgDump := strings.Join([]string{
+		"CREATE TABLE public.orders (",
+		"    id integer",
+		");",
+		"COPY public.orders (id) FROM stdin;",
+		"1",
+		"2",
+		`\.`,
+		"",
+		"--",
+		"-- PostgreSQL database dump complete",
+		"--",
+		"",
+	}, "\n")
+	pgDumpPath := filepath.Join(dir, "pg_dump.sql")
+	os.WriteFile(pgDumpPath, []byte(pgDump), 0644)
+	verification, err = backup.VerifySQLDump(pgDumpPath, manifest[:1])
+	if err != nil {
+		t.Fatalf("Verification failed: %v", err)
+	}
+	if !verification.OK() {
+		t.Errorf("Expected the pg_dump script to pass, got %+v", verification)
+	}
+}
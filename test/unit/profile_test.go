@@ -0,0 +1,147 @@
+package unit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/config"
+)
+
+const profileConfig = `{
+	"databases": [
+		{"host": "localhost", "port": 5432, "username": "dev", "password": "dev", "database": "app"}
+	],
+	"local": {"path": "/tmp/backups"},
+	"backup": {"retention_days": 2, "backup_prefix": "app"},
+	"profiles": {
+		"staging": {
+			"databases": [
+				{"host": "staging-db", "port": 5432, "username": "backup", "password": "secret", "database": "app"}
+			],
+			"backup": {"retention_days": 7}
+		},
+		"prod": {
+			"extends": "staging",
+			"databases": [
+				{"host": "prod-db", "port": 5432, "username": "backup", "password": "secret", "database": "app"}
+			],
+			"backup": {"retention_days": 30}
+		},
+		"loop-a": {"extends": "loop-b"},
+		"loop-b": {"extends": "loop-a"}
+	}
+}`
+
+// writeProfileConfig writes the profile test configuration to a temporary file
+func writeProfileConfig(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "appsettings.json")
+	if err := os.WriteFile(path, []byte(profileConfig), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+// TestConfigProfiles tests that profiles overlay the base configuration and their parents
+func TestConfigProfiles(t *testing.T) {
+	t.Setenv(config.ProfileEnvVar, "")
+	path := writeProfileConfig(t)
+
+	tests := []struct {
+		profile      string
+		expectedHost string
+		expectedDays int
+	}{
+		{profile: "", expectedHost: "localhost", expectedDays: 2},
+		{profile: "staging", expectedHost: "staging-db", expectedDays: 7},
+		{profile: "prod", expectedHost: "prod-db", expectedDays: 30},
+	}
+
+	for _, tt := range tests {
+		t.Run("profile "+tt.profile, func(t *testing.T) {
+			cfg, err := config.LoadConfig(path, config.LoadOptions{Profile: tt.profile})
+			if err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+			if cfg.Databases[0].Host != tt.expectedHost {
+				t.Errorf("Expected host %s, got %s", tt.expectedHost, cfg.Databases[0].Host)
+			}
+			if cfg.Backup.RetentionDays != tt.expectedDays {
+				t.Errorf("Expected retention %d, got %d", tt.expectedDays, cfg.Backup.RetentionDays)
+			}
+			// Keys not set by any profile are inherited from the base
+			if cfg.Backup.BackupPrefix != "app" || cfg.Local.Path != "/tmp/backups" {
+				t.Errorf("Expected base settings to be inherited, got prefix %q and path %q", cfg.Backup.BackupPrefix, cfg.Local.Path)
+			}
+		})
+	}
+}
+
+// TestConfigProfileFromEnv tests selecting a profile with CONFIG_PROFILE
+func TestConfigProfileFromEnv(t *testing.T) {
+	t.Setenv(config.ProfileEnvVar, "staging")
+
+	cfg, err := config.LoadConfig(writeProfileConfig(t), config.LoadOptions{})
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Profile != "staging" || cfg.Databases[0].Host != "staging-db" {
+		t.Errorf("Expected the staging profile, got %q with host %s", cfg.Profile, cfg.Databases[0].Host)
+	}
+}
+
+// TestConfigProfileErrors tests unknown and circular profiles
+func TestConfigProfileErrors(t *testing.T) {
+	t.Setenv(config.ProfileEnvVar, "")
+	path := writeProfileConfig(t)
+
+	for _, profile := range []string{"qa", "loop-a"} {
+		if _, err := config.LoadConfig(path, config.LoadOptions{Profile: profile}); err == nil {
+			t.Errorf("Expected profile %s to be rejected", profile)
+		}
+	}
+}
+
+// TestConfigInclude tests that included files are merged underneath the
+// including file, recursively, and that include cycles are rejected
+func TestConfigInclude(t *testing.T) {
+	t.Setenv(config.ProfileEnvVar, "")
+	dir := t.TempDir()
+	files := map[string]string{
+		"shared/storage.json": `{"local": {"path": "/tmp/shared-backups"}, "backup": {"retention_days": 14}}`,
+		"shared/logging.json": `{"include": ["storage.json"], "logging": {"level": "debug", "format": "json"}}`,
+		"appsettings.json": `{
+			"include": ["shared/logging.json"],
+			"databases": [{"host": "localhost", "port": 5432, "username": "backup", "password": "secret", "database": "app"}],
+			"logging": {"level": "warn"}
+		}`,
+		"loop-a.json": `{"include": ["loop-b.json"]}`,
+		"loop-b.json": `{"include": ["loop-a.json"]}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	cfg, err := config.LoadConfig(filepath.Join(dir, "appsettings.json"), config.LoadOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Local.Path != "/tmp/shared-backups" || cfg.Backup.RetentionDays != 14 {
+		t.Errorf("Expected settings from the nested include, got path %q and retention %d", cfg.Local.Path, cfg.Backup.RetentionDays)
+	}
+	if cfg.Logging.Level != "warn" || cfg.Logging.Format != "json" {
+		t.Errorf("Expected the including file to win field by field, got %+v", cfg.Logging)
+	}
+
+	if _, err := config.LoadConfig(filepath.Join(dir, "loop-a.json"), config.LoadOptions{}); err == nil || !strings.Contains(err.Error(), "includes itself") {
+		t.Errorf("Expected an include cycle to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package unit
+
+import (
+	"strings"
+	"testing"
+
+	"db-backuper/internal/progress"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestFormatBytes tests human readable byte formatting
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		bytes    int64
+		expected string
+	}{
+		{0, "0 B"},
+		{512, "512 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 * 1024 * 1024, "5.0 MiB"},
+		{3 * 1024 * 1024 * 1024, "3.0 GiB"},
+	}
+
+	for _, tt := range tests {
+		if got := progress.FormatBytes(tt.bytes); got != tt.expected {
+			t.Errorf("FormatBytes(%d) = '%s', expected '%s'", tt.bytes, got, tt.expected)
+		}
+	}
+}
+
+// TestStreamLinesTail tests that streamed output keeps only the last lines
+func TestStreamLinesTail(t *testing.T) {
+	output := "line1\nline2\nline3\nline4\n"
+	tail := progress.StreamLines(strings.NewReader(output), logrus.New(), "test", 2)
+
+	if len(tail) != 2 {
+		t.Fatalf("Expected 2 tail lines, got %d", len(tail))
+	}
+	if tail[0] != "line3" || tail[1] != "line4" {
+		t.Errorf("Unexpected tail lines: %v", tail)
+	}
+}
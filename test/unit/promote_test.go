@@ -0,0 +1,179 @@
+package unit
+
+import (
+	"encoding/base64"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/catalog"
+	"db-backuper/internal/config"
+	"db-backuper/internal/promote"
+	"db-backuper/internal/provenance"
+	"db-backuper/internal/s3"
+	"db-backuper/internal/storage"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/sirupsen/logrus"
+)
+
+// TestPromoteKey tests moving a backup key from one backup prefix to another
+func TestPromoteKey(t *testing.T) {
+	tests := []struct {
+		key, srcPrefix, destPrefix string
+		expected                   string
+	}{
+		{"db-backup/orders/2024-01-15/orders.sql", "db-backup", "archive", "archive/orders/2024-01-15/orders.sql"},
+		{"team/a/orders/2024-01-15/orders.sql", "team/a", "archive/team-a", "archive/team-a/orders/2024-01-15/orders.sql"},
+		{"orders/2024-01-15/orders.sql", "", "archive", "archive/orders/2024-01-15/orders.sql"},
+		{"other/orders/2024-01-15/orders.sql", "db-backup", "archive", "archive/other/orders/2024-01-15/orders.sql"},
+	}
+	for _, tt := range tests {
+		if got := promote.Key(tt.key, tt.srcPrefix, tt.destPrefix); got != tt.expected {
+			t.Errorf("Key(%q, %q, %q) = %q, expected %q", tt.key, tt.srcPrefix, tt.destPrefix, got, tt.expected)
+		}
+	}
+}
+
+// TestPromote tests copying a backup from one local storage to another,
+// carrying over its provenance and restore points
+func TestPromote(t *testing.T) {
+	dir := t.TempDir()
+	source, srcKey := storeWithProvenance(t, dir, "orders_2024-01-15_02-00-00.sql", []byte("backup"))
+	if err := catalog.New(source, "db-backup").Tag(catalog.RestorePoint{Name: "release-42", Database: "orders", Key: srcKey, Note: "verified"}, false); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := storage.NewLocalStorage(&config.LocalConfig{Path: filepath.Join(dir, "archive")}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+	from := promote.Target{Backend: source, Prefix: "db-backup"}
+	to := promote.Target{Backend: archive, Prefix: "long-term"}
+
+	destKey, err := promote.Promote(from, to, srcKey, 2)
+	if err != nil {
+		t.Fatalf("Promote failed: %v", err)
+	}
+	if destKey != "long-term/orders/2024-01-15/orders_2024-01-15_02-00-00.sql" {
+		t.Errorf("Unexpected key of the copy: %s", destKey)
+	}
+
+	// The copy keeps the provenance and manifest of the original
+	srcMeta, _ := source.Metadata(srcKey)
+	meta, err := archive.Metadata(destKey)
+	if err != nil || meta == nil || meta.SHA256 != srcMeta.SHA256 || meta.Database != "orders" || len(meta.Tables) != 1 {
+		t.Errorf("Expected the provenance to be copied, got %+v (%v)", meta, err)
+	}
+
+	// The restore point names the copy in the archive's catalog
+	point, err := catalog.New(archive, "long-term").Get("release-42")
+	if err != nil || point.Key != destKey || point.Database != "orders" || point.Note != "verified" {
+		t.Errorf("Expected release-42 to name the copy, got %+v (%v)", point, err)
+	}
+
+	// Promoting again leaves the catalog as it is
+	if _, err := promote.Promote(from, to, srcKey, 2); err != nil {
+		t.Errorf("Expected promoting again to succeed, got %v", err)
+	}
+	if points, _ := catalog.New(archive, "long-term").RestorePoints(); len(points) != 1 {
+		t.Errorf("Expected one restore point, got %+v", points)
+	}
+
+	// A name taken by another backup in the archive is not moved
+	other := promote.Target{Backend: archive, Prefix: "other"}
+	if err := catalog.New(archive, "other").Tag(catalog.RestorePoint{Name: "release-42", Database: "orders", Key: "other/orders/2024-01-01/orders.sql"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := promote.Promote(from, other, srcKey, 2); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Expected the taken name to be reported, got %v", err)
+	}
+
+	// A backup is not copied onto itself
+	if _, err := promote.Promote(from, from, srcKey, 2); err == nil || !strings.Contains(err.Error(), "the same") {
+		t.Errorf("Expected copying onto the source to fail, got %v", err)
+	}
+}
+
+// copyS3 serves the headers of a backup and records server-side copies
+type copyS3 struct {
+	s3iface.S3API
+	meta   *provenance.Metadata
+	copies []*awss3.CopyObjectInput
+}
+
+func (c *copyS3) HeadObject(*awss3.HeadObjectInput) (*awss3.HeadObjectOutput, error) {
+	return &awss3.HeadObjectOutput{ContentLength: aws.Int64(6), Metadata: aws.StringMap(c.meta.Headers())}, nil
+}
+
+func (c *copyS3) GetObject(*awss3.GetObjectInput) (*awss3.GetObjectOutput, error) {
+	return nil, awserr.New(awss3.ErrCodeNoSuchKey, "no manifest", nil)
+}
+
+func (c *copyS3) CopyObject(input *awss3.CopyObjectInput) (*awss3.CopyObjectOutput, error) {
+	c.copies = append(c.copies, input)
+	return &awss3.CopyObjectOutput{}, nil
+}
+
+// TestPromoteCustomerKey tests that S3 copies are decrypted with the source's
+// SSE-C key and encrypted with the destination's, with the provenance
+// rewritten when that changes whether the copy is encrypted
+func TestPromoteCustomerKey(t *testing.T) {
+	keyA := strings.Repeat("a", 32)
+	keyB := strings.Repeat("b", 32)
+
+	tests := []struct {
+		name      string
+		srcKey    string
+		destKey   string
+		directive string
+	}{
+		{name: "encrypt", destKey: keyB, directive: awss3.MetadataDirectiveReplace},
+		{name: "re-encrypt", srcKey: keyA, destKey: keyB, directive: awss3.MetadataDirectiveCopy},
+		{name: "decrypt", srcKey: keyA, directive: awss3.MetadataDirectiveReplace},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := func(bucket, customerKey string, client *copyS3) *s3.S3Manager {
+				awsConfig := &config.AWSConfig{Bucket: bucket}
+				if customerKey != "" {
+					awsConfig.SSECustomerKey = base64.StdEncoding.EncodeToString([]byte(customerKey))
+				}
+				m, err := s3.NewS3ManagerWithClient(awsConfig, client, logrus.New())
+				if err != nil {
+					t.Fatal(err)
+				}
+				return m
+			}
+			srcClient := &copyS3{meta: &provenance.Metadata{Database: "orders", SHA256: "abc", Encrypted: tt.srcKey != ""}}
+			destClient := &copyS3{}
+			source := manager("nightly", tt.srcKey, srcClient)
+			dest := manager("archive", tt.destKey, destClient)
+
+			if err := promote.Copy(source, dest, "db-backup/orders/2024-01-15/orders.sql", "long-term/orders/2024-01-15/orders.sql", 1); err != nil {
+				t.Fatalf("Copy failed: %v", err)
+			}
+			if len(destClient.copies) != 1 {
+				t.Fatalf("Expected one server-side copy, got %d", len(destClient.copies))
+			}
+			input := destClient.copies[0]
+			if aws.StringValue(input.CopySource) != "nightly/db-backup/orders/2024-01-15/orders.sql" || aws.StringValue(input.Key) != "long-term/orders/2024-01-15/orders.sql" {
+				t.Errorf("Unexpected copy from %s to %s", aws.StringValue(input.CopySource), aws.StringValue(input.Key))
+			}
+			if aws.StringValue(input.CopySourceSSECustomerKey) != tt.srcKey || aws.StringValue(input.SSECustomerKey) != tt.destKey {
+				t.Errorf("Expected to decrypt with %q and encrypt with %q, got %q and %q", tt.srcKey, tt.destKey, aws.StringValue(input.CopySourceSSECustomerKey), aws.StringValue(input.SSECustomerKey))
+			}
+			if aws.StringValue(input.MetadataDirective) != tt.directive {
+				t.Errorf("Expected metadata directive %s, got %s", tt.directive, aws.StringValue(input.MetadataDirective))
+			}
+			if tt.directive == awss3.MetadataDirectiveReplace {
+				meta := provenance.FromHeaders(aws.StringValueMap(input.Metadata))
+				if meta == nil || meta.Encrypted != (tt.destKey != "") || meta.SHA256 != "abc" {
+					t.Errorf("Expected the provenance to record the new encryption, got %+v", meta)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package unit
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/provenance"
+	"db-backuper/internal/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestProvenanceHeaders tests round-tripping backup metadata through S3 user metadata
+func TestProvenanceHeaders(t *testing.T) {
+	meta := &provenance.Metadata{
+		SourceHost:    "db.internal",
+		Database:      "orders",
+		Engine:        "postgres",
+		ServerVersion: "16.2",
+		ToolVersion:   "v1.4.0",
+		SHA256:        "abc123",
+		Compression:   provenance.CompressionGzip,
+		Encrypted:     true,
+		CreatedAt:     time.Date(2024, 1, 15, 2, 0, 0, 0, time.UTC),
+		RunID:         "01HM7Z8X4T2V6C9R3K5N1QWJBE",
+		Instance:      "backup-eu-1",
+	}
+
+	// S3 returns user metadata keys in canonical header form
+	headers := map[string]string{}
+	for key, value := range meta.Headers() {
+		headers[canonicalHeader(key)] = value
+	}
+
+	got := provenance.FromHeaders(headers)
+	if got == nil {
+		t.Fatalf("Expected metadata but got none")
+	}
+	if !reflect.DeepEqual(got, meta) {
+		t.Errorf("Expected %+v, got %+v", *meta, *got)
+	}
+
+	if provenance.FromHeaders(map[string]string{"Owner": "team"}) != nil {
+		t.Errorf("Expected no metadata for an object without provenance")
+	}
+}
+
+// canonicalHeader capitalises each dash separated word like S3 does
+func canonicalHeader(key string) string {
+	b := []byte(key)
+	upper := true
+	for i, c := range b {
+		if upper && c >= 'a' && c <= 'z' {
+			b[i] = c - 'a' + 'A'
+		}
+		upper = c == '-'
+	}
+	return string(b)
+}
+
+// TestProvenanceSidecar tests that local backups carry a metadata sidecar
+// which is hidden from listings and deleted with the backup
+func TestProvenanceSidecar(t *testing.T) {
+	dir := t.TempDir()
+	localStorage, err := storage.NewLocalStorage(&config.LocalConfig{Path: filepath.Join(dir, "backups")}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+
+	backupFile := filepath.Join(dir, "orders_2024-01-15_02-00-00.sql.gz")
+	if err := os.WriteFile(backupFile, []byte("backup"), 0644); err != nil {
+		t.Fatalf("Failed to write backup: %v", err)
+	}
+	meta, err := provenance.Describe(backupFile)
+	if err != nil {
+		t.Fatalf("Failed to describe backup: %v", err)
+	}
+	meta.Database = "orders"
+	rows := int64(42)
+	meta.Tables = []provenance.TableStats{{Name: "public.orders", Rows: &rows, EstimatedRows: 40, SizeBytes: 16384}}
+	meta.RunID = "01HM7Z8X4T2V6C9R3K5N1QWJBE"
+
+	if meta.SHA256 != "54d00d867758cef816bc4685f58e327b949712b07ebd17c3485f3ffc9e9f5133" {
+		t.Errorf("Unexpected checksum %q", meta.SHA256)
+	}
+	if meta.Compression != provenance.CompressionGzip {
+		t.Errorf("Expected gzip compression, got %s", meta.Compression)
+	}
+
+	storedPath, err := localStorage.SaveBackup(backupFile, "db-backup", "orders", meta)
+	if err != nil {
+		t.Fatalf("Failed to save backup: %v", err)
+	}
+	if _, err := os.Stat(storedPath + provenance.SidecarSuffix); err != nil {
+		t.Fatalf("Expected metadata sidecar: %v", err)
+	}
+	if !strings.Contains(filepath.Base(storedPath), "_"+meta.RunID) {
+		t.Errorf("Expected the run ID in the stored name, got %s", storedPath)
+	}
+
+	keys, err := localStorage.ListKeys("db-backup")
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("Expected only the backup to be listed, got %v", keys)
+	}
+
+	stored, err := localStorage.Metadata(keys[0])
+	if err != nil {
+		t.Fatalf("Failed to read metadata: %v", err)
+	}
+	if stored == nil || stored.SHA256 != meta.SHA256 || stored.Database != "orders" || stored.RunID != meta.RunID {
+		t.Fatalf("Unexpected stored metadata: %+v", stored)
+	}
+	if !reflect.DeepEqual(stored.Tables, meta.Tables) {
+		t.Errorf("Expected the table manifest to be stored, got %+v", stored.Tables)
+	}
+
+	if _, err := localStorage.DeleteBackups(keys); err != nil {
+		t.Fatalf("Failed to delete backup: %v", err)
+	}
+	if _, err := os.Stat(storedPath + provenance.SidecarSuffix); !os.IsNotExist(err) {
+		t.Errorf("Expected metadata sidecar to be deleted with the backup")
+	}
+}
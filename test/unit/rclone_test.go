@@ -0,0 +1,97 @@
+package unit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/rclone"
+	"db-backuper/internal/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakeRclone logs its arguments and lists a canned remote; every object
+// without a listing is reported missing with rclone's exit code
+const fakeRclone = `#!/bin/sh
+echo "$@" >> "$RCLONE_LOG"
+for arg; do cmd="$arg"; case "$arg" in -*|/*) ;; *) break ;; esac; done
+case "$cmd" in
+  lsjson) cat "$RCLONE_LISTING" ;;
+  copyto|deletefile) exit 0 ;;
+  *) exit 4 ;;
+esac
+`
+
+// fakeListing is lsjson output for the db-backup prefix of the remote
+const fakeListing = `[
+{"Path":"orders/2024-01-15/orders_2024-01-15_02-00-00.sql.gz","Size":6,"ModTime":"2024-01-15T02:00:00Z","IsDir":false},
+{"Path":"orders/2024-01-15/orders_2024-01-15_02-00-00.sql.gz.meta.json","Size":200,"ModTime":"2024-01-15T02:00:00Z","IsDir":false},
+{"Path":"orders/latest.json","Size":100,"ModTime":"2024-01-15T02:00:00Z","IsDir":false},
+{"Path":"_catalog/catalog.json","Size":50,"ModTime":"2024-01-15T02:00:00Z","IsDir":false},
+{"Path":"users/2024-01-14/users_2024-01-14_02-00-00.sql","Size":9,"ModTime":"2024-01-14T02:00:00Z","IsDir":false}
+]`
+
+// TestRcloneRemote tests mapping listings, uploads and metadata onto rclone commands
+func TestRcloneRemote(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "rclone")
+	if err := os.WriteFile(binary, []byte(fakeRclone), 0755); err != nil {
+		t.Fatalf("Failed to write fake rclone: %v", err)
+	}
+	listing := filepath.Join(dir, "listing.json")
+	if err := os.WriteFile(listing, []byte(fakeListing), 0644); err != nil {
+		t.Fatalf("Failed to write listing: %v", err)
+	}
+	logPath := filepath.Join(dir, "rclone.log")
+	t.Setenv("RCLONE_LOG", logPath)
+	t.Setenv("RCLONE_LISTING", listing)
+
+	remote := rclone.NewRemote(&config.RcloneConfig{
+		Remote: "b2:company-backups",
+		Binary: binary,
+		Flags:  []string{"--config", "/etc/rclone.conf"},
+	}, logrus.New())
+
+	entries, err := storage.AllBackups(remote, storage.ListQuery{Prefix: "db-backup"})
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 backups, got %+v", entries)
+	}
+	if entries[0].Key != "db-backup/orders/2024-01-15/orders_2024-01-15_02-00-00.sql.gz" || entries[0].Size != 6 {
+		t.Errorf("Unexpected first entry: %+v", entries[0])
+	}
+
+	meta, err := remote.Metadata(entries[1].Key)
+	if err != nil || meta != nil {
+		t.Errorf("Expected no metadata for a backup without a sidecar, got %+v (%v)", meta, err)
+	}
+
+	backupFile := filepath.Join(dir, "orders_2024-01-16_02-00-00.sql.gz")
+	if err := os.WriteFile(backupFile, []byte("backup"), 0644); err != nil {
+		t.Fatalf("Failed to write backup: %v", err)
+	}
+	key, err := remote.SaveBackup(backupFile, "db-backup", "orders", nil)
+	if err != nil {
+		t.Fatalf("Failed to save backup: %v", err)
+	}
+	if !strings.HasPrefix(key, "db-backup/orders/") || !strings.HasSuffix(key, "/orders_2024-01-16_02-00-00.sql.gz") {
+		t.Errorf("Unexpected key %s", key)
+	}
+
+	log, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read rclone log: %v", err)
+	}
+	expected := "--config /etc/rclone.conf copyto " + backupFile + " b2:company-backups/" + key
+	if !strings.Contains(string(log), expected) {
+		t.Errorf("Expected rclone to be run with %q, got:\n%s", expected, log)
+	}
+	if !strings.Contains(string(log), "lsjson --recursive --files-only b2:company-backups/db-backup") {
+		t.Errorf("Expected a recursive listing of the prefix, got:\n%s", log)
+	}
+}
@@ -0,0 +1,41 @@
+package unit
+
+import (
+	"strings"
+	"testing"
+
+	"db-backuper/internal/rdsauth"
+)
+
+// TestRDSAuthToken tests generating an IAM authentication token from environment credentials
+func TestRDSAuthToken(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_CONFIG_FILE", t.TempDir()+"/config")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", t.TempDir()+"/credentials")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+
+	token, err := rdsauth.Token("orders.abc123.eu-west-1.rds.amazonaws.com", 5432, "backup", "eu-west-1")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	for _, want := range []string{
+		"orders.abc123.eu-west-1.rds.amazonaws.com:5432?",
+		"Action=connect",
+		"DBUser=backup",
+		"X-Amz-Credential=AKIDEXAMPLE%2F",
+		"%2Feu-west-1%2Frds-db%2Faws4_request",
+		"X-Amz-Signature=",
+	} {
+		if !strings.Contains(token, want) {
+			t.Errorf("Expected token to contain %q, got %s", want, token)
+		}
+	}
+
+	if _, err := rdsauth.Token("orders.internal", 5432, "backup", ""); err == nil {
+		t.Errorf("Expected an error without an AWS region")
+	}
+}
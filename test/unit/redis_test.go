@@ -0,0 +1,48 @@
+package unit
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/restore"
+)
+
+// TestRedisCLIArgs tests the redis-cli connection arguments
+func TestRedisCLIArgs(t *testing.T) {
+	redisConfig := config.RedisConfig{Host: "cache", Username: "backup", Password: "secret", TLS: true}
+
+	expected := []string{"-h", "cache", "-p", "6379", "--user", "backup", "--tls"}
+	if args := redisConfig.GetCLIArgs(); !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %v, got %v", expected, args)
+	}
+	for _, arg := range redisConfig.GetCLIArgs() {
+		if arg == "secret" {
+			t.Error("Password must not be passed on the command line")
+		}
+	}
+}
+
+// TestRedisRestoreInstructions tests the file placement steps for Redis restores
+func TestRedisRestoreInstructions(t *testing.T) {
+	redisConfig := &config.RedisConfig{Host: "cache"}
+
+	placement := &restore.RedisPlacement{Dir: "/data", DBFilename: "dump.rdb"}
+	steps := restore.RedisRestoreInstructions("cache.rdb", redisConfig, placement)
+	if !strings.Contains(steps, "cp cache.rdb /data/dump.rdb") {
+		t.Errorf("Expected copy to the server's RDB path, got:\n%s", steps)
+	}
+	if strings.Contains(steps, "appendonly") {
+		t.Errorf("Did not expect AOF steps when appendonly is disabled, got:\n%s", steps)
+	}
+
+	placement.AppendOnly = true
+	if steps := restore.RedisRestoreInstructions("cache.rdb", redisConfig, placement); !strings.Contains(steps, "appendonly no") {
+		t.Errorf("Expected AOF steps when appendonly is enabled, got:\n%s", steps)
+	}
+
+	if steps := restore.RedisRestoreInstructions("cache.rdb", redisConfig, nil); !strings.Contains(steps, "CONFIG GET dir") {
+		t.Errorf("Expected generic steps without placement, got:\n%s", steps)
+	}
+}
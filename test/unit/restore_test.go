@@ -3,9 +3,12 @@ package unit
 import (
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
 	"db-backuper/internal/config"
+	"db-backuper/internal/provenance"
 	"db-backuper/internal/restore"
 
 	"github.com/sirupsen/logrus"
@@ -15,6 +18,7 @@ import (
 func TestRestoreConfigurationValidation(t *testing.T) {
 	logger := logrus.New()
 	logger.SetLevel(logrus.DebugLevel)
+	negativeErrors := -1
 
 	tests := []struct {
 		name        string
@@ -86,6 +90,90 @@ func TestRestoreConfigurationValidation(t *testing.T) {
 			expectError: true,
 			errorMsg:    "import configuration is incomplete",
 		},
+		{
+			name: "If exists without clean",
+			config: &config.ImportConfig{
+				TargetDatabase: config.ImportDatabaseConfig{
+					Host:     "localhost",
+					Port:     5432,
+					Username: "testuser",
+					Password: "testpass",
+					Database: "testdb",
+				},
+				BackupPath: "/tmp/test_backup.dir.tar.gz",
+				IfExists:   true,
+			},
+			expectError: true,
+			errorMsg:    "if_exists requires clean",
+		},
+		{
+			name: "No owner with a role map",
+			config: &config.ImportConfig{
+				TargetDatabase: config.ImportDatabaseConfig{
+					Host:     "localhost",
+					Port:     5432,
+					Username: "testuser",
+					Password: "testpass",
+					Database: "testdb",
+				},
+				BackupPath: "/tmp/test_backup.dir.tar.gz",
+				NoOwner:    true,
+				RoleMap:    map[string]string{"app_owner": "rds_app"},
+			},
+			expectError: true,
+			errorMsg:    "cannot be combined",
+		},
+		{
+			name: "Negative error threshold",
+			config: &config.ImportConfig{
+				TargetDatabase: config.ImportDatabaseConfig{
+					Host:     "localhost",
+					Port:     5432,
+					Username: "testuser",
+					Password: "testpass",
+					Database: "testdb",
+				},
+				BackupPath: "/tmp/test_backup.sql",
+				MaxErrors:  &negativeErrors,
+			},
+			expectError: true,
+			errorMsg:    "max_errors must not be negative",
+		},
+		{
+			name: "Unknown compatibility mode",
+			config: &config.ImportConfig{
+				TargetDatabase: config.ImportDatabaseConfig{
+					Host:     "localhost",
+					Port:     5432,
+					Username: "testuser",
+					Password: "testpass",
+					Database: "testdb",
+				},
+				BackupPath:    "/tmp/test_backup.sql",
+				Compatibility: "ignore",
+			},
+			expectError: true,
+			errorMsg:    "unsupported import compatibility",
+		},
+		{
+			name: "Ownership options for a managed database",
+			config: &config.ImportConfig{
+				TargetDatabase: config.ImportDatabaseConfig{
+					Host:     "localhost",
+					Port:     5432,
+					Username: "testuser",
+					Password: "testpass",
+					Database: "testdb",
+				},
+				BackupPath:   "/tmp/test_backup.dir.tar.gz",
+				NoOwner:      true,
+				NoPrivileges: true,
+				Clean:        true,
+				IfExists:     true,
+				Role:         "rds_app",
+			},
+			expectError: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -116,11 +204,7 @@ func TestRestoreBackupFileValidation(t *testing.T) {
 	logger.SetLevel(logrus.DebugLevel)
 
 	// Create a temporary backup file
-	tempDir := "/tmp/test_restore_validation"
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		t.Fatalf("Failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(tempDir)
+	tempDir := t.TempDir()
 
 	tempBackupFile := filepath.Join(tempDir, "test_backup.sql")
 	testContent := "-- PostgreSQL database dump\nCREATE TABLE test (id int);\n"
@@ -162,11 +246,7 @@ func TestRestoreBackupFileValidation(t *testing.T) {
 // TestImportConfigWithEmptyDatabases tests that import config allows empty databases
 func TestImportConfigWithEmptyDatabases(t *testing.T) {
 	// Create a temporary config file with empty databases
-	tempDir := "/tmp/test_import_empty_db"
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		t.Fatalf("Failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(tempDir)
+	tempDir := t.TempDir()
 
 	configFile := filepath.Join(tempDir, "import_config.json")
 	configContent := `{
@@ -194,7 +274,7 @@ func TestImportConfigWithEmptyDatabases(t *testing.T) {
 	}
 
 	// Test LoadConfigForImport (should succeed with empty databases)
-	cfg, err := config.LoadConfigForImport(configFile)
+	cfg, err := config.LoadConfigForImport(configFile, config.LoadOptions{})
 	if err != nil {
 		t.Errorf("LoadConfigForImport should succeed with empty databases: %v", err)
 	}
@@ -203,7 +283,7 @@ func TestImportConfigWithEmptyDatabases(t *testing.T) {
 	}
 
 	// Test regular LoadConfig (should fail with empty databases)
-	_, err = config.LoadConfig(configFile)
+	_, err = config.LoadConfig(configFile, config.LoadOptions{})
 	if err == nil {
 		t.Error("LoadConfig should fail with empty databases")
 	}
@@ -254,3 +334,219 @@ func containsSubstring(s, substr string) bool {
 	}
 	return false
 }
+
+// TestRewriteRoles tests renaming roles in ownership and privilege statements
+func TestRewriteRoles(t *testing.T) {
+	roles := map[string]string{"app_owner": "rds_app", "Reporting": "readonly"}
+
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"ALTER TABLE public.orders OWNER TO app_owner;\n", "ALTER TABLE public.orders OWNER TO \"rds_app\";\n"},
+		{"GRANT SELECT ON TABLE public.orders TO \"Reporting\";\n", "GRANT SELECT ON TABLE public.orders TO \"readonly\";\n"},
+		{"REVOKE ALL ON SCHEMA public FROM app_owner;\n", "REVOKE ALL ON SCHEMA public FROM \"rds_app\";\n"},
+		{"SET SESSION AUTHORIZATION app_owner;\n", "SET SESSION AUTHORIZATION \"rds_app\";\n"},
+		{"GRANT ALL ON SCHEMA public TO postgres;\n", "GRANT ALL ON SCHEMA public TO postgres;\n"},
+		{"CREATE TABLE public.owner_to_app_owner (id integer);\n", "CREATE TABLE public.owner_to_app_owner (id integer);\n"},
+	}
+
+	for _, tt := range tests {
+		if got := restore.RewriteRoles(tt.line, roles); got != tt.expected {
+			t.Errorf("RewriteRoles(%q) = %q, expected %q", tt.line, got, tt.expected)
+		}
+	}
+}
+
+// TestRestoreErrorReport tests collecting failed statements from psql and pg_restore output
+func TestRestoreErrorReport(t *testing.T) {
+	output := []string{
+		"CREATE TABLE",
+		`psql:orders_2024-01-15.sql:12: ERROR:  relation "orders" already exists`,
+		`psql:orders_2024-01-15.sql:40: ERROR:  insert or update on table "items" violates foreign key constraint "items_order_id_fkey"`,
+		`psql:orders_2024-01-15.sql:40: DETAIL:  Key (order_id)=(7) is not present in table "orders".`,
+		"INSERT 0 1",
+		`pg_restore: error: could not execute query: ERROR:  role "app_owner" does not exist`,
+		"Command was: ALTER TABLE public.orders OWNER TO app_owner;",
+		"pg_restore: warning: errors ignored on restore: 1",
+	}
+
+	report := &restore.ErrorReport{}
+	for _, line := range output {
+		report.Parse(line)
+	}
+
+	expected := []restore.StatementError{
+		{Line: 12, Message: `relation "orders" already exists`},
+		{Line: 40, Message: `insert or update on table "items" violates foreign key constraint "items_order_id_fkey"`, Detail: `Key (order_id)=(7) is not present in table "orders".`},
+		{Message: `role "app_owner" does not exist`, Statement: "ALTER TABLE public.orders OWNER TO app_owner;"},
+	}
+	if len(report.Errors) != len(expected) {
+		t.Fatalf("Expected %d errors, got %d: %+v", len(expected), len(report.Errors), report.Errors)
+	}
+	for i, want := range expected {
+		if report.Errors[i] != want {
+			t.Errorf("Error %d: expected %+v, got %+v", i, want, report.Errors[i])
+		}
+	}
+}
+
+// TestCompareRowCounts tests comparing restored row counts with the backup's manifest
+func TestCompareRowCounts(t *testing.T) {
+	rows := func(n int64) *int64 { return &n }
+	manifest := []provenance.TableStats{
+		{Name: "public.orders", Rows: rows(100)},
+		{Name: "public.items", Rows: rows(250)},
+		{Name: "public.events", Rows: rows(10)},
+		{Name: "public.audit", Rows: rows(5)},
+		{Name: "sales.invoices", EstimatedRows: 800, SizeBytes: 65536},
+	}
+	restored := map[string]int64{"public.orders": 100, "public.items": 120, "public.events": 12}
+	failures := map[string]string{"public.audit": `relation "public.audit" does not exist`}
+
+	report := restore.CompareRowCounts(manifest, restored, failures)
+	if report.Tables != 4 || len(report.Discrepancies) != 3 {
+		t.Fatalf("Expected 3 discrepancies in 4 tables, got %+v", report)
+	}
+	truncated := report.Truncated()
+	if len(truncated) != 2 || truncated[0].Table != "public.items" || truncated[1].Table != "public.audit" {
+		t.Errorf("Expected items and audit to be truncated, got %+v", truncated)
+	}
+	if report.Discrepancies[1].Truncated() {
+		t.Errorf("Expected extra rows not to count as truncation: %+v", report.Discrepancies[1])
+	}
+
+	matching := restore.CompareRowCounts(manifest[:1], restored, nil)
+	if len(matching.Discrepancies) != 0 || len(matching.Truncated()) != 0 {
+		t.Errorf("Expected matching counts to have no discrepancies, got %+v", matching)
+	}
+}
+
+// TestReadDumpVersions tests reading the versions from the headers of pg_dump scripts, pg_restore listings and service backups
+func TestReadDumpVersions(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected restore.DumpVersions
+	}{
+		{"--\n-- PostgreSQL database dump\n--\n\n-- Dumped from database version 16.2 (Debian 16.2-1.pgdg120+2)\n-- Dumped by pg_dump version 17.0\n", restore.DumpVersions{Server: "16.2", PgDump: "17.0"}},
+		{";\n; Archive created at 2024-01-15 02:00:00 UTC\n;     Dumped from database version: 15.6\n;     Dumped by pg_dump version: 15.6\n", restore.DumpVersions{Server: "15.6", PgDump: "15.6"}},
+		{"-- PostgreSQL database backup created by db-backuper\n-- Database: orders\n-- Dumped from database version 9.6.24\n", restore.DumpVersions{Server: "9.6.24"}},
+		{"CREATE TABLE orders (id integer);\n", restore.DumpVersions{}},
+	}
+
+	for _, tt := range tests {
+		if got := restore.ReadDumpVersions(strings.NewReader(tt.header)); got != tt.expected {
+			t.Errorf("ReadDumpVersions(%q) = %+v, expected %+v", tt.header, got, tt.expected)
+		}
+	}
+
+	for version, expected := range map[string]int{"16.2": 160002, "17beta1": 170000, "9.6.24": 90624, "10": 100000, "unknown": 0} {
+		if got := restore.VersionNum(version); got != expected {
+			t.Errorf("VersionNum(%q) = %d, expected %d", version, got, expected)
+		}
+	}
+}
+
+// TestCheckCompatibility tests the fixups and warnings of restores across PostgreSQL versions
+func TestCheckCompatibility(t *testing.T) {
+	fixups, warnings := restore.CheckCompatibility(restore.DumpVersions{Server: "17.2", PgDump: "17.2"}, 130004)
+	var names []string
+	for _, fixup := range fixups {
+		names = append(names, fixup.Name)
+	}
+	expected := []string{
+		"SET transaction_timeout (PostgreSQL 17)",
+		"SET default_toast_compression (PostgreSQL 14)",
+		"pg_database_owner schema owner (PostgreSQL 14)",
+	}
+	if !slices.Equal(names, expected) {
+		t.Errorf("Fixups = %v, expected %v", names, expected)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "PostgreSQL 17 into older PostgreSQL 13") {
+		t.Errorf("Expected a warning about restoring into an older server, got %v", warnings)
+	}
+
+	// pg_dump decides what the script contains, whatever server it dumped
+	fixups, warnings = restore.CheckCompatibility(restore.DumpVersions{Server: "16.2", PgDump: "17.0"}, 160002)
+	if len(fixups) != 1 || len(warnings) != 1 {
+		t.Errorf("Expected one fixup and one warning for a pg_dump 17 script restored into 16, got %v and %v", fixups, warnings)
+	}
+
+	fixups, warnings = restore.CheckCompatibility(restore.DumpVersions{Server: "9.6.24"}, 160002)
+	if len(fixups) != 0 || len(warnings) != 0 {
+		t.Errorf("Expected no fixups or warnings restoring into a newer server, got %v and %v", fixups, warnings)
+	}
+}
+
+// TestParentsFirst tests ordering tables for sampling so that referenced
+// tables come first, with cycles broken and self-references ignored
+func TestParentsFirst(t *testing.T) {
+	key := func(table, refTable string) restore.ForeignKey {
+		return restore.ForeignKey{Table: table, Columns: []string{refTable + "_id"}, RefTable: refTable, RefColumns: []string{"id"}}
+	}
+
+	tests := []struct {
+		name     string
+		tables   []string
+		keys     []restore.ForeignKey
+		expected []string
+	}{
+		{
+			name:     "chain",
+			tables:   []string{"order_items", "orders", "customers"},
+			keys:     []restore.ForeignKey{key("order_items", "orders"), key("orders", "customers")},
+			expected: []string{"customers", "orders", "order_items"},
+		},
+		{
+			name:     "self-reference",
+			tables:   []string{"employees", "departments"},
+			keys:     []restore.ForeignKey{key("employees", "employees"), key("employees", "departments")},
+			expected: []string{"departments", "employees"},
+		},
+		{
+			name:     "cycle",
+			tables:   []string{"invoices", "customers", "accounts", "regions"},
+			keys:     []restore.ForeignKey{key("customers", "accounts"), key("accounts", "customers"), key("invoices", "customers")},
+			expected: []string{"regions", "customers", "invoices", "accounts"},
+		},
+		{
+			name:     "parent of a cycle",
+			tables:   []string{"a", "b", "root"},
+			keys:     []restore.ForeignKey{key("a", "b"), key("b", "a"), key("a", "root")},
+			expected: []string{"root", "a", "b"},
+		},
+		{
+			name:     "reference outside the tables",
+			tables:   []string{"orders"},
+			keys:     []restore.ForeignKey{key("orders", "archive.customers")},
+			expected: []string{"orders"},
+		},
+	}
+	for _, tt := range tests {
+		if got := restore.ParentsFirst(tt.tables, tt.keys); !slices.Equal(got, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+// TestSampleSize tests that sampling rounds the rows kept up
+func TestSampleSize(t *testing.T) {
+	tests := []struct {
+		rows     int64
+		percent  float64
+		expected int64
+	}{
+		{1000, 10, 100},
+		{7, 10, 1},
+		{1, 0.5, 1},
+		{15, 33.3, 5},
+		{10, 100, 10},
+		{0, 50, 0},
+		{10, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := restore.SampleSize(tt.rows, tt.percent); got != tt.expected {
+			t.Errorf("SampleSize(%d, %v) = %d, expected %d", tt.rows, tt.percent, got, tt.expected)
+		}
+	}
+}
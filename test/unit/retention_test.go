@@ -0,0 +1,106 @@
+package unit
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"db-backuper/internal/retention"
+	"db-backuper/internal/storage"
+)
+
+// dailyBackups returns one backup of database per day from first to last
+func dailyBackups(database string, first, last time.Time) []retention.Backup {
+	var backups []retention.Backup
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		date := day.Format(storage.DateLayout)
+		backups = append(backups, retention.Backup{
+			Storage: "local:/var/backups",
+			Entry: storage.Entry{
+				Key:      fmt.Sprintf("postgres-backup/%s/%s/%s_%s_02-00-00.sql", database, date, database, date),
+				Database: database,
+				Date:     day,
+				Size:     100,
+			},
+		})
+	}
+	return backups
+}
+
+// deletedDates returns the dates of the backups of database a simulation deletes
+func deletedDates(decisions []retention.Decision, database string) []string {
+	var dates []string
+	for _, decision := range decisions {
+		if decision.Delete && decision.Database == database {
+			dates = append(dates, decision.Date)
+		}
+	}
+	return dates
+}
+
+// TestRetentionSimulate tests the days, count and GFS rules of a simulated policy
+func TestRetentionSimulate(t *testing.T) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	backups := dailyBackups("orders", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC))
+	backups = append(backups, dailyBackups("users", time.Date(2024, 3, 29, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC))...)
+
+	// Days match retention cleanup: backups dated before the cutoff go
+	decisions := retention.Simulate(retention.Policy{Days: 7}, backups, now)
+	if deleted := deletedDates(decisions, "orders"); len(deleted) != 84 || deleted[0] != "2024-03-24" {
+		t.Errorf("Expected the 84 backups before 2024-03-25 to be deleted, newest first, got %d starting %v", len(deleted), deleted[:1])
+	}
+	if deleted := deletedDates(decisions, "users"); len(deleted) != 0 {
+		t.Errorf("Expected recent backups to be kept, got %v", deleted)
+	}
+
+	// Counts apply per database
+	decisions = retention.Simulate(retention.Policy{KeepLast: 2}, backups, now)
+	if deleted := deletedDates(decisions, "users"); !slices.Equal(deleted, []string{"2024-03-29"}) {
+		t.Errorf("Expected the oldest users backup to be deleted, got %v", deleted)
+	}
+
+	// GFS keeps the newest backup of each of the most recent periods
+	decisions = retention.Simulate(retention.Policy{KeepDaily: 3, KeepWeekly: 2, KeepMonthly: 3}, backups, now)
+	var kept []string
+	for _, decision := range decisions {
+		if !decision.Delete && decision.Database == "orders" {
+			kept = append(kept, decision.Date+" "+fmt.Sprint(decision.KeptBy))
+		}
+	}
+	want := []string{
+		"2024-03-31 [daily 2024-03-31 weekly 2024-W13 monthly 2024-03]",
+		"2024-03-30 [daily 2024-03-30]",
+		"2024-03-29 [daily 2024-03-29]",
+		"2024-03-24 [weekly 2024-W12]",
+		"2024-02-29 [monthly 2024-02]",
+		"2024-01-31 [monthly 2024-01]",
+	}
+	if !slices.Equal(kept, want) {
+		t.Errorf("Expected kept backups %v, got %v", want, kept)
+	}
+
+	// Held backups are never deleted
+	backups[0].Held = true
+	decisions = retention.Simulate(retention.Policy{Days: 7}, backups, now)
+	if deleted := deletedDates(decisions, "orders"); len(deleted) != 83 || slices.Contains(deleted, "2024-01-01") {
+		t.Errorf("Expected the held backup to be kept, got %d deleted", len(deleted))
+	}
+}
+
+// TestRetentionPolicyValidation tests that a policy needs a rule and no negative counts
+func TestRetentionPolicyValidation(t *testing.T) {
+	if err := (retention.Policy{}).Validate(); err == nil {
+		t.Error("Expected a policy without rules to be rejected")
+	}
+	if err := (retention.Policy{Days: 7, KeepWeekly: -1}).Validate(); err == nil {
+		t.Error("Expected a negative count to be rejected")
+	}
+	policy := retention.Policy{Days: 30, KeepMonthly: 12}
+	if err := policy.Validate(); err != nil {
+		t.Errorf("Expected %s to be valid: %v", policy, err)
+	}
+	if policy.String() != "days 30, monthly 12" {
+		t.Errorf("Unexpected description %q", policy)
+	}
+}
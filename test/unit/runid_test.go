@@ -0,0 +1,36 @@
+package unit
+
+import (
+	"testing"
+	"time"
+
+	"db-backuper/internal/runid"
+)
+
+// TestRunIDFormat tests that run IDs are unique, sortable ULIDs
+func TestRunIDFormat(t *testing.T) {
+	first := runid.NewAt(time.Date(2024, 1, 15, 14, 30, 25, 0, time.UTC))
+	second := runid.NewAt(time.Date(2024, 1, 15, 14, 30, 26, 0, time.UTC))
+
+	if len(first) != 26 {
+		t.Errorf("Expected run ID length 26, got %d (%s)", len(first), first)
+	}
+	if first >= second {
+		t.Errorf("Expected run IDs to sort by time: %s >= %s", first, second)
+	}
+	if runid.New() == runid.New() {
+		t.Errorf("Expected unique run IDs")
+	}
+
+	ts, ok := runid.Time(first)
+	if !ok {
+		t.Fatalf("Failed to decode time from run ID %s", first)
+	}
+	if !ts.Equal(time.Date(2024, 1, 15, 14, 30, 25, 0, time.UTC)) {
+		t.Errorf("Unexpected decoded time: %v", ts)
+	}
+
+	if _, ok := runid.Time("not-a-run-id"); ok {
+		t.Errorf("Expected invalid run ID to fail decoding")
+	}
+}
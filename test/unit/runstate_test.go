@@ -0,0 +1,159 @@
+package unit
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/runstate"
+	"db-backuper/internal/sla"
+	"db-backuper/internal/status"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestRunStatePersistence tests that the scheduler state survives reopening
+// and detects a scheduled run missed while the service was stopped
+func TestRunStatePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", runstate.FileName)
+	store, err := runstate.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open state: %v", err)
+	}
+
+	runAt := time.Date(2024, 1, 15, 2, 0, 0, 0, time.UTC)
+	for i := range 2 {
+		started := runAt.Add(time.Duration(i) * time.Hour)
+		summary := &status.RunSummary{StartedAt: started, FinishedAt: started.Add(time.Minute)}
+		summary.Add(status.DatabaseResult{Database: "orders", Status: status.ResultSuccess, StartedAt: started, FinishedAt: started.Add(30 * time.Second)})
+		summary.Add(status.DatabaseResult{Database: "users", Status: status.ResultFailed, FinishedAt: started.Add(time.Minute)})
+		if err := store.RecordRun(summary); err != nil {
+			t.Fatalf("Failed to record run: %v", err)
+		}
+	}
+	nextRun := runAt.Add(2 * time.Hour)
+	if err := store.SetNextRun(nextRun); err != nil {
+		t.Fatalf("Failed to record next run: %v", err)
+	}
+
+	reopened, err := runstate.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen state: %v", err)
+	}
+	users, ok := reopened.Database("users")
+	if !ok || users.ConsecutiveFailures != 2 || users.LastSuccessAt != nil {
+		t.Errorf("Unexpected users state: %+v", users)
+	}
+	orders, _ := reopened.Database("orders")
+	if orders.LastSuccessStartedAt == nil || !orders.LastSuccessStartedAt.Equal(runAt.Add(time.Hour)) {
+		t.Errorf("Unexpected orders recovery point: %v", orders.LastSuccessStartedAt)
+	}
+	if report := reopened.Report(); report == nil || len(report.Databases) != 2 || report.Databases[0].Database != "orders" {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+
+	if _, missed := reopened.Missed(nextRun.Add(-time.Minute)); missed {
+		t.Error("Expected no missed run before it was due")
+	}
+	if missedAt, missed := reopened.Missed(nextRun.Add(time.Hour)); !missed || !missedAt.Equal(nextRun) {
+		t.Errorf("Expected the run at %s to be missed, got %s (%v)", nextRun, missedAt, missed)
+	}
+}
+
+// TestRunStateHistory tests that recorded runs are kept for the history
+// command, newest last, and trimmed to the most recent ones
+func TestRunStateHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), runstate.FileName)
+	store, err := runstate.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open state: %v", err)
+	}
+
+	runAt := time.Date(2024, 1, 15, 2, 0, 0, 0, time.UTC)
+	for i := range runstate.MaxRuns + 5 {
+		started := runAt.Add(time.Duration(i) * time.Hour)
+		summary := &status.RunSummary{RunID: fmt.Sprintf("run-%d", i), StartedAt: started, FinishedAt: started.Add(time.Minute), ObjectsDeleted: 3}
+		summary.Add(status.DatabaseResult{Database: "orders", Status: status.ResultSuccess})
+		summary.Add(status.DatabaseResult{Database: "users", Status: status.ResultFailed})
+		if err := store.RecordRun(summary); err != nil {
+			t.Fatalf("Failed to record run: %v", err)
+		}
+	}
+
+	reopened, err := runstate.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen state: %v", err)
+	}
+	runs := reopened.Runs()
+	if len(runs) != runstate.MaxRuns {
+		t.Fatalf("Expected %d runs, got %d", runstate.MaxRuns, len(runs))
+	}
+	if runs[0].RunID != "run-5" || runs[len(runs)-1].RunID != fmt.Sprintf("run-%d", runstate.MaxRuns+4) {
+		t.Errorf("Expected the oldest runs to be trimmed, got %s to %s", runs[0].RunID, runs[len(runs)-1].RunID)
+	}
+	last := runs[len(runs)-1]
+	if last.Successful != 1 || last.Failed != 1 || last.ObjectsDeleted != 3 || len(last.FailedDatabases) != 1 || last.FailedDatabases[0] != "users" {
+		t.Errorf("Unexpected run record: %+v", last)
+	}
+}
+
+// TestRunStateConcurrentWrites tests recording runs from several goroutines at once
+func TestRunStateConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), runstate.FileName)
+	store, err := runstate.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open state: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Go(func() {
+			summary := &status.RunSummary{StartedAt: time.Now()}
+			summary.Add(status.DatabaseResult{Database: fmt.Sprintf("db%d", i), Status: status.ResultSuccess, FinishedAt: time.Now()})
+			if err := store.RecordRun(summary); err != nil {
+				t.Errorf("Failed to record run: %v", err)
+			}
+		})
+	}
+	wg.Wait()
+
+	reopened, err := runstate.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen state: %v", err)
+	}
+	if report := reopened.Report(); report == nil || len(report.Databases) != 10 {
+		t.Errorf("Expected 10 databases in the state, got %+v", report)
+	}
+}
+
+// TestSLAViolationsSurviveRestart tests that a violation alerted on before a
+// restart is not alerted on again
+func TestSLAViolationsSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), runstate.FileName)
+	cfg := &config.Config{Databases: []config.DatabaseConfig{
+		{Database: "orders", SLA: config.SLAConfig{MaxAgeMinutes: 60}},
+	}}
+	later := time.Now().Add(2 * time.Hour)
+
+	store, _ := runstate.Open(path)
+	alerter := &recordingAlerter{}
+	monitor := sla.NewMonitor(cfg, nil, alerter, nil, logrus.New())
+	monitor.Persist(store)
+	monitor.Check(later)
+	if len(alerter.subjects) != 1 {
+		t.Fatalf("Expected one alert, got %v", alerter.subjects)
+	}
+
+	reopened, _ := runstate.Open(path)
+	restarted := sla.NewMonitor(cfg, nil, alerter, nil, logrus.New())
+	restarted.Persist(reopened)
+	if violations := restarted.Check(later); len(violations) != 1 {
+		t.Fatalf("Expected the violation to persist, got %v", violations)
+	}
+	if len(alerter.subjects) != 1 {
+		t.Errorf("Expected no new alert after the restart, got %v", alerter.subjects)
+	}
+}
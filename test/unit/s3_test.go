@@ -0,0 +1,315 @@
+package unit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/provenance"
+	"db-backuper/internal/s3"
+	"db-backuper/internal/storage"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/sirupsen/logrus"
+)
+
+// fakeS3 lists a fixed set of objects and records the deletions
+type fakeS3 struct {
+	s3iface.S3API
+	objects map[string]time.Time
+	deleted []string
+}
+
+func (f *fakeS3) ListObjectsV2Pages(input *awss3.ListObjectsV2Input, fn func(*awss3.ListObjectsV2Output, bool) bool) error {
+	page := &awss3.ListObjectsV2Output{}
+	for key, modified := range f.objects {
+		if strings.HasPrefix(key, aws.StringValue(input.Prefix)) {
+			page.Contents = append(page.Contents, &awss3.Object{Key: aws.String(key), LastModified: aws.Time(modified)})
+		}
+	}
+	fn(page, true)
+	return nil
+}
+
+func (f *fakeS3) DeleteObjects(input *awss3.DeleteObjectsInput) (*awss3.DeleteObjectsOutput, error) {
+	output := &awss3.DeleteObjectsOutput{}
+	for _, obj := range input.Delete.Objects {
+		f.deleted = append(f.deleted, aws.StringValue(obj.Key))
+		output.Deleted = append(output.Deleted, &awss3.DeletedObject{Key: obj.Key})
+	}
+	return output, nil
+}
+
+// TestS3RetentionNestedPrefix tests that S3 retention dates the backups under
+// a backup prefix of several segments, such as a per-database storage prefix
+func TestS3RetentionNestedPrefix(t *testing.T) {
+	now := time.Now()
+	old := now.AddDate(0, 0, -30)
+	oldDay := old.Format(storage.DateLayout)
+	today := now.Format(storage.DateLayout)
+
+	prefix := "archive/orders"
+	expired := []string{
+		prefix + "/orders/" + oldDay + "/orders_old.sql",
+		prefix + "/orders/" + oldDay + "/orders_old.sql" + provenance.SidecarSuffix,
+		prefix + "/orders/" + oldDay + "/changes/batch-0001.json",
+		prefix + "/orders/" + oldDay + "/orders_files" + storage.DirectorySuffix + "/data/a.txt",
+	}
+	client := &fakeS3{objects: map[string]time.Time{
+		// Dated today, so kept however old its LastModified is
+		prefix + "/orders/" + today + "/orders_new.sql": old,
+		prefix + "/legacy.sql":                          old,
+		"archive/other/orders/" + oldDay + "/x.sql":     old,
+	}}
+	for _, key := range expired {
+		client.objects[key] = old
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	manager, err := s3.NewS3ManagerWithClient(&config.AWSConfig{Bucket: "backups", Region: "us-east-1"}, client, logger)
+	if err != nil {
+		t.Fatalf("Failed to create S3 manager: %v", err)
+	}
+
+	deleted, err := manager.DeleteOldBackups(prefix, 7)
+	if err != nil {
+		t.Fatalf("Retention failed: %v", err)
+	}
+	if deleted != len(expired) || !sameKeys(client.deleted, expired) {
+		t.Errorf("Expected %v to be deleted, got %v", expired, client.deleted)
+	}
+
+	// Undated objects are aged by LastModified only when enabled
+	client.deleted = nil
+	for _, key := range expired {
+		delete(client.objects, key)
+	}
+	manager.SetModTimeRetention(true)
+	if _, err := manager.DeleteOldBackups(prefix, 7); err != nil {
+		t.Fatalf("Retention failed: %v", err)
+	}
+	if !sameKeys(client.deleted, []string{prefix + "/legacy.sql"}) {
+		t.Errorf("Expected only the undated backup to be deleted, got %v", client.deleted)
+	}
+}
+
+// sameKeys reports whether a and b hold the same keys in any order
+func sameKeys(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]int)
+	for _, key := range a {
+		seen[key]++
+	}
+	for _, key := range b {
+		if seen[key] == 0 {
+			return false
+		}
+		seen[key]--
+	}
+	return true
+}
+
+// rangedS3 serves one object by range and records the ranges requested.
+// Each failure in failures is returned once for the range it is keyed by.
+type rangedS3 struct {
+	s3iface.S3API
+	data     []byte
+	etag     string
+	mu       sync.Mutex
+	ranges   []string
+	failures map[string][]error
+}
+
+func (r *rangedS3) HeadObject(*awss3.HeadObjectInput) (*awss3.HeadObjectOutput, error) {
+	return &awss3.HeadObjectOutput{ContentLength: aws.Int64(int64(len(r.data))), ETag: aws.String(r.etag)}, nil
+}
+
+func (r *rangedS3) GetObject(input *awss3.GetObjectInput) (*awss3.GetObjectOutput, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rng := aws.StringValue(input.Range)
+	r.ranges = append(r.ranges, rng)
+	if errs := r.failures[rng]; len(errs) > 0 {
+		r.failures[rng] = errs[1:]
+		return nil, errs[0]
+	}
+	if aws.StringValue(input.IfMatch) != r.etag {
+		return nil, awserr.New("PreconditionFailed", "object changed", nil)
+	}
+	var start, end int
+	if _, err := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end); err != nil {
+		return nil, err
+	}
+	return &awss3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(r.data[start : end+1]))}, nil
+}
+
+// requested returns the ranges requested so far, sorted
+func (r *rangedS3) requested() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Sorted(slices.Values(r.ranges))
+}
+
+// newRangedDownload returns a manager downloading a 10 byte object from
+// client in chunks of 4 bytes, and the destination of the download
+func newRangedDownload(t *testing.T, client *rangedS3) (*s3.S3Manager, string) {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	manager, err := s3.NewS3ManagerWithClient(&config.AWSConfig{Bucket: "backups", Region: "us-east-1"}, client, logger)
+	if err != nil {
+		t.Fatalf("Failed to create S3 manager: %v", err)
+	}
+	manager.SetDownloadChunkSize(4)
+	return manager, filepath.Join(t.TempDir(), "orders.sql")
+}
+
+// writePartialDownload leaves a partial download of destPath with part as
+// its content and state as its recorded progress
+func writePartialDownload(t *testing.T, destPath string, part []byte, state map[string]any) {
+	t.Helper()
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(destPath+".part", part, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(destPath+".part.json", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkDownloaded checks that destPath holds data and the partial download
+// and its progress are gone
+func checkDownloaded(t *testing.T, destPath string, data []byte) {
+	t.Helper()
+	got, err := os.ReadFile(destPath)
+	if err != nil {
+		t.Fatalf("Failed to read download: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("Expected %q, got %q", data, got)
+	}
+	for _, leftover := range []string{destPath + ".part", destPath + ".part.json"} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed", leftover)
+		}
+	}
+}
+
+// TestS3DownloadResume tests that a ranged download skips the chunks an
+// earlier attempt recorded as done
+func TestS3DownloadResume(t *testing.T) {
+	client := &rangedS3{data: []byte("0123456789"), etag: `"v1"`}
+	manager, destPath := newRangedDownload(t, client)
+	writePartialDownload(t, destPath, []byte("xxxx4567xx"), map[string]any{
+		"etag": `"v1"`, "size": 10, "chunk_size": 4, "done": []int{1},
+	})
+
+	if err := manager.Download("orders/2024-01-02/orders.sql", destPath); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if expected := []string{"bytes=0-3", "bytes=8-9"}; !slices.Equal(client.requested(), expected) {
+		t.Errorf("Expected only the missing chunks %v to be requested, got %v", expected, client.requested())
+	}
+	checkDownloaded(t, destPath, client.data)
+}
+
+// TestS3DownloadStateMismatch tests that progress recorded for another
+// version of the object or another chunk size is discarded
+func TestS3DownloadStateMismatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		state map[string]any
+	}{
+		{"etag", map[string]any{"etag": `"v0"`, "size": 10, "chunk_size": 4, "done": []int{0, 1, 2}}},
+		{"size", map[string]any{"etag": `"v1"`, "size": 12, "chunk_size": 4, "done": []int{0, 1, 2}}},
+		{"chunk size", map[string]any{"etag": `"v1"`, "size": 10, "chunk_size": 5, "done": []int{0, 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &rangedS3{data: []byte("0123456789"), etag: `"v1"`}
+			manager, destPath := newRangedDownload(t, client)
+			writePartialDownload(t, destPath, []byte("stale content"), tt.state)
+
+			if err := manager.Download("orders/2024-01-02/orders.sql", destPath); err != nil {
+				t.Fatalf("Download failed: %v", err)
+			}
+			if expected := []string{"bytes=0-3", "bytes=4-7", "bytes=8-9"}; !slices.Equal(client.requested(), expected) {
+				t.Errorf("Expected every chunk %v to be requested, got %v", expected, client.requested())
+			}
+			checkDownloaded(t, destPath, client.data)
+		})
+	}
+}
+
+// TestS3DownloadRetry tests that a failed range is requested again unless
+// the object changed, is missing or is forbidden, and that the chunks
+// written before a failure are kept for the next attempt
+func TestS3DownloadRetry(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{awserr.New("InternalError", "try again", nil), true},
+		{awserr.New("PreconditionFailed", "object changed", nil), false},
+		{awserr.New(awss3.ErrCodeNoSuchKey, "missing", nil), false},
+		{awserr.New("AccessDenied", "forbidden", nil), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.(awserr.Error).Code(), func(t *testing.T) {
+			client := &rangedS3{data: []byte("0123456789"), etag: `"v1"`, failures: map[string][]error{
+				"bytes=4-7": {tt.err},
+			}}
+			manager, destPath := newRangedDownload(t, client)
+			manager.SetTransferJobs(1)
+
+			err := manager.Download("orders/2024-01-02/orders.sql", destPath)
+			if tt.retryable {
+				if err != nil {
+					t.Fatalf("Expected the range to be retried, got %v", err)
+				}
+				if expected := []string{"bytes=0-3", "bytes=4-7", "bytes=4-7", "bytes=8-9"}; !slices.Equal(client.requested(), expected) {
+					t.Errorf("Expected %v to be requested, got %v", expected, client.requested())
+				}
+				checkDownloaded(t, destPath, client.data)
+				return
+			}
+
+			var awsErr awserr.Error
+			if !errors.As(err, &awsErr) || awsErr.Code() != tt.err.(awserr.Error).Code() {
+				t.Fatalf("Expected the download to fail with %v, got %v", tt.err, err)
+			}
+			if n := slices.Index(client.requested(), "bytes=4-7"); n < 0 || slices.Contains(client.requested()[n+1:], "bytes=4-7") {
+				t.Errorf("Expected the range to be requested once, got %v", client.requested())
+			}
+			data, err := os.ReadFile(destPath + ".part.json")
+			if err != nil {
+				t.Fatalf("Expected the progress to be kept: %v", err)
+			}
+			var state struct {
+				Done []int `json:"done"`
+			}
+			if err := json.Unmarshal(data, &state); err != nil || !slices.Contains(state.Done, 0) || slices.Contains(state.Done, 1) {
+				t.Errorf("Expected chunk 0 and not chunk 1 to be recorded, got %s", data)
+			}
+		})
+	}
+}
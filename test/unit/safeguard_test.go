@@ -0,0 +1,137 @@
+package unit
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/backup"
+	"db-backuper/internal/catalog"
+	"db-backuper/internal/config"
+	"db-backuper/internal/provenance"
+	"db-backuper/internal/safeguard"
+	"db-backuper/internal/status"
+	"db-backuper/internal/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// safeguardBackup stores dump as a PostgreSQL backup of orders with a
+// manifest of two rows in public.orders, then replaces the stored copy with
+// stored when given, as a backup damaged in storage would be
+func safeguardBackup(t *testing.T, dir, dump, stored string) safeguard.Backup {
+	t.Helper()
+	localStorage, err := storage.NewLocalStorage(&config.LocalConfig{Path: filepath.Join(dir, "backups")}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+	dumpFile := filepath.Join(dir, "orders_2024-01-15_02-00-00.sql")
+	if err := os.WriteFile(dumpFile, []byte(dump), 0644); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := provenance.Describe(dumpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := int64(2)
+	meta.Database, meta.Engine = "orders", config.EngineTypePostgres
+	meta.Tables = []provenance.TableStats{{Name: "public.orders", Rows: &rows}}
+
+	storedPath, err := localStorage.SaveBackup(dumpFile, "db-backup", "orders", meta)
+	if err != nil {
+		t.Fatalf("Failed to save backup: %v", err)
+	}
+	if stored != "" {
+		if err := os.WriteFile(storedPath, []byte(stored), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return safeguard.Backup{
+		Result:  status.DatabaseResult{Database: "orders", SizeBytes: int64(len(dump)), Location: storedPath},
+		Backend: localStorage,
+		Prefix:  "db-backup",
+	}
+}
+
+// safeguardDump is a complete SQL backup of two rows of public.orders
+var safeguardDump = backup.PostgresHeader + "\n" +
+	"CREATE TABLE public.orders (id integer);\n" +
+	"INSERT INTO public.orders VALUES (1);\n" +
+	"INSERT INTO public.orders VALUES (2);\n" +
+	backup.PostgresCompletionMarker + "2024-01-15 02:00:00\n"
+
+// TestSafeguardVerify tests that safeguard backups are read back and checked
+// like the verify command does before the command may run
+func TestSafeguardVerify(t *testing.T) {
+	truncated := safeguardDump[:strings.Index(safeguardDump, "INSERT")]
+
+	tests := []struct {
+		name    string
+		dump    string
+		stored  string
+		problem string
+	}{
+		{name: "complete", dump: safeguardDump},
+		{name: "corrupted in storage", dump: safeguardDump, stored: strings.Replace(safeguardDump, "(2)", "(3)", 1), problem: "checksum mismatch"},
+		{name: "truncated", dump: truncated, problem: "completion marker"},
+		{name: "missing rows", dump: strings.Replace(safeguardDump, "INSERT INTO public.orders VALUES (2);\n", "", 1), problem: "has 1 rows in the dump, 2 were backed up"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := safeguardBackup(t, t.TempDir(), tt.dump, tt.stored)
+			key, err := safeguard.Verify(b.Backend, b.Result, 1)
+			if tt.problem == "" {
+				if err != nil || !strings.HasPrefix(key, "db-backup/orders/") || !strings.HasSuffix(key, "/orders_2024-01-15_02-00-00.sql") {
+					t.Errorf("Expected the backup to verify, got key %q (%v)", key, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.problem) {
+				t.Errorf("Expected verification to fail with %q, got %v", tt.problem, err)
+			}
+		})
+	}
+}
+
+// TestSafeguardRun tests that the command only runs, with the restore points
+// tagged, once every backup verified
+func TestSafeguardRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the wrapped command is a shell script")
+	}
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "migrated")
+	command := []string{"sh", "-c", `echo "$DB_BACKUP_SAFEGUARD_LABEL $DB_BACKUP_RUN_ID" > "$0"`, marker}
+	opts := safeguard.Options{Label: "pre-migration", Command: command, RunID: "01HM7Z8X4T2V6C9R3K5N1QWJBE", Jobs: 1}
+
+	// A truncated backup refuses the command and tags nothing
+	refused := safeguardBackup(t, filepath.Join(dir, "refused"), safeguardDump[:len(safeguardDump)-40], "")
+	if err := safeguard.Run([]safeguard.Backup{refused}, opts, logger); err == nil || !strings.Contains(err.Error(), "not running the command") {
+		t.Fatalf("Expected the command to be refused, got %v", err)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Fatal("Expected the command not to run")
+	}
+	if points, _ := catalog.New(refused.Backend, refused.Prefix).RestorePoints(); len(points) != 0 {
+		t.Errorf("Expected no restore points, got %+v", points)
+	}
+
+	// A verified backup is tagged and the command runs with the label and run ID
+	verified := safeguardBackup(t, filepath.Join(dir, "verified"), safeguardDump, "")
+	if err := safeguard.Run([]safeguard.Backup{verified}, opts, logger); err != nil {
+		t.Fatalf("Safeguard run failed: %v", err)
+	}
+	data, err := os.ReadFile(marker)
+	if err != nil || string(data) != "pre-migration 01HM7Z8X4T2V6C9R3K5N1QWJBE\n" {
+		t.Errorf("Expected the command to run with the label and run ID, got %q (%v)", data, err)
+	}
+	point, err := catalog.New(verified.Backend, verified.Prefix).Get("pre-migration-orders")
+	if err != nil || point == nil || !strings.HasSuffix(point.Key, "orders_2024-01-15_02-00-00.sql") {
+		t.Errorf("Expected the backup to be tagged pre-migration-orders, got %+v (%v)", point, err)
+	}
+}
@@ -0,0 +1,91 @@
+package unit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/config"
+)
+
+const strictConfig = `{
+  "$schema": "./appsettings.schema.json",
+  "databases": [
+    {
+      "host": "localhost",
+      "port": "5432",
+      "username": "user",
+      "password": "pass",
+      "database": "app"
+    }
+  ],
+  "local": {"path": "/tmp/backups"},
+  "backup": {
+    "retension_days": 7
+  },
+  "profiles": {
+    "prod": {
+      "extends": "",
+      "logging": {"levle": "warn"}
+    }
+  }
+}`
+
+// TestStrictConfig tests that strict loading reports unknown fields and mistyped values by position
+func TestStrictConfig(t *testing.T) {
+	t.Setenv(config.ProfileEnvVar, "")
+	path := filepath.Join(t.TempDir(), "appsettings.json")
+	if err := os.WriteFile(path, []byte(strictConfig), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	_, err := config.LoadConfig(path, config.LoadOptions{Strict: true})
+	if err == nil {
+		t.Fatal("Expected strict loading to fail")
+	}
+	for _, expected := range []string{
+		path + ":6:15: databases[0].port must be an integer, got string \"5432\"",
+		path + ":14:5: unknown field \"retension_days\" in backup",
+		path + ":19:19: unknown field \"levle\" in profiles.prod.logging",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error to contain %q, got:\n%v", expected, err)
+		}
+	}
+
+	// Without strict mode the unknown fields are ignored
+	relaxed := strings.Replace(strictConfig, `"port": "5432"`, `"port": 5432`, 1)
+	if err := os.WriteFile(path, []byte(relaxed), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if _, err := config.LoadConfig(path, config.LoadOptions{}); err != nil {
+		t.Errorf("Expected relaxed loading to succeed: %v", err)
+	}
+}
+
+// TestConfigSchema tests that the schema describes the configuration and its profiles
+func TestConfigSchema(t *testing.T) {
+	schema := config.Schema()
+	if schema["$schema"] != config.SchemaURL {
+		t.Errorf("Expected $schema %s, got %v", config.SchemaURL, schema["$schema"])
+	}
+
+	properties := schema["properties"].(map[string]any)
+	backup := properties["backup"].(map[string]any)["properties"].(map[string]any)
+	if backup["retention_days"].(map[string]any)["type"] != "integer" {
+		t.Errorf("Expected backup.retention_days to be an integer, got %v", backup["retention_days"])
+	}
+
+	profile := properties["profiles"].(map[string]any)["additionalProperties"].(map[string]any)
+	profileProperties := profile["properties"].(map[string]any)
+	if _, ok := profileProperties["extends"]; !ok {
+		t.Error("Expected profiles to accept extends")
+	}
+	if _, ok := profileProperties["databases"]; !ok {
+		t.Error("Expected profiles to accept every configuration section")
+	}
+	if _, ok := properties["Profile"]; ok {
+		t.Error("Did not expect fields excluded from JSON in the schema")
+	}
+}
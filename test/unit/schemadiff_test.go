@@ -0,0 +1,56 @@
+package unit
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/schemadiff"
+)
+
+// TestSchemaDiff tests comparing the tables and columns of a backup with the live database
+func TestSchemaDiff(t *testing.T) {
+	backup := []schemadiff.Column{
+		{Schema: "public", Table: "orders", Name: "id", Type: "integer"},
+		{Schema: "public", Table: "orders", Name: "total", Type: "integer"},
+		{Schema: "public", Table: "orders", Name: "legacy_code", Type: "text"},
+		{Schema: "public", Table: "audit", Name: "id", Type: "bigint"},
+	}
+	live := []schemadiff.Column{
+		{Schema: "public", Table: "orders", Name: "id", Type: "integer"},
+		{Schema: "public", Table: "orders", Name: "total", Type: "numeric"},
+		{Schema: "public", Table: "orders", Name: "currency", Type: "text"},
+		{Schema: "public", Table: "customers", Name: "id", Type: "bigint"},
+	}
+
+	diff := schemadiff.Compare(backup, live)
+	expected := schemadiff.Diff{
+		AddedTables:    []string{"public.customers"},
+		DroppedTables:  []string{"public.audit"},
+		AddedColumns:   []string{"public.orders.currency"},
+		DroppedColumns: []string{"public.orders.legacy_code"},
+		ChangedColumns: []schemadiff.ColumnChange{{Column: "public.orders.total", BackupType: "integer", LiveType: "numeric"}},
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Unexpected diff:\n got %+v\nwant %+v", diff, expected)
+	}
+
+	var out bytes.Buffer
+	diff.Write(&out)
+	for _, line := range []string{
+		"+ table public.customers",
+		"- table public.audit",
+		"+ column public.orders.currency",
+		"- column public.orders.legacy_code",
+		"~ column public.orders.total: integer in backup, numeric live",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Expected %q in report:\n%s", line, out.String())
+		}
+	}
+
+	if same := schemadiff.Compare(live, live); !same.Empty() {
+		t.Errorf("Expected identical schemas to match, got %+v", same)
+	}
+}
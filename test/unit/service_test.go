@@ -0,0 +1,64 @@
+package unit
+
+import (
+	"strings"
+	"testing"
+
+	"db-backuper/internal/service"
+)
+
+// TestSystemdUnit tests the unit registering the scheduler with systemd
+func TestSystemdUnit(t *testing.T) {
+	def := service.Definition{
+		Name:       "db-backuper",
+		Executable: "/usr/local/bin/db-backuper",
+		Args:       []string{"serve", "-config", "/etc/db backups/appsettings.json", "-profile", "100%"},
+		WorkingDir: "/etc/db backups",
+	}
+	unit := def.SystemdUnit()
+
+	for _, line := range []string{
+		"Type=notify",
+		`ExecStart=/usr/local/bin/db-backuper serve -config "/etc/db backups/appsettings.json" -profile 100%%`,
+		"WorkingDirectory=/etc/db backups",
+		"StateDirectory=db-backuper",
+		"WatchdogSec=60",
+	} {
+		if !strings.Contains(unit, line+"\n") {
+			t.Errorf("Expected unit to contain %q, got:\n%s", line, unit)
+		}
+	}
+}
+
+// TestLaunchdPlist tests the daemon registering the scheduler with launchd
+func TestLaunchdPlist(t *testing.T) {
+	def := service.Definition{
+		Name:       "db-backuper",
+		Executable: "/usr/local/bin/db-backuper",
+		Args:       []string{"serve", "-config", "/etc/backups & more/appsettings.json"},
+		WorkingDir: "/etc/backups & more",
+	}
+	plist := def.LaunchdPlist()
+
+	if !strings.Contains(plist, "<string>/etc/backups &amp; more/appsettings.json</string>") {
+		t.Errorf("Expected escaped program arguments, got:\n%s", plist)
+	}
+	if !strings.Contains(plist, "<key>KeepAlive</key>\n\t<true/>") {
+		t.Errorf("Expected the daemon to be kept alive, got:\n%s", plist)
+	}
+}
+
+// TestServiceName tests the names services may be registered under
+func TestServiceName(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"db-backuper":      true,
+		"db-backuper.prod": true,
+		"":                 false,
+		"db backuper":      false,
+		"../db-backuper":   false,
+	} {
+		if err := service.ValidateName(name); (err == nil) != valid {
+			t.Errorf("ValidateName(%q) = %v, expected valid %v", name, err, valid)
+		}
+	}
+}
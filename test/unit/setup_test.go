@@ -0,0 +1,110 @@
+package unit
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/setup"
+)
+
+// TestSetupWizard tests that the answers of the wizard, including a failed
+// connection entered again and invalid answers asked again, make a
+// configuration file that loads strictly
+func TestSetupWizard(t *testing.T) {
+	t.Setenv(config.ProfileEnvVar, "")
+	dir := t.TempDir()
+	answers := strings.Join([]string{
+		// A database refusing the connection, entered again on another port
+		"", "", "orders", "", "secret", "disable", "y",
+		"", "5433", "orders", "", "secret", "disable",
+		"n",
+		// Local storage
+		"local", filepath.Join(dir, "backups"), "",
+		// An invalid schedule and retention before valid ones
+		"every night", "30 1 * * *", "0", "14",
+	}, "\n") + "\n"
+
+	var connected []int
+	var output bytes.Buffer
+	wizard := setup.New(strings.NewReader(answers), &output, setup.Checks{
+		Database: func(db *config.DatabaseConfig) error {
+			connected = append(connected, db.Port)
+			if db.Port == 5432 {
+				return fmt.Errorf("connection refused")
+			}
+			return nil
+		},
+		Storage: func(cfg *config.Config) error {
+			if cfg.Local.Path != filepath.Join(dir, "backups") {
+				return fmt.Errorf("unexpected path %s", cfg.Local.Path)
+			}
+			return nil
+		},
+	})
+	cfg, err := wizard.Run()
+	if err != nil {
+		t.Fatalf("Wizard failed: %v\n%s", err, output.String())
+	}
+	if len(connected) != 2 {
+		t.Errorf("Expected the connection to be tested twice, got %v", connected)
+	}
+	if !strings.Contains(output.String(), "failed: connection refused") {
+		t.Errorf("Expected the failed check to be reported, got:\n%s", output.String())
+	}
+
+	data, err := setup.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("Failed to encode config: %v", err)
+	}
+	path := filepath.Join(dir, "appsettings.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	loaded, err := config.LoadConfig(path, config.LoadOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("Expected the written config to load, got %v\n%s", err, data)
+	}
+	db := loaded.Databases[0]
+	if len(loaded.Databases) != 1 || db.Host != "localhost" || db.Port != 5433 || db.Username != "postgres" || db.SSLMode != "disable" {
+		t.Errorf("Unexpected databases: %+v", loaded.Databases)
+	}
+	if loaded.Backup.Schedule != "30 1 * * *" || loaded.Backup.RetentionDays != 14 || loaded.Backup.BackupPrefix != setup.DefaultBackupPrefix {
+		t.Errorf("Unexpected backup settings: %+v", loaded.Backup)
+	}
+	if !strings.Contains(string(data), `"config_version": 1`) || strings.Contains(string(data), `"aws"`) {
+		t.Errorf("Expected only the answered settings and the version, got:\n%s", data)
+	}
+}
+
+// TestSetupWizardS3 tests S3 storage assuming a role, a failed storage check
+// kept anyway and input ending before the configuration is complete
+func TestSetupWizardS3(t *testing.T) {
+	answers := strings.Join([]string{
+		"db.internal", "", "users", "backup", "", "secret", "", "n",
+		"s3", "eu-west-1", "company-backups", "y", "arn:aws:iam::123456789012:role/db-backup-writer", "nightly",
+		"n", "", "",
+	}, "\n") + "\n"
+	wizard := setup.New(strings.NewReader(answers), &bytes.Buffer{}, setup.Checks{
+		Storage: func(cfg *config.Config) error { return fmt.Errorf("NoSuchBucket") },
+	})
+	cfg, err := wizard.Run()
+	if err != nil {
+		t.Fatalf("Wizard failed: %v", err)
+	}
+	if cfg.AWS.Bucket != "company-backups" || cfg.AWS.RoleARN == "" || cfg.AWS.AccessKeyID != "" || cfg.Backup.BackupPrefix != "nightly" {
+		t.Errorf("Unexpected AWS settings: %+v", cfg.AWS)
+	}
+	if cfg.Databases[0].SSLMode != "require" || cfg.Backup.Schedule != setup.DefaultSchedule || cfg.Backup.RetentionDays != setup.DefaultRetentionDays {
+		t.Errorf("Expected the defaults, got %+v %+v", cfg.Databases[0], cfg.Backup)
+	}
+
+	wizard = setup.New(strings.NewReader("localhost\n5432\n"), &bytes.Buffer{}, setup.Checks{})
+	if _, err := wizard.Run(); err == nil || !strings.Contains(err.Error(), "input ended") {
+		t.Errorf("Expected an error for incomplete input, got %v", err)
+	}
+}
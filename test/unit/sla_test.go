@@ -0,0 +1,79 @@
+package unit
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/sla"
+	"db-backuper/internal/status"
+
+	"github.com/sirupsen/logrus"
+)
+
+// recordingAlerter records the alerts raised by an SLA monitor
+type recordingAlerter struct {
+	subjects []string
+	bodies   []string
+}
+
+func (a *recordingAlerter) Alert(subject, body string, failed bool) error {
+	a.subjects = append(a.subjects, subject)
+	a.bodies = append(a.bodies, body)
+	return nil
+}
+
+// TestSLAMonitor tests detecting stale backups, databases that never
+// succeeded, and alerting only when violations start or end
+func TestSLAMonitor(t *testing.T) {
+	cfg := &config.Config{Databases: []config.DatabaseConfig{
+		{Database: "orders", SLA: config.SLAConfig{MaxAgeMinutes: 180, MaxRPOMinutes: 150}},
+		{Database: "users", SLA: config.SLAConfig{MaxAgeMinutes: 60}},
+		{Database: "audit"},
+	}}
+
+	now := time.Now()
+	finishedAt, startedAt := now.Add(-2*time.Hour), now.Add(-3*time.Hour)
+	report := &status.Report{Databases: []status.DatabaseResult{
+		{Database: "orders", Status: status.ResultSuccess, LastSuccessAt: &finishedAt, LastSuccessStartedAt: &startedAt},
+	}}
+
+	alerter := &recordingAlerter{}
+	monitor := sla.NewMonitor(cfg, report, alerter, nil, logrus.New())
+	if !monitor.Enabled() {
+		t.Fatal("Expected monitor to be enabled")
+	}
+
+	if violations := monitor.Check(now); len(violations) != 1 || violations[0].Database != "orders" || violations[0].Kind != sla.KindMaxRPO {
+		t.Fatalf("Expected only the RPO of orders to be violated, got %v", violations)
+	}
+
+	// The scheduler never backed up users, which counts from the monitor's start
+	later := now.Add(90 * time.Minute)
+	violations := monitor.Check(later)
+	if len(violations) != 3 {
+		t.Fatalf("Expected 3 violations, got %v", violations)
+	}
+	if !violations[2].NeverSucceeded || !strings.Contains(violations[2].String(), "users: no successful backup") {
+		t.Errorf("Unexpected violation for users: %s", violations[2])
+	}
+
+	monitor.Check(later)
+	if len(alerter.subjects) != 2 {
+		t.Fatalf("Expected one alert per new violation set, got %v", alerter.subjects)
+	}
+	if alerter.subjects[1] != "Backup SLA violated for 2 database(s)" || !strings.Contains(alerter.bodies[1], "orders: last successful backup is 3h30m old, exceeding its max age of 3h") {
+		t.Errorf("Unexpected alert: %s\n%s", alerter.subjects[1], alerter.bodies[1])
+	}
+
+	summary := &status.RunSummary{FinishedAt: later}
+	summary.Add(status.DatabaseResult{Database: "orders", Status: status.ResultSuccess, StartedAt: later.Add(-time.Minute), FinishedAt: later})
+	monitor.Update(summary)
+	if violations := monitor.Check(later); len(violations) != 1 || violations[0].Database != "users" {
+		t.Fatalf("Expected only users to be violated, got %v", violations)
+	}
+	if last := alerter.subjects[len(alerter.subjects)-1]; last != "Backup SLA restored for orders" {
+		t.Errorf("Expected a restored alert, got %q", last)
+	}
+}
@@ -0,0 +1,78 @@
+package unit
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/backup"
+	"db-backuper/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestSQLiteBackup tests that the SQLite engine produces a readable copy of the database
+func TestSQLiteBackup(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 is not installed")
+	}
+
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	seed := exec.Command("sqlite3", dbPath, "CREATE TABLE items (name TEXT); INSERT INTO items VALUES ('one'), ('two');")
+	if output, err := seed.CombinedOutput(); err != nil {
+		t.Fatalf("Failed to create test database: %v: %s", err, output)
+	}
+
+	engine, err := backup.NewEngine(&config.DatabaseConfig{
+		Type:     config.EngineTypeSQLite,
+		Path:     dbPath,
+		Database: "app",
+	}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	if err := engine.TestConnection(); err != nil {
+		t.Fatalf("Connection test failed: %v", err)
+	}
+
+	backupPath, err := engine.CreateBackup()
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	defer engine.CleanupBackup(backupPath)
+
+	if !strings.HasPrefix(filepath.Base(backupPath), "app_") || filepath.Ext(backupPath) != ".sqlite" {
+		t.Errorf("Unexpected backup filename: %s", backupPath)
+	}
+
+	output, err := exec.Command("sqlite3", backupPath, "SELECT count(*) FROM items;").Output()
+	if err != nil {
+		t.Fatalf("Failed to read backup: %v", err)
+	}
+	if strings.TrimSpace(string(output)) != "2" {
+		t.Errorf("Expected 2 rows in backup, got %q", output)
+	}
+
+	if err := engine.CleanupBackup(backupPath); err != nil {
+		t.Errorf("Cleanup failed: %v", err)
+	}
+	if _, err := os.Stat(backupPath); !os.IsNotExist(err) {
+		t.Errorf("Expected backup file to be removed")
+	}
+}
+
+// TestSQLiteBackupMissingFile tests that a missing database file fails the connection test
+func TestSQLiteBackupMissingFile(t *testing.T) {
+	engine := backup.NewSQLiteBackup(&config.DatabaseConfig{
+		Type:     config.EngineTypeSQLite,
+		Path:     filepath.Join(t.TempDir(), "missing.db"),
+		Database: "missing",
+	}, logrus.New())
+
+	if err := engine.TestConnection(); err == nil {
+		t.Error("Expected error for missing database file")
+	}
+}
@@ -1,8 +1,10 @@
 package unit
 
 import (
+	"encoding/base64"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -15,6 +17,7 @@ import (
 
 // TestConfigurationValidation tests configuration validation without database setup
 func TestConfigurationValidation(t *testing.T) {
+	disabled := false
 	tests := []struct {
 		name        string
 		config      *config.Config
@@ -157,6 +160,1392 @@ func TestConfigurationValidation(t *testing.T) {
 			},
 			expectError: true,
 		},
+		{
+			name: "Valid SQLite database without connection settings",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Type:     config.EngineTypeSQLite,
+						Path:     "/var/lib/app/app.db",
+						Database: "app",
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "SQLite database without path",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Type:     config.EngineTypeSQLite,
+						Database: "app",
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Redis database without host",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Type:     config.EngineTypeRedis,
+						Database: "cache",
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "SQL Server native backup without server_backup_dir",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Type:     config.EngineTypeMSSQL,
+						Host:     "sql",
+						Username: "sa",
+						Password: "pass",
+						Database: "orders",
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "SQL Server bacpac export",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Type:     config.EngineTypeMSSQL,
+						Host:     "sql",
+						Username: "sa",
+						Password: "pass",
+						Database: "orders",
+						MSSQL: config.MSSQLConfig{
+							Method: config.MSSQLMethodBacpac,
+						},
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "Command database without dump template",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Type:     config.EngineTypeCommand,
+						Database: "custom",
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Backup group with unknown database",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Username: "user",
+						Password: "pass",
+						Database: "orders",
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+				Groups: []config.GroupConfig{
+					{Name: "commerce", Databases: []string{"orders", "billing"}},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Shared snapshot group with non-PostgreSQL member",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Username: "user",
+						Password: "pass",
+						Database: "orders",
+					},
+					{
+						Type:     config.EngineTypeFilesystem,
+						Path:     "/srv/uploads",
+						Database: "uploads",
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+				Groups: []config.GroupConfig{
+					{Name: "app", Databases: []string{"orders", "uploads"}, SharedSnapshot: true},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Quiesce on a non-PostgreSQL database",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Type:    config.EngineTypeSQLite,
+						Path:    "/var/lib/app/app.db",
+						Quiesce: config.QuiesceConfig{PreSQL: []string{"SELECT 1"}},
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Parallel dump jobs with the SQL format",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+						Postgres: config.PostgresConfig{DumpJobs: 4},
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Parallel dump jobs with the directory format",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+						Postgres: config.PostgresConfig{Format: config.PostgresFormatDirectory, DumpJobs: 4},
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+				Backup: config.BackupConfig{
+					RetentionDays: 7,
+					Schedule:      "0 2 * * *",
+					BackupPrefix:  "test-backup",
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "IAM authentication with a static password",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "orders.abc123.eu-west-1.rds.amazonaws.com",
+						Port:     5432,
+						Username: "backup",
+						Password: "pass",
+						Database: "orders",
+						SSLMode:  "require",
+						IAMAuth:  true,
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "IAM authentication without SSL",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "orders.abc123.eu-west-1.rds.amazonaws.com",
+						Port:     5432,
+						Username: "backup",
+						Database: "orders",
+						SSLMode:  "disable",
+						IAMAuth:  true,
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "IAM authentication",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:      "orders.abc123.eu-west-1.rds.amazonaws.com",
+						Port:      5432,
+						Username:  "backup",
+						Database:  "orders",
+						SSLMode:   "require",
+						IAMAuth:   true,
+						IAMRegion: "eu-west-1",
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+				Backup: config.BackupConfig{
+					RetentionDays: 7,
+					Schedule:      "0 2 * * *",
+					BackupPrefix:  "test-backup",
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "AWS S3 with an assumed role instead of static keys",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				AWS: config.AWSConfig{
+					Region:     "us-east-1",
+					Bucket:     "test-bucket",
+					RoleARN:    "arn:aws:iam::123456789012:role/db-backup",
+					ExternalID: "backup-prod",
+				},
+				Backup: config.BackupConfig{
+					RetentionDays: 7,
+					Schedule:      "0 2 * * *",
+					BackupPrefix:  "test-backup",
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "AWS external ID without a role",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				AWS: config.AWSConfig{
+					Region:          "us-east-1",
+					Bucket:          "test-bucket",
+					AccessKeyID:     "test-key",
+					SecretAccessKey: "test-secret",
+					ExternalID:      "backup-prod",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Storage path override with AWS S3",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+						Storage:  config.StorageConfig{Path: "/srv/regulated"},
+					},
+				},
+				AWS: config.AWSConfig{
+					Region:          "us-east-1",
+					Bucket:          "test-bucket",
+					AccessKeyID:     "test-key",
+					SecretAccessKey: "test-secret",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Storage bucket override with local storage",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+						Storage:  config.StorageConfig{Bucket: "regulated-bucket"},
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Storage bucket and prefix override with AWS S3",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+						Storage:  config.StorageConfig{Bucket: "regulated-bucket", Prefix: "pci"},
+					},
+				},
+				AWS: config.AWSConfig{
+					Region:          "us-east-1",
+					Bucket:          "test-bucket",
+					AccessKeyID:     "test-key",
+					SecretAccessKey: "test-secret",
+				},
+				Backup: config.BackupConfig{
+					RetentionDays: 7,
+					Schedule:      "0 2 * * *",
+					BackupPrefix:  "test-backup",
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "Negative incomplete upload age",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				AWS: config.AWSConfig{
+					Region:                      "us-east-1",
+					Bucket:                      "test-bucket",
+					AccessKeyID:                 "test-key",
+					SecretAccessKey:             "test-secret",
+					AbortIncompleteUploadsHours: -1,
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Share TTL beyond seven days",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				AWS: config.AWSConfig{
+					Region:          "us-east-1",
+					Bucket:          "test-bucket",
+					AccessKeyID:     "test-key",
+					SecretAccessKey: "test-secret",
+					ShareTTLHours:   169,
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Negative list request rate",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				AWS: config.AWSConfig{
+					Region:                "us-east-1",
+					Bucket:                "test-bucket",
+					AccessKeyID:           "test-key",
+					SecretAccessKey:       "test-secret",
+					ListRequestsPerSecond: -1,
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Unknown local transfer mode",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Local: config.LocalConfig{
+					Path:     "/tmp/backups",
+					Transfer: "reflink",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Invalid local file mode",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Local: config.LocalConfig{
+					Path:     "/tmp/backups",
+					FileMode: "rw-r-----",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Invalid local file owner",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Local: config.LocalConfig{
+					Path:      "/tmp/backups",
+					FileOwner: "backup:backup",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Unsupported notification channel type",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+				Notifications: config.NotificationsConfig{
+					Channels: []config.ChannelConfig{{Type: "pager", URL: "https://example.com/hook"}},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Notification channel without a URL",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+				Notifications: config.NotificationsConfig{
+					Channels: []config.ChannelConfig{{Type: config.ChannelSlack}},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Escalation channel without escalate_after",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+				Notifications: config.NotificationsConfig{
+					Channels: []config.ChannelConfig{{Type: config.ChannelTeams, URL: "https://example.com/hook", On: config.NotifyOnEscalation}},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "CloudWatch metrics without a region",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+				Metrics: config.MetricsConfig{
+					CloudWatch: config.CloudWatchConfig{Namespace: "DBBackup"},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Invalid StatsD address",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+				Metrics: config.MetricsConfig{
+					StatsD: config.StatsDConfig{Address: "localhost"},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Negative SLA limit",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Type: config.EngineTypeSQLite,
+						Path: "/var/lib/app/app.db",
+						SLA:  config.SLAConfig{MaxAgeMinutes: -60},
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Disabled database with incomplete settings",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Type:     config.EngineTypeSQLite,
+						Database: "app",
+						Path:     "/var/lib/app/app.db",
+					},
+					{
+						Enabled:  &disabled,
+						Database: "legacy",
+						Host:     "legacy-db",
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "Every database disabled",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Enabled: &disabled,
+						Type:    config.EngineTypeSQLite,
+						Path:    "/var/lib/app/app.db",
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "File uploads with the SQL format",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+						Postgres: config.PostgresConfig{UploadFiles: true},
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "File uploads with the directory format",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+						Postgres: config.PostgresConfig{Format: config.PostgresFormatDirectory, UploadFiles: true},
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+				Backup: config.BackupConfig{
+					RetentionDays: 7,
+					Schedule:      "0 2 * * *",
+					BackupPrefix:  "test-backup",
+					TransferJobs:  16,
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "Negative transfer jobs",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Backup: config.BackupConfig{
+					RetentionDays: 7,
+					Schedule:      "0 2 * * *",
+					BackupPrefix:  "test-backup",
+					TransferJobs:  -1,
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "API user with a plaintext token",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Backup: config.BackupConfig{
+					RetentionDays: 7,
+					Schedule:      "0 2 * * *",
+					BackupPrefix:  "test-backup",
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+				API: config.APIConfig{
+					Users: []config.APIUser{
+						{Name: "alice", TokenSHA256: "secret-token"},
+					},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Restore approval without a second user",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Backup: config.BackupConfig{
+					RetentionDays: 7,
+					Schedule:      "0 2 * * *",
+					BackupPrefix:  "test-backup",
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+				API: config.APIConfig{
+					Users: []config.APIUser{
+						{Name: "alice", TokenSHA256: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", Approver: true},
+					},
+					RestoreApproval: true,
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Negative S3 price",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Backup: config.BackupConfig{
+					RetentionDays: 7,
+					Schedule:      "0 2 * * *",
+					BackupPrefix:  "test-backup",
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+				Pricing: config.PricingConfig{
+					S3: map[string]float64{"GLACIER_IR": -0.004},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Unknown S3 storage class price",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Backup: config.BackupConfig{
+					RetentionDays: 7,
+					Schedule:      "0 2 * * *",
+					BackupPrefix:  "test-backup",
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+				Pricing: config.PricingConfig{
+					S3: map[string]float64{"GLACIER_INSTANT": 0.004},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Valid S3 lifecycle rules",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				AWS: config.AWSConfig{
+					Region:          "us-east-1",
+					Bucket:          "test-bucket",
+					AccessKeyID:     "test-key",
+					SecretAccessKey: "test-secret",
+					Lifecycle: config.LifecycleConfig{
+						Transitions: []config.LifecycleTransition{
+							{Days: 30, StorageClass: "STANDARD_IA"},
+							{Days: 90, StorageClass: "GLACIER_IR"},
+						},
+						ExpirationDays: 365,
+					},
+				},
+				Backup: config.BackupConfig{
+					RetentionDays: 7,
+					Schedule:      "0 2 * * *",
+					BackupPrefix:  "test-backup",
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "S3 lifecycle expiration within retention",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				AWS: config.AWSConfig{
+					Region:          "us-east-1",
+					Bucket:          "test-bucket",
+					AccessKeyID:     "test-key",
+					SecretAccessKey: "test-secret",
+					Lifecycle: config.LifecycleConfig{
+						ExpirationDays: 7,
+					},
+				},
+				Backup: config.BackupConfig{
+					RetentionDays: 7,
+					Schedule:      "0 2 * * *",
+					BackupPrefix:  "test-backup",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "S3 lifecycle transitions out of order",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				AWS: config.AWSConfig{
+					Region:          "us-east-1",
+					Bucket:          "test-bucket",
+					AccessKeyID:     "test-key",
+					SecretAccessKey: "test-secret",
+					Lifecycle: config.LifecycleConfig{
+						Transitions: []config.LifecycleTransition{
+							{Days: 90, StorageClass: "GLACIER_IR"},
+							{Days: 30, StorageClass: "STANDARD_IA"},
+						},
+					},
+				},
+				Backup: config.BackupConfig{
+					RetentionDays: 7,
+					Schedule:      "0 2 * * *",
+					BackupPrefix:  "test-backup",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Invalid S3 default encryption",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				AWS: config.AWSConfig{
+					Region:            "us-east-1",
+					Bucket:            "test-bucket",
+					AccessKeyID:       "test-key",
+					SecretAccessKey:   "test-secret",
+					DefaultEncryption: "aws:kms:dsse-unknown",
+				},
+				Backup: config.BackupConfig{
+					RetentionDays: 7,
+					Schedule:      "0 2 * * *",
+					BackupPrefix:  "test-backup",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "KMS key without KMS default encryption",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				AWS: config.AWSConfig{
+					Region:            "us-east-1",
+					Bucket:            "test-bucket",
+					AccessKeyID:       "test-key",
+					SecretAccessKey:   "test-secret",
+					DefaultEncryption: "AES256",
+					KMSKeyID:          "alias/backups",
+				},
+				Backup: config.BackupConfig{
+					RetentionDays: 7,
+					Schedule:      "0 2 * * *",
+					BackupPrefix:  "test-backup",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Negative concurrent jobs",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Backup: config.BackupConfig{
+					RetentionDays:     7,
+					Schedule:          "0 2 * * *",
+					BackupPrefix:      "test-backup",
+					MaxConcurrentJobs: -1,
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Negative split size",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Backup: config.BackupConfig{
+					RetentionDays: 7,
+					Schedule:      "0 2 * * *",
+					BackupPrefix:  "test-backup",
+					SplitSizeGB:   -1,
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Backslashes in backup prefix",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Backup: config.BackupConfig{
+					RetentionDays: 7,
+					Schedule:      "0 2 * * *",
+					BackupPrefix:  `team\backups`,
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Storage plugin",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Backup: config.BackupConfig{
+					RetentionDays: 7,
+					Schedule:      "0 2 * * *",
+				},
+				StoragePlugin: config.StoragePluginConfig{
+					Name: "b2",
+					Args: []string{"--bucket", "backups"},
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "Storage plugin and local storage",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Backup: config.BackupConfig{
+					RetentionDays: 7,
+					Schedule:      "0 2 * * *",
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+				StoragePlugin: config.StoragePluginConfig{
+					Name: "b2",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Storage plugin name with a path",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Backup: config.BackupConfig{
+					RetentionDays: 7,
+					Schedule:      "0 2 * * *",
+				},
+				StoragePlugin: config.StoragePluginConfig{
+					Name: "../b2",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Plugin notification channel",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Backup: config.BackupConfig{
+					RetentionDays: 7,
+					Schedule:      "0 2 * * *",
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+				Notifications: config.NotificationsConfig{
+					Channels: []config.ChannelConfig{{Type: config.ChannelPlugin, Plugin: "pagerduty", Args: []string{"--severity", "critical"}}},
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "Plugin notification channel without a plugin",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Backup: config.BackupConfig{
+					RetentionDays: 7,
+					Schedule:      "0 2 * * *",
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+				Notifications: config.NotificationsConfig{
+					Channels: []config.ChannelConfig{{Type: config.ChannelPlugin, URL: "https://example.com/hook"}},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Negative run budget",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Type: config.EngineTypeSQLite,
+						Path: "/var/lib/app/app.db",
+					},
+				},
+				Backup: config.BackupConfig{
+					MaxRunMinutes: -5,
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Dump verification with the directory format",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+						Postgres: config.PostgresConfig{Format: config.PostgresFormatDirectory, VerifyDump: true},
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Change capture with wal2json",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+						Postgres: config.PostgresConfig{ChangeCapture: config.ChangeCaptureConfig{Enabled: true}},
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "Change capture with pgoutput without a publication",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+						Postgres: config.PostgresConfig{ChangeCapture: config.ChangeCaptureConfig{Enabled: true, Plugin: config.ChangeCapturePluginPgoutput}},
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Change capture with an invalid slot name",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+						Postgres: config.PostgresConfig{ChangeCapture: config.ChangeCaptureConfig{Enabled: true, Slot: "Orders-Slot"}},
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Change capture with an unknown plugin",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+						Postgres: config.PostgresConfig{ChangeCapture: config.ChangeCaptureConfig{Enabled: true, Plugin: "test_decoding"}},
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Valid subset rules",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+				Subset: config.SubsetConfig{Roots: []config.SubsetRoot{{Table: "customers", Where: "country = 'NL'", Limit: 100}}, FollowChildren: true, ChildLimit: 1000},
+			},
+			expectError: false,
+		},
+		{
+			name: "Subset root without a table",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+				Subset: config.SubsetConfig{Roots: []config.SubsetRoot{{Where: "id < 100"}}},
+			},
+			expectError: true,
+		},
+		{
+			name: "Subset child limit without following children",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+				Subset: config.SubsetConfig{Roots: []config.SubsetRoot{{Table: "customers"}}, ChildLimit: 1000},
+			},
+			expectError: true,
+		},
+		{
+			name: "Import transform with an invalid expression",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Host:     "localhost",
+						Port:     5432,
+						Username: "user",
+						Password: "pass",
+						Database: "testdb",
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+				Import: config.ImportConfig{Transforms: []config.TransformRule{{Match: "^SET (transaction_timeout"}}},
+			},
+			expectError: true,
+		},
+		{
+			name: "Unsupported database type",
+			config: &config.Config{
+				Databases: []config.DatabaseConfig{
+					{
+						Type:     "oracle",
+						Database: "app",
+					},
+				},
+				Local: config.LocalConfig{
+					Path: "/tmp/backups",
+				},
+			},
+			expectError: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -175,11 +1564,7 @@ func TestConfigurationValidation(t *testing.T) {
 // TestLocalStorageOperations tests local storage operations without database setup
 func TestLocalStorageOperations(t *testing.T) {
 	// Create temporary directory for testing
-	tempDir := "/tmp/test-local-storage"
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		t.Fatalf("Failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(tempDir)
+	tempDir := t.TempDir()
 
 	// Create local storage instance
 	localStorage, err := storage.NewLocalStorage(&config.LocalConfig{
@@ -202,7 +1587,7 @@ func TestLocalStorageOperations(t *testing.T) {
 	}
 
 	// Save backup
-	backupPath, err := localStorage.SaveBackup(testFile, "test-backup", "testdb")
+	backupPath, err := localStorage.SaveBackup(testFile, "test-backup", "testdb", nil)
 	if err != nil {
 		t.Fatalf("Failed to save backup: %v", err)
 	}
@@ -226,11 +1611,7 @@ func TestLocalStorageOperations(t *testing.T) {
 // TestLocalStorageCleanup tests local storage cleanup functionality without database setup
 func TestLocalStorageCleanup(t *testing.T) {
 	// Create temporary directory for testing
-	tempDir := "/tmp/test-cleanup"
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		t.Fatalf("Failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(tempDir)
+	tempDir := t.TempDir()
 
 	// Create local storage instance
 	localStorage, err := storage.NewLocalStorage(&config.LocalConfig{
@@ -275,7 +1656,7 @@ func TestLocalStorageCleanup(t *testing.T) {
 	}
 
 	// Run cleanup with 1 day retention
-	if err := localStorage.DeleteOldBackups("test-backup", 1); err != nil {
+	if _, err := localStorage.DeleteOldBackups("test-backup", 1); err != nil {
 		t.Fatalf("Failed to cleanup old backups: %v", err)
 	}
 
@@ -289,3 +1670,195 @@ func TestLocalStorageCleanup(t *testing.T) {
 		t.Errorf("New backup file should still exist after cleanup")
 	}
 }
+
+// TestFilterDatabases tests restricting a run to selected databases
+func TestFilterDatabases(t *testing.T) {
+	newConfig := func() *config.Config {
+		return &config.Config{
+			Databases: []config.DatabaseConfig{
+				{Host: "localhost", Username: "user", Password: "pass", Database: "orders"},
+				{Host: "localhost", Username: "user", Password: "pass", Database: "users"},
+				{Host: "localhost", Username: "user", Password: "pass", Database: "events"},
+			},
+		}
+	}
+
+	// No names keeps every database
+	cfg := newConfig()
+	if err := cfg.FilterDatabases(nil); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if len(cfg.Databases) != 3 {
+		t.Errorf("Expected 3 databases, got %d", len(cfg.Databases))
+	}
+
+	// Selected names are kept in the requested order
+	cfg = newConfig()
+	if err := cfg.FilterDatabases([]string{"events", "orders"}); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if len(cfg.Databases) != 2 {
+		t.Fatalf("Expected 2 databases, got %d", len(cfg.Databases))
+	}
+	if cfg.Databases[0].Database != "events" || cfg.Databases[1].Database != "orders" {
+		t.Errorf("Unexpected databases after filter: %s, %s", cfg.Databases[0].Database, cfg.Databases[1].Database)
+	}
+
+	// Unknown names are rejected
+	cfg = newConfig()
+	if err := cfg.FilterDatabases([]string{"missing"}); err == nil {
+		t.Errorf("Expected error for unknown database but got none")
+	}
+}
+
+// TestDatabasesByPriority tests ordering databases by priority, keeping configuration order for ties
+func TestDatabasesByPriority(t *testing.T) {
+	cfg := &config.Config{
+		Databases: []config.DatabaseConfig{
+			{Database: "events"},
+			{Database: "orders", Priority: 10},
+			{Database: "audit", Priority: -5},
+			{Database: "users"},
+			{Database: "payments", Priority: 10},
+		},
+	}
+
+	var names []string
+	for _, db := range cfg.DatabasesByPriority() {
+		names = append(names, db.Database)
+	}
+	if got := strings.Join(names, ","); got != "orders,payments,events,users,audit" {
+		t.Errorf("Unexpected backup order: %s", got)
+	}
+	if cfg.Databases[0].Database != "events" {
+		t.Errorf("Expected the configuration order to be left unchanged")
+	}
+}
+
+// TestStorageFor tests resolving per-database storage overrides
+func TestStorageFor(t *testing.T) {
+	cfg := &config.Config{
+		Databases: []config.DatabaseConfig{
+			{Database: "orders"},
+			{Database: "payments", Storage: config.StorageConfig{Bucket: "regulated-bucket", Prefix: "pci"}},
+		},
+		AWS:    config.AWSConfig{Bucket: "shared-bucket"},
+		Backup: config.BackupConfig{BackupPrefix: "db-backup"},
+	}
+
+	orders := cfg.StorageFor("orders")
+	if orders.Bucket != "shared-bucket" || orders.Prefix != "db-backup" {
+		t.Errorf("Expected default storage for orders, got %+v", orders)
+	}
+
+	payments := cfg.StorageFor("payments")
+	if payments.Bucket != "regulated-bucket" || payments.Prefix != "pci" {
+		t.Errorf("Expected overridden storage for payments, got %+v", payments)
+	}
+
+	unknown := cfg.StorageFor("missing")
+	if unknown.Bucket != "shared-bucket" || unknown.Prefix != "db-backup" {
+		t.Errorf("Expected default storage for unknown database, got %+v", unknown)
+	}
+}
+
+// TestBackupStateDirectory tests the default directory for state kept across restarts
+func TestBackupStateDirectory(t *testing.T) {
+	backupConfig := config.BackupConfig{}
+	if dir := backupConfig.StateDirectory(); dir != config.DefaultStateDir {
+		t.Errorf("Expected default state directory %s, got %s", config.DefaultStateDir, dir)
+	}
+	// State is lost with the temporary directory on reboot, so only Lambda keeps it there
+	if os.Getenv("AWS_LAMBDA_FUNCTION_NAME") == "" && strings.HasPrefix(config.DefaultStateDir, os.TempDir()) {
+		t.Errorf("Expected the default state directory outside %s, got %s", os.TempDir(), config.DefaultStateDir)
+	}
+
+	backupConfig.StateDir = "/srv/db-backuper"
+	if dir := backupConfig.StateDirectory(); dir != "/srv/db-backuper" {
+		t.Errorf("Expected configured state directory, got %s", dir)
+	}
+}
+
+// TestAWSCustomerKey tests decoding the SSE-C key and keeping it out of logs
+func TestAWSCustomerKey(t *testing.T) {
+	awsConfig := config.AWSConfig{}
+	if key, err := awsConfig.CustomerKey(); key != nil || err != nil {
+		t.Errorf("Expected no key when none is configured, got %v (%v)", key, err)
+	}
+
+	awsConfig.SSECustomerKey = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	key, err := awsConfig.CustomerKey()
+	if err != nil || string(key) != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("Expected the decoded 256-bit key, got %q (%v)", key, err)
+	}
+
+	cfg := &config.Config{AWS: awsConfig}
+	if !slices.Contains(cfg.Secrets(), awsConfig.SSECustomerKey) {
+		t.Errorf("Expected the customer key to be redacted from logs")
+	}
+
+	awsConfig.SSEPreviousKeys = []string{base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))}
+	previous, err := awsConfig.PreviousCustomerKeys()
+	if err != nil || len(previous) != 1 || string(previous[0]) != "fedcba9876543210fedcba9876543210" {
+		t.Errorf("Expected the decoded previous key, got %q (%v)", previous, err)
+	}
+	cfg = &config.Config{AWS: awsConfig}
+	if !slices.Contains(cfg.Secrets(), awsConfig.SSEPreviousKeys[0]) {
+		t.Errorf("Expected previous keys to be redacted from logs")
+	}
+
+	awsConfig.SSECustomerKey = base64.StdEncoding.EncodeToString([]byte("too-short"))
+	if _, err := awsConfig.CustomerKey(); err == nil {
+		t.Errorf("Expected a key that is not 256 bits to be rejected")
+	}
+	awsConfig.SSEPreviousKeys = []string{"not base64!"}
+	if _, err := awsConfig.PreviousCustomerKeys(); err == nil {
+		t.Errorf("Expected an invalid previous key to be rejected")
+	}
+}
+
+// TestLocalStorageModTimeCleanup tests aging out files outside the date layout by modification time
+func TestLocalStorageModTimeCleanup(t *testing.T) {
+	root := t.TempDir()
+	localStorage, err := storage.NewLocalStorage(&config.LocalConfig{Path: root}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+	localStorage.SetModTimeRetention(true)
+
+	old := time.Now().AddDate(0, 0, -5)
+	write := func(rel string, modTime time.Time) string {
+		path := filepath.Join(root, "test-backup", filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("backup"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", rel, err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set modification time: %v", err)
+		}
+		return path
+	}
+
+	legacyOld := write("testdb/legacy_backup.sql", old)
+	legacyNew := write("testdb/manual_copy.sql", time.Now())
+	looseOld := write("stray.sql.gz", old)
+	catalog := write("_catalog/catalog.json", old)
+	dated := write("testdb/"+time.Now().Format("2006-01-02")+"/testdb_copied.sql", old)
+
+	if _, err := localStorage.DeleteOldBackups("test-backup", 1); err != nil {
+		t.Fatalf("Failed to cleanup old backups: %v", err)
+	}
+
+	for _, path := range []string{legacyOld, looseOld} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be deleted", path)
+		}
+	}
+	for _, path := range []string{legacyNew, catalog, dated} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to be kept: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package unit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/progress"
+	"db-backuper/internal/status"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestStatusFileUpdate tests that the status file keeps the latest result per database
+func TestStatusFileUpdate(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "status-file")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	statusPath := filepath.Join(tempDir, "nested", "status.json")
+	writer := status.NewWriter(&config.StatusConfig{Path: statusPath}, nil, logrus.New())
+
+	firstRun := time.Date(2024, 1, 15, 2, 0, 0, 0, time.UTC)
+	first := &status.RunSummary{RunID: "run-1", StartedAt: firstRun, FinishedAt: firstRun.Add(time.Minute)}
+	first.Add(status.DatabaseResult{Database: "orders", Status: status.ResultSuccess, StartedAt: firstRun, FinishedAt: firstRun.Add(30 * time.Second), SizeBytes: 1024})
+	first.Add(status.DatabaseResult{Database: "users", Status: status.ResultSuccess, FinishedAt: firstRun.Add(time.Minute)})
+	if err := writer.Update(first); err != nil {
+		t.Fatalf("Failed to write status file: %v", err)
+	}
+
+	// Second run only covers orders, and it fails
+	secondRun := firstRun.Add(3 * time.Hour)
+	second := &status.RunSummary{RunID: "run-2", StartedAt: secondRun, FinishedAt: secondRun.Add(time.Minute)}
+	second.Add(status.DatabaseResult{Database: "orders", Status: status.ResultFailed, FinishedAt: secondRun.Add(time.Minute), Error: "connection refused"})
+	if err := writer.Update(second); err != nil {
+		t.Fatalf("Failed to update status file: %v", err)
+	}
+
+	data, err := os.ReadFile(statusPath)
+	if err != nil {
+		t.Fatalf("Failed to read status file: %v", err)
+	}
+
+	var report status.Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("Failed to decode status file: %v", err)
+	}
+
+	if report.SchemaVersion != status.SchemaVersion {
+		t.Errorf("Expected schema version %d, got %d", status.SchemaVersion, report.SchemaVersion)
+	}
+	if report.LastRun.RunID != "run-2" || report.LastRun.Failed != 1 {
+		t.Errorf("Unexpected last run: %+v", report.LastRun)
+	}
+	if len(report.Databases) != 2 {
+		t.Fatalf("Expected 2 databases, got %d", len(report.Databases))
+	}
+
+	orders := report.Databases[0]
+	if orders.Database != "orders" || orders.Status != status.ResultFailed {
+		t.Errorf("Expected orders to be failed, got %+v", orders)
+	}
+	if orders.LastSuccessAt == nil || !orders.LastSuccessAt.Equal(firstRun.Add(30*time.Second)) {
+		t.Errorf("Expected orders last success to carry over, got %v", orders.LastSuccessAt)
+	}
+	if orders.LastSuccessStartedAt == nil || !orders.LastSuccessStartedAt.Equal(firstRun) {
+		t.Errorf("Expected orders recovery point to carry over, got %v", orders.LastSuccessStartedAt)
+	}
+
+	users := report.Databases[1]
+	if users.Database != "users" || users.Status != status.ResultSuccess {
+		t.Errorf("Expected users to keep its previous success, got %+v", users)
+	}
+}
+
+// TestStatusMergeDisabled tests that disabled databases keep their last result, marked as disabled
+func TestStatusMergeDisabled(t *testing.T) {
+	finishedAt := time.Date(2024, 1, 15, 2, 0, 0, 0, time.UTC)
+	first := &status.RunSummary{RunID: "run-1", FinishedAt: finishedAt}
+	first.Add(status.DatabaseResult{Database: "orders", Status: status.ResultSuccess, FinishedAt: finishedAt})
+	report := status.Merge(nil, first)
+
+	second := &status.RunSummary{RunID: "run-2", FinishedAt: finishedAt.Add(time.Hour), Disabled: []string{"legacy", "orders"}}
+	report = status.Merge(report, second)
+	if len(report.Databases) != 2 {
+		t.Fatalf("Expected 2 databases, got %+v", report.Databases)
+	}
+
+	legacy, orders := report.Databases[0], report.Databases[1]
+	if !legacy.Disabled || legacy.Status != status.ResultDisabled {
+		t.Errorf("Expected legacy to be listed as disabled, got %+v", legacy)
+	}
+	if !orders.Disabled || orders.Status != status.ResultSuccess || orders.LastSuccessAt == nil {
+		t.Errorf("Expected orders to keep its last success while disabled, got %+v", orders)
+	}
+
+	third := &status.RunSummary{RunID: "run-3", FinishedAt: finishedAt.Add(2 * time.Hour)}
+	third.Add(status.DatabaseResult{Database: "orders", Status: status.ResultSuccess, FinishedAt: finishedAt.Add(2 * time.Hour)})
+	if orders := status.Merge(report, third).Databases[1]; orders.Disabled {
+		t.Errorf("Expected orders to be enabled again, got %+v", orders)
+	}
+}
+
+// TestRunSummarySkipped tests that databases skipped by the run budget count as unsuccessful
+func TestRunSummarySkipped(t *testing.T) {
+	summary := &status.RunSummary{RunID: "run-1"}
+	summary.Add(status.DatabaseResult{Database: "orders", Status: status.ResultSuccess})
+	summary.Add(status.SkippedResult("analytics", "run budget of 1h0m0s exhausted"))
+	if summary.Successful != 1 || summary.Failed != 0 || summary.Skipped != 1 {
+		t.Errorf("Unexpected counts: %+v", summary)
+	}
+	if summary.Unsuccessful() != 1 {
+		t.Errorf("Expected the skipped database to be unsuccessful, got %d", summary.Unsuccessful())
+	}
+
+	report := status.Merge(nil, summary)
+	analytics := report.Databases[0]
+	if analytics.Status != status.ResultSkipped || analytics.Error == "" || analytics.LastSuccessAt != nil {
+		t.Errorf("Unexpected skipped result: %+v", analytics)
+	}
+}
+
+// TestStatusRestoreTimes tests that measured restores are kept per database,
+// survive later backup runs and set the restore time objective
+func TestStatusRestoreTimes(t *testing.T) {
+	statusPath := filepath.Join(t.TempDir(), "status.json")
+	writer := status.NewWriter(&config.StatusConfig{Path: statusPath}, nil, logrus.New())
+
+	start := time.Date(2024, 1, 15, 2, 0, 0, 0, time.UTC)
+	for i := range status.MaxRestoreSamples + 2 {
+		// The slowest restore is the oldest and falls out of the window
+		duration := float64(60 + i)
+		if i == 0 {
+			duration = 3600
+		}
+		sample := status.RestoreSample{Source: status.RestoreSourceRehearsal, FinishedAt: start.Add(time.Duration(i) * time.Hour), DurationSeconds: duration}
+		if err := writer.RecordRestore("orders", sample); err != nil {
+			t.Fatalf("Failed to record restore: %v", err)
+		}
+	}
+
+	run := &status.RunSummary{RunID: "run-1", StartedAt: start.Add(24 * time.Hour), FinishedAt: start.Add(25 * time.Hour)}
+	run.Add(status.DatabaseResult{Database: "orders", Status: status.ResultSuccess, FinishedAt: run.FinishedAt})
+	if err := writer.Update(run); err != nil {
+		t.Fatalf("Failed to update status file: %v", err)
+	}
+
+	report := writer.Load()
+	if report == nil || len(report.Databases) != 1 {
+		t.Fatalf("Expected one database in the status file, got %+v", report)
+	}
+	orders := report.Databases[0]
+	if orders.Status != status.ResultSuccess {
+		t.Errorf("Expected the backup result to be kept, got %q", orders.Status)
+	}
+	if len(orders.Restores) != status.MaxRestoreSamples {
+		t.Errorf("Expected %d restores to be kept, got %d", status.MaxRestoreSamples, len(orders.Restores))
+	}
+	if orders.RTOSeconds != 71 {
+		t.Errorf("Expected the slowest recent restore as RTO, got %v", orders.RTOSeconds)
+	}
+}
+
+// oneTimeRun is a run of one successful and one failed backup
+func oneTimeRun() *status.RunSummary {
+	started := time.Date(2024, 1, 15, 2, 0, 0, 0, time.UTC)
+	summary := &status.RunSummary{RunID: "run-1", StartedAt: started, FinishedAt: started.Add(95 * time.Second), Storage: "s3://backups"}
+	summary.Add(status.DatabaseResult{Database: "orders", Status: status.ResultSuccess, DurationSeconds: 90.4, SizeBytes: 3 << 20, Location: "db-backup/orders/2024-01-15/orders_2024-01-15_02-00-00.sql"})
+	summary.Add(status.DatabaseResult{Database: "users", Status: status.ResultFailed, DurationSeconds: 0.25, Error: "connection refused"})
+	return summary
+}
+
+// TestPrintRunResult tests the table printed on exit of a one-time run
+func TestPrintRunResult(t *testing.T) {
+	var out bytes.Buffer
+	status.PrintRunResult(&out, status.NewRunResult("", oneTimeRun()))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected a header, two databases and the totals, got:\n%s", out.String())
+	}
+	expected := [][]string{
+		{"DATABASE", "STATUS", "SIZE", "DURATION", "DESTINATION"},
+		append([]string{"orders", status.ResultSuccess}, append(strings.Fields(progress.FormatBytes(3<<20)), "1m30s", "db-backup/orders/2024-01-15/orders_2024-01-15_02-00-00.sql")...),
+		{"users", status.ResultFailed, "-", "250ms", "-"},
+	}
+	for i, fields := range expected {
+		if got := strings.Fields(lines[i]); !slices.Equal(got, fields) {
+			t.Errorf("Line %d: expected %q, got %q", i+1, fields, got)
+		}
+	}
+	if lines[3] != "1 successful, 1 failed, 0 skipped in 1m35s" {
+		t.Errorf("Unexpected totals: %q", lines[3])
+	}
+
+	// Columns line up under their headers
+	header := lines[0]
+	for _, column := range []string{"STATUS", "SIZE", "DURATION", "DESTINATION"} {
+		offset := strings.Index(header, column)
+		for _, line := range lines[1:3] {
+			if len(line) <= offset || line[offset-1] != ' ' || line[offset] == ' ' {
+				t.Errorf("Expected %s to start at column %d of %q", column, offset, line)
+			}
+		}
+	}
+
+	// Tenants are named above their table
+	out.Reset()
+	status.PrintRunResult(&out, status.NewRunResult("acme", oneTimeRun()))
+	if !strings.HasPrefix(out.String(), "\nTenant acme\nDATABASE") {
+		t.Errorf("Expected the tenant to head the table, got:\n%s", out.String())
+	}
+}
+
+// TestWriteSummaryFile tests the JSON file written on exit of a one-time run
+func TestWriteSummaryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.json")
+	if err := status.WriteSummaryFile(path, status.NewRunResult("", oneTimeRun())); err != nil {
+		t.Fatalf("WriteSummaryFile failed: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result status.RunResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("Summary file is not valid JSON: %v", err)
+	}
+	if result.Run == nil || result.Run.RunID != "run-1" || result.Run.Successful != 1 || result.Run.Failed != 1 {
+		t.Errorf("Unexpected run totals: %+v", result.Run)
+	}
+	if len(result.Databases) != 2 {
+		t.Fatalf("Expected two databases, got %+v", result.Databases)
+	}
+	orders, users := result.Databases[0], result.Databases[1]
+	if orders.Database != "orders" || orders.Status != status.ResultSuccess || orders.SizeBytes != 3<<20 || orders.Location == "" || orders.Error != "" {
+		t.Errorf("Unexpected successful result: %+v", orders)
+	}
+	if users.Database != "users" || users.Status != status.ResultFailed || users.Error != "connection refused" || users.Location != "" {
+		t.Errorf("Unexpected failed result: %+v", users)
+	}
+
+	// The raw document leaves out the tenant of a single configuration
+	var raw map[string]any
+	json.Unmarshal(data, &raw)
+	if _, ok := raw["tenant"]; ok {
+		t.Errorf("Expected no tenant field, got %s", data)
+	}
+}
@@ -0,0 +1,66 @@
+package unit
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"db-backuper/internal/systemd"
+)
+
+// TestSystemdNotify tests sending state to the notification socket
+func TestSystemdNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := systemd.Notify("READY=1"); sent || err != nil {
+		t.Fatalf("Expected nothing to be sent outside systemd, got %v, %v", sent, err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socket)
+
+	if sent, err := systemd.Notify("READY=1"); !sent || err != nil {
+		t.Fatalf("Expected the state to be sent, got %v, %v", sent, err)
+	}
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed to read notification: %v", err)
+	}
+	if got := string(buf[:n]); got != "READY=1" {
+		t.Errorf("Expected READY=1, got %q", got)
+	}
+}
+
+// TestSystemdWatchdogInterval tests deriving the ping interval from the watchdog variables
+func TestSystemdWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	t.Setenv("WATCHDOG_PID", "")
+	if interval := systemd.WatchdogInterval(); interval != 0 {
+		t.Errorf("Expected no watchdog, got %s", interval)
+	}
+
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	if interval := systemd.WatchdogInterval(); interval != 15*time.Second {
+		t.Errorf("Expected pings every 15s, got %s", interval)
+	}
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if interval := systemd.WatchdogInterval(); interval != 15*time.Second {
+		t.Errorf("Expected pings every 15s for this process, got %s", interval)
+	}
+
+	// The watchdog of another process is left to it
+	t.Setenv("WATCHDOG_PID", "1")
+	if interval := systemd.WatchdogInterval(); interval != 0 {
+		t.Errorf("Expected no watchdog for another process, got %s", interval)
+	}
+}
@@ -0,0 +1,69 @@
+package unit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/config"
+)
+
+// tenantConfig returns a tenant configuration backing up database into prefix
+func tenantConfig(database, prefix string) string {
+	return fmt.Sprintf(`{
+	"databases": [
+		{"host": "localhost", "port": 5432, "username": "backup", "password": "secret", "database": %q}
+	],
+	"local": {"path": "/tmp/backups"},
+	"backup": {"retention_days": 7, "backup_prefix": %q}
+}`, database, prefix)
+}
+
+// TestLoadTenants tests loading a directory of tenant configurations, leaving
+// out broken tenants and ones sharing another tenant's storage
+func TestLoadTenants(t *testing.T) {
+	t.Setenv(config.ProfileEnvVar, "")
+	t.Setenv("DB_HOST", "overridden")
+	dir := t.TempDir()
+	files := map[string]string{
+		"acme.json":    tenantConfig("acme", "acme"),
+		"globex.json":  tenantConfig("globex", "globex"),
+		"initech.json": tenantConfig("initech", "acme"),
+		"broken.json":  `{"databases": [`,
+		"notes.txt":    "not a tenant",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	tenants, err := config.LoadTenants(dir, config.LoadOptions{})
+	if err == nil {
+		t.Fatal("Expected errors for the broken and conflicting tenants")
+	}
+	for _, expected := range []string{"tenant broken:", "tenant initech: stores backups in the same location and prefix as tenant acme"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q in error, got %v", expected, err)
+		}
+	}
+
+	if len(tenants) != 2 || tenants[0].Name != "acme" || tenants[1].Name != "globex" {
+		t.Fatalf("Expected tenants acme and globex, got %+v", tenants)
+	}
+	for _, tenant := range tenants {
+		// Database variables would change the first database of every tenant
+		if host := tenant.Config.Databases[0].Host; host != "localhost" {
+			t.Errorf("Expected tenant %s to ignore DB_HOST, got host %s", tenant.Name, host)
+		}
+		if tenant.Config.Databases[0].Database != tenant.Name {
+			t.Errorf("Expected tenant %s to back up its own database, got %s", tenant.Name, tenant.Config.Databases[0].Database)
+		}
+	}
+
+	if _, err := config.LoadTenants(t.TempDir(), config.LoadOptions{}); err == nil {
+		t.Error("Expected an empty directory to be rejected")
+	}
+}
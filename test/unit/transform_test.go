@@ -0,0 +1,63 @@
+package unit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/config"
+	"db-backuper/internal/transform"
+)
+
+// TestTransformPipeline tests rewriting and dropping the lines of a SQL script
+func TestTransformPipeline(t *testing.T) {
+	pipeline, err := transform.New([]config.TransformRule{
+		{Name: "audit data", Match: `^COPY public\.audit `, Drop: true},
+		{Name: "plpython", Match: `^(CREATE|COMMENT ON) EXTENSION .*plpython`, Drop: true},
+		{Name: "schema", Match: `\bpublic\.`, Replace: "app."},
+		{Name: "timeout", Match: `^SET transaction_timeout`, Drop: true},
+	})
+	if err != nil {
+		t.Fatalf("Failed to compile transforms: %v", err)
+	}
+
+	script := strings.Join([]string{
+		"SET transaction_timeout = 0;",
+		"CREATE EXTENSION IF NOT EXISTS plpython3u WITH SCHEMA pg_catalog;",
+		"CREATE TABLE public.orders (id integer, note text);",
+		"COPY public.orders (id, note) FROM stdin;",
+		"1\tsee public.orders",
+		`\.`,
+		"COPY public.audit (id) FROM stdin;",
+		"1",
+		`\.`,
+		"SELECT 1;",
+		"",
+	}, "\n")
+	expected := strings.Join([]string{
+		"CREATE TABLE app.orders (id integer, note text);",
+		"COPY app.orders (id, note) FROM stdin;",
+		"1\tsee public.orders",
+		`\.`,
+		"SELECT 1;",
+		"",
+	}, "\n")
+
+	var out bytes.Buffer
+	stats, err := pipeline.Copy(&out, strings.NewReader(script))
+	if err != nil {
+		t.Fatalf("Failed to transform script: %v", err)
+	}
+	if out.String() != expected {
+		t.Errorf("Transformed script = %q, expected %q", out.String(), expected)
+	}
+	for name, lines := range map[string]int{"audit data": 1, "plpython": 1, "schema": 2, "timeout": 1} {
+		if stats[name] != lines {
+			t.Errorf("Rule %s changed %d lines, expected %d", name, stats[name], lines)
+		}
+	}
+
+	if _, err := transform.New([]config.TransformRule{{Match: "("}}); err == nil {
+		t.Error("Expected an invalid expression to be rejected")
+	}
+}
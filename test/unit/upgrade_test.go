@@ -0,0 +1,66 @@
+package unit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"db-backuper/internal/config"
+)
+
+// versionedConfig is a valid local storage configuration declaring a schema version
+const versionedConfig = `{
+  "config_version": %d,
+  "databases": [
+    {"host": "localhost", "port": 5432, "username": "user", "password": "pass", "database": "testdb"}
+  ],
+  "local": {"path": "/tmp/backups"},
+  "backup": {"retention_days": 7, "schedule": "0 2 * * *", "backup_prefix": "test-backup"}
+}
+`
+
+// TestConfigVersion tests that files of the current version load, strictly
+// as well, and files written for a newer release are refused
+func TestConfigVersion(t *testing.T) {
+	t.Setenv(config.ProfileEnvVar, "")
+	path := filepath.Join(t.TempDir(), "appsettings.json")
+
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(versionedConfig, config.CurrentVersion)), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if _, err := config.LoadConfig(path, config.LoadOptions{Strict: true}); err != nil {
+		t.Errorf("Expected the current version to load, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(versionedConfig, config.CurrentVersion+1)), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	_, err := config.LoadConfig(path, config.LoadOptions{})
+	if err == nil || !strings.Contains(err.Error(), "upgrade db-backuper") {
+		t.Errorf("Expected a newer version to be refused, got %v", err)
+	}
+}
+
+// TestConfigUpgrade tests that current files are left untouched and newer ones refused
+func TestConfigUpgrade(t *testing.T) {
+	for _, data := range []string{
+		fmt.Sprintf(versionedConfig, config.CurrentVersion),
+		`{"databases": [], "local": {"path": "/tmp/backups"}}`,
+	} {
+		upgraded, changes, err := config.Upgrade([]byte(data))
+		if err != nil {
+			t.Errorf("Failed to upgrade: %v", err)
+		} else if string(upgraded) != data || len(changes) != 0 {
+			t.Errorf("Expected %s to be current, got changes %v", data, changes)
+		}
+	}
+
+	if _, _, err := config.Upgrade([]byte(fmt.Sprintf(versionedConfig, config.CurrentVersion+1))); err == nil {
+		t.Error("Expected a newer version to be refused")
+	}
+	if _, _, err := config.Upgrade([]byte(`{"config_version": "1"}`)); err == nil {
+		t.Error("Expected a non-numeric version to be refused")
+	}
+}
@@ -0,0 +1,149 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"db-backuper/internal/retention"
+	"db-backuper/internal/storage"
+	"db-backuper/internal/usage"
+	"db-backuper/internal/web"
+
+	"github.com/sirupsen/logrus"
+)
+
+// usageBackups returns 120 daily backups of 2 GiB in S3 Standard ending on
+// 2024-06-30 and one local backup without a storage class
+func usageBackups() []retention.Backup {
+	backups := dailyBackups("orders", time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC), time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC))
+	for i := range backups {
+		backups[i].Storage = "s3://backups"
+		backups[i].Size = 2 << 30
+		backups[i].StorageClass = usage.ClassStandard
+	}
+	return append(backups, retention.Backup{
+		Storage: "local:/var/backups",
+		Entry: storage.Entry{
+			Key:      "postgres-backup/users/2024-06-30/users_2024-06-30_02-00-00.sql",
+			Database: "users",
+			Date:     time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC),
+			Size:     1 << 30,
+		},
+	})
+}
+
+// TestUsageAnalyze tests the usage, cost and suggestions of a report
+func TestUsageAnalyze(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	report := usage.Analyze(usageBackups(), 120, nil, now)
+
+	if report.Backups != 121 || report.MonthlyCost != 5.52 {
+		t.Errorf("Expected 121 backups costing $5.52/mo, got %d costing $%.2f", report.Backups, report.MonthlyCost)
+	}
+	if len(report.Databases) != 2 {
+		t.Fatalf("Expected usage of 2 databases, got %+v", report.Databases)
+	}
+	orders, users := report.Databases[1], report.Databases[0]
+	if orders.Database != "orders" || orders.Oldest != "2024-03-03" || orders.Newest != "2024-06-30" || orders.BytesByClass[usage.ClassStandard] != 240<<30 {
+		t.Errorf("Unexpected usage of orders: %+v", orders)
+	}
+	if users.Database != "users" || users.MonthlyCost != 0 || users.BytesByClass != nil {
+		t.Errorf("Expected local backups to be unpriced, got %+v", users)
+	}
+
+	// Largest savings first
+	expected := []struct {
+		kind    string
+		savings float64
+	}{
+		{usage.SuggestionRetention, 4.92},
+		{usage.SuggestionStorageClass, 3.42},
+		{usage.SuggestionStorageClass, 1.89},
+		{usage.SuggestionRetention, 1.38},
+	}
+	if len(report.Suggestions) != len(expected) {
+		t.Fatalf("Expected %d suggestions, got %+v", len(expected), report.Suggestions)
+	}
+	for i, suggestion := range report.Suggestions {
+		if suggestion.Kind != expected[i].kind || suggestion.MonthlySavings != expected[i].savings {
+			t.Errorf("Expected suggestion %d to be a %s saving $%.2f, got %+v", i, expected[i].kind, expected[i].savings, suggestion)
+		}
+	}
+	glacier := report.Suggestions[1]
+	if glacier.Class != usage.ClassGlacierIR || glacier.AfterDays != 30 ||
+		glacier.Description != "Moving backups older than 30 days to GLACIER_IR would save ~$3.42/mo" {
+		t.Errorf("Unexpected Glacier Instant Retrieval suggestion: %+v", glacier)
+	}
+	gfs := report.Suggestions[0]
+	if gfs.Policy == nil || gfs.Policy.KeepMonthly != 12 ||
+		gfs.Description != "Keeping 7 days of backups plus 4 weekly and 12 monthly ones instead of 120 days would delete 107 backups and save ~$4.92/mo" {
+		t.Errorf("Unexpected GFS suggestion: %+v", gfs)
+	}
+	if shorter := report.Suggestions[3]; shorter.Policy == nil || shorter.Policy.Days != 90 {
+		t.Errorf("Expected the next shorter retention to be 90 days, got %+v", shorter)
+	}
+}
+
+// TestUsageMinimumDuration tests that transitions are not suggested when
+// retention deletes backups before the minimum duration of the class
+func TestUsageMinimumDuration(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	report := usage.Analyze(usageBackups(), 60, nil, now)
+	for _, suggestion := range report.Suggestions {
+		if suggestion.Class == usage.ClassGlacierIR {
+			t.Errorf("Expected no Glacier Instant Retrieval suggestion with 60 days of retention, got %+v", suggestion)
+		}
+	}
+	found := false
+	for _, suggestion := range report.Suggestions {
+		found = found || (suggestion.Class == usage.ClassStandardIA && suggestion.AfterDays == 30)
+	}
+	if !found {
+		t.Errorf("Expected a Standard-IA suggestion after 30 days, got %+v", report.Suggestions)
+	}
+}
+
+// TestUsagePrices tests that configured prices override the defaults
+func TestUsagePrices(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	report := usage.Analyze(usageBackups(), 7, map[string]float64{usage.ClassStandard: 0.025}, now)
+	if report.MonthlyCost != 6 {
+		t.Errorf("Expected $6.00/mo at $0.025 per GB-month, got $%.2f", report.MonthlyCost)
+	}
+	for _, suggestion := range report.Suggestions {
+		if suggestion.Kind == usage.SuggestionStorageClass {
+			t.Errorf("Expected no transitions with 7 days of retention, got %+v", suggestion)
+		}
+	}
+}
+
+// TestUsageEndpoint tests that /usage serves a report and reuses it while fresh
+func TestUsageEndpoint(t *testing.T) {
+	server := web.NewServer(":0", nil, logrus.New())
+	computed := 0
+	server.SetUsage(func() (*usage.Report, error) {
+		computed++
+		return usage.Analyze(usageBackups(), 120, nil, time.Now()), nil
+	})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	for range 2 {
+		resp, err := http.Get(ts.URL + "/usage")
+		if err != nil {
+			t.Fatalf("GET /usage failed: %v", err)
+		}
+		var report usage.Report
+		err = json.NewDecoder(resp.Body).Decode(&report)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK || report.Backups != 121 {
+			t.Fatalf("Expected a report of 121 backups, got %d %+v (%v)", resp.StatusCode, report, err)
+		}
+	}
+	if computed != 1 {
+		t.Errorf("Expected the report to be computed once, got %d", computed)
+	}
+}
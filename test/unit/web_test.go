@@ -0,0 +1,160 @@
+package unit
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"db-backuper/internal/joblog"
+	"db-backuper/internal/redact"
+	"db-backuper/internal/status"
+	"db-backuper/internal/web"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestStatusBadge tests the badges served for successful, failed and unknown databases
+func TestStatusBadge(t *testing.T) {
+	server := web.NewServer(":0", nil, logrus.New())
+	summary := &status.RunSummary{RunID: "run-1", FinishedAt: time.Now()}
+	summary.Add(status.DatabaseResult{Database: "orders", Status: status.ResultSuccess, FinishedAt: time.Now().Add(-3 * time.Hour)})
+	summary.Add(status.DatabaseResult{Database: "users", Status: status.ResultFailed})
+	server.Update(summary)
+	server.Update(&status.RunSummary{RunID: "run-2", FinishedAt: time.Now(), Disabled: []string{"legacy"}})
+
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	tests := []struct {
+		path     string
+		code     int
+		contains []string
+	}{
+		{"/badge/orders.svg", http.StatusOK, []string{"backup: 3h ago", "#4c1"}},
+		{"/badge/users.svg", http.StatusOK, []string{"backup: failed", "#e05d44"}},
+		{"/badge/legacy.svg", http.StatusOK, []string{"backup: disabled", "#9f9f9f"}},
+		{"/badge/unknown.svg", http.StatusNotFound, []string{"backup: unknown"}},
+		{"/badge/orders", http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(ts.URL + tt.path)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", tt.path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.code {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.code, resp.StatusCode)
+		}
+		if tt.contains != nil && resp.Header.Get("Content-Type") != "image/svg+xml" {
+			t.Errorf("%s: unexpected content type %q", tt.path, resp.Header.Get("Content-Type"))
+		}
+		for _, want := range tt.contains {
+			if !strings.Contains(string(body), want) {
+				t.Errorf("%s: expected %q in %s", tt.path, want, body)
+			}
+		}
+	}
+}
+
+// TestJobLogStream tests streaming the redacted log lines of a run as server-sent events
+func TestJobLogStream(t *testing.T) {
+	redactor := redact.New()
+	redactor.AddSecrets("hunter2")
+	hub := joblog.NewHub(redactor)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.AddHook(hub)
+
+	server := web.NewServer(":0", nil, logger)
+	server.SetJobLogs(hub)
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	runLogger := logger.WithField("run_id", "run-1")
+	runLogger.Info("Starting backup operation for 1 databases")
+	runLogger.WithField("database", "orders").Info("Connecting with password hunter2")
+	logger.Info("Not part of any run")
+
+	resp, err := http.Get(ts.URL + "/jobs")
+	if err != nil {
+		t.Fatalf("Failed to list jobs: %v", err)
+	}
+	var jobs []joblog.Job
+	json.NewDecoder(resp.Body).Decode(&jobs)
+	resp.Body.Close()
+	if len(jobs) != 1 || jobs[0].ID != "run-1" || jobs[0].Lines != 2 || jobs[0].FinishedAt != nil {
+		t.Fatalf("Expected one running job with 2 lines, got %+v", jobs)
+	}
+
+	// Follow the run from its first line, then let it finish
+	resp, err = http.Get(ts.URL + "/jobs/run-1/logs")
+	if err != nil {
+		t.Fatalf("Failed to stream logs: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+	runLogger.Info("Backup operation completed")
+	hub.Finish("run-1", status.ResultSuccess)
+	runLogger.Info("Logged after the run finished")
+
+	done := make(chan []byte)
+	go func() {
+		body, _ := io.ReadAll(resp.Body)
+		done <- body
+	}()
+	var body string
+	select {
+	case data := <-done:
+		body = string(data)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the stream to end with the run")
+	}
+
+	for _, want := range []string{
+		"id: 1\nevent: log\ndata: {",
+		`"msg":"Connecting with password ***"`,
+		`"database":"orders"`,
+		"id: 3\nevent: log\n",
+		"event: end\ndata: {\"id\":\"run-1\"",
+		`"result":"success"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in stream:\n%s", want, body)
+		}
+	}
+	for _, unwanted := range []string{"hunter2", "Not part of any run", "Logged after the run finished"} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("Expected no %q in stream:\n%s", unwanted, body)
+		}
+	}
+
+	// A reconnecting client only receives the lines after its last event
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/jobs/run-1/logs", nil)
+	req.Header.Set("Last-Event-ID", "2")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to resume stream: %v", err)
+	}
+	resumed, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.Contains(string(resumed), "id: 2\n") || !strings.Contains(string(resumed), "id: 3\n") || !strings.Contains(string(resumed), "event: end") {
+		t.Errorf("Expected only line 3 and the end of the run, got:\n%s", resumed)
+	}
+
+	resp, err = http.Get(ts.URL + "/jobs/run-2/logs")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown job, got %d", resp.StatusCode)
+	}
+}